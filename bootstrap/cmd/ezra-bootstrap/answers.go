@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// answers are the answers to the questions a run would otherwise ask the
+// operator, read from the file given with -answers, so that imaging
+// pipelines can run the bootstrap without a terminal
+type answers struct {
+	// Companion picks the companion -discover installs with when several
+	// are found, by URL or name
+	Companion string `json:"companion"`
+	// Media picks the offline media to install from when several are
+	// found, by path
+	Media string `json:"media"`
+	// OnConflict answers whether to replace files changed outside Ezra
+	// under the prompt policy: overwrite or keep
+	OnConflict string `json:"on_conflict"`
+	// TrustKey is the fingerprint of the companion signing key to trust
+	// on first use
+	TrustKey string `json:"trust_key"`
+	// Components are the components to install when -components is not
+	// given
+	Components []string `json:"components"`
+	// EraseDevice confirms erasing the device create-media -format writes
+	EraseDevice bool `json:"erase_device"`
+
+	// nonInteractive makes the questions without an answer fail instead
+	// of prompting
+	nonInteractive bool
+}
+
+// loadAnswers reads the answers file of a run, if one was given, exiting
+// if it is invalid. It is read once per run.
+func loadAnswers(log *logger.Logger, opts *commonOptions) *answers {
+	if opts.answers != nil {
+		return opts.answers
+	}
+	a := &answers{nonInteractive: *opts.nonInteractive}
+	if *opts.answersFile != "" {
+		data, err := os.ReadFile(*opts.answersFile)
+		if err != nil {
+			log.Exitf(failure.ExitUsage, "Failed to read answers file: %v", err)
+		}
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(a); err != nil {
+			log.Exitf(failure.ExitUsage, "Failed to parse answers file %s: %v", *opts.answersFile, err)
+		}
+		switch a.OnConflict {
+		case "", "overwrite", "keep":
+		default:
+			log.Exitf(failure.ExitUsage, "Invalid on_conflict answer %q: use overwrite or keep", a.OnConflict)
+		}
+	}
+	opts.answers = a
+	return a
+}
+
+// unanswered is the error of a question a non-interactive run cannot ask
+func unanswered(question, answer string) error {
+	return fmt.Errorf("%s, and -non-interactive does not ask: answer it with %q in the answers file", question, answer)
+}
+
+// confirmOverwrite answers whether to replace a file that was changed
+// outside Ezra, asking the operator if the answers file does not. A
+// non-interactive run cannot get here, see checkConflictPolicy.
+func (a *answers) confirmOverwrite(path string) bool {
+	if a.OnConflict != "" {
+		return a.OnConflict == "overwrite"
+	}
+	return confirmOverwrite(path)
+}
+
+// checkConflictPolicy fails a non-interactive run whose conflicting
+// files would be left to the operator
+func (a *answers) checkConflictPolicy(log *logger.Logger, policy string) {
+	if a.nonInteractive && policy == "prompt" && a.OnConflict == "" {
+		log.Exitf(failure.ExitUsage, "%v, or use -on-conflict", unanswered("on_conflict is prompt", "on_conflict"))
+	}
+}
+
+// chooseMedia picks the offline media the answers file names, or asks
+// the operator
+func (a *answers) chooseMedia(candidates []string) (string, error) {
+	if a.Media != "" {
+		for _, path := range candidates {
+			if filepath.Clean(path) == filepath.Clean(a.Media) {
+				return path, nil
+			}
+		}
+		return "", fmt.Errorf("answered media %s is not one of the media found: %s", a.Media, strings.Join(candidates, ", "))
+	}
+	if a.nonInteractive {
+		return "", unanswered("several offline media were found ("+strings.Join(candidates, ", ")+")", "media")
+	}
+	return chooseMedia(candidates)
+}
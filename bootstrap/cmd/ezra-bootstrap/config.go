@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+var configCommand = &command{
+	name:    "config",
+	usage:   "config init|get|set|show|edit|validate [OPTIONS]",
+	summary: "Write, change and check the configuration, or show the settings in effect",
+}
+
+// The actions of the config subcommand, for their flags and help
+var (
+	configInitCommand = &command{
+		name:    "config init",
+		usage:   "config init [-config <file>] [-force]",
+		summary: "Write a commented configuration file to start from",
+	}
+	configGetCommand = &command{
+		name:    "config get",
+		usage:   "config get [OPTIONS] <setting>",
+		summary: "Print a setting in effect, e.g. log_level or auto_update.interval_minutes",
+	}
+	configSetCommand = &command{
+		name:    "config set",
+		usage:   "config set [-config <file>] <setting> <value>",
+		summary: "Change a setting in a configuration file, checking the value against its type",
+	}
+	configEditCommand = &command{
+		name:    "config edit",
+		usage:   "config edit [-config <file>]",
+		summary: "Edit a configuration file in $EDITOR, validating it before it is saved",
+	}
+	configValidateCommand = &command{
+		name:    "config validate",
+		usage:   "config validate [OPTIONS]",
+		summary: "Check a configuration before installing with it",
+	}
+	configShowCommand = &command{
+		name:    "config show",
+		usage:   "config show [-origin] [OPTIONS]",
+		summary: "Show the settings in effect, after the configuration files, the environment and the flags",
+	}
+)
+
+// configActions are the actions of the config subcommand, in the order
+// of its help
+var configActions = []*command{
+	configInitCommand, configGetCommand, configSetCommand,
+	configShowCommand, configEditCommand, configValidateCommand,
+}
+
+func init() {
+	configCommand.run = runConfig
+}
+
+// validationReport is what config validate reports
+type validationReport struct {
+	Valid      bool               `json:"valid"`
+	Violations []config.Violation `json:"violations,omitempty"`
+}
+
+// runConfig handles the config subcommand
+func runConfig(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "init":
+			runConfigInit(args[1:])
+			return
+		case "get":
+			runConfigGet(args[1:])
+			return
+		case "set":
+			runConfigSet(args[1:])
+			return
+		case "edit":
+			runConfigEdit(args[1:])
+			return
+		case "validate":
+			runConfigValidate(args[1:])
+			return
+		case "show":
+			runConfigShow(args[1:])
+			return
+		case "-help", "--help", "-h":
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown config command: %s\n\n", args[0])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s\n\nUSAGE:\n", configCommand.summary)
+	for _, action := range configActions {
+		fmt.Fprintf(os.Stderr, "    ezra-bootstrap %s\n", action.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun ezra-bootstrap config <action> -help for the options of each.")
+	os.Exit(2)
+}
+
+// runConfigValidate validates the configuration files and the EZRA_
+// environment variables, the way an install would load them, and lists
+// every problem. It exits with the configuration exit code if there are
+// any.
+func runConfigValidate(args []string) {
+	fs := newFlagSet(configValidateCommand)
+	var (
+		configFile = fs.String("config", os.Getenv(configEnv), "Configuration file path (default $EZRA_CONFIG)")
+		output     = fs.String("output", "text", "Output format: text or json")
+		verbose    = fs.Bool("verbose", false, "Enable verbose logging")
+		logFormat  = fs.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*verbose, *logFormat)
+	if *output == "json" {
+		log.SetOutput(os.Stderr)
+	} else if *output != "text" {
+		log.Exitf(failure.ExitUsage, "Unknown output format %q: use text or json", *output)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to load configuration: %v", err)
+	}
+
+	report := &validationReport{Valid: true}
+	var invalid *config.ValidationError
+	if err := cfg.Validate(); errors.As(err, &invalid) {
+		report.Valid = false
+		report.Violations = invalid.Violations
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+	} else if report.Valid {
+		fmt.Println("Configuration is valid")
+	} else {
+		fmt.Printf("Configuration has %d problems:\n", len(report.Violations))
+		for _, violation := range report.Violations {
+			fmt.Printf("    %s\n", violation)
+		}
+	}
+
+	if !report.Valid {
+		logger.Exit(failure.ExitConfig)
+	}
+}
+
+// runConfigShow prints the settings in effect, with secrets redacted. With
+// -origin each is listed with where it came from: the defaults, a
+// configuration file, the companion, an EZRA_ variable or a flag.
+func runConfigShow(args []string) {
+	fs := newFlagSet(configShowCommand)
+	opts := addCommonFlags(fs)
+	var (
+		origin = fs.Bool("origin", false, "List each setting with where it came from")
+		output = fs.String("output", "text", "Output format: text or json")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	if *output == "json" {
+		log.SetOutput(os.Stderr)
+	} else if *output != "text" {
+		log.Exitf(failure.ExitUsage, "Unknown output format %q: use text or json", *output)
+	}
+
+	cfg := loadConfig(log, opts)
+	pullConfig(log, cfg, opts)
+
+	if !*origin {
+		data, err := cfg.Redacted()
+		if err != nil {
+			log.Fatalf("Failed to write configuration: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	settings, err := cfg.Settings()
+	if err != nil {
+		log.Fatalf("Failed to list settings: %v", err)
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(settings); err != nil {
+			log.Fatalf("Failed to write settings: %v", err)
+		}
+		return
+	}
+	width := 0
+	for _, setting := range settings {
+		if len(setting.Origin) > width {
+			width = len(setting.Origin)
+		}
+	}
+	for _, setting := range settings {
+		fmt.Printf("%-*s  %s = %s\n", width, setting.Origin, setting.Name, setting.Value)
+	}
+}
+
+// configFileFlag adds the -config flag of the actions that write a
+// configuration file. They write the system configuration file unless
+// told otherwise.
+func configFileFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv(configEnv), "Configuration file to write (default $EZRA_CONFIG, or "+config.SystemConfigFile()+")")
+}
+
+// targetConfigFile returns the configuration file an action writes
+func targetConfigFile(configFile string) string {
+	if configFile != "" {
+		return configFile
+	}
+	return config.SystemConfigFile()
+}
+
+// runConfigInit writes the starter configuration file, which lists the
+// common settings at their defaults with comments explaining them
+func runConfigInit(args []string) {
+	fs := newFlagSet(configInitCommand)
+	var (
+		configFile = configFileFlag(fs)
+		force      = fs.Bool("force", false, "Replace an existing configuration file")
+	)
+	fs.Parse(args)
+
+	log := newLogger(false, "text")
+	path := targetConfigFile(*configFile)
+	if _, err := os.Stat(path); err == nil && !*force {
+		log.Exitf(failure.ExitUsage, "%s already exists; use -force to replace it", path)
+	}
+
+	data, err := config.StarterFile()
+	if err != nil {
+		log.Fatalf("Failed to write configuration: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+	log.Infof("Configuration written to %s", path)
+}
+
+// runConfigGet prints a setting in effect, with secrets redacted.
+// Strings are printed as they are, other values as JSON.
+func runConfigGet(args []string) {
+	fs := newFlagSet(configGetCommand)
+	opts := addCommonFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.SetOutput(os.Stderr)
+	cfg := loadConfig(log, opts)
+	pullConfig(log, cfg, opts)
+
+	value, err := cfg.Get(fs.Arg(0))
+	if err != nil {
+		log.Exitf(failure.ExitUsage, "%v", err)
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		fmt.Println(text)
+		return
+	}
+	fmt.Println(string(value))
+}
+
+// runConfigSet changes a setting in a configuration file. The value is
+// written as in an EZRA_ variable and must suit the setting.
+func runConfigSet(args []string) {
+	fs := newFlagSet(configSetCommand)
+	configFile := configFileFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log := newLogger(false, "text")
+	path := targetConfigFile(*configFile)
+	setting := fs.Arg(0)
+	if err := config.SetInFile(path, setting, fs.Arg(1)); err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to set %s: %v", setting, err)
+	}
+	log.Infof("%s set in %s", setting, path)
+}
+
+// runConfigEdit opens a copy of a configuration file in the operator's
+// editor and replaces the file only once the copy loads and validates,
+// so that a mistake never reaches the next install. A file that does not
+// exist yet starts from the starter file.
+func runConfigEdit(args []string) {
+	fs := newFlagSet(configEditCommand)
+	configFile := configFileFlag(fs)
+	fs.Parse(args)
+
+	log := newLogger(false, "text")
+	path := targetConfigFile(*configFile)
+
+	mode := os.FileMode(0644)
+	original, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if original, err = config.StarterFile(); err != nil {
+			log.Fatalf("Failed to write configuration: %v", err)
+		}
+	default:
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	tmp, err := os.CreateTemp("", "ezra-config-*.conf")
+	if err != nil {
+		log.Fatalf("Failed to create a temporary file: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := os.WriteFile(tmp.Name(), original, 0600); err != nil {
+		log.Fatalf("Failed to write %s: %v", tmp.Name(), err)
+	}
+
+	for {
+		if err := runEditor(tmp.Name()); err != nil {
+			log.Fatalf("Failed to run the editor: %v", err)
+		}
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", tmp.Name(), err)
+		}
+		if _, statErr := os.Stat(path); statErr == nil && string(edited) == string(original) {
+			log.Info("No changes made")
+			return
+		}
+
+		cfg, err := config.LoadUnresolved(tmp.Name())
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err == nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				log.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+			}
+			if err := os.WriteFile(path, edited, mode); err != nil {
+				log.Fatalf("Failed to write %s: %v", path, err)
+			}
+			log.Infof("Configuration saved to %s", path)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "The configuration is not valid: %v\n", err)
+		if !confirmEditAgain() {
+			fatal(log, failure.Wrap(failure.Config, err), "Changes to %s discarded", path)
+		}
+	}
+}
+
+// confirmEditAgain asks the operator whether to fix an invalid
+// configuration or to discard the changes
+func confirmEditAgain() bool {
+	fmt.Print("Edit it again? Otherwise the changes are discarded. [Y/n] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// runEditor opens a file in $VISUAL or $EDITOR, which may carry
+// arguments like "code -w", or else in vi, or Notepad on Windows
+func runEditor(path string) error {
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
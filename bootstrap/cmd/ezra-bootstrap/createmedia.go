@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+var createMediaCommand = &command{
+	name:    "create-media",
+	usage:   "create-media [OPTIONS]",
+	summary: "Create signed offline installation media for one or more platforms",
+}
+
+func init() {
+	createMediaCommand.run = runCreateMedia
+}
+
+// runCreateMedia handles the create-media subcommand
+func runCreateMedia(args []string) {
+	fs := newFlagSet(createMediaCommand)
+	opts := addCommonFlags(fs)
+	var (
+		output     = fs.String("output", "", "Directory to write the media to, e.g. where a USB stick is mounted")
+		platforms  = fs.String("platforms", "", "Comma-separated os/arch platforms, e.g. linux/aarch64,linux/armv6,windows/x86_64 (default: this device)")
+		signingKey = fs.String("signing-key", "", "File holding the base64 Ed25519 private key to sign the media manifest with")
+		selected   = fs.String("components", "", "Comma-separated components to put on the media: companion, agent, executor (default from config, or all)")
+		device     = fs.String("format", "", "Erase and format this device before writing, e.g. /dev/sdb, /dev/disk4 or E:")
+		label      = fs.String("label", "", "Volume label of a formatted device (default EZRA)")
+		yes        = fs.Bool("yes", false, "Format the device without asking")
+		tofu       = fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+	)
+	fs.Parse(args)
+
+	if (*output == "") == (*device == "") {
+		fmt.Fprintln(os.Stderr, "Give either -output or -format")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Media Builder starting...")
+
+	media := installer.MediaOptions{
+		Path:       *output,
+		Platforms:  parsePlatforms(log, *platforms),
+		SigningKey: *signingKey,
+		Device:     *device,
+		Label:      *label,
+	}
+
+	inst, cfg := newInstaller(log, opts)
+	ans := loadAnswers(log, opts)
+	if *selected != "" {
+		names := strings.Split(*selected, ",")
+		for n := range names {
+			names[n] = strings.TrimSpace(names[n])
+		}
+		if err := inst.SetComponents(names); err != nil {
+			log.Exitf(failure.ExitUsage, "Invalid -components: %v", err)
+		}
+	} else if len(ans.Components) > 0 {
+		if err := inst.SetComponents(ans.Components); err != nil {
+			log.Exitf(failure.ExitUsage, "Invalid components answer: %v", err)
+		}
+	}
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, ans))
+	pinned := cfg.PublicKeyPinned
+
+	if *device != "" && !*yes && !ans.EraseDevice {
+		if ans.nonInteractive {
+			log.Exitf(failure.ExitUsage, "%v, or use -yes", unanswered("Formatting "+*device+" erases it", "erase_device"))
+		}
+		if !confirmFormat(*device) {
+			log.Fatal("Aborted")
+		}
+	}
+
+	err := inst.CreateMedia(media)
+
+	// A key pinned on first use is kept even if the media was not written
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+	if err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Media creation interrupted")
+		}
+		fatal(log, err, "Creating media failed: %v", err)
+	}
+
+	log.Info("Offline media created successfully!")
+}
+
+// parsePlatforms parses the comma-separated os/arch list of -platforms,
+// exiting if it is invalid
+func parsePlatforms(log *logger.Logger, list string) []downloader.Target {
+	if list == "" {
+		return nil
+	}
+	var targets []downloader.Target
+	for _, platform := range strings.Split(list, ",") {
+		target, err := installer.ParsePlatform(platform)
+		if err != nil {
+			log.Exitf(failure.ExitUsage, "Invalid -platforms: %v", err)
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// confirmFormat asks the operator whether to erase a device
+func confirmFormat(device string) bool {
+	fmt.Printf("All data on %s will be erased. Continue? [y/N] ", device)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
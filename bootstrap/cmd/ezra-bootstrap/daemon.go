@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+var daemonCommand = &command{
+	name:    "daemon",
+	usage:   "daemon [OPTIONS]",
+	summary: "Keep the installation up to date, applying updates in the maintenance window",
+}
+
+func init() {
+	daemonCommand.run = runDaemon
+}
+
+// runDaemon handles the daemon subcommand
+func runDaemon(args []string) {
+	fs := newFlagSet(daemonCommand)
+	opts := addCommonFlags(fs)
+	var (
+		once     = fs.Bool("once", false, "Check for updates once instead of staying resident")
+		schedule = fs.Bool("schedule", false, "Register a systemd timer or scheduled task that checks for updates, instead of staying resident")
+		interval = fs.Int("interval", 0, "Minutes between update checks (default from config, 360 if unset)")
+		window   = fs.String("window", "", "Daily maintenance window in local time that updates are applied in, e.g. 02:00-04:00 (default from config)")
+		latest   = fs.Bool("force-latest", false, "Take the newest releases even if their staged rollouts have not reached this device")
+		self     = fs.Bool("self-update", false, "Update the bootstrap from the release before the components (default from config)")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Update Daemon starting...")
+
+	inst, cfg := newInstaller(log, opts)
+	if *interval > 0 {
+		cfg.AutoUpdate.IntervalMinutes = *interval
+	}
+	if *window != "" {
+		cfg.AutoUpdate.MaintenanceWindow = *window
+	}
+	if *self {
+		cfg.AutoUpdate.SelfUpdate = true
+	}
+	// A bootstrap that still reports the version it was updated from is
+	// not the released one, and would update itself again
+	if updatedFrom := os.Getenv(selfUpdatedEnv); updatedFrom != "" && updatedFrom == buildInfo().Version {
+		log.Errorf("The updated bootstrap still reports version %s, not updating it again", updatedFrom)
+		cfg.AutoUpdate.SelfUpdate = false
+	}
+	inst.SetForceLatest(*latest)
+	relaunchElevated(log, inst, opts)
+
+	if *schedule {
+		defer lockInstall(log, inst, true)()
+		if err := inst.ScheduleUpdates(scheduledDaemonArgs(opts, *interval, *window, *latest, *self)); err != nil {
+			fatal(log, err, "Failed to schedule updates: %v", err)
+		}
+		log.Info("Update checks scheduled successfully!")
+		return
+	}
+
+	// Located before a self-update, which on Windows moves the running
+	// bootstrap aside
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to locate the bootstrap: %v", err)
+	}
+
+	// The install lock is only held while an update is checked for and
+	// applied
+	if err := inst.RunUpdates(*once); err != nil {
+		if errors.Is(err, installer.ErrSelfUpdated) {
+			restartUpdated(log, exe, args)
+		}
+		if interrupted(err) {
+			log.Info("Update daemon stopped")
+			return
+		}
+		fatal(log, err, "Update failed: %v", err)
+	}
+}
+
+// selfUpdatedEnv carries the version a bootstrap was updated from to
+// the updated one it starts
+const selfUpdatedEnv = "EZRA_SELF_UPDATED_FROM"
+
+// restartUpdated runs the updated bootstrap at exe with the same
+// arguments in place of this one, and exits with its status
+func restartUpdated(log *logger.Logger, exe string, args []string) {
+	log.Info("Starting the updated bootstrap...")
+
+	cmd := exec.Command(exe, append([]string{daemonCommand.name}, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), selfUpdatedEnv+"="+buildInfo().Version)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		logger.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		log.Fatalf("Failed to start the updated bootstrap: %v", err)
+	}
+	logger.Exit(0)
+}
+
+// scheduledDaemonArgs returns the arguments the scheduled update check
+// runs the bootstrap with, carrying over the options of this run
+func scheduledDaemonArgs(opts *commonOptions, interval int, window string, forceLatest, selfUpdate bool) []string {
+	args := []string{"daemon", "-once"}
+	if *opts.configFile != "" {
+		path, err := filepath.Abs(*opts.configFile)
+		if err != nil {
+			path = *opts.configFile
+		}
+		args = append(args, "-config", path)
+	}
+	if *opts.companionURL != "" {
+		args = append(args, "-companion-url", *opts.companionURL)
+	}
+	if *opts.user {
+		args = append(args, "-user")
+	}
+	if *opts.remoteConfig {
+		args = append(args, "-remote-config")
+	}
+	if *opts.noCache {
+		args = append(args, "-no-cache")
+	}
+	if *opts.logFormat != "" && *opts.logFormat != logger.FormatText {
+		args = append(args, "-log-format", *opts.logFormat)
+	}
+	if interval > 0 {
+		args = append(args, "-interval", strconv.Itoa(interval))
+	}
+	if window != "" {
+		args = append(args, "-window", window)
+	}
+	if forceLatest {
+		args = append(args, "-force-latest")
+	}
+	if selfUpdate {
+		args = append(args, "-self-update")
+	}
+	return args
+}
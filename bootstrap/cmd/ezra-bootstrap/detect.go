@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+var detectCommand = &command{
+	name:    "detect",
+	usage:   "detect [OPTIONS]",
+	summary: "Print detected system information for support and fleet tools",
+}
+
+func init() {
+	detectCommand.run = runDetect
+}
+
+// detectReport is what detect prints: the detected system, the existing
+// installation and, on request, the network check
+type detectReport struct {
+	*detector.SystemInfo
+	Installation *installer.Status         `json:"installation"`
+	Network      *downloader.NetworkReport `json:"network,omitempty"`
+}
+
+// runDetect handles the detect subcommand
+func runDetect(args []string) {
+	fs := newFlagSet(detectCommand)
+	var (
+		configFile = fs.String("config", os.Getenv(configEnv), "Configuration file path (default $EZRA_CONFIG)")
+		output     = fs.String("output", "text", "Output format: text, json or yaml")
+		network    = fs.Bool("network", false, "Also check that the companion can be reached")
+		verbose    = fs.Bool("verbose", false, "Enable verbose logging")
+		logFormat  = fs.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*verbose, *logFormat)
+	switch *output {
+	case "text":
+	case "json", "yaml":
+		// Keep stdout for the document
+		log.SetOutput(os.Stderr)
+	default:
+		log.Exitf(failure.ExitUsage, "Unknown output format %q: use text, json or yaml", *output)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to load configuration: %v", err)
+	}
+
+	d := detector.New()
+	d.SetPaths([]string{cfg.InstallPath, cfg.DataPath, cfg.CachePath})
+	info, err := d.Detect()
+	if err != nil {
+		log.Fatalf("Failed to detect system: %v", err)
+	}
+
+	inst, err := installer.New(cfg, info, log)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to create installer: %v", err)
+	}
+	report := &detectReport{SystemInfo: info, Installation: inst.Status()}
+	if *network {
+		report.Network = inst.CheckNetwork()
+	}
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	case "yaml":
+		err = writeYAML(os.Stdout, report)
+	default:
+		printDetectReport(log, report)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// printDetectReport prints the report for people
+func printDetectReport(log *logger.Logger, report *detectReport) {
+	info := report.SystemInfo
+	fmt.Printf("OS:           %s\n", info.OS)
+	fmt.Printf("Version:      %s\n", info.Version)
+	fmt.Printf("Architecture: %s\n", info.Architecture)
+	fmt.Printf("Platform:     %s\n", info.Platform)
+	fmt.Printf("Capabilities: %s\n", strings.Join(info.Capabilities, ", "))
+	if info.Board.Model != "" {
+		fmt.Printf("Board:        %s\n", boardDetails(info.Board))
+	}
+	fmt.Printf("Environment:  %s\n", environmentDetails(info.Environment))
+	fmt.Printf("Init system:  %s\n", valueOrUnknown(info.InitSystem))
+	fmt.Printf("Root fs:      %s\n", filesystemDetails(info.Filesystem))
+	fmt.Printf("CPU:          %s (%d cores, %s)\n", valueOrUnknown(info.CPU.Model), info.CPU.Cores, info.CPU.Arch)
+	if len(info.CPU.Features) > 0 {
+		fmt.Printf("CPU features: %s\n", strings.Join(info.CPU.Features, ", "))
+	}
+	if info.Libc != "" {
+		fmt.Printf("C library:    %s\n", info.Libc)
+	}
+	fmt.Printf("Memory:       %s\n", gib(info.MemoryBytes))
+	for _, disk := range info.Disks {
+		fmt.Printf("Disk:         %s free of %s on %s (%s)\n", gib(disk.FreeBytes), gib(disk.TotalBytes), disk.Mount, disk.Medium)
+	}
+	for _, acc := range info.Accelerators {
+		fmt.Printf("Accelerator:  %s (%s)\n", acc.Model, acceleratorDetails(acc))
+	}
+	for _, component := range report.Installation.Components {
+		if component.Installed {
+			fmt.Printf("Installed:    %s %s (%s)\n", component.Name, valueOrUnknown(component.Version), component.Path)
+		}
+	}
+	if network := report.Network; network != nil {
+		if network.Reachable {
+			fmt.Printf("Companion:    reachable (latency %s)\n", network.Latency.Round(time.Millisecond))
+		} else {
+			fmt.Printf("Companion:    unreachable (%v)\n", network.Error)
+		}
+		if suggestion := network.Suggestion(); suggestion != "" {
+			log.Error(suggestion)
+		}
+	}
+
+	failed := make([]string, 0, len(info.ProbeErrors))
+	for name := range info.ProbeErrors {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		log.Errorf("Capability probe %s failed: %s", name, info.ProbeErrors[name])
+	}
+}
+
+// environmentDetails describes the container or virtual machine
+func environmentDetails(env detector.Environment) string {
+	var details []string
+	if env.Container != "" {
+		details = append(details, env.Container)
+	}
+	if env.Kubernetes {
+		details = append(details, "kubernetes")
+	}
+	if env.WSL > 0 {
+		details = append(details, fmt.Sprintf("WSL %d", env.WSL))
+	}
+	if env.Hypervisor != "" {
+		details = append(details, "virtual machine ("+env.Hypervisor+")")
+	}
+	if len(details) == 0 {
+		details = append(details, "host")
+	}
+	return strings.Join(details, ", ")
+}
+
+// filesystemDetails describes the root file system and whether the
+// system is image-based
+func filesystemDetails(fs detector.Filesystem) string {
+	details := []string{valueOrUnknown(fs.RootType)}
+	if fs.RootReadOnly {
+		details = append(details, "read-only")
+	} else if fs.UsrReadOnly {
+		details = append(details, "read-only /usr")
+	}
+	if fs.Immutable != "" {
+		details = append(details, "immutable ("+fs.Immutable+")")
+	}
+	if fs.Sysext {
+		details = append(details, "systemd-sysext")
+	}
+	return strings.Join(details, ", ")
+}
+
+// boardDetails describes the board model, revision and SoC
+func boardDetails(board detector.BoardInfo) string {
+	details := strings.TrimSpace(board.Vendor + " " + board.Model)
+	var extra []string
+	if board.Revision != "" {
+		extra = append(extra, "revision "+board.Revision)
+	}
+	if board.SoC != "" {
+		extra = append(extra, board.SoC)
+	}
+	if len(extra) > 0 {
+		details += " (" + strings.Join(extra, ", ") + ")"
+	}
+	return details
+}
+
+// acceleratorDetails describes the driver and runtime of an accelerator
+func acceleratorDetails(acc detector.Accelerator) string {
+	details := []string{"driver " + valueOrUnknown(acc.Driver)}
+	if acc.Runtime != "" {
+		details = append(details, strings.TrimSpace(acc.Runtime+" "+acc.RuntimeVersion))
+	}
+	return strings.Join(details, ", ")
+}
+
+// gib formats a byte count in GiB, or "unknown" for zero
+func gib(bytes uint64) string {
+	if bytes == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%.1f GiB", float64(bytes)/(1<<30))
+}
+
+// valueOrUnknown returns s, or "unknown" if it is empty
+func valueOrUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// writeYAML writes v as YAML, keeping the field names and order of its
+// JSON encoding
+func writeYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML in flow style
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	blockStyle(&doc)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// blockStyle resets the style of a node tree so it is written in block
+// style, with strings only quoted where needed
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
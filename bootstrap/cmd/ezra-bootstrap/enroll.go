@@ -0,0 +1,42 @@
+package main
+
+var enrollCommand = &command{
+	name:    "enroll",
+	usage:   "enroll [OPTIONS]",
+	summary: "Enroll an installed device with the companion and store its credential",
+}
+
+func init() {
+	enrollCommand.run = runEnroll
+}
+
+// runEnroll handles the enroll subcommand
+func runEnroll(args []string) {
+	fs := newFlagSet(enrollCommand)
+	opts := addCommonFlags(fs)
+	var (
+		token = fs.String("token", "", "One-time enrollment token issued by the companion operator")
+		force = fs.Bool("force", false, "Enroll again even if the device is already enrolled")
+		wait  = fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Enrollment starting...")
+
+	inst, cfg := newInstaller(log, opts)
+	if *token != "" {
+		cfg.Enrollment.Token = *token
+	}
+	relaunchElevated(log, inst, opts)
+	defer lockInstall(log, inst, *wait)()
+
+	if err := inst.Enroll(*force); err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Enrollment interrupted")
+		}
+		fatal(log, err, "Enrollment failed: %v", err)
+	}
+
+	log.Info("Device enrolled successfully!")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/discovery"
+)
+
+var installCommand = &command{
+	name:    "install",
+	usage:   "install [OPTIONS]",
+	summary: "Install Ezra on this device",
+}
+
+func init() {
+	installCommand.run = runInstall
+}
+
+// runInstall handles the install subcommand
+func runInstall(args []string) {
+	fs := newFlagSet(installCommand)
+	opts := addCommonFlags(fs)
+	var (
+		offline  = fs.Bool("offline", false, "Install in offline mode (USB/SD card)")
+		media    = fs.String("media", "", "Offline media directory, or media or bundle archive (.tar.zst, .tar.gz, .zip), to install from (default: search removable devices)")
+		deviceID = fs.String("device-id", "", "Device identifier")
+		dryRun   = fs.Bool("dry-run", false, "Print the installation plan without changing the system")
+		tofu     = fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+		conflict = fs.String("on-conflict", "", "Existing files changed outside Ezra: overwrite, keep or prompt (default from config)")
+		selected = fs.String("components", "", "Comma-separated components to install: companion, agent, executor (default from config, or all)")
+		wait     = fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+		report   = fs.String("report", "", "Write the install report to this path (default from config, or install-report.json in the data directory)")
+		discover = fs.Bool("discover", false, "Find the companion on the local network over mDNS or SSDP")
+		pair     = fs.Bool("pair", false, "Pair with the companion by showing a code to approve there, and take the configuration it sends")
+		latest   = fs.Bool("force-latest", false, "Take the newest release even if its staged rollout has not reached this device")
+	)
+	fs.Parse(args)
+
+	// Set up logging
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Installer starting...")
+
+	if *discover {
+		if *opts.companionURL != "" || *offline || *media != "" {
+			log.Exitf(failure.ExitUsage, "-discover cannot be combined with -companion-url, -offline or -media")
+		}
+		*opts.companionURL = discoverCompanion(log, loadAnswers(log, opts))
+	}
+
+	if *pair && (*offline || *media != "" || *dryRun) {
+		log.Exitf(failure.ExitUsage, "-pair cannot be combined with -offline, -media or -dry-run")
+	}
+
+	// Create installer
+	ans := loadAnswers(log, opts)
+	opts.deviceID = deviceID
+	inst, cfg := newInstaller(log, opts)
+	if *pair {
+		inst, cfg = pairDevice(log, inst, cfg, opts)
+	}
+	if *conflict != "" {
+		cfg.OnConflict = *conflict
+	}
+	if *report != "" {
+		cfg.Report.Path = *report
+	}
+	if *selected != "" {
+		names := strings.Split(*selected, ",")
+		for n := range names {
+			names[n] = strings.TrimSpace(names[n])
+		}
+		if err := inst.SetComponents(names); err != nil {
+			log.Exitf(failure.ExitUsage, "Invalid -components: %v", err)
+		}
+	} else if len(ans.Components) > 0 {
+		if err := inst.SetComponents(ans.Components); err != nil {
+			log.Exitf(failure.ExitUsage, "Invalid components answer: %v", err)
+		}
+	}
+	ans.checkConflictPolicy(log, cfg.OnConflict)
+	inst.SetDryRun(*dryRun)
+	inst.SetForceLatest(*latest)
+	relaunchElevated(log, inst, opts)
+	defer lockInstall(log, inst, *wait)()
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, loadAnswers(log, opts)))
+	inst.SetConflictResolver(ans.confirmOverwrite)
+	if *media != "" {
+		inst.SetMediaPath(*media)
+		*offline = true
+	}
+	inst.SetMediaChooser(ans.chooseMedia)
+	pinned := cfg.PublicKeyPinned
+
+	// Choose installation method
+	var err error
+	if *offline {
+		log.Info("Installing in offline mode...")
+		err = inst.InstallOffline()
+	} else {
+		log.Info("Installing in online mode...")
+		err = inst.InstallOnline()
+	}
+
+	// A key pinned on first use is kept even if the install failed
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+
+	if err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Installation interrupted and rolled back: run it again to resume")
+		}
+		if !*offline && !*dryRun {
+			suggestNetworkFix(log, inst)
+		}
+		fatal(log, err, "Installation failed: %v", err)
+	}
+
+	if *dryRun {
+		printPlan(inst.Plan())
+		return
+	}
+
+	for component, provenance := range inst.Report().Provenance {
+		log.Infof("%s: built by %s from %s (%s)", component, provenance.Builder, provenance.Source, provenance.BuildType)
+	}
+
+	log.Info("Installation completed successfully!")
+}
+
+// printPlan prints the actions recorded during a dry run
+func printPlan(plan *installer.Plan) {
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"Files to download", plan.Downloads},
+		{"Directories to create", plan.Directories},
+		{"Files to write", plan.Files},
+		{"Services to install", plan.Services},
+		{"Commands to run", plan.Commands},
+	}
+
+	fmt.Println("Installation plan (dry run, no changes made):")
+	for _, section := range sections {
+		fmt.Printf("\n%s:\n", section.title)
+		if len(section.items) == 0 {
+			fmt.Println("    (none)")
+		}
+		for _, item := range section.items {
+			fmt.Printf("    %s\n", item)
+		}
+	}
+}
+
+// confirmOverwrite asks the operator whether to replace a file that was
+// changed outside Ezra
+func confirmOverwrite(path string) bool {
+	fmt.Printf("%s was changed outside Ezra. Replace it (the original is backed up)? [y/N] ", path)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// discoverCompanion searches the local network for companions and
+// returns the URL of the only one found, or of the one the companion
+// answer or the operator picks
+func discoverCompanion(log *logger.Logger, a *answers) string {
+	log.Info("Searching the local network for a companion...")
+	found, err := discovery.Discover(interruptContext(log), 0)
+	if err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Discovery interrupted")
+		}
+		fatal(log, failure.Wrap(failure.Network, err), "Companion discovery failed: %v", err)
+	}
+
+	switch len(found) {
+	case 0:
+		log.Exitf(failure.ExitNetwork, "No companion found on the local network; give its address with -companion-url")
+	case 1:
+		log.Infof("Found companion %s at %s", found[0].Name, found[0].URL)
+		return found[0].URL
+	}
+
+	if a.Companion != "" {
+		for _, companion := range found {
+			if companion.URL == a.Companion || companion.Name == a.Companion {
+				log.Infof("Using companion %s at %s", companion.Name, companion.URL)
+				return companion.URL
+			}
+		}
+		log.Exitf(failure.ExitNetwork, "Answered companion %s was not found on the local network", a.Companion)
+	}
+	if a.nonInteractive {
+		log.Exitf(failure.ExitUsage, "%v", unanswered("Several companions were found", "companion"))
+	}
+
+	fmt.Println("Several companions were found:")
+	for n, companion := range found {
+		version := ""
+		if companion.Version != "" {
+			version = " " + companion.Version
+		}
+		fmt.Printf("    %d) %s%s at %s\n", n+1, companion.Name, version, companion.URL)
+	}
+	fmt.Printf("Install with which one? [1-%d] ", len(found))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(found) {
+		log.Fatal("No companion chosen")
+	}
+	return found[n-1].URL
+}
+
+// chooseMedia asks the operator which of several offline media to
+// install from
+func chooseMedia(candidates []string) (string, error) {
+	fmt.Println("Several offline media were found:")
+	for n, path := range candidates {
+		fmt.Printf("    %d) %s\n", n+1, path)
+	}
+	fmt.Printf("Install from which one? [1-%d] ", len(candidates))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(candidates) {
+		return "", fmt.Errorf("no offline media chosen")
+	}
+	return candidates[n-1], nil
+}
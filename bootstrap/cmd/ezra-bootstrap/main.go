@@ -1,109 +1,558 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/events"
+	"github.com/ezra/bootstrap/internal/failure"
 	"github.com/ezra/bootstrap/internal/installer"
 	"github.com/ezra/bootstrap/internal/logger"
 	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/redact"
 )
 
+// command is a bootstrap subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+// Build metadata, set with -ldflags -X when the bootstrap is built, see
+// the Makefile. Builds without them fall back to what the Go toolchain
+// recorded, see buildInfo.
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildTime = ""
+)
+
+// pinnedConfigFile is where a key pinned on first use, or the identity
+// of a paired device, is saved when no configuration file was given
+const pinnedConfigFile = "bootstrap-config.json"
+
+// commands lists the available subcommands in the order shown in help
+var commands = []*command{
+	installCommand,
+	uninstallCommand,
+	upgradeCommand,
+	daemonCommand,
+	selfUpdateCommand,
+	repairCommand,
+	enrollCommand,
+	createMediaCommand,
+	mirrorCommand,
+	statusCommand,
+	reportCommand,
+	verifyCommand,
+	detectCommand,
+	networkCommand,
+	configCommand,
+	secretCommand,
+	versionCommand,
+}
+
 func main() {
-	var (
-		configFile   = flag.String("config", "", "Configuration file path")
-		offline      = flag.Bool("offline", false, "Install in offline mode")
-		deviceID     = flag.String("device-id", "", "Device identifier")
-		companionURL = flag.String("companion-url", "http://localhost:3000", "Companion server URL")
-		verbose      = flag.Bool("verbose", false, "Enable verbose logging")
-		help         = flag.Bool("help", false, "Show help")
-	)
-	flag.Parse()
-
-	if *help {
+	args := os.Args[1:]
+
+	// Without a subcommand, or with only flags, behave like install so
+	// existing provisioning scripts keep working
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-help" || args[0] == "--help" || args[0] == "-h") {
+			showHelp()
+			return
+		}
+		if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+			versionCommand.run(args[1:])
+			return
+		}
+		installCommand.run(args)
+		logger.Exit(0)
+	}
+
+	if args[0] == "help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				cmd.run([]string{"-help"})
+				return
+			}
+		}
 		showHelp()
 		return
 	}
 
-	// Set up logging
-	log := logger.New(*verbose)
-	log.Info("Ezra Bootstrap Installer starting...")
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		showHelp()
+		os.Exit(2)
+	}
 
-	// Load configuration
-	cfg, err := config.Load(*configFile)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	cmd.run(args[1:])
+	// Run what waits for the end, like sending the rest of the log
+	logger.Exit(0)
+}
+
+// findCommand looks up a subcommand by name
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
+	return nil
+}
+
+// newFlagSet creates a flag set for a subcommand with its own help text
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nUSAGE:\n    ezra-bootstrap %s\n\nOPTIONS:\n", cmd.summary, cmd.usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// configEnv names the configuration file when -config is not given, for
+// containers and cloud-init where flags are awkward
+const configEnv = config.EnvPrefix + "CONFIG"
+
+// commonOptions holds the flags shared by most subcommands
+type commonOptions struct {
+	configFile          *string
+	companionURL        *string
+	verbose             *bool
+	logFormat           *string
+	downloadConcurrency *int
+	noCache             *bool
+	progress            *string
+	user                *bool
+	remoteConfig        *bool
+	eventsFD            *int
+	eventsFile          *string
+	regenerateDeviceID  *bool
+	answersFile         *string
+	nonInteractive      *bool
+	// answers are read from answersFile by loadAnswers
+	answers *answers
+	// deviceID is set by the commands that take -device-id, so that the
+	// configuration is pulled for that device
+	deviceID *string
+}
 
-	// Override config with command line flags
-	if *deviceID != "" {
-		cfg.DeviceID = *deviceID
+// addCommonFlags registers the shared flags on a flag set
+func addCommonFlags(fs *flag.FlagSet) *commonOptions {
+	return &commonOptions{
+		configFile:          fs.String("config", os.Getenv(configEnv), "Configuration file path (default $EZRA_CONFIG)"),
+		companionURL:        fs.String("companion-url", "", "Companion server URL"),
+		verbose:             fs.Bool("verbose", false, "Enable verbose logging"),
+		logFormat:           fs.String("log-format", "text", "Log format: text, or json for one JSON object per line"),
+		downloadConcurrency: fs.Int("download-concurrency", 0, "Connections per large download (default from config)"),
+		noCache:             fs.Bool("no-cache", false, "Do not read or populate the download cache"),
+		progress:            fs.String("progress", "", "Progress output: auto, tty, log or json (json is written to stderr)"),
+		user:                fs.Bool("user", false, "Install for the current user only, without root: binaries in ~/.local/bin and per-user services"),
+		remoteConfig:        fs.Bool("remote-config", false, "Pull the configuration of this device from the companion; the local configuration overrides it"),
+		eventsFD:            fs.Int("events-fd", 0, "Write progress events as NDJSON to this inherited file descriptor, for wrapper tools"),
+		eventsFile:          fs.String("events-file", "", "Write progress events as NDJSON to this file or named pipe, for wrapper tools"),
+		regenerateDeviceID:  fs.Bool("regenerate-device-id", false, "Replace the device ID with a new random one, e.g. on devices cloned from one image"),
+		answersFile:         fs.String("answers", "", "JSON file answering the questions the run would ask, for unattended installs"),
+		nonInteractive:      fs.Bool("non-interactive", false, "Fail instead of asking a question the answers file does not answer"),
 	}
-	if *companionURL != "" {
-		cfg.CompanionURL = *companionURL
+}
+
+// newLogger creates the logger of a subcommand in the requested format,
+// exiting on an unknown one
+func newLogger(verbose bool, format string) *logger.Logger {
+	log := logger.New(verbose)
+	if err := log.SetFormat(format); err != nil {
+		log.Fatal(err)
 	}
+	return log
+}
 
-	// Detect system
-	detector := detector.New()
-	systemInfo, err := detector.Detect()
+// newInstaller loads configuration, detects the system and creates an
+// installer, exiting on failure
+func newInstaller(log *logger.Logger, opts *commonOptions) (*installer.Installer, *config.Config) {
+	cfg := loadConfig(log, opts)
+	addLogFile(log, cfg)
+	if err := log.AddSink(cfg.LogSink); err != nil {
+		log.Errorf("Not logging to the system log: %v", err)
+	}
+	pullConfig(log, cfg, opts)
+
+	d := detector.New()
+	d.SetPaths([]string{cfg.InstallPath, cfg.DataPath, cfg.CachePath})
+	systemInfo, err := d.Detect()
 	if err != nil {
 		log.Fatalf("Failed to detect system: %v", err)
 	}
 
 	log.Infof("Detected system: %s %s on %s", systemInfo.OS, systemInfo.Version, systemInfo.Architecture)
 
-	// Create installer
 	inst, err := installer.New(cfg, systemInfo, log)
 	if err != nil {
-		log.Fatalf("Failed to create installer: %v", err)
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to create installer: %v", err)
+	}
+	inst.SetContext(interruptContext(log))
+	inst.SetBootstrapVersion(buildInfo().Version)
+	log.OnExit(inst.ShipLogs())
+
+	stream, err := events.Open(*opts.eventsFD, *opts.eventsFile)
+	if err != nil {
+		log.Fatalf("Failed to open the event stream: %v", err)
+	}
+	inst.SetEvents(stream)
+	log.OnExit(func() { stream.Close() })
+
+	return inst, cfg
+}
+
+// loadConfig loads the configuration and applies the flags over it,
+// exiting on failure
+func loadConfig(log *logger.Logger, opts *commonOptions) *config.Config {
+	cfg, err := config.Load(*opts.configFile)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to load configuration: %v", err)
+	}
+	if *opts.regenerateDeviceID {
+		if err := cfg.RegenerateDeviceID(); err != nil {
+			fatal(log, failure.Wrap(failure.Config, err), "Failed to regenerate the device ID: %v", err)
+		}
+		log.Infof("New device ID: %s", cfg.DeviceID)
+	}
+	applyFlags(cfg, opts)
+	redact.AddSecret(cfg.Secrets()...)
+	return cfg
+}
+
+// pullConfig merges the configuration of the device pulled from the
+// companion, if remote configuration is enabled, and applies the flags
+// over it again, exiting on failure
+func pullConfig(log *logger.Logger, cfg *config.Config, opts *commonOptions) {
+	if *opts.remoteConfig {
+		cfg.RemoteConfig.Enabled = true
+		cfg.SetOrigin("remote_config.enabled", "-remote-config")
+	}
+	if !cfg.RemoteConfig.Enabled || cfg.OfflineMode {
+		return
+	}
+	if err := installer.PullConfig(interruptContext(log), cfg, log); err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to pull configuration from the companion: %v", err)
+	}
+	applyFlags(cfg, opts)
+	redact.AddSecret(cfg.Secrets()...)
+}
+
+// applyFlags overrides settings with the flags given, which win over the
+// configuration files, the environment and the companion
+func applyFlags(cfg *config.Config, opts *commonOptions) {
+	if *opts.companionURL != "" {
+		cfg.CompanionURL = *opts.companionURL
+		cfg.SetOrigin("companion_url", "-companion-url")
+	}
+	if opts.deviceID != nil && *opts.deviceID != "" {
+		cfg.DeviceID = *opts.deviceID
+		cfg.SetOrigin("device_id", "-device-id")
+	}
+	if *opts.downloadConcurrency > 0 {
+		cfg.DownloadConcurrency = *opts.downloadConcurrency
+		cfg.SetOrigin("download_concurrency", "-download-concurrency")
+	}
+	if *opts.noCache {
+		cfg.NoCache = true
+		cfg.SetOrigin("no_cache", "-no-cache")
+	}
+	if *opts.progress != "" {
+		cfg.Progress = *opts.progress
+		cfg.SetOrigin("progress", "-progress")
+	}
+	if *opts.user {
+		cfg.InstallMode = "user"
+		cfg.SetOrigin("install_mode", "-user")
+	}
+}
+
+// addLogFile copies the log to the configured log file. Before elevating,
+// the file usually cannot be opened yet; the elevated run logs to it.
+func addLogFile(log *logger.Logger, cfg *config.Config) {
+	if cfg.LogFile.Disabled {
+		return
+	}
+	path := cfg.LogFilePath()
+	err := log.AddFile(logger.FileOptions{
+		Path:       path,
+		MaxSize:    int64(cfg.LogFile.MaxSizeMB) << 20,
+		MaxBackups: cfg.LogFile.MaxBackups,
+		MaxAge:     time.Duration(cfg.LogFile.MaxAgeDays) * 24 * time.Hour,
+	})
+	if errors.Is(err, os.ErrPermission) {
+		log.Debugf("Not logging to %s: %v", path, err)
+		return
+	}
+	if err != nil {
+		log.Errorf("Not logging to %s: %v", path, err)
 	}
+}
+
+// fatal logs a failure and exits with the exit code of its category, see
+// failure.ExitCode
+func fatal(log *logger.Logger, err error, format string, args ...interface{}) {
+	log.Exitf(failure.ExitCode(err), format, args...)
+}
+
+// interruptCtx is the context interruptContext hands out, set up once
+var (
+	interruptOnce sync.Once
+	interruptCtx  context.Context
+)
+
+// interruptContext returns a context cancelled by the first SIGINT or
+// SIGTERM, so that the run stops cleanly. A second one exits at once.
+func interruptContext(log *logger.Logger) context.Context {
+	interruptOnce.Do(func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			log.Info("Interrupted: stopping and cleaning up, interrupt again to exit at once")
+			stop()
+		}()
+		interruptCtx = ctx
+	})
+	return interruptCtx
+}
 
-	// Choose installation method
-	if *offline {
-		log.Info("Installing in offline mode...")
-		err = inst.InstallOffline()
-	} else {
-		log.Info("Installing in online mode...")
-		err = inst.InstallOnline()
+// interrupted reports whether a run failed because it was interrupted
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// relaunchElevated starts the bootstrap again through a UAC prompt when
+// it needs administrator rights, and exits with the elevated run's status.
+// A non-interactive run fails instead, as nobody can answer the prompt.
+func relaunchElevated(log *logger.Logger, inst *installer.Installer, opts *commonOptions) {
+	if !inst.NeedsRelaunch() {
+		return
+	}
+	if *opts.nonInteractive {
+		log.Exitf(failure.ExitPermission, "Administrator rights are needed, and -non-interactive does not show a UAC prompt: run it elevated")
 	}
+	log.Info("Administrator rights are needed: relaunching through a UAC prompt...")
+	code, err := installer.RelaunchElevated()
+	if err != nil {
+		log.Fatalf("Failed to relaunch as administrator: %v", err)
+	}
+	logger.Exit(code)
+}
 
+// lockInstall takes the install lock, exiting if another run holds it
+// and wait is not set. The lock is also released when a fatal error
+// exits.
+func lockInstall(log *logger.Logger, inst *installer.Installer, wait bool) func() {
+	unlock, err := inst.Lock(wait)
 	if err != nil {
-		log.Fatalf("Installation failed: %v", err)
+		if interrupted(err) {
+			fatal(log, err, "Interrupted while waiting for the install lock")
+		}
+		log.Fatalf("%v", err)
+	}
+	log.OnExit(unlock)
+	return unlock
+}
+
+// keyConfirmation returns how a companion signing key seen for the first
+// time is confirmed: automatically with -tofu, by the trust_key answer,
+// otherwise by the operator
+func keyConfirmation(auto bool, a *answers) installer.KeyConfirmation {
+	return func(fingerprint string) bool {
+		fmt.Printf("No public key is configured. The companion signs releases with key\n    %s\n", fingerprint)
+		switch {
+		case auto:
+			fmt.Println("Trusting it on first use (-tofu).")
+			return true
+		case a.TrustKey != "":
+			if !strings.EqualFold(strings.TrimSpace(a.TrustKey), fingerprint) {
+				fmt.Printf("The answers file trusts key %s instead.\n", a.TrustKey)
+				return false
+			}
+			fmt.Println("Trusting it on first use (trust_key answer).")
+			return true
+		case a.nonInteractive:
+			fmt.Printf("%v, or use -tofu.\n", unanswered("Trusting it needs confirmation", "trust_key"))
+			return false
+		}
+
+		fmt.Print("Trust this key and pin it? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// savePinnedKey saves a configuration whose key was pinned on first use,
+// so that later runs verify against it. Without a configuration file it
+// is written under the data directory.
+func savePinnedKey(log *logger.Logger, cfg *config.Config, configFile string) {
+	path, err := saveSettings(cfg, configFile, map[string]interface{}{
+		"public_key":        cfg.PublicKey,
+		"public_key_pinned": true,
+	})
+	if err != nil {
+		log.Errorf("Failed to save pinned key: %v", err)
+		return
 	}
 
-	log.Info("Installation completed successfully!")
+	log.Infof("Pinned key saved to %s", path)
+	if configFile == "" {
+		log.Infof("Use -config %s on later runs", path)
+	}
+}
+
+// saveSettings sets settings in configFile, or in the file under the
+// data directory when none was given, and returns where they went. The
+// rest of the file is kept, and nothing else of the configuration, such
+// as what the environment or the companion set, is written to it.
+func saveSettings(cfg *config.Config, configFile string, settings map[string]interface{}) (string, error) {
+	path := configFile
+	if path == "" {
+		path = filepath.Join(cfg.DataPath, pinnedConfigFile)
+	}
+	return path, config.UpdateFile(path, settings)
 }
 
 func showHelp() {
 	fmt.Printf(`Ezra Bootstrap Installer
 
 USAGE:
-    ezra-bootstrap [OPTIONS]
-
-OPTIONS:
-    -config string
-        Configuration file path
-    -offline
-        Install in offline mode (USB/SD card)
-    -device-id string
-        Device identifier
-    -companion-url string
-        Companion server URL (default: http://localhost:3000)
-    -verbose
-        Enable verbose logging
-    -help
-        Show this help message
+    ezra-bootstrap <COMMAND> [OPTIONS]
+
+COMMANDS:
+`)
+	for _, cmd := range commands {
+		fmt.Printf("    %-14s%s\n", cmd.name, cmd.summary)
+	}
+	fmt.Printf(`
+Running ezra-bootstrap without a command is the same as "install".
+Use "ezra-bootstrap help <COMMAND>" for the options of a command.
 
 EXAMPLES:
     # Online installation
-    ezra-bootstrap -companion-url https://companion.ezra.dev
+    ezra-bootstrap install -companion-url https://companion.ezra.dev
 
     # Offline installation
-    ezra-bootstrap -offline
+    ezra-bootstrap install -offline
+
+    # Offline installation from a bundle copied to the device
+    ezra-bootstrap install -media /srv/ezra-bundle-linux-aarch64.tar.zst
+
+    # Find the companion on the local network and install with it
+    ezra-bootstrap install -discover
+
+    # Pair a headless device by approving its code in the companion
+    ezra-bootstrap install -discover -pair
+
+    # Check that the companion can be reached, e.g. behind a proxy
+    ezra-bootstrap network -companion-url https://companion.ezra.dev
+
+    # Show what an installation would do
+    ezra-bootstrap install -dry-run
+
+    # Log one JSON object per line for a provisioning pipeline to parse
+    ezra-bootstrap install -log-format json
+
+    # Stream progress events to a GUI wrapper over file descriptor 3
+    ezra-bootstrap install -events-fd 3 3>progress.pipe
+
+    # Install for the current user on a shared machine, without root
+    ezra-bootstrap install -user
+
+    # Install only the agent and executor on a device using a remote companion
+    ezra-bootstrap install -components agent,executor -companion-url https://companion.ezra.dev
+
+    # Upgrade an existing installation, keeping its configuration
+    ezra-bootstrap upgrade
+
+    # Check for updates every 6 hours and apply them between 02:00 and 04:00
+    ezra-bootstrap daemon -schedule -interval 360 -window 02:00-04:00
+
+    # Update this bootstrap to the one in the latest release
+    ezra-bootstrap self-update
+
+    # Fix corrupted binaries, missing files and stopped services
+    ezra-bootstrap repair
+
+    # Enroll an installed device with a token from the companion operator
+    ezra-bootstrap enroll -token 3f9c2a71
+
+    # Write offline media for Raspberry Pis and x86 servers to a USB stick
+    ezra-bootstrap create-media -format /dev/sdb -platforms linux/aarch64,linux/x86_64 -signing-key media.key
+
+    # Keep a local mirror of every release up to date, e.g. from cron
+    ezra-bootstrap mirror sync -target /srv/ezra-mirror -prune
+
+    # Install unattended from an imaging pipeline, failing rather than asking
+    # anything answers.json does not answer
+    ezra-bootstrap install -answers answers.json -non-interactive
+
+    # Start a configuration from the commented defaults, then change a setting
+    ezra-bootstrap config init
+    ezra-bootstrap config set auto_update.interval_minutes 120
+
+    # Check a configuration for invalid settings before rolling it out
+    ezra-bootstrap config validate -config /etc/ezra/config.json
+
+    # Show each setting in effect and the file, variable or flag it came from
+    ezra-bootstrap config show -origin
+
+    # Keep the enrollment token in the keyring, with "token": "secret:enrollment-token"
+    ezra-bootstrap secret set enrollment-token < token.txt
+
+    # Report versions, service health and enrollment for monitoring
+    ezra-bootstrap status -output json
+
+    # Collect logs, state and redacted configuration for a bug report
+    ezra-bootstrap report -output ezra-support.tar.gz
+
+    # Show which build of the bootstrap is running
+    ezra-bootstrap version -output json
+
+    # Remove Ezra including all data
+    ezra-bootstrap uninstall -purge
+
+ENVIRONMENT:
+    Every setting of the configuration file can be set with an EZRA_
+    variable named after its path in upper case, e.g. EZRA_COMPANION_URL,
+    EZRA_DEVICE_ID, EZRA_OFFLINE or EZRA_LOG_FILE_MAX_SIZE_MB. Lists are
+    comma-separated; maps and lists of objects are JSON. EZRA_CONFIG names
+    the configuration file. Settings are taken, from lowest to highest
+    precedence, from the defaults, the companion, /etc/ezra/bootstrap.conf
+    (%%ProgramData%%\Ezra\bootstrap.conf on Windows),
+    ~/.config/ezra/bootstrap.conf, the -config file, the environment and
+    the flags.
 
-    # Custom device ID
-    ezra-bootstrap -device-id my-device-001
+EXIT CODES:
+    0    Success
+    1    Other failure
+    2    Invalid flags or arguments
+    3    Invalid configuration
+    4    Network failure: the companion or a download server cannot be reached
+    5    Verification failure: a checksum, signature or provenance check failed
+    6    Permission denied
+    7    Not enough disk space
+    8    Unsupported platform or hardware
+    130  Interrupted
 
 For more information, visit: https://github.com/ezra/ezra
 `)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ezra/bootstrap/internal/installer"
+)
+
+var mirrorCommand = &command{
+	name:    "mirror",
+	usage:   "mirror sync -target <dir> [OPTIONS]",
+	summary: "Copy the release tree into a directory served as a local mirror",
+}
+
+func init() {
+	mirrorCommand.run = runMirror
+}
+
+// runMirror handles the mirror subcommand
+func runMirror(args []string) {
+	fs := newFlagSet(mirrorCommand)
+	opts := addCommonFlags(fs)
+	var (
+		target     = fs.String("target", "", "Directory to sync the release tree into, e.g. /srv/ezra-mirror")
+		platforms  = fs.String("platforms", "", "Comma-separated os/arch platforms to sync, e.g. linux/aarch64,windows/x86_64 (default: all published)")
+		latestOnly = fs.Bool("latest-only", false, "Sync only the latest release instead of every version in the release index")
+		prune      = fs.Bool("prune", false, "Remove files an earlier sync wrote that are no longer published")
+		tofu       = fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+	)
+	if len(args) == 0 || args[0] != "sync" {
+		if len(args) > 0 && args[0] != "-help" && args[0] != "--help" && args[0] != "-h" {
+			fmt.Fprintf(os.Stderr, "Unknown mirror command: %s\n\n", args[0])
+		}
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args[1:])
+
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "-target is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Mirror Sync starting...")
+
+	mirror := installer.MirrorOptions{
+		Target:     *target,
+		Platforms:  parsePlatforms(log, *platforms),
+		LatestOnly: *latestOnly,
+		Prune:      *prune,
+	}
+
+	inst, cfg := newInstaller(log, opts)
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, loadAnswers(log, opts)))
+	pinned := cfg.PublicKeyPinned
+
+	report, err := inst.SyncMirror(mirror)
+
+	// A key pinned on first use is kept even if the sync failed
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+	if err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Mirror sync interrupted: run it again to continue")
+		}
+		fatal(log, err, "Mirror sync failed: %v", err)
+	}
+
+	fmt.Printf("Downloaded: %d\nUnchanged:  %d\nRemoved:    %d\n", len(report.Downloaded), report.Unchanged, len(report.Removed))
+	if len(report.Unverified) > 0 {
+		fmt.Println("\nPublished without a checksum or signature, not verified:")
+		for _, path := range report.Unverified {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+}
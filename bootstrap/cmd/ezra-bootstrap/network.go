@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+var networkCommand = &command{
+	name:    "network",
+	usage:   "network [OPTIONS]",
+	summary: "Check that the companion can be reached",
+}
+
+func init() {
+	networkCommand.run = runNetwork
+}
+
+// runNetwork handles the network subcommand
+func runNetwork(args []string) {
+	fs := newFlagSet(networkCommand)
+	opts := addCommonFlags(fs)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	inst, cfg := newInstaller(log, opts)
+
+	report := inst.CheckNetwork()
+	fmt.Printf("Companion:      %s\n", cfg.CompanionURL)
+	fmt.Printf("Proxy:          %s\n", valueOrDefault(report.Proxy, "none"))
+	if report.Reachable {
+		fmt.Printf("Reachable:      yes (latency %s)\n", report.Latency.Round(time.Millisecond))
+	} else {
+		fmt.Printf("Reachable:      no (%v)\n", report.Error)
+	}
+	if report.Bandwidth > 0 {
+		fmt.Printf("Bandwidth:      %.0f KiB/s\n", report.Bandwidth/1024)
+	}
+	fmt.Printf("Captive portal: %s\n", yesNo(report.CaptivePortal))
+
+	if suggestion := report.Suggestion(); suggestion != "" {
+		fmt.Println()
+		fmt.Println(suggestion)
+		logger.Exit(failure.ExitNetwork)
+	}
+}
+
+// suggestNetworkFix checks the network after an online installation
+// failed and logs advice if it explains the failure
+func suggestNetworkFix(log *logger.Logger, inst *installer.Installer) {
+	if suggestion := inst.CheckNetwork().Suggestion(); suggestion != "" {
+		log.Error(suggestion)
+	}
+}
+
+// valueOrDefault returns s, or def if it is empty
+func valueOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// yesNo formats a boolean for display
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
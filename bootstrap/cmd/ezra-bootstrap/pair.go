@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/installer"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// qrQuietZone is the light border around a printed QR code, in modules
+const qrQuietZone = 2
+
+// pairDevice pairs the device with the companion, saves its identity
+// and enrollment, and returns an installer using the configuration the
+// companion keeps for it, pulled again on later runs
+func pairDevice(log *logger.Logger, inst *installer.Installer, cfg *config.Config, opts *commonOptions) (*installer.Installer, *config.Config) {
+	relaunchElevated(log, inst, opts)
+	settings, err := inst.Pair(showPairingCode)
+	if err != nil {
+		if interrupted(err) {
+			fatal(log, err, "Pairing interrupted")
+		}
+		fatal(log, err, "Pairing failed: %v", err)
+	}
+	if len(settings) == 0 {
+		return inst, cfg
+	}
+
+	path, err := saveSettings(cfg, *opts.configFile, settings)
+	if err != nil {
+		log.Fatalf("Failed to save the pairing: %v", err)
+	}
+	log.Infof("Pairing saved to %s", path)
+	if *opts.configFile == "" {
+		log.Infof("Use -config %s on later runs", path)
+	}
+
+	*opts.configFile = path
+	return newInstaller(log, opts)
+}
+
+// showPairingCode shows the code and QR code the operator approves the
+// pairing with
+func showPairingCode(code, approveURL string, expires time.Time) {
+	text := approveURL
+	if text == "" {
+		text = code
+	}
+
+	fmt.Println()
+	fmt.Println("Approve this device in the companion to pair it:")
+	fmt.Println()
+	printQR(text)
+	fmt.Printf("    Pairing code: %s\n", code)
+	if approveURL != "" {
+		fmt.Printf("    Or open: %s\n", approveURL)
+	}
+	fmt.Printf("    The code expires at %s\n\n", expires.Local().Format("15:04"))
+}
+
+// printQR prints a QR code with half blocks, two rows of modules per
+// line. Light modules are drawn, so the code reads on the dark
+// background of most consoles.
+func printQR(text string) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return
+	}
+
+	size := code.Size + qrQuietZone
+	light := func(x, y int) bool {
+		return y < size && !code.Black(x, y)
+	}
+	for y := -qrQuietZone; y < size; y += 2 {
+		var line strings.Builder
+		line.WriteString("    ")
+		for x := -qrQuietZone; x < size; x++ {
+			switch top, bottom := light(x, y), light(x, y+1); {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		fmt.Println(line.String())
+	}
+	fmt.Println()
+}
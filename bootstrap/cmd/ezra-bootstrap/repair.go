@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+var repairCommand = &command{
+	name:    "repair",
+	usage:   "repair [OPTIONS]",
+	summary: "Check an installation and fix corrupted or missing files and services",
+}
+
+func init() {
+	repairCommand.run = runRepair
+}
+
+// runRepair handles the repair subcommand
+func runRepair(args []string) {
+	fs := newFlagSet(repairCommand)
+	opts := addCommonFlags(fs)
+	tofu := fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+	wait := fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Repair starting...")
+
+	inst, cfg := newInstaller(log, opts)
+	relaunchElevated(log, inst, opts)
+	unlock := lockInstall(log, inst, *wait)
+	defer unlock()
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, loadAnswers(log, opts)))
+	pinned := cfg.PublicKeyPinned
+
+	report, err := inst.Repair()
+
+	// A key pinned on first use is kept even if the repair failed
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+	if err != nil {
+		fatal(log, err, "Repair failed: %v", err)
+	}
+
+	if len(report.Fixed) == 0 && len(report.Remaining) == 0 {
+		fmt.Println("No problems found")
+	}
+	if len(report.Fixed) > 0 {
+		fmt.Println("Fixed:")
+		for _, item := range report.Fixed {
+			fmt.Printf("    %s: %s (%s)\n", item.Target, item.Problem, item.Action)
+		}
+	}
+	if len(report.Modified) > 0 {
+		fmt.Println("\nChanged outside Ezra, kept:")
+		for _, path := range report.Modified {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+	if len(report.Remaining) > 0 {
+		fmt.Println("\nNot fixed:")
+		for _, item := range report.Remaining {
+			fmt.Printf("    %s: %s: %s\n", item.Target, item.Problem, item.Error)
+		}
+		unlock()
+		logger.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var reportCommand = &command{
+	name:    "report",
+	usage:   "report [OPTIONS]",
+	summary: "Collect logs, state and configuration into a support bundle for bug reports",
+}
+
+func init() {
+	reportCommand.run = runReport
+}
+
+// runReport handles the report subcommand
+func runReport(args []string) {
+	fs := newFlagSet(reportCommand)
+	opts := addCommonFlags(fs)
+	var (
+		output = fs.String("output", "", "Path of the support bundle (default: ezra-support-<time>.tar.gz in the current directory)")
+		upload = fs.Bool("upload", false, "Also upload the support bundle to the companion")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Support Bundle starting...")
+
+	inst, _ := newInstaller(log, opts)
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("ezra-support-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+	if err := inst.SupportBundle(path); err != nil {
+		fatal(log, err, "Failed to create support bundle: %v", err)
+	}
+
+	if *upload {
+		receipt, err := inst.UploadSupportBundle(path)
+		if err != nil {
+			fatal(log, err, "Failed to upload support bundle: %v", err)
+		}
+		log.Infof("Support bundle uploaded to the companion as %s", receipt.ID)
+		if receipt.URL != "" {
+			log.Infof("Operators can find it at %s", receipt.URL)
+		}
+		return
+	}
+	log.Infof("Attach %s to the issue, or run again with -upload to send it to the companion", path)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/secrets"
+)
+
+var secretCommand = &command{
+	name:    "secret",
+	usage:   "secret set|delete [OPTIONS] <name>",
+	summary: "Store a secret in the secret store, for settings written secret:<name>",
+}
+
+func init() {
+	secretCommand.run = runSecret
+}
+
+// runSecret handles the secret subcommand. The value of a secret is read
+// from standard input or a file, never from the command line, where
+// other users could see it.
+func runSecret(args []string) {
+	fs := newFlagSet(secretCommand)
+	var (
+		configFile = fs.String("config", os.Getenv(configEnv), "Configuration file path (default $EZRA_CONFIG)")
+		fromFile   = fs.String("from-file", "", "Read the secret from this file instead of standard input, e.g. a PEM key")
+		verbose    = fs.Bool("verbose", false, "Enable verbose logging")
+		logFormat  = fs.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	)
+	if len(args) == 0 || (args[0] != "set" && args[0] != "delete") {
+		if len(args) > 0 && args[0] != "-help" && args[0] != "--help" && args[0] != "-h" {
+			fmt.Fprintf(os.Stderr, "Unknown secret command: %s\n\n", args[0])
+		}
+		fs.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	log := newLogger(*verbose, *logFormat)
+
+	// The settings referring to the secret may not resolve yet
+	cfg, err := config.LoadUnresolved(*configFile)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to load configuration: %v", err)
+	}
+	store, err := cfg.OpenSecretStore()
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to open the secret store: %v", err)
+	}
+
+	if action == "delete" {
+		if err := store.Delete(name); err != nil {
+			log.Fatalf("Failed to delete secret %s: %v", name, err)
+		}
+		log.Infof("Secret %s deleted", name)
+		return
+	}
+
+	var value []byte
+	if *fromFile != "" {
+		value, err = os.ReadFile(*fromFile)
+	} else {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintf(os.Stderr, "Enter secret %s and end the input (Ctrl-D, or Ctrl-Z and Enter on Windows):\n", name)
+		}
+		value, err = io.ReadAll(os.Stdin)
+		// A secret typed or piped in ends with a newline that is not
+		// part of it
+		value = []byte(strings.TrimRight(string(value), "\r\n"))
+	}
+	if err != nil {
+		log.Fatalf("Failed to read secret: %v", err)
+	}
+	if len(value) == 0 {
+		log.Exitf(failure.ExitUsage, "Secret %s is empty", name)
+	}
+
+	if err := store.Set(name, value); err != nil {
+		log.Fatalf("Failed to store secret %s: %v", name, err)
+	}
+	log.Infof("Secret %s stored in the %s secret store", name, storeBackend(cfg))
+}
+
+// storeBackend names the backend of the configured secret store
+func storeBackend(cfg *config.Config) string {
+	if cfg.SecretStore.Backend == "" {
+		return secrets.BackendFile
+	}
+	return cfg.SecretStore.Backend
+}
@@ -0,0 +1,53 @@
+package main
+
+var selfUpdateCommand = &command{
+	name:    "self-update",
+	usage:   "self-update [OPTIONS]",
+	summary: "Replace this bootstrap with the one in the latest release",
+}
+
+func init() {
+	selfUpdateCommand.run = runSelfUpdate
+}
+
+// runSelfUpdate handles the self-update subcommand
+func runSelfUpdate(args []string) {
+	fs := newFlagSet(selfUpdateCommand)
+	opts := addCommonFlags(fs)
+	var (
+		dryRun = fs.Bool("dry-run", false, "Print what would be downloaded and replaced without changing the system")
+		tofu   = fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+		wait   = fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+		latest = fs.Bool("force-latest", false, "Take the newest bootstrap even if its staged rollout has not reached this device")
+	)
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Self-Update starting...")
+
+	inst, cfg := newInstaller(log, opts)
+	inst.SetDryRun(*dryRun)
+	inst.SetForceLatest(*latest)
+	relaunchElevated(log, inst, opts)
+	defer lockInstall(log, inst, *wait)()
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, loadAnswers(log, opts)))
+	pinned := cfg.PublicKeyPinned
+
+	report, err := inst.SelfUpdate()
+
+	// A key pinned on first use is kept even if the update failed
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+	if err != nil {
+		fatal(log, err, "Self-update failed: %v", err)
+	}
+
+	if *dryRun {
+		printPlan(inst.Plan())
+		return
+	}
+	if report.Updated {
+		log.Infof("%s is now %s", report.Path, report.To)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+)
+
+var statusCommand = &command{
+	name:    "status",
+	usage:   "status [OPTIONS]",
+	summary: "Show installed components, service health, enrollment and companion reachability",
+}
+
+func init() {
+	statusCommand.run = runStatus
+}
+
+// statusReport is what the status subcommand reports
+type statusReport struct {
+	*installer.Status
+	Companion *installer.CompanionStatus `json:"companion"`
+}
+
+// runStatus handles the status subcommand
+func runStatus(args []string) {
+	fs := newFlagSet(statusCommand)
+	opts := addCommonFlags(fs)
+	output := fs.String("output", "text", "Output format: text or json")
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	switch *output {
+	case "text":
+	case "json":
+		// Keep stdout for the document
+		log.SetOutput(os.Stderr)
+	default:
+		log.Exitf(failure.ExitUsage, "Unknown output format %q: use text or json", *output)
+	}
+
+	inst, _ := newInstaller(log, opts)
+	report := &statusReport{Status: inst.Status(), Companion: inst.CheckCompanion()}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		return
+	}
+	printStatusReport(report)
+}
+
+// printStatusReport prints the report for people
+func printStatusReport(report *statusReport) {
+	if report.Bootstrap != "" {
+		fmt.Printf("Bootstrap:    %s\n", report.Bootstrap)
+	}
+	lastUpdated := "never"
+	if report.LastUpdated != nil {
+		lastUpdated = report.LastUpdated.Format("2006-01-02 15:04:05")
+	}
+	fmt.Printf("Last updated: %s\n", lastUpdated)
+
+	enrollment := "not enrolled"
+	switch {
+	case report.Enrollment.Expired:
+		enrollment = "credential expired on " + report.Enrollment.ExpiresAt.Format("2006-01-02")
+	case report.Enrollment.Enrolled:
+		enrollment = "enrolled as " + valueOrDefault(report.Enrollment.DeviceID, "unknown device")
+		if report.Enrollment.EnrolledAt != nil {
+			enrollment += " on " + report.Enrollment.EnrolledAt.Format("2006-01-02")
+		}
+	}
+	fmt.Printf("Enrollment:   %s\n", enrollment)
+
+	companion := report.Companion
+	if companion.Reachable {
+		fmt.Printf("Companion:    %s reachable (latency %s)\n", companion.URL, companion.Latency.Round(time.Millisecond))
+	} else {
+		fmt.Printf("Companion:    %s unreachable (%s)\n", companion.URL, companion.Error)
+	}
+
+	fmt.Println("\nComponents:")
+	for _, component := range report.Components {
+		state := "not installed"
+		if component.Installed {
+			state = "installed"
+			if component.Version != "" {
+				state += " (" + component.Version + ")"
+			}
+		}
+		fmt.Printf("    %-12s%s\n", component.Name, state)
+	}
+
+	if len(report.Services) == 0 {
+		return
+	}
+	fmt.Println("\nServices:")
+	for _, service := range report.Services {
+		state := service.State
+		if service.Health != "" {
+			state += ", " + service.Health
+		}
+		fmt.Printf("    %-16s%s\n", service.Name, state)
+	}
+}
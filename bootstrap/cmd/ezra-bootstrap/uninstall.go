@@ -0,0 +1,46 @@
+package main
+
+var uninstallCommand = &command{
+	name:    "uninstall",
+	usage:   "uninstall [OPTIONS]",
+	summary: "Stop services and remove Ezra from this device",
+}
+
+func init() {
+	uninstallCommand.run = runUninstall
+}
+
+// runUninstall handles the uninstall subcommand
+func runUninstall(args []string) {
+	fs := newFlagSet(uninstallCommand)
+	opts := addCommonFlags(fs)
+	purge := fs.Bool("purge", false, "Also remove data, cache and backup directories")
+	wait := fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Uninstaller starting...")
+
+	inst, _ := newInstaller(log, opts)
+	relaunchElevated(log, inst, opts)
+	defer lockInstall(log, inst, *wait)()
+
+	report, err := inst.Uninstall(*purge)
+	for _, name := range report.StoppedServices {
+		log.Infof("Stopped service: %s", name)
+	}
+	for _, path := range report.RemovedUnits {
+		log.Infof("Removed service unit: %s", path)
+	}
+	for _, path := range report.RemovedBinaries {
+		log.Infof("Removed binary: %s", path)
+	}
+	for _, path := range report.PurgedPaths {
+		log.Infof("Purged directory: %s", path)
+	}
+	if err != nil {
+		log.Fatalf("Uninstall failed: %v", err)
+	}
+
+	log.Info("Uninstall completed successfully!")
+}
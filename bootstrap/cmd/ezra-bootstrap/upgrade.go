@@ -0,0 +1,50 @@
+package main
+
+var upgradeCommand = &command{
+	name:    "upgrade",
+	usage:   "upgrade [OPTIONS]",
+	summary: "Upgrade an existing installation, keeping its configuration",
+}
+
+func init() {
+	upgradeCommand.run = runUpgrade
+}
+
+// runUpgrade handles the upgrade subcommand
+func runUpgrade(args []string) {
+	fs := newFlagSet(upgradeCommand)
+	opts := addCommonFlags(fs)
+	tofu := fs.Bool("tofu", false, "Trust the companion signing key on first use without asking")
+	wait := fs.Bool("wait", false, "Wait for another run changing the installation to finish instead of failing")
+	latest := fs.Bool("force-latest", false, "Take the newest release even if its staged rollout has not reached this device")
+	fs.Parse(args)
+
+	log := newLogger(*opts.verbose, *opts.logFormat)
+	log.Info("Ezra Bootstrap Upgrader starting...")
+
+	inst, cfg := newInstaller(log, opts)
+	relaunchElevated(log, inst, opts)
+	defer lockInstall(log, inst, *wait)()
+	inst.SetKeyConfirmation(keyConfirmation(*tofu, loadAnswers(log, opts)))
+	inst.SetForceLatest(*latest)
+	pinned := cfg.PublicKeyPinned
+
+	report, err := inst.Upgrade()
+
+	// A key pinned on first use is kept even if the upgrade failed
+	if cfg.PublicKeyPinned && !pinned {
+		savePinnedKey(log, cfg, *opts.configFile)
+	}
+	if err != nil {
+		fatal(log, err, "Upgrade failed: %v", err)
+	}
+
+	for component, version := range report.Upgraded {
+		log.Infof("Upgraded %s to %s", component, version)
+	}
+	for component, provenance := range report.Provenance {
+		log.Infof("%s: built by %s from %s (%s)", component, provenance.Builder, provenance.Source, provenance.BuildType)
+	}
+
+	log.Info("Upgrade completed successfully!")
+}
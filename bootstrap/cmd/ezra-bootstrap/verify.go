@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/installer"
+)
+
+var verifyCommand = &command{
+	name:    "verify",
+	usage:   "verify [OPTIONS] <file>",
+	summary: "Verify the signature and checksum of a release file",
+}
+
+func init() {
+	verifyCommand.run = runVerify
+}
+
+// runVerify handles the verify subcommand
+func runVerify(args []string) {
+	fs := newFlagSet(verifyCommand)
+	var (
+		configFile = fs.String("config", os.Getenv(configEnv), "Configuration file path (default $EZRA_CONFIG)")
+		publicKey  = fs.String("public-key", "", "Base64 Ed25519 or minisign public key (overrides config)")
+		sigType    = fs.String("signature-type", "", "Signature type: ed25519 or cosign (overrides config)")
+		signature  = fs.String("signature", "", "Signature or cosign bundle (default: read <file>.sig, <file>.minisig, <file>.asc or <file>.bundle)")
+		checksum   = fs.String("checksum", "", "Expected SHA256, SHA512 or BLAKE3 checksum; skips signature verification")
+		algorithm  = fs.String("checksum-algorithm", "", "Checksum algorithm: sha256, sha512 or blake3 (default: inferred)")
+		verbose    = fs.Bool("verbose", false, "Enable verbose logging")
+		logFormat  = fs.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := fs.Arg(0)
+
+	log := newLogger(*verbose, *logFormat)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Config, err), "Failed to load configuration: %v", err)
+	}
+	if *publicKey != "" {
+		cfg.PublicKey = *publicKey
+	}
+	if *sigType != "" {
+		cfg.SignatureType = *sigType
+	}
+	if *algorithm != "" {
+		cfg.ChecksumAlgorithm = *algorithm
+	}
+
+	v := installer.NewVerifier(cfg, log)
+
+	switch {
+	case *checksum != "":
+		err = v.VerifyChecksum(file, *checksum)
+	case *signature != "":
+		err = v.VerifyFile(file, *signature)
+	default:
+		err = v.VerifyRelease(file)
+	}
+
+	if err != nil {
+		fatal(log, failure.Wrap(failure.Verification, err), "Verification failed: %v", err)
+	}
+
+	fmt.Printf("%s: OK\n", file)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+var versionCommand = &command{
+	name:    "version",
+	usage:   "version [OPTIONS]",
+	summary: "Print the version and build of this bootstrap",
+}
+
+func init() {
+	versionCommand.run = runVersion
+}
+
+// versionInfo describes the build of the bootstrap
+type versionInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// buildInfo returns the build metadata of the running bootstrap. A plain
+// go build or go install leaves the version, commit and time unset, so
+// those come from the module and VCS information the toolchain embeds.
+func buildInfo() *versionInfo {
+	info := &versionInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.GitCommit == "" {
+				info.GitCommit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			if setting.Value == "true" && GitCommit == "" && info.GitCommit != "" {
+				info.GitCommit += "-dirty"
+			}
+		}
+	}
+	return info
+}
+
+// runVersion handles the version subcommand
+func runVersion(args []string) {
+	fs := newFlagSet(versionCommand)
+	output := fs.String("output", "text", "Output format: text or json")
+	fs.Parse(args)
+
+	info := buildInfo()
+	switch *output {
+	case "text":
+		fmt.Printf("ezra-bootstrap %s\n", info.Version)
+		fmt.Printf("    Commit:     %s\n", valueOrDefault(info.GitCommit, "unknown"))
+		fmt.Printf("    Built:      %s\n", valueOrDefault(info.BuildTime, "unknown"))
+		fmt.Printf("    Go version: %s\n", info.GoVersion)
+		fmt.Printf("    Platform:   %s\n", info.Platform)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			logger.New(false).Fatalf("Failed to write version: %v", err)
+		}
+	default:
+		logger.New(false).Exitf(failure.ExitUsage, "Unknown output format %q: use text or json", *output)
+	}
+}
@@ -1,10 +1,10 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Config represents the bootstrap configuration
@@ -19,52 +19,619 @@ type Config struct {
 	OfflineMode  bool   `json:"offline_mode"`
 	VerifySigs   bool   `json:"verify_signatures"`
 	PublicKey    string `json:"public_key"`
+
+	// PublicKeyPinned is set when PublicKey was pinned on first use from
+	// the companion; the companion's key is then checked on every run
+	PublicKeyPinned bool `json:"public_key_pinned"`
+
+	// TrustedKeys are signing keys accepted besides PublicKey. Keys are
+	// rotated in the field by a signed document from the companion.
+	TrustedKeys []TrustedKeyConfig `json:"trusted_keys"`
+
+	// DownloadConcurrency is the number of connections used to fetch a
+	// single large component; 1 disables chunked downloads
+	DownloadConcurrency int `json:"download_concurrency"`
+
+	// ParallelDownloads is the number of components downloaded at once
+	ParallelDownloads int `json:"parallel_downloads"`
+
+	// UseBundle downloads all components as a single release bundle
+	// when one is published for this platform
+	UseBundle bool `json:"use_bundle"`
+
+	// Progress selects how download progress is shown: "auto" (default),
+	// "tty", "log" or "json"
+	Progress string `json:"progress"`
+
+	// Mirrors are additional base URLs serving the same releases as
+	// CompanionURL, tried in order when it fails
+	Mirrors []string `json:"mirrors"`
+
+	// MirrorSelection is "ordered" (default) or "latency"
+	MirrorSelection string `json:"mirror_selection"`
+
+	Retry RetryConfig `json:"retry"`
+
+	// ChecksumAlgorithm is "sha256", "sha512" or "blake3". When empty it
+	// is inferred from the checksum file name, then the digest length.
+	ChecksumAlgorithm string `json:"checksum_algorithm"`
+
+	// SignatureType is "ed25519" (default) or "cosign"
+	SignatureType string       `json:"signature_type"`
+	Cosign        CosignConfig `json:"cosign"`
+
+	// PGP holds the keys trusted for OpenPGP .asc signatures, which are
+	// recognised whatever the signature type
+	PGP PGPConfig `json:"pgp"`
+
+	TUF TUFConfig `json:"tuf"`
+
+	// Provenance refuses components without a SLSA provenance
+	// attestation from the expected CI pipeline
+	Provenance ProvenanceConfig `json:"provenance"`
+
+	// SBOM refuses components whose SBOM lists known vulnerabilities
+	SBOM SBOMConfig `json:"sbom"`
+
+	// Hardware refuses installs on undersized devices
+	Hardware HardwareConfig `json:"hardware"`
+
+	// Board tunes single-board computers such as the Raspberry Pi
+	Board BoardConfig `json:"board"`
+
+	// Supervisor keeps the services running: an init system ("systemd",
+	// "openrc", "runit", "sysv", "launchd" or "scm"), "container" or
+	// "direct". Empty or "auto" picks the running init system.
+	Supervisor string `json:"supervisor"`
+
+	// ServiceTemplates replaces the built-in systemd, OpenRC, runit and
+	// SysV service definitions with text/template files, keyed by init
+	// system. Templates see .Name, .Description, .Command, .User,
+	// .WorkingDirectory, .Restart, .RestartSec, .WantedBy and .Type, and
+	// the quote, command and systemdCommand functions that quote a word or
+	// a command line for the shell or for ExecStart.
+	ServiceTemplates map[string]string `json:"service_templates"`
+
+	// SystemdNotify makes systemd units Type=notify, for services that
+	// send READY=1 through sd_notify, so that starting them waits until
+	// they are ready
+	SystemdNotify bool `json:"systemd_notify"`
+
+	// ConfigTemplates replaces the built-in companion and executor
+	// configuration files with text/template files, keyed by component.
+	// Templates see .DeviceID, .CompanionURL, .CompanionPort,
+	// .ExecutorVariant, .DataDir, .CacheDir, .BackupDir and .LogLevel, and
+	// the json function that encodes a value. They must render JSON.
+	ConfigTemplates map[string]string `json:"config_templates"`
+
+	// CompanionPort is the port the local companion listens on. Zero uses
+	// the port of a loopback CompanionURL, or 3000. When it is taken the
+	// next CompanionPortSearch ports are tried; zero fails instead.
+	CompanionPort       int `json:"companion_port"`
+	CompanionPortSearch int `json:"companion_port_search"`
+
+	// InstallMode chooses where binaries go when InstallPath is read-only,
+	// as on image-based systems: "system" always uses InstallPath, "user"
+	// uses ~/.local/bin with per-user services and nothing that needs
+	// root, and "sysext" a systemd system extension. Empty or "auto" picks
+	// sysext when it is available to root, else user.
+	InstallMode string `json:"install_mode"`
+
+	// Elevation is how steps that need root are run when the bootstrap is
+	// started as an ordinary user: "sudo" or "pkexec" on Linux and macOS,
+	// "none" to fail instead. Empty or "auto" uses sudo, else pkexec. On
+	// Windows the bootstrap is relaunched through a UAC prompt.
+	Elevation string `json:"elevation"`
+
+	// ServiceUser is the account the services run as. It is created as a
+	// system account with a group of the same name if it does not exist,
+	// and owns DataPath and CachePath.
+	ServiceUser string `json:"service_user"`
+
+	// OnConflict decides what happens to an existing file that differs
+	// from the one being installed and was not written by Ezra, or was
+	// changed since: "overwrite" (the default) replaces it after backing
+	// it up under BackupPath, "keep" leaves it and "prompt" asks.
+	OnConflict string `json:"on_conflict"`
+
+	// Hooks runs operator scripts before and after the install and the
+	// start of the services
+	Hooks HooksConfig `json:"hooks"`
+
+	// HealthCheck waits for the services to become ready once started.
+	// An install whose services do not is rolled back.
+	HealthCheck HealthCheckConfig `json:"health_check"`
+
+	// Report describes every install, successful or not, in a JSON
+	// report for provisioning dashboards
+	Report ReportConfig `json:"report"`
+
+	// LogFile keeps the log of every run in a file as well, so that
+	// failed unattended runs leave it behind
+	LogFile LogFileConfig `json:"log_file"`
+
+	// LogSink copies the log to a system log operators monitor: auto,
+	// journald, syslog, eventlog or none. Auto, the default, picks the
+	// Event Log on Windows, the journal on systemd systems and syslog
+	// elsewhere.
+	LogSink string `json:"log_sink"`
+
+	// LogShipping streams the log to the companion, so that operators
+	// can follow installs across the fleet
+	LogShipping LogShippingConfig `json:"log_shipping"`
+
+	// TPM keeps the device identity in a TPM 2.0 and attests the device
+	// when it enrolls with the companion
+	TPM TPMConfig `json:"tpm"`
+
+	// Enrollment registers the device with the companion, which issues
+	// the credential the agent authenticates with
+	Enrollment EnrollmentConfig `json:"enrollment"`
+
+	// SecretStore keeps the device key, its credential and the secrets
+	// settings refer to as "secret:<name>" out of plaintext files
+	SecretStore SecretStoreConfig `json:"secret_store"`
+
+	// OCI configures pulling components from a registry when
+	// CompanionURL is an oci:// URL
+	OCI OCIConfig `json:"oci"`
+
+	// Channel is the release channel to follow: "stable" (default),
+	// "beta", "nightly" or a channel named in the release index
+	Channel string       `json:"channel"`
+	GitHub  GitHubConfig `json:"github"`
+
+	// DeviceTags designate the device for staged rollouts: a release
+	// rolled out to tags such as "canary" is only offered to devices
+	// with one of them
+	DeviceTags []string `json:"device_tags"`
+
+	// ProxyURL is an http://, https:// or socks5:// proxy for all
+	// requests. When empty the environment and system settings are used.
+	ProxyURL string `json:"proxy_url"`
+	NoProxy  string `json:"no_proxy"`
+
+	// ConnectivityCheckURL must answer 204 No Content. Any other answer
+	// means a captive portal. Empty uses a public check URL.
+	ConnectivityCheckURL string `json:"connectivity_check_url"`
+
+	// CACert adds trusted certificate authorities, and ClientCert and
+	// ClientKey enable mutual TLS with the companion
+	CACert             string `json:"ca_cert"`
+	ClientCert         string `json:"client_cert"`
+	ClientKey          string `json:"client_key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	// MaxDownloadRate caps download bandwidth, e.g. "2MiB/s"
+	MaxDownloadRate string `json:"max_download_rate"`
+	// DownloadWindow restricts downloads to a daily window in local
+	// time, e.g. "01:00-05:00"
+	DownloadWindow string `json:"download_window"`
+
+	// NoCache disables the download cache under CachePath.
+	// CacheMaxSize (e.g. "2GiB") and CacheMaxAgeDays bound its size; zero
+	// values leave it unbounded.
+	NoCache         bool   `json:"no_cache"`
+	CacheMaxSize    string `json:"cache_max_size"`
+	CacheMaxAgeDays int    `json:"cache_max_age_days"`
+
+	// Components pins component versions, either exactly ("1.4.2") or
+	// with a constraint (">=2.0 <3.0")
+	Components map[string]string `json:"components"`
+
+	// InstallComponents lists the components to install, out of
+	// "companion", "agent" and "executor"; empty installs all of them.
+	// Devices that use a remote companion leave it out. Components of an
+	// install manifest with other names are always installed.
+	InstallComponents []string `json:"install_components"`
+
+	// ExecutorVariant is the executor build to install: "cpu", "cuda" or
+	// "rocm". Empty or "auto" picks one from the detected GPUs.
+	ExecutorVariant string `json:"executor_variant"`
+
+	// Features are feature flags for the device, passed on to the agent
+	Features map[string]bool `json:"features"`
+
+	// RemoteConfig pulls the configuration from the companion, which
+	// the local configuration overrides
+	RemoteConfig RemoteConfig `json:"remote_config"`
+
+	// AutoUpdate configures the update daemon, which applies new
+	// component versions as the companion publishes them
+	AutoUpdate AutoUpdateConfig `json:"auto_update"`
+
+	// Slots configures installing binaries into a slot per version, so
+	// that an upgrade can switch back to the previous one
+	Slots SlotsConfig `json:"slots"`
+
+	// local are the configuration files as read, in order, which
+	// override the configuration pulled from the companion
+	local []*layer
+
+	// origins are where the settings were set, by name, see SetOrigin
+	origins map[string]string
+
+	// secretRefs are the settings read from the secret store
+	secretRefs []secretRef
 }
 
-// DefaultConfig returns a default configuration
+// GitHubConfig configures downloading from github://owner/repo
+type GitHubConfig struct {
+	Token  string `json:"token"`
+	APIURL string `json:"api_url"`
+	// ChannelTags maps channel names to the tag patterns selecting their
+	// releases
+	ChannelTags map[string]string `json:"channel_tags"`
+}
+
+// OCIConfig configures the OCI registry distribution backend
+type OCIConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Tag is pulled for every component unless it is pinned in Digests
+	Tag       string            `json:"tag"`
+	Digests   map[string]string `json:"digests"`
+	PlainHTTP bool              `json:"plain_http"`
+}
+
+// ProvenanceConfig is the policy SLSA provenance attestations must
+// satisfy. Empty lists accept any value.
+type ProvenanceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Builders are trusted builder IDs; an ID without an @ref suffix
+	// accepts every version of the builder
+	Builders    []string `json:"builders"`
+	SourceRepos []string `json:"source_repos"`
+	BuildTypes  []string `json:"build_types"`
+}
+
+// SBOMConfig configures the vulnerability check of component SBOMs
+type SBOMConfig struct {
+	Enabled bool `json:"enabled"`
+	// AdvisoryDB is the offline advisory database; advisories.json on
+	// the offline media is used when empty
+	AdvisoryDB string `json:"advisory_db"`
+	// FailSeverity is the lowest severity that refuses an install:
+	// "low", "medium", "high" or "critical" (default)
+	FailSeverity string `json:"fail_severity"`
+}
+
+// HardwareConfig sets the minimum hardware an install needs. Sizes are
+// written like "512MiB" or "2GiB"; empty values are not checked.
+type HardwareConfig struct {
+	MinCores  int    `json:"min_cores"`
+	MinMemory string `json:"min_memory"`
+	// MinFreeDisk is the free space needed on each file system holding
+	// InstallPath, DataPath or CachePath
+	MinFreeDisk string `json:"min_free_disk"`
+	// RefuseMedia lists storage media DataPath must not be on, such as
+	// "sd" for removable cards that wear out quickly
+	RefuseMedia []string `json:"refuse_media"`
+}
+
+// BoardConfig holds board-specific settings
+type BoardConfig struct {
+	// GPUMemoryMB sets the Raspberry Pi GPU memory split in config.txt;
+	// 0 leaves it alone. It takes effect after a reboot.
+	GPUMemoryMB int `json:"gpu_memory_mb"`
+	// ThermalLimitC is the SoC temperature at which the agent stops
+	// taking work; 0 uses the board's default
+	ThermalLimitC int `json:"thermal_limit_c"`
+	// Arch overrides the architecture of downloaded components, e.g.
+	// "armv6"; empty uses the detected one
+	Arch string `json:"arch"`
+}
+
+// HooksConfig configures the scripts run at the "pre-install",
+// "post-install", "pre-start" and "post-start" hooks. Scripts see the
+// install in EZRA_* environment variables.
+type HooksConfig struct {
+	// Dir holds executable scripts named after their hook, and <hook>.d
+	// directories of scripts run in name order. Empty uses
+	// /etc/ezra/hooks.d, %ProgramData%\Ezra\hooks.d on Windows, or
+	// DataPath/hooks.d for user installs.
+	Dir string `json:"dir"`
+	// Scripts are run after those found in Dir
+	Scripts []HookScriptConfig `json:"scripts"`
+	// Policies set the timeout and failure policy of the scripts of each
+	// hook, by hook
+	Policies map[string]HookPolicyConfig `json:"policies"`
+}
+
+// HookPolicyConfig sets how long a hook script may run and what its
+// failure does
+type HookPolicyConfig struct {
+	// TimeoutSec kills a script that runs longer; zero allows 300
+	TimeoutSec int `json:"timeout_sec"`
+	// OnFailure is "abort" (default) to fail the install, which is rolled
+	// back, or "continue" to log the failure
+	OnFailure string `json:"on_failure"`
+}
+
+// HookScriptConfig is a hook script listed in the configuration. Its own
+// policy fields override those of its hook.
+type HookScriptConfig struct {
+	Hook string   `json:"hook"`
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	HookPolicyConfig
+}
+
+// HealthCheckConfig configures the readiness check of the services: the
+// companion's /health endpoint and the agent's status socket must report
+// "ok" or "ready" and the installed version
+type HealthCheckConfig struct {
+	// Disabled skips the check
+	Disabled bool `json:"disabled"`
+	// TimeoutSec is how long each service has to become ready; zero
+	// allows 60
+	TimeoutSec int `json:"timeout_sec"`
+	// AgentSocket is the agent's status socket; empty uses
+	// DataPath/agent.sock
+	AgentSocket string `json:"agent_socket"`
+}
+
+// ReportConfig configures the install report
+type ReportConfig struct {
+	// Path is where the report is written; empty uses
+	// DataPath/install-report.json
+	Path string `json:"path"`
+	// Post also sends the report to the companion
+	Post bool `json:"post"`
+}
+
+// LogFileConfig configures the log file. It is rotated when it grows too
+// large, and rotated files are removed when there are too many or they
+// are too old.
+type LogFileConfig struct {
+	// Disabled logs to the console only
+	Disabled bool `json:"disabled"`
+	// Path is the log file; empty uses DataPath/logs/bootstrap.log
+	Path string `json:"path"`
+	// MaxSizeMB is the size the file is rotated at; zero allows 10
+	MaxSizeMB int `json:"max_size_mb"`
+	// MaxBackups is how many rotated files are kept; zero keeps 5
+	MaxBackups int `json:"max_backups"`
+	// MaxAgeDays is how long rotated files are kept; zero keeps them
+	// for 30 days
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// LogShippingConfig configures sending the log to the companion. Entries
+// are sent in batches tagged with the device and the session, the run of
+// the bootstrap. While the companion cannot be reached they are kept,
+// and those still unsent at the end of a run are sent by the next.
+type LogShippingConfig struct {
+	Enabled bool `json:"enabled"`
+	// BatchSize is how many entries are sent at most at once; zero sends
+	// 100
+	BatchSize int `json:"batch_size"`
+	// FlushIntervalSec is how often entries are sent; zero sends every 5
+	// seconds
+	FlushIntervalSec int `json:"flush_interval_sec"`
+	// BufferSize is how many unsent entries are kept; zero keeps 10000.
+	// Beyond it the oldest are dropped.
+	BufferSize int `json:"buffer_size"`
+}
+
+// TPMConfig configures the TPM-backed device identity
+type TPMConfig struct {
+	// Mode is "off" (default), "auto" to use a TPM when the device has
+	// one, or "required"
+	Mode string `json:"mode"`
+	// Device is the TPM device; /dev/tpmrm0 or /dev/tpm0 when empty
+	Device string `json:"device"`
+	// AKHandle and IDIndex locate the attestation key and the device ID
+	// in the TPM; zero selects the defaults
+	AKHandle uint32 `json:"ak_handle"`
+	IDIndex  uint32 `json:"id_index"`
+	// PCRs are the SHA256 PCRs quoted at enrollment, 0-7 when empty
+	PCRs []uint `json:"pcrs"`
+}
+
+// EnrollmentConfig configures the enrollment of the device with the
+// companion. TPM-backed devices are always enrolled.
+type EnrollmentConfig struct {
+	// Mode is "off" (default), "auto" to enroll when the companion offers
+	// enrollment, or "required"
+	Mode string `json:"mode"`
+	// Token is the one-time enrollment token issued by the companion
+	// operator, when the companion asks for one
+	Token string `json:"token"`
+}
+
+// SecretStoreConfig configures where secrets are kept. A string setting
+// written "secret:<name>", e.g. the enrollment token, is read from the
+// store, where "ezra-bootstrap secret set <name>" puts it.
+type SecretStoreConfig struct {
+	// Backend is "file" (default) for files under DataPath only their
+	// owner can read, "keyring" for the keyring of the system: the
+	// Secret Service on Linux, the Keychain on macOS and DPAPI on
+	// Windows, or "encrypted" for files encrypted with a key bound to
+	// the machine
+	Backend string `json:"backend"`
+}
+
+// SlotsConfig configures binary slots. Each version of a component is
+// installed into its own directory under releases/, and a current link
+// is switched to it, and back to the previous slot when the upgraded
+// services do not become healthy. The binaries in InstallPath link to
+// current.
+type SlotsConfig struct {
+	// Enabled installs binaries into slots. Not supported on Windows.
+	Enabled bool `json:"enabled"`
+	// Path is where the slots are kept; empty uses lib/ezra next to
+	// InstallPath, e.g. /usr/local/lib/ezra
+	Path string `json:"path"`
+	// Keep is how many previous slots of each component to keep besides
+	// the current one; zero keeps one, the slot to roll back to. Older
+	// slots are removed after an upgrade.
+	Keep int `json:"keep"`
+}
+
+// AutoUpdateConfig configures the update daemon. New versions are
+// downloaded when they are found, subject to DownloadWindow, and applied
+// in the maintenance window.
+type AutoUpdateConfig struct {
+	// IntervalMinutes is how often to check for updates; zero checks
+	// every 360 minutes
+	IntervalMinutes int `json:"interval_minutes"`
+	// MaintenanceWindow restricts applying updates, which restarts the
+	// services, to a daily window in local time, e.g. "02:00-04:00".
+	// Empty applies them as soon as they are downloaded.
+	MaintenanceWindow string `json:"maintenance_window"`
+	// SelfUpdate updates the bootstrap from the release before the
+	// components, so that the new bootstrap applies them
+	SelfUpdate bool `json:"self_update"`
+}
+
+// TUFConfig enables distribution through The Update Framework
+type TUFConfig struct {
+	Enabled bool `json:"enabled"`
+	// Root is the initial trusted root.json, used until a newer root has
+	// been persisted under DataPath
+	Root string `json:"root"`
+}
+
+// CosignConfig configures verification of cosign keyless signatures
+type CosignConfig struct {
+	FulcioRoots    string `json:"fulcio_roots"`
+	RekorPublicKey string `json:"rekor_public_key"`
+	Identity       string `json:"identity"`
+	IdentityRegexp string `json:"identity_regexp"`
+	Issuer         string `json:"issuer"`
+}
+
+// TrustedKeyConfig is a signing key and the window in which it is valid.
+// NotBefore and NotAfter are RFC 3339 times and may be omitted.
+type TrustedKeyConfig struct {
+	ID        string    `json:"id"`
+	PublicKey string    `json:"public_key"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// PGPConfig configures verification of OpenPGP detached signatures
+type PGPConfig struct {
+	// Keys are ASCII armored public keys
+	Keys []string `json:"keys"`
+	// Keyring is a keyring file exported with gpg --export
+	Keyring string `json:"keyring"`
+}
+
+// RetryConfig controls how failed downloads are retried
+type RetryConfig struct {
+	MaxAttempts     int     `json:"max_attempts"`
+	BaseDelayMs     int     `json:"base_delay_ms"`
+	MaxDelayMs      int     `json:"max_delay_ms"`
+	Jitter          float64 `json:"jitter"`
+	RetryableStatus []int   `json:"retryable_status"`
+}
+
+// DefaultConfig returns a default configuration, with the directories
+// of the platform
 func DefaultConfig() *Config {
-	homeDir, _ := os.UserHomeDir()
+	paths := defaultPaths()
 	
 	return &Config{
 		DeviceID:     "",
 		CompanionURL: "http://localhost:3000",
-		InstallPath:  "/usr/local/bin",
-		DataPath:     filepath.Join(homeDir, ".ezra"),
-		CachePath:    filepath.Join(homeDir, ".ezra", "cache"),
-		BackupPath:   filepath.Join(homeDir, ".ezra", "backups"),
+		InstallPath:  paths.install,
+		DataPath:     paths.data,
+		CachePath:    paths.cache,
+		BackupPath:   paths.backup,
 		LogLevel:     "info",
 		OfflineMode:  false,
 		VerifySigs:   true,
 		PublicKey:    "",
+
+		DownloadConcurrency: 4,
+		ParallelDownloads:   3,
+		Progress:            "auto",
+		MirrorSelection:     "ordered",
+		SignatureType:       "ed25519",
+		Channel:             "stable",
+		CacheMaxSize:        "2GiB",
+		CacheMaxAgeDays:     30,
+		CompanionPortSearch: 10,
+		ServiceUser:         "ezra",
+		SBOM: SBOMConfig{
+			FailSeverity: "critical",
+		},
+		Retry: RetryConfig{
+			MaxAttempts:     4,
+			BaseDelayMs:     1000,
+			MaxDelayMs:      30000,
+			Jitter:          0.5,
+			RetryableStatus: []int{408, 429, 500, 502, 503, 504},
+		},
 	}
 }
 
-// Load loads configuration from file or creates default
+// Load loads the configuration and applies the EZRA_ environment
+// variables over it. Settings are taken, from lowest to highest
+// precedence, from the defaults, the companion (see MergeRemote), the
+// system configuration file, the user's configuration file, configFile
+// if given, the environment and the flags. The system and user files are
+// optional; configFile must exist. Settings written "secret:<name>" are
+// read from the secret store.
 func Load(configFile string) (*Config, error) {
+	return load(configFile, true)
+}
+
+// LoadUnresolved loads the configuration like Load, but leaves the
+// settings that refer to the secret store as they are, for managing the
+// store
+func LoadUnresolved(configFile string) (*Config, error) {
+	return load(configFile, false)
+}
+
+func load(configFile string, resolveSecrets bool) (*Config, error) {
 	cfg := DefaultConfig()
 	
-	if configFile != "" {
-		data, err := os.ReadFile(configFile)
+	files := []string{SystemConfigFile(), UserConfigFile()}
+	for n, path := range append(files, configFile) {
+		if path == "" {
+			continue
+		}
+		l, err := readLayer(path, n < len(files))
 		if err != nil {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
+			return nil, err
 		}
-		
-		if err := json.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		if l == nil {
+			continue
+		}
+		if err := cfg.applyLayer(l); err != nil {
+			return nil, err
+		}
+		cfg.local = append(cfg.local, l)
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if resolveSecrets {
+		if err := cfg.resolveSecrets(); err != nil {
+			return nil, err
+		}
+		if err := cfg.checkCosign(); err != nil {
+			return nil, err
 		}
 	}
 	
-	// Generate device ID if not provided
+	// Keep the device ID of earlier runs if none is configured
 	if cfg.DeviceID == "" {
-		cfg.DeviceID = generateDeviceID()
+		var origin string
+		cfg.DeviceID, origin = cfg.defaultDeviceID()
+		cfg.SetOrigin("device_id", origin)
 	}
 	
 	return cfg, nil
 }
 
-// Save saves configuration to file
+// Save saves configuration to file. Secrets read from the secret store
+// are saved as the references they were read by.
 func (c *Config) Save(configFile string) error {
-	data, err := json.MarshalIndent(c, "", "  ")
+	data, err := c.marshalSecretRefs()
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -76,8 +643,10 @@ func (c *Config) Save(configFile string) error {
 	return nil
 }
 
-// generateDeviceID generates a unique device identifier
-func generateDeviceID() string {
-	hostname, _ := os.Hostname()
-	return fmt.Sprintf("ezra_%s_%d", hostname, os.Getpid())
+// LogFilePath returns where the log file is kept
+func (c *Config) LogFilePath() string {
+	if c.LogFile.Path != "" {
+		return c.LogFile.Path
+	}
+	return filepath.Join(c.DataPath, "logs", "bootstrap.log")
 }
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// DeviceIDFile is the file under DataPath the device ID is kept in, so
+// that it stays the same across runs and changes of the machine
+const DeviceIDFile = "device-id"
+
+// OriginMachine is the origin of a device ID derived from the machine
+// identifier
+const OriginMachine = "machine"
+
+// deviceIDSalt keys the hash of the machine identifier, so that the
+// device ID neither reveals it nor matches what other software derives
+// from it
+const deviceIDSalt = "ezra-bootstrap device ID"
+
+// deviceIDPath returns where the device ID is kept
+func (c *Config) deviceIDPath() string {
+	return filepath.Join(c.DataPath, DeviceIDFile)
+}
+
+// defaultDeviceID returns the device ID of a configuration that sets
+// none, and its origin: the one kept under DataPath, else one derived
+// from the machine identifier, else a random one
+func (c *Config) defaultDeviceID() (string, string) {
+	if data, err := os.ReadFile(c.deviceIDPath()); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, c.deviceIDPath()
+		}
+	}
+	if machine, err := detector.MachineID(); err == nil {
+		mac := hmac.New(sha256.New, []byte(deviceIDSalt))
+		mac.Write([]byte(machine))
+		return "ezra_" + hex.EncodeToString(mac.Sum(nil)[:16]), OriginMachine
+	}
+	return randomDeviceID(), OriginGenerated
+}
+
+// randomDeviceID returns a new random device ID
+func randomDeviceID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "ezra_" + hex.EncodeToString(id)
+}
+
+// SaveDeviceID keeps the device ID under DataPath, unless it is already
+// there
+func (c *Config) SaveDeviceID() error {
+	path := c.deviceIDPath()
+	if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) == c.DeviceID {
+		return nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := os.MkdirAll(c.DataPath, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", c.DataPath, err)
+	}
+	if err := os.WriteFile(path, []byte(c.DeviceID+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// RegenerateDeviceID replaces the device ID with a new random one and
+// keeps it under DataPath, for devices cloned from one image that share
+// their machine identifier. A device ID set in a configuration file, the
+// environment or by the companion is left to be changed there.
+func (c *Config) RegenerateDeviceID() error {
+	switch origin := c.Origin("device_id"); origin {
+	case OriginMachine, OriginGenerated, c.deviceIDPath():
+	default:
+		return fmt.Errorf("device_id is set by %s: change it there", origin)
+	}
+	previous := c.DeviceID
+	c.DeviceID = randomDeviceID()
+	if err := c.SaveDeviceID(); err != nil {
+		c.DeviceID = previous
+		return err
+	}
+	c.SetOrigin("device_id", c.deviceIDPath())
+	return nil
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings. A setting's variable is its path in the configuration file in
+// upper case, e.g. EZRA_COMPANION_URL for companion_url and
+// EZRA_LOG_FILE_MAX_SIZE_MB for max_size_mb in log_file.
+const EnvPrefix = "EZRA_"
+
+// envAliases are shorter names for common settings, by the name derived
+// from the setting
+var envAliases = map[string]string{
+	"EZRA_OFFLINE_MODE": "EZRA_OFFLINE",
+}
+
+// textUnmarshalerType is implemented by settings parsed from text, like
+// times
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// applyEnv overrides settings with the EZRA_ environment variables that
+// are set. Lists are comma-separated, and maps and lists of objects are
+// given as JSON.
+func (c *Config) applyEnv() error {
+	return c.applyEnvStruct(reflect.ValueOf(c).Elem(), EnvPrefix, "")
+}
+
+// applyEnvStruct overrides the fields of a settings struct. parent is the
+// path of a nested struct, like "log_file.", and empty at the top.
+func (c *Config) applyEnvStruct(v reflect.Value, prefix, parent string) error {
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		path := parent + key
+		value := v.Field(n)
+
+		if value.Kind() == reflect.Struct && !reflect.PointerTo(value.Type()).Implements(textUnmarshalerType) {
+			if err := c.applyEnvStruct(value, name+"_", path+"."); err != nil {
+				return err
+			}
+			continue
+		}
+
+		setting, ok := os.LookupEnv(name)
+		if alias := envAliases[name]; !ok && alias != "" {
+			name = alias
+			setting, ok = os.LookupEnv(alias)
+		}
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(value, setting); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		c.SetOrigin(path, "$"+name)
+	}
+	return nil
+}
+
+// setEnvValue parses an environment variable into a setting
+func setEnvValue(v reflect.Value, setting string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(setting))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(setting)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(setting)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(setting, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(setting, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(setting, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct || strings.HasPrefix(strings.TrimSpace(setting), "[") {
+			return json.Unmarshal([]byte(setting), v.Addr().Interface())
+		}
+		var items []string
+		for _, item := range strings.Split(setting, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		list := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for n, item := range items {
+			if err := setEnvValue(list.Index(n), item); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	default:
+		return json.Unmarshal([]byte(setting), v.Addr().Interface())
+	}
+	return nil
+}
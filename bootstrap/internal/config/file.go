@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// starterSettings are the settings the starter file lists, with their
+// comments. Their values are the defaults of the platform. Paths are
+// commented out, so that they still follow the platform and user-mode
+// installs.
+var starterSettings = []struct {
+	key     string
+	comment string
+}{
+	{"companion_url", "URL of the companion that serves releases and manages the device"},
+	{"channel", `Release channel to follow: "stable", "beta" or "nightly"`},
+	{"install_path", "Where binaries are installed"},
+	{"data_path", "Where configuration, state and logs are kept"},
+	{"cache_path", "Where downloads are cached"},
+	{"log_level", `"debug", "info", "warn" or "error"`},
+	{"offline_mode", "Install from offline media instead of the companion"},
+	{"verify_signatures", "Check the signatures of releases; leave on outside development"},
+	{"public_key", "Base64 Ed25519 or minisign key releases are signed with. Empty\n// pins the companion's key on first use."},
+	{"enrollment", `Enroll the device with the companion: mode "off", "auto" or "required".
+// Keep the token out of this file with "secret:<name>", see the secret
+// command.`},
+	{"secret_store", `Where the device key and secrets are kept: "file", "keyring" or
+// "encrypted"`},
+	{"remote_config", "Pull the rest of the configuration from the companion"},
+	{"auto_update", `Check for updates every interval_minutes and apply them in the
+// maintenance window, e.g. "02:00-04:00"`},
+}
+
+// StarterFile returns a configuration file to start from: the common
+// settings at their defaults, each explained in a comment. Every other
+// setting can be added; ezra-bootstrap config show lists them.
+func StarterFile() ([]byte, error) {
+	data, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	var defaults map[string]json.RawMessage
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Ezra bootstrap configuration. Comments are allowed; settings not\n")
+	b.WriteString("// listed keep their defaults, see ezra-bootstrap config show.\n{\n")
+	for n, setting := range starterSettings {
+		var value bytes.Buffer
+		if err := json.Indent(&value, defaults[setting.key], "  ", "  "); err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "  // %s\n", strings.ReplaceAll(setting.comment, "\n", "\n  "))
+		if strings.HasSuffix(setting.key, "_path") {
+			b.WriteString("  // ")
+		} else {
+			b.WriteString("  ")
+		}
+		fmt.Fprintf(&b, "%q: %s", setting.key, value.Bytes())
+		if n < len(starterSettings)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
+
+// stripComments removes the // and /* */ comments of a configuration
+// file, outside strings, so that it parses as JSON
+func stripComments(data []byte) []byte {
+	if !bytes.Contains(data, []byte("//")) && !bytes.Contains(data, []byte("/*")) {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	inString := false
+	for n := 0; n < len(data); n++ {
+		c := data[n]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && n+1 < len(data) {
+				n++
+				out = append(out, data[n])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && n+1 < len(data) && data[n+1] == '/':
+			for n < len(data) && data[n] != '\n' {
+				n++
+			}
+			if n < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && n+1 < len(data) && data[n+1] == '*':
+			end := bytes.Index(data[n+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			n += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Get returns the effective value of a setting, named by its dotted path
+// like "log_file.max_size_mb", as JSON. Secrets are redacted as by
+// Redacted.
+func (c *Config) Get(setting string) (json.RawMessage, error) {
+	data, err := c.Redacted()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	for _, key := range strings.Split(setting, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown setting %q", setting)
+			}
+			value = item
+		case []interface{}:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(v) {
+				return nil, fmt.Errorf("unknown setting %q", setting)
+			}
+			value = v[n]
+		default:
+			return nil, fmt.Errorf("unknown setting %q", setting)
+		}
+	}
+	return json.MarshalIndent(value, "", "  ")
+}
+
+// SetInFile sets a setting in a configuration file, creating the file if
+// there is none. The value is checked against the type of the setting
+// and written like an EZRA_ variable: lists comma-separated, and maps
+// and objects as JSON. The rest of the file, comments included, is kept.
+func SetInFile(path, setting, value string) error {
+	t, err := settingType(setting)
+	if err != nil {
+		return err
+	}
+	parsed := reflect.New(t).Elem()
+	if err := setEnvValue(parsed, value); err != nil {
+		return fmt.Errorf("invalid %s: %w", setting, err)
+	}
+	encoded, err := json.Marshal(parsed.Interface())
+	if err != nil {
+		return err
+	}
+	return updateFile(path, map[string]json.RawMessage{setting: encoded}, 0644)
+}
+
+// UpdateFile sets settings, named by their dotted paths, in a
+// configuration file and keeps the rest of it, comments included, as it
+// is. Unlike Save it writes nothing but these settings, so what the
+// environment, other files or the companion set stays out of the file. A
+// new file is readable by its owner only.
+func UpdateFile(path string, settings map[string]interface{}) error {
+	encoded := make(map[string]json.RawMessage, len(settings))
+	for setting, value := range settings {
+		if _, err := settingType(setting); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", setting, err)
+		}
+		encoded[setting] = data
+	}
+	return updateFile(path, encoded, 0600)
+}
+
+// updateFile sets encoded settings in a configuration file, creating it
+// with mode if there is none
+func updateFile(path string, settings map[string]json.RawMessage, mode os.FileMode) error {
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for setting := range settings {
+		names = append(names, setting)
+	}
+	sort.Strings(names)
+	for _, setting := range names {
+		if data, err = setInText(data, strings.Split(setting, "."), settings[setting]); err != nil {
+			return fmt.Errorf("failed to set %s in config file %s: %w", setting, path, err)
+		}
+	}
+	if !json.Valid(stripComments(data)) {
+		return fmt.Errorf("failed to set settings in config file %s: it is not valid JSON", path)
+	}
+
+	// The mode only applies to a new file; an existing one keeps its own
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// settingType returns the type of a setting, named by its dotted path.
+// Keys of maps, like the names of features, are part of the path.
+func settingType(setting string) (reflect.Type, error) {
+	t := reflect.TypeOf(Config{})
+	for _, key := range strings.Split(setting, ".") {
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("unknown setting %q", setting)
+		case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+			t = t.Elem()
+		case t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType):
+			field, ok := fieldByKey(t, key)
+			if !ok {
+				return nil, fmt.Errorf("unknown setting %q", setting)
+			}
+			t = field.Type
+		default:
+			return nil, fmt.Errorf("unknown setting %q", setting)
+		}
+	}
+	return t, nil
+}
+
+// fieldByKey finds the field of a settings struct by its key in the
+// configuration file
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		if field.IsExported() && strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
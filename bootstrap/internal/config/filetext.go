@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fileIndent is the indentation of settings added to a configuration
+// file, per level of nesting
+const fileIndent = "  "
+
+// errNotObject is returned when a configuration file, or the setting a
+// nested setting is added to, is not a JSON object
+var errNotObject = errors.New("not a JSON object")
+
+// setInText sets the value at a path of keys in the text of a
+// configuration file, leaving everything else, comments included, as it
+// is. A setting that is not there is added at the end of its object, and
+// missing objects on the path are created.
+func setInText(data []byte, keys []string, value json.RawMessage) ([]byte, error) {
+	s := &textScanner{data: data}
+	s.skip()
+	if s.pos == len(data) {
+		// An empty file gets an object holding just the setting
+		var b bytes.Buffer
+		b.Write(data)
+		b.WriteString("{\n" + fileIndent)
+		b.Write(memberText(keys, value, 1))
+		b.WriteString("\n}\n")
+		return b.Bytes(), nil
+	}
+	return s.setIn(keys, value, 1)
+}
+
+// textScanner walks the text of a configuration file: JSON with // and
+// /* */ comments
+type textScanner struct {
+	data []byte
+	pos  int
+}
+
+// setIn sets the value at a path of keys in the object at the current
+// position, whose members are at depth levels of nesting
+func (s *textScanner) setIn(keys []string, value json.RawMessage, depth int) ([]byte, error) {
+	if s.pos >= len(s.data) || s.data[s.pos] != '{' {
+		return nil, errNotObject
+	}
+	open := s.pos
+	s.pos++
+
+	// end of the value of the last member, where a new one is added
+	last := -1
+	for {
+		s.skip()
+		if s.pos >= len(s.data) {
+			return nil, errors.New("unexpected end of file")
+		}
+		switch s.data[s.pos] {
+		case '}':
+			return s.insertMember(open, last, keys, value, depth), nil
+		case ',':
+			s.pos++
+			continue
+		}
+
+		key, err := s.readString()
+		if err != nil {
+			return nil, err
+		}
+		s.skip()
+		if s.pos >= len(s.data) || s.data[s.pos] != ':' {
+			return nil, fmt.Errorf("expected : after %q", key)
+		}
+		s.pos++
+		s.skip()
+
+		start := s.pos
+		if key == keys[0] {
+			if len(keys) > 1 && s.pos < len(s.data) && s.data[s.pos] == '{' {
+				return s.setIn(keys[1:], value, depth+1)
+			}
+			// The value, or what was not an object on the path, is
+			// replaced
+			if err := s.skipValue(); err != nil {
+				return nil, err
+			}
+			return s.replace(start, s.pos, indentText(nestedValue(keys[1:], value), depth)), nil
+		}
+		if err := s.skipValue(); err != nil {
+			return nil, err
+		}
+		last = s.pos
+	}
+}
+
+// insertMember adds a member after the last one of the object opened at
+// open, or as its first
+func (s *textScanner) insertMember(open, last int, keys []string, value json.RawMessage, depth int) []byte {
+	indent := strings.Repeat(fileIndent, depth)
+	member := memberText(keys, value, depth)
+	if last < 0 {
+		text := append([]byte("\n"+indent), member...)
+		text = append(text, "\n"+strings.Repeat(fileIndent, depth-1)...)
+		// Whatever the empty object held, such as a newline, goes
+		return s.replace(open+1, s.pos, text)
+	}
+	return s.replace(last, last, append([]byte(",\n"+indent), member...))
+}
+
+// replace returns the text with data[start:end] replaced by text
+func (s *textScanner) replace(start, end int, text []byte) []byte {
+	out := make([]byte, 0, len(s.data)+len(text))
+	out = append(out, s.data[:start]...)
+	out = append(out, text...)
+	return append(out, s.data[end:]...)
+}
+
+// skip moves past whitespace and comments
+func (s *textScanner) skip() {
+	for s.pos < len(s.data) {
+		switch {
+		case s.data[s.pos] == ' ' || s.data[s.pos] == '\t' || s.data[s.pos] == '\n' || s.data[s.pos] == '\r':
+			s.pos++
+		case bytes.HasPrefix(s.data[s.pos:], []byte("//")):
+			end := bytes.IndexByte(s.data[s.pos:], '\n')
+			if end < 0 {
+				s.pos = len(s.data)
+				return
+			}
+			s.pos += end + 1
+		case bytes.HasPrefix(s.data[s.pos:], []byte("/*")):
+			end := bytes.Index(s.data[s.pos+2:], []byte("*/"))
+			if end < 0 {
+				s.pos = len(s.data)
+				return
+			}
+			s.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// readString reads the string at the current position
+func (s *textScanner) readString() (string, error) {
+	start := s.pos
+	if err := s.skipString(); err != nil {
+		return "", err
+	}
+	var value string
+	if err := json.Unmarshal(s.data[start:s.pos], &value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// skipString moves past the string at the current position
+func (s *textScanner) skipString() error {
+	if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+		return fmt.Errorf("expected a string at offset %d", s.pos)
+	}
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			return nil
+		}
+	}
+	return errors.New("unterminated string")
+}
+
+// skipValue moves past the value at the current position, with the
+// comments inside objects and lists
+func (s *textScanner) skipValue() error {
+	if s.pos >= len(s.data) {
+		return errors.New("unexpected end of file")
+	}
+	switch s.data[s.pos] {
+	case '"':
+		return s.skipString()
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.data) {
+			s.skip()
+			if s.pos >= len(s.data) {
+				break
+			}
+			switch s.data[s.pos] {
+			case '"':
+				if err := s.skipString(); err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.pos++
+			if depth == 0 {
+				return nil
+			}
+		}
+		return errors.New("unexpected end of file")
+	default:
+		// A number, true, false or null
+		start := s.pos
+		for s.pos < len(s.data) && !bytes.ContainsRune([]byte(",}] \t\r\n/"), rune(s.data[s.pos])) {
+			s.pos++
+		}
+		if s.pos == start {
+			return fmt.Errorf("expected a value at offset %d", s.pos)
+		}
+		return nil
+	}
+}
+
+// memberText returns the text of a new member setting the path of keys,
+// whose first key names the member
+func memberText(keys []string, value json.RawMessage, depth int) []byte {
+	name, _ := json.Marshal(keys[0])
+	return append(append(name, ": "...), indentText(nestedValue(keys[1:], value), depth)...)
+}
+
+// nestedValue wraps a value in the objects a path of keys names
+func nestedValue(keys []string, value json.RawMessage) json.RawMessage {
+	for n := len(keys) - 1; n >= 0; n-- {
+		value, _ = json.Marshal(map[string]json.RawMessage{keys[n]: value})
+	}
+	return value
+}
+
+// indentText indents a value for a member at depth levels of nesting
+func indentText(value json.RawMessage, depth int) []byte {
+	var b bytes.Buffer
+	if err := json.Indent(&b, value, strings.Repeat(fileIndent, depth), fileIndent); err != nil {
+		return value
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Origins of settings besides configuration files, which are named by
+// their path. Environment variables are named like "$EZRA_LOG_LEVEL" and
+// flags like "-companion-url".
+const (
+	OriginDefault   = "default"
+	OriginGenerated = "generated"
+	OriginCompanion = "companion"
+)
+
+// layer is a configuration file as read, applied over the settings of
+// the layers before it
+type layer struct {
+	origin string
+	data   []byte
+}
+
+// Setting is an effective setting and where it came from
+type Setting struct {
+	Name   string          `json:"setting"`
+	Value  json.RawMessage `json:"value"`
+	Origin string          `json:"origin"`
+}
+
+// SystemConfigFile returns the configuration file of the machine:
+// /etc/ezra/bootstrap.conf, or %ProgramData%\Ezra\bootstrap.conf on
+// Windows
+func SystemConfigFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Ezra", "bootstrap.conf")
+	}
+	return "/etc/ezra/bootstrap.conf"
+}
+
+// UserConfigFile returns the configuration file of the user:
+// ~/.config/ezra/bootstrap.conf, or under %AppData% on Windows. It is
+// empty if the user has no home directory.
+func UserConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if runtime.GOOS == "windows" {
+		dir = os.Getenv("AppData")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "ezra", "bootstrap.conf")
+}
+
+// readLayer reads a configuration file, without its comments. An
+// optional file that does not exist is skipped with a nil layer.
+func readLayer(path string, optional bool) (*layer, error) {
+	data, err := os.ReadFile(path)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return &layer{origin: path, data: stripComments(data)}, nil
+}
+
+// applyLayer applies the settings of a configuration file
+func (c *Config) applyLayer(l *layer) error {
+	if err := json.Unmarshal(l.data, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", l.origin, err)
+	}
+	return c.setOrigins(l.data, l.origin)
+}
+
+// setOrigins records the origin of the settings in a JSON object in the
+// format of the configuration file
+func (c *Config) setOrigins(data []byte, origin string) error {
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	for _, name := range flattenSettings(settings, "", nil) {
+		c.SetOrigin(name, origin)
+	}
+	return nil
+}
+
+// SetOrigin records where a setting, named by its dotted path in the
+// configuration file like "log_file.max_size_mb", was set. It replaces
+// the origins of the settings within it.
+func (c *Config) SetOrigin(setting, origin string) {
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	for name := range c.origins {
+		if strings.HasPrefix(name, setting+".") {
+			delete(c.origins, name)
+		}
+	}
+	c.origins[setting] = origin
+}
+
+// Origin returns where a setting was set: by itself or by a setting it
+// is within, or else by default
+func (c *Config) Origin(setting string) string {
+	for name := setting; name != ""; {
+		if origin, ok := c.origins[name]; ok {
+			return origin
+		}
+		dot := strings.LastIndex(name, ".")
+		if dot < 0 {
+			break
+		}
+		name = name[:dot]
+	}
+	return OriginDefault
+}
+
+// Settings returns the effective settings, sorted by name, with their
+// origins. Secrets are redacted as by Redacted.
+func (c *Config) Settings() ([]Setting, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	settings = redactValue(settings)
+
+	values := make(map[string]interface{})
+	names := flattenSettings(settings, "", values)
+	sort.Strings(names)
+
+	list := make([]Setting, 0, len(names))
+	for _, name := range names {
+		value, err := json.Marshal(values[name])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Setting{Name: name, Value: value, Origin: c.Origin(name)})
+	}
+	return list, nil
+}
+
+// flattenSettings returns the dotted paths of the values in a decoded
+// JSON object that are not objects themselves, storing the values in
+// values if it is not nil. An empty object is a value.
+func flattenSettings(value interface{}, prefix string, values map[string]interface{}) []string {
+	object, ok := value.(map[string]interface{})
+	if !ok || (len(object) == 0 && prefix != "") {
+		name := strings.TrimSuffix(prefix, ".")
+		if values != nil {
+			values[name] = value
+		}
+		return []string{name}
+	}
+	var names []string
+	for key, item := range object {
+		names = append(names, flattenSettings(item, prefix+key+".", values)...)
+	}
+	return names
+}
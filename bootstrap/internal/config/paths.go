@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// platformPaths are the default directories of an install
+type platformPaths struct {
+	install string
+	data    string
+	cache   string
+	backup  string
+}
+
+// defaultPaths returns the default directories of the platform:
+// /usr/local/bin and the XDG base directories on Linux, %ProgramFiles%
+// and %ProgramData% on Windows, and /usr/local/bin or Homebrew's bin and
+// /Library on macOS. Devices set up before these defaults keep using
+// ~/.ezra.
+func defaultPaths() platformPaths {
+	home, _ := os.UserHomeDir()
+	paths := legacyPaths(home)
+	switch {
+	case paths.data != "":
+		// Keep the existing data where it is
+	case runtime.GOOS == "windows":
+		paths = underDir(filepath.Join(envOr("ProgramData", `C:\ProgramData`), "Ezra"))
+	case runtime.GOOS == "darwin":
+		paths = underDir("/Library/Application Support/Ezra")
+		paths.cache = "/Library/Caches/Ezra"
+	default:
+		paths = xdgPaths(home)
+	}
+	paths.install = defaultInstallPath()
+	return paths
+}
+
+// userPaths returns the default directories of an install for the
+// current user only, which needs no administrator rights
+func userPaths() platformPaths {
+	home, _ := os.UserHomeDir()
+	paths := legacyPaths(home)
+	switch {
+	case paths.data != "":
+		// Keep the existing data where it is
+	case runtime.GOOS == "windows":
+		paths = underDir(filepath.Join(envOr("LocalAppData", filepath.Join(home, "AppData", "Local")), "Ezra"))
+	case runtime.GOOS == "darwin":
+		paths = underDir(filepath.Join(home, "Library", "Application Support", "Ezra"))
+		paths.cache = filepath.Join(home, "Library", "Caches", "Ezra")
+	default:
+		paths = xdgPaths(home)
+	}
+	return paths
+}
+
+// defaultInstallPath returns where binaries are installed. On Windows
+// %ProgramFiles% already matches the architecture of the bootstrap, and
+// on Apple silicon Homebrew's bin directory is used if Homebrew is there.
+func defaultInstallPath() string {
+	switch {
+	case runtime.GOOS == "windows":
+		return filepath.Join(envOr("ProgramFiles", `C:\Program Files`), "Ezra")
+	case runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" && isDir("/opt/homebrew/bin"):
+		return "/opt/homebrew/bin"
+	default:
+		return "/usr/local/bin"
+	}
+}
+
+// legacyPaths returns the directories under ~/.ezra if it exists, and
+// empty paths otherwise
+func legacyPaths(home string) platformPaths {
+	legacy := filepath.Join(home, ".ezra")
+	if home == "" || !isDir(legacy) {
+		return platformPaths{}
+	}
+	return underDir(legacy)
+}
+
+// underDir returns the directories of an install kept in one directory
+func underDir(dir string) platformPaths {
+	return platformPaths{
+		data:   dir,
+		cache:  filepath.Join(dir, "cache"),
+		backup: filepath.Join(dir, "backups"),
+	}
+}
+
+// xdgPaths returns the directories in the XDG base directories: data in
+// $XDG_DATA_HOME, the cache in $XDG_CACHE_HOME and backups in
+// $XDG_STATE_HOME
+func xdgPaths(home string) platformPaths {
+	return platformPaths{
+		data:   filepath.Join(xdgDir("XDG_DATA_HOME", home, ".local", "share"), "ezra"),
+		cache:  filepath.Join(xdgDir("XDG_CACHE_HOME", home, ".cache"), "ezra"),
+		backup: filepath.Join(xdgDir("XDG_STATE_HOME", home, ".local", "state"), "ezra", "backups"),
+	}
+}
+
+// xdgDir returns an XDG base directory, or its default under home. The
+// specification ignores relative paths.
+func xdgDir(env, home string, fallback ...string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(append([]string{home}, fallback...)...)
+}
+
+// UseUserPaths points the data, cache and backup directories that were
+// left at their defaults at the current user's, for an install that
+// needs no administrator rights
+func (c *Config) UseUserPaths() {
+	paths := userPaths()
+	if c.Origin("data_path") == OriginDefault {
+		c.DataPath = paths.data
+	}
+	if c.Origin("cache_path") == OriginDefault {
+		c.CachePath = paths.cache
+	}
+	if c.Origin("backup_path") == OriginDefault {
+		c.BackupPath = paths.backup
+	}
+}
+
+// envOr returns an environment variable, or fallback if it is not set
+func envOr(env, fallback string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// redactedValue replaces secrets in redacted configurations
+const redactedValue = redact.Redacted
+
+// secretKeys are the settings, at any depth, that hold secrets
+var secretKeys = []string{"token", "password", "secret"}
+
+// Redacted returns the configuration as JSON with its secrets replaced:
+// tokens, passwords, and the passwords and query strings of URLs, which
+// may carry signed access
+func (c *Config) Redacted() ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redactValue(settings), "", "  ")
+}
+
+// RedactJSON replaces the secrets in a JSON document like Redacted, and
+// those redact.String finds in its other strings
+func RedactJSON(data []byte) ([]byte, error) {
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redactValue(settings), "", "  ")
+}
+
+// Secrets returns the secret values of the configuration, the ones
+// Redacted replaces, for redact.AddSecret
+func (c *Config) Secrets() []string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil
+	}
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil
+	}
+	var secrets []string
+	collectSecrets(settings, &secrets)
+	return secrets
+}
+
+// collectSecrets appends the secrets of a decoded JSON value
+func collectSecrets(value interface{}, secrets *[]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if s, ok := item.(string); ok && s != "" && secretKey(key) {
+				*secrets = append(*secrets, s)
+				continue
+			}
+			collectSecrets(item, secrets)
+		}
+	case []interface{}:
+		for _, item := range value {
+			collectSecrets(item, secrets)
+		}
+	case string:
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok && password != "" {
+				*secrets = append(*secrets, password)
+			}
+		}
+	}
+}
+
+// redactValue replaces the secrets in a decoded JSON value
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if s, ok := item.(string); ok && s != "" && secretKey(key) {
+				value[key] = redactedValue
+				continue
+			}
+			value[key] = redactValue(item)
+		}
+		return value
+	case []interface{}:
+		for n, item := range value {
+			value[n] = redactValue(item)
+		}
+		return value
+	case string:
+		return redact.String(redactURL(value))
+	default:
+		return value
+	}
+}
+
+// secretKey reports whether a setting holds a secret
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if key == secret || strings.HasSuffix(key, "_"+secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL removes the password and query string of a URL. Other
+// strings are returned unchanged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	return u.Redacted()
+}
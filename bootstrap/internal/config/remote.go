@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// RemoteConfig configures pulling the configuration of the device from
+// the companion, keyed by its device ID or enrollment token
+type RemoteConfig struct {
+	Enabled bool `json:"enabled"`
+	// Required fails when the companion cannot be reached instead of
+	// using the configuration pulled last
+	Required bool `json:"required"`
+	// Revision is the revision of the configuration pulled from the
+	// companion, once merged
+	Revision string `json:"-"`
+}
+
+// localOnly are the settings the companion cannot set: those deciding
+// what is trusted and what runs with root rights stay with the device
+var localOnly = []string{
+	"verify_signatures",
+	"public_key",
+	"public_key_pinned",
+	"trusted_keys",
+	"signature_type",
+	"cosign",
+	"pgp",
+	"tuf",
+	"provenance",
+	"ca_cert",
+	"client_cert",
+	"client_key",
+	"insecure_skip_verify",
+	"hooks",
+	"elevation",
+	"service_templates",
+	"config_templates",
+	"remote_config",
+	"secret_store",
+}
+
+// MergeRemote merges the settings pulled from the companion, a JSON
+// object in the format of the configuration file, into the
+// configuration. Settings of the local configuration files and of the
+// environment win, and the companion's settings of what the device
+// trusts are left out and returned.
+func (c *Config) MergeRemote(settings []byte) ([]string, error) {
+	var remote map[string]json.RawMessage
+	if err := json.Unmarshal(settings, &remote); err != nil {
+		return nil, fmt.Errorf("invalid remote configuration: %w", err)
+	}
+
+	var ignored []string
+	for _, key := range localOnly {
+		if _, ok := remote[key]; ok {
+			delete(remote, key)
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+
+	data, err := json.Marshal(remote)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid remote configuration: %w", err)
+	}
+	if err := c.setOrigins(data, OriginCompanion); err != nil {
+		return nil, err
+	}
+
+	for _, l := range c.local {
+		if err := c.applyLayer(l); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	return ignored, nil
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+	"github.com/ezra/bootstrap/pkg/secrets"
+)
+
+// SecretRefPrefix starts a setting read from the secret store, e.g.
+// "secret:enrollment-token"
+const SecretRefPrefix = "secret:"
+
+// secretRef is a setting read from the secret store
+type secretRef struct {
+	// path is where the setting is in the configuration file, with list
+	// indexes and map keys
+	path []string
+	ref  string
+}
+
+// SecretStoreDir returns where the file backends of the secret store
+// keep their files
+func (c *Config) SecretStoreDir() string {
+	return filepath.Join(c.DataPath, "secrets")
+}
+
+// OpenSecretStore opens the configured secret store
+func (c *Config) OpenSecretStore() (secrets.Store, error) {
+	return secrets.Open(c.SecretStore.Backend, c.SecretStoreDir())
+}
+
+// resolveSecrets replaces the settings written "secret:<name>" with the
+// secrets they name. The store is only opened if there are any. The
+// companion cannot refer to secrets, which would let it read them.
+func (c *Config) resolveSecrets() error {
+	c.secretRefs = nil
+	var store secrets.Store
+	return walkStrings(reflect.ValueOf(c).Elem(), nil, func(path []string, value string) (string, error) {
+		if !strings.HasPrefix(value, SecretRefPrefix) {
+			return value, nil
+		}
+		setting := strings.Join(path, ".")
+		if c.Origin(setting) == OriginCompanion {
+			return "", fmt.Errorf("invalid %s: the companion cannot refer to secrets", setting)
+		}
+		if store == nil {
+			var err error
+			if store, err = c.OpenSecretStore(); err != nil {
+				return "", fmt.Errorf("failed to open the secret store for %s: %w", setting, err)
+			}
+		}
+		secret, err := store.Get(strings.TrimPrefix(value, SecretRefPrefix))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s for %s: %w", value, setting, err)
+		}
+		redact.AddSecret(string(secret))
+		c.secretRefs = append(c.secretRefs, secretRef{path: path, ref: value})
+		return string(secret), nil
+	})
+}
+
+// walkStrings replaces the strings of a settings value with what replace
+// returns for them, given their path. Maps and lists are walked into.
+func walkStrings(v reflect.Value, path []string, replace func([]string, string) (string, error)) error {
+	// Extend a copy, as sibling paths share the array
+	at := func(key string) []string {
+		return append(append([]string(nil), path...), key)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		value, err := replace(path, v.String())
+		if err != nil {
+			return err
+		}
+		if value != v.String() {
+			v.SetString(value)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return walkStrings(v.Elem(), path, replace)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for n := 0; n < t.NumField(); n++ {
+			field := t.Field(n)
+			key := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			if err := walkStrings(v.Field(n), at(key), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for n := 0; n < v.Len(); n++ {
+			if err := walkStrings(v.Index(n), at(strconv.Itoa(n)), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			// Map values cannot be changed in place
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(key))
+			if err := walkStrings(item, at(key.String()), replace); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, item)
+		}
+	}
+	return nil
+}
+
+// marshalSecretRefs encodes the configuration like the configuration
+// file, with the references of the settings read from the secret store
+// instead of the secrets
+func (c *Config) marshalSecretRefs() ([]byte, error) {
+	if len(c.secretRefs) == 0 {
+		return json.MarshalIndent(c, "", "  ")
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var settings interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	for _, ref := range c.secretRefs {
+		setPath(settings, ref.path, ref.ref)
+	}
+	return json.MarshalIndent(settings, "", "  ")
+}
+
+// setPath sets the value at a path in a decoded JSON value, if there is
+// one there
+func setPath(value interface{}, path []string, to string) {
+	if len(path) == 0 {
+		return
+	}
+	last := len(path) == 1
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if _, ok := value[path[0]]; !ok {
+			return
+		}
+		if last {
+			value[path[0]] = to
+			return
+		}
+		setPath(value[path[0]], path[1:], to)
+	case []interface{}:
+		n, err := strconv.Atoi(path[0])
+		if err != nil || n < 0 || n >= len(value) {
+			return
+		}
+		if last {
+			value[n] = to
+			return
+		}
+		setPath(value[n], path[1:], to)
+	}
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/secrets"
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// Allowed values of settings. Empty values are always allowed and pick
+// the default.
+var (
+	logLevels          = []string{"debug", "info", "warn", "error"}
+	progressModes      = []string{"auto", "tty", "log", "json"}
+	mirrorSelections   = []string{"ordered", "latency"}
+	checksumAlgorithms = []string{"sha256", "sha512", "blake3"}
+	signatureTypes     = []string{"ed25519", "cosign"}
+	supervisors        = []string{"auto", "systemd", "openrc", "runit", "sysv", "launchd", "scm", "container", "direct"}
+	installModes       = []string{"auto", "system", "user", "sysext"}
+	elevations         = []string{"auto", "sudo", "pkexec", "none"}
+	conflictPolicies   = []string{"overwrite", "keep", "prompt"}
+	logSinks           = []string{"auto", "journald", "syslog", "eventlog", "none"}
+	executorVariants   = []string{"auto", "cpu", "cuda", "rocm"}
+	modes              = []string{"off", "auto", "required"}
+	severities         = []string{"low", "medium", "high", "critical"}
+	hooks              = []string{"pre-install", "post-install", "pre-start", "post-start"}
+	failurePolicies    = []string{"abort", "continue"}
+	components         = []string{"companion", "agent", "executor"}
+)
+
+// URL schemes of the settings holding URLs
+var (
+	releaseSchemes = []string{"http", "https", "oci", "s3", "gs", "azblob", "github"}
+	httpSchemes    = []string{"http", "https"}
+	proxySchemes   = []string{"http", "https", "socks5"}
+)
+
+// Violation is an invalid setting, named by its path in the configuration
+// file, e.g. log_file.max_size_mb
+type Violation struct {
+	Setting string `json:"setting"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Setting + ": " + v.Message
+}
+
+// ValidationError lists every invalid setting of a configuration
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Violations))
+	for n, violation := range e.Violations {
+		lines[n] = violation.String()
+	}
+	return fmt.Sprintf("invalid configuration:\n    %s", strings.Join(lines, "\n    "))
+}
+
+// Validate checks the settings: URLs, absolute paths, that the data
+// directories can be written, public keys, allowed values and options
+// that exclude each other. It returns every violation at once in a
+// *ValidationError, or nil.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if c.CompanionURL == "" && !c.OfflineMode {
+		v.add("companion_url", "is required unless offline_mode is set")
+	}
+	v.url("companion_url", c.CompanionURL, releaseSchemes)
+	for n, mirror := range c.Mirrors {
+		v.url(fmt.Sprintf("mirrors[%d]", n), mirror, releaseSchemes)
+	}
+	v.url("proxy_url", c.ProxyURL, proxySchemes)
+	v.url("connectivity_check_url", c.ConnectivityCheckURL, httpSchemes)
+	v.url("github.api_url", c.GitHub.APIURL, httpSchemes)
+
+	v.dir("install_path", c.InstallPath, false)
+	v.dir("data_path", c.DataPath, true)
+	v.dir("cache_path", c.CachePath, true)
+	v.dir("backup_path", c.BackupPath, true)
+	v.absPath("log_file.path", c.LogFile.Path)
+	if !c.LogFile.Disabled && filepath.IsAbs(c.LogFilePath()) {
+		v.dir("log_file.path", filepath.Dir(c.LogFilePath()), true)
+	}
+	v.absPath("report.path", c.Report.Path)
+	v.absPath("hooks.dir", c.Hooks.Dir)
+	v.absPath("health_check.agent_socket", c.HealthCheck.AgentSocket)
+	v.absPath("slots.path", c.Slots.Path)
+
+	v.publicKey("public_key", c.PublicKey)
+	for n, key := range c.TrustedKeys {
+		setting := fmt.Sprintf("trusted_keys[%d]", n)
+		if key.PublicKey == "" {
+			v.add(setting+".public_key", "is required")
+		}
+		v.publicKey(setting+".public_key", key.PublicKey)
+		if !key.NotBefore.IsZero() && !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			v.add(setting+".not_after", "must be after not_before")
+		}
+	}
+
+	v.oneOf("log_level", strings.ToLower(c.LogLevel), logLevels)
+	v.oneOf("progress", c.Progress, progressModes)
+	v.oneOf("mirror_selection", c.MirrorSelection, mirrorSelections)
+	v.oneOf("checksum_algorithm", c.ChecksumAlgorithm, checksumAlgorithms)
+	v.oneOf("signature_type", c.SignatureType, signatureTypes)
+	v.oneOf("supervisor", c.Supervisor, supervisors)
+	v.oneOf("install_mode", c.InstallMode, installModes)
+	v.oneOf("elevation", c.Elevation, elevations)
+	v.oneOf("on_conflict", c.OnConflict, conflictPolicies)
+	v.oneOf("log_sink", c.LogSink, logSinks)
+	v.oneOf("executor_variant", c.ExecutorVariant, executorVariants)
+	v.oneOf("tpm.mode", c.TPM.Mode, modes)
+	v.oneOf("enrollment.mode", c.Enrollment.Mode, modes)
+	v.oneOf("secret_store.backend", c.SecretStore.Backend, secrets.Backends)
+	v.oneOf("sbom.fail_severity", c.SBOM.FailSeverity, severities)
+	for n, component := range c.InstallComponents {
+		v.oneOf(fmt.Sprintf("install_components[%d]", n), component, components)
+	}
+	for n, script := range c.Hooks.Scripts {
+		setting := fmt.Sprintf("hooks.scripts[%d]", n)
+		if script.Hook == "" {
+			v.add(setting+".hook", "is required: one of %s", strings.Join(hooks, ", "))
+		}
+		v.oneOf(setting+".hook", script.Hook, hooks)
+		if script.Path == "" {
+			v.add(setting+".path", "is required")
+		}
+		v.oneOf(setting+".on_failure", script.OnFailure, failurePolicies)
+		v.nonNegative(setting+".timeout_sec", script.TimeoutSec)
+	}
+	policies := make([]string, 0, len(c.Hooks.Policies))
+	for hook := range c.Hooks.Policies {
+		policies = append(policies, hook)
+	}
+	sort.Strings(policies)
+	for _, hook := range policies {
+		v.oneOf("hooks.policies", hook, hooks)
+		v.oneOf("hooks.policies."+hook+".on_failure", c.Hooks.Policies[hook].OnFailure, failurePolicies)
+		v.nonNegative("hooks.policies."+hook+".timeout_sec", c.Hooks.Policies[hook].TimeoutSec)
+	}
+
+	v.size("cache_max_size", c.CacheMaxSize, downloader.ParseSize)
+	v.size("max_download_rate", c.MaxDownloadRate, downloader.ParseRate)
+	v.size("hardware.min_memory", c.Hardware.MinMemory, downloader.ParseSize)
+	v.size("hardware.min_free_disk", c.Hardware.MinFreeDisk, downloader.ParseSize)
+	v.window("download_window", c.DownloadWindow)
+	v.window("auto_update.maintenance_window", c.AutoUpdate.MaintenanceWindow)
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"download_concurrency", c.DownloadConcurrency},
+		{"parallel_downloads", c.ParallelDownloads},
+		{"companion_port_search", c.CompanionPortSearch},
+		{"cache_max_age_days", c.CacheMaxAgeDays},
+		{"retry.max_attempts", c.Retry.MaxAttempts},
+		{"retry.base_delay_ms", c.Retry.BaseDelayMs},
+		{"retry.max_delay_ms", c.Retry.MaxDelayMs},
+		{"hardware.min_cores", c.Hardware.MinCores},
+		{"board.gpu_memory_mb", c.Board.GPUMemoryMB},
+		{"board.thermal_limit_c", c.Board.ThermalLimitC},
+		{"health_check.timeout_sec", c.HealthCheck.TimeoutSec},
+		{"log_file.max_size_mb", c.LogFile.MaxSizeMB},
+		{"log_file.max_backups", c.LogFile.MaxBackups},
+		{"log_file.max_age_days", c.LogFile.MaxAgeDays},
+		{"log_shipping.batch_size", c.LogShipping.BatchSize},
+		{"log_shipping.flush_interval_sec", c.LogShipping.FlushIntervalSec},
+		{"log_shipping.buffer_size", c.LogShipping.BufferSize},
+		{"slots.keep", c.Slots.Keep},
+		{"auto_update.interval_minutes", c.AutoUpdate.IntervalMinutes},
+	} {
+		v.nonNegative(setting.name, setting.value)
+	}
+	if c.CompanionPort < 0 || c.CompanionPort > 65535 {
+		v.add("companion_port", "%d is not a port: use 1-65535, or 0 for the default", c.CompanionPort)
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		v.add("retry.jitter", "%g must be between 0 and 1", c.Retry.Jitter)
+	}
+	for n, status := range c.Retry.RetryableStatus {
+		if status < 100 || status > 599 {
+			v.add(fmt.Sprintf("retry.retryable_status[%d]", n), "%d is not an HTTP status", status)
+		}
+	}
+
+	if c.OfflineMode {
+		v.exclusive("remote_config.enabled", c.RemoteConfig.Enabled, "offline_mode", "the configuration cannot be pulled offline")
+		v.exclusive("log_shipping.enabled", c.LogShipping.Enabled, "offline_mode", "logs cannot be shipped offline")
+		v.exclusive("enrollment.mode", c.Enrollment.Mode == "required", "offline_mode", "enrollment needs the companion")
+	}
+	v.exclusive("insecure_skip_verify", c.InsecureSkipVerify && c.CACert != "", "ca_cert", "certificates are not checked at all")
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		v.add("client_cert", "client_cert and client_key must be set together for mutual TLS")
+	}
+	if c.PublicKeyPinned && c.PublicKey == "" {
+		v.add("public_key_pinned", "is set but public_key is empty: unset it to pin the companion's key again")
+	}
+	v.exclusive("cosign.identity_regexp", c.Cosign.Identity != "" && c.Cosign.IdentityRegexp != "", "cosign.identity", "identity_regexp is only used when identity is empty")
+	if c.checkCosign() != nil {
+		v.add("cosign.issuer", "is required with signature_type cosign")
+	}
+	if c.Slots.Enabled && runtime.GOOS == "windows" {
+		v.add("slots.enabled", "slots are not supported on Windows")
+	}
+
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v.violations}
+}
+
+// checkCosign refuses keyless verification without an OIDC issuer, under
+// which a certificate for the expected identity issued on the word of any
+// identity provider Fulcio accepts would be trusted. Load refuses it too,
+// as it is never safe to run with.
+func (c *Config) checkCosign() error {
+	if c.SignatureType == "cosign" && c.Cosign.Issuer == "" {
+		return errors.New("cosign.issuer is required with signature_type cosign")
+	}
+	return nil
+}
+
+// validator collects the violations of a configuration
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(setting, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Setting: setting, Message: fmt.Sprintf(format, args...)})
+}
+
+// url checks that a URL parses and has one of the schemes
+func (v *validator) url(setting, value string, schemes []string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(setting, "%q is not a URL: %v", value, err)
+		return
+	}
+	if !contains(schemes, strings.ToLower(u.Scheme)) {
+		v.add(setting, "%q must start with %s://", value, strings.Join(schemes, "://, "))
+		return
+	}
+	if u.Host == "" {
+		v.add(setting, "%q has no host", value)
+	}
+}
+
+// absPath checks that a path is absolute
+func (v *validator) absPath(setting, path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return true
+	}
+	v.add(setting, "%q must be an absolute path", path)
+	return false
+}
+
+// dir checks that a directory path is absolute and, with writable, that
+// the directory or the one it would be created in can be written
+func (v *validator) dir(setting, path string, writable bool) {
+	if path == "" || !v.absPath(setting, path) || !writable {
+		return
+	}
+
+	existing := path
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				v.add(setting, "%s is not a directory", existing)
+				return
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return
+		}
+		existing = parent
+	}
+
+	probe, err := os.CreateTemp(existing, ".ezra-validate-")
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			v.add(setting, "%s cannot be written by this user: run as the user the install runs as, or choose another directory", existing)
+		} else {
+			v.add(setting, "%s cannot be written: %v", existing, err)
+		}
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+}
+
+// publicKey checks that a key is a base64 Ed25519 or minisign public key
+func (v *validator) publicKey(setting, key string) {
+	if key == "" {
+		return
+	}
+	if _, err := verifier.KeyFingerprint(key); err != nil {
+		v.add(setting, "not a base64 Ed25519 or minisign public key: %v", err)
+	}
+}
+
+// oneOf checks that a value is one of those allowed
+func (v *validator) oneOf(setting, value string, allowed []string) {
+	if value != "" && !contains(allowed, value) {
+		v.add(setting, "%q is not one of %s", value, strings.Join(allowed, ", "))
+	}
+}
+
+func (v *validator) nonNegative(setting string, n int) {
+	if n < 0 {
+		v.add(setting, "%d must not be negative", n)
+	}
+}
+
+// size checks a size or rate with its parser
+func (v *validator) size(setting, value string, parse func(string) (int64, error)) {
+	if value == "" {
+		return
+	}
+	if _, err := parse(value); err != nil {
+		v.add(setting, "%v: write it like 512MiB or 2GB", err)
+	}
+}
+
+// window checks a daily window such as "01:00-05:00"
+func (v *validator) window(setting, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := downloader.ParseWindow(value); err != nil {
+		v.add(setting, "%v: write it like 01:00-05:00", err)
+	}
+}
+
+// exclusive reports an option set together with one it excludes
+func (v *validator) exclusive(setting string, set bool, other, reason string) {
+	if set {
+		v.add(setting, "cannot be combined with %s: %s", other, reason)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// Event types
+const (
+	PhaseStarted      = "phase_started"
+	PhaseCompleted    = "phase_completed"
+	PhaseSkipped      = "phase_skipped"
+	PhaseFailed       = "phase_failed"
+	DownloadStarted   = "download_started"
+	DownloadProgress  = "download_progress"
+	DownloadCompleted = "download_completed"
+	DownloadFailed    = "download_failed"
+	Verification      = "verification"
+	Error             = "error"
+	Finished          = "finished"
+)
+
+// Results of verification events
+const (
+	ResultPassed = "passed"
+	ResultFailed = "failed"
+)
+
+// progressInterval is the minimum time between two download_progress
+// events for the same file
+const progressInterval = 250 * time.Millisecond
+
+// Event is one line of the event stream. Fields that do not apply to an
+// event type are left out.
+type Event struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id,omitempty"`
+	// Phase is the install phase, e.g. download or configure
+	Phase string `json:"phase,omitempty"`
+	// Component is the component downloaded or verified
+	Component string `json:"component,omitempty"`
+	// File, Bytes and Total describe a download; Total is -1 when the size
+	// is unknown
+	File  string `json:"file,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"`
+	Total int64  `json:"total,omitempty"`
+	// Check is what a verification checked: download for checksums and
+	// signatures, provenance for build attestations
+	Check  string `json:"check,omitempty"`
+	Result string `json:"result,omitempty"`
+	// Code classifies an error for wrappers, e.g. network_error
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	// Success is set on the finished event
+	Success *bool `json:"success,omitempty"`
+}
+
+// Stream writes events as JSON, one per line. A nil Stream discards
+// them, so callers need not check whether events were requested.
+type Stream struct {
+	mu        sync.Mutex
+	w         io.WriteCloser
+	enc       *json.Encoder
+	sessionID string
+}
+
+// Open opens the event stream on an inherited file descriptor, or on a
+// file, which may be a named pipe. It returns nil when neither is given.
+func Open(fd int, path string) (*Stream, error) {
+	var w io.WriteCloser
+	switch {
+	case fd > 0:
+		file := os.NewFile(uintptr(fd), "events")
+		if file == nil {
+			return nil, fmt.Errorf("invalid events file descriptor %d", fd)
+		}
+		w = file
+	case path != "":
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open events file: %w", err)
+		}
+		w = file
+	default:
+		return nil, nil
+	}
+	return &Stream{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// SetSessionID tags the events from now on with the ID of this run
+func (s *Stream) SetSessionID(id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionID = id
+}
+
+// Emit writes an event, stamped with the time and session. Messages are
+// redacted like the log. Write errors are ignored: a wrapper that went
+// away must not fail the installation.
+func (s *Stream) Emit(event Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.Time = time.Now().UTC()
+	event.SessionID = s.sessionID
+	event.Message = redact.String(event.Message)
+	s.enc.Encode(event)
+}
+
+// Close closes the stream
+func (s *Stream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
+
+// Progress returns a download progress reporter that emits download
+// events, with progress events at most every 250ms per file
+func (s *Stream) Progress() downloader.ProgressReporter {
+	return &progress{stream: s, files: map[string]*progressFile{}}
+}
+
+// progress emits the progress of downloads
+type progress struct {
+	stream *Stream
+	mu     sync.Mutex
+	files  map[string]*progressFile
+}
+
+// progressFile is what the reporter remembers about a download
+type progressFile struct {
+	total   int64
+	updated time.Time
+}
+
+func (p *progress) Start(name string, total, current int64) {
+	p.mu.Lock()
+	p.files[name] = &progressFile{total: total, updated: time.Now()}
+	p.mu.Unlock()
+
+	p.stream.Emit(Event{Event: DownloadStarted, File: name, Bytes: current, Total: total})
+}
+
+func (p *progress) Update(name string, current int64) {
+	p.mu.Lock()
+	file := p.files[name]
+	if file == nil || time.Since(file.updated) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	file.updated = time.Now()
+	total := file.total
+	p.mu.Unlock()
+
+	p.stream.Emit(Event{Event: DownloadProgress, File: name, Bytes: current, Total: total})
+}
+
+func (p *progress) Finish(name string, err error) {
+	p.mu.Lock()
+	delete(p.files, name)
+	p.mu.Unlock()
+
+	if err != nil {
+		p.stream.Emit(Event{Event: DownloadFailed, File: name, Message: err.Error()})
+		return
+	}
+	p.stream.Emit(Event{Event: DownloadCompleted, File: name})
+}
@@ -0,0 +1,119 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// Code is the category of a failure, which scripts and wrappers can
+// branch on instead of parsing messages
+type Code string
+
+// Failure categories
+const (
+	Network             Code = "network"
+	Verification        Code = "verification"
+	Permission          Code = "permission"
+	Disk                Code = "disk"
+	Config              Code = "config"
+	UnsupportedPlatform Code = "unsupported_platform"
+	Interrupted         Code = "interrupted"
+	// Unknown is any other failure
+	Unknown Code = "failed"
+)
+
+// Exit codes of the bootstrap, one per category. 2 is left to invalid
+// flags, as the flag package uses it, and 130 is the shell's code for a
+// run stopped by Ctrl-C.
+const (
+	ExitFailure             = 1
+	ExitUsage               = 2
+	ExitConfig              = 3
+	ExitNetwork             = 4
+	ExitVerification        = 5
+	ExitPermission          = 6
+	ExitDisk                = 7
+	ExitUnsupportedPlatform = 8
+	ExitInterrupted         = 130
+)
+
+// exitCodes are the exit codes of the categories
+var exitCodes = map[Code]int{
+	Network:             ExitNetwork,
+	Verification:        ExitVerification,
+	Permission:          ExitPermission,
+	Disk:                ExitDisk,
+	Config:              ExitConfig,
+	UnsupportedPlatform: ExitUnsupportedPlatform,
+	Interrupted:         ExitInterrupted,
+}
+
+// Error is an error tagged with its category. Its message is the wrapped
+// error's.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap tags err with a category, unless it already has one. A nil error
+// stays nil.
+func Wrap(code Code, err error) error {
+	if err == nil || CodeOf(err) != Unknown {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// CodeOf returns the category of an error: the one it was tagged with,
+// or else the one its cause falls in, e.g. Network for a connection
+// that was refused
+func CodeOf(err error) Code {
+	var tagged *Error
+	var verificationErr *downloader.VerificationError
+	var statusErr *downloader.StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &tagged):
+		return tagged.Code
+	case errors.Is(err, context.Canceled):
+		return Interrupted
+	case errors.As(err, &verificationErr):
+		return Verification
+	case errors.Is(err, os.ErrPermission):
+		return Permission
+	case errors.Is(err, syscall.ENOSPC):
+		return Disk
+	// Error statuses of servers count as network failures too
+	case errors.As(err, &statusErr), errors.As(err, &netErr):
+		return Network
+	default:
+		return Unknown
+	}
+}
+
+// ExitCode returns the exit code for an error: 0 for nil, the code of its
+// category, or 1
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if code, ok := exitCodes[CodeOf(err)]; ok {
+		return code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,435 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// defaultUpdateInterval is how often the update daemon checks for
+// updates unless configured
+const defaultUpdateInterval = 6 * time.Hour
+
+// updateUnit names the systemd timer and service, and the scheduled
+// task, that run the update check
+const updateUnit = "ezra-update"
+
+// heldVersionsFile records the component versions the update daemon
+// rolled back, which it does not try again
+const heldVersionsFile = "held-versions.json"
+
+// updateTimerUnit runs the update check periodically. The first check
+// waits for the system to settle after boot.
+const updateTimerUnit = `[Unit]
+Description=Ezra update check
+
+[Timer]
+OnBootSec=15min
+OnUnitActiveSec=%dmin
+RandomizedDelaySec=5min
+
+[Install]
+WantedBy=timers.target
+`
+
+// updateServiceUnit is the update check the timer starts. It may wait
+// for the maintenance window, so it has no start timeout.
+const updateServiceUnit = `[Unit]
+Description=Ezra update check
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+TimeoutStartSec=infinity
+`
+
+// RunUpdates keeps the installation up to date: every update interval
+// the release is checked for new component versions, which are
+// downloaded and verified into the download cache at once and applied
+// in the maintenance window. An upgrade whose services do not become
+// ready is rolled back, and its versions are not tried again. With once
+// a single check is made, as the systemd timer and scheduled task do.
+// RunUpdates returns when the run's context is cancelled, or with
+// ErrSelfUpdated once the bootstrap updated itself.
+func (i *Installer) RunUpdates(once bool) error {
+	window, err := i.maintenanceWindow()
+	if err != nil {
+		return err
+	}
+	interval := i.updateInterval()
+
+	for {
+		err := i.checkForUpdates(window)
+		if i.ctx.Err() != nil {
+			return i.ctx.Err()
+		}
+		if once || errors.Is(err, ErrSelfUpdated) {
+			return err
+		}
+		if err != nil {
+			i.log.Errorf("Update failed: %v", err)
+		}
+
+		i.log.Infof("Next update check at %s", time.Now().Add(interval).Format("2006-01-02 15:04"))
+		timer := time.NewTimer(interval)
+		select {
+		case <-i.ctx.Done():
+			timer.Stop()
+			return i.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// checkForUpdates checks for new component versions once and applies
+// them in the maintenance window. With self_update the bootstrap is
+// updated first, so that the new one applies them.
+func (i *Installer) checkForUpdates(window *downloader.DownloadWindow) error {
+	if i.config.AutoUpdate.SelfUpdate {
+		if err := i.selfUpdateLocked(); err != nil {
+			return err
+		}
+	}
+
+	available, err := i.prefetchUpdates()
+	if err != nil || !available {
+		return err
+	}
+
+	if err := i.waitForMaintenance(window); err != nil {
+		return err
+	}
+
+	unlock, err := i.Lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	report, err := i.Upgrade()
+	if report != nil && len(report.RolledBack) > 0 {
+		i.holdVersions(report.RolledBack)
+	}
+	if err != nil {
+		return fmt.Errorf("upgrade failed: %w", err)
+	}
+	for component, version := range report.Upgraded {
+		i.log.Infof("Upgraded %s to %s", component, version)
+	}
+	return nil
+}
+
+// selfUpdateLocked updates the bootstrap under the install lock. It
+// returns ErrSelfUpdated if it did; a failed self-update is logged and
+// the running bootstrap carries on.
+func (i *Installer) selfUpdateLocked() error {
+	unlock, err := i.Lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	report, err := i.SelfUpdate()
+	if err != nil {
+		if i.ctx.Err() != nil {
+			return i.ctx.Err()
+		}
+		i.log.Errorf("Self-update failed: %v", err)
+		return nil
+	}
+	if report.Updated {
+		return ErrSelfUpdated
+	}
+	return nil
+}
+
+// prefetchUpdates checks the release for new component versions and
+// downloads them into the download cache, so that applying them in the
+// maintenance window needs no download. It reports whether there are
+// any.
+func (i *Installer) prefetchUpdates() (bool, error) {
+	unlock, err := i.Lock(true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	i.log.Info("Checking for updates...")
+	if !i.isInstalled() {
+		return false, fmt.Errorf("no existing installation found in %s", i.config.InstallPath)
+	}
+
+	// Versions are resolved once per downloader, so pick them again from
+	// the current release index
+	i.downloader.SetVersions(i.config.Channel, i.config.Components)
+	i.heldVersions = i.loadHeldVersions()
+	manifest, err := i.fetchRelease()
+	if err != nil {
+		return false, err
+	}
+
+	installed := i.loadInstalledVersions()
+	outdated := i.outdatedComponents(manifest, installed)
+	if len(outdated) == 0 {
+		i.log.Info("All components are up to date")
+		return false, nil
+	}
+
+	for _, component := range outdated {
+		latest := manifest.Components[component]
+		i.log.Infof("Update available for %s: %s -> %s", component, versionOrUnknown(installed[component]), latest.Version)
+
+		// Without the cache nothing is kept until the window, and a patch
+		// is small enough to download then
+		if i.config.NoCache || latest.SHA256 == "" || i.downloader.Cached(latest.SHA256) {
+			continue
+		}
+		if _, ok := latest.PatchFrom(installed[component]); installed[component] != "" && ok {
+			continue
+		}
+		if err := i.prefetchComponent(component, latest); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// prefetchComponent downloads and verifies a component into the download
+// cache
+func (i *Installer) prefetchComponent(component string, latest downloader.ComponentManifest) error {
+	if err := os.MkdirAll(i.config.CachePath, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", i.config.CachePath, err)
+	}
+	path := filepath.Join(i.config.CachePath, "."+binaryName(component)+".prefetch")
+	defer os.Remove(path)
+	return i.downloader.DownloadComponentVerified(i.ctx, component, path, i.streamVerifier(latest))
+}
+
+// waitForMaintenance blocks until the maintenance window is open or the
+// run is cancelled. A nil window is always open.
+func (i *Installer) waitForMaintenance(window *downloader.DownloadWindow) error {
+	now := time.Now()
+	if window == nil || window.Contains(now) {
+		return nil
+	}
+
+	open := window.Next(now)
+	i.log.Infof("Outside the maintenance window, applying the update at %s", open.Format("15:04"))
+	timer := time.NewTimer(time.Until(open))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-i.ctx.Done():
+		return i.ctx.Err()
+	}
+}
+
+// maintenanceWindow returns the configured maintenance window, or nil
+// when updates may be applied at any time
+func (i *Installer) maintenanceWindow() (*downloader.DownloadWindow, error) {
+	if i.config.AutoUpdate.MaintenanceWindow == "" {
+		return nil, nil
+	}
+	window, err := downloader.ParseWindow(i.config.AutoUpdate.MaintenanceWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance_window: %w", err)
+	}
+	return &window, nil
+}
+
+// updateInterval returns how often to check for updates
+func (i *Installer) updateInterval() time.Duration {
+	if i.config.AutoUpdate.IntervalMinutes > 0 {
+		return time.Duration(i.config.AutoUpdate.IntervalMinutes) * time.Minute
+	}
+	return defaultUpdateInterval
+}
+
+// loadHeldVersions reads the versions that were rolled back, by
+// component
+func (i *Installer) loadHeldVersions() map[string]string {
+	held := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(i.config.DataPath, heldVersionsFile))
+	if err != nil {
+		return held
+	}
+	if err := json.Unmarshal(data, &held); err != nil {
+		i.log.Errorf("Ignoring unreadable %s: %v", heldVersionsFile, err)
+		return map[string]string{}
+	}
+	return held
+}
+
+// holdVersions records versions that were rolled back, so that they are
+// skipped until a newer release is published
+func (i *Installer) holdVersions(versions map[string]string) {
+	held := i.loadHeldVersions()
+	for component, version := range versions {
+		i.log.Errorf("Holding back %s %s until a newer version is released", component, version)
+		held[component] = version
+	}
+	data, err := json.MarshalIndent(held, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(i.config.DataPath, heldVersionsFile), data, 0644)
+	}
+	if err != nil {
+		i.log.Errorf("Could not record held versions: %v", err)
+	}
+}
+
+// ScheduleUpdates registers a systemd timer, or a scheduled task on
+// Windows, that runs the update check every update interval in place of
+// a resident daemon. The running bootstrap is installed to InstallPath
+// for it and run with args.
+func (i *Installer) ScheduleUpdates(args []string) error {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return err
+	}
+	if supervisor != supervisorSystemd && supervisor != supervisorSCM && supervisor != supervisorTask {
+		return fmt.Errorf("updates can only be scheduled with systemd or the Task Scheduler, not %s; run the daemon under the service manager instead", supervisor)
+	}
+	if _, err := i.maintenanceWindow(); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the bootstrap: %w", err)
+	}
+	bootstrap := filepath.Join(i.config.InstallPath, binaryName("bootstrap"))
+	command := append([]string{bootstrap}, args...)
+	minutes := int(i.updateInterval() / time.Minute)
+
+	return i.transaction(func() error {
+		if err := i.mkdirAll(i.config.InstallPath, 0755); err != nil {
+			return err
+		}
+		if same, _ := sameFile(exe, bootstrap); !same {
+			if err := i.installFile(exe, bootstrap, 0755); err != nil {
+				return fmt.Errorf("failed to install the bootstrap: %w", err)
+			}
+		}
+
+		if supervisor != supervisorSystemd {
+			return i.scheduleUpdateTask(command, minutes)
+		}
+		dir, err := i.systemdUnitDir()
+		if err != nil {
+			return err
+		}
+		if i.dryRun {
+			i.plan.addService(updateUnit + " (systemd)")
+		}
+		if err := i.mkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		service := fmt.Sprintf(updateServiceUnit, systemdCommand(command))
+		if err := i.writeFile(filepath.Join(dir, updateUnit+".service"), []byte(service), 0644); err != nil {
+			return err
+		}
+		timer := fmt.Sprintf(updateTimerUnit, minutes)
+		if err := i.writeFile(filepath.Join(dir, updateUnit+".timer"), []byte(timer), 0644); err != nil {
+			return err
+		}
+		var user []string
+		if i.userMode() {
+			user = []string{"--user"}
+		}
+		if err := i.runServiceCommand("systemctl", append(user, "daemon-reload")...); err != nil {
+			return err
+		}
+		return i.runServiceCommand("systemctl", append(user, "enable", "--now", updateUnit+".timer")...)
+	})
+}
+
+// scheduleUpdateTask registers the scheduled task that runs the update
+// check: as SYSTEM for a system install, else as the installing user
+func (i *Installer) scheduleUpdateTask(command []string, minutes int) error {
+	if i.dryRun {
+		i.plan.addService(updateUnit + " (" + supervisorTask + ")")
+	}
+	args := []string{"/Create", "/F", "/TN", userTaskName(updateUnit), "/TR", windowsCommandLine(command)}
+	// Minute schedules repeat at most daily
+	if minutes < 24*60 {
+		args = append(args, "/SC", "MINUTE", "/MO", strconv.Itoa(minutes))
+	} else {
+		args = append(args, "/SC", "DAILY", "/MO", strconv.Itoa(minutes/(24*60)))
+	}
+	if i.userMode() {
+		args = append(args, "/RL", "LIMITED")
+	} else {
+		args = append(args, "/RU", "SYSTEM", "/RL", "HIGHEST")
+	}
+	return i.runServiceCommand("schtasks", args...)
+}
+
+// removeUpdateSchedule removes the update timer or scheduled task and
+// the bootstrap installed for it
+func (i *Installer) removeUpdateSchedule(supervisor string, report *UninstallReport) error {
+	switch supervisor {
+	case supervisorSystemd:
+		dir, err := i.systemdUnitDir()
+		if err != nil {
+			return err
+		}
+		timer := filepath.Join(dir, updateUnit+".timer")
+		if fileExists(timer) {
+			if _, err := i.combinedOutput(i.systemctl("disable", "--now", updateUnit+".timer")); err != nil {
+				i.log.Errorf("Failed to disable %s: %v", updateUnit, err)
+			}
+		}
+		for _, unit := range []string{timer, filepath.Join(dir, updateUnit+".service")} {
+			if !fileExists(unit) {
+				continue
+			}
+			if err := i.removeAll(unit); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", unit, err)
+			}
+			report.RemovedUnits = append(report.RemovedUnits, unit)
+		}
+	case supervisorSCM, supervisorTask:
+		if err := i.removeUserTask(updateUnit, report); err != nil {
+			return err
+		}
+	}
+
+	// A bootstrap run from InstallPath cannot remove itself on Windows
+	bootstrap := filepath.Join(i.config.InstallPath, binaryName("bootstrap"))
+	if !fileExists(bootstrap) {
+		return nil
+	}
+	exe, _ := os.Executable()
+	if same, _ := sameFile(exe, bootstrap); same && runtime.GOOS == "windows" {
+		i.log.Infof("Remove %s once the bootstrap has exited", bootstrap)
+		return nil
+	}
+	if err := i.removeAll(bootstrap); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", bootstrap, err)
+	}
+	report.RemovedBinaries = append(report.RemovedBinaries, bootstrap)
+	return nil
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
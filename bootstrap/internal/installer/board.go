@@ -0,0 +1,114 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// Raspberry Pi boot configuration, under /boot/firmware since Bookworm
+var raspberryPiConfigFiles = []string{"/boot/firmware/config.txt", "/boot/config.txt"}
+
+// defaultThermalLimits is the SoC temperature in °C at which the agent
+// stops taking work, a little below where each board throttles
+var defaultThermalLimits = map[string]int{
+	detector.BoardRaspberryPi: 80,
+	detector.BoardJetson:      90,
+	detector.BoardRockPi:      85,
+}
+
+// thermalLimit returns the configured or default thermal limit of the
+// board, or 0 if there is none
+func (i *Installer) thermalLimit() int {
+	if i.config.Board.ThermalLimitC > 0 {
+		return i.config.Board.ThermalLimitC
+	}
+	if i.systemInfo == nil {
+		return 0
+	}
+	return defaultThermalLimits[i.systemInfo.Board.Family]
+}
+
+// applyBoardTweaks applies the settings for the detected board
+func (i *Installer) applyBoardTweaks() error {
+	if i.systemInfo == nil || i.systemInfo.Board.Family == "" {
+		return nil
+	}
+	board := i.systemInfo.Board
+	i.log.Infof("Detected %s", board.Model)
+
+	if i.config.Board.GPUMemoryMB == 0 {
+		return nil
+	}
+	if i.userMode() {
+		i.log.Info("Ignoring the GPU memory split: changing the boot configuration needs root")
+		return nil
+	}
+	if board.Family != detector.BoardRaspberryPi {
+		i.log.Infof("Ignoring the GPU memory split: not a Raspberry Pi")
+		return nil
+	}
+	return i.setGPUMemory(i.config.Board.GPUMemoryMB)
+}
+
+// setGPUMemory sets gpu_mem in the Raspberry Pi config.txt
+func (i *Installer) setGPUMemory(megabytes int) error {
+	path := ""
+	for _, candidate := range raspberryPiConfigFiles {
+		if _, err := os.Stat(candidate); err == nil {
+			path = candidate
+			break
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("Raspberry Pi config.txt not found")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	updated, changed := setConfigTxt(string(data), "gpu_mem", fmt.Sprint(megabytes))
+	if !changed {
+		return nil
+	}
+
+	i.log.Infof("Setting the GPU memory split to %d MB in %s; it takes effect after a reboot", megabytes, path)
+	return i.writeFile(path, []byte(updated), 0644)
+}
+
+// setConfigTxt sets a global option in a Raspberry Pi config.txt. Lines
+// under conditional sections such as [pi4] are left alone; a new option
+// is added under [all] if the file has sections.
+func setConfigTxt(content, option, value string) (string, bool) {
+	line := option + "=" + value
+	var lines []string
+	if trimmed := strings.TrimRight(content, "\n"); trimmed != "" {
+		lines = strings.Split(trimmed, "\n")
+	}
+
+	global, sections := true, false
+	for n, current := range lines {
+		trimmed := strings.TrimSpace(current)
+		if strings.HasPrefix(trimmed, "[") {
+			sections = true
+			global = trimmed == "[all]"
+			continue
+		}
+		if global && strings.HasPrefix(trimmed, option+"=") {
+			if trimmed == line {
+				return content, false
+			}
+			lines[n] = line
+			return strings.Join(lines, "\n") + "\n", true
+		}
+	}
+
+	if sections && !global {
+		lines = append(lines, "", "[all]")
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, "\n") + "\n", true
+}
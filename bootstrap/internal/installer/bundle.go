@@ -0,0 +1,181 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/archive"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// bundleManifestName is the manifest stored inside a bundle
+const bundleManifestName = "manifest.json"
+
+// useBundle reports whether components should come from a release
+// bundle. Bundles always hold the latest release and are not TUF
+// targets, so pinned versions and TUF fall back to single downloads.
+func (i *Installer) useBundle() bool {
+	switch {
+	case !i.config.UseBundle:
+		return false
+	case i.config.TUF.Enabled:
+		i.log.Info("Bundles are not used with TUF, downloading components individually")
+		return false
+	case len(i.config.Components) > 0:
+		i.log.Info("Bundles are not used with pinned versions, downloading components individually")
+		return false
+	case len(i.components) < len(components):
+		i.log.Info("Bundles hold every component, downloading the selected ones individually")
+		return false
+	}
+	return true
+}
+
+// downloadBundle downloads the release bundle and moves its components
+// to where individual downloads would have put them. The bundle is only
+// extracted once verified against the release manifest, as the manifest
+// inside it vouches for nothing before that; every component is then
+// checked against the manifest inside. Only with verify_signatures off
+// is a bundle the release manifest does not cover extracted.
+func (i *Installer) downloadBundle(manifest *downloader.Manifest) error {
+	if manifest == nil && i.config.VerifySigs {
+		return failure.Wrap(failure.Verification, errors.New("no release manifest to verify the bundle against"))
+	}
+
+	verified := make(map[string]bool)
+	verify := func(name string) downloader.StreamVerifier {
+		if manifest == nil {
+			return nil
+		}
+		sv := i.streamVerifier(manifest.Bundles[name])
+		verified[name] = sv != nil
+		return sv
+	}
+
+	bundle, err := i.downloader.DownloadBundle(i.ctx, i.config.CachePath, verify)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(bundle)
+
+	if name := filepath.Base(bundle); !verified[name] && i.config.VerifySigs {
+		return failure.Wrap(failure.Verification, fmt.Errorf("the release manifest does not list bundle %s, refusing to extract it unverified", name))
+	}
+
+	dir, err := os.MkdirTemp(i.config.CachePath, ".bundle-")
+	if err != nil {
+		return fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := archive.Extract(bundle, dir); err != nil {
+		return fmt.Errorf("failed to extract bundle: %w", err)
+	}
+
+	contents, err := readBundleManifest(filepath.Join(dir, bundleManifestName))
+	if err != nil {
+		return err
+	}
+
+	for _, component := range i.components {
+		entry, ok := contents.Components[component]
+		if !ok {
+			return fmt.Errorf("bundle does not contain %s", component)
+		}
+
+		src := filepath.Join(dir, downloader.ComponentFilename(component))
+		if err := verifyFile(src, i.reportedVerifier(component, i.streamVerifier(entry))); err != nil {
+			return failure.Wrap(failure.Verification, fmt.Errorf("%s in bundle failed verification: %w", component, err))
+		}
+
+		if err := os.Rename(src, filepath.Join(i.config.CachePath, component)); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", component, err)
+		}
+
+		if i.state != nil {
+			i.state.markDownloaded(component)
+		}
+		i.log.Infof("%s extracted from bundle", component)
+	}
+
+	if i.state != nil {
+		if err := i.state.save(); err != nil {
+			i.log.Errorf("Failed to save install state: %v", err)
+		}
+	}
+	return nil
+}
+
+// verifyBundleMedia checks the components of a release bundle extracted
+// for an offline install against the manifest inside it and names them
+// the way offline media does. With signatures enabled every component
+// must be signed, unless the bundle itself was.
+func (i *Installer) verifyBundleMedia(dir string, signed bool) error {
+	i.log.Info("Verifying release bundle...")
+
+	contents, err := readBundleManifest(filepath.Join(dir, bundleManifestName))
+	if err != nil {
+		return err
+	}
+
+	i.mediaVersions = map[string]string{}
+	for _, component := range i.components {
+		entry, ok := contents.Components[component]
+		if !ok {
+			return fmt.Errorf("bundle does not contain %s", component)
+		}
+		i.mediaVersions[component] = entry.Version
+		if i.signaturesEnabled() && !signed && entry.Signature == "" {
+			return failure.Wrap(failure.Verification, fmt.Errorf("%s in bundle is not signed and the bundle has no signature", component))
+		}
+
+		src := filepath.Join(dir, downloader.ComponentFilename(component))
+		if err := verifyFile(src, i.reportedVerifier(component, i.streamVerifier(entry))); err != nil {
+			return failure.Wrap(failure.Verification, fmt.Errorf("%s in bundle failed verification: %w", component, err))
+		}
+		if err := os.Rename(src, filepath.Join(dir, component)); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", component, err)
+		}
+	}
+
+	i.log.Infof("Release bundle %s verified", contents.Version)
+	return nil
+}
+
+// readBundleManifest reads the manifest stored inside a bundle
+func readBundleManifest(path string) (*downloader.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("bundle has no manifest: %w", err)
+	}
+
+	var manifest downloader.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// verifyFile runs a file through a stream verifier. A nil verifier
+// accepts any file.
+func verifyFile(path string, sv downloader.StreamVerifier) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if sv == nil {
+		return nil
+	}
+	sv.Reset()
+	if _, err := io.Copy(sv, file); err != nil {
+		return err
+	}
+	return sv.Verify()
+}
@@ -0,0 +1,62 @@
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// cacheDir returns where the content-addressable download cache is kept.
+// It sits next to the per-install downloads under CachePath.
+func cacheDir(cfg *config.Config) string {
+	return filepath.Join(cfg.CachePath, "blobs")
+}
+
+// cacheLimits returns the configured cache size and age limits
+func cacheLimits(cfg *config.Config) (int64, time.Duration, error) {
+	var maxSize int64
+	if cfg.CacheMaxSize != "" {
+		size, err := downloader.ParseSize(cfg.CacheMaxSize)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid cache_max_size: %w", err)
+		}
+		maxSize = size
+	}
+	return maxSize, time.Duration(cfg.CacheMaxAgeDays) * 24 * time.Hour, nil
+}
+
+// configureCache enables the download cache unless it is turned off
+func configureCache(d *downloader.Downloader, cfg *config.Config) error {
+	if cfg.NoCache {
+		return nil
+	}
+	if _, _, err := cacheLimits(cfg); err != nil {
+		return err
+	}
+	d.SetCache(downloader.NewCache(cacheDir(cfg)))
+	return nil
+}
+
+// pruneCache trims the download cache to the configured limits. A cache
+// that cannot be pruned only wastes disk space, so errors are logged.
+func (i *Installer) pruneCache() {
+	if i.config.NoCache || i.dryRun {
+		return
+	}
+
+	maxSize, maxAge, err := cacheLimits(i.config)
+	if err != nil {
+		return
+	}
+	stats, err := downloader.NewCache(cacheDir(i.config)).Prune(maxSize, maxAge)
+	if err != nil {
+		i.log.Errorf("Failed to prune download cache: %v", err)
+		return
+	}
+	if stats.Removed > 0 {
+		i.log.Infof("Pruned %d cached downloads (%d bytes)", stats.Removed, stats.Freed)
+	}
+}
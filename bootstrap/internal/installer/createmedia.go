@@ -0,0 +1,364 @@
+package installer
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// defaultMediaLabel is the volume label of formatted media unless another
+// is given
+const defaultMediaLabel = "EZRA"
+
+// fatLabelLength is the longest volume label FAT file systems keep
+const fatLabelLength = 11
+
+// formatVolumeScript formats the drive in EZRA_MEDIA_DRIVE on Windows
+const formatVolumeScript = `Format-Volume -DriveLetter $env:EZRA_MEDIA_DRIVE -FileSystem exFAT -NewFileSystemLabel $env:EZRA_MEDIA_LABEL -Force -Confirm:$false | Out-Null`
+
+// MediaOptions describes offline media to create
+type MediaOptions struct {
+	// Path is the directory the media is written to, e.g. where the
+	// device is mounted. It is chosen when a device is formatted.
+	Path string
+	// Platforms are the targets the media carries components for. Empty
+	// selects this device.
+	Platforms []downloader.Target
+	// SigningKey is the file holding the base64 Ed25519 private key, or
+	// its 32 byte seed, the media manifest is signed with. Without one
+	// the manifest is left unsigned.
+	SigningKey string
+	// Device is formatted and mounted before the media is written, e.g.
+	// /dev/sdb, /dev/disk4 or E:
+	Device string
+	// Label is the volume label of a formatted device
+	Label string
+}
+
+// ParsePlatform parses a platform given as os/arch, e.g. linux/aarch64,
+// linux/armv6-musl or windows/amd64. Go architecture names are mapped to
+// the ones in published file names.
+func ParsePlatform(platform string) (downloader.Target, error) {
+	goos, arch, ok := strings.Cut(strings.TrimSpace(platform), "/")
+	if !ok || goos == "" || arch == "" {
+		return downloader.Target{}, fmt.Errorf("invalid platform %q, expected os/arch", platform)
+	}
+	switch goos {
+	case "linux", "darwin", "windows":
+	default:
+		return downloader.Target{}, fmt.Errorf("unsupported operating system %q in platform %q", goos, platform)
+	}
+
+	target := downloader.Target{OS: goos}
+	if base, found := strings.CutSuffix(arch, "-musl"); found {
+		arch, target.Libc = base, "musl"
+	}
+	target.Arch = downloader.ArchName(arch)
+	return target, nil
+}
+
+// CreateMedia writes offline installation media: the components for each
+// platform in a directory named after it, a copy of this bootstrap, and
+// the media manifest listing every file with its SHA256, signed with the
+// operator's key. InstallOffline verifies the media against it.
+func (i *Installer) CreateMedia(opts MediaOptions) error {
+	i.log.Info("Creating offline media...")
+
+	var key ed25519.PrivateKey
+	if opts.SigningKey != "" {
+		var err error
+		if key, err = readSigningKey(opts.SigningKey); err != nil {
+			return err
+		}
+	}
+
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = []downloader.Target{downloadTarget(i.config, i.systemInfo)}
+	}
+	seen := map[string]bool{}
+	for _, target := range platforms {
+		if seen[target.Platform()] {
+			return fmt.Errorf("platform %s is given twice", target.Platform())
+		}
+		seen[target.Platform()] = true
+	}
+
+	if opts.Device != "" {
+		path, eject, err := i.formatMedia(opts.Device, opts.Label)
+		if err != nil {
+			return err
+		}
+		defer eject()
+		opts.Path = path
+	}
+	if opts.Path == "" {
+		return fmt.Errorf("no media path given")
+	}
+	if err := os.MkdirAll(opts.Path, 0755); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	manifest, err := i.fetchRelease()
+	if err != nil {
+		return err
+	}
+
+	for _, target := range platforms {
+		if err := i.downloadMediaPlatform(opts.Path, target, manifest); err != nil {
+			return err
+		}
+	}
+	if err := i.copyBootstrap(opts.Path); err != nil {
+		return err
+	}
+
+	media := mediaManifest{Version: manifest.Version}
+	for _, target := range platforms {
+		media.Platforms = append(media.Platforms, target.Platform())
+	}
+	if media.Files, err = hashMedia(opts.Path); err != nil {
+		return fmt.Errorf("failed to hash media files: %w", err)
+	}
+	if err := i.writeMediaManifest(opts.Path, media, key); err != nil {
+		return err
+	}
+
+	i.log.Infof("Offline media for %s written to %s (%d files)", strings.Join(media.Platforms, ", "), opts.Path, len(media.Files))
+	return nil
+}
+
+// downloadMediaPlatform downloads the selected components for a platform
+// into its directory on the media
+func (i *Installer) downloadMediaPlatform(mediaPath string, target downloader.Target, manifest *downloader.Manifest) error {
+	// The executor build is chosen by configuration, not by the GPU of
+	// the machine making the media
+	variant, err := executorVariant(i.config, nil)
+	if err != nil {
+		return err
+	}
+	host := downloadTarget(i.config, i.systemInfo)
+	hostVariant, err := executorVariant(i.config, i.systemInfo)
+	if err != nil {
+		return err
+	}
+	i.downloader.SetTarget(target)
+	i.downloader.SetVariant("executor", variant)
+	defer func() {
+		i.downloader.SetTarget(host)
+		i.downloader.SetVariant("executor", hostVariant)
+	}()
+
+	// The release manifest describes the builds for this device, so the
+	// other platforms are only checked against TUF metadata
+	verify := target.Platform() == host.Platform()
+	if !verify && !i.config.TUF.Enabled {
+		i.log.Errorf("The release manifest does not cover %s: its components are downloaded without checksums", target.Platform())
+	}
+
+	i.log.Infof("Downloading components for %s...", target.Platform())
+	for _, component := range i.components {
+		dir := filepath.Join(mediaPath, target.Platform(), component)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+
+		var sv downloader.StreamVerifier
+		if verify {
+			sv = i.streamVerifier(manifest.Components[component])
+		}
+		dest := filepath.Join(dir, i.downloader.TargetFilename(component))
+		if err := i.downloader.DownloadComponentVerified(i.ctx, component, dest, sv); err != nil {
+			return fmt.Errorf("failed to download %s for %s: %w", component, target.Platform(), err)
+		}
+	}
+	return nil
+}
+
+// copyBootstrap copies the running bootstrap to the media root, so that
+// devices without one can install from the media
+func (i *Installer) copyBootstrap(mediaPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the bootstrap: %w", err)
+	}
+	src, err := os.Open(exe)
+	if err != nil {
+		return fmt.Errorf("failed to read the bootstrap: %w", err)
+	}
+	defer src.Close()
+
+	dest := filepath.Join(mediaPath, filepath.Base(exe))
+	dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to copy the bootstrap: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy the bootstrap: %w", err)
+	}
+	return dst.Close()
+}
+
+// writeMediaManifest writes the media manifest and, with a key, its
+// signature. A signature the configured keys do not accept is reported,
+// since devices using this configuration would refuse the media.
+func (i *Installer) writeMediaManifest(mediaPath string, media mediaManifest, key ed25519.PrivateKey) error {
+	data, err := json.MarshalIndent(media, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode media manifest: %w", err)
+	}
+	manifestPath := filepath.Join(mediaPath, mediaManifestFile)
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write media manifest: %w", err)
+	}
+
+	// Signatures of an earlier run no longer match
+	for _, ext := range []string{".sig", ".minisig", ".asc", ".bundle"} {
+		if err := os.Remove(manifestPath + ext); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old media signature: %w", err)
+		}
+	}
+
+	if key == nil {
+		i.log.Errorf("No signing key given: the media manifest is unsigned and only devices with signature verification disabled accept it")
+		return nil
+	}
+	digest := sha256.Sum256(append(data, '\n'))
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:]))
+	if err := os.WriteFile(manifestPath+".sig", []byte(signature), 0644); err != nil {
+		return fmt.Errorf("failed to write media signature: %w", err)
+	}
+
+	if i.signaturesEnabled() {
+		if err := i.verifier.VerifyRelease(manifestPath); err != nil {
+			i.log.Errorf("The configured keys do not accept the media signature, devices using this configuration will refuse it: %v", err)
+		}
+	}
+	return nil
+}
+
+// hashMedia returns the SHA256 of every file on the media by its slash
+// separated path, leaving out the manifest and its signatures
+func hashMedia(mediaPath string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.WalkDir(mediaPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(mediaPath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, mediaManifestFile) || !d.Type().IsRegular() {
+			return nil
+		}
+
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = sum
+		return nil
+	})
+	return files, err
+}
+
+// readSigningKey reads a base64 Ed25519 private key, or its seed
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing key: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	default:
+		return nil, fmt.Errorf("invalid signing key length %d", len(raw))
+	}
+}
+
+// formatMedia erases a device, formats it with a file system every
+// platform can read, and mounts it. It returns where the device
+// is mounted and the function that ejects it.
+func (i *Installer) formatMedia(device, label string) (string, func(), error) {
+	if label == "" {
+		label = defaultMediaLabel
+	}
+	if len(label) > fatLabelLength {
+		return "", nil, fmt.Errorf("volume label %q is longer than %d characters", label, fatLabelLength)
+	}
+	i.log.Infof("Formatting %s as %s...", device, label)
+
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := i.combinedOutput(exec.Command("mkfs.vfat", "-I", "-F", "32", "-n", strings.ToUpper(label), device)); err != nil {
+			return "", nil, fmt.Errorf("failed to format %s: %w", device, err)
+		}
+		dir, err := os.MkdirTemp("", "ezra-media-")
+		if err != nil {
+			return "", nil, err
+		}
+		// FAT has no owners: the files belong to whoever mounts it
+		options := fmt.Sprintf("uid=%d,gid=%d", os.Getuid(), os.Getgid())
+		if _, err := i.combinedOutput(exec.Command("mount", "-o", options, device, dir)); err != nil {
+			os.Remove(dir)
+			return "", nil, fmt.Errorf("failed to mount %s: %w", device, err)
+		}
+		return dir, func() {
+			if _, err := i.combinedOutput(exec.Command("umount", dir)); err != nil {
+				i.log.Errorf("Failed to unmount %s: %v", device, err)
+				return
+			}
+			os.Remove(dir)
+		}, nil
+
+	case "darwin":
+		// diskutil mounts the new volume under /Volumes
+		if _, err := i.combinedOutput(exec.Command("diskutil", "eraseDisk", "FAT32", strings.ToUpper(label), "MBRFormat", device)); err != nil {
+			return "", nil, fmt.Errorf("failed to format %s: %w", device, err)
+		}
+		return filepath.Join("/Volumes", strings.ToUpper(label)), func() {
+			if _, err := i.combinedOutput(exec.Command("diskutil", "eject", device)); err != nil {
+				i.log.Errorf("Failed to eject %s: %v", device, err)
+			}
+		}, nil
+
+	case "windows":
+		letter := strings.TrimSuffix(strings.TrimRight(device, `\`), ":")
+		if len(letter) != 1 {
+			return "", nil, fmt.Errorf("invalid drive %q, expected a drive letter such as E:", device)
+		}
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", formatVolumeScript)
+		cmd.Env = append(os.Environ(), "EZRA_MEDIA_DRIVE="+letter, "EZRA_MEDIA_LABEL="+label)
+		if _, err := i.combinedOutput(cmd); err != nil {
+			return "", nil, fmt.Errorf("failed to format %s: %w", device, err)
+		}
+		return letter + `:\`, func() {}, nil
+
+	default:
+		return "", nil, failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("formatting media is not supported on %s", runtime.GOOS))
+	}
+}
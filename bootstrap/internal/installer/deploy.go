@@ -0,0 +1,117 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Conflict policies, see config.OnConflict
+const (
+	conflictOverwrite = "overwrite"
+	conflictKeep      = "keep"
+	conflictPrompt    = "prompt"
+)
+
+// deployedFilesName is the file under DataPath that records the checksum
+// of every file installations wrote
+const deployedFilesName = "deployed-files.json"
+
+// ConflictResolver asks the operator whether to overwrite a file that
+// Ezra did not write, or that was changed since it did
+type ConflictResolver func(path string) bool
+
+// SetConflictResolver sets how the operator is asked about conflicting
+// files under the prompt policy. Without one, conflicting files are kept.
+func (i *Installer) SetConflictResolver(resolve ConflictResolver) {
+	i.resolveConflict = resolve
+}
+
+// validConflictPolicy checks the on_conflict setting
+func validConflictPolicy(policy string) error {
+	switch policy {
+	case "", conflictOverwrite, conflictKeep, conflictPrompt:
+		return nil
+	default:
+		return fmt.Errorf("unknown on_conflict policy %q", policy)
+	}
+}
+
+// shouldWrite decides whether path is written with new contents whose
+// SHA-256 is sum. Files with the same contents are left alone, and files
+// an earlier installation wrote are replaced. Any other existing file is
+// a conflict, settled by the on_conflict policy.
+func (i *Installer) shouldWrite(path, sum string) (bool, error) {
+	if err := validConflictPolicy(i.config.OnConflict); err != nil {
+		return false, err
+	}
+
+	current, err := fileSHA256(path)
+	if err != nil {
+		// Missing, or unreadable and left to the write to report
+		return true, nil
+	}
+	if current == sum {
+		return false, nil
+	}
+	if recorded, ok := i.deployedFiles()[path]; ok && recorded == current {
+		return true, nil
+	}
+
+	switch i.config.OnConflict {
+	case conflictKeep:
+		i.log.Infof("Keeping %s, which was changed outside Ezra", path)
+		return false, nil
+	case conflictPrompt:
+		if i.resolveConflict != nil && i.resolveConflict(path) {
+			return true, nil
+		}
+		i.log.Infof("Keeping %s", path)
+		return false, nil
+	default:
+		i.log.Infof("Replacing %s, which was changed outside Ezra", path)
+		return true, nil
+	}
+}
+
+// deployedFiles returns the checksums of the files installations wrote,
+// by path
+func (i *Installer) deployedFiles() map[string]string {
+	if i.deployed == nil {
+		i.deployed = map[string]string{}
+		if data, err := os.ReadFile(filepath.Join(i.config.DataPath, deployedFilesName)); err == nil {
+			if err := json.Unmarshal(data, &i.deployed); err != nil {
+				i.log.Errorf("Ignoring unreadable %s: %v", deployedFilesName, err)
+			}
+		}
+	}
+	return i.deployed
+}
+
+// recordDeployed remembers the checksum of a written file, so that a
+// later installation can tell it from a file changed outside Ezra
+func (i *Installer) recordDeployed(path, sum string) {
+	deployed := i.deployedFiles()
+	deployed[path] = sum
+
+	err := os.MkdirAll(i.config.DataPath, 0755)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(deployed, "", "  ")
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(i.config.DataPath, deployedFilesName), data, 0644)
+	}
+	if err != nil {
+		i.log.Errorf("Failed to record %s: %v", path, err)
+	}
+}
+
+// dataChecksum returns the hex SHA-256 of data
+func dataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
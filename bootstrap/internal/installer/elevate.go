@@ -0,0 +1,276 @@
+package installer
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// Elevation tools, see config.Elevation
+const (
+	elevationSudo   = "sudo"
+	elevationPkexec = "pkexec"
+	elevationNone   = "none"
+)
+
+// elevationAuditFile lists every command that ran as root, under DataPath
+const elevationAuditFile = "elevated.log"
+
+// elevator runs commands as root through a single sudo or pkexec shell,
+// so the password is asked for once however many steps need root
+type elevator struct {
+	tool   string
+	marker string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// elevationTool returns the tool that runs commands as root
+func elevationTool(mode string) (string, error) {
+	switch mode {
+	case elevationSudo, elevationPkexec:
+		if _, err := exec.LookPath(mode); err != nil {
+			return "", fmt.Errorf("%s is not available: %w", mode, err)
+		}
+		return mode, nil
+	case elevationNone:
+		return "", fmt.Errorf("root is needed: run the bootstrap as root, or install with -user")
+	case "", "auto":
+	default:
+		return "", fmt.Errorf("unknown elevation %q", mode)
+	}
+
+	for _, tool := range []string{elevationSudo, elevationPkexec} {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool, nil
+		}
+	}
+	return "", fmt.Errorf("root is needed but neither sudo nor pkexec is available: run the bootstrap as root, or install with -user")
+}
+
+// start opens the root shell, which asks for the password
+func (e *elevator) start() error {
+	marker := make([]byte, 16)
+	if _, err := rand.Read(marker); err != nil {
+		return err
+	}
+	e.marker = "ezra-" + hex.EncodeToString(marker)
+
+	cmd := exec.Command(e.tool, "/bin/sh")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", e.tool, err)
+	}
+	e.cmd, e.stdin, e.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// run runs a command in the root shell and returns its combined output
+func (e *elevator) run(args []string) ([]byte, error) {
+	if e.cmd == nil {
+		if err := e.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The status is printed on a line of its own after the output, which
+	// may not end in a newline
+	_, err := fmt.Fprintf(e.stdin, "%s </dev/null 2>&1; status=$?; printf '\\n%s %%d\\n' \"$status\"\n",
+		shellCommand(args), e.marker)
+
+	var out strings.Builder
+	for err == nil {
+		var line string
+		line, err = e.stdout.ReadString('\n')
+		if status, ok := strings.CutPrefix(line, e.marker+" "); ok && err == nil {
+			output := []byte(strings.TrimSuffix(out.String(), "\n"))
+			code, _ := strconv.Atoi(strings.TrimSpace(status))
+			if code != 0 {
+				return output, fmt.Errorf("exit status %d", code)
+			}
+			return output, nil
+		}
+		out.WriteString(line)
+	}
+
+	// The shell is gone, e.g. the password was refused: ask again next time
+	e.close()
+	return []byte(out.String()), fmt.Errorf("%s did not run the command: %w", e.tool, err)
+}
+
+// close ends the root shell
+func (e *elevator) close() {
+	if e.cmd == nil {
+		return
+	}
+	e.stdin.Close()
+	e.cmd.Wait()
+	e.cmd = nil
+}
+
+// needsElevation reports whether changing path, or running a service
+// command when path is empty, needs root the installer does not have.
+// Windows cannot elevate part of a process, see NeedsRelaunch.
+func (i *Installer) needsElevation(path string) bool {
+	if i.userMode() || runtime.GOOS == "windows" || isElevated() {
+		return false
+	}
+	return path == "" || !writable(path)
+}
+
+// runElevated runs a command as root, asking for the password the first
+// time. Every command is logged, kept in the install report and appended
+// to the audit file.
+func (i *Installer) runElevated(args ...string) ([]byte, error) {
+	if i.elevator == nil {
+		tool, err := elevationTool(i.config.Elevation)
+		if err != nil {
+			return nil, err
+		}
+		i.log.Infof("Some steps need root: they are run through %s", tool)
+		i.elevator = &elevator{tool: tool}
+	}
+
+	command := shellCommand(args)
+	i.log.Infof("Running as root: %s", command)
+	i.report.Elevated = append(i.report.Elevated, command)
+	i.auditElevated(command)
+
+	out, err := i.elevator.run(args)
+	if err != nil {
+		return out, fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// auditElevated appends a command run as root to the audit file
+func (i *Installer) auditElevated(command string) {
+	path := filepath.Join(i.config.DataPath, elevationAuditFile)
+	if err := os.MkdirAll(i.config.DataPath, 0755); err != nil {
+		i.log.Errorf("Cannot audit elevated commands: %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		i.log.Errorf("Cannot audit elevated commands: %v", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s: %s\n", time.Now().Format(time.RFC3339), i.elevator.tool, redact.String(command))
+}
+
+// combinedOutput runs a service manager command, as root if needed
+func (i *Installer) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	if i.needsElevation("") {
+		return i.runElevated(cmd.Args...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// installElevated copies a file into place as root. Like installFile the
+// copy is renamed over the destination.
+func (i *Installer) installElevated(src, dst string, perm os.FileMode) error {
+	tmp := dst + ".new"
+	if _, err := i.runElevated("cp", src, tmp); err != nil {
+		return err
+	}
+	if _, err := i.runElevated("chmod", strconv.FormatUint(uint64(perm.Perm()), 8), tmp); err != nil {
+		i.runElevated("rm", "-f", tmp)
+		return err
+	}
+	_, err := i.runElevated("mv", "-f", tmp, dst)
+	return err
+}
+
+// writeElevated writes a file as root through a temporary copy
+func (i *Installer) writeElevated(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp("", "ezra-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return i.installElevated(tmp.Name(), path, perm)
+}
+
+// rename moves a file into place, as root if its directory needs it
+func (i *Installer) rename(src, dst string) error {
+	if i.needsElevation(dst) {
+		_, err := i.runElevated("mv", "-f", src, dst)
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// removeAll deletes a file or directory, as root if its directory needs
+// it
+func (i *Installer) removeAll(path string) error {
+	if i.needsElevation(path) {
+		_, err := i.runElevated("rm", "-rf", path)
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// NeedsRelaunch reports whether the bootstrap must be started again with
+// administrator rights. Windows cannot elevate part of a process, so a
+// system install there is relaunched through a UAC prompt.
+func (i *Installer) NeedsRelaunch() bool {
+	return runtime.GOOS == "windows" && !i.userMode() && !i.dryRun &&
+		!isElevated() && i.config.Elevation != elevationNone
+}
+
+// RelaunchElevated runs the bootstrap again with the same arguments
+// through a UAC prompt, waits for it and returns its exit code
+func RelaunchElevated() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	script := fmt.Sprintf("$p = Start-Process -FilePath %s -Verb RunAs -Wait -PassThru", quote(executable))
+	if len(os.Args) > 1 {
+		script = fmt.Sprintf("$p = Start-Process -FilePath %s -ArgumentList %s -Verb RunAs -Wait -PassThru",
+			quote(executable), quote(windowsCommandLine(os.Args[1:])))
+	}
+	script += "; exit $p.ExitCode"
+
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package installer
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// isElevated reports whether the process runs as root
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
+
+// writable reports whether the process can create or replace path: the
+// file itself if it exists, and the nearest existing directory above it
+func writable(path string) bool {
+	if _, err := os.Lstat(path); err == nil && unix.Access(path, unix.W_OK) != nil {
+		return false
+	}
+	dir := filepath.Dir(filepath.Clean(path))
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return unix.Access(dir, unix.W_OK) == nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
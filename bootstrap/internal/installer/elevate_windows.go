@@ -0,0 +1,14 @@
+package installer
+
+import "golang.org/x/sys/windows"
+
+// isElevated reports whether the process token is elevated
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// writable is not used on Windows, where the whole bootstrap is
+// relaunched elevated instead
+func writable(path string) bool {
+	return true
+}
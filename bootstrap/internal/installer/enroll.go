@@ -0,0 +1,365 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/companion"
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/identity"
+)
+
+// Enrollment modes, see config.EnrollmentConfig
+const (
+	enrollModeOff      = "off"
+	enrollModeAuto     = "auto"
+	enrollModeRequired = "required"
+)
+
+// Files in DataPath holding the device key and the credential the
+// companion issued for it
+const (
+	deviceKeyFile        = "device-key.pem"
+	deviceCredentialFile = "device-credentials.json"
+)
+
+// deviceCredential is the stored credential of an enrolled device
+type deviceCredential struct {
+	companion.Credential
+	DeviceID string `json:"device_id"`
+	// PublicKey is the device key the credential was issued for
+	PublicKey  []byte    `json:"public_key"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
+
+// enrolls reports whether the device is enrolled with the companion
+func (i *Installer) enrolls() (bool, error) {
+	switch i.config.Enrollment.Mode {
+	case "", enrollModeOff:
+		return i.useTPM, nil
+	case enrollModeAuto, enrollModeRequired:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid enrollment mode %q", i.config.Enrollment.Mode)
+	}
+}
+
+// deviceKeyPath returns where the device key is kept
+func (i *Installer) deviceKeyPath() string {
+	return filepath.Join(i.config.DataPath, deviceKeyFile)
+}
+
+// deviceCredentialPath returns where the credential of an enrolled
+// device is kept
+func (i *Installer) deviceCredentialPath() string {
+	return filepath.Join(i.config.DataPath, deviceCredentialFile)
+}
+
+// setupDeviceKey creates the key the device enrolls with, unless it has
+// one. Kept outside the journal like the TPM identity: a rollback must
+// not lose the key the companion may already know the device by.
+func (i *Installer) setupDeviceKey() error {
+	if ok, err := i.enrolls(); !ok || err != nil {
+		return err
+	}
+	if i.dryRun {
+		if !fileExists(i.deviceKeyPath()) {
+			i.plan.addFile(i.deviceKeyPath())
+		}
+		return nil
+	}
+
+	_, err := i.deviceKey()
+	return err
+}
+
+// deviceKey reads the device key, creating it the first time
+func (i *Installer) deviceKey() (ed25519.PrivateKey, error) {
+	path := i.deviceKeyPath()
+	data, err := readSecret(i.config, path)
+	if err == nil {
+		return parseDeviceKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read device key: %w", err)
+	}
+
+	i.log.Info("Creating device key...")
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := i.writeSecret(path, data); err != nil {
+		return nil, fmt.Errorf("failed to write device key: %w", err)
+	}
+	return key, nil
+}
+
+// parseDeviceKey parses a PEM PKCS #8 Ed25519 private key
+func parseDeviceKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("device key is not a PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("device key is not an Ed25519 key")
+	}
+	return key, nil
+}
+
+// writeSecret writes a file only the owner can read, as root if it needs
+// to be, and hands it to the service account. With a secret store it is
+// kept there instead.
+func (i *Installer) writeSecret(path string, data []byte) error {
+	if usesSecretStore(i.config) {
+		return i.storeSecret(path, data)
+	}
+
+	var err error
+	if i.needsElevation(path) {
+		err = i.writeElevated(path, data, 0600)
+	} else {
+		err = writeAtomic(path, bytes.NewReader(data), 0600)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ok, err := i.hasServiceUser(); !ok || err != nil {
+		return err
+	}
+	return i.grantPath(i.serviceUser(), path)
+}
+
+// enrolledCredential returns the stored credential when it was issued
+// for this device and key and has not expired
+func (i *Installer) enrolledCredential(key ed25519.PrivateKey) *deviceCredential {
+	data, err := readSecret(i.config, i.deviceCredentialPath())
+	if err != nil {
+		return nil
+	}
+	var credential deviceCredential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return nil
+	}
+	if credential.DeviceID != i.config.DeviceID || !bytes.Equal(credential.PublicKey, key.Public().(ed25519.PublicKey)) {
+		return nil
+	}
+	if credential.ExpiresAt != nil && time.Now().After(*credential.ExpiresAt) {
+		return nil
+	}
+	return &credential
+}
+
+// enrollDevice enrolls the device with the companion: the device ID, the
+// system and the device key are sent, signed over the companion's nonce,
+// and the credential the companion issues is stored for the agent. A
+// TPM-backed device also sends a quote, which the companion checks
+// against the attestation key before it accepts the device ID. A device
+// that is already enrolled is not enrolled again unless forced.
+func (i *Installer) enrollDevice(force bool) error {
+	if ok, err := i.enrolls(); !ok || err != nil {
+		return err
+	}
+	if i.dryRun {
+		i.plan.addCommand("enroll device " + i.config.DeviceID + " with the companion")
+		i.plan.addFile(i.deviceCredentialPath())
+		return nil
+	}
+
+	key, err := i.deviceKey()
+	if err != nil {
+		return err
+	}
+	if enrolled := i.enrolledCredential(key); enrolled != nil && !force {
+		i.log.Infof("Device %s is already enrolled", i.config.DeviceID)
+		i.companion.SetToken(enrolled.Token)
+		return nil
+	}
+
+	i.log.Info("Enrolling device with the companion...")
+
+	ctx := i.ctx
+	nonce, err := i.companion.EnrollChallenge(ctx, i.config.DeviceID)
+	if downloader.IsNotFound(err) && i.config.Enrollment.Mode == enrollModeAuto && !i.useTPM {
+		i.log.Info("The companion does not offer enrollment, skipping it")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get enrollment challenge: %w", err)
+	}
+
+	request := companion.Enrollment{
+		DeviceID:         i.config.DeviceID,
+		System:           i.systemInfo,
+		PublicKey:        key.Public().(ed25519.PublicKey),
+		Signature:        ed25519.Sign(key, nonce),
+		Token:            i.config.Enrollment.Token,
+		BootstrapVersion: i.bootstrapVersion,
+	}
+	if i.useTPM {
+		tpm, err := identity.Open(i.tpmOptions())
+		if err != nil {
+			return fmt.Errorf("failed to open TPM: %w", err)
+		}
+		defer tpm.Close()
+
+		if request.AKPublic, err = tpm.AttestationKey(); err != nil {
+			return err
+		}
+		if request.Quote, err = tpm.Quote(nonce, i.config.TPM.PCRs); err != nil {
+			return err
+		}
+	}
+
+	issued, err := i.companion.Enroll(ctx, request)
+	if err != nil {
+		var statusErr *downloader.StatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden) {
+			return fmt.Errorf("the companion rejected the enrollment of device %s (status %d): check the enrollment token and that the device is allowed", i.config.DeviceID, statusErr.StatusCode)
+		}
+		return fmt.Errorf("enrollment rejected: %w", err)
+	}
+	if err := i.storeCredential(issued, key); err != nil {
+		return err
+	}
+
+	if i.useTPM {
+		i.log.Infof("Device %s enrolled with TPM attestation", i.config.DeviceID)
+	} else {
+		i.log.Infof("Device %s enrolled", i.config.DeviceID)
+	}
+	return nil
+}
+
+// storeCredential stores the credential the companion issued for the
+// device key, and authenticates later requests with it
+func (i *Installer) storeCredential(issued *companion.Credential, key ed25519.PrivateKey) error {
+	if err := checkCredential(issued, key); err != nil {
+		return fmt.Errorf("invalid credential from the companion: %w", err)
+	}
+
+	credential := deviceCredential{
+		Credential: *issued,
+		DeviceID:   i.config.DeviceID,
+		PublicKey:  key.Public().(ed25519.PublicKey),
+		EnrolledAt: time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(credential, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := i.writeSecret(i.deviceCredentialPath(), data); err != nil {
+		return fmt.Errorf("failed to store device credential: %w", err)
+	}
+	i.companion.SetToken(credential.Token)
+	return nil
+}
+
+// checkCredential checks that the companion issued a credential, and
+// that a certificate is one of the device key
+func checkCredential(credential *companion.Credential, key ed25519.PrivateKey) error {
+	if credential.Token == "" && credential.Certificate == "" {
+		return errors.New("neither a token nor a certificate was issued")
+	}
+	if credential.Certificate == "" {
+		return nil
+	}
+
+	block, _ := pem.Decode([]byte(credential.Certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	public, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok || !public.Equal(key.Public()) {
+		return errors.New("certificate is not for the device key")
+	}
+	if credential.ExpiresAt == nil {
+		credential.ExpiresAt = &cert.NotAfter
+	}
+	return nil
+}
+
+// Enroll enrolls an installed device with the companion, again when
+// forced, and restarts the agent so it authenticates with the new
+// credential. Devices not configured to enroll are enrolled as required.
+func (i *Installer) Enroll(force bool) error {
+	if !i.isInstalled() {
+		return fmt.Errorf("no existing installation found in %s", i.config.InstallPath)
+	}
+	if err := i.setupDeviceIdentity(); err != nil {
+		return fmt.Errorf("failed to set up device identity: %w", err)
+	}
+	ok, err := i.enrolls()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		i.config.Enrollment.Mode = enrollModeRequired
+	}
+
+	// Enroll the components that were installed rather than the
+	// configured selection
+	var present []string
+	for _, component := range components {
+		if fileExists(filepath.Join(i.config.InstallPath, binaryName(component))) {
+			present = append(present, component)
+		}
+	}
+	i.components = present
+
+	err = i.transaction(func() error {
+		if err := i.setupDeviceKey(); err != nil {
+			return fmt.Errorf("failed to set up device key: %w", err)
+		}
+		// The agent is told where its credential is
+		if i.selected("companion") {
+			if err := i.chooseCompanionPort(); err != nil {
+				return err
+			}
+		}
+		if err := i.createConfigFiles(); err != nil {
+			return fmt.Errorf("failed to create config files: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := i.enrollDevice(force); err != nil {
+		return err
+	}
+
+	if !i.selected("agent") || i.dryRun {
+		return nil
+	}
+	if err := i.restartServices([]string{"agent"}); err != nil {
+		return err
+	}
+	return i.waitForAgent()
+}
@@ -0,0 +1,76 @@
+package installer
+
+import (
+	"time"
+
+	"github.com/ezra/bootstrap/internal/events"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// SetEvents streams the progress of the run to wrapper tooling: phases,
+// downloads, verification results and errors. A nil stream emits nothing.
+func (i *Installer) SetEvents(stream *events.Stream) {
+	i.events = stream
+	if stream == nil {
+		return
+	}
+	stream.SetSessionID(i.sessionID)
+	i.downloader.AddProgress(stream.Progress())
+}
+
+// errorCode classifies an error for the event stream
+func errorCode(err error) string {
+	return string(failure.CodeOf(err))
+}
+
+// emitFinished ends the event stream of an installation with its
+// outcome, led by an error event if it failed
+func (i *Installer) emitFinished(started time.Time, installErr error) {
+	success := installErr == nil
+	finished := events.Event{
+		Event:      events.Finished,
+		Success:    &success,
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+	if installErr != nil {
+		code := errorCode(installErr)
+		i.events.Emit(events.Event{Event: events.Error, Code: code, Message: installErr.Error()})
+		finished.Code = code
+	}
+	i.events.Emit(finished)
+}
+
+// emitVerification reports the result of a check of a component
+func (i *Installer) emitVerification(component, check string, err error) {
+	event := events.Event{Event: events.Verification, Component: component, Check: check, Result: events.ResultPassed}
+	if err != nil {
+		event.Result = events.ResultFailed
+		event.Code = string(failure.Verification)
+		event.Message = err.Error()
+	}
+	i.events.Emit(event)
+}
+
+// reportedVerifier emits the result of a stream verifier checking the
+// download of a component. A nil verifier checks nothing and reports
+// nothing.
+func (i *Installer) reportedVerifier(component string, sv downloader.StreamVerifier) downloader.StreamVerifier {
+	if sv == nil || i.events == nil {
+		return sv
+	}
+	return &eventVerifier{StreamVerifier: sv, i: i, component: component}
+}
+
+// eventVerifier is a stream verifier that emits its result
+type eventVerifier struct {
+	downloader.StreamVerifier
+	i         *Installer
+	component string
+}
+
+func (v *eventVerifier) Verify() error {
+	err := v.StreamVerifier.Verify()
+	v.i.emitVerification(v.component, "download", err)
+	return err
+}
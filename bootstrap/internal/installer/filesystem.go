@@ -0,0 +1,138 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// Install modes, see config.InstallMode
+const (
+	installModeSystem = "system"
+	installModeUser   = "user"
+	installModeSysext = "sysext"
+)
+
+// sysextRoot is the systemd system extension binaries are installed into
+// when /usr is read-only. systemd-sysext merges its usr tree over /usr.
+const sysextRoot = "/var/lib/extensions/ezra"
+
+// sysextRelease marks sysextRoot as an extension for any distribution
+const sysextRelease = "usr/lib/extension-release.d/extension-release.ezra"
+
+// installMode returns how binaries are installed. InstallPath is kept
+// unless it is read-only, or outside PATH on NixOS.
+func installMode(cfg *config.Config, info *detector.SystemInfo) (string, error) {
+	switch cfg.InstallMode {
+	case installModeSystem, installModeUser, installModeSysext:
+		return cfg.InstallMode, nil
+	case "", "auto":
+	default:
+		return "", fmt.Errorf("unknown install mode %q", cfg.InstallMode)
+	}
+
+	if info == nil || !installPathReadOnly(cfg, info) {
+		return installModeSystem, nil
+	}
+	if info.Filesystem.Sysext && contains(info.Capabilities, "root_access") {
+		return installModeSysext, nil
+	}
+	return installModeUser, nil
+}
+
+// installPathReadOnly reports whether binaries cannot, or should not, be
+// written to InstallPath
+func installPathReadOnly(cfg *config.Config, info *detector.SystemInfo) bool {
+	if disk := info.Disk(cfg.InstallPath); disk != nil && disk.ReadOnly {
+		return true
+	}
+	// NixOS only puts the Nix store on PATH
+	return info.Filesystem.Immutable == detector.ImmutableNixOS && strings.HasPrefix(cfg.InstallPath, "/usr/")
+}
+
+// relocateInstallPath points InstallPath at the directory the install
+// mode writes binaries to, and in user mode the data directories left at
+// their defaults at the user's, and returns the mode
+func relocateInstallPath(cfg *config.Config, info *detector.SystemInfo, log Logger) (string, error) {
+	mode, err := installMode(cfg, info)
+	if err != nil {
+		return "", err
+	}
+
+	path := cfg.InstallPath
+	switch mode {
+	case installModeUser:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot find a user-local install path: %w", err)
+		}
+		path = filepath.Join(home, ".local", "bin")
+		// The platform's data directories need administrator rights
+		data := cfg.DataPath
+		cfg.UseUserPaths()
+		if cfg.DataPath != data {
+			log.Infof("Keeping data in %s instead of %s (%s install mode)", cfg.DataPath, data, mode)
+		}
+	case installModeSysext:
+		path = filepath.Join(sysextRoot, "usr", "bin")
+	}
+
+	if path != cfg.InstallPath {
+		log.Infof("Installing binaries to %s instead of %s (%s install mode)", path, cfg.InstallPath, mode)
+		cfg.InstallPath = path
+	}
+	return mode, nil
+}
+
+// prepareInstallPath creates InstallPath, and for a system extension its
+// release file, so that binaries can be written
+func (i *Installer) prepareInstallPath() error {
+	if info := i.systemInfo; info != nil && info.Filesystem.RootType == "overlay" && !info.Environment.InContainer() {
+		i.log.Info("The root file system is an overlay: files outside persistent storage may be lost on reboot")
+	}
+
+	if err := i.mkdirAll(i.config.InstallPath, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", i.config.InstallPath, err)
+	}
+
+	switch i.installMode {
+	case installModeSysext:
+		release := filepath.Join(sysextRoot, sysextRelease)
+		if err := i.mkdirAll(filepath.Dir(release), 0755); err != nil {
+			return err
+		}
+		return i.writeFile(release, []byte("ID=_any\n"), 0644)
+	case installModeUser:
+		if !contains(filepath.SplitList(os.Getenv("PATH")), i.config.InstallPath) {
+			i.log.Infof("Add %s to PATH to run the Ezra commands", i.config.InstallPath)
+		}
+	}
+	return nil
+}
+
+// mergeSysext makes the binaries of the system extension appear in /usr
+func (i *Installer) mergeSysext() error {
+	if i.installMode != installModeSysext {
+		return nil
+	}
+	return i.runServiceCommand("systemd-sysext", "refresh")
+}
+
+// removeSysext deletes the system extension and unmerges it from /usr
+func (i *Installer) removeSysext(report *UninstallReport) error {
+	if i.installMode != installModeSysext {
+		return nil
+	}
+	if _, err := os.Stat(sysextRoot); err != nil {
+		return nil
+	}
+	if err := i.removeAll(sysextRoot); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", sysextRoot, err)
+	}
+	report.RemovedBinaries = append(report.RemovedBinaries, sysextRoot)
+	return i.runServiceCommand("systemd-sysext", "refresh")
+}
@@ -0,0 +1,165 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// hardwareRequirements lets an install manifest reserve a component for
+// devices that can run it
+type hardwareRequirements struct {
+	MinCores int `json:"min_cores,omitempty"`
+	// MinMemory is a size such as "1GiB"
+	MinMemory string `json:"min_memory,omitempty"`
+	// Media lists the storage media DataPath may be on, e.g. ["nvme",
+	// "ssd"]
+	Media []string `json:"media,omitempty"`
+}
+
+// checkHardware refuses to install on a device below the configured
+// minimums. Values the detector could not read are not checked.
+func (i *Installer) checkHardware() error {
+	hw := i.config.Hardware
+	info := i.systemInfo
+	if info == nil {
+		return nil
+	}
+
+	if hw.MinCores > 0 && info.CPU.Cores > 0 && info.CPU.Cores < hw.MinCores {
+		return fmt.Errorf("%d CPU cores, at least %d required", info.CPU.Cores, hw.MinCores)
+	}
+	if err := checkMemory(info, hw.MinMemory); err != nil {
+		return err
+	}
+
+	if hw.MinFreeDisk != "" {
+		minimum, err := downloader.ParseSize(hw.MinFreeDisk)
+		if err != nil {
+			return fmt.Errorf("invalid hardware min_free_disk: %w", err)
+		}
+		for _, disk := range info.Disks {
+			if disk.TotalBytes > 0 && disk.FreeBytes < uint64(minimum) {
+				return fmt.Errorf("%s free on %s, at least %s required", formatSize(disk.FreeBytes), disk.Mount, hw.MinFreeDisk)
+			}
+		}
+	}
+
+	if disk := info.Disk(i.config.DataPath); disk != nil && contains(hw.RefuseMedia, disk.Medium) {
+		return fmt.Errorf("refusing to install on %s storage (%s)", disk.Medium, disk.Mount)
+	}
+	return nil
+}
+
+// checkMemory fails if the device has less memory than minimum
+func checkMemory(info *detector.SystemInfo, minimum string) error {
+	if minimum == "" || info.MemoryBytes == 0 {
+		return nil
+	}
+	size, err := downloader.ParseSize(minimum)
+	if err != nil {
+		return fmt.Errorf("invalid minimum memory: %w", err)
+	}
+	if info.MemoryBytes < uint64(size) {
+		return fmt.Errorf("%s of memory, at least %s required", formatSize(info.MemoryBytes), minimum)
+	}
+	return nil
+}
+
+// fitsHardware reports why a component cannot run on this device, or nil
+func (i *Installer) fitsHardware(requires *hardwareRequirements) error {
+	info := i.systemInfo
+	if requires == nil || info == nil {
+		return nil
+	}
+
+	if requires.MinCores > 0 && info.CPU.Cores > 0 && info.CPU.Cores < requires.MinCores {
+		return fmt.Errorf("%d CPU cores, at least %d required", info.CPU.Cores, requires.MinCores)
+	}
+	if err := checkMemory(info, requires.MinMemory); err != nil {
+		return err
+	}
+	if len(requires.Media) > 0 {
+		disk := info.Disk(i.config.DataPath)
+		if disk != nil && disk.Medium != detector.MediumUnknown && !contains(requires.Media, disk.Medium) {
+			return fmt.Errorf("%s storage, requires %s", disk.Medium, strings.Join(requires.Media, " or "))
+		}
+	}
+	return nil
+}
+
+// selectManifestComponents drops the components of an install manifest
+// that this device cannot run, and the built-in components that were not
+// selected
+func (i *Installer) selectManifestComponents(manifest *installManifest) error {
+	var selected []manifestComponent
+	for _, component := range manifest.Components {
+		if contains(components, component.Name) && !i.selected(component.Name) {
+			i.log.Infof("Skipping %s: not selected", component.Name)
+			continue
+		}
+		if err := i.fitsHardware(component.Requires); err != nil {
+			i.log.Infof("Skipping %s: %v", component.Name, err)
+			continue
+		}
+		selected = append(selected, component)
+	}
+
+	if len(selected) == 0 {
+		return fmt.Errorf("no component of the install manifest fits this device")
+	}
+	manifest.Components = selected
+	return nil
+}
+
+// executorVariant picks the executor build: the configured one, or the
+// one matching the GPU runtime the detector found
+func executorVariant(cfg *config.Config, info *detector.SystemInfo) (string, error) {
+	switch cfg.ExecutorVariant {
+	case "cpu", detector.RuntimeCUDA, detector.RuntimeROCm:
+		return cfg.ExecutorVariant, nil
+	case "", "auto":
+		if info != nil {
+			if runtime := info.BestRuntime(); runtime != "" {
+				return runtime, nil
+			}
+		}
+		return "cpu", nil
+	default:
+		return "", fmt.Errorf("unknown executor variant %q", cfg.ExecutorVariant)
+	}
+}
+
+// downloadTarget describes the binaries this device can run. An
+// architecture set in the board settings wins over the detected one.
+func downloadTarget(cfg *config.Config, info *detector.SystemInfo) downloader.Target {
+	var target downloader.Target
+	if info != nil {
+		target = downloader.Target{
+			Arch:     info.CPU.Arch,
+			Libc:     info.Libc,
+			Features: info.CPU.Features,
+		}
+	}
+	if cfg.Board.Arch != "" {
+		target.Arch = cfg.Board.Arch
+	}
+	return target
+}
+
+// formatSize formats a byte count with a binary unit
+func formatSize(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,223 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// Readiness endpoints of the services
+const (
+	companionHealthPath = "/health"
+	agentStatusURL      = "http://agent/status"
+	agentSocketName     = "agent.sock"
+)
+
+// defaultReadyTimeout is how long a service has to become ready unless
+// configured
+const defaultReadyTimeout = 60 * time.Second
+
+// readyPollInterval is how often a starting service is asked again
+const readyPollInterval = 500 * time.Millisecond
+
+// serviceLogLines is how much of a failed service's output is shown
+const serviceLogLines = 20
+
+// serviceHealth is what the companion's health endpoint and the agent's
+// status socket report
+type serviceHealth struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+// ready reports whether a service says it is ready
+func (h serviceHealth) ready() bool {
+	return h.Status == "ok" || h.Status == "ready"
+}
+
+// agentSocket returns the path of the agent's status socket
+func (i *Installer) agentSocket() string {
+	if i.config.HealthCheck.AgentSocket != "" {
+		return i.config.HealthCheck.AgentSocket
+	}
+	return filepath.Join(i.config.DataPath, agentSocketName)
+}
+
+// waitForCompanion waits until the local companion answers its health
+// endpoint with the version that was installed
+func (i *Installer) waitForCompanion() error {
+	err := i.waitReady("companion", i.companionProbe())
+	if err != nil {
+		i.logServiceOutput("ezra-companion")
+	}
+	return err
+}
+
+// waitForAgent waits until the agent answers on its status socket with
+// the version that was installed
+func (i *Installer) waitForAgent() error {
+	err := i.waitReady("agent", i.agentProbe())
+	if err != nil {
+		i.logServiceOutput("ezra-agent")
+	}
+	return err
+}
+
+// companionProbe asks the local companion's health endpoint for its state
+func (i *Installer) companionProbe() func(ctx context.Context) (serviceHealth, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	url := strings.TrimSuffix(i.companionURL(), "/") + companionHealthPath
+	return func(ctx context.Context) (serviceHealth, error) {
+		return getHealth(ctx, client, url)
+	}
+}
+
+// agentProbe asks the agent's status socket for its state
+func (i *Installer) agentProbe() func(ctx context.Context) (serviceHealth, error) {
+	socket := i.agentSocket()
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	return func(ctx context.Context) (serviceHealth, error) {
+		return getHealth(ctx, client, agentStatusURL)
+	}
+}
+
+// waitReady polls a service until it reports ready or the timeout runs
+// out. A service reporting a version other than the installed one fails
+// at once.
+func (i *Installer) waitReady(component string, probe func(ctx context.Context) (serviceHealth, error)) error {
+	if i.dryRun || i.config.HealthCheck.Disabled {
+		return nil
+	}
+
+	timeout := defaultReadyTimeout
+	if i.config.HealthCheck.TimeoutSec > 0 {
+		timeout = time.Duration(i.config.HealthCheck.TimeoutSec) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(i.ctx, timeout)
+	defer cancel()
+
+	i.componentLog(component).Infof("Waiting for the %s to become ready...", component)
+	started := time.Now()
+	var last error
+	for {
+		health, err := probe(ctx)
+		switch {
+		case err != nil:
+			last = err
+		case !health.ready():
+			last = fmt.Errorf("status %q", health.Status)
+		default:
+			expected := i.expectedVersions[component]
+			if expected != "" && health.Version != "" && normalizeVersion(health.Version) != normalizeVersion(expected) {
+				return fmt.Errorf("%s reports version %s, but %s was installed", component, health.Version, expected)
+			}
+			i.logWith(logrus.Fields{
+				logger.FieldComponent:  component,
+				logger.FieldDurationMS: time.Since(started).Milliseconds(),
+			}).Infof("The %s is ready", component)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := i.ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s not ready after %s: %v", component, timeout, last)
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+// getHealth asks a readiness endpoint for the state of a service
+func getHealth(ctx context.Context, client *http.Client, url string) (serviceHealth, error) {
+	var health serviceHealth
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return health, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return health, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return health, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return health, fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	return health, nil
+}
+
+// normalizeVersion drops the "v" prefix some versions are reported with
+func normalizeVersion(version string) string {
+	return strings.TrimPrefix(strings.TrimSpace(version), "v")
+}
+
+// logServiceOutput logs the recent output of a service that failed to
+// start or become ready
+func (i *Installer) logServiceOutput(name string) {
+	out, err := i.serviceOutput(name, serviceLogLines)
+	if err != nil {
+		i.log.Errorf("Cannot read the output of %s: %v", name, err)
+		return
+	}
+	if text := string(out); text != "" {
+		i.log.Errorf("Recent output of %s:\n%s", name, text)
+	}
+}
+
+// serviceOutput returns the last lines of a service's output: from the
+// journal under systemd, or from the log file launchd writes. Other
+// service managers keep no output, which is returned empty.
+func (i *Installer) serviceOutput(name string, lines int) ([]byte, error) {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	switch supervisor {
+	case supervisorSystemd:
+		args := []string{"-u", name, "-n", strconv.Itoa(lines), "--no-pager", "-o", "cat"}
+		if i.userMode() {
+			args = append([]string{"--user"}, args...)
+		}
+		out, err = i.combinedOutput(exec.Command("journalctl", args...))
+	case supervisorLaunchd:
+		out, err = os.ReadFile(filepath.Join(i.config.DataPath, name+".log"))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tail := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(tail) > lines {
+		tail = tail[len(tail)-lines:]
+	}
+	return []byte(strings.Join(tail, "\n")), nil
+}
@@ -0,0 +1,230 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/config"
+)
+
+// Hooks operators can run scripts at
+const (
+	hookPreInstall  = "pre-install"
+	hookPostInstall = "post-install"
+	hookPreStart    = "pre-start"
+	hookPostStart   = "post-start"
+)
+
+// Failure policies of hook scripts, see config.HookPolicyConfig
+const (
+	hookAbort    = "abort"
+	hookContinue = "continue"
+)
+
+// defaultHookTimeout is how long a hook script may run unless configured
+const defaultHookTimeout = 300 * time.Second
+
+// hookScript is a script to run at a hook
+type hookScript struct {
+	path   string
+	args   []string
+	policy config.HookPolicyConfig
+}
+
+// withInstallHooks runs the pre-install and post-install hooks around an
+// install
+func (i *Installer) withInstallHooks(install func() error) func() error {
+	return func() error {
+		if err := i.runHook(hookPreInstall); err != nil {
+			return err
+		}
+		if err := install(); err != nil {
+			return err
+		}
+		return i.runHook(hookPostInstall)
+	}
+}
+
+// withStartHooks runs the pre-start and post-start hooks around the
+// start of the services
+func (i *Installer) withStartHooks(start func() error) func() error {
+	return func() error {
+		if err := i.runHook(hookPreStart); err != nil {
+			return err
+		}
+		if err := start(); err != nil {
+			return err
+		}
+		return i.runHook(hookPostStart)
+	}
+}
+
+// hooksDir returns the directory hook scripts are discovered in
+func (i *Installer) hooksDir() string {
+	switch {
+	case i.config.Hooks.Dir != "":
+		return i.config.Hooks.Dir
+	case i.userMode():
+		return filepath.Join(i.config.DataPath, "hooks.d")
+	case runtime.GOOS == "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "Ezra", "hooks.d")
+	default:
+		return "/etc/ezra/hooks.d"
+	}
+}
+
+// hookScripts lists the scripts of a hook in the order they run: the
+// script named after the hook, those in its .d directory by name, then
+// those listed in the configuration
+func (i *Installer) hookScripts(hook string) ([]hookScript, error) {
+	policy := i.config.Hooks.Policies[hook]
+	dir := i.hooksDir()
+
+	var paths []string
+	if _, err := os.Stat(filepath.Join(dir, hook)); err == nil {
+		paths = append(paths, filepath.Join(dir, hook))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, hook+".d"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		// Editor backups and disabled scripts are left out
+		if name := entry.Name(); !entry.IsDir() && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "~") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dir, hook+".d", name))
+	}
+
+	var scripts []hookScript
+	for _, path := range paths {
+		if !isExecutable(path) {
+			i.log.Infof("Skipping hook script %s: not executable", path)
+			continue
+		}
+		scripts = append(scripts, hookScript{path: path, policy: policy})
+	}
+
+	for _, listed := range i.config.Hooks.Scripts {
+		if listed.Hook != hook {
+			continue
+		}
+		script := hookScript{path: listed.Path, args: listed.Args, policy: policy}
+		if listed.TimeoutSec != 0 {
+			script.policy.TimeoutSec = listed.TimeoutSec
+		}
+		if listed.OnFailure != "" {
+			script.policy.OnFailure = listed.OnFailure
+		}
+		scripts = append(scripts, script)
+	}
+
+	for _, script := range scripts {
+		switch script.policy.OnFailure {
+		case "", hookAbort, hookContinue:
+		default:
+			return nil, fmt.Errorf("unknown on_failure policy %q for %s", script.policy.OnFailure, script.path)
+		}
+		// Scripts run with the bootstrap's rights, so nobody else may be
+		// able to change them
+		if err := checkHookOwner(script.path); err != nil {
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
+
+// runHook runs the scripts of a hook. A failing script fails the hook
+// unless its policy is to continue.
+func (i *Installer) runHook(hook string) error {
+	scripts, err := i.hookScripts(hook)
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", hook, err)
+	}
+
+	for _, script := range scripts {
+		if i.dryRun {
+			i.plan.addCommand(hook + " hook: " + shellCommand(append([]string{script.path}, script.args...)))
+			continue
+		}
+
+		i.log.Infof("Running %s hook %s...", hook, script.path)
+		if err := i.runHookScript(hook, script); err != nil {
+			if script.policy.OnFailure == hookContinue {
+				i.log.Errorf("%s hook %s failed, continuing: %v", hook, script.path, err)
+				continue
+			}
+			return fmt.Errorf("%s hook %s failed: %w", hook, script.path, err)
+		}
+	}
+	return nil
+}
+
+// runHookScript runs a hook script with the install context in its
+// environment and logs its output
+func (i *Installer) runHookScript(hook string, script hookScript) error {
+	timeout := defaultHookTimeout
+	if script.policy.TimeoutSec > 0 {
+		timeout = time.Duration(script.policy.TimeoutSec) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(i.ctx, timeout)
+	defer cancel()
+
+	args := hookCommand(script.path, script.args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = i.config.DataPath
+	cmd.Env = append(os.Environ(), i.hookEnv(hook)...)
+	// Children left running must not hold the output open past the timeout
+	cmd.WaitDelay = 5 * time.Second
+
+	out, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			i.log.Infof("[%s] %s", filepath.Base(script.path), line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// hookEnv describes the install to hook scripts
+func (i *Installer) hookEnv(hook string) []string {
+	supervisor, _ := i.supervisor()
+	return []string{
+		"EZRA_HOOK=" + hook,
+		"EZRA_DEVICE_ID=" + i.config.DeviceID,
+		"EZRA_COMPANION_URL=" + i.companionURL(),
+		"EZRA_COMPANION_PORT=" + strconv.Itoa(i.companionPort),
+		"EZRA_INSTALL_PATH=" + i.config.InstallPath,
+		"EZRA_DATA_PATH=" + i.config.DataPath,
+		"EZRA_CACHE_PATH=" + i.config.CachePath,
+		"EZRA_BACKUP_PATH=" + i.config.BackupPath,
+		"EZRA_INSTALL_MODE=" + i.installMode,
+		"EZRA_SUPERVISOR=" + supervisor,
+		"EZRA_SERVICE_USER=" + i.serviceUser(),
+	}
+}
+
+// hookCommand returns the command line that runs a hook script. Windows
+// runs PowerShell scripts through PowerShell and the rest directly.
+func hookCommand(path string, args []string) []string {
+	if runtime.GOOS == "windows" && strings.EqualFold(filepath.Ext(path), ".ps1") {
+		return append([]string{"powershell", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", path}, args...)
+	}
+	return append([]string{path}, args...)
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package installer
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// isExecutable reports whether path is a file with an execute bit set
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// checkHookOwner refuses a hook script that another user could change:
+// it must belong to root or the user running the bootstrap and must not
+// be writable by its group or others
+func checkHookOwner(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by other users", path)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s belongs to another user", path)
+	}
+	return nil
+}
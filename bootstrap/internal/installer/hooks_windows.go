@@ -0,0 +1,29 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isExecutable reports whether path is a file Windows can run, or a
+// PowerShell script
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".exe", ".cmd", ".bat", ".ps1":
+		return true
+	default:
+		return false
+	}
+}
+
+// checkHookOwner only checks that the hook script exists: the hooks
+// directory is expected to be protected by its ACL
+func checkHookOwner(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
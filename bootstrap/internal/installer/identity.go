@@ -0,0 +1,85 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/identity"
+)
+
+// TPM identity modes
+const (
+	tpmModeOff      = "off"
+	tpmModeAuto     = "auto"
+	tpmModeRequired = "required"
+)
+
+// tpmOptions returns where the configured identity lives in the TPM
+func (i *Installer) tpmOptions() identity.Options {
+	return identity.Options{
+		Device:   i.config.TPM.Device,
+		AKHandle: i.config.TPM.AKHandle,
+		IDIndex:  i.config.TPM.IDIndex,
+	}
+}
+
+// setupDeviceIdentity moves the device identity into the TPM when one is
+// used: the device ID is read from the TPM, or written there from the
+// configuration on first install, and the attestation key is created.
+// Kept outside the journal: the TPM index is locked once written and a
+// rollback must not lose the identity the companion knows the device by.
+func (i *Installer) setupDeviceIdentity() error {
+	switch i.config.TPM.Mode {
+	case "", tpmModeOff:
+		return nil
+	case tpmModeAuto, tpmModeRequired:
+	default:
+		return fmt.Errorf("invalid tpm mode %q", i.config.TPM.Mode)
+	}
+
+	if i.dryRun {
+		i.plan.addCommand("store device identity in the TPM")
+		i.useTPM = true
+		return nil
+	}
+
+	tpm, err := identity.Open(i.tpmOptions())
+	if errors.Is(err, identity.ErrNoTPM) && i.config.TPM.Mode == tpmModeAuto {
+		i.log.Info("No TPM 2.0 found, keeping the device identity in the configuration")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open TPM: %w", err)
+	}
+	defer tpm.Close()
+
+	deviceID, err := tpm.DeviceID(i.config.DeviceID)
+	if err != nil {
+		return err
+	}
+	if _, err := tpm.AttestationKey(); err != nil {
+		return err
+	}
+
+	if deviceID != i.config.DeviceID {
+		i.log.Infof("Using device ID %s from the TPM", deviceID)
+	}
+	i.config.DeviceID = deviceID
+	i.setLogField(logger.FieldDeviceID, deviceID)
+	i.useTPM = true
+	return nil
+}
+
+// saveDeviceID keeps the device ID under DataPath, so that later runs
+// use it even where it is neither configured nor derivable from the
+// machine. Like the TPM identity it is kept outside the journal.
+func (i *Installer) saveDeviceID() error {
+	if i.dryRun {
+		i.plan.addFile(filepath.Join(i.config.DataPath, config.DeviceIDFile))
+		return nil
+	}
+	return i.config.SaveDeviceID()
+}
@@ -0,0 +1,310 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+)
+
+// Where init scripts and runit service directories are installed
+const (
+	initScriptDir   = "/etc/init.d"
+	runitServiceDir = "/etc/sv"
+)
+
+// runitServiceDirs are the directories runsvdir watches, by distribution
+var runitServiceDirs = []string{"/etc/service", "/var/service", "/etc/runit/runsvdir/default"}
+
+// serviceSpec describes a service for an init system. The command must
+// stay in the foreground.
+type serviceSpec struct {
+	Name        string
+	Description string
+	Command     []string
+	User        string
+	// Restart is the systemd restart policy, "always" when empty
+	Restart string
+}
+
+// agentService describes the agent service
+func (i *Installer) agentService() serviceSpec {
+	return serviceSpec{
+		Name:        "ezra-agent",
+		Description: "Ezra Agent",
+		Command:     []string{filepath.Join(i.config.InstallPath, binaryName("agent")), "start"},
+		User:        i.serviceUser(),
+	}
+}
+
+// companionService describes the companion service
+func (i *Installer) companionService() serviceSpec {
+	return serviceSpec{
+		Name:        "ezra-companion",
+		Description: "Ezra Companion",
+		Command: []string{filepath.Join(i.config.InstallPath, binaryName("companion")), "start",
+			"--port", strconv.Itoa(i.companionPort)},
+		User: i.serviceUser(),
+	}
+}
+
+// services describes the services of the selected components
+func (i *Installer) services() []serviceSpec {
+	var specs []serviceSpec
+	if i.selected("companion") {
+		specs = append(specs, i.companionService())
+	}
+	if i.selected("agent") {
+		specs = append(specs, i.agentService())
+	}
+	return specs
+}
+
+// setupInitService registers a service with OpenRC, runit or a SysV init
+func (i *Installer) setupInitService(supervisor string, spec serviceSpec) error {
+	if i.dryRun {
+		i.plan.addService(spec.Name + " (" + supervisor + ")")
+	}
+
+	switch supervisor {
+	case supervisorOpenRC:
+		return i.setupOpenRCService(spec)
+	case supervisorRunit:
+		return i.setupRunitService(spec)
+	case supervisorSysV:
+		return i.setupSysVService(spec)
+	default:
+		return failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("%s services are not supported", supervisor))
+	}
+}
+
+// setupOpenRCService writes an OpenRC script that runs the service under
+// supervise-daemon, which restarts it when it exits
+func (i *Installer) setupOpenRCService(spec serviceSpec) error {
+	script, err := i.renderService(supervisorOpenRC, spec)
+	if err != nil {
+		return err
+	}
+	if err := i.writeFile(filepath.Join(initScriptDir, spec.Name), script, 0755); err != nil {
+		return err
+	}
+	return i.runServiceCommand("rc-update", "add", spec.Name, "default")
+}
+
+// setupRunitService writes a runit service directory and links it into
+// the directory runsvdir watches, which starts the service. runsv
+// restarts it when it exits, after the finish script's delay.
+func (i *Installer) setupRunitService(spec serviceSpec) error {
+	dir := filepath.Join(runitServiceDir, spec.Name)
+	if err := i.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	script, err := i.renderService(supervisorRunit, spec)
+	if err != nil {
+		return err
+	}
+	if err := i.writeFile(filepath.Join(dir, "run"), script, 0755); err != nil {
+		return err
+	}
+	finish := fmt.Sprintf("#!/bin/sh\n# Wait before runsv restarts the service\nsleep %d\n", serviceRestartSec)
+	if err := i.writeFile(filepath.Join(dir, "finish"), []byte(finish), 0755); err != nil {
+		return err
+	}
+
+	for _, serviceDir := range runitServiceDirs {
+		if _, err := os.Stat(serviceDir); err != nil {
+			continue
+		}
+		link := filepath.Join(serviceDir, spec.Name)
+		if _, err := os.Lstat(link); err == nil {
+			return nil
+		}
+		return i.symlink(dir, link)
+	}
+	return fmt.Errorf("no runsvdir service directory found")
+}
+
+// setupSysVService writes an LSB init script and enables it in the
+// default runlevels. SysV init does not restart services, so the script
+// runs the service in a loop that does.
+func (i *Installer) setupSysVService(spec serviceSpec) error {
+	script, err := i.renderService(supervisorSysV, spec)
+	if err != nil {
+		return err
+	}
+	if err := i.writeFile(filepath.Join(initScriptDir, spec.Name), script, 0755); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		return i.runServiceCommand("update-rc.d", spec.Name, "defaults")
+	}
+	return i.runServiceCommand("chkconfig", "--add", spec.Name)
+}
+
+// controlService starts, stops or restarts a registered service through
+// its init system. It reports false if the service is not registered.
+func (i *Installer) controlService(name, action string) (bool, error) {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return false, err
+	}
+
+	var cmd *exec.Cmd
+	switch supervisor {
+	case supervisorSCM:
+		return controlWindowsService(name, action)
+	case supervisorLaunchd:
+		return i.controlLaunchdService(name, action)
+	case supervisorTask:
+		return i.controlUserTask(name, action)
+	case supervisorSystemd:
+		dir, err := i.systemdUnitDir()
+		if err != nil {
+			return false, err
+		}
+		if !fileExists(filepath.Join(dir, name+".service")) {
+			return false, nil
+		}
+		cmd = i.systemctl(action, name)
+	case supervisorOpenRC:
+		if !fileExists(filepath.Join(initScriptDir, name)) {
+			return false, nil
+		}
+		cmd = exec.Command("rc-service", name, action)
+	case supervisorRunit:
+		if !fileExists(filepath.Join(runitServiceDir, name)) {
+			return false, nil
+		}
+		cmd = exec.Command("sv", action, name)
+	case supervisorSysV:
+		if !fileExists(filepath.Join(initScriptDir, name)) {
+			return false, nil
+		}
+		cmd = exec.Command(filepath.Join(initScriptDir, name), action)
+	default:
+		return false, nil
+	}
+
+	if _, err := i.combinedOutput(cmd); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// startManagedService starts a service through the service manager it
+// is registered with, which owns its process from then on, rather than
+// the installer starting it directly. A failure comes with the service's
+// recent log output. It reports false if the service is not registered.
+func (i *Installer) startManagedService(name string) (bool, error) {
+	if i.dryRun {
+		return false, nil
+	}
+
+	registered, err := i.controlService(name, "start")
+	if err != nil {
+		i.logServiceOutput(name)
+		return true, err
+	}
+	if registered && i.journal != nil {
+		i.journal.recordStartService(name, func() error {
+			_, err := i.controlService(name, "stop")
+			return err
+		})
+	}
+	return registered, nil
+}
+
+// removeInitService unregisters a service from OpenRC, runit or a SysV
+// init and removes its files
+func (i *Installer) removeInitService(supervisor, name string, report *UninstallReport) error {
+	// Nothing to unregister for a service that was never installed
+	if supervisor != supervisorRunit && !fileExists(filepath.Join(initScriptDir, name)) {
+		return nil
+	}
+
+	var paths []string
+	switch supervisor {
+	case supervisorOpenRC:
+		if _, err := i.combinedOutput(exec.Command("rc-update", "del", name, "default")); err != nil {
+			i.log.Errorf("Failed to disable %s: %v", name, err)
+		}
+		paths = []string{filepath.Join(initScriptDir, name)}
+	case supervisorRunit:
+		for _, serviceDir := range runitServiceDirs {
+			paths = append(paths, filepath.Join(serviceDir, name))
+		}
+		paths = append(paths, filepath.Join(runitServiceDir, name))
+	case supervisorSysV:
+		var err error
+		if _, lookErr := exec.LookPath("update-rc.d"); lookErr == nil {
+			_, err = i.combinedOutput(exec.Command("update-rc.d", "-f", name, "remove"))
+		} else {
+			_, err = i.combinedOutput(exec.Command("chkconfig", "--del", name))
+		}
+		if err != nil {
+			i.log.Errorf("Failed to disable %s: %v", name, err)
+		}
+		paths = []string{filepath.Join(initScriptDir, name)}
+	}
+
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if err := i.removeAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		report.RemovedUnits = append(report.RemovedUnits, path)
+	}
+	return nil
+}
+
+// runServiceCommand runs an init system command, or plans it in a dry run
+func (i *Installer) runServiceCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if i.dryRun {
+		i.plan.addCommand(cmd.String())
+		return nil
+	}
+	_, err := i.combinedOutput(cmd)
+	return err
+}
+
+// symlink creates a symbolic link, journaling it like a created file
+func (i *Installer) symlink(target, link string) error {
+	if i.dryRun {
+		i.plan.addFile(link)
+		return nil
+	}
+	if i.needsElevation(link) {
+		if _, err := i.runElevated("ln", "-s", target, link); err != nil {
+			return err
+		}
+	} else if err := os.Symlink(target, link); err != nil {
+		return err
+	}
+	if i.journal != nil {
+		i.journal.recordCreateFile(link)
+	}
+	return nil
+}
+
+// shellCommand quotes a command line for a POSIX shell
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
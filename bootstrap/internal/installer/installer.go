@@ -1,18 +1,96 @@
 package installer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/internal/events"
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/companion"
+	"github.com/ezra/bootstrap/pkg/copier"
 	"github.com/ezra/bootstrap/pkg/detector"
 	"github.com/ezra/bootstrap/pkg/downloader"
 	"github.com/ezra/bootstrap/pkg/verifier"
 )
 
+// systemdServiceFile is where the agent's systemd unit is installed
+const systemdServiceFile = "/etc/systemd/system/ezra-agent.service"
+
+// components lists the Ezra components managed by the installer
+var components = []string{
+	"companion",
+	"agent",
+	"executor",
+}
+
+// selectComponents returns the components to install, in the order of
+// components: those listed, or all of them when none are
+func selectComponents(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return components, nil
+	}
+	for _, name := range names {
+		if !contains(components, name) {
+			return nil, fmt.Errorf("unknown component %q: expected %s", name, strings.Join(components, ", "))
+		}
+	}
+
+	var selected []string
+	for _, component := range components {
+		if contains(names, component) {
+			selected = append(selected, component)
+		}
+	}
+	return selected, nil
+}
+
+// SetComponents selects the components to install, overriding
+// install_components
+func (i *Installer) SetComponents(names []string) error {
+	selected, err := selectComponents(names)
+	if err != nil {
+		return err
+	}
+	i.config.InstallComponents = names
+	i.components = selected
+	return nil
+}
+
+// SetContext sets the context that cancels the run, e.g. on SIGINT or
+// SIGTERM. Cancelling it aborts the downloads in flight and the phase
+// that is running, and the install is rolled back like a failed one,
+// keeping completed downloads and the install state for a resumed run.
+func (i *Installer) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
+// selected reports whether a component is selected for installation
+func (i *Installer) selected(component string) bool {
+	return contains(i.components, component)
+}
+
+// binaryName returns the executable name of a component
+func binaryName(component string) string {
+	name := "ezra-" + component
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
 // Installer handles the installation process
 type Installer struct {
 	config     *config.Config
@@ -20,6 +98,69 @@ type Installer struct {
 	log        Logger
 	downloader *downloader.Downloader
 	verifier   *verifier.Verifier
+	journal    *journal
+	dryRun     bool
+	plan       *Plan
+	state      *installState
+	report     *InstallReport
+	sbomPolicy SBOMPolicy
+	// useTPM is set once the device identity is kept in the TPM
+	useTPM bool
+	// confirmKey confirms a companion key before it is pinned, and
+	// keyPinned is set once one was pinned by this run
+	confirmKey KeyConfirmation
+	keyPinned  bool
+	// installMode is how binaries are installed, see config.InstallMode
+	installMode string
+	// companionPort is the port the companion is started on, once chosen
+	companionPort int
+	// elevator runs the steps that need root, once one does
+	elevator *elevator
+	// resolveConflict asks whether to overwrite a conflicting file
+	resolveConflict ConflictResolver
+	// deployed caches the checksums of the files installations wrote
+	deployed map[string]string
+	// servicePassword is the password of the Windows service account,
+	// reset on every install so services can be registered with it
+	servicePassword string
+	// steps are the custom install steps by the phase they follow
+	steps map[string][]Step
+	// components are the components selected for installation
+	components []string
+	// expectedVersions are the installed versions the services must
+	// report, by component, when they are known
+	expectedVersions map[string]string
+	// heldVersions are the versions the update daemon rolled back, by
+	// component, which its upgrades skip
+	heldVersions map[string]string
+	// logFields is the logger when it has structured output, see logWith
+	logFields fieldLogger
+	// sessionID identifies this run in its log entries and report
+	sessionID string
+
+	// bootstrapVersion is the version of the running bootstrap, see
+	// SetBootstrapVersion
+	bootstrapVersion string
+	// ctx cancels the run, see SetContext
+	ctx context.Context
+	// mediaPath is the offline media in use, once found or given
+	mediaPath string
+	// mediaVersions are the versions of the components on the offline
+	// media, once verified
+	mediaVersions map[string]string
+	// mediaFiles are the SHA-256 of the files on the offline media by
+	// their path below the components directory, once its manifest is
+	// verified
+	mediaFiles map[string]string
+	// chooseMedia asks which offline media to use when several are found
+	chooseMedia MediaChooser
+	// tuf is the verified TUF repository, once set up
+	tuf *verifier.TUFRepository
+	// companion calls the companion's API with the downloader's proxy
+	// and TLS settings
+	companion *companion.Client
+	// events streams progress to wrapper tooling, see SetEvents
+	events *events.Stream
 }
 
 // Logger interface for logging
@@ -32,72 +173,328 @@ type Logger interface {
 
 // New creates a new installer instance
 func New(cfg *config.Config, systemInfo *detector.SystemInfo, log Logger) (*Installer, error) {
+	mode, err := relocateInstallPath(cfg, systemInfo, log)
+	if err != nil {
+		return nil, err
+	}
+
 	downloader := downloader.New(cfg.CompanionURL, log)
-	verifier := verifier.New(cfg.PublicKey, log)
+	downloader.SetConcurrency(cfg.DownloadConcurrency)
+	downloader.SetMirrors(cfg.Mirrors)
+	downloader.SetRetryPolicy(retryPolicy(cfg.Retry))
+	downloader.SetOCIOptions(ociOptions(cfg.OCI))
+	downloader.SetVersions(cfg.Channel, cfg.Components)
+	downloader.SetRolloutTarget(rolloutTarget(cfg, false))
+	variant, err := executorVariant(cfg, systemInfo)
+	if err != nil {
+		return nil, err
+	}
+	downloader.SetVariant("executor", variant)
+	downloader.SetTarget(downloadTarget(cfg, systemInfo))
+	if variant != "cpu" {
+		log.Infof("Using the %s build of the executor", variant)
+	}
+	if err := downloader.SetProxy(proxyOptions(cfg)); err != nil {
+		return nil, fmt.Errorf("failed to configure proxy: %w", err)
+	}
+	if err := downloader.SetTLS(tlsOptions(cfg)); err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	if err := configureSchedule(downloader, cfg); err != nil {
+		return nil, err
+	}
+	if err := configureCache(downloader, cfg); err != nil {
+		return nil, err
+	}
+	if err := configureProgress(downloader, cfg, log); err != nil {
+		return nil, err
+	}
+	if err := checkSlots(cfg.Slots.Enabled); err != nil {
+		return nil, err
+	}
+	verifier := NewVerifier(cfg, log)
+	selected, err := selectComponents(cfg.InstallComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	client := companion.New(cfg.CompanionURL, companion.Options{
+		HTTPClient: downloader.HTTPClient(),
+		Retry:      retryPolicy(cfg.Retry),
+		Log:        log,
+	})
+
+	report := newInstallReport()
+	i := &Installer{
+		config:      cfg,
+		systemInfo:  systemInfo,
+		log:         warningLog{Logger: log, report: report},
+		downloader:  downloader,
+		companion:   client,
+		verifier:    verifier,
+		report:      report,
+		ctx:         context.Background(),
+		installMode: mode,
+		components:  selected,
+		sessionID:   newSessionID(),
+	}
+	downloader.SetGitHubOptions(i.gitHubOptions())
+	if fields, ok := log.(fieldLogger); ok {
+		i.logFields = fields
+		i.setLogField(logger.FieldDeviceID, cfg.DeviceID)
+		i.setLogField(logger.FieldSessionID, i.sessionID)
+	}
+
+	return i, nil
+}
+
+// NewVerifier creates a verifier for the configured signature type
+func NewVerifier(cfg *config.Config, log Logger) *verifier.Verifier {
+	v := verifier.New(cfg.PublicKey, log)
+	if cfg.SignatureType != "" {
+		v.SetSignatureType(cfg.SignatureType)
+	}
+	v.SetCosign(verifier.CosignOptions{
+		FulcioRoots:    cfg.Cosign.FulcioRoots,
+		RekorPublicKey: cfg.Cosign.RekorPublicKey,
+		Identity:       cfg.Cosign.Identity,
+		IdentityRegexp: cfg.Cosign.IdentityRegexp,
+		Issuer:         cfg.Cosign.Issuer,
+	})
+	if cfg.ChecksumAlgorithm != "" {
+		v.SetChecksumAlgorithm(cfg.ChecksumAlgorithm)
+	}
+	v.SetPGP(verifier.PGPOptions{
+		Keys:    cfg.PGP.Keys,
+		Keyring: cfg.PGP.Keyring,
+	})
+
+	var keys []verifier.TrustedKey
+	for _, key := range cfg.TrustedKeys {
+		keys = append(keys, verifier.TrustedKey{
+			ID:        key.ID,
+			PublicKey: key.PublicKey,
+			NotBefore: key.NotBefore,
+			NotAfter:  key.NotAfter,
+		})
+	}
+	v.SetTrustedKeys(keys)
+	return v
+}
+
+// retryPolicy converts the configured retry settings for the downloader
+func retryPolicy(cfg config.RetryConfig) downloader.RetryPolicy {
+	return downloader.RetryPolicy{
+		MaxAttempts:     cfg.MaxAttempts,
+		BaseDelay:       time.Duration(cfg.BaseDelayMs) * time.Millisecond,
+		MaxDelay:        time.Duration(cfg.MaxDelayMs) * time.Millisecond,
+		Jitter:          cfg.Jitter,
+		RetryableStatus: cfg.RetryableStatus,
+	}
+}
+
+// ociOptions converts the configured registry settings for the downloader
+func ociOptions(cfg config.OCIConfig) downloader.OCIOptions {
+	return downloader.OCIOptions{
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Tag:       cfg.Tag,
+		Digests:   cfg.Digests,
+		PlainHTTP: cfg.PlainHTTP,
+	}
+}
+
+// configureSchedule applies the configured bandwidth limit and download
+// window
+func configureSchedule(d *downloader.Downloader, cfg *config.Config) error {
+	if cfg.MaxDownloadRate != "" {
+		rate, err := downloader.ParseRate(cfg.MaxDownloadRate)
+		if err != nil {
+			return fmt.Errorf("invalid max_download_rate: %w", err)
+		}
+		d.SetRateLimit(rate)
+	}
+
+	if cfg.DownloadWindow != "" {
+		window, err := downloader.ParseWindow(cfg.DownloadWindow)
+		if err != nil {
+			return fmt.Errorf("invalid download_window: %w", err)
+		}
+		d.SetDownloadWindow(&window)
+	}
+
+	return nil
+}
 
-	return &Installer{
-		config:     cfg,
-		systemInfo: systemInfo,
-		log:        log,
-		downloader: downloader,
-		verifier:   verifier,
-	}, nil
+// configureProgress selects how download progress is reported
+func configureProgress(d *downloader.Downloader, cfg *config.Config, log Logger) error {
+	progress, err := downloader.NewProgress(cfg.Progress, log)
+	if err != nil {
+		return fmt.Errorf("invalid progress: %w", err)
+	}
+	d.SetProgress(progress)
+	return nil
+}
+
+// proxyOptions converts the configured proxy settings for the downloader
+func proxyOptions(cfg *config.Config) downloader.ProxyOptions {
+	return downloader.ProxyOptions{
+		URL:     cfg.ProxyURL,
+		NoProxy: cfg.NoProxy,
+	}
+}
+
+// tlsOptions converts the configured TLS settings for the downloader
+func tlsOptions(cfg *config.Config) downloader.TLSOptions {
+	return downloader.TLSOptions{
+		CACert:             cfg.CACert,
+		ClientCert:         cfg.ClientCert,
+		ClientKey:          cfg.ClientKey,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+}
+
+// gitHubOptions converts the configured GitHub settings for the
+// downloader. Assets are checked the same way as manifest entries.
+func (i *Installer) gitHubOptions() downloader.GitHubOptions {
+	return downloader.GitHubOptions{
+		Token:       i.config.GitHub.Token,
+		APIURL:      i.config.GitHub.APIURL,
+		Channel:     i.config.Channel,
+		ChannelTags: i.config.GitHub.ChannelTags,
+		Verify: func(checksum, signature string) downloader.StreamVerifier {
+			return i.streamVerifier(downloader.ComponentManifest{SHA256: checksum, Signature: signature})
+		},
+	}
 }
 
-// InstallOnline installs Ezra in online mode
+// InstallOnline installs Ezra in online mode. Any failure rolls back the
+// steps that already completed.
 func (i *Installer) InstallOnline() error {
+	started := time.Now()
+	i.beginState("online")
+	err := i.transaction(i.withInstallHooks(i.installOnline))
+	i.finishState(err)
+	i.finishReport("online", started, err)
+	return err
+}
+
+func (i *Installer) installOnline() error {
 	i.log.Info("Starting online installation...")
 
+	if err := i.checkHardware(); err != nil {
+		return failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("hardware check failed: %w", err))
+	}
+
+	if err := i.pinCompanionKey(); err != nil {
+		return fmt.Errorf("failed to pin companion key: %w", err)
+	}
+	if err := i.setupTrustedKeys(); err != nil {
+		return fmt.Errorf("failed to update trusted keys: %w", err)
+	}
+	if err := i.checkPinnedKey(); err != nil {
+		return err
+	}
+
+	// A published install manifest describes the whole installation
+	manifest, err := i.fetchInstallManifest()
+	if err != nil {
+		return fmt.Errorf("failed to fetch install manifest: %w", err)
+	}
+	if manifest != nil {
+		return i.installFromManifest(manifest)
+	}
+
 	// Download components
-	if err := i.downloadComponents(); err != nil {
+	if err := i.runPhase(phaseDownload, i.downloadComponents); err != nil {
 		return fmt.Errorf("failed to download components: %w", err)
 	}
 
-	// Install components
-	if err := i.installComponents(); err != nil {
+	// Refuse binaries not built by the expected pipeline
+	if err := i.verifyDownloadsProvenance(i.components, nil); err != nil {
+		return failure.Wrap(failure.Verification, fmt.Errorf("provenance verification failed: %w", err))
+	}
+	if err := i.checkSBOMs(i.components, nil); err != nil {
+		return fmt.Errorf("SBOM check failed: %w", err)
+	}
+
+	// Install components, into slots named after the released versions
+	var versions map[string]string
+	if !i.dryRun {
+		versions = i.releaseVersions()
+	}
+	if err := i.runPhase(phaseInstall, func() error { return i.installComponents(i.config.CachePath, versions) }); err != nil {
 		return fmt.Errorf("failed to install components: %w", err)
 	}
 
 	// Configure system
-	if err := i.configureSystem(); err != nil {
+	if err := i.runPhase(phaseConfigure, i.configureSystem); err != nil {
 		return fmt.Errorf("failed to configure system: %w", err)
 	}
 
+	// The services must report the versions that were installed
+	i.expectedVersions = versions
+
 	// Start services
-	if err := i.startServices(); err != nil {
+	if err := i.runPhase(phaseStart, i.withStartHooks(i.startServices)); err != nil {
 		return fmt.Errorf("failed to start services: %w", err)
 	}
 
+	if !i.dryRun {
+		i.recordInstalledVersions()
+	}
+
 	return nil
 }
 
-// InstallOffline installs Ezra in offline mode
+// InstallOffline installs Ezra in offline mode. Any failure rolls back
+// the steps that already completed.
 func (i *Installer) InstallOffline() error {
+	started := time.Now()
+	i.beginState("offline")
+	err := i.transaction(i.withInstallHooks(i.installOffline))
+	i.finishState(err)
+	i.finishReport("offline", started, err)
+	return err
+}
+
+func (i *Installer) installOffline() error {
 	i.log.Info("Starting offline installation...")
 
+	if err := i.checkHardware(); err != nil {
+		return failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("hardware check failed: %w", err))
+	}
+
 	// Look for offline installation media
 	mediaPath, err := i.findOfflineMedia()
 	if err != nil {
 		return fmt.Errorf("failed to find offline media: %w", err)
 	}
 
+	// Nothing is copied from media that does not match its signed manifest
+	mediaDir, closeMedia, err := i.openMedia(mediaPath)
+	if err != nil {
+		return failure.Wrap(failure.Verification, fmt.Errorf("failed to verify offline media: %w", err))
+	}
+	defer closeMedia()
+
 	// Copy components from media
-	if err := i.copyComponents(mediaPath); err != nil {
+	if err := i.runPhase(phaseCopy, func() error { return i.copyComponents(mediaDir) }); err != nil {
 		return fmt.Errorf("failed to copy components: %w", err)
 	}
 
 	// Install components
-	if err := i.installComponents(); err != nil {
+	if err := i.runPhase(phaseInstall, func() error { return i.installComponents(i.config.DataPath, i.mediaVersions) }); err != nil {
 		return fmt.Errorf("failed to install components: %w", err)
 	}
 
 	// Configure system
-	if err := i.configureSystem(); err != nil {
+	if err := i.runPhase(phaseConfigure, i.configureSystem); err != nil {
 		return fmt.Errorf("failed to configure system: %w", err)
 	}
 
 	// Start services
-	if err := i.startServices(); err != nil {
+	if err := i.runPhase(phaseStart, i.withStartHooks(i.startServices)); err != nil {
 		return fmt.Errorf("failed to start services: %w", err)
 	}
 
@@ -108,63 +505,186 @@ func (i *Installer) InstallOffline() error {
 func (i *Installer) downloadComponents() error {
 	i.log.Info("Downloading components...")
 
-	// Download companion server
-	if err := i.downloader.DownloadCompanion(); err != nil {
-		return fmt.Errorf("failed to download companion: %w", err)
+	if i.dryRun {
+		for _, component := range i.components {
+			i.plan.addDownload(i.downloader.ComponentURL(component))
+		}
+		return nil
+	}
+
+	if err := i.prepareDownloads(); err != nil {
+		return err
+	}
+
+	// With TUF every download is verified against the trusted targets
+	// metadata. Otherwise the manifest lets downloads be verified as they
+	// stream in. Without it the components are only downloaded unverified
+	// when verify_signatures is off; a blocked manifest must not be a way
+	// around verification.
+	var manifest *downloader.Manifest
+	if i.config.TUF.Enabled {
+		if err := i.setupTUF(); err != nil {
+			return failure.Wrap(failure.Verification, fmt.Errorf("failed to verify update metadata: %w", err))
+		}
+	} else {
+		var err error
+		if manifest, err = i.downloader.FetchManifest(i.ctx); err != nil {
+			if i.config.VerifySigs {
+				return failure.Wrap(failure.Verification, fmt.Errorf("failed to fetch release manifest to verify downloads: %w", err))
+			}
+			i.log.Errorf("Release manifest unavailable, skipping download verification as verify_signatures is off: %v", err)
+		}
+	}
+
+	if err := i.checkDiskSpace(i.componentFiles(manifest)); err != nil {
+		return err
+	}
+
+	// A release bundle replaces the individual downloads when one is
+	// published for this platform
+	if i.useBundle() {
+		err := i.downloadBundle(manifest)
+		if err == nil {
+			i.pruneCache()
+			return nil
+		}
+		if !errors.Is(err, downloader.ErrNoBundle) {
+			return err
+		}
+		i.log.Info("No bundle published, downloading components individually")
 	}
 
-	// Download agent
-	if err := i.downloader.DownloadAgent(); err != nil {
-		return fmt.Errorf("failed to download agent: %w", err)
+	var jobs []downloadJob
+	for _, component := range i.components {
+		var sv downloader.StreamVerifier
+		if manifest != nil {
+			sv = i.streamVerifier(manifest.Components[component])
+		}
+		if sv == nil && manifest != nil && i.config.VerifySigs {
+			return failure.Wrap(failure.Verification, fmt.Errorf("the release manifest publishes no checksum or signature for %s", component))
+		}
+		sv = i.reportedVerifier(component, sv)
+
+		jobs = append(jobs, downloadJob{
+			name: component,
+			fetch: func(ctx context.Context, dest string) error {
+				return i.downloader.DownloadComponentVerified(ctx, component, dest, sv)
+			},
+		})
 	}
 
-	// Download executor
-	if err := i.downloader.DownloadExecutor(); err != nil {
-		return fmt.Errorf("failed to download executor: %w", err)
+	return i.downloadConcurrently(jobs)
+}
+
+// prepareDownloads creates the download cache and orders the mirrors
+func (i *Installer) prepareDownloads() error {
+	// Downloads go to the cache outside the journal so that they survive
+	// a rollback and can be reused when the install is resumed
+	if err := os.MkdirAll(i.config.CachePath, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
+	if i.config.MirrorSelection == "latency" {
+		i.downloader.SortMirrorsByLatency()
+	}
 	return nil
 }
 
-// copyComponents copies components from offline media
-func (i *Installer) copyComponents(mediaPath string) error {
-	i.log.Info("Copying components from offline media...")
+// downloadJob is a single download run by downloadConcurrently. It is
+// stored in the cache under its name.
+type downloadJob struct {
+	name  string
+	fetch func(ctx context.Context, dest string) error
+}
 
-	// Copy companion server
-	if err := i.copyFile(filepath.Join(mediaPath, "companion"), i.config.DataPath); err != nil {
-		return fmt.Errorf("failed to copy companion: %w", err)
+// downloadConcurrently runs downloads in parallel, skipping the ones an
+// interrupted install already completed. The first failure cancels the
+// downloads still running.
+func (i *Installer) downloadConcurrently(jobs []downloadJob) error {
+	group, ctx := errgroup.WithContext(i.ctx)
+	if i.config.ParallelDownloads > 0 {
+		group.SetLimit(i.config.ParallelDownloads)
 	}
+	var stateMu sync.Mutex
+
+	stopProgress := i.downloader.StartProgressGroup()
+	for _, job := range jobs {
+		dest := filepath.Join(i.config.CachePath, job.name)
 
-	// Copy agent
-	if err := i.copyFile(filepath.Join(mediaPath, "agent"), i.config.DataPath); err != nil {
-		return fmt.Errorf("failed to copy agent: %w", err)
+		if i.state != nil && i.state.hasDownloaded(job.name) {
+			if _, err := os.Stat(dest); err == nil {
+				i.componentLog(job.name).Infof("Skipping %s download (already downloaded)", job.name)
+				continue
+			}
+		}
+
+		group.Go(func() error {
+			if err := job.fetch(ctx, dest); err != nil {
+				return err
+			}
+
+			if i.state != nil {
+				stateMu.Lock()
+				defer stateMu.Unlock()
+				i.state.markDownloaded(job.name)
+				if err := i.state.save(); err != nil {
+					i.log.Errorf("Failed to save install state: %v", err)
+				}
+			}
+			return nil
+		})
+	}
+	err := group.Wait()
+	stopProgress()
+	if err != nil {
+		return err
 	}
 
-	// Copy executor
-	if err := i.copyFile(filepath.Join(mediaPath, "executor"), i.config.DataPath); err != nil {
-		return fmt.Errorf("failed to copy executor: %w", err)
+	i.pruneCache()
+
+	return nil
+}
+
+// copyComponents copies components from offline media and checks the
+// copies against the media manifest
+func (i *Installer) copyComponents(mediaPath string) error {
+	i.log.Info("Copying components from offline media...")
+
+	for _, component := range i.components {
+		copied, err := i.copyFile(filepath.Join(mediaPath, component), i.config.DataPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", component, err)
+		}
+		if err := i.checkCopy(component, copied); err != nil {
+			return failure.Wrap(failure.Verification, err)
+		}
 	}
 
 	return nil
 }
 
-// installComponents installs all components
-func (i *Installer) installComponents() error {
+// installComponents installs all components from dir, where they were
+// downloaded or copied from offline media. versions name the slots they
+// are installed into.
+func (i *Installer) installComponents(dir string, versions map[string]string) error {
 	i.log.Info("Installing components...")
 
-	// Install companion server
-	if err := i.installCompanion(); err != nil {
-		return fmt.Errorf("failed to install companion: %w", err)
+	if err := i.prepareInstallPath(); err != nil {
+		return err
 	}
 
-	// Install agent
-	if err := i.installAgent(); err != nil {
-		return fmt.Errorf("failed to install agent: %w", err)
+	installed := i.loadInstalledVersions()
+	for _, component := range i.components {
+		install := func() error {
+			return i.installComponent(component, dir, installed[component], versions[component])
+		}
+		if err := i.forComponent(component, install); err != nil {
+			return fmt.Errorf("failed to install %s: %w", component, err)
+		}
 	}
 
-	// Install executor
-	if err := i.installExecutor(); err != nil {
-		return fmt.Errorf("failed to install executor: %w", err)
+	if err := i.mergeSysext(); err != nil {
+		return fmt.Errorf("failed to merge system extension: %w", err)
 	}
 
 	return nil
@@ -179,11 +699,48 @@ func (i *Installer) configureSystem() error {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
+	// Apply board-specific settings
+	if err := i.applyBoardTweaks(); err != nil {
+		return fmt.Errorf("failed to apply board settings: %w", err)
+	}
+
+	// Keep the device identity in the TPM when there is one
+	if err := i.setupDeviceIdentity(); err != nil {
+		return fmt.Errorf("failed to set up device identity: %w", err)
+	}
+	if err := i.saveDeviceID(); err != nil {
+		return fmt.Errorf("failed to keep device ID: %w", err)
+	}
+
+	// Keep the device key and credential in the secret store if one is
+	// configured
+	if err := i.moveSecretsToStore(); err != nil {
+		return err
+	}
+
+	// Create the key the device enrolls with the companion with
+	if err := i.setupDeviceKey(); err != nil {
+		return fmt.Errorf("failed to set up device key: %w", err)
+	}
+
+	// Pick the companion port before the agent is told about it. Without
+	// a local companion the agent uses the configured one.
+	if i.selected("companion") {
+		if err := i.chooseCompanionPort(); err != nil {
+			return err
+		}
+	}
+
 	// Create configuration files
 	if err := i.createConfigFiles(); err != nil {
 		return fmt.Errorf("failed to create config files: %w", err)
 	}
 
+	// Create the account the services run as and hand it the data
+	if err := i.setupServiceUser(); err != nil {
+		return fmt.Errorf("failed to set up service user: %w", err)
+	}
+
 	// Set up system service
 	if err := i.setupSystemService(); err != nil {
 		return fmt.Errorf("failed to setup system service: %w", err)
@@ -197,13 +754,29 @@ func (i *Installer) startServices() error {
 	i.log.Info("Starting services...")
 
 	// Start companion server
-	if err := i.startCompanion(); err != nil {
-		return fmt.Errorf("failed to start companion: %w", err)
+	if i.selected("companion") {
+		if err := i.startCompanion(); err != nil {
+			return fmt.Errorf("failed to start companion: %w", err)
+		}
+		if err := i.waitForCompanion(); err != nil {
+			return err
+		}
+	}
+
+	// Enroll the device once the companion is up. The agent is not
+	// started without the credential it authenticates with.
+	if err := i.enrollDevice(false); err != nil {
+		return fmt.Errorf("failed to enroll device: %w", err)
 	}
 
 	// Start agent
-	if err := i.startAgent(); err != nil {
-		return fmt.Errorf("failed to start agent: %w", err)
+	if i.selected("agent") {
+		if err := i.startAgent(); err != nil {
+			return fmt.Errorf("failed to start agent: %w", err)
+		}
+		if err := i.waitForAgent(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -211,60 +784,120 @@ func (i *Installer) startServices() error {
 
 // Helper methods
 
-func (i *Installer) findOfflineMedia() (string, error) {
-	// Look for USB/SD card with Ezra installation
-	// This is a simplified implementation
-	possiblePaths := []string{
-		"/media/ezra",
-		"/mnt/ezra",
-		"/tmp/ezra-offline",
+// copyFile copies a file or directory into dst and returns the SHA-256
+// of the copied files by their path below src, hashed as they were read.
+// Links are left out: media holds none, and a link cannot be checked.
+func (i *Installer) copyFile(src, dst string) (map[string]string, error) {
+	// Create destination directory
+	if err := i.mkdirAll(dst, 0755); err != nil {
+		return nil, err
 	}
 
-	for _, path := range possiblePaths {
-		if _, err := os.Stat(path); err == nil {
-			return path, nil
+	target := filepath.Join(dst, filepath.Base(src))
+	if i.dryRun {
+		i.plan.addFile(target)
+		return nil, nil
+	}
+	if _, err := os.Stat(target); os.IsNotExist(err) && i.journal != nil {
+		if info, err := os.Stat(src); err == nil && info.IsDir() {
+			i.journal.recordCreateDir(target)
+		} else {
+			i.journal.recordCreateFile(target)
 		}
 	}
 
-	return "", fmt.Errorf("no offline media found")
-}
-
-func (i *Installer) copyFile(src, dst string) error {
-	// Create destination directory
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return err
+	// Copies into root-owned directories are made next to the installer
+	// and moved into place as root
+	copyTo := target
+	if i.needsElevation(target) {
+		tmp, err := os.MkdirTemp("", "ezra-copy-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		copyTo = filepath.Join(tmp, filepath.Base(src))
 	}
 
-	// Copy file
-	cmd := exec.Command("cp", "-r", src, dst)
-	return cmd.Run()
-}
-
-func (i *Installer) installCompanion() error {
-	i.log.Info("Installing companion server...")
-
-	// This would install the companion server
-	// Implementation depends on the platform
-
-	return nil
+	progress, err := downloader.NewProgress(i.config.Progress, i.log)
+	if err != nil {
+		return nil, fmt.Errorf("invalid progress: %w", err)
+	}
+	name := filepath.Base(src)
+	started := false
+	result, err := copier.Copy(src, copyTo, copier.Options{
+		Symlinks:       copier.SymlinksSkip,
+		PreserveXattrs: true,
+		Verify:         true,
+		Progress: func(copied, total int64) {
+			if !started {
+				progress.Start(name, total, 0)
+				started = true
+			}
+			progress.Update(name, copied)
+		},
+	})
+	if started {
+		progress.Finish(name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.log.Infof("Copied %s: %d files, %s, checksums verified", name, result.Files, formatSize(uint64(result.Bytes)))
+
+	if copyTo != target {
+		// mv would move a directory into an existing one of the same name
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			if err := i.removeAll(target); err != nil {
+				return nil, err
+			}
+		}
+		if err := i.rename(copyTo, target); err != nil {
+			return nil, err
+		}
+	}
+	return result.Checksums, nil
 }
 
-func (i *Installer) installAgent() error {
-	i.log.Info("Installing agent...")
-
-	// This would install the agent
-	// Implementation depends on the platform
-
-	return nil
-}
+// installComponent installs the binary of a component from dir the way
+// an upgrade replaces one: staged beside its target, then renamed over a
+// binary already there, which is backed up, or into its slot. previous
+// is the version already installed, if any.
+func (i *Installer) installComponent(component, dir, previous, version string) error {
+	target := filepath.Join(i.config.InstallPath, binaryName(component))
+	i.componentLog(component).Infof("Installing %s %s to %s", component, versionOrUnknown(version), target)
+	if i.dryRun {
+		i.plan.addFile(target)
+		return nil
+	}
 
-func (i *Installer) installExecutor() error {
-	i.log.Info("Installing executor...")
+	src := filepath.Join(dir, component)
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("%s was not downloaded: %w", component, err)
+	}
+	if info.IsDir() {
+		// Media made by create-media keeps each component in a directory,
+		// under its published name
+		src = filepath.Join(src, i.downloader.TargetFilename(component))
+	}
 
-	// This would install the executor
-	// Implementation depends on the platform
+	staged, err := i.stagingPath(component)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	err = writeAtomic(staged, in, 0755)
+	in.Close()
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", component, err)
+	}
+	// Gone once renamed into place
+	defer os.Remove(staged)
 
-	return nil
+	return i.replaceBinary(component, previous, version, staged)
 }
 
 func (i *Installer) createDirectories() error {
@@ -275,7 +908,7 @@ func (i *Installer) createDirectories() error {
 	}
 
 	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := i.mkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
@@ -284,78 +917,171 @@ func (i *Installer) createDirectories() error {
 }
 
 func (i *Installer) createConfigFiles() error {
+	// The companion and executor configurations come from templates the
+	// operator can replace
+	for _, component := range []string{"companion", "executor"} {
+		if !i.selected(component) {
+			continue
+		}
+		if err := i.writeConfigTemplate(component); err != nil {
+			return err
+		}
+	}
+	if !i.selected("agent") {
+		return nil
+	}
+
 	// Create agent configuration
 	agentConfig := map[string]interface{}{
-		"companion_url": i.config.CompanionURL,
+		"companion_url": i.companionURL(),
 		"device_id":     i.config.DeviceID,
 		"data_dir":      i.config.DataPath,
 		"cache_dir":     i.config.CachePath,
 		"backup_dir":    i.config.BackupPath,
 		"log_level":     i.config.LogLevel,
+		"status_socket": i.agentSocket(),
 	}
 
-	configPath := filepath.Join(i.config.DataPath, "agent-config.json")
+	// The agent reads a TPM-backed identity from the TPM, so a copy of
+	// this file does not carry it
+	if i.useTPM {
+		delete(agentConfig, "device_id")
+		agentConfig["device_identity"] = "tpm"
+		agentConfig["tpm"] = i.tpmOptions()
+	}
+
+	// An enrolled agent authenticates with the device key and the
+	// credential the companion issued for it. Those kept in a secret
+	// store are given as references to it.
+	if enrolls, _ := i.enrolls(); enrolls {
+		agentConfig["device_key"] = i.deviceKeyPath()
+		agentConfig["device_credentials"] = i.deviceCredentialPath()
+		if usesSecretStore(i.config) {
+			agentConfig["device_key"] = config.SecretRefPrefix + deviceKeyFile
+			agentConfig["device_credentials"] = config.SecretRefPrefix + deviceCredentialFile
+			agentConfig["secret_store"] = map[string]string{
+				"backend": i.config.SecretStore.Backend,
+				"dir":     i.config.SecretStoreDir(),
+			}
+		}
+	}
+
+	if i.systemInfo != nil && i.systemInfo.Board.Family != "" {
+		agentConfig["board"] = i.systemInfo.Board.Family
+	}
+	if limit := i.thermalLimit(); limit > 0 {
+		agentConfig["thermal_limit_c"] = limit
+	}
+	if len(i.config.Features) > 0 {
+		agentConfig["features"] = i.config.Features
+	}
+
+	configPath := filepath.Join(i.config.DataPath, agentConfigFile)
 	return i.writeJSONConfig(configPath, agentConfig)
 }
 
 func (i *Installer) setupSystemService() error {
 	i.log.Info("Setting up system service...")
 
-	switch runtime.GOOS {
-	case "linux":
-		return i.setupSystemdService()
-	case "windows":
-		return i.setupWindowsService()
-	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return err
 	}
-}
 
-func (i *Installer) setupSystemdService() error {
-	// Create systemd service file
-	serviceContent := fmt.Sprintf(`[Unit]
-Description=Ezra Agent
-After=network.target
-
-[Service]
-Type=simple
-User=ezra
-WorkingDirectory=%s
-ExecStart=%s/ezra-agent start --daemon
-Restart=always
-RestartSec=5
+	switch supervisor {
+	case supervisorContainer:
+		return i.setupContainerEntrypoint()
+	case supervisorDirect:
+		i.log.Info("No init system to register with: services will not be restarted after a reboot")
+		return nil
+	}
 
-[Install]
-WantedBy=multi-user.target
-`, i.config.DataPath, i.config.InstallPath)
+	// The service manager owns the processes of the services it runs
+	for _, spec := range i.services() {
+		var err error
+		switch supervisor {
+		case supervisorSystemd:
+			err = i.setupSystemdService(spec)
+		case supervisorOpenRC, supervisorRunit, supervisorSysV:
+			err = i.setupInitService(supervisor, spec)
+		case supervisorLaunchd:
+			err = i.setupLaunchdService(spec)
+		case supervisorSCM:
+			err = i.setupWindowsService(spec)
+		case supervisorTask:
+			err = i.setupUserTask(spec)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to register %s: %w", spec.Name, err)
+		}
+	}
 
-	serviceFile := "/etc/systemd/system/ezra-agent.service"
-	return os.WriteFile(serviceFile, []byte(serviceContent), 0644)
+	// Register with the Event Log, so that the Event Viewer shows what
+	// the bootstrap logs there
+	if supervisor == supervisorSCM && !i.dryRun {
+		if err := logger.RegisterEventSource(); err != nil {
+			i.log.Errorf("Failed to register the event log source: %v", err)
+		}
+	}
+	return nil
 }
 
-func (i *Installer) setupWindowsService() error {
-	// Windows service setup would go here
-	return fmt.Errorf("Windows service setup not implemented")
+// setupSystemdService writes a systemd unit for a service and enables it,
+// in the user's service manager in user mode
+func (i *Installer) setupSystemdService(spec serviceSpec) error {
+	unit, err := i.renderService(supervisorSystemd, spec)
+	if err != nil {
+		return err
+	}
+
+	dir, err := i.systemdUnitDir()
+	if err != nil {
+		return err
+	}
+	if i.dryRun {
+		i.plan.addService(spec.Name + " (systemd)")
+	}
+	if err := i.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := i.writeFile(filepath.Join(dir, spec.Name+".service"), unit, 0644); err != nil {
+		return err
+	}
+	if i.userMode() {
+		return i.enableUserUnit(spec.Name)
+	}
+	if err := i.runServiceCommand("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return i.runServiceCommand("systemctl", "enable", spec.Name)
 }
 
 func (i *Installer) startCompanion() error {
 	i.log.Info("Starting companion server...")
 
+	if managed, err := i.startManagedService("ezra-companion"); managed || err != nil {
+		return err
+	}
+
+	if i.companionPort == 0 {
+		if err := i.chooseCompanionPort(); err != nil {
+			return err
+		}
+	}
+
 	// Start companion server
-	cmd := exec.Command(filepath.Join(i.config.InstallPath, "ezra-companion"), "start")
-	return cmd.Start()
+	cmd := exec.Command(filepath.Join(i.config.InstallPath, "ezra-companion"), "start", "--port", strconv.Itoa(i.companionPort))
+	return i.startProcess("ezra-companion", cmd)
 }
 
 func (i *Installer) startAgent() error {
 	i.log.Info("Starting agent...")
 
+	if managed, err := i.startManagedService("ezra-agent"); managed || err != nil {
+		return err
+	}
+
 	// Start agent
 	cmd := exec.Command(filepath.Join(i.config.InstallPath, "ezra-agent"), "start", "--daemon")
-	return cmd.Start()
-}
-
-func (i *Installer) writeJSONConfig(path string, config map[string]interface{}) error {
-	// This would write JSON configuration
-	// Implementation depends on JSON handling
-	return nil
+	return i.startProcess("ezra-agent", cmd)
 }
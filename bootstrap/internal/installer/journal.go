@@ -0,0 +1,426 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// journalAction identifies the kind of mutation a journal entry undoes
+type journalAction int
+
+const (
+	actionCreateDir journalAction = iota
+	actionCreateFile
+	actionReplaceFile
+	actionStartProcess
+	actionCreateService
+	actionRunStep
+	actionStartService
+)
+
+// backupManifestName lists the originals kept in a backup set
+const backupManifestName = "manifest.json"
+
+// backupRecord is an entry of a backup set's manifest
+type backupRecord struct {
+	Path       string      `json:"path"`
+	Backup     string      `json:"backup"`
+	SHA256     string      `json:"sha256"`
+	Mode       os.FileMode `json:"mode"`
+	BackedUpAt time.Time   `json:"backed_up_at"`
+}
+
+// journalEntry records a single mutating step of an installation
+type journalEntry struct {
+	action  journalAction
+	path    string
+	backup  string
+	name    string
+	process *os.Process
+	// undo rolls back a custom install step or stops a started service
+	undo func() error
+}
+
+// journal records every mutating step of an installation so that a
+// failure in a later step can undo the earlier ones
+type journal struct {
+	entries   []journalEntry
+	backupDir string
+	backups   []backupRecord
+	log       Logger
+	// elevate undoes steps that were made as root
+	elevate func(args ...string) error
+	// undone describes the steps a rollback undid, for the install report
+	undone []string
+}
+
+// newJournal creates an empty journal that keeps the originals of
+// replaced files, and a manifest of them, in backupDir
+func newJournal(backupDir string, log Logger) *journal {
+	return &journal{
+		backupDir: backupDir,
+		log:       log,
+	}
+}
+
+func (j *journal) recordCreateDir(path string) {
+	j.entries = append(j.entries, journalEntry{action: actionCreateDir, path: path})
+}
+
+func (j *journal) recordCreateFile(path string) {
+	j.entries = append(j.entries, journalEntry{action: actionCreateFile, path: path})
+}
+
+func (j *journal) recordReplaceFile(path, backup string) {
+	j.entries = append(j.entries, journalEntry{action: actionReplaceFile, path: path, backup: backup})
+}
+
+func (j *journal) recordStartProcess(name string, process *os.Process) {
+	j.entries = append(j.entries, journalEntry{action: actionStartProcess, name: name, process: process})
+}
+
+func (j *journal) recordCreateService(name string) {
+	j.entries = append(j.entries, journalEntry{action: actionCreateService, name: name})
+}
+
+func (j *journal) recordStartService(name string, stop func() error) {
+	j.entries = append(j.entries, journalEntry{action: actionStartService, name: name, undo: stop})
+}
+
+func (j *journal) recordStep(name string, undo func() error) {
+	j.entries = append(j.entries, journalEntry{action: actionRunStep, name: name, undo: undo})
+}
+
+// undid logs a step the rollback undoes
+func (j *journal) undid(format string, args ...interface{}) {
+	action := fmt.Sprintf(format, args...)
+	j.log.Infof("Rollback: %s", action)
+	j.undone = append(j.undone, action)
+}
+
+// rollback undoes all recorded steps in reverse order. It keeps going
+// after individual failures and returns every error it encountered.
+func (j *journal) rollback() []error {
+	var errs []error
+
+	for idx := len(j.entries) - 1; idx >= 0; idx-- {
+		entry := j.entries[idx]
+
+		var err error
+		switch entry.action {
+		case actionStartProcess:
+			j.undid("stopping %s", entry.name)
+			err = entry.process.Kill()
+			if err == os.ErrProcessDone {
+				err = nil
+			}
+		case actionStartService:
+			j.undid("stopping service %s", entry.name)
+			err = entry.undo()
+		case actionRunStep:
+			j.undid("undoing step %s", entry.name)
+			err = entry.undo()
+		case actionCreateService:
+			j.undid("removing service %s", entry.name)
+			_, err = deleteWindowsService(entry.name)
+		case actionCreateFile:
+			j.undid("removing %s", entry.path)
+			err = os.Remove(entry.path)
+		case actionReplaceFile:
+			j.undid("restoring %s", entry.path)
+			err = os.Rename(entry.backup, entry.path)
+		case actionCreateDir:
+			j.undid("removing directory %s", entry.path)
+			err = os.RemoveAll(entry.path)
+		}
+
+		if os.IsPermission(err) && j.elevate != nil {
+			if undo := entry.undoCommand(); undo != nil {
+				err = j.elevate(undo...)
+			}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to roll back %s: %w", entry.describe(), err))
+		}
+	}
+
+	j.entries = nil
+	// Every original was restored: the backup set is empty
+	if len(errs) == 0 {
+		os.RemoveAll(j.backupDir)
+	}
+	return errs
+}
+
+// undoCommand returns the command that undoes a file system step as
+// root, or nil for other steps
+func (e journalEntry) undoCommand() []string {
+	switch e.action {
+	case actionCreateFile:
+		return []string{"rm", "-f", e.path}
+	case actionReplaceFile:
+		return []string{"mv", "-f", e.backup, e.path}
+	case actionCreateDir:
+		return []string{"rm", "-rf", e.path}
+	}
+	return nil
+}
+
+// describe returns a short human readable name for the entry
+func (e journalEntry) describe() string {
+	if e.name != "" {
+		return e.name
+	}
+	return e.path
+}
+
+// backupFile copies an existing file into the journal's backup directory,
+// lists it in the backup manifest and returns the location of the copy
+func (j *journal) backupFile(path string) (string, error) {
+	if err := os.MkdirAll(j.backupDir, 0755); err != nil {
+		return "", err
+	}
+
+	// Numbered so that files of the same name do not collide
+	backup := filepath.Join(j.backupDir, fmt.Sprintf("%03d-%s", len(j.backups), filepath.Base(path)))
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := os.OpenFile(backup, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, sum), src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	j.backups = append(j.backups, backupRecord{
+		Path:       path,
+		Backup:     backup,
+		SHA256:     hex.EncodeToString(sum.Sum(nil)),
+		Mode:       info.Mode().Perm(),
+		BackedUpAt: time.Now().UTC(),
+	})
+	data, err := json.MarshalIndent(j.backups, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(j.backupDir, backupManifestName), data, 0644); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// mkdirAll creates a directory and its parents, journaling the topmost
+// directory that did not exist before
+func (i *Installer) mkdirAll(dir string, perm os.FileMode) error {
+	if i.dryRun {
+		i.plan.addDirectory(dir)
+		return nil
+	}
+
+	created := ""
+	for p := filepath.Clean(dir); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil {
+			break
+		}
+		created = p
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	if created == "" {
+		return nil
+	}
+
+	if i.needsElevation(created) {
+		if _, err := i.runElevated("mkdir", "-p", "-m", strconv.FormatUint(uint64(perm.Perm()), 8), dir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+
+	if created != "" && i.journal != nil {
+		i.journal.recordCreateDir(created)
+	}
+	return nil
+}
+
+// writeFile writes a file, journaling either its creation or a backup of
+// the previous contents so the write can be undone. The file is written
+// beside the destination and renamed over it. Existing files are handled
+// as shouldWrite decides.
+func (i *Installer) writeFile(path string, data []byte, perm os.FileMode) error {
+	if i.dryRun {
+		i.plan.addFile(path)
+		return nil
+	}
+
+	sum := dataChecksum(data)
+	if write, err := i.shouldWrite(path, sum); !write || err != nil {
+		return err
+	}
+	if err := i.journalWrite(path); err != nil {
+		return err
+	}
+
+	var err error
+	if i.needsElevation(path) {
+		err = i.writeElevated(path, data, perm)
+	} else {
+		err = writeAtomic(path, bytes.NewReader(data), perm)
+	}
+	if err != nil {
+		return err
+	}
+	i.recordDeployed(path, sum)
+	return nil
+}
+
+// installFile copies a file into place, journaling it like writeFile.
+// The copy is renamed over the destination so that a crash never leaves
+// a partially written binary behind.
+func (i *Installer) installFile(src, dst string, perm os.FileMode) error {
+	if i.dryRun {
+		i.plan.addFile(dst)
+		return nil
+	}
+
+	sum, err := fileSHA256(src)
+	if err != nil {
+		return err
+	}
+	if write, err := i.shouldWrite(dst, sum); !write || err != nil {
+		return err
+	}
+	if err := i.journalWrite(dst); err != nil {
+		return err
+	}
+
+	if i.needsElevation(dst) {
+		err = i.installElevated(src, dst, perm)
+	} else {
+		var in *os.File
+		if in, err = os.Open(src); err == nil {
+			err = writeAtomic(dst, in, perm)
+			in.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	i.recordDeployed(dst, sum)
+	return nil
+}
+
+// writeAtomic writes a file beside path and renames it over path, so that
+// a crash never leaves a partially written file behind
+func writeAtomic(path string, r io.Reader, perm os.FileMode) error {
+	tmp := path + ".new"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// journalWrite records the creation of path or a backup of its current
+// contents before it is written
+func (i *Installer) journalWrite(path string) error {
+	if i.journal == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		backup, err := i.journal.backupFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		i.journal.recordReplaceFile(path, backup)
+	} else {
+		i.journal.recordCreateFile(path)
+	}
+	return nil
+}
+
+// startProcess starts a command and journals it so it can be stopped
+func (i *Installer) startProcess(name string, cmd *exec.Cmd) error {
+	if i.dryRun {
+		i.plan.addCommand(cmd.String())
+		return nil
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if i.journal != nil {
+		i.journal.recordStartProcess(name, cmd.Process)
+	}
+	return nil
+}
+
+// transaction runs fn with a fresh journal and rolls back every recorded
+// step if fn fails
+func (i *Installer) transaction(fn func() error) error {
+	i.journal = newJournal(filepath.Join(i.config.BackupPath, time.Now().Format("20060102-150405")), i.log)
+	i.journal.elevate = func(args ...string) error {
+		_, err := i.runElevated(args...)
+		return err
+	}
+	defer func() { i.journal = nil }()
+
+	err := fn()
+	if err == nil {
+		return nil
+	}
+
+	i.log.Errorf("Installation failed, rolling back: %v", err)
+	for _, rbErr := range i.journal.rollback() {
+		i.log.Error(rbErr)
+	}
+	i.report.RolledBack = append(i.report.RolledBack, i.journal.undone...)
+
+	return err
+}
@@ -0,0 +1,250 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ezra/bootstrap/internal/config"
+)
+
+// readFile returns the contents of path, or "" if it does not exist
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// record makes changes in dir and journals them
+		record func(t *testing.T, j *journal, dir string)
+		// want is the contents of each file after the rollback, "" for
+		// files that must not exist
+		want       map[string]string
+		wantUndone []string
+		wantErrs   int
+	}{
+		{
+			name: "created file",
+			record: func(t *testing.T, j *journal, dir string) {
+				writeTestFile(t, filepath.Join(dir, "agent.yaml"), "new")
+				j.recordCreateFile(filepath.Join(dir, "agent.yaml"))
+			},
+			want:       map[string]string{"agent.yaml": ""},
+			wantUndone: []string{"removing agent.yaml"},
+		},
+		{
+			name: "replaced file",
+			record: func(t *testing.T, j *journal, dir string) {
+				path := filepath.Join(dir, "agent.yaml")
+				writeTestFile(t, path, "original")
+				backup, err := j.backupFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				j.recordReplaceFile(path, backup)
+				writeTestFile(t, path, "new")
+			},
+			want:       map[string]string{"agent.yaml": "original"},
+			wantUndone: []string{"restoring agent.yaml"},
+		},
+		{
+			name: "created directory",
+			record: func(t *testing.T, j *journal, dir string) {
+				if err := os.MkdirAll(filepath.Join(dir, "bin", "sub"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				j.recordCreateDir(filepath.Join(dir, "bin"))
+				writeTestFile(t, filepath.Join(dir, "bin", "sub", "ezra-agent"), "agent")
+			},
+			want:       map[string]string{"bin/sub/ezra-agent": ""},
+			wantUndone: []string{"removing directory bin"},
+		},
+		{
+			name: "reverse order",
+			record: func(t *testing.T, j *journal, dir string) {
+				if err := os.Mkdir(filepath.Join(dir, "bin"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				j.recordCreateDir(filepath.Join(dir, "bin"))
+				writeTestFile(t, filepath.Join(dir, "bin", "ezra-agent"), "agent")
+				j.recordCreateFile(filepath.Join(dir, "bin", "ezra-agent"))
+				j.recordStep("enroll", func() error { return nil })
+			},
+			want: map[string]string{"bin/ezra-agent": ""},
+			wantUndone: []string{
+				"undoing step enroll",
+				"removing bin/ezra-agent",
+				"removing directory bin",
+			},
+		},
+		{
+			name: "already removed",
+			record: func(t *testing.T, j *journal, dir string) {
+				j.recordCreateFile(filepath.Join(dir, "agent.yaml"))
+				j.recordCreateDir(filepath.Join(dir, "bin"))
+			},
+			want:       map[string]string{"agent.yaml": ""},
+			wantUndone: []string{"removing directory bin", "removing agent.yaml"},
+		},
+		{
+			name: "keeps going after failures",
+			record: func(t *testing.T, j *journal, dir string) {
+				writeTestFile(t, filepath.Join(dir, "agent.yaml"), "new")
+				j.recordCreateFile(filepath.Join(dir, "agent.yaml"))
+				j.recordStep("enroll", func() error { return errors.New("companion unreachable") })
+				j.recordStartService("ezra-agent", func() error { return errors.New("service busy") })
+			},
+			want: map[string]string{"agent.yaml": ""},
+			wantUndone: []string{
+				"stopping service ezra-agent",
+				"undoing step enroll",
+				"removing agent.yaml",
+			},
+			wantErrs: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backupDir := filepath.Join(t.TempDir(), "backup")
+			j := newJournal(backupDir, testLogger{})
+			test.record(t, j, dir)
+
+			errs := j.rollback()
+			if len(errs) != test.wantErrs {
+				t.Fatalf("rollback errors = %v, want %d", errs, test.wantErrs)
+			}
+			for name, want := range test.want {
+				if got := readFile(t, filepath.Join(dir, filepath.FromSlash(name))); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			var undone []string
+			for _, action := range j.undone {
+				undone = append(undone, strings.ReplaceAll(action, dir+string(filepath.Separator), ""))
+			}
+			if strings.Join(undone, "\n") != strings.Join(test.wantUndone, "\n") {
+				t.Errorf("undone = %q, want %q", undone, test.wantUndone)
+			}
+			if len(j.entries) != 0 {
+				t.Errorf("%d entries left after the rollback", len(j.entries))
+			}
+
+			// The backups are only dropped once every original is back
+			_, err := os.Stat(backupDir)
+			if test.wantErrs == 0 && !os.IsNotExist(err) {
+				t.Errorf("backup directory kept after a clean rollback: %v", err)
+			}
+		})
+	}
+}
+
+func TestJournalRollbackKeepsBackupsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.yaml")
+	writeTestFile(t, path, "original")
+
+	j := newJournal(filepath.Join(t.TempDir(), "backup"), testLogger{})
+	backup, err := j.backupFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.recordReplaceFile(path, backup)
+	writeTestFile(t, path, "new")
+	j.recordStep("enroll", func() error { return errors.New("companion unreachable") })
+
+	if errs := j.rollback(); len(errs) != 1 {
+		t.Fatalf("rollback errors = %v, want 1", errs)
+	}
+	if got := readFile(t, path); got != "original" {
+		t.Errorf("agent.yaml = %q, want the original", got)
+	}
+	if _, err := os.Stat(filepath.Join(j.backupDir, backupManifestName)); err != nil {
+		t.Errorf("backup manifest removed after a failed rollback: %v", err)
+	}
+}
+
+func TestTransactionRollsBack(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Progress = "log"
+	cfg.DataPath = filepath.Join(root, "data")
+	cfg.BackupPath = filepath.Join(root, "backups")
+	inst := &Installer{config: cfg, log: testLogger{}, report: newInstallReport()}
+
+	existing := filepath.Join(root, "agent.yaml")
+	writeTestFile(t, existing, "original")
+	binDir := filepath.Join(root, "bin", "ezra")
+	created := filepath.Join(binDir, "ezra-agent")
+
+	failure := errors.New("service failed to start")
+	err := inst.transaction(func() error {
+		if err := inst.mkdirAll(binDir, 0755); err != nil {
+			return err
+		}
+		if err := inst.writeFile(created, []byte("agent"), 0755); err != nil {
+			return err
+		}
+		if err := inst.writeFile(existing, []byte("new"), 0644); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("transaction = %v, want %v", err, failure)
+	}
+
+	if got := readFile(t, existing); got != "original" {
+		t.Errorf("agent.yaml = %q, want the original", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bin")); !os.IsNotExist(err) {
+		t.Errorf("created directory not removed: %v", err)
+	}
+	if len(inst.report.RolledBack) != 3 {
+		t.Errorf("RolledBack = %q, want the three steps", inst.report.RolledBack)
+	}
+	if inst.journal != nil {
+		t.Error("journal kept after the transaction")
+	}
+}
+
+func TestTransactionKeepsChangesOnSuccess(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Progress = "log"
+	cfg.DataPath = filepath.Join(root, "data")
+	cfg.BackupPath = filepath.Join(root, "backups")
+	inst := &Installer{config: cfg, log: testLogger{}, report: newInstallReport()}
+
+	path := filepath.Join(root, "agent.yaml")
+	if err := inst.transaction(func() error {
+		return inst.writeFile(path, []byte("new"), 0644)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "new" {
+		t.Errorf("agent.yaml = %q, want %q", got, "new")
+	}
+	if len(inst.report.RolledBack) != 0 {
+		t.Errorf("RolledBack = %q, want nothing", inst.report.RolledBack)
+	}
+}
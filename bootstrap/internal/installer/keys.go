@@ -0,0 +1,66 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// keyRotationFile is where the companion publishes the key rotation
+// document, and where the latest one applied is kept under DataPath
+const keyRotationFile = "keys/rotation.json"
+
+// setupTrustedKeys loads the last applied key rotation and applies a newer
+// one if the companion publishes it. A rotation must be signed by a key
+// trusted before it, and is persisted so the next run starts from it
+// rather than the configured keys.
+func (i *Installer) setupTrustedKeys() error {
+	if !i.signaturesEnabled() || i.config.SignatureType == verifier.SignatureCosign {
+		return nil
+	}
+
+	rotationPath := filepath.Join(i.config.DataPath, keyRotationFile)
+	if data, err := os.ReadFile(rotationPath); err == nil {
+		if err := i.verifier.LoadKeyRotation(data); err != nil {
+			return fmt.Errorf("failed to load key rotation: %w", err)
+		}
+	}
+
+	data, err := i.downloader.FetchFile(i.ctx, keyRotationFile)
+	if downloader.IsNotPublished(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch key rotation: %w", err)
+	}
+	signature, err := i.downloader.FetchFile(i.ctx, keyRotationFile+".sig")
+	if err != nil {
+		return fmt.Errorf("failed to fetch key rotation signature: %w", err)
+	}
+	if len(bytes.TrimSpace(signature)) == 0 {
+		return fmt.Errorf("key rotation rejected: %s is empty", keyRotationFile+".sig")
+	}
+
+	rotated, err := i.verifier.ApplyKeyRotation(data, string(signature))
+	if err != nil {
+		return fmt.Errorf("key rotation rejected: %w", err)
+	}
+	if !rotated {
+		return nil
+	}
+
+	// Kept outside the journal: a verified rotation must survive a rollback
+	if err := os.MkdirAll(filepath.Dir(rotationPath), 0755); err != nil {
+		return fmt.Errorf("failed to create keys directory: %w", err)
+	}
+	if err := os.WriteFile(rotationPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to persist key rotation: %w", err)
+	}
+
+	i.log.Infof("Trusted keys rotated (version %d)", i.verifier.KeyRotationVersion())
+	return nil
+}
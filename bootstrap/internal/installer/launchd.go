@@ -0,0 +1,189 @@
+package installer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Where launchd jobs are installed: daemons for the whole system when
+// installing as root, agents of the installing user otherwise
+const (
+	launchDaemonsDir = "/Library/LaunchDaemons"
+	launchAgentsDir  = "Library/LaunchAgents"
+)
+
+// launchdLabel returns the job label of a service, e.g. dev.ezra.agent
+func launchdLabel(name string) string {
+	return "dev.ezra." + strings.TrimPrefix(name, "ezra-")
+}
+
+// launchdDomain returns the launchctl domain jobs are bootstrapped into
+// and the directory their property lists are kept in
+func (i *Installer) launchdDomain() (string, string, error) {
+	// Daemons need root, which the installer has or asks for
+	if !i.userMode() && (os.Geteuid() == 0 || i.config.Elevation != elevationNone) {
+		return "system", launchDaemonsDir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot find the LaunchAgents directory: %w", err)
+	}
+	return "gui/" + strconv.Itoa(os.Getuid()), filepath.Join(home, launchAgentsDir), nil
+}
+
+// launchdPlistPath returns where the property list of a service is kept
+func (i *Installer) launchdPlistPath(name string) (string, error) {
+	_, dir, err := i.launchdDomain()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, launchdLabel(name)+".plist"), nil
+}
+
+// setupLaunchdService writes a launchd property list for a service and
+// bootstraps it, which starts it. launchd restarts it whenever it exits.
+// A job already loaded under the same label is replaced.
+func (i *Installer) setupLaunchdService(spec serviceSpec) error {
+	domain, dir, err := i.launchdDomain()
+	if err != nil {
+		return err
+	}
+	label := launchdLabel(spec.Name)
+	plist := filepath.Join(dir, label+".plist")
+
+	if i.dryRun {
+		i.plan.addService(spec.Name + " (" + supervisorLaunchd + " " + domain + ")")
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistString(&b, "Label", label)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range spec.Command {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	plistString(&b, "WorkingDirectory", i.config.DataPath)
+	// Daemons run as root unless the service names an existing user;
+	// agents always run as the installing user
+	if domain == "system" && spec.User != "" {
+		if _, err := user.Lookup(spec.User); err == nil {
+			plistString(&b, "UserName", spec.User)
+		}
+	}
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n\t<key>KeepAlive</key>\n\t<true/>\n")
+	plistString(&b, "StandardOutPath", filepath.Join(i.config.DataPath, spec.Name+".log"))
+	plistString(&b, "StandardErrorPath", filepath.Join(i.config.DataPath, spec.Name+".log"))
+	b.WriteString("</dict>\n</plist>\n")
+
+	if err := i.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := i.writeFile(plist, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+
+	// bootstrap fails for a loaded job, e.g. from an earlier install
+	if !i.dryRun && launchdLoaded(domain, label) {
+		if _, err := i.combinedOutput(exec.Command("launchctl", "bootout", domain+"/"+label)); err != nil {
+			i.log.Errorf("Failed to unload %s: %v", label, err)
+		}
+	}
+	return i.runServiceCommand("launchctl", "bootstrap", domain, plist)
+}
+
+// controlLaunchdService starts, stops or restarts a launchd job. Stopping
+// unloads the job, since launchd would restart it otherwise. It reports
+// false if the service has no property list.
+func (i *Installer) controlLaunchdService(name, action string) (bool, error) {
+	domain, dir, err := i.launchdDomain()
+	if err != nil {
+		return false, err
+	}
+	label := launchdLabel(name)
+	plist := filepath.Join(dir, label+".plist")
+	if !fileExists(plist) {
+		return false, nil
+	}
+
+	loaded := launchdLoaded(domain, label)
+	var args []string
+	switch action {
+	case "start":
+		if loaded {
+			args = []string{"kickstart", domain + "/" + label}
+		} else {
+			args = []string{"bootstrap", domain, plist}
+		}
+	case "stop":
+		if !loaded {
+			return true, nil
+		}
+		args = []string{"bootout", domain + "/" + label}
+	case "restart":
+		if loaded {
+			args = []string{"kickstart", "-k", domain + "/" + label}
+		} else {
+			args = []string{"bootstrap", domain, plist}
+		}
+	default:
+		return true, fmt.Errorf("unknown service action %q", action)
+	}
+
+	if _, err := i.combinedOutput(exec.Command("launchctl", args...)); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// removeLaunchdService unloads a launchd job and deletes its property
+// list
+func (i *Installer) removeLaunchdService(name string, report *UninstallReport) error {
+	domain, dir, err := i.launchdDomain()
+	if err != nil {
+		return err
+	}
+	label := launchdLabel(name)
+	plist := filepath.Join(dir, label+".plist")
+
+	if launchdLoaded(domain, label) {
+		if _, err := i.combinedOutput(exec.Command("launchctl", "bootout", domain+"/"+label)); err != nil {
+			i.log.Errorf("Failed to unload %s: %v", label, err)
+		}
+	}
+	if _, err := os.Stat(plist); os.IsNotExist(err) {
+		return nil
+	}
+	if err := i.removeAll(plist); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", plist, err)
+	}
+	report.RemovedUnits = append(report.RemovedUnits, plist)
+	return nil
+}
+
+// launchdLoaded reports whether a job is loaded in a domain
+func launchdLoaded(domain, label string) bool {
+	return exec.Command("launchctl", "print", domain+"/"+label).Run() == nil
+}
+
+// plistString writes a string entry of a property list dictionary
+func plistString(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", xmlEscape(key), xmlEscape(value))
+}
+
+// xmlEscape escapes s for XML character data
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installLockName is the file under DataPath held by the bootstrap run
+// that is changing the installation
+const installLockName = "install.lock"
+
+// lockPollInterval is how often a waiting run checks the lock again
+const lockPollInterval = time.Second
+
+// lockWriteGrace is how long an unreadable lock file is taken to be one
+// that is still being written
+const lockWriteGrace = 10 * time.Second
+
+// lockOwner is what the lock file records about the run holding it
+type lockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	User      string    `json:"user"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("pid %d on %s, started by %s at %s: %s", o.PID, o.Host, o.User, o.StartedAt.Format(time.RFC3339), o.Command)
+}
+
+// Lock takes the install lock, so that two bootstrap runs, e.g. from a
+// retrying provisioning script, cannot change the installation at once.
+// While another run holds it Lock fails, or with wait queues behind it.
+// A lock left by a process that is no longer running is removed. The
+// returned function releases the lock. Dry runs change nothing and take
+// no lock.
+func (i *Installer) Lock(wait bool) (func(), error) {
+	if i.dryRun {
+		return func() {}, nil
+	}
+
+	path := filepath.Join(i.config.DataPath, installLockName)
+	if err := os.MkdirAll(i.config.DataPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", i.config.DataPath, err)
+	}
+
+	owner := currentLockOwner()
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		held, err := createLock(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take the install lock: %w", err)
+		}
+		if held == nil {
+			return func() { releaseLock(path, data) }, nil
+		}
+
+		if i.staleLock(path, held) {
+			continue
+		}
+		if !wait {
+			return nil, fmt.Errorf("another bootstrap run is changing the installation (%s); use -wait to wait for it", describeLock(held))
+		}
+		if !waiting {
+			i.log.Infof("Waiting for another bootstrap run to finish (%s)...", describeLock(held))
+			waiting = true
+		}
+		select {
+		case <-i.ctx.Done():
+			return nil, i.ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// createLock creates the lock file with data unless it exists, and
+// returns the contents of an existing one
+func createLock(path string, data []byte) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		held, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Released meanwhile
+			return createLock(path, data)
+		}
+		if len(held) == 0 {
+			// Another run is still writing it
+			held = []byte("{}")
+		}
+		return held, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// staleLock removes the lock file if the run it names is gone and
+// reports whether it did. Only runs on this host can be checked, and a
+// lock that cannot be read is left alone while it may still be being
+// written.
+func (i *Installer) staleLock(path string, held []byte) bool {
+	var owner lockOwner
+	if err := json.Unmarshal(held, &owner); err != nil || owner.PID == 0 {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < lockWriteGrace {
+			return false
+		}
+		i.log.Infof("Removing unreadable install lock %s", path)
+		return removeLock(path, held)
+	}
+
+	host, _ := os.Hostname()
+	if owner.Host != host || processRunning(owner.PID) {
+		return false
+	}
+	i.log.Infof("Removing stale install lock of %s", owner)
+	return removeLock(path, held)
+}
+
+// removeLock removes the lock file if it still holds what was read from
+// it, so that a lock another run has just taken is not removed instead
+func removeLock(path string, held []byte) bool {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	if !bytes.Equal(current, held) {
+		return true
+	}
+	err = os.Remove(path)
+	return err == nil || errors.Is(err, os.ErrNotExist)
+}
+
+// releaseLock removes the lock file unless another run took it over
+func releaseLock(path string, data []byte) {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		os.Remove(path)
+	}
+}
+
+// describeLock describes the run holding a lock for messages
+func describeLock(held []byte) string {
+	var owner lockOwner
+	if err := json.Unmarshal(held, &owner); err != nil || owner.PID == 0 {
+		return "unknown process"
+	}
+	return owner.String()
+}
+
+// currentLockOwner describes this run for the lock file. Only the
+// subcommand is recorded: the rest of the command line can hold tokens.
+func currentLockOwner() lockOwner {
+	command := []string{filepath.Base(os.Args[0])}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = append(command, os.Args[1])
+	}
+	owner := lockOwner{
+		PID:       os.Getpid(),
+		Command:   strings.Join(command, " "),
+		StartedAt: time.Now().UTC(),
+	}
+	owner.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		owner.User = u.Username
+	}
+	return owner
+}
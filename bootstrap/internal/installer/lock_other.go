@@ -0,0 +1,15 @@
+//go:build !windows
+
+package installer
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process exists. A process of another
+// user that may not be signalled still exists.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,55 @@
+package installer
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// fieldLogger is a Logger that attaches structured fields to its entries,
+// like the bootstrap logger in json format
+type fieldLogger interface {
+	WithFields(fields logrus.Fields) *logrus.Entry
+	SetField(key string, value interface{})
+}
+
+// logWith returns a logger whose entries carry fields. Loggers without
+// structured output are returned as they are.
+func (i *Installer) logWith(fields logrus.Fields) Logger {
+	if i.logFields == nil {
+		return i.log
+	}
+	return warningLog{Logger: i.logFields.WithFields(fields), report: i.report}
+}
+
+// setLogField attaches a field to every entry logged from now on, or
+// removes it when value is nil
+func (i *Installer) setLogField(key string, value interface{}) {
+	if i.logFields != nil {
+		i.logFields.SetField(key, value)
+	}
+}
+
+// componentLog returns a logger whose entries carry the component
+func (i *Installer) componentLog(component string) Logger {
+	return i.logWith(logrus.Fields{logger.FieldComponent: component})
+}
+
+// forComponent runs fn with the component attached to what it logs. The
+// components must be handled one at a time.
+func (i *Installer) forComponent(component string, fn func() error) error {
+	i.setLogField(logger.FieldComponent, component)
+	defer i.setLogField(logger.FieldComponent, nil)
+	return fn()
+}
+
+// logPhaseCompleted logs how long a phase took
+func (i *Installer) logPhaseCompleted(phase string, started time.Time) {
+	elapsed := time.Since(started)
+	i.logWith(logrus.Fields{
+		logger.FieldPhase:      phase,
+		logger.FieldDurationMS: elapsed.Milliseconds(),
+	}).Infof("Completed %s phase in %s", phase, elapsed.Round(time.Millisecond))
+}
@@ -0,0 +1,92 @@
+package installer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/logger"
+	"github.com/ezra/bootstrap/pkg/companion"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// unshippedLogsFile keeps, in the log directory under DataPath, the log
+// entries a run could not send to the companion
+const unshippedLogsFile = "unshipped.jsonl"
+
+// logShipper is a Logger that can send its entries to a collector, like
+// the bootstrap logger
+type logShipper interface {
+	Ship(opts logger.ShipOptions) (stop func())
+}
+
+// newSessionID returns an ID for this run of the bootstrap, which tags
+// its log entries and install report
+func newSessionID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// SessionID returns the ID of this run of the bootstrap
+func (i *Installer) SessionID() string {
+	return i.sessionID
+}
+
+// ShipLogs streams what is logged from now on to the companion when
+// configured, tagged with the device and session IDs, and returns a
+// function that sends what is left and stops. It does nothing offline.
+func (i *Installer) ShipLogs() (stop func()) {
+	shipper, ok := i.logFields.(logShipper)
+	if !ok || !i.config.LogShipping.Enabled || i.config.OfflineMode {
+		return func() {}
+	}
+
+	device := *i.config
+	device.DeviceID = rolloutDeviceID(i.config)
+	// Each batch is tried once: the shipper sends it again later, and
+	// the client must not log, which would ship more entries
+	client := companion.New(i.config.CompanionURL, companion.Options{
+		HTTPClient: i.downloader.HTTPClient(),
+		Retry:      downloader.RetryPolicy{MaxAttempts: 1},
+		Token:      remoteConfigToken(&device),
+	})
+
+	settings := i.config.LogShipping
+	return shipper.Ship(logger.ShipOptions{
+		Send: func(ctx context.Context, entries []logger.ShippedEntry) error {
+			return client.ShipLogs(ctx, device.DeviceID, shippedEntries(entries))
+		},
+		BatchSize:     settings.BatchSize,
+		FlushInterval: time.Duration(settings.FlushIntervalSec) * time.Second,
+		BufferSize:    settings.BufferSize,
+		SpoolPath:     filepath.Join(filepath.Dir(i.config.LogFilePath()), unshippedLogsFile),
+	})
+}
+
+// shippedEntries converts log entries for the companion, taking the
+// session ID out of their fields
+func shippedEntries(entries []logger.ShippedEntry) []companion.LogEntry {
+	converted := make([]companion.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		fields := map[string]interface{}{}
+		for key, value := range entry.Fields {
+			fields[key] = value
+		}
+		session, _ := fields[logger.FieldSessionID].(string)
+		delete(fields, logger.FieldSessionID)
+
+		converted = append(converted, companion.LogEntry{
+			Time:      entry.Time,
+			Level:     entry.Level,
+			Message:   entry.Message,
+			SessionID: session,
+			Fields:    fields,
+		})
+	}
+	return converted
+}
@@ -0,0 +1,387 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// installManifestPath is where the companion publishes the install
+// manifest. Its signature is published next to it with a .sig suffix.
+const installManifestPath = "install-manifest.json"
+
+// installManifest describes a complete installation: which components to
+// download, where they go and which services run them. It lets the
+// companion add components without changes to the bootstrap.
+type installManifest struct {
+	Version    string              `json:"version"`
+	Components []manifestComponent `json:"components"`
+}
+
+// manifestComponent is a single file of an installation
+type manifestComponent struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// URL is an absolute URL or a path under the companion. When empty
+	// the component's release file for this platform is used.
+	URL       string `json:"url,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Size is the download size in bytes. When zero it is asked from the
+	// server before downloading.
+	Size int64 `json:"size,omitempty"`
+	// Target is the installed path. It may use $install_path,
+	// $data_path and $exe; relative paths are under InstallPath.
+	Target string `json:"target"`
+	// Mode is the octal file mode, 0755 by default
+	Mode    string             `json:"mode,omitempty"`
+	Service *serviceDefinition `json:"service,omitempty"`
+	// Requires skips the component on devices that cannot run it
+	Requires *hardwareRequirements `json:"requires,omitempty"`
+}
+
+// serviceDefinition describes a service running an installed component
+type serviceDefinition struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	User        string   `json:"user,omitempty"`
+	// Restart is the systemd restart policy, "always" by default
+	Restart string `json:"restart,omitempty"`
+}
+
+// fetchInstallManifest fetches and verifies the install manifest. It
+// returns nil when the companion does not publish one, in which case the
+// built-in component list is installed.
+func (i *Installer) fetchInstallManifest() (*installManifest, error) {
+	data, err := i.downloader.FetchFile(i.ctx, installManifestPath)
+	if downloader.IsNotPublished(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i.signaturesEnabled() {
+		signature, err := i.downloader.FetchFile(i.ctx, installManifestPath+".sig")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch install manifest signature: %w", err)
+		}
+		sv := i.verifier.NewStream("", strings.TrimSpace(string(signature)))
+		sv.Write(data)
+		if err := sv.Verify(); err != nil {
+			return nil, failure.Wrap(failure.Verification, fmt.Errorf("install manifest: %w", err))
+		}
+	}
+
+	var manifest installManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse install manifest: %w", err)
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("invalid install manifest: %w", err)
+	}
+
+	i.log.Infof("Using install manifest %s with %d components", manifest.Version, len(manifest.Components))
+	return &manifest, nil
+}
+
+// validate checks that every component can be installed
+func (m *installManifest) validate() error {
+	if len(m.Components) == 0 {
+		return fmt.Errorf("no components")
+	}
+
+	seen := map[string]bool{}
+	for _, component := range m.Components {
+		switch {
+		case component.Name == "":
+			return fmt.Errorf("component without a name")
+		case seen[component.Name]:
+			return fmt.Errorf("duplicate component %s", component.Name)
+		case component.Target == "":
+			return fmt.Errorf("component %s has no target", component.Name)
+		case component.Service != nil && component.Service.Name == "":
+			return fmt.Errorf("service of component %s has no name", component.Name)
+		}
+		if _, err := component.mode(); err != nil {
+			return fmt.Errorf("component %s: %w", component.Name, err)
+		}
+		seen[component.Name] = true
+	}
+	return nil
+}
+
+// mode returns the file mode the component is installed with
+func (c manifestComponent) mode() (os.FileMode, error) {
+	if c.Mode == "" {
+		return 0755, nil
+	}
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", c.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// installFromManifest runs the installation phases for the components
+// of an install manifest
+func (i *Installer) installFromManifest(manifest *installManifest) error {
+	if err := i.selectManifestComponents(manifest); err != nil {
+		return err
+	}
+
+	if err := i.runPhase(phaseDownload, func() error { return i.downloadManifestComponents(manifest) }); err != nil {
+		return fmt.Errorf("failed to download components: %w", err)
+	}
+
+	var names []string
+	locations := map[string]string{}
+	for _, component := range manifest.Components {
+		names = append(names, component.Name)
+		locations[component.Name] = component.URL
+	}
+	if err := i.verifyDownloadsProvenance(names, locations); err != nil {
+		return failure.Wrap(failure.Verification, fmt.Errorf("provenance verification failed: %w", err))
+	}
+	if err := i.checkSBOMs(names, locations); err != nil {
+		return fmt.Errorf("SBOM check failed: %w", err)
+	}
+
+	if err := i.runPhase(phaseInstall, func() error { return i.installManifestComponents(manifest) }); err != nil {
+		return fmt.Errorf("failed to install components: %w", err)
+	}
+
+	if err := i.runPhase(phaseConfigure, func() error { return i.configureManifestServices(manifest) }); err != nil {
+		return fmt.Errorf("failed to configure system: %w", err)
+	}
+
+	i.expectedVersions = map[string]string{}
+	for _, component := range manifest.Components {
+		i.expectedVersions[component.Name] = component.Version
+	}
+
+	if err := i.runPhase(phaseStart, i.withStartHooks(func() error { return i.startManifestServices(manifest) })); err != nil {
+		return fmt.Errorf("failed to start services: %w", err)
+	}
+
+	if !i.dryRun {
+		i.recordInstalledVersions()
+	}
+
+	return nil
+}
+
+// downloadManifestComponents downloads every component of the manifest
+func (i *Installer) downloadManifestComponents(manifest *installManifest) error {
+	i.log.Info("Downloading components...")
+
+	if i.dryRun {
+		for _, component := range manifest.Components {
+			location := component.URL
+			if location == "" {
+				location = i.downloader.ComponentURL(component.Name)
+			}
+			i.plan.addDownload(location)
+		}
+		return nil
+	}
+
+	if err := i.prepareDownloads(); err != nil {
+		return err
+	}
+
+	files := make([]plannedFile, 0, len(manifest.Components))
+	for _, component := range manifest.Components {
+		files = append(files, plannedFile{
+			name:     component.Name,
+			location: component.URL,
+			size:     component.Size,
+			sha256:   component.SHA256,
+			target:   i.expandTarget(component.Target),
+		})
+	}
+	if err := i.checkDiskSpace(files); err != nil {
+		return err
+	}
+
+	var jobs []downloadJob
+	for _, component := range manifest.Components {
+		sv := i.streamVerifier(downloader.ComponentManifest{
+			Version:   component.Version,
+			SHA256:    component.SHA256,
+			Signature: component.Signature,
+		})
+		sv = i.reportedVerifier(component.Name, sv)
+
+		jobs = append(jobs, downloadJob{
+			name: component.Name,
+			fetch: func(ctx context.Context, dest string) error {
+				if component.URL == "" {
+					return i.downloader.DownloadComponentVerified(ctx, component.Name, dest, sv)
+				}
+				return i.downloader.DownloadFile(ctx, component.URL, dest, sv)
+			},
+		})
+	}
+
+	return i.downloadConcurrently(jobs)
+}
+
+// installManifestComponents copies the downloaded components to their
+// targets
+func (i *Installer) installManifestComponents(manifest *installManifest) error {
+	i.log.Info("Installing components...")
+
+	for _, component := range manifest.Components {
+		target := i.expandTarget(component.Target)
+		mode, _ := component.mode()
+
+		i.componentLog(component.Name).Infof("Installing %s to %s", component.Name, target)
+		if err := i.mkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", component.Name, err)
+		}
+		if err := i.installFile(filepath.Join(i.config.CachePath, component.Name), target, mode); err != nil {
+			return fmt.Errorf("failed to install %s: %w", component.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// configureManifestServices prepares the system and registers a service
+// for every component that defines one
+func (i *Installer) configureManifestServices(manifest *installManifest) error {
+	i.log.Info("Configuring system...")
+
+	if err := i.createDirectories(); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	if err := i.createConfigFiles(); err != nil {
+		return fmt.Errorf("failed to create config files: %w", err)
+	}
+
+	for _, component := range manifest.Components {
+		if component.Service == nil {
+			continue
+		}
+		if err := i.setupManifestService(component.Service, i.expandTarget(component.Target)); err != nil {
+			return fmt.Errorf("failed to set up service %s: %w", component.Service.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// setupManifestService registers a service with the init system
+func (i *Installer) setupManifestService(service *serviceDefinition, executable string) error {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return err
+	}
+
+	switch supervisor {
+	case supervisorSystemd:
+		description := service.Description
+		if description == "" {
+			description = service.Name
+		}
+		return i.setupSystemdService(serviceSpec{
+			Name:        service.Name,
+			Description: description,
+			Command:     append([]string{executable}, service.Args...),
+			User:        service.User,
+			Restart:     service.Restart,
+		})
+	case supervisorOpenRC, supervisorRunit, supervisorSysV:
+		description := service.Description
+		if description == "" {
+			description = service.Name
+		}
+		return i.setupInitService(supervisor, serviceSpec{
+			Name:        service.Name,
+			Description: description,
+			Command:     append([]string{executable}, service.Args...),
+			User:        service.User,
+		})
+	case supervisorLaunchd:
+		return i.setupLaunchdService(serviceSpec{
+			Name:    service.Name,
+			Command: append([]string{executable}, service.Args...),
+			User:    service.User,
+		})
+	case supervisorSCM:
+		description := service.Description
+		if description == "" {
+			description = service.Name
+		}
+		return i.setupWindowsService(serviceSpec{
+			Name:        service.Name,
+			Description: description,
+			Command:     append([]string{executable}, service.Args...),
+		})
+	case supervisorTask:
+		return i.setupUserTask(serviceSpec{
+			Name:    service.Name,
+			Command: append([]string{executable}, service.Args...),
+		})
+	default:
+		// Started by startManifestServices without an init system
+		i.log.Infof("Not registering %s: no init system", service.Name)
+		return nil
+	}
+}
+
+// startManifestServices starts the services of the manifest
+func (i *Installer) startManifestServices(manifest *installManifest) error {
+	i.log.Info("Starting services...")
+
+	for _, component := range manifest.Components {
+		service := component.Service
+		if service == nil {
+			continue
+		}
+
+		i.log.Infof("Starting %s...", service.Name)
+		cmd := exec.Command(i.expandTarget(component.Target), service.Args...)
+		if err := i.startProcess(service.Name, cmd); err != nil {
+			return fmt.Errorf("failed to start %s: %w", service.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// expandTarget resolves the placeholders of a manifest target path
+func (i *Installer) expandTarget(target string) string {
+	expanded := os.Expand(target, func(name string) string {
+		switch name {
+		case "install_path":
+			return i.config.InstallPath
+		case "data_path":
+			return i.config.DataPath
+		case "exe":
+			if runtime.GOOS == "windows" {
+				return ".exe"
+			}
+			return ""
+		default:
+			return "$" + name
+		}
+	})
+
+	if !filepath.IsAbs(expanded) {
+		expanded = filepath.Join(i.config.InstallPath, expanded)
+	}
+	return expanded
+}
@@ -0,0 +1,333 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/archive"
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// mediaManifestFile lists every file on the offline media with its
+// SHA256. It is signed like a release, e.g. media-manifest.json.sig.
+const mediaManifestFile = "media-manifest.json"
+
+// offlineMediaPaths are searched for offline media mounted by hand,
+// besides the removable devices
+var offlineMediaPaths = []string{
+	"/media/ezra",
+	"/mnt/ezra",
+	"/tmp/ezra-offline",
+}
+
+// mediaSubdir is where offline media may be kept on a device that holds
+// other files too
+const mediaSubdir = "ezra"
+
+// MediaChooser asks the operator which of several offline media to
+// install from
+type MediaChooser func(candidates []string) (string, error)
+
+// SetMediaChooser sets how the operator is asked to pick offline media
+// when several are found. Without one, finding several is an error.
+func (i *Installer) SetMediaChooser(choose MediaChooser) {
+	i.chooseMedia = choose
+}
+
+// SetMediaPath sets the offline media to install from instead of
+// searching for it
+func (i *Installer) SetMediaPath(path string) {
+	i.mediaPath = path
+}
+
+// findOfflineMedia returns the offline media to install from: the one
+// given, otherwise the removable device or hand-mounted directory holding
+// a media manifest, at its root or in an ezra directory
+func (i *Installer) findOfflineMedia() (string, error) {
+	if i.mediaPath != "" {
+		return i.mediaPath, nil
+	}
+
+	mounts, err := detector.RemovableMounts()
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		i.log.Errorf("Cannot list removable devices: %v", err)
+	}
+	var candidates []string
+	for _, dir := range append(mounts, offlineMediaPaths...) {
+		for _, path := range []string{dir, filepath.Join(dir, mediaSubdir)} {
+			if fileExists(filepath.Join(path, mediaManifestFile)) && !contains(candidates, path) {
+				candidates = append(candidates, path)
+			}
+		}
+	}
+
+	var path string
+	switch {
+	case len(candidates) == 0:
+		return "", fmt.Errorf("no offline media found: no removable device or %s holds %s", strings.Join(offlineMediaPaths, ", "), mediaManifestFile)
+	case len(candidates) == 1:
+		path = candidates[0]
+	case i.chooseMedia == nil:
+		return "", fmt.Errorf("several offline media found, choose one of %s", strings.Join(candidates, ", "))
+	default:
+		if path, err = i.chooseMedia(candidates); err != nil {
+			return "", err
+		}
+	}
+
+	i.log.Infof("Using offline media at %s", path)
+	i.mediaPath = path
+	return path, nil
+}
+
+// openMedia verifies the offline media and returns the directory holding
+// the components for this device. Media can be a directory, or an archive
+// of offline media or of a release bundle, whose detached signature is
+// verified before it is extracted and which closeMedia removes again.
+func (i *Installer) openMedia(mediaPath string) (dir string, closeMedia func(), err error) {
+	info, err := os.Stat(mediaPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		if err := i.verifyMedia(mediaPath); err != nil {
+			return "", nil, err
+		}
+		return i.mediaDir(mediaPath), func() {}, nil
+	}
+
+	// A signature next to the archive covers everything in it. Nothing
+	// is extracted before it is verified, so with verify_signatures on an
+	// archive without one is refused.
+	signed := false
+	if i.config.VerifySigs {
+		switch {
+		case !hasSignatureFile(mediaPath):
+			return "", nil, fmt.Errorf("%s is not signed: put its .sig, .minisig, .asc or .bundle next to it", filepath.Base(mediaPath))
+		case !i.signaturesEnabled():
+			return "", nil, fmt.Errorf("%s cannot be verified: no public key is configured", filepath.Base(mediaPath))
+		}
+		if err := i.verifier.VerifyRelease(mediaPath); err != nil {
+			return "", nil, fmt.Errorf("%s: %w", filepath.Base(mediaPath), err)
+		}
+		signed = true
+	}
+
+	extracted, err := os.MkdirTemp("", "ezra-media-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	closeMedia = func() {
+		os.RemoveAll(extracted)
+		i.mediaPath = mediaPath
+	}
+
+	i.log.Infof("Extracting %s...", mediaPath)
+	if err := archive.Extract(mediaPath, extracted); err != nil {
+		closeMedia()
+		return "", nil, fmt.Errorf("failed to extract %s: %w", filepath.Base(mediaPath), err)
+	}
+	// Files read from the media later, such as the advisory database,
+	// are read from the extracted copy
+	i.mediaPath = extracted
+
+	if fileExists(filepath.Join(extracted, mediaManifestFile)) {
+		err = i.verifyMedia(extracted)
+		dir = i.mediaDir(extracted)
+	} else {
+		err = i.verifyBundleMedia(extracted, signed)
+		dir = extracted
+	}
+	if err != nil {
+		closeMedia()
+		return "", nil, err
+	}
+	return dir, closeMedia, nil
+}
+
+// hasSignatureFile reports whether a detached signature of a file lies
+// next to it
+func hasSignatureFile(path string) bool {
+	for _, ext := range []string{".sig", ".minisig", ".asc", ".bundle"} {
+		if fileExists(path + ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaManifest is the signed inventory of the offline media
+type mediaManifest struct {
+	Version string `json:"version"`
+	// Platforms lists the platforms media made by create-media carries
+	// components for, each in a directory of its own
+	Platforms []string `json:"platforms,omitempty"`
+	// Files maps slash separated paths relative to the media root to
+	// their SHA256
+	Files map[string]string `json:"files"`
+}
+
+// mediaDir returns the directory of the offline media holding the
+// components for this device: the one named after its platform on
+// media made for several, otherwise the media root
+func (i *Installer) mediaDir(mediaPath string) string {
+	dir := filepath.Join(mediaPath, downloadTarget(i.config, i.systemInfo).Platform())
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return mediaPath
+}
+
+// verifyMedia checks the signature of the media manifest and the files
+// that will be copied from the media against it. Files missing from the
+// manifest, or listed but missing from the media, refuse the
+// installation. Their hashes are checked on the copies, see checkCopy,
+// so that the media cannot change between the check and the copy.
+func (i *Installer) verifyMedia(mediaPath string) error {
+	i.log.Info("Verifying offline media...")
+
+	manifestPath := filepath.Join(mediaPath, mediaManifestFile)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read media manifest: %w", err)
+	}
+
+	switch {
+	case i.signaturesEnabled():
+		if err := i.verifier.VerifyRelease(manifestPath); err != nil {
+			return fmt.Errorf("media manifest: %w", err)
+		}
+	case i.config.VerifySigs:
+		return fmt.Errorf("%s cannot be verified: no public key is configured", mediaManifestFile)
+	default:
+		i.log.Info("Signature verification disabled, checking media files against an unsigned manifest")
+	}
+
+	var manifest mediaManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse media manifest: %w", err)
+	}
+	// The media carries the components of one release
+	i.mediaVersions = map[string]string{}
+	for _, component := range i.components {
+		i.mediaVersions[component] = manifest.Version
+	}
+
+	dir := i.mediaDir(mediaPath)
+	prefix, err := filepath.Rel(mediaPath, dir)
+	if err != nil {
+		return err
+	}
+	if prefix == "." {
+		prefix = ""
+	} else {
+		prefix = filepath.ToSlash(prefix) + "/"
+		i.log.Infof("Using the components for %s", strings.TrimSuffix(prefix, "/"))
+	}
+
+	// The files for this device, by their path below dir
+	files := map[string]string{}
+	for rel, checksum := range manifest.Files {
+		if inDir, ok := strings.CutPrefix(rel, prefix); ok {
+			files[inDir] = strings.ToLower(checksum)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, component := range i.components {
+		root := filepath.Join(dir, component)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !d.Type().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", prefix+rel)
+			}
+			if _, ok := files[rel]; !ok {
+				return fmt.Errorf("%s is not listed in the media manifest", prefix+rel)
+			}
+			seen[rel] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Files of the copied components listed in the manifest must all be
+	// on the media
+	var missing []string
+	for rel := range files {
+		component, _, _ := strings.Cut(rel, "/")
+		if i.selected(component) && !seen[rel] {
+			missing = append(missing, prefix+rel)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("files missing from the media: %s", strings.Join(missing, ", "))
+	}
+
+	i.mediaFiles = files
+	i.log.Infof("Offline media manifest verified (%d files)", len(seen))
+	return nil
+}
+
+// checkCopy checks the files of a component copied from offline media
+// against the media manifest. copied are the SHA-256 of the copied
+// files, hashed as they were read from the media, by their path below
+// the component. Media without a manifest, such as a release bundle, was
+// verified as it was extracted.
+func (i *Installer) checkCopy(component string, copied map[string]string) error {
+	if i.mediaFiles == nil || i.dryRun {
+		return nil
+	}
+
+	want := map[string]string{}
+	for rel, checksum := range i.mediaFiles {
+		switch {
+		case rel == component:
+			want["."] = checksum
+		case strings.HasPrefix(rel, component+"/"):
+			want[strings.TrimPrefix(rel, component+"/")] = checksum
+		}
+	}
+
+	for rel, checksum := range copied {
+		name := path.Join(component, rel)
+		expected, ok := want[rel]
+		if !ok {
+			return fmt.Errorf("%s is not listed in the media manifest", name)
+		}
+		if checksum != expected {
+			return fmt.Errorf("%s: sha256 mismatch: expected %s, got %s", name, expected, checksum)
+		}
+	}
+	var missing []string
+	for rel := range want {
+		if _, ok := copied[rel]; !ok {
+			missing = append(missing, path.Join(component, rel))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("files missing from the copy: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package installer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ezra/bootstrap/internal/config"
+)
+
+// testLogger discards the installer's output
+type testLogger struct{}
+
+func (testLogger) Info(args ...interface{})                  {}
+func (testLogger) Infof(format string, args ...interface{})  {}
+func (testLogger) Error(args ...interface{})                 {}
+func (testLogger) Errorf(format string, args ...interface{}) {}
+
+// mediaAgent is the file of the agent on the test media
+const mediaAgent = "agent/ezra-agent"
+
+// newMediaInstaller returns an installer of the agent copying into a
+// temporary data directory, checking signatures with publicKey if given
+func newMediaInstaller(t *testing.T, verifySigs bool, publicKey string) *Installer {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.DataPath = t.TempDir()
+	cfg.Progress = "log"
+	cfg.VerifySigs = verifySigs
+	cfg.PublicKey = publicKey
+	return &Installer{
+		config:     cfg,
+		log:        testLogger{},
+		verifier:   NewVerifier(cfg, testLogger{}),
+		components: []string{"agent"},
+	}
+}
+
+// writeMedia writes offline media holding files and a manifest listing
+// them, signed with private if given
+func writeMedia(t *testing.T, files map[string]string, private ed25519.PrivateKey) string {
+	t.Helper()
+	dir := t.TempDir()
+	manifest := mediaManifest{Version: "1.2.3", Files: map[string]string{}}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(data))
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(dir, mediaManifestFile)
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if private != nil {
+		sum := sha256.Sum256(data)
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, sum[:]))
+		if err := os.WriteFile(manifestPath+".sig", []byte(signature), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestVerifyMediaSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(public)
+
+	tests := []struct {
+		name       string
+		verifySigs bool
+		publicKey  string
+		signer     ed25519.PrivateKey
+		wantErr    string
+	}{
+		{"signatures off", false, "", nil, ""},
+		{"signed", true, key, private, ""},
+		{"no public key", true, "", private, "cannot be verified: no public key is configured"},
+		{"unsigned without a public key", true, "", nil, "cannot be verified: no public key is configured"},
+		{"unsigned", true, key, nil, "signature file not found"},
+		{"signed with another key", true, key, other, "media manifest"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			media := writeMedia(t, map[string]string{mediaAgent: "agent"}, test.signer)
+			err := newMediaInstaller(t, test.verifySigs, test.publicKey).verifyMedia(media)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("verifyMedia: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("verifyMedia = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMediaFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// change alters the media after its manifest is written
+		change  func(media string) error
+		wantErr string
+	}{
+		{"unlisted file", func(media string) error {
+			return os.WriteFile(filepath.Join(media, "agent", "extra"), nil, 0644)
+		}, "agent/extra is not listed"},
+		{"missing file", func(media string) error {
+			return os.Remove(filepath.Join(media, filepath.FromSlash(mediaAgent)))
+		}, "files missing from the media: " + mediaAgent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			media := writeMedia(t, map[string]string{mediaAgent: "agent"}, nil)
+			if err := test.change(media); err != nil {
+				t.Fatal(err)
+			}
+			err := newMediaInstaller(t, false, "").verifyMedia(media)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("verifyMedia = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopyComponentsChecksCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		// change alters the media between its verification and the copy
+		change  func(media string) error
+		wantErr string
+	}{
+		{"unchanged", func(string) error { return nil }, ""},
+		{"file replaced", func(media string) error {
+			return os.WriteFile(filepath.Join(media, filepath.FromSlash(mediaAgent)), []byte("evil"), 0644)
+		}, "sha256 mismatch"},
+		{"file added", func(media string) error {
+			return os.WriteFile(filepath.Join(media, "agent", "extra"), []byte("evil"), 0644)
+		}, "agent/extra is not listed"},
+		{"file removed", func(media string) error {
+			return os.Remove(filepath.Join(media, filepath.FromSlash(mediaAgent)))
+		}, "files missing from the copy"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			media := writeMedia(t, map[string]string{mediaAgent: "agent"}, nil)
+			inst := newMediaInstaller(t, false, "")
+			if err := inst.verifyMedia(media); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.change(media); err != nil {
+				t.Fatal(err)
+			}
+
+			err := inst.copyComponents(media)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("copyComponents: %v", err)
+				}
+				data, err := os.ReadFile(filepath.Join(inst.config.DataPath, filepath.FromSlash(mediaAgent)))
+				if err != nil || string(data) != "agent" {
+					t.Errorf("copied agent = %q, %v", data, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("copyComponents = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
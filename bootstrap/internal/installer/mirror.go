@@ -0,0 +1,468 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// mirrorStateFile records the files a mirror sync wrote with their
+// SHA256, so that the next sync only downloads what changed
+const mirrorStateFile = ".ezra-mirror.json"
+
+// mirrorPlatforms are synced unless others are given. Platforms nothing
+// is published for are skipped.
+var mirrorPlatforms = []string{
+	"linux/x86_64",
+	"linux/x86_64-musl",
+	"linux/aarch64",
+	"linux/aarch64-musl",
+	"linux/armv7",
+	"linux/armv6",
+	"darwin/x86_64",
+	"darwin/aarch64",
+	"windows/x86_64",
+	"windows/aarch64",
+}
+
+// mirrorVariants are the builds of a component besides the plain one
+var mirrorVariants = map[string][]string{
+	"executor": {detector.RuntimeCUDA, detector.RuntimeROCm},
+}
+
+// releaseSidecars are published next to release files besides their
+// .sha256 checksum: signatures, provenance attestations and SBOMs
+var releaseSidecars = []string{".sig", ".minisig", ".asc", ".intoto.jsonl", ".cdx.json", ".spdx.json"}
+
+// MirrorOptions describes a mirror to sync
+type MirrorOptions struct {
+	// Target is the directory the release tree is written to, to be
+	// served over HTTP as a mirror
+	Target string
+	// Platforms are the targets to sync builds for. Empty selects every
+	// platform Ezra is published for.
+	Platforms []downloader.Target
+	// LatestOnly syncs releases/latest only instead of every version in
+	// the release index
+	LatestOnly bool
+	// Prune removes files an earlier sync wrote that are no longer
+	// published
+	Prune bool
+}
+
+// MirrorReport describes what a mirror sync changed
+type MirrorReport struct {
+	// Downloaded lists the files that were new or changed
+	Downloaded []string `json:"downloaded"`
+	// Unchanged counts the files that were already up to date
+	Unchanged int `json:"unchanged"`
+	// Removed lists the files that were pruned
+	Removed []string `json:"removed"`
+	// Unverified lists the release files published without a checksum
+	// or signature to check them against
+	Unverified []string `json:"unverified"`
+}
+
+// mirrorState is what mirrorStateFile holds
+type mirrorState struct {
+	// Files maps slash separated paths in the mirror to their SHA256
+	Files map[string]string `json:"files"`
+}
+
+// mirrorSync is a single sync of a mirror
+type mirrorSync struct {
+	i      *Installer
+	target string
+	// previous is the state the last sync left, synced what this one
+	// wrote or found unchanged
+	previous map[string]string
+	synced   map[string]string
+	report   *MirrorReport
+}
+
+// SyncMirror copies the release tree from the companion into a directory
+// that can be served as a mirror: the release index, the manifests and
+// every published build of each release with its checksums, signatures,
+// provenance and SBOMs, patches, bundles, the key rotation document, the
+// install manifest and TUF metadata and targets. Files already up to
+// date are not downloaded again, and release files are checked against
+// their checksums and signatures.
+func (i *Installer) SyncMirror(opts MirrorOptions) (*MirrorReport, error) {
+	i.log.Infof("Syncing release mirror in %s...", opts.Target)
+
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		for _, platform := range mirrorPlatforms {
+			target, err := ParsePlatform(platform)
+			if err != nil {
+				return nil, err
+			}
+			platforms = append(platforms, target)
+		}
+	}
+	if err := os.MkdirAll(opts.Target, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mirror directory: %w", err)
+	}
+
+	s := &mirrorSync{
+		i:        i,
+		target:   opts.Target,
+		previous: loadMirrorState(opts.Target),
+		synced:   map[string]string{},
+		report:   &MirrorReport{},
+	}
+
+	// Sets up the keys release files are checked against, and TUF
+	if _, err := i.fetchRelease(); err != nil {
+		return nil, err
+	}
+
+	for _, path := range []string{installManifestPath, keyRotationFile} {
+		if err := s.fetchSigned(path); err != nil {
+			return nil, err
+		}
+	}
+
+	releases := map[string][]string{"latest": components}
+	data, ok, err := s.fetch(downloader.ReleaseIndexPath)
+	if err != nil {
+		return nil, err
+	}
+	if ok && !opts.LatestOnly {
+		var index downloader.ReleaseIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse release index: %w", err)
+		}
+		for component, entries := range index.Components {
+			for _, entry := range entries {
+				if entry.Version != "" && !contains(releases[entry.Version], component) {
+					releases[entry.Version] = append(releases[entry.Version], component)
+				}
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(releases))
+	for dir := range releases {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := s.syncRelease(dir, releases[dir], platforms); err != nil {
+			return s.report, err
+		}
+	}
+
+	if i.tuf != nil {
+		if err := s.syncTUF(); err != nil {
+			return s.report, err
+		}
+	}
+
+	if opts.Prune {
+		s.prune()
+	} else {
+		// Kept so that a later sync with pruning can remove them
+		for path, sum := range s.previous {
+			if _, ok := s.synced[path]; !ok && fileExists(filepath.Join(s.target, filepath.FromSlash(path))) {
+				s.synced[path] = sum
+			}
+		}
+	}
+	if err := saveMirrorState(opts.Target, s.synced); err != nil {
+		return s.report, err
+	}
+
+	i.log.Infof("Mirror synced: %d files downloaded, %d unchanged, %d removed", len(s.report.Downloaded), s.report.Unchanged, len(s.report.Removed))
+	return s.report, nil
+}
+
+// syncRelease syncs a release directory: its manifest, the builds of its
+// components for every platform with their sidecar files, their patches
+// and, for the latest release, its bundles
+func (s *mirrorSync) syncRelease(dir string, releaseComponents []string, platforms []downloader.Target) error {
+	s.i.log.Infof("Syncing release %s...", dir)
+	base := "releases/" + dir + "/"
+
+	manifestPath := base + "manifest.json"
+	if err := s.fetchSigned(manifestPath); err != nil {
+		return err
+	}
+	var manifest downloader.Manifest
+	if sum, ok := s.synced[manifestPath]; ok && sum != "" {
+		data, err := os.ReadFile(filepath.Join(s.target, filepath.FromSlash(manifestPath)))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", manifestPath, err)
+		}
+	}
+
+	// Versioned releases never change, latest does with every release
+	immutable := dir != "latest"
+
+	for _, component := range releaseComponents {
+		names := []string{component}
+		for _, variant := range mirrorVariants[component] {
+			names = append(names, component+"-"+variant)
+		}
+		for _, target := range platforms {
+			for _, name := range names {
+				if err := s.syncReleaseFile(base+target.Filename(name), immutable); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, patch := range manifest.Components[component].Patches {
+			published, err := s.syncFile(patch.Path, patch.SHA256, true)
+			if err != nil {
+				return err
+			}
+			if !published {
+				s.i.log.Errorf("Patch %s listed in %s is not published", patch.Path, manifestPath)
+			}
+		}
+	}
+
+	if dir == "latest" {
+		for name, bundle := range manifest.Bundles {
+			published, err := s.syncFile(base+name, bundle.SHA256, false)
+			if err != nil {
+				return err
+			}
+			if !published {
+				s.i.log.Errorf("Bundle %s listed in %s is not published", name, manifestPath)
+			}
+		}
+	}
+	return nil
+}
+
+// syncReleaseFile syncs a build with its sidecar files and checks it
+// against its published checksum and signatures. Builds not published
+// for a platform are skipped.
+func (s *mirrorSync) syncReleaseFile(path string, immutable bool) error {
+	data, ok, err := s.fetch(path + ".sha256")
+	if err != nil {
+		return err
+	}
+	sum := ""
+	if fields := strings.Fields(string(data)); ok && len(fields) > 0 {
+		sum = fields[0]
+	}
+	if sum == "" && s.i.tuf != nil && !immutable {
+		if target, err := s.i.tuf.Target(filepath.Base(path)); err == nil {
+			sum = target.Hashes["sha256"]
+		}
+	}
+
+	published, err := s.syncFile(path, sum, immutable)
+	if err != nil || !published {
+		return err
+	}
+
+	verified := sum != ""
+	dest := filepath.Join(s.target, filepath.FromSlash(path))
+	for _, ext := range releaseSidecars {
+		signature, ok, err := s.fetch(path + ext)
+		if err != nil {
+			return err
+		}
+		if !ok || !s.i.signaturesEnabled() || (ext != ".sig" && ext != ".minisig" && ext != ".asc") {
+			continue
+		}
+		if err := s.i.verifier.VerifyFile(dest, string(signature)); err != nil {
+			os.Remove(dest)
+			delete(s.synced, path)
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		verified = true
+	}
+	if !verified {
+		s.report.Unverified = append(s.report.Unverified, path)
+	}
+	return nil
+}
+
+// syncTUF syncs the TUF metadata the downloader verified, and every
+// target it lists
+func (s *mirrorSync) syncTUF() error {
+	s.i.log.Info("Syncing TUF metadata...")
+	repo := s.i.tuf
+
+	names := []string{"timestamp.json", "snapshot.json", "targets.json"}
+	for version := int64(1); version <= repo.RootVersion(); version++ {
+		names = append(names, fmt.Sprintf("%d.root.json", version))
+	}
+	if repo.ConsistentSnapshot() {
+		names = append(names,
+			fmt.Sprintf("%d.snapshot.json", repo.SnapshotVersion()),
+			fmt.Sprintf("%d.targets.json", repo.TargetsVersion()))
+	}
+	for _, name := range names {
+		if _, _, err := s.fetch("tuf/" + name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range repo.TargetNames() {
+		target, err := repo.Target(name)
+		if err != nil {
+			return err
+		}
+		sum := target.Hashes["sha256"]
+		path := "tuf/targets/" + name
+		if repo.ConsistentSnapshot() && sum != "" {
+			path = "tuf/targets/" + sum + "." + name
+		}
+		published, err := s.syncFile(path, sum, sum != "")
+		if err != nil {
+			return err
+		}
+		if !published {
+			s.i.log.Errorf("TUF target %s is not published", name)
+		}
+	}
+	return nil
+}
+
+// fetchSigned syncs a small file and its signature
+func (s *mirrorSync) fetchSigned(path string) error {
+	if _, ok, err := s.fetch(path); err != nil || !ok {
+		return err
+	}
+	_, _, err := s.fetch(path + ".sig")
+	return err
+}
+
+// fetch syncs a small file, such as a manifest or signature, which is
+// always fetched again. ok is false if the file is not published.
+func (s *mirrorSync) fetch(path string) (data []byte, ok bool, err error) {
+	data, err = s.i.downloader.FetchFile(s.i.ctx, path)
+	if downloader.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+
+	dest := filepath.Join(s.target, filepath.FromSlash(path))
+	digest := sha256.Sum256(data)
+	sum := hex.EncodeToString(digest[:])
+	if previous, err := fileSHA256(dest); err == nil && previous == sum {
+		s.report.Unchanged++
+		s.synced[path] = sum
+		return data, true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, false, err
+	}
+	if err := os.WriteFile(dest+".sync", data, 0644); err != nil {
+		return nil, false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(dest+".sync", dest); err != nil {
+		return nil, false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	s.synced[path] = sum
+	s.report.Downloaded = append(s.report.Downloaded, path)
+	return data, true, nil
+}
+
+// syncFile syncs a large file, such as a build or patch. A copy matching
+// the expected SHA256, or without one an immutable file the last sync
+// wrote, is kept. The file is downloaded beside its destination and
+// renamed into place, so that the mirror never serves a partial file.
+// published is false if the file is not published.
+func (s *mirrorSync) syncFile(path, sum string, immutable bool) (published bool, err error) {
+	dest := filepath.Join(s.target, filepath.FromSlash(path))
+	if local, err := fileSHA256(dest); err == nil {
+		if sum != "" && strings.EqualFold(local, sum) || sum == "" && immutable && s.previous[path] == local {
+			s.report.Unchanged++
+			s.synced[path] = local
+			return true, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	var sv downloader.StreamVerifier
+	if sum != "" {
+		sv = s.i.verifier.NewStream(sum, "")
+	}
+	tmp := dest + ".sync"
+	err = s.i.downloader.DownloadFile(s.i.ctx, path, tmp, sv)
+	if downloader.IsNotFound(err) {
+		os.Remove(tmp)
+		return false, nil
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return false, fmt.Errorf("failed to move %s into place: %w", path, err)
+	}
+
+	local, err := fileSHA256(dest)
+	if err != nil {
+		return false, err
+	}
+	s.synced[path] = local
+	s.report.Downloaded = append(s.report.Downloaded, path)
+	return true, nil
+}
+
+// prune removes the files the last sync wrote that this one did not
+func (s *mirrorSync) prune() {
+	var paths []string
+	for path := range s.previous {
+		if _, ok := s.synced[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		err := os.Remove(filepath.Join(s.target, filepath.FromSlash(path)))
+		if err != nil && !os.IsNotExist(err) {
+			s.i.log.Errorf("Failed to remove %s: %v", path, err)
+			s.synced[path] = s.previous[path]
+			continue
+		}
+		s.report.Removed = append(s.report.Removed, path)
+	}
+}
+
+// loadMirrorState reads what the last sync of a mirror wrote
+func loadMirrorState(target string) map[string]string {
+	var state mirrorState
+	data, err := os.ReadFile(filepath.Join(target, mirrorStateFile))
+	if err != nil || json.Unmarshal(data, &state) != nil || state.Files == nil {
+		return map[string]string{}
+	}
+	return state.Files
+}
+
+// saveMirrorState records what a sync wrote
+func saveMirrorState(target string, files map[string]string) error {
+	data, err := json.MarshalIndent(mirrorState{Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(target, mirrorStateFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save mirror state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package installer
+
+import "github.com/ezra/bootstrap/pkg/downloader"
+
+// CheckNetwork checks how the companion can be reached from this
+// network, through the configured proxy
+func (i *Installer) CheckNetwork() *downloader.NetworkReport {
+	return i.downloader.CheckNetwork(i.ctx, i.config.ConnectivityCheckURL)
+}
@@ -0,0 +1,141 @@
+package installer
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/companion"
+)
+
+// Pairing defaults for companions that leave them out
+const (
+	defaultPairingInterval = 5 * time.Second
+	defaultPairingTimeout  = 15 * time.Minute
+)
+
+// PairingDisplay shows the operator the code a pairing is approved with
+// and, when the companion has one, the page that approves it
+type PairingDisplay func(code, approveURL string, expires time.Time)
+
+// Pair pairs a device without a keyboard with the companion: a short
+// code is shown, and once the operator approves it in the companion the
+// device's credential is stored and the configuration the companion
+// keeps for the device is merged under the local one. It returns the
+// settings the caller keeps in the local configuration file: the
+// device's identity and enrollment, never what the companion set, which
+// later runs pull again.
+func (i *Installer) Pair(show PairingDisplay) (map[string]interface{}, error) {
+	if i.dryRun {
+		return nil, errors.New("a dry run cannot pair the device")
+	}
+	if err := i.mkdirAll(i.config.DataPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", i.config.DataPath, err)
+	}
+	key, err := i.deviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	i.log.Info("Starting pairing with the companion...")
+	pairing, err := i.companion.StartPairing(i.ctx, companion.PairingRequest{
+		DeviceID:  i.config.DeviceID,
+		System:    i.systemInfo,
+		PublicKey: key.Public().(ed25519.PublicKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expires := pairing.ExpiresAt
+	if expires.IsZero() {
+		expires = time.Now().Add(defaultPairingTimeout)
+	}
+	show(pairing.Code, pairing.ApproveURL, expires)
+
+	status, err := i.waitForPairing(pairing, key, expires)
+	if err != nil {
+		return nil, err
+	}
+	if status.Credential == nil {
+		return nil, errors.New("the companion approved the pairing but issued no credential")
+	}
+
+	// The companion may name the device, so its configuration is merged
+	// before the credential is stored for it, as a pulled one is. The
+	// data stays where the device key already is.
+	settings := map[string]interface{}{}
+	if len(status.Config) > 0 {
+		dataPath := i.config.DataPath
+		ignored, err := i.config.MergeRemote(status.Config)
+		if err != nil {
+			return nil, err
+		}
+		if len(ignored) > 0 {
+			i.log.Errorf("Ignoring settings only the local configuration can change: %s", strings.Join(ignored, ", "))
+		}
+		i.config.DataPath = dataPath
+
+		settings["remote_config.enabled"] = true
+		if i.config.Origin("device_id") == config.OriginCompanion {
+			settings["device_id"] = i.config.DeviceID
+		}
+	}
+	enrolls, err := i.enrolls()
+	if err != nil {
+		return nil, err
+	}
+	if !enrolls {
+		i.config.Enrollment.Mode = enrollModeRequired
+		settings["enrollment.mode"] = enrollModeRequired
+	}
+	if err := i.storeCredential(status.Credential, key); err != nil {
+		return nil, err
+	}
+
+	i.log.Infof("Device %s paired", i.config.DeviceID)
+	return settings, nil
+}
+
+// waitForPairing asks the companion for the outcome of a pairing until
+// the operator approves or rejects it, or it expires
+func (i *Installer) waitForPairing(pairing *companion.Pairing, key ed25519.PrivateKey, expires time.Time) (*companion.PairingStatus, error) {
+	interval := defaultPairingInterval
+	if pairing.Interval > 0 {
+		interval = time.Duration(pairing.Interval) * time.Second
+	}
+	signature := ed25519.Sign(key, []byte(pairing.ID))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.ctx.Done():
+			return nil, i.ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := i.companion.PairingStatus(i.ctx, pairing.ID, signature)
+		if err != nil {
+			return nil, err
+		}
+		switch status.Status {
+		case companion.PairingApproved:
+			return status, nil
+		case companion.PairingRejected:
+			return nil, errors.New("the pairing was rejected in the companion")
+		case companion.PairingExpired:
+			return nil, errors.New("the pairing code expired before it was approved")
+		case companion.PairingPending, "":
+		default:
+			return nil, fmt.Errorf("unknown pairing status %q", status.Status)
+		}
+
+		if time.Now().After(expires) {
+			return nil, errors.New("the pairing code expired before it was approved")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package installer
+
+import "os"
+
+// Plan lists the actions an installation would perform. It is filled in
+// when the installer runs in dry-run mode.
+type Plan struct {
+	Downloads   []string `json:"downloads"`
+	Directories []string `json:"directories"`
+	Files       []string `json:"files"`
+	Services    []string `json:"services"`
+	Commands    []string `json:"commands"`
+}
+
+// SetDryRun enables or disables dry-run mode. In dry-run mode the
+// installer walks the full installation but only records what it would
+// do in the plan instead of changing the system.
+func (i *Installer) SetDryRun(enabled bool) {
+	i.dryRun = enabled
+	if enabled {
+		i.plan = &Plan{}
+	} else {
+		i.plan = nil
+	}
+}
+
+// Plan returns the plan recorded during a dry run
+func (i *Installer) Plan() *Plan {
+	return i.plan
+}
+
+func (p *Plan) addDownload(url string) {
+	p.Downloads = append(p.Downloads, url)
+}
+
+func (p *Plan) addDirectory(dir string) {
+	if _, err := os.Stat(dir); err == nil {
+		return
+	}
+	p.Directories = append(p.Directories, dir)
+}
+
+func (p *Plan) addFile(path string) {
+	p.Files = append(p.Files, path)
+}
+
+func (p *Plan) addService(name string) {
+	p.Services = append(p.Services, name)
+}
+
+func (p *Plan) addCommand(command string) {
+	p.Commands = append(p.Commands, command)
+}
@@ -0,0 +1,96 @@
+package installer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// defaultCompanionPort is used when neither companion_port nor a loopback
+// companion URL names one
+const defaultCompanionPort = 3000
+
+// portOwner is the process listening on a port, as far as it could be
+// identified
+type portOwner struct {
+	PID  int
+	Name string
+}
+
+func (o portOwner) String() string {
+	switch {
+	case o.PID == 0:
+		return "another process"
+	case o.Name == "":
+		return fmt.Sprintf("process %d", o.PID)
+	default:
+		return fmt.Sprintf("%s (pid %d)", o.Name, o.PID)
+	}
+}
+
+// configuredCompanionPort returns the port the companion should listen on
+func (i *Installer) configuredCompanionPort() int {
+	if i.config.CompanionPort > 0 {
+		return i.config.CompanionPort
+	}
+	if u, err := url.Parse(i.config.CompanionURL); err == nil && isLoopback(u.Hostname()) {
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			return port
+		}
+	}
+	return defaultCompanionPort
+}
+
+// chooseCompanionPort picks the port the companion is started on. A port
+// held by an earlier companion is kept since that companion is replaced.
+// When another process holds it, the following companion_port_search
+// ports are tried; without a free one the install fails naming the
+// process in the way.
+func (i *Installer) chooseCompanionPort() error {
+	port := i.configuredCompanionPort()
+
+	free, owner := portAvailable(port)
+	if free || owner.Name == "ezra-companion" {
+		i.companionPort = port
+		return nil
+	}
+	i.log.Infof("Port %d is used by %s", port, owner)
+
+	for candidate := port + 1; candidate <= port+i.config.CompanionPortSearch && candidate <= 65535; candidate++ {
+		if free, _ := portAvailable(candidate); free {
+			i.log.Infof("Starting the companion on port %d instead", candidate)
+			i.companionPort = candidate
+			return nil
+		}
+	}
+
+	return fmt.Errorf("companion port %d is used by %s: stop it, or set companion_port to a free port or companion_port_search to look for one", port, owner)
+}
+
+// portAvailable reports whether a TCP port can be listened on, and if
+// not, which process holds it
+func portAvailable(port int) (bool, portOwner) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err == nil {
+		listener.Close()
+		return true, portOwner{}
+	}
+	if !addrInUse(err) {
+		// Not allowed to bind, e.g. a privileged port: let the companion
+		// report it
+		return true, portOwner{}
+	}
+	return false, listeningProcess(port)
+}
+
+// companionURL is the URL the agent reaches the companion at: the
+// configured URL, moved to the chosen port if it is a local one
+func (i *Installer) companionURL() string {
+	u, err := url.Parse(i.config.CompanionURL)
+	if err != nil || !isLoopback(u.Hostname()) || i.companionPort == 0 {
+		return i.config.CompanionURL
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(i.companionPort))
+	return u.String()
+}
@@ -0,0 +1,79 @@
+package installer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// tcpListen is the LISTEN state in /proc/net/tcp
+const tcpListen = "0A"
+
+// addrInUse reports whether a listen error means the port is taken
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// listeningProcess finds the process listening on a TCP port through the
+// socket inodes in /proc/net/tcp and the file descriptors of every
+// process. Processes of other users are only visible to root.
+func listeningProcess(port int) portOwner {
+	inodes := map[string]bool{}
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, inode := range listeningInodes(table, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return portOwner{}
+	}
+
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				pid, _ := strconv.Atoi(filepath.Base(proc))
+				comm, _ := os.ReadFile(filepath.Join(proc, "comm"))
+				return portOwner{PID: pid, Name: strings.TrimSpace(string(comm))}
+			}
+		}
+	}
+	return portOwner{}
+}
+
+// listeningInodes returns the inodes of the sockets listening on a port
+// in a /proc/net/tcp table
+func listeningInodes(table string, port int) []string {
+	file, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	suffix := fmt.Sprintf(":%04X", port)
+	var inodes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpListen || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
@@ -0,0 +1,37 @@
+//go:build !linux && !windows
+
+package installer
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// addrInUse reports whether a listen error means the port is taken
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// listeningProcess asks lsof for the process listening on a TCP port
+func listeningProcess(port int) portOwner {
+	out, err := exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return portOwner{}
+	}
+
+	// One field per line, prefixed with its name: p for the PID and c for
+	// the command
+	var owner portOwner
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && owner.PID == 0:
+			owner.PID, _ = strconv.Atoi(line[1:])
+		case strings.HasPrefix(line, "c") && owner.Name == "":
+			owner.Name = line[1:]
+		}
+	}
+	return owner
+}
@@ -0,0 +1,74 @@
+package installer
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procGetExtendedTcpTable is not wrapped by x/sys/windows
+var procGetExtendedTcpTable = windows.NewLazySystemDLL("iphlpapi.dll").NewProc("GetExtendedTcpTable")
+
+// tcpTableOwnerPIDListener selects the listening sockets with their
+// owning process
+const tcpTableOwnerPIDListener = 3
+
+// tcpRowOwnerPID is the MIB_TCPROW_OWNER_PID structure
+type tcpRowOwnerPID struct {
+	State      uint32
+	LocalAddr  uint32
+	LocalPort  uint32
+	RemoteAddr uint32
+	RemotePort uint32
+	OwningPID  uint32
+}
+
+// addrInUse reports whether a listen error means the port is taken
+func addrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
+
+// listeningProcess finds the process listening on a TCP port in the IPv4
+// TCP table
+func listeningProcess(port int) portOwner {
+	var size uint32
+	procGetExtendedTcpTable.Call(0, uintptr(unsafe.Pointer(&size)), 0, windows.AF_INET, tcpTableOwnerPIDListener, 0)
+	if size == 0 {
+		return portOwner{}
+	}
+	buf := make([]byte, size)
+	if ret, _, _ := procGetExtendedTcpTable.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0,
+		windows.AF_INET, tcpTableOwnerPIDListener, 0); ret != 0 {
+		return portOwner{}
+	}
+
+	// MIB_TCPTABLE_OWNER_PID: the number of rows, then the rows
+	count := *(*uint32)(unsafe.Pointer(&buf[0]))
+	rows := unsafe.Slice((*tcpRowOwnerPID)(unsafe.Pointer(&buf[4])), count)
+	for _, row := range rows {
+		// The port is in network byte order in the low 16 bits
+		if int(row.LocalPort&0xff)<<8|int(row.LocalPort>>8&0xff) == port {
+			return portOwner{PID: int(row.OwningPID), Name: processName(row.OwningPID)}
+		}
+	}
+	return portOwner{}
+}
+
+// processName returns the executable name of a process without .exe
+func processName(pid uint32) string {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return ""
+	}
+	defer windows.CloseHandle(process)
+
+	name := make([]uint16, windows.MAX_PATH)
+	size := uint32(len(name))
+	if err := windows.QueryFullProcessImageName(process, 0, &name[0], &size); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(windows.UTF16ToString(name[:size])), ".exe")
+}
@@ -0,0 +1,80 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// verifyProvenance checks the provenance attestation of a downloaded
+// component against the configured policy. location is where the
+// component was downloaded from, or empty for its release file. It
+// returns nil when provenance is not checked.
+func (i *Installer) verifyProvenance(component, path, location string) (*verifier.Provenance, error) {
+	if !i.config.Provenance.Enabled {
+		return nil, nil
+	}
+
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", component, err)
+	}
+
+	attestation, err := i.downloader.FetchProvenance(i.ctx, component, location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch provenance of %s: %w", component, err)
+	}
+
+	provenance, err := i.verifier.VerifyProvenance(attestation, digest, verifier.ProvenancePolicy{
+		Builders:    i.config.Provenance.Builders,
+		SourceRepos: i.config.Provenance.SourceRepos,
+		BuildTypes:  i.config.Provenance.BuildTypes,
+	})
+	i.emitVerification(component, "provenance", err)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", component, err)
+	}
+
+	i.log.Infof("Provenance of %s verified: built by %s from %s", component, provenance.Builder, provenance.Source)
+	return provenance, nil
+}
+
+// verifyDownloadsProvenance checks the provenance of the components
+// downloaded to the cache and records it in the install report. locations
+// holds where components not from their release file were downloaded.
+func (i *Installer) verifyDownloadsProvenance(names []string, locations map[string]string) error {
+	if i.dryRun || !i.config.Provenance.Enabled {
+		return nil
+	}
+
+	for _, component := range names {
+		location := locations[component]
+		path := filepath.Join(i.config.CachePath, component)
+		provenance, err := i.verifyProvenance(component, path, location)
+		if err != nil {
+			return err
+		}
+		i.report.Provenance[component] = provenance
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA256 of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/companion"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// remoteConfigFile is the file under DataPath keeping the configuration
+// pulled last, used when the companion cannot be reached
+const remoteConfigFile = "remote-config.json"
+
+// PullConfig pulls the configuration the companion keeps for the device
+// and merges it into cfg under the local configuration file. The
+// companion knows the device by its ID, or by the credential or
+// enrollment token it authenticates with. When the companion cannot be
+// reached the configuration pulled last is used, unless a pull is
+// required. The revision used is recorded in cfg.RemoteConfig.Revision.
+func PullConfig(ctx context.Context, cfg *config.Config, log Logger) error {
+	d := downloader.New(cfg.CompanionURL, log)
+	if err := d.SetProxy(proxyOptions(cfg)); err != nil {
+		return fmt.Errorf("failed to configure proxy: %w", err)
+	}
+	if err := d.SetTLS(tlsOptions(cfg)); err != nil {
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	client := companion.New(cfg.CompanionURL, companion.Options{
+		HTTPClient: d.HTTPClient(),
+		Retry:      retryPolicy(cfg.Retry),
+		Token:      remoteConfigToken(cfg),
+		Log:        log,
+	})
+
+	log.Info("Pulling configuration from the companion...")
+	cachePath := filepath.Join(cfg.DataPath, remoteConfigFile)
+	remote, err := client.DeviceConfig(ctx, cfg.DeviceID)
+	switch {
+	case err == nil:
+		if err := cacheRemoteConfig(cachePath, remote); err != nil {
+			log.Errorf("Could not keep the pulled configuration: %v", err)
+		}
+	case cfg.RemoteConfig.Required || ctx.Err() != nil:
+		return err
+	default:
+		cached, cacheErr := readRemoteConfig(cachePath)
+		if cacheErr != nil {
+			log.Errorf("Could not pull the configuration from the companion, using the local one: %v", err)
+			return nil
+		}
+		log.Errorf("Could not pull the configuration from the companion, using revision %s pulled earlier: %v", cached.Revision, err)
+		remote = cached
+	}
+
+	ignored, err := cfg.MergeRemote(remote.Settings)
+	if err != nil {
+		return err
+	}
+	if len(ignored) > 0 {
+		log.Errorf("Ignoring settings only the local configuration can change: %s", strings.Join(ignored, ", "))
+	}
+	cfg.RemoteConfig.Revision = remote.Revision
+	log.Infof("Using configuration revision %s from the companion", versionOrUnknown(remote.Revision))
+	return nil
+}
+
+// remoteConfigToken returns what the device authenticates with when it
+// pulls its configuration: the credential issued when it enrolled, or
+// else the enrollment token
+func remoteConfigToken(cfg *config.Config) string {
+	data, err := readSecret(cfg, filepath.Join(cfg.DataPath, deviceCredentialFile))
+	if err == nil {
+		var credential deviceCredential
+		if json.Unmarshal(data, &credential) == nil && credential.Token != "" && credential.DeviceID == cfg.DeviceID {
+			return credential.Token
+		}
+	}
+	return cfg.Enrollment.Token
+}
+
+// cacheRemoteConfig keeps a pulled configuration for when the companion
+// cannot be reached
+func cacheRemoteConfig(path string, remote *companion.DeviceConfig) error {
+	data, err := json.MarshalIndent(remote, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// readRemoteConfig reads the configuration pulled last
+func readRemoteConfig(path string) (*companion.DeviceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var remote companion.DeviceConfig
+	if err := json.Unmarshal(data, &remote); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &remote, nil
+}
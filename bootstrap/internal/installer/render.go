@@ -0,0 +1,125 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// templateFuncs are the functions templates can call
+var templateFuncs = template.FuncMap{
+	"quote":          shellQuote,
+	"command":        shellCommand,
+	"systemdCommand": systemdCommand,
+	"json":           jsonValue,
+}
+
+// defaultConfigTemplates are the built-in configuration files of the
+// companion and the executor
+var defaultConfigTemplates = map[string]string{
+	"companion": `{
+  "port": {{.CompanionPort}},
+  "device_id": {{json .DeviceID}},
+  "data_dir": {{json .DataDir}},
+  "cache_dir": {{json .CacheDir}},
+  "log_level": {{json .LogLevel}}
+}
+`,
+	"executor": `{
+  "companion_url": {{json .CompanionURL}},
+  "variant": {{json .ExecutorVariant}},
+  "data_dir": {{json .DataDir}},
+  "cache_dir": {{json .CacheDir}},
+  "log_level": {{json .LogLevel}}
+}
+`,
+}
+
+// configTemplateData is what configuration templates are rendered with
+type configTemplateData struct {
+	DeviceID        string
+	CompanionURL    string
+	CompanionPort   int
+	ExecutorVariant string
+	DataDir         string
+	CacheDir        string
+	BackupDir       string
+	LogLevel        string
+}
+
+// renderTemplate renders the template in the file at path, or the
+// built-in text when path is empty. Missing fields are errors rather
+// than empty output.
+func renderTemplate(builtin, path string, data interface{}) ([]byte, error) {
+	name, text := "built-in template", builtin
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		name, text = path, string(contents)
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeConfigTemplate renders the configuration file of a component from
+// the operator's template in config_templates, or the built-in one, and
+// writes it to DataPath
+func (i *Installer) writeConfigTemplate(component string) error {
+	variant, err := executorVariant(i.config, i.systemInfo)
+	if err != nil {
+		return err
+	}
+
+	data, err := renderTemplate(defaultConfigTemplates[component], i.config.ConfigTemplates[component], configTemplateData{
+		DeviceID:        i.config.DeviceID,
+		CompanionURL:    i.companionURL(),
+		CompanionPort:   i.companionPort,
+		ExecutorVariant: variant,
+		DataDir:         i.config.DataPath,
+		CacheDir:        i.config.CachePath,
+		BackupDir:       i.config.BackupPath,
+		LogLevel:        i.config.LogLevel,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render %s config: %w", component, err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("the %s config template does not render valid JSON", component)
+	}
+	return i.writeConfigFile(filepath.Join(i.config.DataPath, component+"-config.json"), data)
+}
+
+// writeJSONConfig writes a configuration file as indented JSON
+func (i *Installer) writeJSONConfig(path string, config map[string]interface{}) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+	return i.writeConfigFile(path, append(data, '\n'))
+}
+
+// writeConfigFile writes a configuration file readable only by its
+// owner, since configurations can carry credentials
+func (i *Installer) writeConfigFile(path string, data []byte) error {
+	return i.writeFile(path, data, 0600)
+}
+
+// jsonValue encodes a value as JSON for templates
+func jsonValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,371 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// repairProbeTimeout is how long a service has to answer the health
+// check that decides whether a repair restarts it
+const repairProbeTimeout = 5 * time.Second
+
+// RepairReport describes what a repair found wrong with an installation
+type RepairReport struct {
+	// Fixed lists the problems that were repaired
+	Fixed []RepairItem `json:"fixed"`
+	// Remaining lists the problems that could not be repaired
+	Remaining []RepairItem `json:"remaining"`
+	// Modified lists files Ezra wrote that were changed outside it. They
+	// are kept as they are.
+	Modified []string `json:"modified"`
+}
+
+// RepairItem is a problem found in an installation
+type RepairItem struct {
+	Target  string `json:"target"`
+	Problem string `json:"problem"`
+	Action  string `json:"action,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r *RepairReport) fixed(target, problem, action string) {
+	r.Fixed = append(r.Fixed, RepairItem{Target: target, Problem: problem, Action: action})
+}
+
+func (r *RepairReport) failed(target, problem string, err error) {
+	r.Remaining = append(r.Remaining, RepairItem{Target: target, Problem: problem, Error: err.Error()})
+}
+
+// Repair checks an existing installation and fixes what it can: binaries
+// that no longer match their recorded checksums are downloaded again,
+// missing configuration and service files are written again, wrong
+// permissions and ownership are restored, and services that are not
+// healthy are restarted. Files changed outside Ezra are left alone.
+func (i *Installer) Repair() (*RepairReport, error) {
+	i.log.Info("Starting repair...")
+
+	installed := i.loadInstalledVersions()
+	if !i.isInstalled() && len(installed) == 0 {
+		return nil, fmt.Errorf("no existing installation found in %s", i.config.InstallPath)
+	}
+
+	// Repair the components that were installed rather than the
+	// configured selection
+	var present []string
+	for _, component := range components {
+		_, err := os.Stat(filepath.Join(i.config.InstallPath, binaryName(component)))
+		if err == nil || installed[component] != "" {
+			present = append(present, component)
+		}
+	}
+	i.components = present
+	i.expectedVersions = installed
+
+	report := &RepairReport{}
+
+	replaced, err := i.repairBinaries(installed, report)
+	if err != nil {
+		return report, err
+	}
+	if err := i.repairFiles(report); err != nil {
+		return report, err
+	}
+	if err := i.repairPermissions(report); err != nil {
+		return report, err
+	}
+	i.repairServices(replaced, report)
+
+	return report, nil
+}
+
+// repairBinaries downloads the component binaries that are missing or no
+// longer match the checksum recorded when they were installed, and
+// returns the components it replaced
+func (i *Installer) repairBinaries(installed map[string]string, report *RepairReport) ([]string, error) {
+	i.log.Info("Checking binaries...")
+
+	deployed := i.deployedFiles()
+	problems := map[string]string{}
+	var broken []string
+	for _, component := range i.components {
+		target := filepath.Join(i.config.InstallPath, binaryName(component))
+		sum, err := fileSHA256(target)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems[component] = "missing"
+		case err != nil:
+			problems[component] = fmt.Sprintf("unreadable: %v", err)
+		case deployed[target] == "":
+			i.log.Infof("No checksum recorded for %s, not checking it", target)
+			continue
+		case deployed[target] != sum:
+			problems[component] = "checksum does not match the installed one"
+		default:
+			continue
+		}
+		i.log.Errorf("%s: %s", target, problems[component])
+		broken = append(broken, component)
+	}
+	if len(broken) == 0 {
+		return nil, nil
+	}
+
+	manifest, err := i.fetchRelease()
+	if err != nil {
+		for _, component := range broken {
+			report.failed(filepath.Join(i.config.InstallPath, binaryName(component)), problems[component], err)
+		}
+		return nil, nil
+	}
+
+	staged := map[string]string{}
+	defer func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}()
+	var replace []string
+	for _, component := range broken {
+		target := filepath.Join(i.config.InstallPath, binaryName(component))
+		release, ok := manifest.Components[component]
+		if !ok {
+			report.failed(target, problems[component], fmt.Errorf("%s is not in the release manifest", component))
+			continue
+		}
+		if release.Version != installed[component] {
+			i.log.Infof("%s %s is no longer released, installing %s", component, versionOrUnknown(installed[component]), release.Version)
+		}
+
+		// The broken binary is no base for a patch
+		path, err := i.stageComponent(component, "", release)
+		if err == nil {
+			_, err = i.verifyProvenance(component, path, "")
+		}
+		if path != "" {
+			staged[component] = path
+		}
+		if err != nil {
+			report.failed(target, problems[component], err)
+			continue
+		}
+		replace = append(replace, component)
+	}
+	if len(replace) == 0 {
+		return nil, nil
+	}
+
+	err = i.transaction(func() error {
+		for _, component := range replace {
+			// The broken binary is not kept in a slot
+			if err := i.replaceBinary(component, "", manifest.Components[component].Version, staged[component]); err != nil {
+				return err
+			}
+			delete(staged, component)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, component := range replace {
+		installed[component] = manifest.Components[component].Version
+		report.fixed(filepath.Join(i.config.InstallPath, binaryName(component)), problems[component], "downloaded "+installed[component])
+	}
+	if err := i.mergeSysext(); err != nil {
+		return replace, fmt.Errorf("failed to refresh system extension: %w", err)
+	}
+	if err := i.saveInstalledVersions(installed); err != nil {
+		i.log.Errorf("Could not record installed versions: %v", err)
+	}
+	return replace, nil
+}
+
+// repairFiles writes the configuration and service files of the
+// installation again. Missing files are recreated, while files changed
+// outside Ezra are kept and reported.
+func (i *Installer) repairFiles(report *RepairReport) error {
+	i.log.Info("Checking configuration and service files...")
+
+	binaries := map[string]bool{}
+	for _, component := range components {
+		binaries[filepath.Join(i.config.InstallPath, binaryName(component))] = true
+	}
+	existed := map[string]bool{}
+	for path, sum := range i.deployedFiles() {
+		if binaries[path] {
+			continue
+		}
+		current, err := fileSHA256(path)
+		existed[path] = err == nil
+		if err == nil && current != sum {
+			report.Modified = append(report.Modified, path)
+		}
+	}
+
+	sort.Strings(report.Modified)
+
+	policy := i.config.OnConflict
+	i.config.OnConflict = conflictKeep
+	defer func() { i.config.OnConflict = policy }()
+
+	err := i.transaction(func() error {
+		if err := i.createDirectories(); err != nil {
+			return fmt.Errorf("failed to create directories: %w", err)
+		}
+		if i.selected("companion") {
+			if err := i.chooseCompanionPort(); err != nil {
+				return err
+			}
+		}
+		if err := i.createConfigFiles(); err != nil {
+			return fmt.Errorf("failed to create config files: %w", err)
+		}
+		if err := i.setupSystemService(); err != nil {
+			return fmt.Errorf("failed to setup system service: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for path := range i.deployedFiles() {
+		if !binaries[path] && !existed[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if fileExists(path) {
+			report.fixed(path, "missing", "regenerated")
+		} else {
+			report.failed(path, "missing", errors.New("not written by the current configuration"))
+		}
+	}
+	return nil
+}
+
+// repairPermissions restores the permissions of the binaries and
+// configuration files, and the service account's ownership of the data
+func (i *Installer) repairPermissions(report *RepairReport) error {
+	i.log.Info("Checking permissions...")
+
+	wanted := map[string]os.FileMode{}
+	for _, component := range i.components {
+		wanted[filepath.Join(i.config.InstallPath, binaryName(component))] = 0755
+		wanted[filepath.Join(i.config.DataPath, component+"-config.json")] = 0600
+	}
+	for path, perm := range wanted {
+		info, err := os.Stat(path)
+		if err != nil || !modeDiffers(info, perm) {
+			continue
+		}
+		problem := fmt.Sprintf("mode %04o instead of %04o", info.Mode().Perm(), perm)
+		if err := i.chmod(path, perm); err != nil {
+			report.failed(path, problem, err)
+			continue
+		}
+		report.fixed(path, problem, "permissions restored")
+	}
+
+	if ok, err := i.hasServiceUser(); !ok || err != nil {
+		return err
+	}
+	name := i.serviceUser()
+	for _, path := range []string{i.config.DataPath, i.config.CachePath} {
+		info, err := os.Stat(path)
+		if err != nil || ownedBy(info, name) {
+			continue
+		}
+		problem := "not owned by " + name
+		if err := i.setupServiceUser(); err != nil {
+			report.failed(path, problem, err)
+			return nil
+		}
+		report.fixed(path, problem, "ownership restored")
+	}
+	return nil
+}
+
+// repairServices restarts the services whose binaries were replaced or
+// that do not report healthy, and waits for them to become ready
+func (i *Installer) repairServices(replaced []string, report *RepairReport) {
+	i.log.Info("Checking services...")
+
+	probes := map[string]func(ctx context.Context) (serviceHealth, error){
+		"companion": i.companionProbe(),
+		"agent":     i.agentProbe(),
+	}
+	waits := map[string]func() error{
+		"companion": i.waitForCompanion,
+		"agent":     i.waitForAgent,
+	}
+
+	for _, component := range []string{"companion", "agent"} {
+		if !i.selected(component) {
+			continue
+		}
+		name := "ezra-" + component
+
+		var problem string
+		switch {
+		case contains(replaced, component):
+			problem = "binary replaced"
+		case i.config.HealthCheck.Disabled:
+			continue
+		default:
+			err := i.probeService(component, probes[component])
+			if err == nil {
+				continue
+			}
+			i.log.Errorf("The %s is not healthy: %v", component, err)
+			problem = "not healthy: " + err.Error()
+		}
+
+		err := i.restartServices([]string{component})
+		if err == nil {
+			err = waits[component]()
+		}
+		if err != nil {
+			report.failed(name, problem, err)
+			continue
+		}
+		report.fixed(name, problem, "restarted")
+	}
+}
+
+// probeService asks a service once whether it is ready and runs the
+// installed version
+func (i *Installer) probeService(component string, probe func(ctx context.Context) (serviceHealth, error)) error {
+	ctx, cancel := context.WithTimeout(i.ctx, repairProbeTimeout)
+	defer cancel()
+
+	health, err := probe(ctx)
+	if err != nil {
+		return err
+	}
+	if !health.ready() {
+		return fmt.Errorf("status %q", health.Status)
+	}
+	expected := i.expectedVersions[component]
+	if expected != "" && health.Version != "" && normalizeVersion(health.Version) != normalizeVersion(expected) {
+		return fmt.Errorf("runs version %s instead of %s", health.Version, expected)
+	}
+	return nil
+}
+
+// chmod changes the permissions of a file, as root if it needs to be
+func (i *Installer) chmod(path string, perm os.FileMode) error {
+	if i.needsElevation(path) {
+		_, err := i.runElevated("chmod", strconv.FormatUint(uint64(perm.Perm()), 8), path)
+		return err
+	}
+	return os.Chmod(path, perm)
+}
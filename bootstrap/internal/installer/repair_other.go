@@ -0,0 +1,25 @@
+//go:build !windows
+
+package installer
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// modeDiffers reports whether a file's permissions are not perm
+func modeDiffers(info os.FileInfo, perm os.FileMode) bool {
+	return info.Mode().Perm() != perm
+}
+
+// ownedBy reports whether a file belongs to the named account
+func ownedBy(info os.FileInfo, name string) bool {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || strconv.FormatUint(uint64(stat.Uid), 10) == u.Uid
+}
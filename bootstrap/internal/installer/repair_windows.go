@@ -0,0 +1,17 @@
+package installer
+
+import (
+	"os"
+)
+
+// modeDiffers is always false: Windows files have no permission bits to
+// restore
+func modeDiffers(info os.FileInfo, perm os.FileMode) bool {
+	return false
+}
+
+// ownedBy is always true: the service account is given its paths through
+// their ACLs, which are not inspected
+func ownedBy(info os.FileInfo, name string) bool {
+	return true
+}
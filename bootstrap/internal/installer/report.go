@@ -0,0 +1,205 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// installReportName is the file under DataPath the install report is
+// written to unless configured
+const installReportName = "install-report.json"
+
+// installReportTimeout bounds sending the report to the companion
+const installReportTimeout = 30 * time.Second
+
+// Results of a phase in the install report
+const (
+	phaseCompleted = "completed"
+	phaseSkipped   = "skipped"
+	phaseFailed    = "failed"
+)
+
+// InstallReport describes an installation, successful or not, for fleet
+// provisioning dashboards
+type InstallReport struct {
+	DeviceID string `json:"device_id"`
+	// Method is "online" or "offline", and InstallMode where the
+	// binaries went, see config.InstallMode
+	Method      string    `json:"method"`
+	InstallMode string    `json:"install_mode"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationMS  int64     `json:"duration_ms"`
+	// ConfigRevision is the revision of the configuration pulled from
+	// the companion, when one was
+	ConfigRevision string `json:"config_revision,omitempty"`
+	// BootstrapVersion is the version of the bootstrap that installed
+	BootstrapVersion string `json:"bootstrap_version,omitempty"`
+	// SessionID identifies the run in the log shipped to the companion
+	SessionID string `json:"session_id,omitempty"`
+
+	Components []ComponentReport `json:"components"`
+	Phases     []PhaseReport     `json:"phases"`
+	Services   []ServiceStatus   `json:"services"`
+	// Warnings are the errors the installation logged and carried on from
+	Warnings []string `json:"warnings,omitempty"`
+	// RolledBack lists what the rollback of a failed installation undid
+	RolledBack []string `json:"rolled_back,omitempty"`
+
+	// Provenance is the verified build provenance of each component
+	Provenance map[string]*verifier.Provenance `json:"provenance,omitempty"`
+	// Elevated lists the commands that ran as root
+	Elevated []string `json:"elevated,omitempty"`
+}
+
+// ComponentReport describes an installed component
+type ComponentReport struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Path    string `json:"path,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// PhaseReport describes a phase or custom step of an installation
+type PhaseReport struct {
+	Name       string `json:"name"`
+	Result     string `json:"result"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func newInstallReport() *InstallReport {
+	return &InstallReport{Provenance: map[string]*verifier.Provenance{}}
+}
+
+// Report returns the report of the last installation
+func (i *Installer) Report() *InstallReport {
+	return i.report
+}
+
+// reportPhase adds the result of a phase to the install report
+func (i *Installer) reportPhase(phase, result string, started time.Time) {
+	var duration int64
+	if !started.IsZero() {
+		duration = time.Since(started).Milliseconds()
+	}
+	i.report.Phases = append(i.report.Phases, PhaseReport{Name: phase, Result: result, DurationMS: duration})
+}
+
+// finishReport completes the install report once an installation has
+// ended, writes it and sends it to the companion if configured. Neither
+// failing fails the installation. The event stream ends with the outcome.
+func (i *Installer) finishReport(method string, started time.Time, installErr error) {
+	i.emitFinished(started, installErr)
+	if i.dryRun {
+		return
+	}
+
+	report := i.report
+	report.DeviceID = i.config.DeviceID
+	report.Method = method
+	report.InstallMode = i.installMode
+	report.ConfigRevision = i.config.RemoteConfig.Revision
+	report.BootstrapVersion = i.bootstrapVersion
+	report.SessionID = i.sessionID
+	report.Success = installErr == nil
+	if installErr != nil {
+		report.Error = redact.String(installErr.Error())
+	}
+	// Errors and commands may quote tokens or passwords
+	redact.Strings(report.Warnings)
+	redact.Strings(report.RolledBack)
+	redact.Strings(report.Elevated)
+	report.StartedAt = started
+	report.FinishedAt = time.Now()
+	report.DurationMS = report.FinishedAt.Sub(started).Milliseconds()
+	report.Components = i.componentReports()
+	report.Services = nil
+	for _, spec := range i.services() {
+		report.Services = append(report.Services, ServiceStatus{Name: spec.Name, State: i.serviceState(spec.Name)})
+	}
+
+	path := i.config.Report.Path
+	if path == "" {
+		path = filepath.Join(i.config.DataPath, installReportName)
+	}
+	if err := writeReport(path, report); err != nil {
+		i.log.Errorf("Failed to write install report: %v", err)
+	} else {
+		i.log.Infof("Install report written to %s", path)
+	}
+
+	// The report of an interrupted install is still sent
+	if i.config.Report.Post {
+		ctx, cancel := context.WithTimeout(context.Background(), installReportTimeout)
+		defer cancel()
+		if err := i.companion.UploadInstallReport(ctx, report); err != nil {
+			i.log.Errorf("Failed to send install report to the companion: %v", err)
+		}
+	}
+}
+
+// componentReports describes the selected components with the versions
+// that were installed and the checksums of their binaries
+func (i *Installer) componentReports() []ComponentReport {
+	var reports []ComponentReport
+	for _, component := range i.components {
+		path := filepath.Join(i.config.InstallPath, binaryName(component))
+		report := ComponentReport{Name: component, Version: i.expectedVersions[component]}
+		if sum, err := fileSHA256(path); err == nil {
+			report.Path = path
+			report.SHA256 = sum
+		}
+		reports = append(reports, report)
+	}
+	// Install manifests can name further components
+	var others []string
+	for name := range i.expectedVersions {
+		if !contains(components, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		reports = append(reports, ComponentReport{Name: name, Version: i.expectedVersions[name]})
+	}
+	return reports
+}
+
+// writeReport writes a report as indented JSON
+func writeReport(path string, report interface{}) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// warningLog keeps the errors an installation logs in its report, as
+// warnings when the installation carries on
+type warningLog struct {
+	Logger
+	report *InstallReport
+}
+
+func (l warningLog) Error(args ...interface{}) {
+	l.report.Warnings = append(l.report.Warnings, fmt.Sprint(args...))
+	l.Logger.Error(args...)
+}
+
+func (l warningLog) Errorf(format string, args ...interface{}) {
+	l.report.Warnings = append(l.report.Warnings, fmt.Sprintf(format, args...))
+	l.Logger.Errorf(format, args...)
+}
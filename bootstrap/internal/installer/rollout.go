@@ -0,0 +1,45 @@
+package installer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// agentConfigFile is the agent's configuration under DataPath
+const agentConfigFile = "agent-config.json"
+
+// SetForceLatest takes every release as soon as it is published,
+// ignoring staged rollouts
+func (i *Installer) SetForceLatest(force bool) {
+	i.downloader.SetRolloutTarget(rolloutTarget(i.config, force))
+}
+
+// rolloutTarget describes the device for staged rollouts
+func rolloutTarget(cfg *config.Config, force bool) downloader.RolloutTarget {
+	return downloader.RolloutTarget{
+		DeviceID:    rolloutDeviceID(cfg),
+		Tags:        cfg.DeviceTags,
+		ForceLatest: force,
+	}
+}
+
+// rolloutDeviceID returns the device ID that rollouts bucket the device
+// by. Devices installed before device IDs were kept got a new one on
+// every run, so the one the agent was installed with is preferred.
+func rolloutDeviceID(cfg *config.Config) string {
+	data, err := os.ReadFile(filepath.Join(cfg.DataPath, agentConfigFile))
+	if err != nil {
+		return cfg.DeviceID
+	}
+	var agent struct {
+		DeviceID string `json:"device_id"`
+	}
+	if json.Unmarshal(data, &agent) != nil || agent.DeviceID == "" {
+		return cfg.DeviceID
+	}
+	return agent.DeviceID
+}
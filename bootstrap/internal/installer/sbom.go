@@ -0,0 +1,143 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// advisoryFile is the advisory database looked for on the offline media
+// when none is configured
+const advisoryFile = "advisories.json"
+
+// severities orders advisory severities from least to most severe
+var severities = []string{"low", "medium", "high", "critical"}
+
+// SBOMPolicy decides whether a component may be installed given its SBOM.
+// Returning an error refuses the installation.
+type SBOMPolicy func(component string, sbom *downloader.SBOM) error
+
+// advisoryDB is an offline vulnerability database
+type advisoryDB struct {
+	Advisories []advisory `json:"advisories"`
+}
+
+// advisory is a known vulnerability of a package
+type advisory struct {
+	ID string `json:"id"`
+	// Package is a package name or a package URL without version
+	Package  string `json:"package"`
+	Severity string `json:"severity"`
+	// Affected is the version constraint of the vulnerable releases, in
+	// the syntax of component pins
+	Affected string `json:"affected"`
+}
+
+// SetSBOMPolicy replaces the policy applied to component SBOMs when the
+// SBOM check is enabled. By default components are refused when their
+// SBOM lists a package with a known vulnerability at or above the
+// configured severity.
+func (i *Installer) SetSBOMPolicy(policy SBOMPolicy) {
+	i.sbomPolicy = policy
+}
+
+// checkSBOMs fetches the SBOM of each downloaded component and applies
+// the SBOM policy. locations holds where components not from their
+// release file were downloaded.
+func (i *Installer) checkSBOMs(names []string, locations map[string]string) error {
+	if i.dryRun || !i.config.SBOM.Enabled {
+		return nil
+	}
+
+	policy := i.sbomPolicy
+	if policy == nil {
+		var err error
+		if policy, err = i.advisoryPolicy(); err != nil {
+			return err
+		}
+	}
+
+	for _, component := range names {
+		sbom, err := i.downloader.FetchSBOM(i.ctx, component, locations[component])
+		if err != nil {
+			return fmt.Errorf("failed to fetch SBOM of %s: %w", component, err)
+		}
+		if err := policy(component, sbom); err != nil {
+			return fmt.Errorf("%s: %w", component, err)
+		}
+		i.log.Infof("SBOM of %s checked (%d packages)", component, len(sbom.Packages))
+	}
+	return nil
+}
+
+// advisoryPolicy returns the default SBOM policy, backed by the
+// configured advisory database or the one on the offline media
+func (i *Installer) advisoryPolicy() (SBOMPolicy, error) {
+	path := i.config.SBOM.AdvisoryDB
+	if path == "" {
+		mediaPath, err := i.findOfflineMedia()
+		if err != nil {
+			return nil, fmt.Errorf("no advisory database configured and %w", err)
+		}
+		path = filepath.Join(mediaPath, advisoryFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory database: %w", err)
+	}
+	var db advisoryDB
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("failed to parse advisory database: %w", err)
+	}
+
+	threshold := severityRank(i.config.SBOM.FailSeverity)
+	if threshold < 0 {
+		return nil, fmt.Errorf("invalid sbom fail_severity %q", i.config.SBOM.FailSeverity)
+	}
+	i.log.Infof("Checking SBOMs against %d advisories from %s", len(db.Advisories), path)
+
+	return func(component string, sbom *downloader.SBOM) error {
+		for _, pkg := range sbom.Packages {
+			for _, adv := range db.Advisories {
+				if severityRank(adv.Severity) < threshold || !adv.affects(pkg) {
+					continue
+				}
+				return fmt.Errorf("%s %s is affected by %s (%s)", pkg.Name, pkg.Version, adv.ID, strings.ToLower(adv.Severity))
+			}
+		}
+		return nil
+	}, nil
+}
+
+// affects reports whether a package is a vulnerable release
+func (a advisory) affects(pkg downloader.SBOMPackage) bool {
+	purl, _, _ := strings.Cut(pkg.PURL, "@")
+	if !strings.EqualFold(a.Package, pkg.Name) && (purl == "" || a.Package != purl) {
+		return false
+	}
+	if a.Affected == "" {
+		return true
+	}
+
+	matches, err := downloader.VersionMatches(pkg.Version, a.Affected)
+	if err != nil {
+		// Versions we cannot compare are treated as affected
+		return true
+	}
+	return matches
+}
+
+// severityRank returns the position of a severity in severities, or -1
+func severityRank(severity string) int {
+	for rank, name := range severities {
+		if strings.EqualFold(severity, name) {
+			return rank
+		}
+	}
+	return -1
+}
@@ -0,0 +1,109 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/secrets"
+)
+
+// storedSecretFiles are the files under DataPath the installer keeps in
+// the secret store when one is configured
+var storedSecretFiles = []string{deviceKeyFile, deviceCredentialFile}
+
+// usesSecretStore reports whether secrets are kept in the secret store
+// instead of in files under DataPath
+func usesSecretStore(cfg *config.Config) bool {
+	return cfg.SecretStore.Backend != "" && cfg.SecretStore.Backend != secrets.BackendFile
+}
+
+// readSecret reads a secret the installer keeps, named by its file under
+// DataPath: from the secret store if one is configured, or else from the
+// file. A secret not yet moved to the store is read from its file.
+func readSecret(cfg *config.Config, path string) ([]byte, error) {
+	if !usesSecretStore(cfg) {
+		return os.ReadFile(path)
+	}
+	store, err := cfg.OpenSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Get(filepath.Base(path))
+	if errors.Is(err, secrets.ErrNotFound) {
+		return os.ReadFile(path)
+	}
+	return data, err
+}
+
+// storeSecret keeps a secret in the secret store, removes the file it
+// was kept in before, and hands the files of the store to the service
+// account
+func (i *Installer) storeSecret(path string, data []byte) error {
+	store, err := i.config.OpenSecretStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Set(filepath.Base(path), data); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		i.log.Errorf("Failed to remove %s, now kept in the secret store: %v", path, err)
+	}
+
+	if ok, err := i.hasServiceUser(); !ok || err != nil {
+		return err
+	}
+	if !fileExists(i.config.SecretStoreDir()) {
+		return nil
+	}
+	return i.grantPath(i.serviceUser(), i.config.SecretStoreDir())
+}
+
+// moveSecretsToStore moves the device key and credential from their
+// files into the secret store, once one is configured. Like the key
+// itself this is kept outside the journal.
+func (i *Installer) moveSecretsToStore() error {
+	if !usesSecretStore(i.config) {
+		return nil
+	}
+	for _, name := range storedSecretFiles {
+		path := filepath.Join(i.config.DataPath, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if i.dryRun {
+			i.plan.addCommand(fmt.Sprintf("move %s to the %s secret store", path, i.config.SecretStore.Backend))
+			continue
+		}
+		i.log.Infof("Moving %s to the %s secret store...", path, i.config.SecretStore.Backend)
+		if err := i.storeSecret(path, data); err != nil {
+			return fmt.Errorf("failed to move %s to the secret store: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// purgeSecrets deletes the secrets the installer kept in the secret
+// store, which may live outside DataPath
+func (i *Installer) purgeSecrets() error {
+	if !usesSecretStore(i.config) {
+		return nil
+	}
+	store, err := i.config.OpenSecretStore()
+	if err != nil {
+		return err
+	}
+	for _, name := range storedSecretFiles {
+		if err := store.Delete(name); err != nil {
+			return fmt.Errorf("failed to delete %s from the secret store: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// bootstrapComponent is the name the bootstrap is published under in
+// release manifests and the release index
+const bootstrapComponent = "bootstrap"
+
+// ErrSelfUpdated means the running bootstrap was replaced by a newer
+// one, which must be started to carry on
+var ErrSelfUpdated = errors.New("the bootstrap was updated and must be started again")
+
+// SelfUpdateReport describes what a self-update changed
+type SelfUpdateReport struct {
+	// From is the version that was running and To the version released
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Path is the bootstrap binary
+	Path    string `json:"path"`
+	Updated bool   `json:"updated"`
+}
+
+// SetBootstrapVersion sets the version of the running bootstrap, which
+// self-updates compare the released one with
+func (i *Installer) SetBootstrapVersion(version string) {
+	i.bootstrapVersion = version
+}
+
+// SelfUpdate replaces the running bootstrap with the one in the latest
+// release for this platform, when that is newer. The download must be
+// signed, or verified against TUF metadata. The new binary is renamed
+// over the running one; Windows cannot replace a running executable, so
+// it is renamed aside first and removed by the next self-update.
+func (i *Installer) SelfUpdate() (*SelfUpdateReport, error) {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the bootstrap: %w", err)
+	}
+	report := &SelfUpdateReport{From: i.bootstrapVersion, Path: exe}
+	if i.dryRun {
+		i.plan.addDownload(i.downloader.ComponentURL(bootstrapComponent))
+		i.plan.addFile(exe)
+		return report, nil
+	}
+	removeOldExecutable(exe)
+
+	i.log.Info("Checking for a new bootstrap...")
+	manifest, err := i.fetchRelease()
+	if err != nil {
+		return nil, err
+	}
+	release, ok := manifest.Components[bootstrapComponent]
+	if !ok {
+		return nil, errors.New("the release does not publish the bootstrap")
+	}
+	report.To = release.Version
+	if !i.downloader.Offered(bootstrapComponent, release) {
+		i.log.Infof("Bootstrap %s is not rolled out to this device yet", release.Version)
+		report.To = i.bootstrapVersion
+		return report, nil
+	}
+	if !newerVersion(release.Version, i.bootstrapVersion) {
+		i.log.Infof("The bootstrap is up to date (%s)", versionOrUnknown(i.bootstrapVersion))
+		return report, nil
+	}
+	if !i.config.TUF.Enabled && (!i.signaturesEnabled() || release.Signature == "") {
+		return nil, failure.Wrap(failure.Verification, fmt.Errorf("bootstrap %s cannot be verified: self-updates need a signed release or TUF", release.Version))
+	}
+
+	staged, err := i.stageBootstrap(exe, release)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.replaceExecutable(staged, exe); err != nil {
+		os.Remove(staged)
+		return nil, fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+
+	i.log.Infof("Updated the bootstrap from %s to %s", versionOrUnknown(i.bootstrapVersion), release.Version)
+	report.Updated = true
+	return report, nil
+}
+
+// stageBootstrap downloads and verifies the released bootstrap next to
+// the running one, so that it can be renamed into place
+func (i *Installer) stageBootstrap(exe string, release downloader.ComponentManifest) (string, error) {
+	dir := filepath.Dir(exe)
+	if i.needsElevation(exe) {
+		dir = i.config.CachePath
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	path := filepath.Join(dir, "."+filepath.Base(exe)+".new")
+
+	if err := i.downloader.DownloadComponentVerified(i.ctx, bootstrapComponent, path, i.streamVerifier(release)); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	if err := os.Chmod(path, 0755); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// replaceExecutable moves a new binary over the running one. Windows
+// lets a running executable be renamed but not replaced, so it is moved
+// aside first and moved back if the new binary cannot take its place.
+func (i *Installer) replaceExecutable(staged, exe string) error {
+	if runtime.GOOS != "windows" {
+		return i.rename(staged, exe)
+	}
+
+	old := exe + ".old"
+	// A bootstrap updated earlier may still be running from it
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		if restoreErr := os.Rename(old, exe); restoreErr != nil {
+			return fmt.Errorf("%w (and restoring %s failed: %v)", err, exe, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeOldExecutable removes the bootstrap an earlier self-update moved
+// aside on Windows, once it is no longer running
+func removeOldExecutable(exe string) {
+	if runtime.GOOS == "windows" {
+		os.Remove(exe + ".old")
+	}
+}
+
+// newerVersion reports whether a released version is newer than the
+// running one. A running version that is not a release, such as a
+// development build, is replaced by any other.
+func newerVersion(released, running string) bool {
+	newer, err := downloader.VersionMatches(released, ">"+running)
+	if err != nil {
+		return released != "" && released != running
+	}
+	return newer
+}
@@ -0,0 +1,152 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// serviceRestartSec is how long a supervisor waits before restarting a
+// service that exited
+const serviceRestartSec = 5
+
+// serviceTemplateData is what service definition templates are rendered
+// with
+type serviceTemplateData struct {
+	serviceSpec
+	WorkingDirectory string
+	RestartSec       int
+	// WantedBy is the systemd target that starts the service
+	WantedBy string
+	// Type is the systemd service type: "notify" when the services tell
+	// systemd they are ready, else "simple"
+	Type string
+}
+
+// defaultServiceTemplates are the built-in service definitions by init
+// system
+var defaultServiceTemplates = map[string]string{
+	supervisorSystemd: `[Unit]
+Description={{.Description}}
+After=network.target
+
+[Service]
+Type={{.Type}}
+{{if eq .Type "notify"}}NotifyAccess=main
+{{end}}{{if .User}}User={{.User}}
+{{end}}WorkingDirectory={{.WorkingDirectory}}
+ExecStart={{systemdCommand .Command}}
+Restart={{.Restart}}
+RestartSec={{.RestartSec}}
+
+[Install]
+WantedBy={{.WantedBy}}
+`,
+	supervisorOpenRC: `#!/sbin/openrc-run
+
+description={{quote .Description}}
+supervisor=supervise-daemon
+command={{quote (index .Command 0)}}
+command_args={{command (slice .Command 1)}}
+command_user={{quote .User}}
+directory={{quote .WorkingDirectory}}
+respawn_delay={{.RestartSec}}
+respawn_max=0
+
+depend() {
+	need net
+}
+`,
+	supervisorRunit: `#!/bin/sh
+cd {{quote .WorkingDirectory}} || exit 1
+exec chpst -u {{quote .User}} {{command .Command}} 2>&1
+`,
+	supervisorSysV: `#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $network $remote_fs
+# Required-Stop:     $network $remote_fs
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.Description}}
+### END INIT INFO
+
+PIDFILE=/var/run/{{.Name}}.pid
+
+case "$1" in
+supervise)
+	# Restart the service whenever it exits, until stopped
+	trap 'kill "$child" 2>/dev/null; exit 0' TERM INT
+	while :; do
+		{{command .Command}} &
+		child=$!
+		wait "$child"
+		sleep {{.RestartSec}}
+	done
+	;;
+start)
+	start-stop-daemon --start --background --make-pidfile --pidfile "$PIDFILE" \
+		--chuid {{quote .User}} --chdir {{quote .WorkingDirectory}} --exec /bin/sh -- "$0" supervise
+	;;
+stop)
+	start-stop-daemon --stop --retry 10 --pidfile "$PIDFILE" && rm -f "$PIDFILE"
+	;;
+restart)
+	"$0" stop
+	"$0" start
+	;;
+status)
+	start-stop-daemon --status --pidfile "$PIDFILE"
+	;;
+*)
+	echo "Usage: $0 {start|stop|restart|status}"
+	exit 1
+	;;
+esac
+`,
+}
+
+// renderService renders the service definition of an init system from
+// the operator's template in service_templates, or the built-in one
+func (i *Installer) renderService(supervisor string, spec serviceSpec) ([]byte, error) {
+	builtin, ok := defaultServiceTemplates[supervisor]
+	path := i.config.ServiceTemplates[supervisor]
+	if !ok && path == "" {
+		return nil, fmt.Errorf("no %s service template", supervisor)
+	}
+
+	// User units run as their user and start with the user's session
+	wantedBy := "multi-user.target"
+	if i.userMode() {
+		spec.User, wantedBy = "", "default.target"
+	}
+	if spec.Restart == "" {
+		spec.Restart = "always"
+	}
+	serviceType := "simple"
+	if i.config.SystemdNotify {
+		serviceType = "notify"
+	}
+
+	data, err := renderTemplate(builtin, path, serviceTemplateData{
+		serviceSpec:      spec,
+		WorkingDirectory: i.config.DataPath,
+		RestartSec:       serviceRestartSec,
+		WantedBy:         wantedBy,
+		Type:             serviceType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s service: %w", spec.Name, err)
+	}
+	return data, nil
+}
+
+// systemdCommand quotes a command line for ExecStart, which splits words
+// like a shell but expands specifiers and variables of its own
+func systemdCommand(args []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$")
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = `"` + escape.Replace(arg) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,81 @@
+package installer
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// defaultServiceUser is the service account when service_user is empty
+const defaultServiceUser = "ezra"
+
+// serviceUser returns the account the services run as
+func (i *Installer) serviceUser() string {
+	if i.config.ServiceUser != "" {
+		return i.config.ServiceUser
+	}
+	return defaultServiceUser
+}
+
+// hasServiceUser reports whether the services run as the service
+// account rather than the installing user, who runs them in user mode,
+// in containers and without an init system
+func (i *Installer) hasServiceUser() (bool, error) {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return false, err
+	}
+	switch supervisor {
+	case supervisorContainer, supervisorDirect, supervisorTask:
+		return false, nil
+	}
+	return !i.userMode(), nil
+}
+
+// setupServiceUser creates the service account unless it exists and
+// gives it DataPath and CachePath. Nothing is done when the services run
+// as the installing user.
+func (i *Installer) setupServiceUser() error {
+	if ok, err := i.hasServiceUser(); !ok || err != nil {
+		return err
+	}
+
+	name := i.serviceUser()
+	if _, err := user.Lookup(name); err != nil {
+		i.log.Infof("Creating service user %s...", name)
+	}
+	if err := i.createServiceUser(name); err != nil {
+		return err
+	}
+
+	if err := i.grantPath(name, i.config.DataPath); err != nil {
+		return err
+	}
+	// A cache inside DataPath is already granted
+	if rel, err := filepath.Rel(i.config.DataPath, i.config.CachePath); err != nil || strings.HasPrefix(rel, "..") {
+		return i.grantPath(name, i.config.CachePath)
+	}
+	return nil
+}
+
+// nologinShell returns the shell that refuses logins
+func nologinShell() string {
+	for _, shell := range []string{"/usr/sbin/nologin", "/sbin/nologin"} {
+		if _, err := os.Stat(shell); err == nil {
+			return shell
+		}
+	}
+	return "/usr/bin/false"
+}
+
+// ownerOf returns the owner and group chown gives the service account's
+// files: its primary group, or the group of the same name when the
+// account is only being created in a dry run
+func ownerOf(name string) string {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return name + ":" + name
+	}
+	return u.Uid + ":" + u.Gid
+}
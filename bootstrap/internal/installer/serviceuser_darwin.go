@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Range macOS keeps for system accounts that are not Apple's own
+const (
+	firstServiceID = 200
+	lastServiceID  = 400
+)
+
+// createServiceUser creates a hidden system account and a group of the
+// same name in the local directory
+func (i *Installer) createServiceUser(name string) error {
+	if _, err := user.Lookup(name); err == nil {
+		return nil
+	}
+
+	gid := ""
+	if group, err := user.LookupGroup(name); err == nil {
+		gid = group.Gid
+	}
+
+	ids, err := directoryIDs()
+	if err != nil {
+		return err
+	}
+	id := ""
+	for candidate := firstServiceID; candidate <= lastServiceID; candidate++ {
+		if !ids[candidate] {
+			id = strconv.Itoa(candidate)
+			break
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("no free system account ID between %d and %d", firstServiceID, lastServiceID)
+	}
+
+	var commands [][]string
+	if gid == "" {
+		gid = id
+		commands = append(commands,
+			[]string{"/Groups/" + name},
+			[]string{"/Groups/" + name, "PrimaryGroupID", gid},
+			[]string{"/Groups/" + name, "RealName", "Ezra"},
+		)
+	}
+	commands = append(commands,
+		[]string{"/Users/" + name},
+		[]string{"/Users/" + name, "UniqueID", id},
+		[]string{"/Users/" + name, "PrimaryGroupID", gid},
+		[]string{"/Users/" + name, "UserShell", "/usr/bin/false"},
+		[]string{"/Users/" + name, "NFSHomeDirectory", i.config.DataPath},
+		[]string{"/Users/" + name, "RealName", "Ezra"},
+		[]string{"/Users/" + name, "IsHidden", "1"},
+	)
+	for _, args := range commands {
+		if err := i.runServiceCommand("dscl", append([]string{".", "-create"}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directoryIDs returns the user and group IDs in use
+func directoryIDs() (map[int]bool, error) {
+	ids := map[int]bool{}
+	for _, args := range [][]string{{"/Users", "UniqueID"}, {"/Groups", "PrimaryGroupID"}} {
+		out, err := exec.Command("dscl", append([]string{".", "-list"}, args...)...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", args[0], err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			if id, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				ids[id] = true
+			}
+		}
+	}
+	return ids, nil
+}
+
+// grantPath makes the service account own path
+func (i *Installer) grantPath(name, path string) error {
+	return i.runServiceCommand("chown", "-R", ownerOf(name), path)
+}
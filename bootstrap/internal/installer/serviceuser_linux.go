@@ -0,0 +1,38 @@
+package installer
+
+import (
+	"os/exec"
+	"os/user"
+)
+
+// createServiceUser creates a system account and a group of the same
+// name, with useradd or, on BusyBox systems such as Alpine, adduser
+func (i *Installer) createServiceUser(name string) error {
+	if _, err := user.Lookup(name); err == nil {
+		return nil
+	}
+	_, groupErr := user.LookupGroup(name)
+	shell := nologinShell()
+
+	if _, err := exec.LookPath("useradd"); err == nil {
+		args := []string{"--system", "--home-dir", i.config.DataPath, "--no-create-home", "--shell", shell}
+		if groupErr == nil {
+			args = append(args, "--gid", name)
+		} else {
+			args = append(args, "--user-group")
+		}
+		return i.runServiceCommand("useradd", append(args, name)...)
+	}
+
+	if groupErr != nil {
+		if err := i.runServiceCommand("addgroup", "-S", name); err != nil {
+			return err
+		}
+	}
+	return i.runServiceCommand("adduser", "-S", "-D", "-H", "-h", i.config.DataPath, "-s", shell, "-G", name, name)
+}
+
+// grantPath makes the service account own path
+func (i *Installer) grantPath(name, path string) error {
+	return i.runServiceCommand("chown", "-R", ownerOf(name), path)
+}
@@ -0,0 +1,25 @@
+//go:build !linux && !darwin && !windows
+
+package installer
+
+import (
+	"fmt"
+	"os/user"
+	"runtime"
+
+	"github.com/ezra/bootstrap/internal/failure"
+)
+
+// createServiceUser only checks that the account exists: creating one
+// is not supported on this platform
+func (i *Installer) createServiceUser(name string) error {
+	if _, err := user.Lookup(name); err != nil {
+		return failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("creating service user %s is not supported on %s: create it, or set service_user to an existing account", name, runtime.GOOS))
+	}
+	return nil
+}
+
+// grantPath makes the service account own path
+func (i *Installer) grantPath(name, path string) error {
+	return i.runServiceCommand("chown", "-R", ownerOf(name), path)
+}
@@ -0,0 +1,135 @@
+package installer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// The LSA functions that grant account rights, which x/sys does not wrap
+var (
+	modadvapi32               = windows.NewLazySystemDLL("advapi32.dll")
+	procLsaOpenPolicy         = modadvapi32.NewProc("LsaOpenPolicy")
+	procLsaAddAccountRights   = modadvapi32.NewProc("LsaAddAccountRights")
+	procLsaClose              = modadvapi32.NewProc("LsaClose")
+	procLsaNtStatusToWinError = modadvapi32.NewProc("LsaNtStatusToWinError")
+)
+
+// Access rights of the LSA policy object
+const (
+	policyCreateAccount = 0x00000010
+	policyLookupNames   = 0x00000800
+)
+
+// lsaUnicodeString is LSA_UNICODE_STRING
+type lsaUnicodeString struct {
+	Length        uint16
+	MaximumLength uint16
+	Buffer        *uint16
+}
+
+// lsaObjectAttributes is LSA_OBJECT_ATTRIBUTES, which must be zeroed
+type lsaObjectAttributes struct {
+	Length                   uint32
+	RootDirectory            windows.Handle
+	ObjectName               *lsaUnicodeString
+	Attributes               uint32
+	SecurityDescriptor       uintptr
+	SecurityQualityOfService uintptr
+}
+
+// serviceUserScript creates the service account, or resets its password
+// so that services can be registered with it. The password is passed in
+// the environment rather than on the command line.
+const serviceUserScript = `$p = ConvertTo-SecureString $env:EZRA_SERVICE_PASSWORD -AsPlainText -Force
+if (Get-LocalUser -Name $env:EZRA_SERVICE_USER -ErrorAction SilentlyContinue) {
+	Set-LocalUser -Name $env:EZRA_SERVICE_USER -Password $p -PasswordNeverExpires $true
+} else {
+	New-LocalUser -Name $env:EZRA_SERVICE_USER -Password $p -PasswordNeverExpires -AccountNeverExpires -Description 'Ezra service account' | Out-Null
+}`
+
+// createServiceUser creates a local account with a random password and
+// allows it to log on as a service
+func (i *Installer) createServiceUser(name string) error {
+	if i.dryRun {
+		i.plan.addCommand("New-LocalUser " + name)
+		return nil
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	// The suffix satisfies password complexity policies
+	password := base64.RawURLEncoding.EncodeToString(secret) + "#Ez1"
+
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", serviceUserScript)
+	cmd.Env = append(os.Environ(), "EZRA_SERVICE_USER="+name, "EZRA_SERVICE_PASSWORD="+password)
+	if _, err := i.combinedOutput(cmd); err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+
+	if err := grantServiceLogon(name); err != nil {
+		return fmt.Errorf("failed to allow %s to log on as a service: %w", name, err)
+	}
+	i.servicePassword = password
+	redact.AddSecret(password)
+	return nil
+}
+
+// grantServiceLogon gives an account the right to log on as a service
+func grantServiceLogon(account string) error {
+	sid, _, _, err := windows.LookupSID("", account)
+	if err != nil {
+		return err
+	}
+
+	var attrs lsaObjectAttributes
+	attrs.Length = uint32(unsafe.Sizeof(attrs))
+	var policy windows.Handle
+	status, _, _ := procLsaOpenPolicy.Call(0, uintptr(unsafe.Pointer(&attrs)),
+		policyCreateAccount|policyLookupNames, uintptr(unsafe.Pointer(&policy)))
+	if status != 0 {
+		return lsaError(status)
+	}
+	defer procLsaClose.Call(uintptr(policy))
+
+	right, err := windows.UTF16FromString("SeServiceLogonRight")
+	if err != nil {
+		return err
+	}
+	rights := lsaUnicodeString{
+		Length:        uint16((len(right) - 1) * 2),
+		MaximumLength: uint16(len(right) * 2),
+		Buffer:        &right[0],
+	}
+	status, _, _ = procLsaAddAccountRights.Call(uintptr(policy), uintptr(unsafe.Pointer(sid)),
+		uintptr(unsafe.Pointer(&rights)), 1)
+	if status != 0 {
+		return lsaError(status)
+	}
+	return nil
+}
+
+// lsaError converts an NTSTATUS from the LSA functions
+func lsaError(status uintptr) error {
+	code, _, _ := procLsaNtStatusToWinError.Call(status)
+	return syscall.Errno(code)
+}
+
+// grantPath makes the service account own path and gives it full control
+// of everything below
+func (i *Installer) grantPath(name, path string) error {
+	if err := i.runServiceCommand("icacls", path, "/setowner", name, "/T", "/C", "/Q"); err != nil {
+		return err
+	}
+	return i.runServiceCommand("icacls", path, "/grant", name+":(OI)(CI)F", "/T", "/C", "/Q")
+}
@@ -0,0 +1,256 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+// A component's slots are kept in <slot root>/<component>/: one
+// directory per version under releases/, and the current link to the
+// one in use. The binary in InstallPath links to current.
+const (
+	slotReleasesDir = "releases"
+	slotCurrentLink = "current"
+)
+
+// slotsEnabled reports whether components are installed into slots
+func (i *Installer) slotsEnabled() bool {
+	return i.config.Slots.Enabled
+}
+
+// checkSlots fails if slots are enabled where they cannot be used
+func checkSlots(enabled bool) error {
+	if enabled && runtime.GOOS == "windows" {
+		return errors.New("slots need symbolic links, which are not available on Windows")
+	}
+	return nil
+}
+
+// slotRoot returns where the slots are kept
+func (i *Installer) slotRoot() string {
+	if i.config.Slots.Path != "" {
+		return i.config.Slots.Path
+	}
+	return filepath.Join(filepath.Dir(i.config.InstallPath), "lib", "ezra")
+}
+
+// slotDir returns the slot of a component version
+func (i *Installer) slotDir(component, version string) string {
+	return filepath.Join(i.slotRoot(), component, slotReleasesDir, version)
+}
+
+// currentLink returns the link to a component's slot in use
+func (i *Installer) currentLink(component string) string {
+	return filepath.Join(i.slotRoot(), component, slotCurrentLink)
+}
+
+// replaceBinary puts a staged binary of a component version in place:
+// over the installed binary, or into its slot when slots are enabled.
+// previous is the version being replaced. Every step is journaled.
+func (i *Installer) replaceBinary(component, previous, version, staged string) error {
+	target := filepath.Join(i.config.InstallPath, binaryName(component))
+	if i.slotsEnabled() {
+		if err := i.installSlot(component, previous, version, staged); err != nil {
+			return err
+		}
+	} else {
+		if err := i.journalWrite(target); err != nil {
+			return err
+		}
+		if err := i.rename(staged, target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+
+	if sum, err := fileSHA256(target); err == nil {
+		i.recordDeployed(target, sum)
+	}
+	return nil
+}
+
+// installSlot installs a component version into its slot and switches
+// the component to it. A binary installed before slots were enabled is
+// first kept as the slot of its version, so that it can be switched
+// back to.
+func (i *Installer) installSlot(component, previous, version, staged string) error {
+	if version == "" {
+		return fmt.Errorf("%s has no version to name its slot after", component)
+	}
+	target := filepath.Join(i.config.InstallPath, binaryName(component))
+	if err := i.adoptBinary(component, previous, target); err != nil {
+		return fmt.Errorf("failed to keep %s as slot %s: %w", target, previous, err)
+	}
+
+	dir := i.slotDir(component, version)
+	if err := i.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	binary := filepath.Join(dir, binaryName(component))
+	if err := i.journalWrite(binary); err != nil {
+		return err
+	}
+	if err := i.rename(staged, binary); err != nil {
+		return fmt.Errorf("failed to install %s: %w", binary, err)
+	}
+
+	if err := i.switchSlot(component, version); err != nil {
+		return err
+	}
+	return i.linkSlot(component, target)
+}
+
+// adoptBinary copies a binary installed outside the slots into the slot
+// of its version, unless there is one
+func (i *Installer) adoptBinary(component, version, target string) error {
+	info, err := os.Lstat(target)
+	if err != nil || !info.Mode().IsRegular() || version == "" {
+		return nil
+	}
+	binary := filepath.Join(i.slotDir(component, version), binaryName(component))
+	if fileExists(binary) {
+		return nil
+	}
+	if err := i.mkdirAll(filepath.Dir(binary), 0755); err != nil {
+		return err
+	}
+	return i.installFile(target, binary, 0755)
+}
+
+// switchSlot points a component's current link at the slot of a
+// version. The new link is renamed over the old one, so the component
+// always has one. A rollback switches back to the previous slot.
+func (i *Installer) switchSlot(component, version string) error {
+	link := i.currentLink(component)
+	dest := filepath.Join(slotReleasesDir, version)
+	previous, err := os.Readlink(link)
+	hadPrevious := err == nil
+	if hadPrevious && previous == dest {
+		return nil
+	}
+
+	if err := i.replaceLink(dest, link); err != nil {
+		return fmt.Errorf("failed to switch %s to slot %s: %w", component, version, err)
+	}
+	i.log.Infof("Switched %s to slot %s", component, version)
+
+	if i.journal != nil {
+		i.journal.recordStep("switch "+component+" slot", func() error {
+			if !hadPrevious {
+				return i.removeAll(link)
+			}
+			return i.replaceLink(previous, link)
+		})
+	}
+	return nil
+}
+
+// replaceLink points link at dest by renaming a new link over it
+func (i *Installer) replaceLink(dest, link string) error {
+	tmp := link + ".new"
+	if i.needsElevation(link) {
+		if _, err := i.runElevated("ln", "-sfn", dest, tmp); err != nil {
+			return err
+		}
+		// mv must not follow a link to a directory into it
+		noFollow := "-T"
+		if runtime.GOOS != "linux" {
+			noFollow = "-h"
+		}
+		_, err := i.runElevated("mv", "-f", noFollow, tmp, link)
+		return err
+	}
+
+	os.Remove(tmp)
+	if err := os.Symlink(dest, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkSlot makes the binary in InstallPath a link into the component's
+// current slot, replacing a binary installed before slots were enabled
+func (i *Installer) linkSlot(component, target string) error {
+	dest := filepath.Join(i.currentLink(component), binaryName(component))
+	if current, err := os.Readlink(target); err == nil && current == dest {
+		return nil
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		if err := i.journalWrite(target); err != nil {
+			return err
+		}
+		if err := i.removeAll(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+	return i.symlink(dest, target)
+}
+
+// pruneSlots removes the slots of components beyond the one in use and
+// the configured number of previous ones, keeping the most recently
+// installed. Old slots only waste disk space, so errors are logged.
+func (i *Installer) pruneSlots(changed []string) {
+	if !i.slotsEnabled() || i.dryRun {
+		return
+	}
+	keep := i.config.Slots.Keep
+	if keep <= 0 {
+		keep = 1
+	}
+
+	for _, component := range changed {
+		dir := filepath.Join(i.slotRoot(), component, slotReleasesDir)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		current, _ := os.Readlink(i.currentLink(component))
+
+		var slots []os.FileInfo
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.IsDir() || filepath.Join(slotReleasesDir, entry.Name()) == current {
+				continue
+			}
+			slots = append(slots, info)
+		}
+		sort.Slice(slots, func(a, b int) bool {
+			return slots[a].ModTime().After(slots[b].ModTime())
+		})
+
+		for n := keep; n < len(slots); n++ {
+			path := filepath.Join(dir, slots[n].Name())
+			if err := i.removeAll(path); err != nil {
+				i.log.Errorf("Failed to remove old slot %s: %v", path, err)
+				continue
+			}
+			i.log.Infof("Removed old slot %s", path)
+		}
+	}
+}
+
+// removeSlots deletes the slots of every component
+func (i *Installer) removeSlots(report *UninstallReport) error {
+	root := i.slotRoot()
+	for _, component := range components {
+		dir := filepath.Join(root, component)
+		if _, err := os.Lstat(dir); err != nil {
+			continue
+		}
+		if err := i.removeAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+		report.RemovedBinaries = append(report.RemovedBinaries, dir)
+	}
+	// Only remove the root once nothing else is left in it
+	os.Remove(root)
+	return nil
+}
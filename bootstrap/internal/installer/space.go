@@ -0,0 +1,126 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/downloader"
+)
+
+// plannedFile is a file the install will download and install, for the
+// disk space check
+type plannedFile struct {
+	// name is the file name in CachePath
+	name string
+	// location is a URL or a path under the companion, or empty for the
+	// component's release file
+	location string
+	// size is the size in bytes, or zero if it must be asked from the
+	// server
+	size   int64
+	sha256 string
+	// target is where the file is installed
+	target string
+}
+
+// spaceUsage is the space an install needs on one file system
+type spaceUsage struct {
+	mount     string
+	available uint64
+	required  uint64
+}
+
+// checkDiskSpace fails before anything is downloaded if the file systems
+// holding CachePath and the install targets cannot take the files. Files
+// already downloaded or in the download cache are not counted, nor are
+// files whose size is unknown.
+func (i *Installer) checkDiskSpace(files []plannedFile) error {
+	ctx := i.ctx
+	usage := map[string]*spaceUsage{}
+	disks := map[string]*spaceUsage{}
+
+	add := func(path string, size int64) {
+		u, ok := disks[path]
+		if !ok {
+			disk, err := detector.DiskUsage(path)
+			if err != nil {
+				// Unknown free space: leave it to the write to fail
+				i.log.Errorf("Cannot check free space for %s: %v", path, err)
+				disks[path] = nil
+				return
+			}
+			if u = usage[disk.Mount]; u == nil {
+				u = &spaceUsage{mount: disk.Mount, available: disk.FreeBytes}
+				usage[disk.Mount] = u
+			}
+			disks[path] = u
+		}
+		if u != nil {
+			u.required += uint64(size)
+		}
+	}
+
+	for _, file := range files {
+		size := file.size
+		if size <= 0 {
+			var err error
+			if file.location == "" {
+				size, err = i.downloader.ComponentSize(ctx, file.name)
+			} else {
+				size, err = i.downloader.FileSize(ctx, file.location)
+			}
+			if err != nil || size <= 0 {
+				i.log.Infof("Size of %s unknown, not counted in the disk space check", file.name)
+				continue
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(i.config.CachePath, file.name)); err != nil {
+			add(i.config.CachePath, size)
+			// Verified downloads are also copied into the download cache
+			if !i.config.NoCache && file.sha256 != "" && !i.downloader.Cached(file.sha256) {
+				add(cacheDir(i.config), size)
+			}
+		}
+		add(file.target, size)
+	}
+
+	mounts := make([]string, 0, len(usage))
+	for mount := range usage {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	for _, mount := range mounts {
+		u := usage[mount]
+		if u.required > u.available {
+			return failure.Wrap(failure.Disk, fmt.Errorf("not enough disk space on %s: %s required, %s available; free up %s or move cache_path or install_path",
+				u.mount, formatSize(u.required), formatSize(u.available), formatSize(u.required-u.available)))
+		}
+		i.log.Infof("Disk space on %s: %s required, %s available", u.mount, formatSize(u.required), formatSize(u.available))
+	}
+	return nil
+}
+
+// componentFiles lists the release files of the components for the disk
+// space check, with the sizes the release manifest gives
+func (i *Installer) componentFiles(manifest *downloader.Manifest) []plannedFile {
+	files := make([]plannedFile, 0, len(i.components))
+	for _, component := range i.components {
+		var release downloader.ComponentManifest
+		if manifest != nil {
+			release = manifest.Components[component]
+		}
+		files = append(files, plannedFile{
+			name:   component,
+			size:   release.Size,
+			sha256: release.SHA256,
+			target: filepath.Join(i.config.InstallPath, binaryName(component)),
+		})
+	}
+	return files
+}
@@ -0,0 +1,213 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/events"
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// stateFileName is the file under DataPath that tracks install progress
+const stateFileName = "install-state.json"
+
+// Installation phases tracked in the state file
+const (
+	phaseDownload  = "download"
+	phaseCopy      = "copy"
+	phaseInstall   = "install"
+	phaseConfigure = "configure"
+	phaseStart     = "start"
+)
+
+// installState is the persisted progress of an installation. It lets an
+// interrupted install resume from the last completed step.
+type installState struct {
+	Mode            string    `json:"mode"`
+	Downloaded      []string  `json:"downloaded"`
+	CompletedPhases []string  `json:"completed_phases"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	path string
+}
+
+// loadState reads the state file for the given install mode. A missing
+// file, an unreadable file or a state left by a different mode yields a
+// fresh state.
+func loadState(path, mode string) *installState {
+	state := &installState{Mode: mode, path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state
+	}
+
+	var saved installState
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Mode != mode {
+		return state
+	}
+
+	saved.path = path
+	return &saved
+}
+
+// save writes the state file
+func (s *installState) save() error {
+	s.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal install state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write install state: %w", err)
+	}
+
+	return nil
+}
+
+// clear removes the state file once an installation has completed
+func (s *installState) clear() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *installState) hasPhase(phase string) bool {
+	return contains(s.CompletedPhases, phase)
+}
+
+func (s *installState) markPhase(phase string) {
+	if !s.hasPhase(phase) {
+		s.CompletedPhases = append(s.CompletedPhases, phase)
+	}
+}
+
+func (s *installState) hasDownloaded(component string) bool {
+	return contains(s.Downloaded, component)
+}
+
+func (s *installState) markDownloaded(component string) {
+	if !s.hasDownloaded(component) {
+		s.Downloaded = append(s.Downloaded, component)
+	}
+}
+
+// resetAfterRollback forgets every phase a rollback has undone. Downloads
+// live in the cache and are not rolled back, so they are kept.
+func (s *installState) resetAfterRollback() {
+	var kept []string
+	for _, phase := range s.CompletedPhases {
+		if phase == phaseDownload {
+			kept = append(kept, phase)
+		}
+	}
+	s.CompletedPhases = kept
+}
+
+// beginState loads the persisted progress for an install mode
+func (i *Installer) beginState(mode string) {
+	if i.dryRun {
+		return
+	}
+
+	i.state = loadState(filepath.Join(i.config.DataPath, stateFileName), mode)
+	if len(i.state.CompletedPhases) > 0 || len(i.state.Downloaded) > 0 {
+		i.log.Infof("Resuming previous installation (completed: %v)", i.state.CompletedPhases)
+	}
+}
+
+// runPhase runs an installation phase unless the state file records it
+// as already completed, then the custom steps registered after it
+func (i *Installer) runPhase(phase string, fn func() error) error {
+	if err := i.trackPhase(phase, fn); err != nil {
+		return err
+	}
+	return i.runSteps(phase)
+}
+
+// trackPhase runs fn unless the state file records phase as already
+// completed, and persists its completion. Its result and duration go in
+// the install report.
+func (i *Installer) trackPhase(phase string, fn func() error) error {
+	if i.state != nil && i.state.hasPhase(phase) {
+		i.log.Infof("Skipping %s phase (already completed)", phase)
+		i.reportPhase(phase, phaseSkipped, time.Time{})
+		i.events.Emit(events.Event{Event: events.PhaseSkipped, Phase: phase})
+		return nil
+	}
+
+	// An interrupted install starts no further phase
+	if err := i.ctx.Err(); err != nil {
+		return err
+	}
+
+	i.setLogField(logger.FieldPhase, phase)
+	defer i.setLogField(logger.FieldPhase, nil)
+
+	started := time.Now()
+	i.events.Emit(events.Event{Event: events.PhaseStarted, Phase: phase})
+	if err := fn(); err != nil {
+		i.reportPhase(phase, phaseFailed, started)
+		i.events.Emit(events.Event{
+			Event:      events.PhaseFailed,
+			Phase:      phase,
+			Code:       errorCode(err),
+			Message:    err.Error(),
+			DurationMS: time.Since(started).Milliseconds(),
+		})
+		return err
+	}
+	i.reportPhase(phase, phaseCompleted, started)
+	i.events.Emit(events.Event{Event: events.PhaseCompleted, Phase: phase, DurationMS: time.Since(started).Milliseconds()})
+	i.logPhaseCompleted(phase, started)
+	if i.state == nil {
+		return nil
+	}
+
+	i.state.markPhase(phase)
+	if err := i.state.save(); err != nil {
+		i.log.Errorf("Failed to save install state: %v", err)
+	}
+
+	return nil
+}
+
+// finishState clears or trims the persisted progress once an install
+// attempt has ended
+func (i *Installer) finishState(installErr error) {
+	if i.state == nil {
+		return
+	}
+	defer func() { i.state = nil }()
+
+	if installErr == nil {
+		if err := i.state.clear(); err != nil {
+			i.log.Errorf("Failed to remove install state: %v", err)
+		}
+		return
+	}
+
+	i.state.resetAfterRollback()
+	if err := i.state.save(); err != nil {
+		i.log.Errorf("Failed to save install state: %v", err)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
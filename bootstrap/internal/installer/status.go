@@ -0,0 +1,283 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Service states reported by Status
+const (
+	serviceRunning      = "running"
+	serviceStopped      = "stopped"
+	serviceStarting     = "starting"
+	serviceStopping     = "stopping"
+	serviceFailed       = "failed"
+	serviceNotInstalled = "not-installed"
+	serviceUnknown      = "unknown"
+)
+
+// Status describes the state of an installation
+type Status struct {
+	Installed  bool              `json:"installed"`
+	Bootstrap  string            `json:"bootstrap_version,omitempty"`
+	Components []ComponentStatus `json:"components"`
+	Services   []ServiceStatus   `json:"services"`
+	// LastUpdated is when components were last installed, upgraded or
+	// repaired
+	LastUpdated *time.Time       `json:"last_updated,omitempty"`
+	Enrollment  EnrollmentStatus `json:"enrollment"`
+}
+
+// ComponentStatus describes an installed component
+type ComponentStatus struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Installed bool   `json:"installed"`
+	Version   string `json:"version,omitempty"`
+}
+
+// ServiceStatus describes the state of a service
+type ServiceStatus struct {
+	Name string `json:"name"`
+	// State is running, stopped, starting, stopping, failed,
+	// not-installed or unknown
+	State string `json:"state"`
+	// Health is "ready" when a running service answers its health check,
+	// or else why it is not
+	Health string `json:"health,omitempty"`
+}
+
+// EnrollmentStatus describes the credential the device enrolled with
+type EnrollmentStatus struct {
+	Enrolled   bool       `json:"enrolled"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	EnrolledAt *time.Time `json:"enrolled_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// Expired is set if the device enrolled but its credential expired
+	Expired bool `json:"expired,omitempty"`
+}
+
+// CompanionStatus describes whether the companion can be reached
+type CompanionStatus struct {
+	URL       string        `json:"url"`
+	Reachable bool          `json:"reachable"`
+	Latency   time.Duration `json:"latency_ns,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Status reports which components are installed, whether their services
+// are running and healthy, when the installation last changed and
+// whether the device is enrolled
+func (i *Installer) Status() *Status {
+	status := &Status{Bootstrap: i.bootstrapVersion}
+	versions := i.loadInstalledVersions()
+
+	for _, component := range components {
+		path := filepath.Join(i.config.InstallPath, binaryName(component))
+		_, err := os.Stat(path)
+
+		installed := err == nil
+		status.Installed = status.Installed || installed
+		status.Components = append(status.Components, ComponentStatus{
+			Name:      component,
+			Path:      path,
+			Installed: installed,
+			Version:   versions[component],
+		})
+	}
+
+	probes := map[string]func(ctx context.Context) (serviceHealth, error){
+		"companion": i.companionProbe(),
+		"agent":     i.agentProbe(),
+	}
+	for _, component := range []string{"companion", "agent"} {
+		if !fileExists(filepath.Join(i.config.InstallPath, binaryName(component))) {
+			continue
+		}
+		service := ServiceStatus{Name: "ezra-" + component, State: i.serviceState("ezra-" + component)}
+		if service.State == serviceRunning && !i.config.HealthCheck.Disabled {
+			service.Health = "ready"
+			if err := i.probeService(component, probes[component]); err != nil {
+				service.Health = err.Error()
+			}
+		}
+		status.Services = append(status.Services, service)
+	}
+
+	if info, err := os.Stat(filepath.Join(i.config.DataPath, installedVersionsFile)); err == nil {
+		updated := info.ModTime()
+		status.LastUpdated = &updated
+	}
+	status.Enrollment = i.enrollmentStatus()
+
+	return status
+}
+
+// enrollmentStatus reads the stored credential of the device
+func (i *Installer) enrollmentStatus() EnrollmentStatus {
+	data, err := readSecret(i.config, i.deviceCredentialPath())
+	if err != nil {
+		return EnrollmentStatus{}
+	}
+	var credential deviceCredential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return EnrollmentStatus{}
+	}
+
+	status := EnrollmentStatus{
+		Enrolled:  true,
+		DeviceID:  credential.DeviceID,
+		ExpiresAt: credential.ExpiresAt,
+	}
+	if !credential.EnrolledAt.IsZero() {
+		status.EnrolledAt = &credential.EnrolledAt
+	}
+	if credential.ExpiresAt != nil && time.Now().After(*credential.ExpiresAt) {
+		status.Enrolled = false
+		status.Expired = true
+	}
+	return status
+}
+
+// CheckCompanion asks the companion once whether it can be reached
+func (i *Installer) CheckCompanion() *CompanionStatus {
+	status := &CompanionStatus{URL: i.config.CompanionURL}
+	latency, err := i.downloader.Ping(i.ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+	status.Latency = latency
+	return status
+}
+
+// serviceState asks the service manager the service is registered with
+// for its state
+func (i *Installer) serviceState(name string) string {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return serviceUnknown
+	}
+
+	switch supervisor {
+	case supervisorSystemd:
+		return i.systemdServiceState(name)
+	case supervisorLaunchd:
+		return i.launchdServiceState(name)
+	case supervisorSCM:
+		state, err := windowsServiceState(name)
+		if err != nil {
+			return serviceUnknown
+		}
+		return state
+	case supervisorTask:
+		return userTaskState(name)
+	case supervisorOpenRC:
+		return initScriptState(filepath.Join(initScriptDir, name), exec.Command("rc-service", name, "status"))
+	case supervisorSysV:
+		script := filepath.Join(initScriptDir, name)
+		return initScriptState(script, exec.Command(script, "status"))
+	case supervisorRunit:
+		if !fileExists(filepath.Join(runitServiceDir, name)) {
+			return serviceNotInstalled
+		}
+		// sv prints "run: <name>: ..." for a running service
+		out, _ := exec.Command("sv", "status", name).Output()
+		switch {
+		case strings.HasPrefix(string(out), "run:"):
+			return serviceRunning
+		case strings.HasPrefix(string(out), "down:"):
+			return serviceStopped
+		}
+		return serviceUnknown
+	default:
+		return serviceUnknown
+	}
+}
+
+// systemdServiceState returns the state of a systemd unit
+func (i *Installer) systemdServiceState(name string) string {
+	dir, err := i.systemdUnitDir()
+	if err != nil {
+		return serviceUnknown
+	}
+	if !fileExists(filepath.Join(dir, name+".service")) {
+		return serviceNotInstalled
+	}
+
+	// is-active exits non-zero for inactive units but still prints the state
+	out, _ := i.systemctl("is-active", name).Output()
+	switch strings.TrimSpace(string(out)) {
+	case "active", "reloading":
+		return serviceRunning
+	case "inactive":
+		return serviceStopped
+	case "activating":
+		return serviceStarting
+	case "deactivating":
+		return serviceStopping
+	case "failed":
+		return serviceFailed
+	default:
+		return serviceUnknown
+	}
+}
+
+// launchdServiceState returns the state of a launchd job. Stopped jobs
+// are unloaded, see controlLaunchdService.
+func (i *Installer) launchdServiceState(name string) string {
+	domain, dir, err := i.launchdDomain()
+	if err != nil {
+		return serviceUnknown
+	}
+	label := launchdLabel(name)
+	if !fileExists(filepath.Join(dir, label+".plist")) {
+		return serviceNotInstalled
+	}
+
+	out, err := exec.Command("launchctl", "print", domain+"/"+label).Output()
+	if err != nil {
+		return serviceStopped
+	}
+	if strings.Contains(string(out), "state = running") {
+		return serviceRunning
+	}
+	return serviceStopped
+}
+
+// userTaskState returns the state of a per-user scheduled task
+func userTaskState(name string) string {
+	out, err := exec.Command("schtasks", "/Query", "/TN", userTaskName(name), "/FO", "LIST").Output()
+	if err != nil {
+		return serviceNotInstalled
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) != "Status" {
+			continue
+		}
+		if strings.TrimSpace(value) == "Running" {
+			return serviceRunning
+		}
+		return serviceStopped
+	}
+	return serviceUnknown
+}
+
+// initScriptState returns the state of an OpenRC or SysV init service
+// from its status command, which exits zero only while it runs
+func initScriptState(script string, status *exec.Cmd) string {
+	if !fileExists(script) {
+		return serviceNotInstalled
+	}
+	if status.Run() != nil {
+		return serviceStopped
+	}
+	return serviceRunning
+}
@@ -0,0 +1,104 @@
+package installer
+
+import (
+	"fmt"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// Phases custom steps can follow. Online installs download and offline
+// installs copy, so steps after the other one do not run.
+const (
+	PhaseDownload  = phaseDownload
+	PhaseCopy      = phaseCopy
+	PhaseInstall   = phaseInstall
+	PhaseConfigure = phaseConfigure
+	PhaseStart     = phaseStart
+)
+
+// stepPhasePrefix marks custom steps in the install state
+const stepPhasePrefix = "step:"
+
+// Step is a custom install step, such as enrolling the device in an MDM
+// or configuring a VPN, that an embedder runs after one of the built-in
+// phases
+type Step interface {
+	// Name identifies the step in logs, the dry-run plan and the install
+	// state, which keeps a completed step from running again on resume
+	Name() string
+	// Run performs the step. It is not called in a dry run.
+	Run(ctx *StepContext) error
+}
+
+// RollbackStep is a Step that can undo itself when the install fails
+// after it ran
+type RollbackStep interface {
+	Step
+	Rollback(ctx *StepContext) error
+}
+
+// StepContext is what a custom step sees of the install
+type StepContext struct {
+	Config     *config.Config
+	SystemInfo *detector.SystemInfo
+	Log        Logger
+	// Phase is the built-in phase the step follows
+	Phase string
+}
+
+// RegisterStep adds a custom step to run after a built-in phase. Steps
+// after the same phase run in the order they were registered.
+func (i *Installer) RegisterStep(after string, step Step) error {
+	switch after {
+	case PhaseDownload, PhaseCopy, PhaseInstall, PhaseConfigure, PhaseStart:
+	default:
+		return fmt.Errorf("unknown install phase %q", after)
+	}
+	for _, steps := range i.steps {
+		for _, registered := range steps {
+			if registered.Name() == step.Name() {
+				return fmt.Errorf("install step %s is already registered", step.Name())
+			}
+		}
+	}
+
+	if i.steps == nil {
+		i.steps = map[string][]Step{}
+	}
+	i.steps[after] = append(i.steps[after], step)
+	return nil
+}
+
+// runSteps runs the custom steps registered after a phase, each tracked
+// in the install state like a phase of its own
+func (i *Installer) runSteps(phase string) error {
+	for _, step := range i.steps[phase] {
+		ctx := &StepContext{
+			Config:     i.config,
+			SystemInfo: i.systemInfo,
+			Log:        i.log,
+			Phase:      phase,
+		}
+
+		err := i.trackPhase(stepPhasePrefix+step.Name(), func() error {
+			if i.dryRun {
+				i.plan.addCommand("step " + step.Name())
+				return nil
+			}
+
+			i.log.Infof("Running install step %s...", step.Name())
+			if err := step.Run(ctx); err != nil {
+				return err
+			}
+			if undo, ok := step.(RollbackStep); ok && i.journal != nil {
+				i.journal.recordStep(step.Name(), func() error { return undo.Rollback(ctx) })
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("install step %s failed: %w", step.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// Process supervision strategies. Init systems are named as the
+// detector reports them.
+const (
+	supervisorSystemd = detector.InitSystemd
+	supervisorOpenRC  = detector.InitOpenRC
+	supervisorRunit   = detector.InitRunit
+	supervisorSysV    = detector.InitSysV
+	supervisorLaunchd = detector.InitLaunchd
+	// supervisorSCM registers Windows services
+	supervisorSCM = detector.InitSCM
+	// supervisorContainer leaves restarts to the container runtime: no
+	// units are written and an entrypoint runs the agent in the
+	// foreground
+	supervisorContainer = "container"
+	// supervisorDirect only starts the processes, for systems without a
+	// usable init system such as WSL 1
+	supervisorDirect = "direct"
+	// supervisorTask registers Windows scheduled tasks that run at logon,
+	// in place of services when installing for the current user
+	supervisorTask = "task"
+)
+
+// containerEntrypoint is written to InstallPath for use as the
+// container's command
+const containerEntrypoint = "ezra-entrypoint.sh"
+
+// supervisor returns the process supervision strategy for this system:
+// the running init system, outside containers that have none. In user
+// mode the user's own service manager is used.
+func (i *Installer) supervisor() (string, error) {
+	supervisor, err := i.systemSupervisor()
+	if err != nil || !i.userMode() {
+		return supervisor, err
+	}
+	return userSupervisor(supervisor), nil
+}
+
+// systemSupervisor returns the configured or detected supervision
+// strategy
+func (i *Installer) systemSupervisor() (string, error) {
+	switch i.config.Supervisor {
+	case supervisorSystemd, supervisorOpenRC, supervisorRunit, supervisorSysV,
+		supervisorLaunchd, supervisorSCM, supervisorContainer, supervisorDirect:
+		return i.config.Supervisor, nil
+	case "", "auto":
+	default:
+		return "", fmt.Errorf("unknown supervisor %q", i.config.Supervisor)
+	}
+
+	if i.systemInfo == nil {
+		switch runtime.GOOS {
+		case "linux":
+			return supervisorSystemd, nil
+		case "darwin":
+			return supervisorLaunchd, nil
+		case "windows":
+			return supervisorSCM, nil
+		default:
+			return "", failure.Wrap(failure.UnsupportedPlatform, fmt.Errorf("unsupported platform: %s", runtime.GOOS))
+		}
+	}
+
+	initSystem := i.systemInfo.InitSystem
+	switch {
+	// Windows containers have a service manager but stop with their
+	// entrypoint like any other
+	case i.systemInfo.Environment.InContainer() && (initSystem == "" || initSystem == supervisorSCM):
+		return supervisorContainer, nil
+	case initSystem != "":
+		return initSystem, nil
+	default:
+		return supervisorDirect, nil
+	}
+}
+
+// setupContainerEntrypoint writes a script that starts the companion and
+// runs the agent in the foreground, so that the container stops, and is
+// restarted by its runtime, when the agent exits. Without the agent the
+// companion runs in the foreground.
+func (i *Installer) setupContainerEntrypoint() error {
+	companion := shellQuote(filepath.Join(i.config.InstallPath, "ezra-companion")) + " start --port " + strconv.Itoa(i.companionPort)
+	agent := shellQuote(filepath.Join(i.config.InstallPath, "ezra-agent")) + " start"
+
+	// The last process runs in the foreground as the container command
+	var commands []string
+	switch {
+	case i.selected("companion") && i.selected("agent"):
+		commands = []string{companion + " &", "exec " + agent}
+	case i.selected("agent"):
+		commands = []string{"exec " + agent}
+	case i.selected("companion"):
+		commands = []string{"exec " + companion}
+	default:
+		i.log.Info("Running in a container without the companion or the agent: no container command to write")
+		return nil
+	}
+
+	entrypoint := filepath.Join(i.config.InstallPath, containerEntrypoint)
+	i.log.Infof("Running in a container: use %s as the container command so the runtime restarts the services", entrypoint)
+
+	script := "#!/bin/sh\ncd " + shellQuote(i.config.DataPath) + "\n" + strings.Join(commands, "\n") + "\n"
+	return i.writeFile(entrypoint, []byte(script), 0755)
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,150 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ezra/bootstrap/internal/config"
+	"github.com/ezra/bootstrap/pkg/archive"
+	"github.com/ezra/bootstrap/pkg/companion"
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// supportBundleDir is the directory the files of a support bundle are
+// archived under
+const supportBundleDir = "ezra-support"
+
+// supportLogLines is how much of each service's output a support bundle
+// keeps
+const supportLogLines = 1000
+
+// SupportBundle collects what is needed to look into a problem with the
+// installation into a .tar.gz archive at path: the system information,
+// the installation status, the configuration with its secrets redacted,
+// the install state and last install report, the log of the bootstrap
+// and the recent output of the services. Device keys and credentials
+// are left out. Files that cannot be read are listed in the bundle
+// instead.
+func (i *Installer) SupportBundle(path string) error {
+	var buf bytes.Buffer
+	w := archive.NewTarGzWriter(&buf)
+	now := time.Now()
+	var missing []string
+
+	// Logs and command output may quote secrets, whatever the file
+	add := func(name string, data []byte) error {
+		return w.WriteFile(supportBundleDir+"/"+name, redact.Bytes(data), 0644, now)
+	}
+	addJSON := func(name string, value interface{}) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		return add(name, append(data, '\n'))
+	}
+
+	i.log.Info("Collecting system information and status...")
+	if err := addJSON("system.json", i.systemInfo); err != nil {
+		return err
+	}
+	if err := addJSON("status.json", i.Status()); err != nil {
+		return err
+	}
+	if err := addJSON("companion.json", i.CheckCompanion()); err != nil {
+		return err
+	}
+	cfg, err := i.config.Redacted()
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	if err := add("config.json", append(cfg, '\n')); err != nil {
+		return err
+	}
+
+	reportPath := i.config.Report.Path
+	if reportPath == "" {
+		reportPath = filepath.Join(i.config.DataPath, installReportName)
+	}
+	files := [][2]string{
+		{installReportName, reportPath},
+		{stateFileName, filepath.Join(i.config.DataPath, stateFileName)},
+		{installedVersionsFile, filepath.Join(i.config.DataPath, installedVersionsFile)},
+		{heldVersionsFile, filepath.Join(i.config.DataPath, heldVersionsFile)},
+		{remoteConfigFile, filepath.Join(i.config.DataPath, remoteConfigFile)},
+		{elevationAuditFile, filepath.Join(i.config.DataPath, elevationAuditFile)},
+		{"logs/bootstrap.log", i.config.LogFilePath()},
+	}
+	for _, file := range files {
+		name, source := file[0], file[1]
+		data, err := os.ReadFile(source)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", source, err))
+			continue
+		}
+		// The pulled configuration may carry secrets like the local one
+		if filepath.Ext(name) == ".json" {
+			if redacted, err := config.RedactJSON(data); err == nil {
+				data = append(redacted, '\n')
+			}
+		}
+		if err := add(name, data); err != nil {
+			return err
+		}
+	}
+
+	i.log.Info("Collecting service output...")
+	for _, name := range []string{"ezra-companion", "ezra-agent", updateUnit} {
+		out, err := i.serviceOutput(name, supportLogLines)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("output of %s: %v", name, err))
+			continue
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if err := add("logs/"+name+".log", append(out, '\n')); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := add("missing.txt", []byte(strings.Join(missing, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write support bundle: %w", err)
+	}
+
+	// Logs and reports may still say more than operators should share
+	// with everyone on the machine
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write support bundle: %w", err)
+	}
+	i.log.Infof("Support bundle written to %s", path)
+	return nil
+}
+
+// UploadSupportBundle sends a support bundle to the companion, which
+// keeps it with the device. The device is known by the ID it was
+// installed with and authenticates like it does to pull its
+// configuration.
+func (i *Installer) UploadSupportBundle(path string) (*companion.SupportBundleReceipt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	device := *i.config
+	device.DeviceID = rolloutDeviceID(i.config)
+	i.companion.SetToken(remoteConfigToken(&device))
+	return i.companion.UploadSupportBundle(i.ctx, device.DeviceID, data)
+}
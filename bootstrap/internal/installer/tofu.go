@@ -0,0 +1,99 @@
+package installer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// KeyConfirmation asks the operator whether to trust a signing key seen
+// for the first time, given its fingerprint
+type KeyConfirmation func(fingerprint string) bool
+
+// SetKeyConfirmation sets how a companion key is confirmed before it is
+// pinned. Without one, no key is pinned and installs without a configured
+// key run without signature verification.
+func (i *Installer) SetKeyConfirmation(confirm KeyConfirmation) {
+	i.confirmKey = confirm
+}
+
+// pinCompanionKey trusts the companion's signing key on first use: when
+// no key is configured it is fetched over TLS, confirmed by the operator
+// and pinned into the configuration, which the caller saves.
+func (i *Installer) pinCompanionKey() error {
+	if !i.config.VerifySigs || i.signaturesEnabled() || i.confirmKey == nil {
+		return nil
+	}
+	if i.dryRun {
+		i.plan.addCommand("pin the companion signing key on first use")
+		return nil
+	}
+
+	key, fingerprint, err := i.fetchCompanionKey()
+	if err != nil {
+		return err
+	}
+	if !i.confirmKey(fingerprint) {
+		return fmt.Errorf("companion signing key %s was not accepted", fingerprint)
+	}
+
+	i.config.PublicKey = key
+	i.config.PublicKeyPinned = true
+	i.verifier.SetPublicKey(key)
+	i.keyPinned = true
+
+	i.log.Infof("Pinned companion signing key %s", fingerprint)
+	return nil
+}
+
+// checkPinnedKey fails if the companion now serves a key other than the
+// one pinned on first use, or one that a signed key rotation replaced it
+// with
+func (i *Installer) checkPinnedKey() error {
+	if !i.config.PublicKeyPinned || i.keyPinned || !i.config.VerifySigs || i.dryRun {
+		return nil
+	}
+
+	key, fingerprint, err := i.fetchCompanionKey()
+	if err != nil {
+		return err
+	}
+	if !i.verifier.Trusts(key) {
+		return fmt.Errorf("companion signing key changed to %s, which does not match the pinned key", fingerprint)
+	}
+	return nil
+}
+
+// fetchCompanionKey fetches the companion's signing key and returns it
+// with its fingerprint. Only TLS or loopback connections are used, so
+// the key cannot be swapped on the network.
+func (i *Installer) fetchCompanionKey() (string, string, error) {
+	u, err := url.Parse(i.config.CompanionURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid companion URL: %w", err)
+	}
+	if u.Scheme != "https" && !isLoopback(u.Hostname()) {
+		return "", "", fmt.Errorf("refusing to fetch the companion signing key over %s; use an https companion URL or configure public_key", u.Scheme)
+	}
+
+	key, err := i.companion.SigningKey(i.ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch companion signing key: %w", err)
+	}
+	fingerprint, err := verifier.KeyFingerprint(key)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid companion signing key: %w", err)
+	}
+	return key, fingerprint, nil
+}
+
+// isLoopback reports whether a host name refers to this machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
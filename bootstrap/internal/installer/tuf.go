@@ -0,0 +1,76 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// tufDir is where the trusted TUF metadata is kept, under the data
+// directory, and tufRootFile the latest trusted root in it
+const (
+	tufDir      = "tuf"
+	tufRootFile = tufDir + "/root.json"
+)
+
+// setupTUF loads the trusted TUF root, refreshes the repository metadata
+// and hands it to the downloader. The rotated root and the timestamp,
+// snapshot and targets metadata are persisted, so that the next run
+// starts from them rather than the configured initial root and rejects
+// metadata older than what this run trusted.
+func (i *Installer) setupTUF() error {
+	if !i.config.TUF.Enabled {
+		return nil
+	}
+
+	rootPath := filepath.Join(i.config.DataPath, tufRootFile)
+	data, err := os.ReadFile(rootPath)
+	if err != nil {
+		if i.config.TUF.Root == "" {
+			return fmt.Errorf("no trusted TUF root configured")
+		}
+		if data, err = os.ReadFile(i.config.TUF.Root); err != nil {
+			return fmt.Errorf("failed to read TUF root: %w", err)
+		}
+	}
+
+	repo, err := verifier.NewTUFRepository(data)
+	if err != nil {
+		return fmt.Errorf("failed to load TUF root: %w", err)
+	}
+
+	dir := filepath.Join(i.config.DataPath, tufDir)
+	for _, role := range verifier.TUFTrustedRoles {
+		data, err := os.ReadFile(filepath.Join(dir, role+".json"))
+		if err != nil {
+			continue
+		}
+		if err := repo.LoadTrusted(role, data); err != nil {
+			i.log.Errorf("Ignoring trusted TUF %s metadata: %v", role, err)
+		}
+	}
+
+	if err := i.downloader.UpdateTUF(i.ctx, repo); err != nil {
+		return err
+	}
+
+	// Kept outside the journal: verified metadata must survive a rollback
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create TUF directory: %w", err)
+	}
+	if err := os.WriteFile(rootPath, repo.Root(), 0644); err != nil {
+		return fmt.Errorf("failed to persist TUF root: %w", err)
+	}
+	for _, role := range verifier.TUFTrustedRoles {
+		if err := os.WriteFile(filepath.Join(dir, role+".json"), repo.Trusted(role), 0644); err != nil {
+			return fmt.Errorf("failed to persist TUF %s metadata: %w", role, err)
+		}
+	}
+
+	i.downloader.SetTUF(repo)
+	i.tuf = repo
+	i.log.Infof("TUF metadata verified (root version %d)", repo.RootVersion())
+	return nil
+}
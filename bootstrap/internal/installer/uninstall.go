@@ -0,0 +1,256 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezra/bootstrap/internal/logger"
+)
+
+// UninstallReport describes what an uninstall removed
+type UninstallReport struct {
+	StoppedServices []string `json:"stopped_services"`
+	RemovedUnits    []string `json:"removed_units"`
+	RemovedBinaries []string `json:"removed_binaries"`
+	PurgedPaths     []string `json:"purged_paths"`
+}
+
+// Uninstall removes Ezra from the system. When purge is set the data,
+// cache and backup directories and the secrets kept in the secret store
+// are deleted as well.
+func (i *Installer) Uninstall(purge bool) (*UninstallReport, error) {
+	i.log.Info("Starting uninstall...")
+
+	report := &UninstallReport{}
+
+	// Stop services
+	if err := i.stopServices(report); err != nil {
+		return report, fmt.Errorf("failed to stop services: %w", err)
+	}
+
+	// Remove system service
+	if err := i.removeSystemService(report); err != nil {
+		return report, fmt.Errorf("failed to remove system service: %w", err)
+	}
+
+	// Remove binaries
+	if err := i.removeBinaries(report); err != nil {
+		return report, fmt.Errorf("failed to remove binaries: %w", err)
+	}
+	if err := i.removeSysext(report); err != nil {
+		return report, fmt.Errorf("failed to remove system extension: %w", err)
+	}
+
+	// Purge data directories
+	if purge {
+		if err := i.purgeSecrets(); err != nil {
+			return report, err
+		}
+		if err := i.purgeDirectories(report); err != nil {
+			return report, fmt.Errorf("failed to purge directories: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+// stopServices stops the running Ezra services
+func (i *Installer) stopServices(report *UninstallReport) error {
+	i.log.Info("Stopping services...")
+
+	if registered, err := i.controlService("ezra-agent", "stop"); err != nil {
+		i.log.Errorf("Failed to stop ezra-agent: %v", err)
+	} else if registered {
+		report.StoppedServices = append(report.StoppedServices, "ezra-agent")
+	}
+
+	// Without a service manager the companion was started directly, so
+	// ask it to stop itself
+	if registered, err := i.controlService("ezra-companion", "stop"); err != nil {
+		i.log.Errorf("Failed to stop ezra-companion: %v", err)
+	} else if registered {
+		report.StoppedServices = append(report.StoppedServices, "ezra-companion")
+		return nil
+	}
+	companion := filepath.Join(i.config.InstallPath, "ezra-companion")
+	if _, err := os.Stat(companion); err == nil {
+		if err := exec.Command(companion, "stop").Run(); err != nil {
+			i.log.Errorf("Failed to stop ezra-companion: %v", err)
+		} else {
+			report.StoppedServices = append(report.StoppedServices, "ezra-companion")
+		}
+	}
+
+	return nil
+}
+
+// removeSystemService removes the service definitions of the companion
+// and the agent, and the update schedule
+func (i *Installer) removeSystemService(report *UninstallReport) error {
+	supervisor, err := i.supervisor()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"ezra-companion", "ezra-agent"} {
+		var err error
+		switch supervisor {
+		case supervisorSystemd:
+			err = i.removeSystemdService(name, report)
+		case supervisorOpenRC, supervisorRunit, supervisorSysV:
+			err = i.removeInitService(supervisor, name, report)
+		case supervisorLaunchd:
+			err = i.removeLaunchdService(name, report)
+		case supervisorSCM:
+			var removed bool
+			if removed, err = deleteWindowsService(name); err != nil {
+				err = fmt.Errorf("failed to remove service %s: %w", name, err)
+			} else if removed {
+				report.RemovedUnits = append(report.RemovedUnits, name)
+			}
+		case supervisorTask:
+			err = i.removeUserTask(name, report)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if supervisor == supervisorSCM {
+		if err := logger.RemoveEventSource(); err != nil {
+			i.log.Errorf("Failed to remove the event log source: %v", err)
+		}
+	}
+	return i.removeUpdateSchedule(supervisor, report)
+}
+
+func (i *Installer) removeSystemdService(name string, report *UninstallReport) error {
+	dir, err := i.systemdUnitDir()
+	if err != nil {
+		return err
+	}
+	unit := filepath.Join(dir, name+".service")
+	if _, err := os.Stat(unit); os.IsNotExist(err) {
+		return nil
+	}
+
+	if _, err := i.combinedOutput(i.systemctl("disable", name)); err != nil {
+		i.log.Errorf("Failed to disable %s: %v", name, err)
+	}
+
+	if err := i.removeAll(unit); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", unit, err)
+	}
+	report.RemovedUnits = append(report.RemovedUnits, unit)
+
+	if _, err := i.combinedOutput(i.systemctl("daemon-reload")); err != nil {
+		i.log.Errorf("Failed to reload systemd: %v", err)
+	}
+
+	return nil
+}
+
+// removeBinaries deletes the component executables from InstallPath
+func (i *Installer) removeBinaries(report *UninstallReport) error {
+	for _, component := range components {
+		path := filepath.Join(i.config.InstallPath, binaryName(component))
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := i.removeAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		report.RemovedBinaries = append(report.RemovedBinaries, path)
+	}
+
+	return i.removeSlots(report)
+}
+
+// purgeDirectories deletes the data, cache and backup directories. They
+// are removed as root when needed, so nothing is removed unless all of
+// them are Ezra's own, see checkPurgeable.
+func (i *Installer) purgeDirectories(report *UninstallReport) error {
+	i.log.Info("Purging data directories...")
+
+	// Cache and backup usually live under DataPath, so remove them first
+	// and only report the ones that actually existed
+	var dirs []string
+	for _, dir := range []string{i.config.CachePath, i.config.BackupPath, i.config.DataPath} {
+		if dir == "" {
+			continue
+		}
+		if err := i.checkPurgeable(dir); err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			continue
+		}
+		if err := i.removeAll(dir); err != nil {
+			return fmt.Errorf("failed to remove directory %s: %w", dir, err)
+		}
+		report.PurgedPaths = append(report.PurgedPaths, dir)
+	}
+
+	return nil
+}
+
+// checkPurgeable refuses to purge a directory that is not Ezra's own: a
+// relative path, a filesystem root, the home directory or a directory
+// holding it or the binaries, or a path no part of which is named after
+// Ezra. The path a link points to is checked as well.
+func (i *Installer) checkPurgeable(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("refusing to purge %s: not an absolute path", dir)
+	}
+
+	paths := []string{filepath.Clean(dir)}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil && resolved != paths[0] {
+		paths = append(paths, resolved)
+	}
+	var protected []string
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		protected = append(protected, home)
+	}
+	if i.config.InstallPath != "" {
+		protected = append(protected, i.config.InstallPath)
+	}
+
+	for _, path := range paths {
+		if filepath.Dir(path) == path {
+			return fmt.Errorf("refusing to purge %s: it is a filesystem root", dir)
+		}
+		for _, keep := range protected {
+			if within(keep, path) {
+				return fmt.Errorf("refusing to purge %s: it holds %s", dir, keep)
+			}
+		}
+		if !namedAfterEzra(path) {
+			return fmt.Errorf("refusing to purge %s: it is not an Ezra directory", dir)
+		}
+	}
+	return nil
+}
+
+// within reports whether path is dir or lies below it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// namedAfterEzra reports whether a part of path below its root is named
+// after Ezra, as all of its default directories are
+func namedAfterEzra(path string) bool {
+	rest := strings.TrimPrefix(path, filepath.VolumeName(path))
+	for _, part := range strings.Split(rest, string(filepath.Separator)) {
+		if strings.Contains(strings.ToLower(part), "ezra") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ezra/bootstrap/internal/config"
+)
+
+// newPurgeInstaller returns an installer whose directories are below a
+// temporary directory named after Ezra
+func newPurgeInstaller(t *testing.T) *Installer {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "ezra")
+	cfg := config.DefaultConfig()
+	cfg.InstallPath = filepath.Join(t.TempDir(), "bin")
+	cfg.DataPath = root
+	cfg.CachePath = filepath.Join(root, "cache")
+	cfg.BackupPath = filepath.Join(root, "backups")
+	for _, dir := range []string{cfg.InstallPath, cfg.CachePath, cfg.BackupPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Installer{config: cfg, log: testLogger{}}
+}
+
+func TestCheckPurgeable(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home", "user")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	inst := newPurgeInstaller(t)
+	unrelated := t.TempDir()
+	link := filepath.Join(t.TempDir(), "ezra-link")
+	if err := os.Symlink(unrelated, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{"data directory", inst.config.DataPath, ""},
+		{"legacy directory", filepath.Join(home, ".ezra"), ""},
+		{"relative", "ezra", "not an absolute path"},
+		{"root", string(filepath.Separator), ""},
+		{"home", home, "holds " + home},
+		{"above home", filepath.Dir(home), "holds " + home},
+		{"install path", inst.config.InstallPath, "holds " + inst.config.InstallPath},
+		{"above install path", filepath.Dir(inst.config.InstallPath), "holds " + inst.config.InstallPath},
+		{"unrelated", unrelated, "not an Ezra directory"},
+		{"link to an unrelated directory", link, "not an Ezra directory"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := inst.checkPurgeable(test.dir)
+			switch {
+			case test.name == "root":
+				// Not absolute on Windows, a filesystem root elsewhere
+				if err == nil {
+					t.Fatal("checkPurgeable accepted the filesystem root")
+				}
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("checkPurgeable: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("checkPurgeable = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPurgeDirectories(t *testing.T) {
+	inst := newPurgeInstaller(t)
+	// The backup directory was never made
+	if err := os.Remove(inst.config.BackupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	report := &UninstallReport{}
+	if err := inst.purgeDirectories(report); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(inst.config.DataPath); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", inst.config.DataPath, err)
+	}
+	want := []string{inst.config.CachePath, inst.config.DataPath}
+	if strings.Join(report.PurgedPaths, ",") != strings.Join(want, ",") {
+		t.Errorf("PurgedPaths = %v, want %v", report.PurgedPaths, want)
+	}
+}
+
+func TestPurgeDirectoriesRefuses(t *testing.T) {
+	inst := newPurgeInstaller(t)
+	unrelated := t.TempDir()
+	kept := filepath.Join(unrelated, "kept")
+	if err := os.WriteFile(kept, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	inst.config.BackupPath = unrelated
+
+	report := &UninstallReport{}
+	if err := inst.purgeDirectories(report); err == nil {
+		t.Fatal("purgeDirectories removed a directory that is not Ezra's")
+	}
+	// Nothing is removed when one of the directories is refused
+	for _, path := range []string{kept, inst.config.CachePath, inst.config.DataPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed: %v", path, err)
+		}
+	}
+	if len(report.PurgedPaths) != 0 {
+		t.Errorf("PurgedPaths = %v, want none", report.PurgedPaths)
+	}
+}
@@ -0,0 +1,485 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/internal/failure"
+	"github.com/ezra/bootstrap/pkg/delta"
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// installedVersionsFile records the component versions that are installed
+const installedVersionsFile = "installed.json"
+
+// errNoPatch means no usable patch is published for a component
+var errNoPatch = errors.New("no patch available")
+
+// UpgradeReport describes what an upgrade changed
+type UpgradeReport struct {
+	Upgraded  map[string]string `json:"upgraded"`
+	Unchanged map[string]string `json:"unchanged"`
+	// Provenance is the verified build provenance of each upgraded
+	// component
+	Provenance map[string]*verifier.Provenance `json:"provenance,omitempty"`
+	// RolledBack are the versions that were installed and rolled back
+	// because their services did not become ready
+	RolledBack map[string]string `json:"rolled_back,omitempty"`
+}
+
+// Upgrade upgrades an existing installation in place. Only components
+// whose version differs from the latest release are downloaded, and the
+// agent configuration and data directories are left untouched. An
+// upgrade whose restarted services do not become ready is rolled back.
+func (i *Installer) Upgrade() (*UpgradeReport, error) {
+	i.log.Info("Starting upgrade...")
+
+	if !i.isInstalled() {
+		return nil, fmt.Errorf("no existing installation found in %s", i.config.InstallPath)
+	}
+
+	manifest, err := i.fetchRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	installed := i.loadInstalledVersions()
+	report := &UpgradeReport{
+		Upgraded:   map[string]string{},
+		Unchanged:  map[string]string{},
+		Provenance: map[string]*verifier.Provenance{},
+	}
+
+	changed := i.outdatedComponents(manifest, installed)
+	for _, component := range i.components {
+		_, ok := manifest.Components[component]
+		if ok && i.installedComponent(component) && !contains(changed, component) {
+			report.Unchanged[component] = installed[component]
+		}
+	}
+	if len(changed) == 0 {
+		i.log.Info("All components are up to date")
+		return report, nil
+	}
+	for _, component := range changed {
+		i.componentLog(component).Infof("Upgrading %s: %s -> %s", component, versionOrUnknown(installed[component]), manifest.Components[component].Version)
+	}
+
+	// Stage all new binaries before touching the installed ones
+	staged := map[string]string{}
+	defer func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}()
+	for _, component := range changed {
+		path, err := i.stageComponent(component, installed[component], manifest.Components[component])
+		if err != nil {
+			return report, err
+		}
+		staged[component] = path
+
+		provenance, err := i.verifyProvenance(component, path, "")
+		if err != nil {
+			return report, failure.Wrap(failure.Verification, fmt.Errorf("provenance verification failed: %w", err))
+		}
+		if provenance != nil {
+			report.Provenance[component] = provenance
+		}
+	}
+
+	if err := i.checkSBOMs(changed, nil); err != nil {
+		return report, fmt.Errorf("SBOM check failed: %w", err)
+	}
+
+	upgraded := map[string]string{}
+	for component, version := range installed {
+		upgraded[component] = version
+	}
+	for _, component := range changed {
+		upgraded[component] = manifest.Components[component].Version
+	}
+	i.expectedVersions = upgraded
+
+	// Swap binaries into place, backing up the old ones, and restart the
+	// services on them. If a binary cannot be replaced or a restarted
+	// service does not become ready, the old binaries are restored and
+	// their services restarted, so the installation is never left with
+	// old and new binaries mixed.
+	err = i.transaction(func() error {
+		// Recorded first so that it is undone last, once the old
+		// binaries are back
+		restarted := false
+		i.journal.recordStep("restart services", func() error {
+			if !restarted {
+				return nil
+			}
+			if err := i.mergeSysext(); err != nil {
+				return err
+			}
+			return i.restartServices(changed)
+		})
+
+		for _, component := range changed {
+			if err := i.replaceBinary(component, installed[component], upgraded[component], staged[component]); err != nil {
+				return err
+			}
+			delete(staged, component)
+		}
+		if err := i.mergeSysext(); err != nil {
+			return fmt.Errorf("failed to refresh system extension: %w", err)
+		}
+		if err := i.saveInstalledVersions(upgraded); err != nil {
+			return fmt.Errorf("failed to record installed versions: %w", err)
+		}
+
+		// Restart the services whose binaries changed
+		restarted = true
+		if err := i.restartServices(changed); err != nil {
+			return fmt.Errorf("failed to restart services: %w", err)
+		}
+		if err := i.waitForServices(changed); err != nil {
+			report.RolledBack = map[string]string{}
+			for _, component := range changed {
+				report.RolledBack[component] = upgraded[component]
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, component := range changed {
+		report.Upgraded[component] = upgraded[component]
+	}
+	i.pruneSlots(changed)
+	i.pruneCache()
+
+	return report, nil
+}
+
+// outdatedComponents returns the installed components whose version
+// differs from the release manifest's. Components left out of the
+// install are not added by upgrades, and versions that are held or not
+// rolled out to the device are skipped.
+func (i *Installer) outdatedComponents(manifest *downloader.Manifest, installed map[string]string) []string {
+	var outdated []string
+	for _, component := range i.components {
+		latest, ok := manifest.Components[component]
+		if !ok || !i.installedComponent(component) || installed[component] == latest.Version {
+			continue
+		}
+		if !i.downloader.Offered(component, latest) {
+			i.log.Infof("Skipping %s %s, which is not rolled out to this device yet", component, latest.Version)
+			continue
+		}
+		if i.heldVersions[component] == latest.Version {
+			i.log.Infof("Skipping %s %s, which was rolled back", component, latest.Version)
+			continue
+		}
+		outdated = append(outdated, component)
+	}
+	return outdated
+}
+
+// installedComponent reports whether a component's binary is installed
+func (i *Installer) installedComponent(component string) bool {
+	_, err := os.Stat(filepath.Join(i.config.InstallPath, binaryName(component)))
+	return err == nil
+}
+
+// waitForServices waits for the services of the given components to
+// become ready
+func (i *Installer) waitForServices(changed []string) error {
+	for _, component := range changed {
+		var err error
+		switch component {
+		case "companion":
+			err = i.waitForCompanion()
+		case "agent":
+			err = i.waitForAgent()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchRelease sets up the signing keys and update metadata and fetches
+// the release manifest that components are downloaded against
+func (i *Installer) fetchRelease() (*downloader.Manifest, error) {
+	if err := i.pinCompanionKey(); err != nil {
+		return nil, fmt.Errorf("failed to pin companion key: %w", err)
+	}
+	if err := i.setupTrustedKeys(); err != nil {
+		return nil, fmt.Errorf("failed to update trusted keys: %w", err)
+	}
+	if err := i.checkPinnedKey(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := i.downloader.FetchManifest(i.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release manifest: %w", err)
+	}
+
+	if err := i.setupTUF(); err != nil {
+		return nil, failure.Wrap(failure.Verification, fmt.Errorf("failed to verify update metadata: %w", err))
+	}
+	return manifest, nil
+}
+
+// stageComponent downloads a component next to its installed binary so
+// that it can later be renamed into place atomically. A published patch
+// from the installed version is tried first, falling back to a full
+// download if it cannot be applied.
+func (i *Installer) stageComponent(component, current string, latest downloader.ComponentManifest) (string, error) {
+	path, err := i.stagingPath(component)
+	if err != nil {
+		return "", err
+	}
+
+	if err := i.stagePatch(component, current, latest, path); err != nil {
+		if !errors.Is(err, errNoPatch) {
+			i.componentLog(component).Errorf("Delta update of %s failed, downloading full binary: %v", component, err)
+		}
+		os.Remove(path)
+
+		// Verify while downloading so a bad binary never lands next to
+		// the installed one
+		if err := i.downloader.DownloadComponentVerified(i.ctx, component, path, i.reportedVerifier(component, i.streamVerifier(latest))); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+	}
+
+	if err := os.Chmod(path, 0755); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// stagingPath returns where a new binary of a component is staged, so
+// that it can be renamed into place: next to the installed binary, or to
+// the slots when binaries are installed into them, and in the cache when
+// only root can write there
+func (i *Installer) stagingPath(component string) (string, error) {
+	dir := i.config.InstallPath
+	if i.slotsEnabled() {
+		dir = filepath.Join(i.slotRoot(), component)
+	}
+	if i.needsElevation(filepath.Join(dir, binaryName(component))) {
+		dir = i.config.CachePath
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "."+binaryName(component)+".new"), nil
+}
+
+// stagePatch builds the new binary by patching the installed one. The
+// result must match the manifest checksum, so patches are only used when
+// one is published, and with TUF it must match the trusted target as a
+// full download would.
+func (i *Installer) stagePatch(component, current string, latest downloader.ComponentManifest, path string) error {
+	if current == "" || latest.SHA256 == "" {
+		return errNoPatch
+	}
+	// A cached full binary needs no download at all
+	if i.downloader.Cached(latest.SHA256) {
+		return errNoPatch
+	}
+	patch, ok := latest.PatchFrom(current)
+	if !ok {
+		return errNoPatch
+	}
+
+	// The patched binary is checked the way a full download would be
+	sv, maxSize := i.streamVerifier(latest), latest.Size
+	if i.tuf != nil {
+		target, err := i.tuf.Target(i.downloader.TargetFilename(component))
+		if err != nil {
+			return failure.Wrap(failure.Verification, err)
+		}
+		sv, maxSize = downloader.NewTargetVerifier(target), target.Length
+	}
+
+	i.log.Infof("Applying %s patch for %s %s -> %s", patch.Format, component, current, latest.Version)
+
+	old, err := os.ReadFile(filepath.Join(i.config.InstallPath, binaryName(component)))
+	if err != nil {
+		return fmt.Errorf("failed to read installed binary: %w", err)
+	}
+
+	patchFile := path + ".patch"
+	defer os.Remove(patchFile)
+	if err := i.downloader.DownloadPatch(i.ctx, patch, patchFile); err != nil {
+		return err
+	}
+	patchData, err := os.ReadFile(patchFile)
+	if err != nil {
+		return fmt.Errorf("failed to read patch: %w", err)
+	}
+
+	patched, err := delta.Apply(patch.Format, old, patchData, maxSize)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sv.Write(patched); err != nil {
+		return failure.Wrap(failure.Verification, fmt.Errorf("patched binary failed verification: %w", err))
+	}
+	if err := sv.Verify(); err != nil {
+		return failure.Wrap(failure.Verification, fmt.Errorf("patched binary failed verification: %w", err))
+	}
+
+	if err := os.WriteFile(path, patched, 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// restartServices restarts the services backed by the given components
+func (i *Installer) restartServices(changed []string) error {
+	for _, component := range changed {
+		switch component {
+		case "companion":
+			i.log.Info("Restarting companion server...")
+			registered, err := i.controlService("ezra-companion", "restart")
+			if err != nil {
+				return fmt.Errorf("failed to restart companion: %w", err)
+			}
+			if registered {
+				continue
+			}
+			companion := filepath.Join(i.config.InstallPath, binaryName(component))
+			if err := exec.Command(companion, "stop").Run(); err != nil {
+				i.log.Errorf("Failed to stop companion: %v", err)
+			}
+			if err := i.startCompanion(); err != nil {
+				return fmt.Errorf("failed to start companion: %w", err)
+			}
+		case "agent":
+			i.log.Info("Restarting agent...")
+			registered, err := i.controlService("ezra-agent", "restart")
+			if err != nil {
+				return fmt.Errorf("failed to restart agent: %w", err)
+			}
+			if registered {
+				continue
+			}
+			if err := i.startAgent(); err != nil {
+				return fmt.Errorf("failed to start agent: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isInstalled reports whether any component binary is present
+func (i *Installer) isInstalled() bool {
+	for _, component := range components {
+		if _, err := os.Stat(filepath.Join(i.config.InstallPath, binaryName(component))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadInstalledVersions reads the recorded component versions. A missing
+// or unreadable record yields an empty map so every component upgrades.
+func (i *Installer) loadInstalledVersions() map[string]string {
+	versions := map[string]string{}
+
+	data, err := os.ReadFile(filepath.Join(i.config.DataPath, installedVersionsFile))
+	if err != nil {
+		return versions
+	}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		i.log.Errorf("Ignoring unreadable %s: %v", installedVersionsFile, err)
+		return map[string]string{}
+	}
+
+	return versions
+}
+
+// saveInstalledVersions records the installed component versions
+func (i *Installer) saveInstalledVersions(versions map[string]string) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return i.writeFile(filepath.Join(i.config.DataPath, installedVersionsFile), data, 0644)
+}
+
+// releaseVersions returns the versions the release manifest gives the
+// selected components, or nil when it cannot be fetched
+func (i *Installer) releaseVersions() map[string]string {
+	manifest, err := i.downloader.FetchManifest(i.ctx)
+	if err != nil {
+		i.log.Errorf("Could not read the released versions: %v", err)
+		return nil
+	}
+
+	versions := map[string]string{}
+	for name, component := range manifest.Components {
+		if i.selected(name) {
+			versions[name] = component.Version
+		}
+	}
+	return versions
+}
+
+// recordInstalledVersions stores the versions of a fresh install. Failure
+// is not fatal; a later upgrade will simply refresh every component.
+func (i *Installer) recordInstalledVersions() {
+	if i.expectedVersions == nil {
+		return
+	}
+	if err := i.saveInstalledVersions(i.expectedVersions); err != nil {
+		i.log.Errorf("Could not record installed versions: %v", err)
+	}
+}
+
+// streamVerifier returns a verifier for the checksum and signature the
+// manifest publishes for a component, or nil if there is nothing to check
+func (i *Installer) streamVerifier(latest downloader.ComponentManifest) downloader.StreamVerifier {
+	signature := ""
+	if i.signaturesEnabled() {
+		signature = latest.Signature
+	}
+
+	if latest.SHA256 == "" && signature == "" {
+		return nil
+	}
+	return i.verifier.NewStream(latest.SHA256, signature)
+}
+
+// signaturesEnabled reports whether signatures are checked, which needs
+// a public key, OpenPGP keys or cosign keyless verification
+func (i *Installer) signaturesEnabled() bool {
+	if !i.config.VerifySigs {
+		return false
+	}
+	keys := i.config.PublicKey != "" || len(i.config.TrustedKeys) > 0
+	pgp := len(i.config.PGP.Keys) > 0 || i.config.PGP.Keyring != ""
+	return keys || pgp || i.config.SignatureType == verifier.SignatureCosign
+}
+
+func versionOrUnknown(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
@@ -0,0 +1,142 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// systemdSystemDir is where systemd units are installed for the system
+var systemdSystemDir = filepath.Dir(systemdServiceFile)
+
+// userTaskFolder is the Task Scheduler folder per-user tasks are kept in
+const userTaskFolder = `\Ezra\`
+
+// userMode reports whether Ezra is installed for the current user only,
+// with per-user services and nothing that needs root
+func (i *Installer) userMode() bool {
+	return i.installMode == installModeUser
+}
+
+// userSupervisor returns the per-user counterpart of a supervision
+// strategy. OpenRC, runit and SysV init have no per-user services, so
+// the processes are only started.
+func userSupervisor(supervisor string) string {
+	switch supervisor {
+	case supervisorSCM:
+		return supervisorTask
+	case supervisorOpenRC, supervisorRunit, supervisorSysV:
+		return supervisorDirect
+	default:
+		return supervisor
+	}
+}
+
+// systemdUnitDir returns the directory systemd units are installed in:
+// the user's unit directory in user mode
+func (i *Installer) systemdUnitDir() (string, error) {
+	if !i.userMode() {
+		return systemdSystemDir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the systemd user unit directory: %w", err)
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// systemctl returns a systemctl command for the service manager of the
+// install mode
+func (i *Installer) systemctl(args ...string) *exec.Cmd {
+	if i.userMode() {
+		args = append([]string{"--user"}, args...)
+	}
+	return exec.Command("systemctl", args...)
+}
+
+// enableUserUnit makes the user's service manager start a unit when the
+// user logs in
+func (i *Installer) enableUserUnit(name string) error {
+	if err := i.runServiceCommand("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := i.runServiceCommand("systemctl", "--user", "enable", name); err != nil {
+		return err
+	}
+	i.log.Infof("%s starts when you log in; run 'loginctl enable-linger' to start it at boot instead", name)
+	return nil
+}
+
+// userTaskName returns the Task Scheduler path of a per-user task
+func userTaskName(name string) string {
+	return userTaskFolder + name
+}
+
+// setupUserTask registers a scheduled task that starts a service when
+// the installing user logs on, which needs no elevation unlike a Windows
+// service
+func (i *Installer) setupUserTask(spec serviceSpec) error {
+	if i.dryRun {
+		i.plan.addService(spec.Name + " (" + supervisorTask + ")")
+	}
+	return i.runServiceCommand("schtasks", "/Create", "/F", "/TN", userTaskName(spec.Name),
+		"/SC", "ONLOGON", "/RL", "LIMITED", "/TR", windowsCommandLine(spec.Command))
+}
+
+// controlUserTask starts, stops or restarts the process of a per-user
+// task. It reports false if there is no such task.
+func (i *Installer) controlUserTask(name, action string) (bool, error) {
+	task := userTaskName(name)
+	if exec.Command("schtasks", "/Query", "/TN", task).Run() != nil {
+		return false, nil
+	}
+
+	var commands [][]string
+	switch action {
+	case "start":
+		commands = [][]string{{"/Run", "/TN", task}}
+	case "stop":
+		commands = [][]string{{"/End", "/TN", task}}
+	case "restart":
+		commands = [][]string{{"/End", "/TN", task}, {"/Run", "/TN", task}}
+	default:
+		return true, fmt.Errorf("unknown service action %q", action)
+	}
+
+	for _, args := range commands {
+		cmd := exec.Command("schtasks", args...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return true, fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return true, nil
+}
+
+// removeUserTask deletes a per-user task
+func (i *Installer) removeUserTask(name string, report *UninstallReport) error {
+	task := userTaskName(name)
+	if exec.Command("schtasks", "/Query", "/TN", task).Run() != nil {
+		return nil
+	}
+	cmd := exec.Command("schtasks", "/Delete", "/F", "/TN", task)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove task %s: %w: %s", task, err, strings.TrimSpace(string(out)))
+	}
+	report.RemovedUnits = append(report.RemovedUnits, task)
+	return nil
+}
+
+// windowsCommandLine joins arguments into a Windows command line,
+// quoting those with spaces
+func windowsCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"") {
+			arg = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+		}
+		quoted[n] = arg
+	}
+	return strings.Join(quoted, " ")
+}
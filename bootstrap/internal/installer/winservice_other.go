@@ -0,0 +1,27 @@
+//go:build !windows
+
+package installer
+
+import "fmt"
+
+// setupWindowsService is only available on Windows
+func (i *Installer) setupWindowsService(spec serviceSpec) error {
+	return fmt.Errorf("Windows services can only be registered on Windows")
+}
+
+// controlWindowsService reports every service as unregistered outside
+// Windows
+func controlWindowsService(name, action string) (bool, error) {
+	return false, nil
+}
+
+// windowsServiceState reports every service as unregistered outside
+// Windows
+func windowsServiceState(name string) (string, error) {
+	return serviceNotInstalled, nil
+}
+
+// deleteWindowsService has nothing to delete outside Windows
+func deleteWindowsService(name string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,222 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsServiceRecovery restarts a failed service after 5 seconds, then
+// 30 seconds, then every minute
+var windowsServiceRecovery = []mgr.RecoveryAction{
+	{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+	{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	{Type: mgr.ServiceRestart, Delay: time.Minute},
+}
+
+// windowsServiceResetPeriod is the number of seconds without failures
+// after which the recovery actions start over
+const windowsServiceResetPeriod = 24 * 60 * 60
+
+// windowsServiceStopTimeout bounds the wait for a service to stop
+const windowsServiceStopTimeout = 30 * time.Second
+
+// setupWindowsService registers a service with the Service Control
+// Manager. It starts automatically, delayed until the system has booted,
+// runs as the service account, or LocalSystem if none was set up, and is
+// restarted when it fails. An existing service of the same name is
+// updated.
+func (i *Installer) setupWindowsService(spec serviceSpec) error {
+	if i.dryRun {
+		i.plan.addService(spec.Name + " (" + supervisorSCM + ")")
+		return nil
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(spec.Name)
+	if err == nil {
+		config, err := s.Config()
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("failed to read service %s: %w", spec.Name, err)
+		}
+		config.BinaryPathName = windows.ComposeCommandLine(spec.Command)
+		config.DisplayName = spec.Description
+		config.Description = spec.Description
+		config.StartType = mgr.StartAutomatic
+		config.DelayedAutoStart = true
+		if i.servicePassword != "" {
+			config.ServiceStartName = `.\` + i.serviceUser()
+			config.Password = i.servicePassword
+		}
+		if err := s.UpdateConfig(config); err != nil {
+			s.Close()
+			return fmt.Errorf("failed to update service %s: %w", spec.Name, err)
+		}
+	} else {
+		config := mgr.Config{
+			DisplayName:      spec.Description,
+			Description:      spec.Description,
+			StartType:        mgr.StartAutomatic,
+			DelayedAutoStart: true,
+		}
+		if i.servicePassword != "" {
+			config.ServiceStartName = `.\` + i.serviceUser()
+			config.Password = i.servicePassword
+		}
+		s, err = m.CreateService(spec.Name, spec.Command[0], config, spec.Command[1:]...)
+		if err != nil {
+			return fmt.Errorf("failed to create service %s: %w", spec.Name, err)
+		}
+		if i.journal != nil {
+			i.journal.recordCreateService(spec.Name)
+		}
+	}
+	defer s.Close()
+
+	if err := s.SetRecoveryActions(windowsServiceRecovery, windowsServiceResetPeriod); err != nil {
+		return fmt.Errorf("failed to set recovery actions of %s: %w", spec.Name, err)
+	}
+	// Also restart the service when it stops with an error rather than
+	// crashing
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("failed to set recovery actions of %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// controlWindowsService starts, stops or restarts a Windows service. It
+// reports false if the service is not registered.
+func controlWindowsService(name, action string) (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+
+	switch action {
+	case "start":
+		return true, startWindowsService(s)
+	case "stop":
+		return true, stopWindowsService(s)
+	case "restart":
+		if err := stopWindowsService(s); err != nil {
+			return true, err
+		}
+		return true, startWindowsService(s)
+	default:
+		return true, fmt.Errorf("unknown service action %q", action)
+	}
+}
+
+// windowsServiceState returns the state of a Windows service, or
+// "not-installed" if it is not registered
+func windowsServiceState(name string) (string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return serviceNotInstalled, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	status, err := s.Query()
+	if err != nil {
+		return "", fmt.Errorf("failed to query service %s: %w", name, err)
+	}
+	switch status.State {
+	case svc.Running:
+		return serviceRunning, nil
+	case svc.Stopped:
+		return serviceStopped, nil
+	case svc.StartPending, svc.ContinuePending:
+		return serviceStarting, nil
+	case svc.StopPending, svc.PausePending, svc.Paused:
+		return serviceStopping, nil
+	default:
+		return serviceUnknown, nil
+	}
+}
+
+// deleteWindowsService stops and unregisters a Windows service. It
+// reports false if the service is not registered.
+func deleteWindowsService(name string) (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+
+	if err := stopWindowsService(s); err != nil {
+		return true, err
+	}
+	if err := s.Delete(); err != nil && !errors.Is(err, windows.ERROR_SERVICE_MARKED_FOR_DELETE) {
+		return true, err
+	}
+	return true, nil
+}
+
+// startWindowsService starts a service unless it is already running
+func startWindowsService(s *mgr.Service) error {
+	if err := s.Start(); err != nil && !errors.Is(err, windows.ERROR_SERVICE_ALREADY_RUNNING) {
+		return fmt.Errorf("failed to start service %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// stopWindowsService stops a service and waits until it has stopped
+func stopWindowsService(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", s.Name, err)
+	}
+
+	deadline := time.Now().Add(windowsServiceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within %s", s.Name, windowsServiceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
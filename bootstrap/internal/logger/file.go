@@ -0,0 +1,222 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of FileOptions
+const (
+	defaultMaxSize    = 10 << 20
+	defaultMaxBackups = 5
+	defaultMaxAge     = 30 * 24 * time.Hour
+)
+
+// backupTimeFormat names rotated files after when they were rotated, e.g.
+// bootstrap-2024-05-01T10-30-00.000.log
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileOptions configures a log file. Zero values use the defaults.
+type FileOptions struct {
+	Path string
+	// MaxSize is the size in bytes the file is rotated at; zero allows
+	// 10 MiB
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept; zero keeps 5
+	MaxBackups int
+	// MaxAge is how long rotated files are kept; zero keeps them for 30
+	// days
+	MaxAge time.Duration
+}
+
+// AddFile writes the log to a file as well as the console, in the same
+// format without colors. Call it after SetFormat.
+func (l *Logger) AddFile(opts FileOptions) error {
+	file, err := OpenRotatingFile(opts)
+	if err != nil {
+		return err
+	}
+
+	formatter := l.Logger.Formatter
+	if _, ok := formatter.(*logrus.TextFormatter); ok {
+		formatter = &logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+			DisableColors:   true,
+		}
+	}
+	l.Logger.AddHook(&fileHook{file: file, formatter: formatter})
+	return nil
+}
+
+// fileHook writes log entries to a file
+type fileHook struct {
+	file      *RotatingFile
+	formatter logrus.Formatter
+}
+
+func (h *fileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *fileHook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(line)
+	return err
+}
+
+// RotatingFile is a log file that is rotated when it grows past a size.
+// Rotated files are named after when they were rotated and removed once
+// there are too many or they are too old.
+type RotatingFile struct {
+	opts FileOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens a log file for appending, creating it and its
+// directory if needed
+func OpenRotatingFile(opts FileOptions) (*RotatingFile, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = defaultMaxBackups
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultMaxAge
+	}
+
+	f := &RotatingFile{opts: opts}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	f.prune()
+	return f, nil
+}
+
+// Write appends to the file, rotating it first if it would grow past its
+// maximum size
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file for appending. Logs can tell more than everyone on
+// the machine should read.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the file aside and starts a new one
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if err := os.Rename(f.opts.Path, f.backupPath(time.Now())); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// backupPath is the name of the file rotated at t
+func (f *RotatingFile) backupPath(t time.Time) string {
+	ext := filepath.Ext(f.opts.Path)
+	return strings.TrimSuffix(f.opts.Path, ext) + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// prune removes the rotated files beyond MaxBackups and those older than
+// MaxAge
+func (f *RotatingFile) prune() {
+	ext := filepath.Ext(f.opts.Path)
+	prefix := strings.TrimSuffix(filepath.Base(f.opts.Path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.opts.Path))
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path    string
+		rotated time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		rotated, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(filepath.Dir(f.opts.Path), name), rotated: rotated})
+	}
+
+	// Newest first
+	sort.Slice(backups, func(a, b int) bool {
+		return backups[a].rotated.After(backups[b].rotated)
+	})
+	cutoff := time.Now().Add(-f.opts.MaxAge)
+	for n, backup := range backups {
+		if n >= f.opts.MaxBackups || backup.rotated.Before(cutoff) {
+			os.Remove(backup.path)
+		}
+	}
+}
@@ -1,15 +1,37 @@
 package logger
 
 import (
+	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Names of the structured fields of log entries. Provisioning pipelines
+// and log aggregators parse them, so they must not change.
+const (
+	FieldComponent  = "component"
+	FieldPhase      = "phase"
+	FieldDeviceID   = "device_id"
+	FieldDurationMS = "duration_ms"
+	FieldSessionID  = "session_id"
 )
 
 // Logger wraps logrus.Logger with additional functionality
 type Logger struct {
 	*logrus.Logger
+	fields *fieldsHook
 }
 
 // New creates a new logger instance
@@ -32,7 +54,10 @@ func New(verbose bool) *Logger {
 		log.SetLevel(logrus.InfoLevel)
 	}
 	
-	return &Logger{log}
+	// Secrets are removed before any other hook sees an entry
+	log.AddHook(redactHook{})
+
+	return &Logger{Logger: log, fields: &fieldsHook{fields: logrus.Fields{}}}
 }
 
 // SetLevel sets the log level from string
@@ -50,3 +75,91 @@ func (l *Logger) SetLevel(level string) {
 		l.Logger.SetLevel(logrus.InfoLevel)
 	}
 }
+
+// OnExit runs fn before a fatal error exits the program, or Exit
+func (l *Logger) OnExit(fn func()) {
+	logrus.RegisterExitHandler(fn)
+}
+
+// Exit runs the functions registered with OnExit and exits with code
+func Exit(code int) {
+	logrus.Exit(code)
+}
+
+// Exitf logs a message at fatal level like Fatalf, but exits with code
+func (l *Logger) Exitf(code int, format string, args ...interface{}) {
+	l.Logf(logrus.FatalLevel, format, args...)
+	Exit(code)
+}
+
+// SetFormat switches the output between text for people and json, one
+// JSON object per line with the time, level and msg of each entry and
+// its structured fields
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case FormatText, "":
+		return nil
+	case FormatJSON:
+		l.Logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+		// Text output stays as it was, without the fields of the run
+		l.Logger.AddHook(l.fields)
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q: use text or json", format)
+	}
+}
+
+// SetField attaches a field to every entry logged from now on in json
+// format, or removes it when value is nil
+func (l *Logger) SetField(key string, value interface{}) {
+	l.fields.mu.Lock()
+	defer l.fields.mu.Unlock()
+	if value == nil {
+		delete(l.fields.fields, key)
+		return
+	}
+	l.fields.fields[key] = value
+}
+
+// fieldsHook adds the fields set with SetField to log entries. Fields
+// given to the entry itself win.
+type fieldsHook struct {
+	mu     sync.Mutex
+	fields logrus.Fields
+}
+
+func (h *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// redactHook removes secrets from the message and fields of entries, see
+// redact.String
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = redact.String(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = redact.String(value)
+		case error:
+			entry.Data[key] = redact.String(value.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of ShipOptions
+const (
+	defaultShipBatchSize     = 100
+	defaultShipFlushInterval = 5 * time.Second
+	defaultShipBufferSize    = 10000
+)
+
+// maxShipBackoff bounds the wait after failed sends
+const maxShipBackoff = 5 * time.Minute
+
+// shipStopTimeout bounds the sending of what is left when shipping stops
+const shipStopTimeout = 10 * time.Second
+
+// ShippedEntry is a log entry sent to a log collector
+type ShippedEntry struct {
+	Time    time.Time     `json:"time"`
+	Level   string        `json:"level"`
+	Message string        `json:"message"`
+	Fields  logrus.Fields `json:"fields,omitempty"`
+}
+
+// ShipOptions configures log shipping. Zero values use the defaults.
+type ShipOptions struct {
+	// Send delivers a batch of entries. A batch that fails is sent again
+	// later.
+	Send func(ctx context.Context, entries []ShippedEntry) error
+	// BatchSize is how many entries are sent at most at once; zero sends
+	// 100
+	BatchSize int
+	// FlushInterval is how often entries are sent, or sooner when a
+	// batch is full; zero sends every 5 seconds
+	FlushInterval time.Duration
+	// BufferSize is how many entries are kept while they cannot be sent;
+	// zero keeps 10000. Beyond it the oldest are dropped so that logging
+	// never waits for the collector.
+	BufferSize int
+	// SpoolPath is where the entries still unsent when shipping stops
+	// are kept for the next run to send first; empty drops them
+	SpoolPath string
+}
+
+// Ship sends what is logged from now on to a log collector in batches,
+// in the background. The returned function sends what is left, keeps
+// what cannot be sent in the spool file and stops.
+func (l *Logger) Ship(opts ShipOptions) (stop func()) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultShipBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultShipFlushInterval
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultShipBufferSize
+	}
+
+	s := &shipper{
+		opts:     opts,
+		run:      l.fields,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	for _, entry := range readSpool(opts.SpoolPath) {
+		s.add(entry)
+	}
+	l.Logger.AddHook(s)
+	go s.loop()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(s.done)
+			<-s.finished
+		})
+	}
+}
+
+// shipper buffers log entries and sends them in batches
+type shipper struct {
+	opts ShipOptions
+	run  *fieldsHook
+
+	mu      sync.Mutex
+	buffer  []bufferedEntry
+	next    uint64
+	dropped int
+
+	wake     chan struct{}
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// bufferedEntry is an entry waiting to be sent, numbered so that a batch
+// can be removed once sent even if older entries were dropped meanwhile
+type bufferedEntry struct {
+	seq   uint64
+	entry ShippedEntry
+}
+
+func (s *shipper) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *shipper) Fire(entry *logrus.Entry) error {
+	_, fields := entryFields(entry, s.run)
+	s.add(ShippedEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Fields:  fields,
+	})
+	return nil
+}
+
+// add buffers an entry, dropping the oldest when the buffer is full, and
+// wakes the sender once a batch is full
+func (s *shipper) add(entry ShippedEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buffer) >= s.opts.BufferSize {
+		s.buffer = s.buffer[1:]
+		s.dropped++
+	}
+	s.next++
+	s.buffer = append(s.buffer, bufferedEntry{seq: s.next, entry: entry})
+	if len(s.buffer) >= s.opts.BatchSize {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// loop sends the buffered entries every flush interval or when a batch
+// is full, backing off while sending fails
+func (s *shipper) loop() {
+	defer close(s.finished)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	var failures int
+	var retryAt time.Time
+	for {
+		select {
+		case <-s.done:
+			ctx, cancel := context.WithTimeout(context.Background(), shipStopTimeout)
+			s.flush(ctx)
+			cancel()
+			s.spool()
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if time.Now().Before(retryAt) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shipStopTimeout)
+		err := s.flush(ctx)
+		cancel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		backoff := s.opts.FlushInterval << min(failures, 10)
+		retryAt = time.Now().Add(min(backoff, maxShipBackoff))
+	}
+}
+
+// flush sends the buffered entries batch by batch until the buffer is
+// empty or a send fails
+func (s *shipper) flush(ctx context.Context) error {
+	for {
+		batch, last, dropped := s.batch()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.opts.Send(ctx, batch); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.dropped -= dropped
+		n := 0
+		for n < len(s.buffer) && s.buffer[n].seq <= last {
+			n++
+		}
+		s.buffer = s.buffer[n:]
+		s.mu.Unlock()
+	}
+}
+
+// batch returns the next entries to send and the number of the last, led
+// by a note of how many entries were dropped if any were
+func (s *shipper) batch() ([]ShippedEntry, uint64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch []ShippedEntry
+	dropped := s.dropped
+	if dropped > 0 {
+		batch = append(batch, droppedNote(dropped))
+	}
+	var last uint64
+	for _, buffered := range s.buffer {
+		if len(batch) >= s.opts.BatchSize {
+			break
+		}
+		batch = append(batch, buffered.entry)
+		last = buffered.seq
+	}
+	return batch, last, dropped
+}
+
+// droppedNote is the entry that tells the collector entries were dropped
+func droppedNote(dropped int) ShippedEntry {
+	return ShippedEntry{
+		Time:    time.Now(),
+		Level:   logrus.WarnLevel.String(),
+		Message: fmt.Sprintf("%d log entries were dropped while they could not be sent", dropped),
+	}
+}
+
+// spool keeps the entries still unsent for the next run, replacing the
+// spool file it was started with
+func (s *shipper) spool() {
+	if s.opts.SpoolPath == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buffer) == 0 && s.dropped == 0 {
+		os.Remove(s.opts.SpoolPath)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.opts.SpoolPath), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(s.opts.SpoolPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	if s.dropped > 0 {
+		enc.Encode(droppedNote(s.dropped))
+	}
+	for _, buffered := range s.buffer {
+		enc.Encode(buffered.entry)
+	}
+	w.Flush()
+}
+
+// readSpool reads the entries a previous run could not send. The file is
+// replaced when shipping stops, so a run that crashes sends them again
+// rather than losing them.
+func readSpool(path string) []ShippedEntry {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var entries []ShippedEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry ShippedEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Log sinks, the system logs the log can be copied to
+const (
+	SinkAuto     = "auto"
+	SinkNone     = "none"
+	SinkJournald = "journald"
+	SinkSyslog   = "syslog"
+	SinkEventLog = "eventlog"
+)
+
+// EventSource is the name the bootstrap logs under in the system logs:
+// the syslog tag, the journald SYSLOG_IDENTIFIER and the Windows Event
+// Log source
+const EventSource = "ezra-bootstrap"
+
+// AddSink copies the log to a system log that operators monitor: the
+// journal, syslog or the Windows Event Log. Auto picks the one of the
+// platform: the Event Log on Windows, the journal on systemd systems
+// unless the output already goes there, syslog elsewhere, or none.
+func (l *Logger) AddSink(sink string) error {
+	if sink == "" || sink == SinkAuto {
+		sink = defaultSink()
+	}
+	if sink == SinkNone {
+		return nil
+	}
+
+	hook, err := newSinkHook(sink, l.fields)
+	if err != nil {
+		return err
+	}
+	l.Logger.AddHook(hook)
+	return nil
+}
+
+// journalStreamSet reports whether the output already goes to the
+// journal: systemd sets JOURNAL_STREAM for the services it connects to
+// it
+func journalStreamSet() bool {
+	return os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// unsupportedSink is the error of a sink the platform does not have
+func unsupportedSink(sink string) error {
+	switch sink {
+	case SinkJournald, SinkSyslog, SinkEventLog:
+		return fmt.Errorf("log sink %s is not available on this platform", sink)
+	default:
+		return fmt.Errorf("unknown log sink %q: use auto, journald, syslog, eventlog or none", sink)
+	}
+}
+
+// entryFields returns the fields of an entry with the fields of the run
+// set with SetField, sorted by name
+func entryFields(entry *logrus.Entry, run *fieldsHook) ([]string, logrus.Fields) {
+	fields := logrus.Fields{}
+	run.mu.Lock()
+	for key, value := range run.fields {
+		fields[key] = value
+	}
+	run.mu.Unlock()
+	for key, value := range entry.Data {
+		fields[key] = value
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, fields
+}
+
+// messageWithFields appends the fields of an entry to its message as
+// key=value pairs, for the sinks without structured fields
+func messageWithFields(entry *logrus.Entry, run *fieldsHook) string {
+	keys, fields := entryFields(entry, run)
+	var b strings.Builder
+	b.WriteString(entry.Message)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, fields[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,167 @@
+//go:build !windows
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journalSocket is where journald takes entries in its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// syslogSockets are the local syslog sockets log/syslog connects to
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// defaultSink is the journal on systemd systems, unless the output goes
+// there already, and syslog where a syslog daemon listens
+func defaultSink() string {
+	if _, err := os.Stat(journalSocket); err == nil {
+		if journalStreamSet() {
+			return SinkNone
+		}
+		return SinkJournald
+	}
+	for _, socket := range syslogSockets {
+		if _, err := os.Stat(socket); err == nil {
+			return SinkSyslog
+		}
+	}
+	return SinkNone
+}
+
+// newSinkHook connects to a system log
+func newSinkHook(sink string, run *fieldsHook) (logrus.Hook, error) {
+	switch sink {
+	case SinkJournald:
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to journald: %w", err)
+		}
+		return &journaldHook{conn: conn, run: run}, nil
+	case SinkSyslog:
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, EventSource)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		return &syslogHook{writer: writer, run: run}, nil
+	default:
+		return nil, unsupportedSink(sink)
+	}
+}
+
+// journaldHook sends entries to journald with their fields as journal
+// fields, e.g. COMPONENT=agent, so that they can be filtered with
+// journalctl
+type journaldHook struct {
+	conn *net.UnixConn
+	run  *fieldsHook
+}
+
+func (h *journaldHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *journaldHook) Fire(entry *logrus.Entry) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(journalPriority(entry.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", EventSource)
+	keys, fields := entryFields(entry, h.run)
+	for _, key := range keys {
+		if name := journalFieldName(key); name != "" {
+			writeJournalField(&b, name, fmt.Sprint(fields[key]))
+		}
+	}
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+// journalPriority maps a level to a syslog priority
+func journalPriority(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// journalFieldName makes a field name a valid journal field name:
+// uppercase letters, digits and underscores, not starting with an
+// underscore, which journald reserves, or a digit
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return strings.TrimLeft(name, "_0123456789")
+}
+
+// writeJournalField writes a field in the native journal protocol. Values
+// spanning lines are sent with their length.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// syslogHook sends entries to syslog with their fields appended
+type syslogHook struct {
+	writer *syslog.Writer
+	run    *fieldsHook
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	message := messageWithFields(entry, h.run)
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.writer.Crit(message)
+	case logrus.ErrorLevel:
+		return h.writer.Err(message)
+	case logrus.WarnLevel:
+		return h.writer.Warning(message)
+	case logrus.InfoLevel:
+		return h.writer.Info(message)
+	default:
+		return h.writer.Debug(message)
+	}
+}
+
+// RegisterEventSource registers the bootstrap with the Windows Event Log.
+// Other platforms have nothing to register.
+func RegisterEventSource() error {
+	return nil
+}
+
+// RemoveEventSource removes the registration of RegisterEventSource
+func RemoveEventSource() error {
+	return nil
+}
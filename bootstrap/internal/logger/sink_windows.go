@@ -0,0 +1,82 @@
+//go:build windows
+
+package logger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventSourceKey is the registry key of the event sources of the
+// Application log
+const eventSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application`
+
+// eventID is the ID of every event. EventCreate.exe, the message file
+// the source is registered with, takes IDs from 1 to 1000.
+const eventID = 1
+
+// defaultSink is the Event Log
+func defaultSink() string {
+	return SinkEventLog
+}
+
+// newSinkHook opens the Event Log. Events of a source that was not
+// registered are written too, but shown without their message text.
+func newSinkHook(sink string, run *fieldsHook) (logrus.Hook, error) {
+	if sink != SinkEventLog {
+		return nil, unsupportedSink(sink)
+	}
+	log, err := eventlog.Open(EventSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the event log: %w", err)
+	}
+	return &eventLogHook{log: log, run: run}, nil
+}
+
+// eventLogHook writes entries to the Application log with their fields
+// appended
+type eventLogHook struct {
+	log *eventlog.Log
+	run *fieldsHook
+}
+
+func (h *eventLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *eventLogHook) Fire(entry *logrus.Entry) error {
+	message := messageWithFields(entry, h.run)
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return h.log.Error(eventID, message)
+	case logrus.WarnLevel:
+		return h.log.Warning(eventID, message)
+	default:
+		return h.log.Info(eventID, message)
+	}
+}
+
+// RegisterEventSource registers the bootstrap as a source of the
+// Application log, so that the Event Viewer shows its messages. It needs
+// administrator rights and does nothing if the source is registered.
+func RegisterEventSource() error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+EventSource, registry.QUERY_VALUE)
+	if err == nil {
+		key.Close()
+		return nil
+	}
+	return eventlog.InstallAsEventCreate(EventSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+}
+
+// RemoveEventSource removes the registration of RegisterEventSource
+func RemoveEventSource() error {
+	err := eventlog.Remove(EventSource)
+	if errors.Is(err, registry.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,312 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported archive extensions
+const (
+	ExtTarZstd = ".tar.zst"
+	ExtTarGz   = ".tar.gz"
+	ExtTgz     = ".tgz"
+	ExtZip     = ".zip"
+)
+
+// errUnsafePath is returned for entries that would be written outside
+// the extraction directory
+var errUnsafePath = errors.New("entry escapes the extraction directory")
+
+// Extract unpacks a .tar.zst, .tar.gz, .tgz or .zip archive into dir,
+// choosing the format by the file extension. Absolute paths, entries
+// climbing out of dir, also through links extracted earlier, and links
+// pointing outside dir are rejected, and file permissions are preserved
+// without setuid, setgid or sticky bits.
+func Extract(path, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// Entries are checked against the real directory, as the links they
+	// resolve through are
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ExtZip):
+		return extractZip(path, dir)
+	case strings.HasSuffix(lower, ExtTarZstd):
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read zstd stream: %w", err)
+		}
+		defer decoder.Close()
+		return extractTar(decoder, dir)
+	case strings.HasSuffix(lower, ExtTarGz), strings.HasSuffix(lower, ExtTgz):
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		decoder, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		defer decoder.Close()
+		return extractTar(decoder, dir)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", filepath.Base(path))
+	}
+}
+
+// extractTar unpacks a tar stream into dir
+func extractTar(r io.Reader, dir string) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target, err := safeJoin(dir, header.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, dirMode(mode))
+		case tar.TypeReg:
+			err = writeFile(target, reader, mode)
+		case tar.TypeSymlink:
+			err = writeSymlink(dir, target, header.Linkname)
+		case tar.TypeLink:
+			var source string
+			if source, err = safeJoin(dir, header.Linkname); err == nil {
+				err = writeHardlink(source, target)
+			}
+		default:
+			// Devices, FIFOs and the like have no place in a bundle
+			err = fmt.Errorf("unsupported entry type %q", header.Typeflag)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
+
+// extractZip unpacks a zip file into dir
+func extractZip(path, dir string) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer reader.Close()
+
+	for _, entry := range reader.File {
+		if err := extractZipEntry(entry, dir); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// extractZipEntry unpacks a single zip entry into dir
+func extractZipEntry(entry *zip.File, dir string) error {
+	target, err := safeJoin(dir, entry.Name)
+	if err != nil {
+		return err
+	}
+
+	mode := entry.Mode()
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, dirMode(mode))
+	case mode&fs.ModeSymlink != 0:
+		link, err := readZipEntry(entry)
+		if err != nil {
+			return err
+		}
+		return writeSymlink(dir, target, link)
+	case mode.IsRegular():
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeFile(target, rc, mode)
+	default:
+		return fmt.Errorf("unsupported entry mode %s", mode)
+	}
+}
+
+// readZipEntry returns the contents of a small zip entry such as a
+// symlink target
+func readZipEntry(entry *zip.File) (string, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, 4096))
+	return string(data), err
+}
+
+// maxLinks bounds the symlinks followed resolving one path, against
+// loops
+const maxLinks = 40
+
+// safeJoin resolves an entry name inside dir, the real path of the
+// extraction directory, rejecting names that would escape it. The
+// directories of the entry are resolved through the symlinks extracted
+// so far, so the returned path has a real parent inside dir.
+func safeJoin(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, string(filepath.Separator)) {
+		return "", errUnsafePath
+	}
+
+	// Names are resolved as written, not cleaned, as "link/.." leaves
+	// the directory link points to
+	name = strings.TrimRight(name, string(filepath.Separator))
+	parentName, base := filepath.Split(name)
+	if base == ".." || !inside(dir, filepath.Join(dir, name)) {
+		return "", errUnsafePath
+	}
+	parent, err := resolveIn(dir, dir, parentName, 0)
+	if err != nil {
+		return "", err
+	}
+	if base == "" || base == "." {
+		return parent, nil
+	}
+	return filepath.Join(parent, base), nil
+}
+
+// resolveIn resolves the relative path name from the directory from,
+// following the symlinks on the way, and fails if any step leaves dir.
+// Components that do not exist yet are kept as they are.
+func resolveIn(dir, from, name string, links int) (string, error) {
+	current := from
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		switch part {
+		case ".", "":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			if !inside(dir, current) {
+				return "", errUnsafePath
+			}
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) || filepath.VolumeName(link) != "" || links >= maxLinks {
+			return "", errUnsafePath
+		}
+		if current, err = resolveIn(dir, current, link, links+1); err != nil {
+			return "", err
+		}
+	}
+	return current, nil
+}
+
+// inside reports whether path is dir or within it
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeFile writes an extracted file with the permissions from the
+// archive
+func writeFile(target string, r io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Never write through a symlink placed by an earlier entry
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return errUnsafePath
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// The umask may have masked bits from the archive
+	return os.Chmod(target, mode.Perm())
+}
+
+// writeSymlink creates a symlink whose target must stay inside dir. The
+// target is resolved from the real directory of the link, through the
+// symlinks extracted so far.
+func writeSymlink(dir, target, link string) error {
+	if filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+		return errUnsafePath
+	}
+	if _, err := resolveIn(dir, filepath.Dir(target), filepath.FromSlash(link), 0); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Symlink(link, target)
+}
+
+// writeHardlink links target to an already extracted file
+func writeHardlink(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Link(source, target)
+}
+
+// dirMode returns the permissions for an extracted directory, keeping it
+// traversable by its owner
+func dirMode(mode fs.FileMode) fs.FileMode {
+	return mode.Perm() | 0700
+}
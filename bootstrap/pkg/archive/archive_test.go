@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry of a test archive: a regular file with data, or
+// a symlink to link
+type tarEntry struct {
+	name string
+	link string
+	data string
+}
+
+// writeTarGz writes a .tar.gz archive of entries to a temporary file
+func writeTarGz(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.data))}
+		if entry.link != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.link}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"parent directory", []tarEntry{{name: "../pwned.txt", data: "x"}}},
+		{"absolute link", []tarEntry{{name: "evil", link: "/"}, {name: "evil/pwned.txt", data: "x"}}},
+		{"link climbing out", []tarEntry{{name: "evil", link: "../"}, {name: "evil/pwned.txt", data: "x"}}},
+		{"link through links", []tarEntry{
+			{name: "s", link: "."},
+			{name: "s/s/s/evil", link: "../../../"},
+			{name: "evil/pwned.txt", data: "x"},
+		}},
+		{"link dotdot after a link", []tarEntry{
+			{name: "s", link: "."},
+			{name: "evil", link: "s/../.."},
+			{name: "evil/pwned.txt", data: "x"},
+		}},
+		{"write through a link", []tarEntry{{name: "s", link: "."}, {name: "s/../pwned.txt", data: "x"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := writeTarGz(t, test.entries)
+			// The extraction directory is nested so that an escape
+			// lands in parent, where it can be seen
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "a", "b", "c")
+
+			err := Extract(archive, dir)
+			if !errors.Is(err, errUnsafePath) {
+				t.Errorf("Extract() = %v, want %v", err, errUnsafePath)
+			}
+			filepath.WalkDir(parent, func(path string, entry os.DirEntry, err error) error {
+				if err == nil && entry.Name() == "pwned.txt" {
+					t.Errorf("file written at %s", path)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestExtractFollowsLinksInside(t *testing.T) {
+	archive := writeTarGz(t, []tarEntry{
+		{name: "lib/v1/tool", data: "binary"},
+		{name: "lib/current", link: "v1"},
+		{name: "bin", link: "lib/current"},
+		{name: "bin/config", data: "settings"},
+	})
+	dir := t.TempDir()
+
+	if err := Extract(archive, dir); err != nil {
+		t.Fatalf("Extract() = %v", err)
+	}
+	for path, want := range map[string]string{
+		"bin/tool":         "binary",
+		"lib/v1/config":    "settings",
+		"lib/current/tool": "binary",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+}
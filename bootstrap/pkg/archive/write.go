@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// TarGzWriter writes a .tar.gz archive
+type TarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewTarGzWriter creates a writer of a .tar.gz archive to w. Close
+// finishes the archive.
+func NewTarGzWriter(w io.Writer) *TarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &TarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+// WriteFile adds a regular file to the archive
+func (w *TarGzWriter) WriteFile(name string, data []byte, mode fs.FileMode, modTime time.Time) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     int64(mode.Perm()),
+		ModTime:  modTime,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close finishes the archive. It does not close the underlying writer.
+func (w *TarGzWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
@@ -0,0 +1,273 @@
+package companion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ezra/bootstrap/pkg/detector"
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/identity"
+)
+
+// Companion API endpoints
+const (
+	signingKeyPath      = "api/keys/signing"
+	enrollChallengePath = "api/devices/enroll/challenge"
+	enrollPath          = "api/devices/enroll"
+	installReportPath   = "api/devices/install-reports"
+	pairingPath         = "api/devices/pairing"
+	devicePath          = "api/devices/"
+)
+
+// SupportBundleReceipt is what the companion answers an uploaded support
+// bundle with
+type SupportBundleReceipt struct {
+	ID string `json:"id"`
+	// URL is where operators find the bundle, if the companion says
+	URL string `json:"url,omitempty"`
+}
+
+// LogEntry is a log entry of the bootstrap on a device
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	// SessionID tells the runs of the bootstrap on the device apart
+	SessionID string                 `json:"session_id,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Enrollment is sent to the companion to enroll a device
+type Enrollment struct {
+	DeviceID string               `json:"device_id"`
+	System   *detector.SystemInfo `json:"system,omitempty"`
+	// PublicKey is the Ed25519 device key, and Signature its signature
+	// of the challenge nonce
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+	Token     string `json:"token,omitempty"`
+	// AKPublic is the marshalled TPMT_PUBLIC of the attestation key of a
+	// TPM-backed device
+	AKPublic []byte          `json:"ak_public,omitempty"`
+	Quote    *identity.Quote `json:"quote,omitempty"`
+	// BootstrapVersion is the version of the bootstrap that enrolled
+	BootstrapVersion string `json:"bootstrap_version,omitempty"`
+}
+
+// Credential is what the companion issues an enrolled device. The agent
+// authenticates with the token or the certificate, whichever was issued.
+type Credential struct {
+	Token string `json:"token,omitempty"`
+	// Certificate is a PEM certificate of the device key
+	Certificate string     `json:"certificate,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Pairing states
+const (
+	PairingPending  = "pending"
+	PairingApproved = "approved"
+	PairingRejected = "rejected"
+	PairingExpired  = "expired"
+)
+
+// PairingRequest starts the pairing of a device that an operator then
+// approves in the companion
+type PairingRequest struct {
+	DeviceID  string               `json:"device_id"`
+	System    *detector.SystemInfo `json:"system,omitempty"`
+	PublicKey []byte               `json:"public_key"`
+}
+
+// Pairing is a pairing waiting for the operator's approval
+type Pairing struct {
+	ID string `json:"id"`
+	// Code is the short code the operator enters or compares in the
+	// companion, and ApproveURL the page that approves it
+	Code       string    `json:"code"`
+	ApproveURL string    `json:"approve_url,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Interval is how often to ask for the outcome, in seconds
+	Interval int `json:"interval,omitempty"`
+}
+
+// PairingStatus is the state of a pairing. An approved pairing carries
+// the device's credential and configuration.
+type PairingStatus struct {
+	Status     string      `json:"status"`
+	Credential *Credential `json:"credential,omitempty"`
+	// Config is the device's configuration, in the format of the
+	// bootstrap configuration file
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// pairingPoll proves that the device asking for the outcome of a
+// pairing holds the key it started it with
+type pairingPoll struct {
+	Signature []byte `json:"signature"`
+}
+
+// Heartbeat tells the companion a device is alive and what it runs
+type Heartbeat struct {
+	DeviceID string `json:"device_id"`
+	// Versions are the installed versions by component
+	Versions map[string]string `json:"versions,omitempty"`
+	// Services are the states of the services by name
+	Services map[string]string `json:"services,omitempty"`
+	SentAt   time.Time         `json:"sent_at"`
+}
+
+// HeartbeatResponse is the companion's answer to a heartbeat
+type HeartbeatResponse struct {
+	// Interval is how long to wait before the next heartbeat, in
+	// seconds; zero leaves it to the device
+	Interval int `json:"interval,omitempty"`
+	// UpdateAvailable is set when the companion has a newer release for
+	// the device
+	UpdateAvailable bool `json:"update_available,omitempty"`
+}
+
+// DeviceConfig is the configuration the companion keeps for a device
+type DeviceConfig struct {
+	// Revision changes whenever the configuration does
+	Revision string `json:"revision"`
+	// Settings are the configuration keys the companion sets, in the
+	// format of the bootstrap configuration file
+	Settings json.RawMessage `json:"settings"`
+}
+
+// signingKey is the response of the signing key endpoint
+type signingKey struct {
+	PublicKey string `json:"public_key"`
+}
+
+// enrollChallenge is the nonce the companion expects to be signed, so
+// that a recorded enrollment cannot be replayed
+type enrollChallenge struct {
+	Nonce []byte `json:"nonce"`
+}
+
+// ReleaseManifest fetches the manifest of a release, e.g. "latest" or a
+// version, from the companion itself. Downloads that fail over to
+// mirrors or resolve channels use downloader.FetchManifest instead.
+func (c *Client) ReleaseManifest(ctx context.Context, release string) (*downloader.Manifest, error) {
+	var manifest downloader.Manifest
+	if err := c.do(ctx, "GET", "releases/"+url.PathEscape(release)+"/manifest.json", nil, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to fetch release manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// SigningKey fetches the base64 Ed25519 key the companion signs releases
+// with
+func (c *Client) SigningKey(ctx context.Context) (string, error) {
+	var response signingKey
+	if err := c.do(ctx, "GET", signingKeyPath, nil, &response); err != nil {
+		return "", err
+	}
+	if response.PublicKey == "" {
+		return "", errors.New("companion sent no signing key")
+	}
+	return response.PublicKey, nil
+}
+
+// EnrollChallenge asks the companion for the nonce a device signs when
+// it enrolls
+func (c *Client) EnrollChallenge(ctx context.Context, deviceID string) ([]byte, error) {
+	var challenge enrollChallenge
+	if err := c.do(ctx, "POST", enrollChallengePath, map[string]string{"device_id": deviceID}, &challenge); err != nil {
+		return nil, err
+	}
+	if len(challenge.Nonce) == 0 {
+		return nil, errors.New("companion sent an empty enrollment challenge")
+	}
+	return challenge.Nonce, nil
+}
+
+// Enroll registers a device with the companion and returns the
+// credential issued for it. A rejected enrollment fails with a
+// *downloader.StatusError of status 401 or 403.
+func (c *Client) Enroll(ctx context.Context, enrollment Enrollment) (*Credential, error) {
+	var credential Credential
+	if err := c.do(ctx, "POST", enrollPath, enrollment, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// StartPairing starts pairing a device and returns the code the
+// operator approves it with
+func (c *Client) StartPairing(ctx context.Context, request PairingRequest) (*Pairing, error) {
+	var pairing Pairing
+	if err := c.do(ctx, "POST", pairingPath, request, &pairing); err != nil {
+		return nil, fmt.Errorf("failed to start pairing: %w", err)
+	}
+	if pairing.ID == "" || pairing.Code == "" {
+		return nil, errors.New("companion sent an incomplete pairing")
+	}
+	return &pairing, nil
+}
+
+// PairingStatus asks for the outcome of a pairing. signature is the
+// device key's signature of the pairing ID.
+func (c *Client) PairingStatus(ctx context.Context, id string, signature []byte) (*PairingStatus, error) {
+	var status PairingStatus
+	path := pairingPath + "/" + url.PathEscape(id) + "/status"
+	if err := c.do(ctx, "POST", path, pairingPoll{Signature: signature}, &status); err != nil {
+		return nil, fmt.Errorf("failed to get pairing status: %w", err)
+	}
+	return &status, nil
+}
+
+// Heartbeat reports that a device is alive
+func (c *Client) Heartbeat(ctx context.Context, heartbeat Heartbeat) (*HeartbeatResponse, error) {
+	var response HeartbeatResponse
+	path := devicePath + url.PathEscape(heartbeat.DeviceID) + "/heartbeat"
+	if err := c.do(ctx, "POST", path, heartbeat, &response); err != nil {
+		return nil, fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	return &response, nil
+}
+
+// UploadInstallReport sends the report of an installation, which is
+// encoded as JSON
+func (c *Client) UploadInstallReport(ctx context.Context, report interface{}) error {
+	if err := c.do(ctx, "POST", installReportPath, report, nil); err != nil {
+		return fmt.Errorf("failed to upload install report: %w", err)
+	}
+	return nil
+}
+
+// UploadSupportBundle sends a support bundle of a device, a .tar.gz
+// archive, for operators to look into a problem
+func (c *Client) UploadSupportBundle(ctx context.Context, deviceID string, bundle []byte) (*SupportBundleReceipt, error) {
+	var receipt SupportBundleReceipt
+	path := devicePath + url.PathEscape(deviceID) + "/support-bundles"
+	if err := c.send(ctx, "POST", path, "application/gzip", bundle, &receipt); err != nil {
+		return nil, fmt.Errorf("failed to upload support bundle: %w", err)
+	}
+	return &receipt, nil
+}
+
+// ShipLogs sends a batch of log entries of a device, for operators to
+// follow installs as they happen
+func (c *Client) ShipLogs(ctx context.Context, deviceID string, entries []LogEntry) error {
+	path := devicePath + url.PathEscape(deviceID) + "/logs"
+	if err := c.do(ctx, "POST", path, map[string][]LogEntry{"entries": entries}, nil); err != nil {
+		return fmt.Errorf("failed to ship logs: %w", err)
+	}
+	return nil
+}
+
+// DeviceConfig pulls the configuration the companion keeps for a device
+func (c *Client) DeviceConfig(ctx context.Context, deviceID string) (*DeviceConfig, error) {
+	var config DeviceConfig
+	if err := c.do(ctx, "GET", devicePath+url.PathEscape(deviceID)+"/config", nil, &config); err != nil {
+		return nil, fmt.Errorf("failed to pull device configuration: %w", err)
+	}
+	return &config, nil
+}
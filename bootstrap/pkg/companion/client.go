@@ -0,0 +1,140 @@
+package companion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ezra/bootstrap/pkg/downloader"
+	"github.com/ezra/bootstrap/pkg/redact"
+)
+
+// maxResponseSize bounds the JSON responses read from the companion
+const maxResponseSize = 8 << 20
+
+// Client calls the companion's API
+type Client struct {
+	baseURL string
+	client  *http.Client
+	retry   downloader.RetryPolicy
+	log     downloader.Logger
+
+	tokenMu sync.Mutex
+	token   string
+}
+
+// Options configures a client
+type Options struct {
+	// HTTPClient sends the requests, so that they use the proxy and TLS
+	// settings of the downloader. http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Retry is the retry policy of the requests;
+	// downloader.DefaultRetryPolicy when zero
+	Retry downloader.RetryPolicy
+	// Token is sent as a bearer token once the device is enrolled
+	Token string
+	Log   downloader.Logger
+}
+
+// New creates a client of the companion at baseURL
+func New(baseURL string, opts Options) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  opts.HTTPClient,
+		retry:   opts.Retry,
+		log:     opts.Log,
+		token:   opts.Token,
+	}
+	if c.client == nil {
+		c.client = http.DefaultClient
+	}
+	if c.retry.MaxAttempts == 0 {
+		c.retry = downloader.DefaultRetryPolicy()
+	}
+	redact.AddSecret(c.token)
+	return c
+}
+
+// SetToken sets the bearer token requests authenticate with, e.g. the
+// one issued when the device enrolled. It is kept out of logs and
+// reports.
+func (c *Client) SetToken(token string) {
+	redact.AddSecret(token)
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.token = token
+}
+
+// BaseURL returns the URL of the companion
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// do calls a JSON endpoint of the companion. body is sent as JSON when
+// not nil and the response is decoded into result when not nil. Failed
+// responses are returned as *downloader.StatusError, and retried when
+// the retry policy says so.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var payload []byte
+	contentType := ""
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request to %s: %w", path, err)
+		}
+		contentType = "application/json"
+	}
+	return c.send(ctx, method, path, contentType, payload, result)
+}
+
+// send calls an endpoint of the companion with a payload of the given
+// content type, if any, and decodes the JSON response into result when
+// not nil
+func (c *Client) send(ctx context.Context, method, path, contentType string, payload []byte, result interface{}) error {
+	url := c.baseURL + "/" + strings.TrimPrefix(path, "/")
+
+	var data []byte
+	err := c.retry.Run(ctx, c.log, path, func() error {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		c.tokenMu.Lock()
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		c.tokenMu.Unlock()
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to call %s: %w", path, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			return &downloader.StatusError{StatusCode: resp.StatusCode}
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		if err != nil {
+			return fmt.Errorf("failed to read response of %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil || result == nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse response of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package copier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy says what happens to symbolic links inside the copied
+// tree. A link given as the source itself is always followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinksPreserve recreates links as links. Links pointing outside
+	// the copied tree are rejected since they would dangle or point
+	// somewhere else in the copy.
+	SymlinksPreserve SymlinkPolicy = iota
+	// SymlinksFollow copies what links point to
+	SymlinksFollow
+	// SymlinksSkip leaves links out of the copy
+	SymlinksSkip
+)
+
+// Options configure a copy
+type Options struct {
+	Symlinks SymlinkPolicy
+	// PreserveXattrs copies extended attributes on platforms that have
+	// them. Attributes the destination does not support, or that need
+	// privileges, are skipped.
+	PreserveXattrs bool
+	// Verify reads every copied file back and compares its SHA-256 with
+	// that of the source
+	Verify bool
+	// Progress is called as data is copied with the bytes copied so far
+	// and the total size of the regular files to copy
+	Progress func(copied, total int64)
+}
+
+// Result describes a finished copy
+type Result struct {
+	Files int
+	Bytes int64
+	// Checksums are the SHA-256 of the copied files by their path
+	// relative to the source, when Verify is set
+	Checksums map[string]string
+}
+
+// entry is a file, directory or preserved link to copy
+type entry struct {
+	rel  string
+	info fs.FileInfo
+	// link is the target of a preserved symbolic link
+	link string
+}
+
+// copier copies one tree
+type copier struct {
+	opts    Options
+	entries []entry
+	visited map[string]bool
+	total   int64
+	copied  int64
+	result  *Result
+}
+
+// Copy copies src, a file or a directory tree, to dst, which must not
+// exist or be of the same kind. Permissions and modification times are
+// preserved without setuid, setgid or sticky bits.
+func Copy(src, dst string, opts Options) (*Result, error) {
+	c := &copier{
+		opts:    opts,
+		visited: map[string]bool{},
+		result:  &Result{},
+	}
+	if opts.Verify {
+		c.result.Checksums = map[string]string{}
+	}
+
+	if err := c.collect(src, ".", true); err != nil {
+		return nil, err
+	}
+
+	// Directories are made writable while their contents are copied and
+	// get their own mode afterwards, deepest first
+	var dirs []entry
+	for _, e := range c.entries {
+		from, to := filepath.Join(src, e.rel), filepath.Join(dst, e.rel)
+		var err error
+		switch {
+		case e.link != "":
+			err = copyLink(e.link, to)
+		case e.info.IsDir():
+			err = os.MkdirAll(to, 0700)
+			dirs = append(dirs, e)
+		default:
+			err = c.copyFile(from, to, e)
+		}
+		if err != nil {
+			return c.result, fmt.Errorf("failed to copy %s: %w", from, err)
+		}
+	}
+
+	for n := len(dirs) - 1; n >= 0; n-- {
+		from, to := filepath.Join(src, dirs[n].rel), filepath.Join(dst, dirs[n].rel)
+		if err := c.finish(from, to, dirs[n].info); err != nil {
+			return c.result, fmt.Errorf("failed to copy %s: %w", from, err)
+		}
+	}
+	return c.result, nil
+}
+
+// collect lists what to copy below path, in the order it is copied
+func (c *copier) collect(path, rel string, root bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		switch {
+		case root || c.opts.Symlinks == SymlinksFollow:
+			if info, err = os.Stat(path); err != nil {
+				return fmt.Errorf("cannot follow %s: %w", path, err)
+			}
+		case c.opts.Symlinks == SymlinksSkip:
+			return nil
+		default:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			resolved := filepath.Join(filepath.Dir(rel), target)
+			if filepath.IsAbs(target) || resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("%s points outside the copied tree", path)
+			}
+			c.entries = append(c.entries, entry{rel: rel, info: info, link: target})
+			return nil
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		// Followed links can loop back to a directory being copied
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if c.visited[real] {
+			return fmt.Errorf("%s loops back to %s", path, real)
+		}
+		c.visited[real] = true
+		defer delete(c.visited, real)
+
+		c.entries = append(c.entries, entry{rel: rel, info: info})
+		children, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := c.collect(filepath.Join(path, child.Name()), filepath.Join(rel, child.Name()), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case info.Mode().IsRegular():
+		c.entries = append(c.entries, entry{rel: rel, info: info})
+		c.total += info.Size()
+		return nil
+	default:
+		return fmt.Errorf("%s: cannot copy %s", path, info.Mode().Type())
+	}
+}
+
+// copyFile copies a regular file and verifies the copy
+func (c *copier) copyFile(from, to string, e entry) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var sum hash.Hash
+	var w io.Writer = &progressWriter{c: c}
+	if c.opts.Verify {
+		sum = sha256.New()
+		w = io.MultiWriter(w, sum)
+	}
+	n, err := io.Copy(out, io.TeeReader(in, w))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if sum != nil {
+		expected := sum.Sum(nil)
+		actual, err := fileSHA256(to)
+		if err != nil {
+			return fmt.Errorf("failed to verify the copy: %w", err)
+		}
+		if !bytes.Equal(actual, expected) {
+			return fmt.Errorf("the copy at %s does not match: sha256 %x, expected %x", to, actual, expected)
+		}
+		c.result.Checksums[filepath.ToSlash(e.rel)] = hex.EncodeToString(expected)
+	}
+
+	c.result.Files++
+	c.result.Bytes += n
+	return c.finish(from, to, e.info)
+}
+
+// finish gives a copied file or directory the mode, modification time
+// and extended attributes of the source
+func (c *copier) finish(from, to string, info fs.FileInfo) error {
+	if c.opts.PreserveXattrs {
+		if err := copyXattrs(from, to); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(to, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// copyLink recreates a symbolic link, replacing any file in its place
+func copyLink(target, to string) error {
+	if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, to)
+}
+
+// fileSHA256 hashes a file
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
+
+// progressWriter reports the bytes written through it
+type progressWriter struct {
+	c *copier
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.c.copied += int64(len(p))
+	if w.c.opts.Progress != nil {
+		w.c.opts.Progress(w.c.copied, w.c.total)
+	}
+	return len(p), nil
+}
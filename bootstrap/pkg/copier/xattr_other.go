@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package copier
+
+// copyXattrs does nothing on platforms without extended attributes
+func copyXattrs(from, to string) error {
+	return nil
+}
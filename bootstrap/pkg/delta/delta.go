@@ -0,0 +1,164 @@
+package delta
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Patch formats
+const (
+	FormatBsdiff = "bsdiff"
+	FormatZstd   = "zstd"
+)
+
+// maxPatchWindow bounds the zstd window so that a patch cannot make the
+// decoder allocate without limit
+const maxPatchWindow = 1 << 31
+
+// MaxSize is the largest file a patch may build when the size of the
+// new file is not known
+const MaxSize = 1 << 30
+
+// errCorrupt is returned for bsdiff patches whose lengths do not add up
+var errCorrupt = errors.New("corrupt bsdiff patch")
+
+// bsdiffMagic starts every BSDIFF40 patch
+var bsdiffMagic = []byte("BSDIFF40")
+
+// Apply applies a binary patch to old and returns the new contents. The
+// patch is untrusted until its result is verified, so the new file may
+// be at most maxSize bytes, or MaxSize when maxSize is 0.
+func Apply(format string, old, patch []byte, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 || maxSize > MaxSize {
+		maxSize = MaxSize
+	}
+	switch format {
+	case FormatBsdiff:
+		return applyBsdiff(old, patch, maxSize)
+	case FormatZstd:
+		return applyZstd(old, patch, maxSize)
+	default:
+		return nil, fmt.Errorf("unsupported patch format %q", format)
+	}
+}
+
+// applyZstd decodes a patch made with `zstd --patch-from`, which uses the
+// old file as a raw dictionary
+func applyZstd(old, patch []byte, maxSize int64) ([]byte, error) {
+	decoder, err := zstd.NewReader(nil,
+		zstd.WithDecoderDictRaw(0, old),
+		zstd.WithDecoderMaxWindow(maxPatchWindow),
+		zstd.WithDecoderMaxMemory(uint64(maxSize)),
+		zstd.WithDecoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	out, err := decoder.DecodeAll(patch, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply zstd patch: %w", err)
+	}
+
+	return out, nil
+}
+
+// applyBsdiff applies a BSDIFF40 patch. The header holds the lengths of
+// the bzip2 compressed control and diff blocks and the size of the new
+// file; the extra block takes up the rest of the patch. Every length is
+// checked before it is used, without sums that could overflow.
+func applyBsdiff(old, patch []byte, maxSize int64) ([]byte, error) {
+	if len(patch) < 32 || !bytes.Equal(patch[:8], bsdiffMagic) {
+		return nil, fmt.Errorf("not a bsdiff patch")
+	}
+
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+	blocks := int64(len(patch) - 32)
+	if ctrlLen < 0 || diffLen < 0 || ctrlLen > blocks || diffLen > blocks-ctrlLen {
+		return nil, fmt.Errorf("corrupt bsdiff header")
+	}
+	if newSize < 0 {
+		return nil, fmt.Errorf("corrupt bsdiff header")
+	}
+	if newSize > maxSize {
+		return nil, fmt.Errorf("bsdiff patch builds a file of %d bytes, larger than the %d expected", newSize, maxSize)
+	}
+
+	body := patch[32:]
+	ctrl := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+
+	out := make([]byte, newSize)
+	var oldPos, newPos int64
+	var buf [24]byte
+	var err error
+
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, buf[:]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff control block: %w", err)
+		}
+		add := offtin(buf[0:8])
+		copyLen := offtin(buf[8:16])
+		seek := offtin(buf[16:24])
+
+		// Every entry must make progress, within the new file
+		if add < 0 || add > newSize-newPos || copyLen < 0 || copyLen > newSize-newPos-add || add+copyLen == 0 {
+			return nil, errCorrupt
+		}
+
+		// Add diff bytes to the old data
+		if _, err := io.ReadFull(diff, out[newPos:newPos+add]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff diff block: %w", err)
+		}
+		for i := int64(0); i < add; i++ {
+			if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+				out[newPos+i] += old[oldPos+i]
+			}
+		}
+		newPos += add
+		if oldPos, err = addOffset(oldPos, add); err != nil {
+			return nil, err
+		}
+
+		// Copy bytes from the extra block
+		if _, err := io.ReadFull(extra, out[newPos:newPos+copyLen]); err != nil {
+			return nil, fmt.Errorf("corrupt bsdiff extra block: %w", err)
+		}
+		newPos += copyLen
+		if oldPos, err = addOffset(oldPos, seek); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// addOffset moves a position in the old file, refusing moves that
+// overflow
+func addOffset(pos, n int64) (int64, error) {
+	if (n > 0 && pos > math.MaxInt64-n) || (n < 0 && pos < math.MinInt64-n) {
+		return 0, errCorrupt
+	}
+	return pos + n, nil
+}
+
+// offtin decodes bsdiff's sign-magnitude little endian integers
+func offtin(b []byte) int64 {
+	v := binary.LittleEndian.Uint64(b)
+	n := int64(v &^ (1 << 63))
+	if v&(1<<63) != 0 {
+		n = -n
+	}
+	return n
+}
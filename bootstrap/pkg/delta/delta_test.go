@@ -0,0 +1,150 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2 compressed blocks for the test patches. Go has no bzip2
+// encoder, so they were made with Python's bz2 module. Control entries
+// are (add, copy, seek).
+const (
+	// (6, 5, 0): six bytes of the old file, then five extra bytes
+	ctrlGood = "425a6839314159265359661a900c00000540004b0820002186819a00ad9af177245385090661a900c0"
+	// (0, 0, 0): an entry that builds nothing
+	ctrlNoProgress = "425a6839314159265359045383c5000000600040000400200021008283177245385090045383c5"
+	// (6, 6, 0): one byte more than the new file holds
+	ctrlPastEnd = "425a683931415926535916c25142000004c00049082000218c8334d09ad538bb9229c28480b6128a10"
+	// (6, -1, 0)
+	ctrlNegativeCopy = "425a68393141592653591aecb20c00000840407d004000200021886d4218094d6958c3c5dc914e142406bb2c8300"
+	// (1, 0, MaxInt64), (1, 0, 0): a seek past the end of int64
+	ctrlSeekOverflow = "425a6839314159265359e44f235a0000046080e808080000008000a000212219086018c5d2e9315f177245385090e44f235a"
+	// six zero bytes, which leave the old bytes as they are
+	diffZeros = "425a6839314159265359c585438d00000040005000200021008283177245385090c585438d"
+	// "there"
+	extraThere = "425a6839314159265359fdd4d8820000020180024014002000219a68334d0cb38bb9229c28487eea6c4100"
+)
+
+// sizeField encodes n the way bsdiff headers do
+func sizeField(n int64) []byte {
+	v := uint64(n)
+	if n < 0 {
+		v = uint64(-n) | 1<<63
+	}
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
+
+// bsdiffPatch builds a BSDIFF40 patch from hex encoded blocks
+func bsdiffPatch(t *testing.T, ctrl, diff, extra string, newSize int64) []byte {
+	t.Helper()
+	var blocks [3][]byte
+	for n, block := range []string{ctrl, diff, extra} {
+		data, err := hex.DecodeString(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[n] = data
+	}
+	patch := append([]byte{}, bsdiffMagic...)
+	patch = append(patch, sizeField(int64(len(blocks[0])))...)
+	patch = append(patch, sizeField(int64(len(blocks[1])))...)
+	patch = append(patch, sizeField(newSize)...)
+	return append(append(append(patch, blocks[0]...), blocks[1]...), blocks[2]...)
+}
+
+// withHeader returns a copy of patch with a header field replaced
+func withHeader(patch []byte, field int, n int64) []byte {
+	patch = bytes.Clone(patch)
+	copy(patch[8+8*field:], sizeField(n))
+	return patch
+}
+
+func TestApplyBsdiff(t *testing.T) {
+	old := []byte("hello world")
+	patch := bsdiffPatch(t, ctrlGood, diffZeros, extraThere, 11)
+
+	got, err := Apply(FormatBsdiff, old, patch, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello there" {
+		t.Errorf("Apply = %q, want %q", got, "hello there")
+	}
+}
+
+func TestApplyBsdiffRejects(t *testing.T) {
+	old := []byte("hello world")
+	good := bsdiffPatch(t, ctrlGood, diffZeros, extraThere, 11)
+
+	tests := []struct {
+		name    string
+		patch   []byte
+		maxSize int64
+	}{
+		{"empty", nil, 0},
+		{"not bsdiff", []byte("BSDIFF39" + string(good[8:])), 0},
+		{"truncated header", good[:31], 0},
+		{"truncated blocks", good[:40], 0},
+		{"truncated extra block", good[:len(good)-35], 0},
+		{"negative control length", withHeader(good, 0, -1), 0},
+		{"negative diff length", withHeader(good, 1, -1), 0},
+		{"negative new size", withHeader(good, 2, -1), 0},
+		{"control longer than patch", withHeader(good, 0, int64(len(good))), 0},
+		{"diff longer than patch", withHeader(good, 1, int64(len(good))), 0},
+		{"lengths overflow", withHeader(withHeader(good, 0, 8), 1, math.MaxInt64-4), 0},
+		{"largest lengths", withHeader(withHeader(good, 0, math.MaxInt64), 1, math.MaxInt64), 0},
+		{"larger than expected", good, 10},
+		{"larger than MaxSize", withHeader(good, 2, MaxSize+1), 0},
+		{"huge new size", withHeader(good, 2, math.MaxInt64), 0},
+		{"entry without progress", bsdiffPatch(t, ctrlNoProgress, diffZeros, extraThere, 11), 0},
+		{"entry past the end", bsdiffPatch(t, ctrlPastEnd, diffZeros, extraThere, 11), 0},
+		{"negative copy", bsdiffPatch(t, ctrlNegativeCopy, diffZeros, extraThere, 11), 0},
+		{"seek overflow", bsdiffPatch(t, ctrlSeekOverflow, diffZeros, extraThere, 2), 0},
+		{"short new size", bsdiffPatch(t, ctrlGood, diffZeros, extraThere, 5), 0},
+		{"missing entries", bsdiffPatch(t, ctrlGood, diffZeros, extraThere, 12), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := Apply(FormatBsdiff, old, test.patch, test.maxSize); err == nil {
+				t.Errorf("Apply = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestApplyZstd(t *testing.T) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("ezra "), 1000)
+	patch := encoder.EncodeAll(data, nil)
+	encoder.Close()
+
+	got, err := Apply(FormatZstd, []byte("old"), patch, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Apply did not return the encoded data")
+	}
+
+	if _, err := Apply(FormatZstd, []byte("old"), patch, int64(len(data))-1); err == nil {
+		t.Error("Apply built a file larger than expected")
+	}
+	if _, err := Apply(FormatZstd, []byte("old"), patch[:len(patch)/2], 0); err == nil {
+		t.Error("Apply accepted a truncated patch")
+	}
+}
+
+func TestApplyUnknownFormat(t *testing.T) {
+	if _, err := Apply("xdelta", nil, nil, 0); err == nil {
+		t.Error("Apply accepted an unknown format")
+	}
+}
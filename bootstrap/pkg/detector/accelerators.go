@@ -0,0 +1,161 @@
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Compute runtimes reported in Accelerator.Runtime
+const (
+	RuntimeCUDA    = "cuda"
+	RuntimeROCm    = "rocm"
+	RuntimeEdgeTPU = "edgetpu"
+)
+
+// commandTimeout bounds the vendor tools run during detection
+const commandTimeout = 5 * time.Second
+
+// cudaVersionPattern finds the CUDA version in the nvidia-smi banner
+var cudaVersionPattern = regexp.MustCompile(`CUDA Version:\s*([0-9.]+)`)
+
+// Accelerator is a GPU or machine learning accelerator
+type Accelerator struct {
+	// Vendor is "nvidia", "amd", "intel" or "google"
+	Vendor string `json:"vendor"`
+	Model  string `json:"model"`
+	Driver string `json:"driver,omitempty"`
+	// Runtime is the compute runtime usable with the device: "cuda",
+	// "rocm", "edgetpu" or empty
+	Runtime        string `json:"runtime,omitempty"`
+	RuntimeVersion string `json:"runtime_version,omitempty"`
+}
+
+// detectAccelerators fills in the accelerators and adds a capability per
+// vendor and runtime, e.g. gpu_nvidia, cuda and cuda_12.2
+func (d *Detector) detectAccelerators(info *SystemInfo) {
+	info.Accelerators = detectAccelerators()
+
+	seen := map[string]bool{}
+	add := func(capability string) {
+		if !seen[capability] {
+			seen[capability] = true
+			info.Capabilities = append(info.Capabilities, capability)
+		}
+	}
+	for _, acc := range info.Accelerators {
+		switch {
+		case acc.Vendor == "google":
+			add("coral_tpu")
+		case strings.HasPrefix(acc.Model, "NVIDIA Jetson"):
+			add("jetson")
+		default:
+			add("gpu_" + acc.Vendor)
+		}
+		if acc.Runtime != "" && acc.Runtime != RuntimeEdgeTPU {
+			add(acc.Runtime)
+			if acc.RuntimeVersion != "" {
+				add(acc.Runtime + "_" + acc.RuntimeVersion)
+			}
+		}
+	}
+}
+
+// BestRuntime returns the GPU compute runtime to build for: "cuda",
+// "rocm" or "" when there is none
+func (info *SystemInfo) BestRuntime() string {
+	best := ""
+	for _, acc := range info.Accelerators {
+		switch acc.Runtime {
+		case RuntimeCUDA:
+			return RuntimeCUDA
+		case RuntimeROCm:
+			best = RuntimeROCm
+		}
+	}
+	return best
+}
+
+// runCommand runs a vendor tool and returns its output, or "" if it is
+// not installed or fails
+func runCommand(name string, args ...string) string {
+	if _, err := exec.LookPath(name); err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// nvidiaSMI lists NVIDIA GPUs with nvidia-smi, which ships with the
+// driver on every platform
+func nvidiaSMI() []Accelerator {
+	out := runCommand("nvidia-smi", "--query-gpu=name,driver_version", "--format=csv,noheader")
+	if out == "" {
+		return nil
+	}
+	cuda := nvidiaCUDAVersion()
+
+	var gpus []Accelerator
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		name, driver, _ := strings.Cut(line, ",")
+		gpus = append(gpus, Accelerator{
+			Vendor:         "nvidia",
+			Model:          strings.TrimSpace(name),
+			Driver:         strings.TrimSpace(driver),
+			Runtime:        RuntimeCUDA,
+			RuntimeVersion: cuda,
+		})
+	}
+	return gpus
+}
+
+// nvidiaCUDAVersion returns the highest CUDA version the driver supports,
+// as shown in the nvidia-smi banner
+func nvidiaCUDAVersion() string {
+	if match := cudaVersionPattern.FindStringSubmatch(runCommand("nvidia-smi")); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// cudaToolkitVersion reads the version of a CUDA toolkit installed in
+// dir, as found on Jetson boards
+func cudaToolkitVersion(dir string) string {
+	if data, err := os.ReadFile(dir + "/version.json"); err == nil {
+		var version struct {
+			CUDA struct {
+				Version string `json:"version"`
+			} `json:"cuda"`
+		}
+		if json.Unmarshal(data, &version) == nil && version.CUDA.Version != "" {
+			return majorMinor(version.CUDA.Version)
+		}
+	}
+	if data, err := os.ReadFile(dir + "/version.txt"); err == nil {
+		// CUDA Version 10.2.89
+		fields := strings.Fields(string(data))
+		if len(fields) > 0 {
+			return majorMinor(fields[len(fields)-1])
+		}
+	}
+	return ""
+}
+
+// majorMinor shortens a version such as 12.2.140 to 12.2
+func majorMinor(version string) string {
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + "." + parts[1]
+}
@@ -0,0 +1,174 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PCI vendor IDs of the GPUs found through DRM
+var drmVendors = map[string]string{
+	"0x1002": "amd",
+	"0x8086": "intel",
+}
+
+// coralUSBIDs are the USB IDs of the Coral accelerator before and after
+// its firmware is loaded
+var coralUSBIDs = []string{"1a6e:089a", "18d1:9302"}
+
+// nvidiaModulePattern finds the driver version in /proc/driver/nvidia/version
+var nvidiaModulePattern = regexp.MustCompile(`Kernel Module\s+([0-9.]+)`)
+
+// tegraReleasePattern parses /etc/nv_tegra_release, e.g.
+// "# R35 (release), REVISION: 3.1, ..."
+var tegraReleasePattern = regexp.MustCompile(`R(\d+) \(release\), REVISION: ([0-9.]+)`)
+
+// detectAccelerators finds GPUs and accelerators through procfs and
+// sysfs, running nvidia-smi only for the CUDA version
+func detectAccelerators() []Accelerator {
+	var found []Accelerator
+	if jetson, ok := detectJetson(); ok {
+		found = append(found, jetson)
+	} else {
+		found = append(found, nvidiaGPUs()...)
+	}
+	found = append(found, drmGPUs()...)
+	found = append(found, coralDevices()...)
+	return found
+}
+
+// nvidiaGPUs lists the GPUs handled by the NVIDIA kernel driver
+func nvidiaGPUs() []Accelerator {
+	data, err := os.ReadFile("/proc/driver/nvidia/version")
+	if err != nil {
+		return nil
+	}
+	driver := ""
+	if match := nvidiaModulePattern.FindSubmatch(data); match != nil {
+		driver = string(match[1])
+	}
+
+	dirs, _ := filepath.Glob("/proc/driver/nvidia/gpus/*/information")
+	if len(dirs) == 0 {
+		return nil
+	}
+	cuda := nvidiaCUDAVersion()
+
+	var gpus []Accelerator
+	for _, info := range dirs {
+		gpus = append(gpus, Accelerator{
+			Vendor:         "nvidia",
+			Model:          readKeyValues(info)["Model"],
+			Driver:         driver,
+			Runtime:        RuntimeCUDA,
+			RuntimeVersion: cuda,
+		})
+	}
+	return gpus
+}
+
+// detectJetson recognises NVIDIA Jetson boards, whose integrated GPU is
+// not listed by the desktop driver
+func detectJetson() (Accelerator, bool) {
+	data, err := os.ReadFile("/etc/nv_tegra_release")
+	if err != nil {
+		return Accelerator{}, false
+	}
+
+	jetson := Accelerator{
+		Vendor:         "nvidia",
+		Model:          "NVIDIA Jetson",
+		Runtime:        RuntimeCUDA,
+		RuntimeVersion: cudaToolkitVersion("/usr/local/cuda"),
+	}
+	if model := readDeviceTree("model"); model != "" {
+		jetson.Model = model
+	}
+	if match := tegraReleasePattern.FindSubmatch(data); match != nil {
+		jetson.Driver = "L4T R" + string(match[1]) + "." + string(match[2])
+	}
+	return jetson, true
+}
+
+// drmGPUs lists AMD and Intel GPUs from the DRM cards in sysfs
+func drmGPUs() []Accelerator {
+	cards, _ := filepath.Glob("/sys/class/drm/card[0-9]*")
+
+	var gpus []Accelerator
+	for _, card := range cards {
+		// Connectors such as card0-HDMI-A-1 are not devices
+		if strings.Contains(filepath.Base(card), "-") {
+			continue
+		}
+		device := filepath.Join(card, "device")
+		vendor, ok := drmVendors[readTrimmed(filepath.Join(device, "vendor"))]
+		if !ok {
+			continue
+		}
+
+		gpu := Accelerator{
+			Vendor: vendor,
+			Model:  readTrimmed(filepath.Join(device, "product_name")),
+		}
+		if gpu.Model == "" {
+			gpu.Model = vendor + " GPU " + readTrimmed(filepath.Join(device, "device"))
+		}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(device, "driver")); err == nil {
+			gpu.Driver = filepath.Base(driver)
+		}
+		if vendor == "amd" {
+			if version, ok := rocmVersion(); ok {
+				gpu.Runtime = RuntimeROCm
+				gpu.RuntimeVersion = version
+			}
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus
+}
+
+// rocmVersion returns the installed ROCm version, e.g. 5.7 for
+// 5.7.1-44
+func rocmVersion() (string, bool) {
+	version := readTrimmed("/opt/rocm/.info/version")
+	if version == "" {
+		if _, err := os.Stat("/opt/rocm"); err != nil {
+			return "", false
+		}
+		return "", true
+	}
+	version, _, _ = strings.Cut(version, "-")
+	return majorMinor(version), true
+}
+
+// coralDevices lists Coral Edge TPUs on USB and PCIe
+func coralDevices() []Accelerator {
+	var found []Accelerator
+
+	usb, _ := filepath.Glob("/sys/bus/usb/devices/*/idVendor")
+	for _, vendorFile := range usb {
+		dir := filepath.Dir(vendorFile)
+		id := readTrimmed(vendorFile) + ":" + readTrimmed(filepath.Join(dir, "idProduct"))
+		for _, coral := range coralUSBIDs {
+			if id == coral {
+				found = append(found, Accelerator{Vendor: "google", Model: "Coral USB Accelerator", Runtime: RuntimeEdgeTPU})
+			}
+		}
+	}
+
+	pcie, _ := filepath.Glob("/dev/apex_*")
+	for range pcie {
+		found = append(found, Accelerator{Vendor: "google", Model: "Coral PCIe Accelerator", Driver: "apex", Runtime: RuntimeEdgeTPU})
+	}
+	return found
+}
+
+// readTrimmed returns the trimmed contents of a small file, or ""
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
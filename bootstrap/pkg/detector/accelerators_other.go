@@ -0,0 +1,9 @@
+//go:build !linux
+
+package detector
+
+// detectAccelerators finds NVIDIA GPUs through nvidia-smi; other GPUs
+// are not detected on this platform
+func detectAccelerators() []Accelerator {
+	return nvidiaSMI()
+}
@@ -0,0 +1,33 @@
+package detector
+
+import (
+	"os"
+	"os/user"
+)
+
+// hasAdminRights reports whether the process runs as root or as a member
+// of the admin group, whose members may use sudo
+func hasAdminRights() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return false
+	}
+	admin, err := user.LookupGroup("admin")
+	if err != nil {
+		return false
+	}
+	groups, err := current.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, gid := range groups {
+		if gid == admin.Gid {
+			return true
+		}
+	}
+	return false
+}
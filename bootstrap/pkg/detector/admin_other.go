@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package detector
+
+import "os"
+
+// hasAdminRights reports whether the process runs as root
+func hasAdminRights() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,10 @@
+package detector
+
+import "golang.org/x/sys/windows"
+
+// hasAdminRights reports whether the process token is elevated. With UAC
+// an administrator's unelevated processes run with a filtered token and
+// cannot install services.
+func hasAdminRights() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
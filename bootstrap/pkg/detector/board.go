@@ -0,0 +1,73 @@
+package detector
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Single-board computer families reported in BoardInfo.Family
+const (
+	BoardRaspberryPi = "raspberry_pi"
+	BoardJetson      = "jetson"
+	BoardRockPi      = "rock_pi"
+)
+
+// BoardInfo identifies the board or machine model
+type BoardInfo struct {
+	// Family is set for recognised single-board computers only
+	Family   string `json:"family,omitempty"`
+	Vendor   string `json:"vendor,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// SoC is the system on chip, e.g. bcm2711 or tegra210
+	SoC string `json:"soc,omitempty"`
+}
+
+// detectBoard fills in the board and adds a capability for recognised
+// single-board computers
+func (d *Detector) detectBoard(info *SystemInfo) {
+	info.Board = detectBoard()
+	if info.Board.Family != "" {
+		info.Capabilities = append(info.Capabilities, "sbc", info.Board.Family)
+	}
+
+	// BCM2835 boards run ARMv6 code only, whatever the kernel reports
+	if info.Board.SoC == "bcm2835" && info.CPU.Arch == "armv7" {
+		info.CPU.Arch = "armv6"
+	}
+}
+
+// boardFamily recognises a single-board computer from its model name
+func boardFamily(vendor, model string) string {
+	lower := strings.ToLower(vendor + " " + model)
+	switch {
+	case strings.Contains(lower, "raspberry pi"):
+		return BoardRaspberryPi
+	case strings.Contains(lower, "jetson"):
+		return BoardJetson
+	case strings.Contains(lower, "radxa") || strings.Contains(lower, "rock pi"):
+		return BoardRockPi
+	default:
+		return ""
+	}
+}
+
+// Raspberry Pi SoCs by the processor field of a new-style revision code
+var raspberryPiSoCs = []string{"bcm2835", "bcm2836", "bcm2837", "bcm2711", "bcm2712"}
+
+// raspberryPiSoC decodes the SoC from a Raspberry Pi revision code such
+// as c03114. Old-style codes without bit 23 set are all BCM2835 boards.
+func raspberryPiSoC(revision string) string {
+	code, err := strconv.ParseUint(strings.TrimPrefix(revision, "1000"), 16, 32)
+	if err != nil {
+		return ""
+	}
+	if code&(1<<23) == 0 {
+		return "bcm2835"
+	}
+	processor := (code >> 12) & 0xf
+	if int(processor) >= len(raspberryPiSoCs) {
+		return ""
+	}
+	return raspberryPiSoCs[processor]
+}
@@ -0,0 +1,58 @@
+package detector
+
+import (
+	"os"
+	"strings"
+)
+
+// detectBoard identifies the board from the device tree on ARM boards and
+// from DMI data elsewhere
+func detectBoard() BoardInfo {
+	var board BoardInfo
+
+	cpuinfo := readKeyValues("/proc/cpuinfo")
+	board.Revision = cpuinfo["Revision"]
+
+	if model := readDeviceTree("model"); model != "" {
+		board.Model = model
+		compatible := strings.Split(readDeviceTree("compatible"), "\x00")
+		if vendor, _, ok := strings.Cut(compatible[0], ","); ok {
+			board.Vendor = vendor
+		}
+		// The last compatible string names the SoC, e.g. brcm,bcm2711
+		if _, soc, ok := strings.Cut(compatible[len(compatible)-1], ","); ok {
+			board.SoC = soc
+		}
+	} else {
+		board.Vendor = readTrimmed("/sys/class/dmi/id/sys_vendor")
+		board.Model = readTrimmed("/sys/class/dmi/id/product_name")
+		if board.Revision == "" {
+			board.Revision = readTrimmed("/sys/class/dmi/id/board_version")
+		}
+	}
+
+	board.Family = boardFamily(board.Vendor, board.Model)
+	switch board.Family {
+	case BoardRaspberryPi:
+		if soc := raspberryPiSoC(board.Revision); soc != "" {
+			board.SoC = soc
+		}
+	case BoardJetson:
+		if release, err := os.ReadFile("/etc/nv_tegra_release"); err == nil {
+			if match := tegraReleasePattern.FindSubmatch(release); match != nil {
+				board.Revision = "R" + string(match[1]) + "." + string(match[2])
+			}
+		}
+	}
+	return board
+}
+
+// readDeviceTree reads a string property of the device tree root,
+// joining string lists with NULs
+func readDeviceTree(property string) string {
+	data, err := os.ReadFile("/proc/device-tree/" + property)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\x00\n")
+}
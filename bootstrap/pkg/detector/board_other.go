@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package detector
+
+// detectBoard is not supported on this platform
+func detectBoard() BoardInfo {
+	return BoardInfo{}
+}
@@ -0,0 +1,33 @@
+package detector
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// detectBoard reads the SMBIOS system and board names the firmware
+// reported at boot
+func detectBoard() BoardInfo {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\BIOS`, registry.QUERY_VALUE)
+	if err != nil {
+		return BoardInfo{}
+	}
+	defer key.Close()
+
+	value := func(name string) string {
+		s, _, err := key.GetStringValue(name)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(s)
+	}
+
+	board := BoardInfo{
+		Vendor:   value("SystemManufacturer"),
+		Model:    value("SystemProductName"),
+		Revision: value("BaseBoardVersion"),
+	}
+	board.Family = boardFamily(board.Vendor, board.Model)
+	return board
+}
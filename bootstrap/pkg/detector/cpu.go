@@ -0,0 +1,69 @@
+package detector
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// C libraries reported in SystemInfo.Libc
+const (
+	LibcGlibc = "glibc"
+	LibcMusl  = "musl"
+)
+
+// cpuArch returns the architecture binaries must be built for. On 32-bit
+// ARM the kernel's machine name tells ARMv6 from ARMv7, which a single
+// GOARCH does not.
+func cpuArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "x86"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		switch armVersion() {
+		case 6:
+			return "armv6"
+		case 7, 8:
+			// A 64-bit kernel runs 32-bit ARMv7 code
+			return "armv7"
+		default:
+			return "arm"
+		}
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// cpuFeatures lists the instruction set extensions binaries are built
+// against
+func cpuFeatures() []string {
+	var features []string
+	add := func(present bool, name string) {
+		if present {
+			features = append(features, name)
+		}
+	}
+
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		add(cpu.X86.HasSSE42, "sse4_2")
+		add(cpu.X86.HasAVX, "avx")
+		add(cpu.X86.HasAVX2, "avx2")
+		add(cpu.X86.HasFMA, "fma")
+		add(cpu.X86.HasAVX512F, "avx512f")
+		add(cpu.X86.HasAES, "aes")
+	case "arm":
+		add(cpu.ARM.HasNEON, "neon")
+		add(cpu.ARM.HasVFPv4, "vfpv4")
+	case "arm64":
+		// Advanced SIMD is NEON on AArch64
+		add(cpu.ARM64.HasASIMD, "neon")
+		add(cpu.ARM64.HasAES, "aes")
+		add(cpu.ARM64.HasASIMDDP, "dotprod")
+	}
+	return features
+}
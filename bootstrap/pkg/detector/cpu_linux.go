@@ -0,0 +1,43 @@
+package detector
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// armVersion returns the ARM architecture version of the running kernel
+// from its machine name, e.g. armv7l, or 0 on other architectures
+func armVersion() int {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return 0
+	}
+	machine := unix.ByteSliceToString(uts.Machine[:])
+	switch {
+	case machine == "aarch64" || strings.HasPrefix(machine, "armv8"):
+		return 8
+	case strings.HasPrefix(machine, "armv7"):
+		return 7
+	case strings.HasPrefix(machine, "armv6"):
+		return 6
+	case strings.HasPrefix(machine, "armv5"):
+		return 5
+	default:
+		return 0
+	}
+}
+
+// detectLibc tells musl from glibc systems by their dynamic loader
+func detectLibc() string {
+	if musl, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(musl) > 0 {
+		return LibcMusl
+	}
+	for _, pattern := range []string{"/lib*/ld-linux*.so.*", "/lib/*-linux-gnu*/ld-linux*.so.*", "/lib*/libc.so.6"} {
+		if glibc, _ := filepath.Glob(pattern); len(glibc) > 0 {
+			return LibcGlibc
+		}
+	}
+	return ""
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package detector
+
+// armVersion is only read from Linux kernels
+func armVersion() int {
+	return 0
+}
+
+// detectLibc returns "": binaries for this platform do not depend on a
+// choice of C library
+func detectLibc() string {
+	return ""
+}
@@ -3,21 +3,49 @@ package detector
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // SystemInfo represents detected system information
 type SystemInfo struct {
-	OS           string `json:"os"`
-	Version      string `json:"version"`
-	Architecture string `json:"architecture"`
-	Platform     string `json:"platform"`
+	OS           string   `json:"os"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"architecture"`
+	Platform     string   `json:"platform"`
 	Capabilities []string `json:"capabilities"`
+
+	// Probes holds the result of every capability probe, and ProbeErrors
+	// the probes that failed or timed out
+	Probes      map[string]Capability `json:"probes,omitempty"`
+	ProbeErrors map[string]string     `json:"probe_errors,omitempty"`
+
+	// OSVersion is the parsed Windows or macOS version
+	OSVersion OSVersion `json:"os_version"`
+
+	// Libc is "glibc" or "musl" on Linux
+	Libc string `json:"libc,omitempty"`
+
+	CPU         CPUInfo    `json:"cpu"`
+	MemoryBytes uint64     `json:"memory_bytes"`
+	Disks       []DiskInfo `json:"disks"`
+
+	Accelerators []Accelerator `json:"accelerators"`
+	Board        BoardInfo     `json:"board"`
+	Environment  Environment   `json:"environment"`
+	// InitSystem is the running init system, or empty if there is none,
+	// as in most containers
+	InitSystem string     `json:"init_system,omitempty"`
+	Filesystem Filesystem `json:"filesystem"`
 }
 
 // Detector detects system information
-type Detector struct{}
+type Detector struct {
+	paths        []string
+	probeTimeout time.Duration
+}
 
 // New creates a new detector
 func New() *Detector {
@@ -31,28 +59,47 @@ func (d *Detector) Detect() (*SystemInfo, error) {
 		Architecture: runtime.GOARCH,
 		Capabilities: []string{},
 	}
-	
+
 	// Detect platform
 	platform, err := d.detectPlatform()
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect platform: %w", err)
 	}
 	info.Platform = platform
-	
+
 	// Detect version
-	version, err := d.detectVersion()
+	info.OSVersion = osVersion()
+	version, err := d.detectVersion(info.OSVersion)
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect version: %w", err)
 	}
 	info.Version = version
-	
+
 	// Detect capabilities
-	capabilities, err := d.detectCapabilities()
+	capabilities, err := d.detectCapabilities(info)
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect capabilities: %w", err)
 	}
-	info.Capabilities = capabilities
-	
+	info.Capabilities = append(capabilities, info.OSVersion.capabilities()...)
+
+	// Detect processor, memory and disks
+	d.detectHardware(info)
+
+	// Detect GPUs and accelerators
+	d.detectAccelerators(info)
+
+	// Identify the board
+	d.detectBoard(info)
+
+	// Detect containers and virtual machines
+	d.detectEnvironment(info)
+
+	// Detect the init system
+	d.detectInitSystem(info)
+
+	// Detect read-only and image-based systems
+	d.detectFilesystem(info)
+
 	return info, nil
 }
 
@@ -73,14 +120,14 @@ func (d *Detector) detectPlatform() (string, error) {
 }
 
 // detectVersion detects the OS version
-func (d *Detector) detectVersion() (string, error) {
+func (d *Detector) detectVersion(osVersion OSVersion) (string, error) {
 	switch runtime.GOOS {
 	case "linux":
 		return d.detectLinuxVersion()
 	case "windows":
-		return d.detectWindowsVersion()
+		return d.detectWindowsVersion(osVersion)
 	case "darwin":
-		return d.detectMacOSVersion()
+		return d.detectMacOSVersion(osVersion)
 	default:
 		return "unknown", nil
 	}
@@ -97,119 +144,94 @@ func (d *Detector) detectLinuxVersion() (string, error) {
 			}
 		}
 	}
-	
+
 	// Fallback to uname
 	return "Linux", nil
 }
 
-// detectWindowsVersion detects Windows version
-func (d *Detector) detectWindowsVersion() (string, error) {
-	// This would use Windows API to detect version
-	// For now, return a placeholder
-	return "Windows", nil
+// detectWindowsVersion describes the Windows version, edition and build
+func (d *Detector) detectWindowsVersion(osVersion OSVersion) (string, error) {
+	if osVersion.Major == 0 {
+		return "Windows", nil
+	}
+	return osVersion.windowsName(), nil
 }
 
-// detectMacOSVersion detects macOS version
-func (d *Detector) detectMacOSVersion() (string, error) {
-	// This would use system_profiler or similar
-	// For now, return a placeholder
-	return "macOS", nil
+// detectMacOSVersion describes the macOS version
+func (d *Detector) detectMacOSVersion(osVersion OSVersion) (string, error) {
+	if osVersion.Major == 0 {
+		return "macOS", nil
+	}
+	return osVersion.macOSName(), nil
 }
 
-// detectCapabilities detects system capabilities
-func (d *Detector) detectCapabilities() ([]string, error) {
+// detectCapabilities detects system capabilities. The platform probes
+// and every registered probe run concurrently.
+func (d *Detector) detectCapabilities(info *SystemInfo) ([]string, error) {
 	capabilities := []string{
 		"file_system",
 		"process_management",
 		"network",
 	}
-	
+
 	// Platform-specific capabilities
+	var probes []namedProbe
 	switch runtime.GOOS {
 	case "linux":
-		capabilities = append(capabilities, d.detectLinuxCapabilities()...)
+		probes = d.linuxProbes()
 	case "windows":
-		capabilities = append(capabilities, d.detectWindowsCapabilities()...)
+		capabilities = append(capabilities, "powershell", "registry_access")
+		probes = d.windowsProbes()
 	case "darwin":
-		capabilities = append(capabilities, d.detectMacOSCapabilities()...)
+		capabilities = append(capabilities, "homebrew")
+		probes = d.macOSProbes()
 	}
-	
-	return capabilities, nil
+
+	// Probes registered by other packages
+	probes = append(probes, registeredProbes()...)
+
+	return append(capabilities, d.runProbes(info, probes)...), nil
 }
 
-// detectLinuxCapabilities detects Linux-specific capabilities
-func (d *Detector) detectLinuxCapabilities() []string {
-	capabilities := []string{}
-	
-	// Check for package managers
-	if d.hasCommand("apt") {
-		capabilities = append(capabilities, "apt_package_manager")
-	}
-	if d.hasCommand("yum") {
-		capabilities = append(capabilities, "yum_package_manager")
-	}
-	if d.hasCommand("dnf") {
-		capabilities = append(capabilities, "dnf_package_manager")
-	}
-	if d.hasCommand("pacman") {
-		capabilities = append(capabilities, "pacman_package_manager")
-	}
-	
-	// Check for systemd
-	if d.hasFile("/etc/systemd") {
-		capabilities = append(capabilities, "systemd")
-	}
-	
-	// Check for root access
-	if os.Geteuid() == 0 {
-		capabilities = append(capabilities, "root_access")
+// linuxProbes returns the Linux-specific capability probes
+func (d *Detector) linuxProbes() []namedProbe {
+	return []namedProbe{
+		// Package managers
+		{"apt_package_manager", d.commandProbe("apt")},
+		{"yum_package_manager", d.commandProbe("yum")},
+		{"dnf_package_manager", d.commandProbe("dnf")},
+		{"pacman_package_manager", d.commandProbe("pacman")},
+
+		{"systemd", d.fileProbe("/run/systemd/system")},
+		{"root_access", d.adminProbe()},
 	}
-	
-	return capabilities
 }
 
-// detectWindowsCapabilities detects Windows-specific capabilities
-func (d *Detector) detectWindowsCapabilities() []string {
-	capabilities := []string{
-		"powershell",
-		"registry_access",
-	}
-	
-	// Check for package managers
-	if d.hasCommand("choco") {
-		capabilities = append(capabilities, "chocolatey_package_manager")
-	}
-	if d.hasCommand("winget") {
-		capabilities = append(capabilities, "winget_package_manager")
-	}
-	
-	// Check for administrator access
-	if d.isAdministrator() {
-		capabilities = append(capabilities, "administrator_access")
+// windowsProbes returns the Windows-specific capability probes
+func (d *Detector) windowsProbes() []namedProbe {
+	return []namedProbe{
+		// Package managers
+		{"chocolatey_package_manager", d.commandProbe("choco")},
+		{"winget_package_manager", d.commandProbe("winget")},
+
+		{"administrator_access", d.adminProbe()},
 	}
-	
-	return capabilities
 }
 
-// detectMacOSCapabilities detects macOS-specific capabilities
-func (d *Detector) detectMacOSCapabilities() []string {
-	capabilities := []string{
-		"homebrew",
+// macOSProbes returns the macOS-specific capability probes
+func (d *Detector) macOSProbes() []namedProbe {
+	return []namedProbe{
+		{"homebrew_package_manager", d.commandProbe("brew")},
+		{"administrator_access", d.adminProbe()},
 	}
-	
-	// Check for Homebrew
-	if d.hasCommand("brew") {
-		capabilities = append(capabilities, "homebrew_package_manager")
-	}
-	
-	return capabilities
 }
 
-// hasCommand checks if a command exists
+// hasCommand checks if a command exists in PATH. On Windows the
+// extensions in PATHEXT are tried. Commands only found relative to the
+// current directory do not count.
 func (d *Detector) hasCommand(cmd string) bool {
-	// This would check if command exists in PATH
-	// Simplified implementation
-	return false
+	_, err := exec.LookPath(cmd)
+	return err == nil
 }
 
 // hasFile checks if a file exists
@@ -218,9 +240,8 @@ func (d *Detector) hasFile(path string) bool {
 	return err == nil
 }
 
-// isAdministrator checks if running as administrator
+// isAdministrator checks if running as administrator: elevated on
+// Windows, root or a member of the admin group on macOS, root elsewhere
 func (d *Detector) isAdministrator() bool {
-	// This would check for administrator privileges
-	// Simplified implementation
-	return false
+	return hasAdminRights()
 }
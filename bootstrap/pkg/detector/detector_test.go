@@ -0,0 +1,127 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeCommand creates a file standing in for a command in dir. On
+// Windows what runs is decided by the extension, elsewhere by the mode.
+func fakeCommand(t *testing.T, dir, name string, executable bool) {
+	t.Helper()
+	mode := os.FileMode(0644)
+	if executable {
+		mode = 0755
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakePath puts directories holding the fake commands on PATH, in order
+func fakePath(t *testing.T, dirs ...[]string) {
+	t.Helper()
+	var path []string
+	for _, commands := range dirs {
+		dir := t.TempDir()
+		for _, name := range commands {
+			fakeCommand(t, dir, name, true)
+		}
+		path = append(path, dir)
+	}
+	t.Setenv("PATH", strings.Join(path, string(os.PathListSeparator)))
+	if runtime.GOOS == "windows" {
+		t.Setenv("PATHEXT", ".COM;.EXE;.BAT;.CMD")
+	}
+}
+
+func TestHasCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		// path are the commands in each directory on PATH
+		path [][]string
+		cmd  string
+		want bool
+	}{
+		{"on PATH", [][]string{{"apt"}}, "apt", true},
+		{"in a later directory", [][]string{{"yum"}, {"dnf"}}, "dnf", true},
+		{"missing", [][]string{{"apt"}}, "pacman", false},
+		{"empty PATH", nil, "apt", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakePath(t, test.path...)
+			if got := New().hasCommand(test.cmd); got != test.want {
+				t.Errorf("hasCommand(%q) = %v, want %v", test.cmd, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasCommandNotExecutable(t *testing.T) {
+	dir := t.TempDir()
+	fakeCommand(t, dir, "brew", false)
+	if err := os.Mkdir(filepath.Join(dir, "choco"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	d := New()
+	for _, cmd := range []string{"brew", "choco"} {
+		if d.hasCommand(cmd) {
+			t.Errorf("hasCommand(%q) = true for a file that cannot run", cmd)
+		}
+	}
+}
+
+func TestDetectPackageManagers(t *testing.T) {
+	tests := map[string]struct {
+		commands []string
+		want     []string
+		notWant  []string
+	}{
+		"linux": {
+			commands: []string{"apt", "dnf"},
+			want:     []string{"apt_package_manager", "dnf_package_manager"},
+			notWant:  []string{"yum_package_manager", "pacman_package_manager"},
+		},
+		"windows": {
+			commands: []string{"winget"},
+			want:     []string{"winget_package_manager"},
+			notWant:  []string{"chocolatey_package_manager"},
+		},
+		"darwin": {
+			commands: []string{"brew"},
+			want:     []string{"homebrew_package_manager"},
+		},
+	}
+	test, ok := tests[runtime.GOOS]
+	if !ok {
+		t.Skipf("no package managers are detected on %s", runtime.GOOS)
+	}
+	fakePath(t, test.commands)
+
+	info := &SystemInfo{}
+	capabilities, err := New().detectCapabilities(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range test.want {
+		if !slices.Contains(capabilities, name) || !info.Probes[name].Present {
+			t.Errorf("%s not detected in %v", name, capabilities)
+		}
+	}
+	for _, name := range test.notWant {
+		if slices.Contains(capabilities, name) {
+			t.Errorf("%s detected without its command", name)
+		}
+	}
+}
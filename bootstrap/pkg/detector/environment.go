@@ -0,0 +1,87 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Container runtimes reported in Environment.Container
+const (
+	ContainerDocker = "docker"
+	ContainerPodman = "podman"
+	ContainerLXC    = "lxc"
+	// ContainerOther is a container whose runtime is not recognised
+	ContainerOther = "container"
+)
+
+// Hypervisors reported in Environment.Hypervisor
+const (
+	HypervisorKVM        = "kvm"
+	HypervisorHyperV     = "hyperv"
+	HypervisorVMware     = "vmware"
+	HypervisorVirtualBox = "virtualbox"
+	HypervisorXen        = "xen"
+	// HypervisorOther is a virtual machine whose hypervisor is not
+	// recognised
+	HypervisorOther = "other"
+)
+
+// Environment describes the container or virtual machine the bootstrap
+// runs in
+type Environment struct {
+	// Container is the container runtime, or empty on a host or VM
+	Container  string `json:"container,omitempty"`
+	Kubernetes bool   `json:"kubernetes,omitempty"`
+	// WSL is 1 or 2 under the Windows Subsystem for Linux
+	WSL        int    `json:"wsl,omitempty"`
+	Hypervisor string `json:"hypervisor,omitempty"`
+}
+
+// InContainer reports whether the bootstrap runs in a container
+func (e Environment) InContainer() bool {
+	return e.Container != "" || e.Kubernetes
+}
+
+// detectEnvironment fills in the environment and adds a capability for
+// it, e.g. container, container_docker, wsl2 or virtual_machine
+func (d *Detector) detectEnvironment(info *SystemInfo) {
+	env := detectEnvironment()
+	info.Environment = env
+
+	if env.InContainer() {
+		info.Capabilities = append(info.Capabilities, "container")
+	}
+	if env.Container != "" && env.Container != ContainerOther {
+		info.Capabilities = append(info.Capabilities, "container_"+env.Container)
+	}
+	if env.Kubernetes {
+		info.Capabilities = append(info.Capabilities, "kubernetes")
+	}
+	if env.WSL > 0 {
+		info.Capabilities = append(info.Capabilities, "wsl", fmt.Sprintf("wsl%d", env.WSL))
+	}
+	if env.Hypervisor != "" {
+		info.Capabilities = append(info.Capabilities, "virtual_machine")
+	}
+}
+
+// hypervisorFromDMI recognises a hypervisor from the system vendor and
+// product names the firmware reports
+func hypervisorFromDMI(vendor, product string) string {
+	lower := strings.ToLower(vendor + " " + product)
+	switch {
+	case strings.Contains(lower, "vmware"):
+		return HypervisorVMware
+	case strings.Contains(lower, "virtualbox") || strings.Contains(lower, "innotek"):
+		return HypervisorVirtualBox
+	case strings.Contains(lower, "microsoft") && strings.Contains(lower, "virtual machine"):
+		return HypervisorHyperV
+	case strings.Contains(lower, "xen"):
+		return HypervisorXen
+	case strings.Contains(lower, "qemu") || strings.Contains(lower, "kvm") ||
+		strings.Contains(lower, "amazon ec2") || strings.Contains(lower, "google compute engine"):
+		return HypervisorKVM
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,95 @@
+package detector
+
+import (
+	"os"
+	"strings"
+)
+
+// detectEnvironment looks for the marker files container runtimes leave
+// behind, the cgroups of PID 1, the WSL kernel and DMI data
+func detectEnvironment() Environment {
+	var env Environment
+
+	env.Container = detectContainer()
+	env.Kubernetes = os.Getenv("KUBERNETES_SERVICE_HOST") != "" ||
+		strings.Contains(readTrimmed("/proc/1/cgroup"), "kubepods")
+	env.WSL = detectWSL()
+	if env.Container == "" && env.WSL == 0 {
+		env.Hypervisor = detectHypervisor()
+	}
+	return env
+}
+
+// detectContainer returns the container runtime, or "" outside one
+func detectContainer() string {
+	// systemd and Podman record the container type for PID 1
+	if container := readTrimmed("/run/systemd/container"); container != "" {
+		switch container {
+		case ContainerDocker, ContainerPodman, ContainerLXC:
+			return container
+		case "lxc-libvirt":
+			return ContainerLXC
+		default:
+			return ContainerOther
+		}
+	}
+
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return ContainerPodman
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return ContainerDocker
+	}
+
+	cgroup := readTrimmed("/proc/1/cgroup")
+	switch {
+	case strings.Contains(cgroup, "/docker"):
+		return ContainerDocker
+	case strings.Contains(cgroup, "libpod"):
+		return ContainerPodman
+	case strings.Contains(cgroup, "/lxc"):
+		return ContainerLXC
+	case strings.Contains(cgroup, "kubepods"), strings.Contains(cgroup, "containerd"):
+		return ContainerOther
+	}
+
+	if _, err := os.Stat("/dev/lxd/sock"); err == nil {
+		return ContainerLXC
+	}
+	return ""
+}
+
+// detectWSL returns 1 or 2 under the Windows Subsystem for Linux. WSL 2
+// runs a real Linux kernel whose release names it; WSL 1 emulates one
+// whose release only mentions Microsoft.
+func detectWSL() int {
+	release := readTrimmed("/proc/sys/kernel/osrelease")
+	switch {
+	case strings.Contains(release, "WSL2") || strings.Contains(release, "microsoft-standard"):
+		return 2
+	case strings.Contains(release, "Microsoft"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// detectHypervisor recognises the hypervisor from DMI data, falling back
+// to the hypervisor CPU flag
+func detectHypervisor() string {
+	if readTrimmed("/sys/hypervisor/type") == "xen" {
+		return HypervisorXen
+	}
+	vendor := readTrimmed("/sys/class/dmi/id/sys_vendor")
+	product := readTrimmed("/sys/class/dmi/id/product_name")
+	if hypervisor := hypervisorFromDMI(vendor, product); hypervisor != "" {
+		return hypervisor
+	}
+
+	for _, flag := range strings.Fields(readKeyValues("/proc/cpuinfo")["flags"]) {
+		if flag == "hypervisor" {
+			return HypervisorOther
+		}
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package detector
+
+// detectEnvironment is not supported on this platform
+func detectEnvironment() Environment {
+	return Environment{}
+}
@@ -0,0 +1,34 @@
+package detector
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// detectEnvironment recognises Windows containers by their built-in
+// account and virtual machines by the SMBIOS names
+func detectEnvironment() Environment {
+	var env Environment
+
+	if strings.EqualFold(os.Getenv("USERNAME"), "ContainerAdministrator") ||
+		strings.EqualFold(os.Getenv("USERNAME"), "ContainerUser") {
+		env.Container = ContainerDocker
+	}
+	env.Kubernetes = os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+	if env.Container != "" {
+		return env
+	}
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\BIOS`, registry.QUERY_VALUE)
+	if err != nil {
+		return env
+	}
+	defer key.Close()
+
+	vendor, _, _ := key.GetStringValue("SystemManufacturer")
+	product, _, _ := key.GetStringValue("SystemProductName")
+	env.Hypervisor = hypervisorFromDMI(vendor, product)
+	return env
+}
@@ -0,0 +1,59 @@
+package detector
+
+import "runtime"
+
+// Image-based distribution families reported in Filesystem.Immutable
+const (
+	// ImmutableOSTree covers Fedora Silverblue, Kinoite, CoreOS and other
+	// OSTree-deployed systems
+	ImmutableOSTree = "ostree"
+	ImmutableNixOS  = "nixos"
+)
+
+// Filesystem describes whether and where the system can be written to
+type Filesystem struct {
+	// RootType is the type of the root file system, e.g. ext4, overlay
+	// or apfs
+	RootType     string `json:"root_type,omitempty"`
+	RootReadOnly bool   `json:"root_read_only,omitempty"`
+	// UsrReadOnly is set if /usr cannot be written, as on image-based
+	// systems, even when the root file system can
+	UsrReadOnly bool `json:"usr_read_only,omitempty"`
+	// Immutable names the image-based distribution family, or is empty
+	Immutable string `json:"immutable,omitempty"`
+	// Sysext is set if systemd-sysext can merge system extensions into
+	// /usr
+	Sysext bool `json:"sysext,omitempty"`
+}
+
+// detectFilesystem fills in the root file system and image-based system
+// details and adds them as capabilities: readonly_root, overlay_root,
+// immutable_<family> and sysext. It needs the init system.
+func (d *Detector) detectFilesystem(info *SystemInfo) {
+	fs := &info.Filesystem
+	if root, err := diskUsage(rootPath()); err == nil {
+		fs.RootType = root.Type
+		fs.RootReadOnly = root.ReadOnly
+	}
+
+	if runtime.GOOS == "linux" {
+		if usr, err := diskUsage("/usr"); err == nil {
+			fs.UsrReadOnly = usr.ReadOnly
+		}
+		fs.Immutable = immutableSystem()
+		fs.Sysext = info.InitSystem == InitSystemd && d.hasCommand("systemd-sysext")
+	}
+
+	if fs.RootReadOnly {
+		info.Capabilities = append(info.Capabilities, "readonly_root")
+	}
+	if fs.RootType == "overlay" {
+		info.Capabilities = append(info.Capabilities, "overlay_root")
+	}
+	if fs.Immutable != "" {
+		info.Capabilities = append(info.Capabilities, "immutable_"+fs.Immutable)
+	}
+	if fs.Sysext {
+		info.Capabilities = append(info.Capabilities, "sysext")
+	}
+}
@@ -0,0 +1,26 @@
+package detector
+
+import (
+	"os"
+	"strings"
+)
+
+// immutableSystem recognises image-based distributions by the markers
+// they leave at runtime
+func immutableSystem() string {
+	// Written by ostree-prepare-root when booted from a deployment
+	if _, err := os.Stat("/run/ostree-booted"); err == nil {
+		return ImmutableOSTree
+	}
+	if _, err := os.Stat("/etc/NIXOS"); err == nil {
+		return ImmutableNixOS
+	}
+	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if id, ok := strings.CutPrefix(line, "ID="); ok && strings.Trim(id, `"`) == "nixos" {
+				return ImmutableNixOS
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package detector
+
+// immutableSystem is only detected on Linux
+func immutableSystem() string {
+	return ""
+}
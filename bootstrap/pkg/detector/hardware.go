@@ -0,0 +1,135 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Storage media reported in DiskInfo.Medium
+const (
+	MediumEMMC    = "emmc"
+	MediumSD      = "sd"
+	MediumNVMe    = "nvme"
+	MediumSSD     = "ssd"
+	MediumHDD     = "hdd"
+	MediumUnknown = "unknown"
+)
+
+// CPUInfo describes the processor
+type CPUInfo struct {
+	Model string `json:"model"`
+	Cores int    `json:"cores"`
+	// Arch is the architecture binaries must be built for: x86_64, x86,
+	// armv6, armv7, aarch64 or the Go architecture name
+	Arch string `json:"arch"`
+	// Features lists the instruction set extensions binaries may use,
+	// e.g. sse4_2, avx2 or neon
+	Features []string `json:"features,omitempty"`
+}
+
+// DiskInfo describes the file system holding some of the inspected paths
+type DiskInfo struct {
+	Mount      string   `json:"mount"`
+	Device     string   `json:"device,omitempty"`
+	Paths      []string `json:"paths"`
+	TotalBytes uint64   `json:"total_bytes"`
+	FreeBytes  uint64   `json:"free_bytes"`
+	Medium     string   `json:"medium"`
+	// Type is the file system type, e.g. ext4, overlay or apfs
+	Type     string `json:"type,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// SetPaths sets the paths whose file systems are inspected, such as the
+// install and data directories. Paths that do not exist yet are looked up
+// through their nearest existing parent. The root file system is
+// inspected when none are set.
+func (d *Detector) SetPaths(paths []string) {
+	d.paths = paths
+}
+
+// Disk returns the inspected file system holding a path, or nil
+func (info *SystemInfo) Disk(path string) *DiskInfo {
+	for n := range info.Disks {
+		for _, p := range info.Disks[n].Paths {
+			if p == path {
+				return &info.Disks[n]
+			}
+		}
+	}
+	return nil
+}
+
+// detectHardware fills in the processor, memory and disks. Values that
+// cannot be read are left zero or unknown rather than failing detection.
+func (d *Detector) detectHardware(info *SystemInfo) {
+	info.CPU = CPUInfo{
+		Model:    cpuModel(),
+		Cores:    runtime.NumCPU(),
+		Arch:     cpuArch(),
+		Features: cpuFeatures(),
+	}
+	info.MemoryBytes = totalMemory()
+	if info.Libc = detectLibc(); info.Libc != "" {
+		info.Capabilities = append(info.Capabilities, info.Libc)
+	}
+
+	paths := d.paths
+	if len(paths) == 0 {
+		paths = []string{rootPath()}
+	}
+
+	for _, path := range paths {
+		usage, err := DiskUsage(path)
+		if err != nil {
+			continue
+		}
+
+		if disk := findMount(info.Disks, usage.Mount); disk != nil {
+			disk.Paths = append(disk.Paths, path)
+			continue
+		}
+		info.Disks = append(info.Disks, *usage)
+	}
+}
+
+// DiskUsage inspects the file system holding a path, looking up paths
+// that do not exist yet through their nearest existing parent
+func DiskUsage(path string) (*DiskInfo, error) {
+	usage, err := diskUsage(existingPath(path))
+	if err != nil {
+		return nil, err
+	}
+	usage.Paths = []string{path}
+	usage.Medium = storageMedium(usage.Device)
+	return usage, nil
+}
+
+// findMount returns the disk mounted at mount, or nil
+func findMount(disks []DiskInfo, mount string) *DiskInfo {
+	for n := range disks {
+		if disks[n].Mount == mount {
+			return &disks[n]
+		}
+	}
+	return nil
+}
+
+// existingPath returns path, or its nearest parent that exists
+func existingPath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
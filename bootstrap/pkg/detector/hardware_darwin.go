@@ -0,0 +1,50 @@
+package detector
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// cpuModel reads the processor name from sysctl
+func cpuModel() string {
+	model, err := unix.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return ""
+	}
+	return model
+}
+
+// totalMemory reads the physical memory size from sysctl
+func totalMemory() uint64 {
+	size, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// rootPath is inspected when no paths are set
+func rootPath() string {
+	return "/"
+}
+
+// diskUsage returns the size, free space, mount point, device and type
+// of the file system holding path
+func diskUsage(path string) (*DiskInfo, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &DiskInfo{
+		Mount:      unix.ByteSliceToString(st.Mntonname[:]),
+		Device:     unix.ByteSliceToString(st.Mntfromname[:]),
+		TotalBytes: st.Blocks * uint64(st.Bsize),
+		FreeBytes:  st.Bavail * uint64(st.Bsize),
+		Type:       unix.ByteSliceToString(st.Fstypename[:]),
+		ReadOnly:   st.Flags&unix.MNT_RDONLY != 0,
+	}, nil
+}
+
+// storageMedium is not detected on macOS
+func storageMedium(device string) string {
+	return MediumUnknown
+}
@@ -0,0 +1,208 @@
+package detector
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// cpuModelKeys are the /proc/cpuinfo fields naming the processor, in
+// order of preference; ARM boards only report Hardware or Model
+var cpuModelKeys = []string{"model name", "cpu model", "Hardware", "Model"}
+
+// cpuModel reads the processor name from /proc/cpuinfo
+func cpuModel() string {
+	fields := readKeyValues("/proc/cpuinfo")
+	for _, key := range cpuModelKeys {
+		if value := fields[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// totalMemory reads MemTotal from /proc/meminfo
+func totalMemory() uint64 {
+	value := readKeyValues("/proc/meminfo")["MemTotal"]
+	kb, err := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return kb * 1024
+}
+
+// readKeyValues parses "key : value" lines, keeping the first value of
+// each key
+func readKeyValues(path string) map[string]string {
+	values := map[string]string{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if _, seen := values[key]; !seen {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// rootPath is inspected when no paths are set
+func rootPath() string {
+	return "/"
+}
+
+// diskUsage returns the size and free space of the file system holding
+// path, with its mount point, block device and type from
+// /proc/self/mountinfo
+func diskUsage(path string) (*DiskInfo, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	disk := &DiskInfo{
+		Mount:      "/",
+		TotalBytes: st.Blocks * uint64(st.Bsize),
+		FreeBytes:  st.Bavail * uint64(st.Bsize),
+		ReadOnly:   st.Flags&unix.ST_RDONLY != 0,
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if mount, ok := findMountInfo(path); ok {
+		disk.Mount = mount.point
+		disk.Device = mount.device
+		disk.Type = mount.fsType
+	}
+	return disk, nil
+}
+
+// mountEntry is a line of /proc/self/mountinfo
+type mountEntry struct {
+	point  string
+	device string
+	fsType string
+}
+
+// findMountInfo returns the mount holding path, choosing the longest
+// matching mount point
+func findMountInfo(path string) (mountEntry, bool) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return mountEntry{}, false
+	}
+	defer file.Close()
+
+	var mount mountEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// id parent major:minor root mount-point options [optional...] -
+		// type source super-options
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		point := unescapeMount(fields[4])
+		if !isUnder(path, point) || len(point) < len(mount.point) {
+			continue
+		}
+		mount = mountEntry{point: point, device: fields[2]}
+		for n := 5; n+1 < len(fields); n++ {
+			if fields[n] == "-" {
+				mount.fsType = fields[n+1]
+				break
+			}
+		}
+	}
+	return mount, mount.point != ""
+}
+
+// isUnder reports whether path is mount or inside it
+func isUnder(path, mount string) bool {
+	if mount == "/" || path == mount {
+		return true
+	}
+	return strings.HasPrefix(path, mount+"/")
+}
+
+// unescapeMount decodes the octal escapes mountinfo uses for spaces,
+// tabs, newlines and backslashes
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for n := 0; n < len(s); n++ {
+		if s[n] == '\\' && n+3 < len(s) {
+			if code, err := strconv.ParseUint(s[n+1:n+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				n += 3
+				continue
+			}
+		}
+		b.WriteByte(s[n])
+	}
+	return b.String()
+}
+
+// storageMedium classifies the disk behind a major:minor device number
+// using sysfs. Partitions and device mapper volumes are followed to the
+// disk they live on.
+func storageMedium(device string) string {
+	if device == "" {
+		return MediumUnknown
+	}
+	sys, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", device))
+	if err != nil {
+		return MediumUnknown
+	}
+
+	// LVM, LUKS and other device mapper volumes: classify the first
+	// device underneath
+	if slaves, err := os.ReadDir(filepath.Join(sys, "slaves")); err == nil && len(slaves) > 0 {
+		if sys, err = filepath.EvalSymlinks(filepath.Join(sys, "slaves", slaves[0].Name())); err != nil {
+			return MediumUnknown
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sys, "partition")); err == nil {
+		sys = filepath.Dir(sys)
+	}
+
+	name := filepath.Base(sys)
+	switch {
+	case strings.HasPrefix(name, "mmcblk"):
+		cardType, _ := os.ReadFile(filepath.Join(sys, "device", "type"))
+		switch strings.TrimSpace(string(cardType)) {
+		case "MMC":
+			return MediumEMMC
+		case "SD":
+			return MediumSD
+		}
+		return MediumUnknown
+	case strings.HasPrefix(name, "nvme"):
+		return MediumNVMe
+	}
+
+	rotational, err := os.ReadFile(filepath.Join(sys, "queue", "rotational"))
+	if err != nil {
+		return MediumUnknown
+	}
+	if strings.TrimSpace(string(rotational)) == "1" {
+		return MediumHDD
+	}
+	return MediumSSD
+}
@@ -0,0 +1,30 @@
+//go:build !linux && !darwin && !windows
+
+package detector
+
+import "errors"
+
+// cpuModel is not detected on this platform
+func cpuModel() string {
+	return ""
+}
+
+// totalMemory is not detected on this platform
+func totalMemory() uint64 {
+	return 0
+}
+
+// rootPath is inspected when no paths are set
+func rootPath() string {
+	return "/"
+}
+
+// diskUsage is not supported on this platform
+func diskUsage(path string) (*DiskInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// storageMedium is not detected on this platform
+func storageMedium(device string) string {
+	return MediumUnknown
+}
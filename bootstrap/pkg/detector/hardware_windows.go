@@ -0,0 +1,89 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// procGlobalMemoryStatusEx is not wrapped by x/sys/windows
+var procGlobalMemoryStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+// memoryStatusEx is the MEMORYSTATUSEX structure
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// cpuModel reads the name of the first processor from the registry
+func cpuModel() string {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\CentralProcessor\0`, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+
+	model, _, err := key.GetStringValue("ProcessorNameString")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(model)
+}
+
+// totalMemory returns the physical memory size
+func totalMemory() uint64 {
+	status := memoryStatusEx{Length: uint32(unsafe.Sizeof(memoryStatusEx{}))}
+	if ok, _, _ := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); ok == 0 {
+		return 0
+	}
+	return status.TotalPhys
+}
+
+// rootPath is the system drive, inspected when no paths are set
+func rootPath() string {
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	return drive + `\`
+}
+
+// diskUsage returns the size, free space and file system type of the
+// volume holding path
+func diskUsage(path string) (*DiskInfo, error) {
+	volume := filepath.VolumeName(path) + `\`
+	name, err := windows.UTF16PtrFromString(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &free, &total, &totalFree); err != nil {
+		return nil, err
+	}
+	disk := &DiskInfo{Mount: volume, TotalBytes: total, FreeBytes: free}
+
+	var flags uint32
+	fsName := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumeInformation(name, nil, 0, nil, nil, &flags, &fsName[0], uint32(len(fsName))); err == nil {
+		disk.Type = strings.ToLower(windows.UTF16ToString(fsName))
+		disk.ReadOnly = flags&windows.FILE_READ_ONLY_VOLUME != 0
+	}
+	return disk, nil
+}
+
+// storageMedium is not detected on Windows
+func storageMedium(device string) string {
+	return MediumUnknown
+}
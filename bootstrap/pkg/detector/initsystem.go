@@ -0,0 +1,31 @@
+package detector
+
+import "runtime"
+
+// Init systems reported in SystemInfo.InitSystem
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitRunit   = "runit"
+	InitSysV    = "sysv"
+	InitLaunchd = "launchd"
+	// InitSCM is the Windows Service Control Manager
+	InitSCM = "scm"
+)
+
+// detectInitSystem fills in the running init system and adds it as a
+// capability, e.g. init_openrc
+func (d *Detector) detectInitSystem(info *SystemInfo) {
+	switch runtime.GOOS {
+	case "darwin":
+		info.InitSystem = InitLaunchd
+	case "windows":
+		info.InitSystem = InitSCM
+	default:
+		info.InitSystem = linuxInitSystem()
+	}
+
+	if info.InitSystem != "" {
+		info.Capabilities = append(info.Capabilities, "init_"+info.InitSystem)
+	}
+}
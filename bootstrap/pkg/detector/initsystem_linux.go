@@ -0,0 +1,41 @@
+package detector
+
+import "os"
+
+// linuxInitSystem recognises the init system running as PID 1. Installed
+// but unused init systems leave files behind, so only runtime state and
+// the name of PID 1 are trusted.
+func linuxInitSystem() string {
+	// sd_booted(3): systemd is running if this directory exists
+	if isDir("/run/systemd/system") {
+		return InitSystemd
+	}
+	if isDir("/run/openrc") {
+		return InitOpenRC
+	}
+
+	switch readTrimmed("/proc/1/comm") {
+	case "systemd":
+		return InitSystemd
+	case "openrc-init":
+		return InitOpenRC
+	case "runit", "runit-init":
+		return InitRunit
+	case "init":
+		// sysvinit, or the init of OpenRC or runit distributions that
+		// keep sysvinit as PID 1
+		switch {
+		case isDir("/run/runit") || isDir("/etc/runit/runsvdir"):
+			return InitRunit
+		case isDir("/etc/init.d"):
+			return InitSysV
+		}
+	}
+	return ""
+}
+
+// isDir reports whether path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
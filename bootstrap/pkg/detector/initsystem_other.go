@@ -0,0 +1,8 @@
+//go:build !linux
+
+package detector
+
+// linuxInitSystem is only used on Linux
+func linuxInitSystem() string {
+	return ""
+}
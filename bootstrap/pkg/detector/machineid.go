@@ -0,0 +1,15 @@
+package detector
+
+import "errors"
+
+// ErrNoMachineID is returned by MachineID when the operating system
+// keeps no identifier of the machine, or it cannot be read
+var ErrNoMachineID = errors.New("no machine identifier found")
+
+// MachineID returns the identifier the operating system keeps for the
+// machine: /etc/machine-id on Linux, the IOPlatformUUID on macOS and the
+// MachineGuid on Windows. It is unique to an installation of the system
+// and should not be shown or sent as is; derive identifiers from it.
+func MachineID() (string, error) {
+	return machineID()
+}
@@ -0,0 +1,25 @@
+package detector
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+)
+
+// platformUUIDPattern finds the IOPlatformUUID in the output of ioreg
+var platformUUIDPattern = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineID reads the hardware UUID of the Mac from the I/O Registry
+func machineID() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", ErrNoMachineID
+	}
+	match := platformUUIDPattern.FindSubmatch(out)
+	if match == nil {
+		return "", ErrNoMachineID
+	}
+	return string(match[1]), nil
+}
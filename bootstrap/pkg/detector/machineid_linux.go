@@ -0,0 +1,25 @@
+package detector
+
+import (
+	"os"
+	"strings"
+)
+
+// machineIDFiles are where systemd and older D-Bus installations keep
+// the machine ID
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// machineID reads the machine ID. An empty file, as left in images that
+// are still to be booted for the first time, does not count.
+func machineID() (string, error) {
+	for _, path := range machineIDFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" && id != "uninitialized" {
+			return id, nil
+		}
+	}
+	return "", ErrNoMachineID
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package detector
+
+// machineID is not supported on this platform
+func machineID() (string, error) {
+	return "", ErrNoMachineID
+}
@@ -0,0 +1,23 @@
+package detector
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// machineID reads the MachineGuid Windows setup generates. The 64-bit
+// registry view is read so that a 32-bit bootstrap sees the same value.
+func machineID() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", ErrNoMachineID
+	}
+	defer key.Close()
+
+	guid, _, err := key.GetStringValue("MachineGuid")
+	if err != nil || strings.TrimSpace(guid) == "" {
+		return "", ErrNoMachineID
+	}
+	return strings.TrimSpace(guid), nil
+}
@@ -0,0 +1,156 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultProbeTimeout bounds each capability probe
+const DefaultProbeTimeout = 5 * time.Second
+
+// Capability is the result of a capability probe
+type Capability struct {
+	// Present adds the probe's name to SystemInfo.Capabilities
+	Present bool `json:"present"`
+	// Details describes what was found, e.g. the device of a dongle
+	Details string `json:"details,omitempty"`
+}
+
+// Probe checks the device for one capability. Probes run concurrently
+// and should return when ctx is done; a probe that does not is
+// abandoned.
+type Probe func(ctx context.Context) (Capability, error)
+
+// namedProbe is a probe and the capability it reports
+type namedProbe struct {
+	name  string
+	probe Probe
+}
+
+var (
+	probesMu sync.Mutex
+	probes   []namedProbe
+)
+
+// RegisterProbe adds a probe run by every detector, typically from an
+// init function. The name is the capability reported when the probe
+// finds it present, e.g. "has_zigbee_dongle". Registering a name again
+// replaces its probe.
+func RegisterProbe(name string, probe Probe) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+
+	for n := range probes {
+		if probes[n].name == name {
+			probes[n].probe = probe
+			return
+		}
+	}
+	probes = append(probes, namedProbe{name: name, probe: probe})
+}
+
+// registeredProbes returns a copy of the registered probes
+func registeredProbes() []namedProbe {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+
+	return append([]namedProbe(nil), probes...)
+}
+
+// SetProbeTimeout bounds each capability probe; 0 selects
+// DefaultProbeTimeout
+func (d *Detector) SetProbeTimeout(timeout time.Duration) {
+	d.probeTimeout = timeout
+}
+
+// runProbes runs the probes concurrently, records their results in info
+// and returns the capabilities found, in the order of the probes
+func (d *Detector) runProbes(info *SystemInfo, probes []namedProbe) []string {
+	timeout := d.probeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+
+	results := make([]Capability, len(probes))
+	errs := make([]error, len(probes))
+
+	var wg sync.WaitGroup
+	for n, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[n], errs[n] = runProbe(p.probe, timeout)
+		}()
+	}
+	wg.Wait()
+
+	var capabilities []string
+	info.Probes = make(map[string]Capability, len(probes))
+	for n, p := range probes {
+		if errs[n] != nil {
+			if info.ProbeErrors == nil {
+				info.ProbeErrors = make(map[string]string)
+			}
+			info.ProbeErrors[p.name] = errs[n].Error()
+			continue
+		}
+		info.Probes[p.name] = results[n]
+		if results[n].Present {
+			capabilities = append(capabilities, p.name)
+		}
+	}
+	return capabilities
+}
+
+// runProbe runs a probe with a timeout, recovering from panics so that a
+// faulty probe cannot take detection down
+func runProbe(probe Probe, timeout time.Duration) (Capability, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		capability Capability
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("probe panicked: %v", r)}
+			}
+		}()
+		capability, err := probe(ctx)
+		done <- result{capability, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.capability, r.err
+	case <-ctx.Done():
+		return Capability{}, errors.New("probe timed out")
+	}
+}
+
+// commandProbe finds a command in PATH
+func (d *Detector) commandProbe(cmd string) Probe {
+	return func(ctx context.Context) (Capability, error) {
+		return Capability{Present: d.hasCommand(cmd)}, nil
+	}
+}
+
+// fileProbe checks that a file exists
+func (d *Detector) fileProbe(path string) Probe {
+	return func(ctx context.Context) (Capability, error) {
+		return Capability{Present: d.hasFile(path)}, nil
+	}
+}
+
+// adminProbe checks for administrator rights
+func (d *Detector) adminProbe() Probe {
+	return func(ctx context.Context) (Capability, error) {
+		return Capability{Present: d.isAdministrator()}, nil
+	}
+}
@@ -0,0 +1,7 @@
+package detector
+
+// RemovableMounts returns where removable storage such as USB sticks and
+// SD card readers is mounted. Disks the system runs from are left out.
+func RemovableMounts() ([]string, error) {
+	return removableMounts()
+}
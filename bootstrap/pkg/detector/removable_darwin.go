@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// removableMounts lists the volumes under /Volumes that diskutil reports
+// as removable or external
+func removableMounts() ([]string, error) {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []string
+	for _, entry := range entries {
+		// The startup disk is a link to /
+		if !entry.IsDir() {
+			continue
+		}
+		point := filepath.Join("/Volumes", entry.Name())
+		out, err := exec.Command("diskutil", "info", point).Output()
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if (key == "Removable Media" && value == "Removable") || (key == "Device Location" && value == "External") {
+				mounts = append(mounts, point)
+				break
+			}
+		}
+	}
+	return mounts, nil
+}
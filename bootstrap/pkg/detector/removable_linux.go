@@ -0,0 +1,59 @@
+package detector
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// removableMounts lists the mounts in /proc/mounts of block devices that
+// sysfs flags removable or that are attached over USB
+func removableMounts() ([]string, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// source mount-point type options dump pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		point := unescapeMount(fields[1])
+		if point == "/" || isUnder(point, "/boot") || isUnder(point, "/usr") {
+			continue
+		}
+		if removableDevice(fields[0]) {
+			mounts = append(mounts, point)
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// removableDevice reports whether the disk holding a device node is
+// removable. Partitions are followed to their disk.
+func removableDevice(device string) bool {
+	node, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return false
+	}
+	sys, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", filepath.Base(node)))
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(sys, "partition")); err == nil {
+		sys = filepath.Dir(sys)
+	}
+
+	// USB disks often do not set the removable flag
+	if strings.Contains(sys, "/usb") {
+		return true
+	}
+	removable, err := os.ReadFile(filepath.Join(sys, "removable"))
+	return err == nil && strings.TrimSpace(string(removable)) == "1"
+}
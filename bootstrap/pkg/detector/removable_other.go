@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package detector
+
+import "errors"
+
+// removableMounts is not supported on this platform
+func removableMounts() ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
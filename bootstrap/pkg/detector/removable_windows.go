@@ -0,0 +1,29 @@
+package detector
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// removableMounts lists the drives Windows reports as removable
+func removableMounts() ([]string, error) {
+	drives, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []string
+	for n := 0; n < 26; n++ {
+		if drives&(1<<n) == 0 {
+			continue
+		}
+		root := string(rune('A'+n)) + `:\`
+		name, err := windows.UTF16PtrFromString(root)
+		if err != nil {
+			continue
+		}
+		if windows.GetDriveType(name) == windows.DRIVE_REMOVABLE {
+			mounts = append(mounts, root)
+		}
+	}
+	return mounts, nil
+}
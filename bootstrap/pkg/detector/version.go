@@ -0,0 +1,91 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OSVersion is the parsed version of Windows or macOS
+type OSVersion struct {
+	// Name is the product name, e.g. "Windows 11 Pro" or "macOS"
+	Name  string `json:"name,omitempty"`
+	Major int    `json:"major"`
+	Minor int    `json:"minor"`
+	// Patch is the build number on Windows, e.g. 22631 for 10.0.22631
+	Patch int `json:"patch"`
+	// Build is the full build, e.g. 22631.3007 on Windows or 23E224 on
+	// macOS
+	Build string `json:"build,omitempty"`
+	// Release is the Windows feature update, e.g. 23H2
+	Release string `json:"release,omitempty"`
+	// Edition is the Windows edition, e.g. Professional or ServerStandard
+	Edition string `json:"edition,omitempty"`
+	// Server is set on Windows Server, and ServerCore on its installs
+	// without a desktop
+	Server     bool `json:"server,omitempty"`
+	ServerCore bool `json:"server_core,omitempty"`
+	// AppleSilicon is set on Apple silicon Macs, and Rosetta when the
+	// bootstrap is an Intel binary translated by Rosetta 2
+	AppleSilicon bool `json:"apple_silicon,omitempty"`
+	Rosetta      bool `json:"rosetta,omitempty"`
+}
+
+// AtLeast reports whether the version is major.minor.patch or later
+func (v OSVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// capabilities returns the capabilities implied by the version
+func (v OSVersion) capabilities() []string {
+	var capabilities []string
+	if v.Server {
+		capabilities = append(capabilities, "windows_server")
+	}
+	if v.ServerCore {
+		capabilities = append(capabilities, "server_core")
+	}
+	if v.AppleSilicon {
+		capabilities = append(capabilities, "apple_silicon")
+	}
+	if v.Rosetta {
+		capabilities = append(capabilities, "rosetta")
+	}
+	return capabilities
+}
+
+// windowsName describes a Windows version, e.g. "Windows 11 Pro 23H2
+// (build 22631.3007)"
+func (v OSVersion) windowsName() string {
+	name := v.Name
+	if name == "" {
+		name = "Windows"
+	}
+	// Windows 11 still reports itself as Windows 10 in the registry
+	if v.Major == 10 && v.Patch >= 22000 && !v.Server {
+		name = strings.Replace(name, "Windows 10", "Windows 11", 1)
+	}
+	if v.Release != "" {
+		name += " " + v.Release
+	}
+
+	build := v.Build
+	if build == "" {
+		build = fmt.Sprint(v.Patch)
+	}
+	return fmt.Sprintf("%s (build %s)", name, build)
+}
+
+// macOSName describes a macOS version, e.g. "macOS 14.4.1"
+func (v OSVersion) macOSName() string {
+	version := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	if v.Patch > 0 {
+		version += fmt.Sprintf(".%d", v.Patch)
+	}
+	return "macOS " + version
+}
@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// osVersion reads the macOS version and the processor from sysctl,
+// falling back to sw_vers on releases before 10.13.4
+func osVersion() OSVersion {
+	v := OSVersion{Name: "macOS"}
+
+	product, err := unix.Sysctl("kern.osproductversion")
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, "sw_vers", "-productVersion").Output()
+		if err == nil {
+			product = strings.TrimSpace(string(out))
+		}
+	}
+	parts := strings.SplitN(product, ".", 3)
+	for n, field := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if n < len(parts) {
+			*field, _ = strconv.Atoi(parts[n])
+		}
+	}
+
+	v.Build, _ = unix.Sysctl("kern.osversion")
+	// hw.optional.arm64 is also set for processes translated by Rosetta
+	if arm64, err := unix.SysctlUint32("hw.optional.arm64"); err == nil && arm64 == 1 {
+		v.AppleSilicon = true
+	}
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		v.Rosetta = true
+	}
+	return v
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !darwin
+
+package detector
+
+// osVersion is only parsed on Windows and macOS
+func osVersion() OSVersion {
+	return OSVersion{}
+}
@@ -0,0 +1,52 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// verNTWorkstation is the OSVERSIONINFOEX product type of desktop Windows
+const verNTWorkstation = 1
+
+// osVersion reads the version from RtlGetVersion, which unlike
+// GetVersionEx is not capped by the application manifest, and the
+// edition and feature update from the registry
+func osVersion() OSVersion {
+	info := windows.RtlGetVersion()
+	v := OSVersion{
+		Major:  int(info.MajorVersion),
+		Minor:  int(info.MinorVersion),
+		Patch:  int(info.BuildNumber),
+		Server: info.ProductType != verNTWorkstation,
+	}
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return v
+	}
+	defer key.Close()
+
+	value := func(name string) string {
+		s, _, err := key.GetStringValue(name)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(s)
+	}
+
+	v.Name = value("ProductName")
+	v.Edition = value("EditionID")
+	// DisplayVersion replaced ReleaseId with 20H2
+	if v.Release = value("DisplayVersion"); v.Release == "" {
+		v.Release = value("ReleaseId")
+	}
+	v.Build = fmt.Sprint(v.Patch)
+	if ubr, _, err := key.GetIntegerValue("UBR"); err == nil {
+		v.Build += fmt.Sprintf(".%d", ubr)
+	}
+	v.ServerCore = value("InstallationType") == "Server Core"
+	return v
+}
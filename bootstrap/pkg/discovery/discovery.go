@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultTimeout is how long each protocol listens for answers unless
+// told otherwise
+const DefaultTimeout = 3 * time.Second
+
+// maxPacketSize bounds the answers read; mDNS packets may fill a jumbo
+// frame
+const maxPacketSize = 9000
+
+// Companion is a companion server advertised on the local network
+type Companion struct {
+	// Name is the instance name the companion advertises
+	Name string
+	// URL is the base URL of the companion
+	URL     string
+	Version string
+	// Via is the protocol it was found with, "mdns" or "ssdp"
+	Via string
+}
+
+// Discover searches the local network for companion servers advertised
+// over mDNS, and over SSDP when mDNS finds none. Each protocol listens
+// for answers for timeout, DefaultTimeout when zero. The companions are
+// returned sorted by name, each URL once.
+func Discover(ctx context.Context, timeout time.Duration) ([]Companion, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	found, mdnsErr := queryMDNS(ctx, timeout)
+	if len(found) == 0 && ctx.Err() == nil {
+		var ssdpErr error
+		found, ssdpErr = querySSDP(ctx, timeout)
+		if len(found) == 0 && mdnsErr != nil && ssdpErr != nil {
+			return nil, errors.Join(mdnsErr, ssdpErr)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var companions []Companion
+	for _, companion := range found {
+		if !seen[companion.URL] {
+			seen[companion.URL] = true
+			companions = append(companions, companion)
+		}
+	}
+	sort.Slice(companions, func(a, b int) bool {
+		if companions[a].Name != companions[b].Name {
+			return companions[a].Name < companions[b].Name
+		}
+		return companions[a].URL < companions[b].URL
+	})
+	return companions, nil
+}
+
+// multicast sends a packet to a multicast group on every interface that
+// can send one, so that devices on several networks search all of them
+func multicast(conn *net.UDPConn, packet []byte, group *net.UDPAddr) error {
+	pc := ipv4.NewPacketConn(conn)
+	interfaces, _ := net.Interfaces()
+
+	sent := false
+	var lastErr error
+	for n := range interfaces {
+		ifi := &interfaces[n]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := conn.WriteToUDP(packet, group); err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+
+	// Leave the choice to the routing table
+	if _, err := conn.WriteToUDP(packet, group); err != nil {
+		if lastErr != nil {
+			return lastErr
+		}
+		return err
+	}
+	return nil
+}
+
+// listen reads the answers to a query until timeout passes or ctx is
+// cancelled, handing each to answer with the address it came from
+func listen(ctx context.Context, conn *net.UDPConn, timeout time.Duration, answer func(packet []byte, from *net.UDPAddr)) error {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return ctx.Err()
+			}
+			return err
+		}
+		answer(buf[:n], from)
+	}
+}
@@ -0,0 +1,198 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsService is the DNS-SD service type companions advertise
+const mdnsService = "_ezra-companion._tcp.local."
+
+// mdnsGroup is the IPv4 mDNS multicast group
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// unicastResponse is the top bit of the question class, asking
+// responders to answer the querier directly
+const unicastResponse = 1 << 15
+
+// queryMDNS browses for the companion service. The query is sent from an
+// ephemeral port, so responders answer it directly (RFC 6762, 6.7).
+func queryMDNS(ctx context.Context, timeout time.Duration) ([]Companion, error) {
+	query, err := mdnsQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("mdns: %w", err)
+	}
+	defer conn.Close()
+
+	if err := multicast(conn, query, mdnsGroup); err != nil {
+		return nil, fmt.Errorf("mdns: %w", err)
+	}
+
+	records := newMDNSRecords()
+	err = listen(ctx, conn, timeout, func(packet []byte, from *net.UDPAddr) {
+		records.add(packet, from.IP)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mdns: %w", err)
+	}
+	return records.companions(), nil
+}
+
+// mdnsQuery builds the PTR query for the companion service
+func mdnsQuery() ([]byte, error) {
+	name, err := dnsmessage.NewName(mdnsService)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	err = b.Question(dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET | unicastResponse,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// mdnsRecords collects the records of the answers to a query. A
+// responder may spread an instance over several packets.
+type mdnsRecords struct {
+	// instances are the service instances by lowercased name
+	instances map[string]mdnsInstance
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	// addresses are the addresses of the hosts by name
+	addresses map[string]net.IP
+}
+
+// mdnsInstance is a service instance named in an answer
+type mdnsInstance struct {
+	name string
+	// from is the address of the responder that named it
+	from net.IP
+}
+
+func newMDNSRecords() *mdnsRecords {
+	return &mdnsRecords{
+		instances: map[string]mdnsInstance{},
+		srv:       map[string]dnsmessage.SRVResource{},
+		txt:       map[string][]string{},
+		addresses: map[string]net.IP{},
+	}
+}
+
+// add records the resources of an answer. Malformed packets and other
+// services' records are ignored.
+func (r *mdnsRecords) add(packet []byte, from net.IP) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Header.Response {
+		return
+	}
+
+	resources := append(msg.Answers, msg.Additionals...)
+	for _, resource := range resources {
+		name := strings.ToLower(resource.Header.Name.String())
+		switch body := resource.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == mdnsService {
+				r.instances[strings.ToLower(body.PTR.String())] = mdnsInstance{name: body.PTR.String(), from: from}
+			}
+		case *dnsmessage.SRVResource:
+			r.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			r.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			r.addresses[name] = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			if r.addresses[name] == nil {
+				r.addresses[name] = net.IP(body.AAAA[:])
+			}
+		}
+	}
+}
+
+// companions returns the instances whose address and port are known
+func (r *mdnsRecords) companions() []Companion {
+	var names []string
+	for name := range r.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var companions []Companion
+	for _, name := range names {
+		instance := r.instances[name]
+		srv, ok := r.srv[name]
+		if !ok {
+			continue
+		}
+		// Hosts without an address record are reached at the address
+		// the answer came from
+		ip := r.addresses[strings.ToLower(srv.Target.String())]
+		if ip == nil {
+			ip = instance.from
+		}
+
+		txt := txtValues(r.txt[name])
+		scheme := txt["scheme"]
+		if scheme == "" {
+			scheme = "http"
+			if srv.Port == 443 {
+				scheme = "https"
+			}
+		}
+		u := url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+			Path:   txt["path"],
+		}
+
+		companions = append(companions, Companion{
+			Name:    instanceName(instance.name),
+			URL:     strings.TrimSuffix(u.String(), "/"),
+			Version: txt["version"],
+			Via:     "mdns",
+		})
+	}
+	return companions
+}
+
+// instanceName returns the instance label of a service instance name
+func instanceName(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), "."+mdnsService) {
+		name = name[:len(name)-len(mdnsService)-1]
+	}
+	return strings.ReplaceAll(name, `\ `, " ")
+}
+
+// txtValues parses the key=value strings of a TXT record (RFC 6763,
+// 6.3). Keys are case-insensitive and the first occurrence counts.
+func txtValues(txt []string) map[string]string {
+	values := map[string]string{}
+	for _, entry := range txt {
+		key, value, _ := strings.Cut(entry, "=")
+		key = strings.ToLower(key)
+		if _, ok := values[key]; !ok && key != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ssdpTarget is the search target companions answer. Their LOCATION is
+// the base URL of the companion.
+const ssdpTarget = "urn:ezra-dev:service:companion:1"
+
+// ssdpGroup is the IPv4 SSDP multicast group
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// querySSDP searches for companions with an SSDP M-SEARCH, for networks
+// where mDNS is filtered
+func querySSDP(ctx context.Context, timeout time.Duration) ([]Companion, error) {
+	// Responders wait up to MX seconds before answering
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\n\r\n", ssdpGroup, mx, ssdpTarget)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("ssdp: %w", err)
+	}
+	defer conn.Close()
+
+	if err := multicast(conn, []byte(request), ssdpGroup); err != nil {
+		return nil, fmt.Errorf("ssdp: %w", err)
+	}
+
+	var companions []Companion
+	err = listen(ctx, conn, timeout, func(packet []byte, _ *net.UDPAddr) {
+		if companion, ok := parseSSDP(packet); ok {
+			companions = append(companions, companion)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssdp: %w", err)
+	}
+	return companions, nil
+}
+
+// parseSSDP parses an answer to the search, ignoring other services'
+func parseSSDP(packet []byte) (Companion, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(packet)), nil)
+	if err != nil {
+		return Companion{}, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.EqualFold(resp.Header.Get("ST"), ssdpTarget) {
+		return Companion{}, false
+	}
+
+	location, err := url.Parse(resp.Header.Get("LOCATION"))
+	if err != nil || (location.Scheme != "http" && location.Scheme != "https") || location.Host == "" {
+		return Companion{}, false
+	}
+	name := resp.Header.Get("X-Ezra-Name")
+	if name == "" {
+		name = location.Host
+	}
+	return Companion{
+		Name:    name,
+		URL:     strings.TrimSuffix(location.String(), "/"),
+		Version: resp.Header.Get("X-Ezra-Version"),
+		Via:     "ssdp",
+	}, true
+}
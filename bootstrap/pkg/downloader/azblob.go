@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	azureStorageResource = "https://storage.azure.com/"
+	azureStorageVersion  = "2021-08-06"
+)
+
+// azblobSource fetches the release tree from Azure Blob Storage given as
+// azblob://account/container/prefix
+type azblobSource struct {
+	d         *Downloader
+	account   string
+	container string
+	prefix    string
+	client    *http.Client
+
+	mu    sync.Mutex
+	sas   url.Values
+	token bearerToken
+	anon  bool
+}
+
+func newAzblobSource(d *Downloader, base *url.URL) (Source, error) {
+	container, prefix, _ := strings.Cut(strings.Trim(base.Path, "/"), "/")
+	if base.Host == "" || container == "" {
+		return nil, fmt.Errorf("Azure base URL %q must be azblob://account/container[/prefix]", base.String())
+	}
+
+	s := &azblobSource{
+		d:         d,
+		account:   base.Host,
+		container: container,
+		prefix:    prefix,
+		client:    &http.Client{Timeout: d.httpClient.Timeout, Transport: d.httpClient.Transport},
+	}
+
+	if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		s.sas = values
+	}
+
+	return s, nil
+}
+
+// ResolveComponent returns the blob URL of a component
+func (s *azblobSource) ResolveComponent(component string) (string, StreamVerifier, error) {
+	url, err := s.ResolveFile(s.d.componentPath(component))
+	return url, nil, err
+}
+
+// ResolveFile returns the blob URL of a file, fetching an access token
+// first unless a SAS token is configured
+func (s *azblobSource) ResolveFile(file string) (string, error) {
+	if err := s.refreshToken(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s/%s/%s", s.host(), s.container, escapeKey(objectKey(s.prefix, file))), nil
+}
+
+// Authorize adds the SAS token or access token to requests for the
+// container
+func (s *azblobSource) Authorize(req *http.Request) error {
+	if req.URL.Host != s.host() || !strings.HasPrefix(req.URL.Path, "/"+s.container+"/") {
+		return nil
+	}
+
+	if s.sas != nil {
+		query := req.URL.Query()
+		for key, values := range s.sas {
+			query[key] = values
+		}
+		req.URL.RawQuery = query.Encode()
+		return nil
+	}
+
+	s.mu.Lock()
+	token := s.token.value
+	s.mu.Unlock()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("x-ms-version", azureStorageVersion)
+	}
+	return nil
+}
+
+func (s *azblobSource) host() string {
+	return s.account + ".blob.core.windows.net"
+}
+
+// refreshToken obtains an Azure AD token for a service principal from
+// AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, or for the
+// VM's managed identity. Without either the container is read
+// anonymously.
+func (s *azblobSource) refreshToken() error {
+	if s.sas != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.anon || s.token.valid() {
+		return nil
+	}
+
+	tenant, clientID, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if tenant != "" && clientID != "" && secret != "" {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {secret},
+			"scope":         {azureStorageResource + ".default"},
+		}
+		req, err := http.NewRequest("POST", fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(tenant)), strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		token, err := doTokenRequest(s.client, req)
+		if err != nil {
+			return fmt.Errorf("failed to obtain Azure access token: %w", err)
+		}
+		s.token = token
+		return nil
+	}
+
+	token, err := azureManagedIdentityToken(clientID)
+	if err != nil {
+		// No managed identity, read anonymously
+		s.anon = true
+		return nil
+	}
+	s.token = token
+	return nil
+}
+
+// azureManagedIdentityToken fetches a storage token from the Azure
+// instance metadata service
+func azureManagedIdentityToken(clientID string) (bearerToken, error) {
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {azureStorageResource},
+	}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	req, err := http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return doTokenRequest(metadataClient, req)
+}
@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/pkg/archive"
+)
+
+// ErrNoBundle is returned when no bundle is published for the current
+// platform
+var ErrNoBundle = errors.New("no bundle published for this platform")
+
+// bundleFormats lists the archive formats a bundle may be published in,
+// in order of preference
+var bundleFormats = []string{archive.ExtTarZstd, archive.ExtTarGz, archive.ExtZip}
+
+// bundleFilename returns the published name of the bundle holding every
+// component for the target platform
+func (d *Downloader) bundleFilename(ext string) string {
+	return fmt.Sprintf("ezra-bundle-%s%s", d.target.Platform(), ext)
+}
+
+// DownloadBundle downloads the bundle of the latest release into dir and
+// returns its path. verify returns the verifier for a bundle file name,
+// or nil to download it unverified. ErrNoBundle is returned when the
+// release has no bundle in any supported format.
+func (d *Downloader) DownloadBundle(ctx context.Context, dir string, verify func(name string) StreamVerifier) (string, error) {
+	for _, ext := range bundleFormats {
+		name := d.bundleFilename(ext)
+		dest := filepath.Join(dir, name)
+
+		sv := verify(name)
+		locate := func(mirror string) (string, StreamVerifier, error) {
+			url, err := d.fileURL(mirror, "releases/latest/"+name)
+			return url, sv, err
+		}
+
+		d.log.Infof("Downloading bundle %s...", name)
+		_, err := d.fetchURLFromMirrors(ctx, "bundle", locate, dest)
+		if err == nil {
+			return dest, nil
+		}
+		if !IsNotPublished(err) {
+			return "", fmt.Errorf("failed to download bundle: %w", err)
+		}
+	}
+	return "", ErrNoBundle
+}
+
+// IsNotPublished reports whether an error means the requested file does
+// not exist on the server
+func IsNotPublished(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound
+	}
+	return errors.Is(err, errNotServed)
+}
@@ -0,0 +1,237 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache is a content-addressable store of downloaded files keyed by their
+// SHA256. It lives outside any single install, so re-installs, upgrades
+// and provisioning several devices from one host reuse what was fetched
+// once.
+type Cache struct {
+	dir string
+}
+
+// CacheStats describes what a cache prune removed
+type CacheStats struct {
+	Removed int
+	Freed   int64
+}
+
+// digester is implemented by verifiers that know the SHA256 a download
+// must have
+type digester interface {
+	ExpectedSHA256() string
+}
+
+// NewCache returns a cache stored in dir
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// SetCache makes downloads whose SHA256 is known ahead of time go through
+// the cache. A nil cache disables caching.
+func (d *Downloader) SetCache(cache *Cache) {
+	d.cache = cache
+}
+
+// Cached reports whether the file with the given SHA256 is in the cache
+func (d *Downloader) Cached(sha256 string) bool {
+	if d.cache == nil || sha256 == "" {
+		return false
+	}
+	_, err := os.Stat(d.cache.path(strings.ToLower(sha256)))
+	return err == nil
+}
+
+// expectedSHA256 returns the SHA256 a verifier expects, or "" when it
+// does not check one
+func expectedSHA256(sv StreamVerifier) string {
+	if d, ok := sv.(digester); ok {
+		return strings.ToLower(d.ExpectedSHA256())
+	}
+	return ""
+}
+
+// fromCache places a cached copy of a download at name. The copy is
+// verified like a download; a cached file that fails is evicted.
+func (d *Downloader) fromCache(name string, sv StreamVerifier) bool {
+	digest := expectedSHA256(sv)
+	if d.cache == nil || digest == "" {
+		return false
+	}
+
+	partName := name + ".cached"
+	if err := d.cache.copyTo(digest, partName); err != nil {
+		os.Remove(partName)
+		if !os.IsNotExist(err) {
+			d.log.Errorf("Failed to read %s from cache: %v", filepath.Base(name), err)
+		}
+		return false
+	}
+
+	err := seedVerifier(partName, sv)
+	if err == nil {
+		err = finishVerified(partName, name, sv)
+	}
+	if err != nil {
+		d.log.Errorf("Evicting bad cache entry %s: %v", digest, err)
+		discardPartial(partName)
+		os.Remove(d.cache.path(digest))
+		return false
+	}
+
+	d.log.Infof("Using cached copy of %s", filepath.Base(name))
+	return true
+}
+
+// toCache stores a verified download in the cache. Failures only cost
+// a later download, so they are logged and otherwise ignored.
+func (d *Downloader) toCache(name string, sv StreamVerifier) {
+	digest := expectedSHA256(sv)
+	if d.cache == nil || digest == "" {
+		return
+	}
+	if err := d.cache.store(digest, name); err != nil {
+		d.log.Errorf("Failed to cache %s: %v", filepath.Base(name), err)
+	}
+}
+
+// path returns where a file with the given SHA256 is kept
+func (c *Cache) path(digest string) string {
+	if len(digest) < 2 {
+		return filepath.Join(c.dir, "sha256", digest)
+	}
+	return filepath.Join(c.dir, "sha256", digest[:2], digest)
+}
+
+// copyTo copies a cached file to dest and marks it as recently used
+func (c *Cache) copyTo(digest, dest string) error {
+	src := c.path(digest)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return nil
+}
+
+// store copies a file into the cache. The copy is written to a temporary
+// file first so that concurrent installs never see a partial entry.
+func (c *Cache) store(digest, name string) error {
+	dest := c.path(digest)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Prune removes entries not used for longer than maxAge, then the least
+// recently used entries until the cache fits in maxSize bytes. A zero
+// limit is not enforced.
+func (c *Cache) Prune(maxSize int64, maxAge time.Duration) (CacheStats, error) {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.WalkDir(c.dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return CacheStats{}, fmt.Errorf("failed to scan cache: %w", err)
+	}
+
+	// Oldest first, so both limits remove the least recently used files
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].modTime.Before(entries[b].modTime)
+	})
+
+	var stats CacheStats
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		expired := maxAge > 0 && e.modTime.Before(cutoff)
+		oversized := maxSize > 0 && total > maxSize
+		if !expired && !oversized {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			continue
+		}
+		total -= e.size
+		stats.Removed++
+		stats.Freed += e.size
+	}
+
+	return stats, nil
+}
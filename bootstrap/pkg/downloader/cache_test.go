@@ -0,0 +1,262 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLogger discards the downloader's output
+type testLogger struct{}
+
+func (testLogger) Info(args ...interface{})                  {}
+func (testLogger) Infof(format string, args ...interface{})  {}
+func (testLogger) Error(args ...interface{})                 {}
+func (testLogger) Errorf(format string, args ...interface{}) {}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// newCachedDownloader returns a downloader using a cache in a temporary
+// directory
+func newCachedDownloader(t *testing.T, baseURL string) (*Downloader, *Cache) {
+	t.Helper()
+	d := New(baseURL, testLogger{})
+	cache := NewCache(t.TempDir())
+	d.SetCache(cache)
+	return d, cache
+}
+
+// putCache writes data to the cache entry for digest as it is, without
+// checking that it matches
+func putCache(t *testing.T, cache *Cache, digest, data string) {
+	t.Helper()
+	path := cache.path(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFromCache(t *testing.T) {
+	const data = "ezra-agent"
+	digest := sha256Hex(data)
+
+	tests := []struct {
+		name string
+		// entry is stored under digest, if not empty
+		entry    string
+		verifier StreamVerifier
+		want     bool
+		// kept reports whether the entry is still cached afterwards
+		kept bool
+	}{
+		{"hit", data, newChecksumVerifier(digest), true, true},
+		{"upper case digest", data, newChecksumVerifier(strings.ToUpper(digest)), true, true},
+		{"among other verifiers", data, joinVerifiers(newChecksumVerifier(digest), newChecksumVerifier(digest)), true, true},
+		{"miss", "", newChecksumVerifier(digest), false, false},
+		{"corrupt entry", "tampered", newChecksumVerifier(digest), false, false},
+		{"truncated entry", data[:4], newChecksumVerifier(digest), false, false},
+		{"no expected digest", data, nil, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, cache := newCachedDownloader(t, "")
+			if test.entry != "" {
+				putCache(t, cache, digest, test.entry)
+			}
+			dest := filepath.Join(t.TempDir(), "ezra-agent")
+
+			if got := d.fromCache(dest, test.verifier); got != test.want {
+				t.Fatalf("fromCache = %v, want %v", got, test.want)
+			}
+			got, err := os.ReadFile(dest)
+			switch {
+			case test.want && (err != nil || string(got) != data):
+				t.Errorf("destination = %q, %v, want %q", got, err, data)
+			case !test.want && !os.IsNotExist(err):
+				t.Errorf("destination written from a rejected entry: %q, %v", got, err)
+			}
+			if _, err := os.Stat(dest + ".cached"); !os.IsNotExist(err) {
+				t.Errorf("partial copy left behind: %v", err)
+			}
+			if kept := d.Cached(digest); kept != test.kept {
+				t.Errorf("Cached = %v, want %v", kept, test.kept)
+			}
+		})
+	}
+}
+
+func TestFromCacheDisabled(t *testing.T) {
+	const data = "ezra-agent"
+	d := New("", testLogger{})
+	dest := filepath.Join(t.TempDir(), "ezra-agent")
+	if d.fromCache(dest, newChecksumVerifier(sha256Hex(data))) {
+		t.Fatal("fromCache used a cache that is not set")
+	}
+	if d.Cached(sha256Hex(data)) {
+		t.Fatal("Cached reported a file without a cache")
+	}
+}
+
+func TestToCache(t *testing.T) {
+	const data = "ezra-agent"
+	digest := sha256Hex(data)
+
+	tests := []struct {
+		name     string
+		verifier StreamVerifier
+		// existing is already cached under digest, if not empty
+		existing string
+		want     string
+	}{
+		{"stored", newChecksumVerifier(digest), "", data},
+		{"already cached", newChecksumVerifier(digest), data, data},
+		{"no expected digest", nil, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, cache := newCachedDownloader(t, "")
+			if test.existing != "" {
+				putCache(t, cache, digest, test.existing)
+			}
+			name := filepath.Join(t.TempDir(), "ezra-agent")
+			if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			d.toCache(name, test.verifier)
+			got, err := os.ReadFile(cache.path(digest))
+			switch {
+			case test.want == "" && !os.IsNotExist(err):
+				t.Errorf("cached %q without an expected digest", got)
+			case test.want != "" && (err != nil || string(got) != test.want):
+				t.Errorf("cache entry = %q, %v, want %q", got, err, test.want)
+			}
+			leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(cache.path(digest)), ".tmp-*"))
+			if len(leftovers) != 0 {
+				t.Errorf("temporary files left behind: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestFetchURLFromMirrorsUsesCache(t *testing.T) {
+	const data = "ezra-agent"
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	d, cache := newCachedDownloader(t, server.URL)
+	locate := func(mirror string) (string, StreamVerifier, error) {
+		return mirror + "/ezra-agent", newChecksumVerifier(sha256Hex(data)), nil
+	}
+	fetch := func() {
+		t.Helper()
+		dest := filepath.Join(t.TempDir(), "ezra-agent")
+		if _, err := d.fetchURLFromMirrors(context.Background(), "agent", locate, dest); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dest); err != nil || string(got) != data {
+			t.Fatalf("downloaded %q, %v, want %q", got, err, data)
+		}
+	}
+
+	fetch()
+	if !d.Cached(sha256Hex(data)) {
+		t.Fatal("verified download was not cached")
+	}
+	fetch()
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("%d requests, want the second fetch served from the cache", n)
+	}
+
+	// A corrupt entry is evicted, downloaded again and replaced
+	putCache(t, cache, sha256Hex(data), "tampered")
+	fetch()
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("%d requests, want the corrupt entry downloaded again", n)
+	}
+	if got, err := os.ReadFile(cache.path(sha256Hex(data))); err != nil || string(got) != data {
+		t.Fatalf("cache entry = %q, %v, want %q", got, err, data)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	now := time.Now()
+	// Entries of 10 bytes each, by age
+	ages := map[string]time.Duration{
+		"old":    48 * time.Hour,
+		"recent": 2 * time.Hour,
+		"new":    0,
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		maxAge  time.Duration
+		kept    []string
+	}{
+		{"no limits", 0, 0, []string{"new", "old", "recent"}},
+		{"by age", 0, 24 * time.Hour, []string{"new", "recent"}},
+		{"by size", 20, 0, []string{"new", "recent"}},
+		{"least recently used first", 10, 0, []string{"new"}},
+		{"by age and size", 10, 24 * time.Hour, []string{"new"}},
+		{"everything too old", 0, time.Hour, []string{"new"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewCache(t.TempDir())
+			for name, age := range ages {
+				digest := sha256Hex(name)
+				putCache(t, cache, digest, "0123456789")
+				modTime := now.Add(-age)
+				if err := os.Chtimes(cache.path(digest), modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stats, err := cache.Prune(test.maxSize, test.maxAge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, name := range []string{"new", "old", "recent"} {
+				if _, err := os.Stat(cache.path(sha256Hex(name))); err == nil {
+					kept = append(kept, name)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(test.kept, ",") {
+				t.Errorf("kept %v, want %v", kept, test.kept)
+			}
+			if removed := len(ages) - len(test.kept); stats.Removed != removed || stats.Freed != int64(removed*10) {
+				t.Errorf("stats = %+v, want %d removed", stats, removed)
+			}
+		})
+	}
+}
+
+func TestCachePruneMissingDirectory(t *testing.T) {
+	cache := NewCache(filepath.Join(t.TempDir(), "missing"))
+	if stats, err := cache.Prune(1, time.Hour); err != nil || stats.Removed != 0 {
+		t.Fatalf("Prune = %+v, %v", stats, err)
+	}
+}
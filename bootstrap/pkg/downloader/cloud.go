@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataClient talks to instance metadata services. They are link-local
+// and answer quickly, so a short timeout keeps credential discovery fast
+// on machines outside the cloud.
+var metadataClient = &http.Client{
+	Timeout:   2 * time.Second,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+// bearerToken is an OAuth access token and its expiry
+type bearerToken struct {
+	value   string
+	expires time.Time
+}
+
+// valid reports whether the token can still be used for a while
+func (t bearerToken) valid() bool {
+	return t.value != "" && time.Until(t.expires) > time.Minute
+}
+
+// tokenResponse is the body returned by OAuth token endpoints. Some
+// endpoints encode expires_in as a string.
+type tokenResponse struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+}
+
+// doTokenRequest performs an OAuth token request
+func doTokenRequest(client *http.Client, req *http.Request) (bearerToken, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return bearerToken{}, fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return bearerToken{}, fmt.Errorf("failed to fetch access token: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+
+	var body tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return bearerToken{}, fmt.Errorf("failed to parse access token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return bearerToken{}, fmt.Errorf("token endpoint returned no access token")
+	}
+
+	seconds, err := strconv.Atoi(strings.Trim(string(body.ExpiresIn), `"`))
+	if err != nil {
+		seconds = 300
+	}
+
+	return bearerToken{
+		value:   body.AccessToken,
+		expires: time.Now().Add(time.Duration(seconds) * time.Second),
+	}, nil
+}
+
+// objectKey joins a bucket prefix and a release tree path
+func objectKey(prefix, file string) string {
+	return strings.TrimPrefix(path.Join(prefix, file), "/")
+}
+
+// escapeKey URL-escapes an object key, keeping its slashes
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uriEscape percent-encodes everything except RFC 3986 unreserved
+// characters, as cloud request signing expects
+func uriEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -1,22 +1,58 @@
 package downloader
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
-	"github.com/cheggaaa/pb/v3"
 	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
 )
 
 // Downloader handles downloading components
 type Downloader struct {
-	baseURL string
-	client  *resty.Client
-	log     Logger
+	baseURL     string
+	client      *resty.Client
+	httpClient  *http.Client
+	transport   *sourceTransport
+	proxy       func(*http.Request) (*url.URL, error)
+	tlsConfig   *tls.Config
+	limiter     *rate.Limiter
+	window      *DownloadWindow
+	cache       *Cache
+	progress    ProgressReporter
+	concurrency int
+	mirrors     []string
+	served      map[string]string
+	servedMu    sync.Mutex
+	retry       RetryPolicy
+	tuf         *verifier.TUFRepository
+	sources     map[string]Source
+	sourcesMu   sync.Mutex
+	oci         OCIOptions
+	github      GitHubOptions
+	variants    map[string]string
+	target      Target
+	log         Logger
+
+	versionsMu       sync.Mutex
+	channel          string
+	constraints      map[string]string
+	versionsResolved bool
+	indexed          bool
+	releases         map[string]ComponentManifest
+	rollout          RolloutTarget
 }
 
 // Logger interface for logging
@@ -29,155 +65,367 @@ type Logger interface {
 
 // New creates a new downloader
 func New(baseURL string, log Logger) *Downloader {
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
-
-	return &Downloader{
-		baseURL: baseURL,
-		client:  client,
-		log:     log,
+	d := &Downloader{
+		baseURL:  baseURL,
+		retry:    DefaultRetryPolicy(),
+		proxy:    http.ProxyFromEnvironment,
+		progress: autoProgress(log),
+		log:      log,
 	}
-}
 
-// DownloadCompanion downloads the companion server
-func (d *Downloader) DownloadCompanion() error {
-	d.log.Info("Downloading companion server...")
+	// Requests to non-HTTP backends carry the credentials their source
+	// hands out
+	d.transport = &sourceTransport{d: d, next: http.DefaultTransport}
 
-	// Determine download URL based on platform
-	url := d.getDownloadURL("companion")
+	d.client = resty.New()
+	d.client.SetTimeout(30 * time.Second)
+	d.client.SetTransport(d.transport)
+	d.httpClient = &http.Client{Timeout: 30 * time.Second, Transport: d.transport}
 
-	// Download file
-	if err := d.downloadFile(url, "companion"); err != nil {
-		return fmt.Errorf("failed to download companion: %w", err)
-	}
-
-	d.log.Info("Companion server downloaded successfully")
-	return nil
+	return d
 }
 
-// DownloadAgent downloads the agent
-func (d *Downloader) DownloadAgent() error {
-	d.log.Info("Downloading agent...")
-
-	// Determine download URL based on platform
-	url := d.getDownloadURL("agent")
-
-	// Download file
-	if err := d.downloadFile(url, "agent"); err != nil {
-		return fmt.Errorf("failed to download agent: %w", err)
-	}
-
-	d.log.Info("Agent downloaded successfully")
-	return nil
+// HTTPClient returns the client requests to the companion are sent
+// with. It follows later proxy and TLS changes.
+func (d *Downloader) HTTPClient() *http.Client {
+	return d.httpClient
 }
 
-// DownloadExecutor downloads the executor
-func (d *Downloader) DownloadExecutor() error {
-	d.log.Info("Downloading executor...")
-
-	// Determine download URL based on platform
-	url := d.getDownloadURL("executor")
+// DownloadComponentVerified downloads a single component to the given
+// path, verifying its contents while they stream in. The file is only
+// moved into place if verification succeeds. A nil verifier downloads
+// without verification. Cancelling ctx aborts the download, including
+// its retries, and keeps what was downloaded for a later resume.
+func (d *Downloader) DownloadComponentVerified(ctx context.Context, component, dest string, sv StreamVerifier) error {
+	d.log.Infof("Downloading %s...", component)
 
-	// Download file
-	if err := d.downloadFile(url, "executor"); err != nil {
-		return fmt.Errorf("failed to download executor: %w", err)
+	if err := d.fetchFromMirrors(ctx, component, dest, sv); err != nil {
+		return fmt.Errorf("failed to download %s: %w", component, err)
 	}
 
-	d.log.Info("Executor downloaded successfully")
+	if sv != nil {
+		d.log.Infof("%s downloaded and verified successfully", component)
+	} else {
+		d.log.Infof("%s downloaded successfully", component)
+	}
 	return nil
 }
 
-// downloadFile downloads a file with progress bar
-func (d *Downloader) downloadFile(url, name string) error {
+// downloadFile downloads a file with progress bar. Partial downloads
+// are kept in a .part file and resumed with a Range request on the next
+// attempt when the server supports it.
+func (d *Downloader) downloadFile(ctx context.Context, url, name string, sv StreamVerifier) error {
 	// Get file info
-	resp, err := d.client.R().Head(url)
+	resp, err := d.client.R().SetContext(ctx).Head(url)
 	if err != nil {
 		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
+	if resp.StatusCode() >= 500 {
+		return &StatusError{StatusCode: resp.StatusCode()}
+	}
+
 	contentLength := resp.Header().Get("Content-Length")
 	if contentLength == "" {
 		// Fallback to simple download
-		return d.simpleDownload(url, name)
+		return d.simpleDownload(ctx, url, name, sv)
+	}
+
+	remote := remoteInfoFromHeader(resp.Header())
+
+	// Split large files across several connections
+	if d.useChunked(remote) {
+		err := d.downloadChunked(ctx, url, name, remote, sv)
+		if !errors.Is(err, errRangesUnsupported) {
+			return err
+		}
+		d.log.Info("Server ignored range request, falling back to a single connection")
 	}
 
 	// Download with progress bar
-	return d.downloadWithProgress(url, name)
+	return d.downloadWithProgress(ctx, url, name, remote, sv)
 }
 
-// downloadWithProgress downloads a file with progress bar
-func (d *Downloader) downloadWithProgress(url, name string) error {
+// downloadWithProgress downloads a file with progress bar, resuming a
+// previous partial download if one matches the remote file
+func (d *Downloader) downloadWithProgress(ctx context.Context, url, name string, remote remoteInfo, sv StreamVerifier) error {
+	partName := name + ".part"
+
+	// Work out how much of the file we already have
+	var offset int64
+	if remote.acceptsRanges() {
+		offset = d.resumeOffset(partName, remote)
+	} else {
+		discardPartial(partName)
+	}
+
 	// Create HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator := remote.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
 
 	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := d.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %d", resp.StatusCode)
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		d.log.Infof("Resuming download of %s at %d bytes", name, offset)
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server ignored the range or the file changed, start over
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 && offset == remote.length {
+			if err := seedVerifier(partName, sv); err != nil {
+				return err
+			}
+			return finishVerified(partName, name, sv)
+		}
+		discardPartial(partName)
+		return &StatusError{StatusCode: resp.StatusCode}
+	default:
+		return &StatusError{StatusCode: resp.StatusCode}
 	}
 
-	// Create progress bar
-	bar := pb.New64(resp.ContentLength)
-	bar.SetTemplateString(`{{counters . }} {{bar . }} {{percent . }} {{speed . }} {{rtime . "ETA %s"}}`)
-	bar.Start()
+	// Feed the bytes we already have to the verifier
+	if offset > 0 {
+		if err := seedVerifier(partName, sv); err != nil {
+			return err
+		}
+	} else if sv != nil {
+		sv.Reset()
+	}
+
+	// Remember what we are downloading so a later attempt can resume
+	if err := writePartialInfo(partName, remote); err != nil {
+		return fmt.Errorf("failed to record partial download: %w", err)
+	}
 
 	// Create file
-	file, err := os.Create(name)
+	file, err := os.OpenFile(partName, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
 
-	// Copy with progress
-	reader := bar.NewProxyReader(resp.Body)
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := d.startProgress(filepath.Base(name), total, offset)
+
+	// Copy with progress, hashing on the fly
+	var reader io.Reader = io.TeeReader(resp.Body, progress)
+	if sv != nil {
+		reader = io.TeeReader(reader, sv)
+	}
 	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	progress.finish(err)
 	if err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
 
-	bar.Finish()
-	return nil
+	return finishVerified(partName, name, sv)
 }
 
-// simpleDownload downloads a file without progress bar
-func (d *Downloader) simpleDownload(url, name string) error {
-	resp, err := d.client.R().Get(url)
+// simpleDownload downloads a file without progress bar. The response is
+// streamed to a temporary file next to name, which replaces name only
+// once it has been verified.
+func (d *Downloader) simpleDownload(ctx context.Context, url, name string, sv StreamVerifier) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := d.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
 
-	if err := os.WriteFile(name, resp.Body(), 0644); err != nil {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	progress := d.startProgress(filepath.Base(name), resp.ContentLength, 0)
+
+	var reader io.Reader = io.TeeReader(resp.Body, progress)
+	if sv != nil {
+		sv.Reset()
+		reader = io.TeeReader(reader, sv)
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	progress.finish(err)
+	if err != nil {
+		os.Remove(tmpName)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
-	return nil
+	return finishVerified(tmpName, name, sv)
+}
+
+// ComponentURL returns the URL a component would be downloaded from
+func (d *Downloader) ComponentURL(component string) string {
+	if err := d.resolveVersions(context.Background()); err != nil {
+		d.log.Errorf("Failed to resolve component versions: %v", err)
+	}
+	if source, err := d.sourceFor(d.baseURL); err != nil || source != nil {
+		// Other backends resolve components when they are fetched
+		return fmt.Sprintf("%s/%s", d.baseURL, d.componentFilename(component))
+	}
+	return d.getDownloadURL(component)
 }
 
-// getDownloadURL constructs the download URL for a component
+// getDownloadURL constructs the download URL for a component on the
+// primary server
 func (d *Downloader) getDownloadURL(component string) string {
-	// Construct URL based on platform and architecture
-	platform := runtime.GOOS
-	arch := runtime.GOARCH
+	return d.componentURL(d.baseURL, component)
+}
 
-	// Map Go architecture to common names
-	switch arch {
-	case "amd64":
-		arch = "x86_64"
-	case "386":
-		arch = "x86"
-	case "arm64":
-		arch = "aarch64"
+// componentURL constructs the download URL for a component on the given
+// base URL
+func (d *Downloader) componentURL(baseURL, component string) string {
+	// Construct full URL
+	return fmt.Sprintf("%s/%s", baseURL, d.componentPath(component))
+}
+
+// ComponentFilename returns the published file name of a component for
+// the current platform and architecture. Bundles hold components under
+// the same names.
+func ComponentFilename(component string) string {
+	return componentFilename(component)
+}
+
+// SetVariant selects the build of a component to download, e.g. "cuda"
+// for ezra-executor-cuda-linux-amd64. "cpu" or an empty variant selects
+// the plain build.
+func (d *Downloader) SetVariant(component, variant string) {
+	if d.variants == nil {
+		d.variants = make(map[string]string)
+	}
+	if variant == "" || variant == "cpu" {
+		delete(d.variants, component)
+		return
 	}
+	d.variants[component] = variant
+}
+
+// publishedName returns the name a component is published under, with
+// its selected variant
+func (d *Downloader) publishedName(component string) string {
+	if variant := d.variants[component]; variant != "" {
+		return component + "-" + variant
+	}
+	return component
+}
+
+// Target describes the binaries the device can run
+type Target struct {
+	// OS is the operating system in published file names, e.g. "linux"
+	// or "windows"; empty selects the one the bootstrap was built for
+	OS string
+	// Arch is the architecture in published file names, e.g. "armv6" or
+	// "aarch64"; empty selects the one the bootstrap was built for
+	Arch string
+	// Libc is "musl" on musl-based systems such as Alpine, whose builds
+	// are published with a -musl suffix; glibc builds carry none
+	Libc string
+	// Features lists CPU features such as avx2 or neon. They are sent to
+	// the companion, which may serve a build optimised for them.
+	Features []string
+}
+
+// cpuFeaturesHeader carries Target.Features on requests to the companion
+const cpuFeaturesHeader = "X-Ezra-CPU-Features"
+
+// SetTarget selects the builds to download for the device
+func (d *Downloader) SetTarget(target Target) {
+	d.target = target
+}
+
+// Platform returns the platform part of published file names, e.g.
+// "linux-aarch64-musl"
+func (t Target) Platform() string {
+	return t.os() + "-" + t.arch()
+}
+
+// Filename returns the published file name of a component for the
+// target. component may carry a variant, e.g. "executor-cuda".
+func (t Target) Filename(component string) string {
+	return platformFilename(component, t.os(), t.arch())
+}
 
+// os returns the operating system of the target
+func (t Target) os() string {
+	if t.OS == "" {
+		return runtime.GOOS
+	}
+	return t.OS
+}
+
+// arch returns the architecture part of published file names, with the
+// libc suffix of the target
+func (t Target) arch() string {
+	arch := t.Arch
+	if arch == "" {
+		arch = archName()
+	}
+	if t.Libc == "musl" {
+		arch += "-musl"
+	}
+	return arch
+}
+
+// targetArch returns the architecture part of published file names for
+// the target
+func (d *Downloader) targetArch() string {
+	return d.target.arch()
+}
+
+// TargetFilename returns the published file name of the selected variant
+// of a component for the target
+func (d *Downloader) TargetFilename(component string) string {
+	return d.componentFilename(component)
+}
+
+// componentFilename returns the published file name of the selected
+// variant of a component for the target
+func (d *Downloader) componentFilename(component string) string {
+	return d.target.Filename(d.publishedName(component))
+}
+
+// componentFilename returns the published file name of a component for
+// the current platform and architecture
+func componentFilename(component string) string {
+	return platformFilename(component, runtime.GOOS, archName())
+}
+
+// platformFilename returns the published file name of a component for
+// the given platform and architecture
+func platformFilename(component, platform, arch string) string {
 	// Construct filename
 	filename := fmt.Sprintf("ezra-%s-%s-%s", component, platform, arch)
 
@@ -186,6 +434,25 @@ func (d *Downloader) getDownloadURL(component string) string {
 		filename += ".exe"
 	}
 
-	// Construct full URL
-	return fmt.Sprintf("%s/releases/latest/%s", d.baseURL, filename)
+	return filename
+}
+
+// archName maps the Go architecture to the name used in published files
+func archName() string {
+	return ArchName(runtime.GOARCH)
+}
+
+// ArchName maps a Go architecture to the name used in published files.
+// Other names are returned unchanged.
+func ArchName(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "x86"
+	case "arm64":
+		return "aarch64"
+	default:
+		return goarch
+	}
 }
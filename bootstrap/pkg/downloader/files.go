@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// provenanceSuffix is appended to a file name to find its provenance
+// attestation, as published by the SLSA generators
+const provenanceSuffix = ".intoto.jsonl"
+
+// FetchFile fetches a small file published under the release tree, such
+// as a manifest, failing over to the configured mirrors. An absolute
+// http(s) URL is fetched as it is.
+func (d *Downloader) FetchFile(ctx context.Context, path string) ([]byte, error) {
+	var lastErr error
+
+	for _, mirror := range d.mirrorList() {
+		var data []byte
+		err := d.withRetry(ctx, path, func() error {
+			url := path
+			if !isAbsoluteURL(path) {
+				var err error
+				if url, err = d.fileURL(mirror, path); err != nil {
+					return err
+				}
+			}
+
+			resp, err := d.client.R().SetContext(ctx).Get(url)
+			if err != nil {
+				return fmt.Errorf("failed to fetch %s: %w", path, err)
+			}
+			if resp.StatusCode() != http.StatusOK {
+				return &StatusError{StatusCode: resp.StatusCode()}
+			}
+			data = resp.Body()
+			return nil
+		})
+		if err == nil {
+			return data, nil
+		}
+		if !isFailoverError(err) {
+			return nil, err
+		}
+
+		d.log.Errorf("Mirror %s failed for %s: %v", mirror, path, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all mirrors failed: %w", lastErr)
+}
+
+// DownloadFile downloads a file to dest, verifying it while it streams
+// in. location is either an absolute http(s) URL, which is fetched as it
+// is, or a path under the release tree, which is tried on every mirror.
+func (d *Downloader) DownloadFile(ctx context.Context, location, dest string, sv StreamVerifier) error {
+	locate := func(mirror string) (string, StreamVerifier, error) {
+		if isAbsoluteURL(location) {
+			return location, sv, nil
+		}
+		url, err := d.fileURL(mirror, location)
+		return url, sv, err
+	}
+
+	if _, err := d.fetchURLFromMirrors(ctx, location, locate, dest); err != nil {
+		return fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	return nil
+}
+
+// isAbsoluteURL reports whether a location is a full http(s) URL
+func isAbsoluteURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// FetchProvenance fetches the provenance attestation published next to a
+// component. location is where the component was downloaded from with
+// DownloadFile, or empty for its release file.
+func (d *Downloader) FetchProvenance(ctx context.Context, component, location string) ([]byte, error) {
+	if location == "" {
+		location = d.componentPath(component)
+	}
+	return d.FetchFile(ctx, location+provenanceSuffix)
+}
@@ -0,0 +1,252 @@
+package downloader
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsHost  = "storage.googleapis.com"
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+)
+
+// googleCredentials is an application default credentials file
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// gcsSource fetches the release tree from a Google Cloud Storage bucket
+// given as gs://bucket/prefix
+type gcsSource struct {
+	d      *Downloader
+	bucket string
+	prefix string
+	client *http.Client
+
+	mu       sync.Mutex
+	token    bearerToken
+	resolved bool
+	creds    *googleCredentials
+}
+
+func newGCSSource(d *Downloader, base *url.URL) (Source, error) {
+	if base.Host == "" {
+		return nil, fmt.Errorf("GCS base URL %q has no bucket", base.String())
+	}
+
+	return &gcsSource{
+		d:      d,
+		bucket: base.Host,
+		prefix: strings.Trim(base.Path, "/"),
+		client: &http.Client{Timeout: d.httpClient.Timeout, Transport: d.httpClient.Transport},
+	}, nil
+}
+
+// ResolveComponent returns the object URL of a component
+func (s *gcsSource) ResolveComponent(component string) (string, StreamVerifier, error) {
+	url, err := s.ResolveFile(s.d.componentPath(component))
+	return url, nil, err
+}
+
+// ResolveFile returns the object URL of a file, fetching an access token
+// first if credentials are available
+func (s *gcsSource) ResolveFile(file string) (string, error) {
+	if err := s.refreshToken(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s/%s/%s", gcsHost, s.bucket, escapeKey(objectKey(s.prefix, file))), nil
+}
+
+// Authorize adds the access token to requests for the bucket
+func (s *gcsSource) Authorize(req *http.Request) error {
+	if req.URL.Host != gcsHost || !strings.HasPrefix(req.URL.Path, "/"+s.bucket+"/") {
+		return nil
+	}
+
+	s.mu.Lock()
+	token := s.token.value
+	s.mu.Unlock()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// refreshToken obtains an access token from application default
+// credentials: GOOGLE_APPLICATION_CREDENTIALS, the gcloud well-known
+// file, or the GCE metadata server. Without any the bucket is read
+// anonymously.
+func (s *gcsSource) refreshToken() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.valid() {
+		return nil
+	}
+
+	if !s.resolved {
+		creds, err := loadGoogleCredentials()
+		if err != nil {
+			return err
+		}
+		s.creds = creds
+		s.resolved = true
+	}
+
+	var (
+		token bearerToken
+		err   error
+	)
+	switch {
+	case s.creds == nil:
+		token, err = gceMetadataToken()
+		if err != nil {
+			// Not on GCE, read anonymously
+			s.token = bearerToken{}
+			return nil
+		}
+	case s.creds.Type == "service_account":
+		token, err = s.serviceAccountToken()
+	case s.creds.Type == "authorized_user":
+		token, err = s.refreshUserToken()
+	default:
+		err = fmt.Errorf("unsupported credentials type %q", s.creds.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to obtain GCS access token: %w", err)
+	}
+
+	s.token = token
+	return nil
+}
+
+// serviceAccountToken exchanges a signed JWT for an access token
+func (s *gcsSource) serviceAccountToken() (bearerToken, error) {
+	block, _ := pem.Decode([]byte(s.creds.PrivateKey))
+	if block == nil {
+		return bearerToken{}, fmt.Errorf("service account key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return bearerToken{}, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return bearerToken{}, fmt.Errorf("unsupported service account key type %T", parsed)
+	}
+
+	tokenURI := s.creds.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.creds.ClientEmail,
+		"scope": gcsScope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return bearerToken{}, fmt.Errorf("failed to sign token request: %w", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	return s.postTokenForm(tokenURI, form)
+}
+
+// refreshUserToken uses the refresh token stored by gcloud
+func (s *gcsSource) refreshUserToken() (bearerToken, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.creds.ClientID},
+		"client_secret": {s.creds.ClientSecret},
+		"refresh_token": {s.creds.RefreshToken},
+	}
+	return s.postTokenForm("https://oauth2.googleapis.com/token", form)
+}
+
+func (s *gcsSource) postTokenForm(tokenURI string, form url.Values) (bearerToken, error) {
+	req, err := http.NewRequest("POST", tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return bearerToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(s.client, req)
+}
+
+// loadGoogleCredentials reads the application default credentials file,
+// returning nil if there is none
+func loadGoogleCredentials() (*googleCredentials, error) {
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if file == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		file = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+		if _, err := os.Stat(file); err != nil {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Google credentials: %w", err)
+	}
+
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials: %w", err)
+	}
+
+	return &creds, nil
+}
+
+// gceMetadataToken fetches the default service account token from the
+// GCE metadata server
+func gceMetadataToken() (bearerToken, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+
+	req, err := http.NewRequest("GET", "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	return doTokenRequest(metadataClient, req)
+}
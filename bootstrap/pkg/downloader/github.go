@@ -0,0 +1,367 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// defaultChannelTags selects the releases of each channel by tag
+var defaultChannelTags = map[string]string{
+	"stable":  `^v?\d+\.\d+\.\d+$`,
+	"beta":    `^v?\d+\.\d+\.\d+-(alpha|beta|rc)`,
+	"nightly": `^nightly`,
+}
+
+// maxChecksumAssetSize bounds the .sha256 and signature assets read
+const maxChecksumAssetSize = 64 << 10
+
+// signatureExtensions are the suffixes of signature assets published next
+// to a component, in order of preference
+var signatureExtensions = []string{".sig", ".minisig", ".asc"}
+
+// GitHubOptions configures downloading from GitHub Releases
+type GitHubOptions struct {
+	// Token authenticates API requests; GITHUB_TOKEN is used when empty
+	Token string
+	// APIURL is the API endpoint, for GitHub Enterprise Server
+	APIURL string
+	// Channel is "stable" (default), "beta" or "nightly"
+	Channel string
+	// ChannelTags overrides the tag pattern that selects a channel's
+	// releases
+	ChannelTags map[string]string
+	// Verify builds a verifier for the checksum and signature attached to
+	// an asset. Without it only the checksum is checked.
+	Verify func(checksum, signature string) StreamVerifier
+}
+
+// SetGitHubOptions configures the GitHub Releases backend
+func (d *Downloader) SetGitHubOptions(opts GitHubOptions) {
+	d.github = opts
+}
+
+// githubRelease is a release as listed by the API
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+// githubAsset is a file attached to a release
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// githubSource downloads release assets from github://owner/repo. The
+// newest release whose tag matches the configured channel is used.
+type githubSource struct {
+	d       *Downloader
+	owner   string
+	repo    string
+	api     *url.URL
+	opts    GitHubOptions
+	pattern *regexp.Regexp
+	client  *http.Client
+
+	mu      sync.Mutex
+	release *githubRelease
+}
+
+func newGitHubSource(d *Downloader, base *url.URL) (Source, error) {
+	owner, repo := base.Host, strings.Trim(base.Path, "/")
+	if owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return nil, fmt.Errorf("GitHub base URL %q must be github://owner/repo", base.String())
+	}
+
+	opts := d.github
+	if opts.Token == "" {
+		opts.Token = os.Getenv("GITHUB_TOKEN")
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = "https://api.github.com"
+	}
+	if opts.Channel == "" {
+		opts.Channel = "stable"
+	}
+
+	api, err := url.Parse(strings.TrimSuffix(opts.APIURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL %q: %w", opts.APIURL, err)
+	}
+
+	expr, ok := opts.ChannelTags[opts.Channel]
+	if !ok {
+		expr, ok = defaultChannelTags[opts.Channel]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown release channel %q", opts.Channel)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag pattern for channel %s: %w", opts.Channel, err)
+	}
+
+	return &githubSource{
+		d:       d,
+		owner:   owner,
+		repo:    repo,
+		api:     api,
+		opts:    opts,
+		pattern: pattern,
+		client:  &http.Client{Timeout: d.httpClient.Timeout, Transport: d.httpClient.Transport},
+	}, nil
+}
+
+// ResolveComponent picks the component's asset for this platform and
+// returns it with a verifier for the .sha256 and .sig assets published
+// next to it. Components pinned to a version come from the release
+// tagged with it.
+func (s *githubSource) ResolveComponent(component string) (string, StreamVerifier, error) {
+	var (
+		release *githubRelease
+		err     error
+	)
+	if version := s.d.pinnedVersion(component); version != "" {
+		release, err = s.taggedRelease(version)
+	} else {
+		release, err = s.selectRelease()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	asset, ok := selectAsset(release.Assets, s.d.publishedName(component))
+	if !ok {
+		return "", nil, fmt.Errorf("release %s has no %s asset for %s/%s", release.TagName, component, runtime.GOOS, runtime.GOARCH)
+	}
+
+	checksum, err := s.readAsset(release, asset.Name+".sha256")
+	if err != nil {
+		return "", nil, err
+	}
+	if fields := strings.Fields(checksum); len(fields) > 0 {
+		// sha256sum output is "<digest>  <file>"
+		checksum = fields[0]
+	}
+	var signature string
+	for _, ext := range signatureExtensions {
+		if signature, err = s.readAsset(release, asset.Name+ext); err != nil {
+			return "", nil, err
+		}
+		if signature != "" {
+			break
+		}
+	}
+
+	var sv StreamVerifier
+	switch {
+	case s.opts.Verify != nil && (checksum != "" || signature != ""):
+		sv = s.opts.Verify(checksum, signature)
+	case checksum != "":
+		sv = newChecksumVerifier(checksum)
+	}
+
+	return asset.URL, sv, nil
+}
+
+// ResolveFile returns the asset of the selected release named like the
+// last element of the path
+func (s *githubSource) ResolveFile(file string) (string, error) {
+	release, err := s.selectRelease()
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Base(file)
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no asset %s: %w", release.TagName, name, errNotServed)
+}
+
+// Authorize adds the token to API requests and asks for asset contents
+// rather than their metadata
+func (s *githubSource) Authorize(req *http.Request) error {
+	if req.URL.Host != s.api.Host {
+		return nil
+	}
+
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	}
+	if strings.Contains(req.URL.Path, "/releases/assets/") {
+		req.Header.Set("Accept", "application/octet-stream")
+	} else if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	return nil
+}
+
+// selectRelease finds the newest release on the configured channel. The
+// choice is made once so that every component comes from the same
+// release.
+func (s *githubSource) selectRelease() (*githubRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.release != nil {
+		return s.release, nil
+	}
+
+	resp, err := s.client.Get(fmt.Sprintf("%s/repos/%s/%s/releases?per_page=100", s.api, s.owner, s.repo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var releases []githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to parse releases: %w", err)
+	}
+
+	// Releases are listed newest first
+	for i := range releases {
+		release := &releases[i]
+		if release.Draft || !s.pattern.MatchString(release.TagName) {
+			continue
+		}
+		if s.opts.Channel == "stable" && release.Prerelease {
+			continue
+		}
+		s.release = release
+		return release, nil
+	}
+
+	return nil, fmt.Errorf("no %s release found in %s/%s", s.opts.Channel, s.owner, s.repo)
+}
+
+// taggedRelease fetches the release tagged with a version, with or
+// without a leading "v"
+func (s *githubSource) taggedRelease(version string) (*githubRelease, error) {
+	tags := []string{version}
+	if strings.HasPrefix(version, "v") {
+		tags = append(tags, strings.TrimPrefix(version, "v"))
+	} else {
+		tags = append(tags, "v"+version)
+	}
+
+	for _, tag := range tags {
+		resp, err := s.client.Get(fmt.Sprintf("%s/repos/%s/%s/releases/tags/%s", s.api, s.owner, s.repo, url.PathEscape(tag)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch release %s: %w", tag, err)
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		var release githubRelease
+		err = json.NewDecoder(resp.Body).Decode(&release)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse release %s: %w", tag, err)
+		}
+		return &release, nil
+	}
+
+	return nil, fmt.Errorf("no release tagged %s in %s/%s", version, s.owner, s.repo)
+}
+
+// readAsset returns the contents of a small asset, or "" if the release
+// does not have it
+func (s *githubSource) readAsset(release *githubRelease, name string) (string, error) {
+	for _, asset := range release.Assets {
+		if asset.Name != name {
+			continue
+		}
+
+		resp, err := s.client.Get(asset.URL)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch %s: %w", name, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumAssetSize))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	return "", nil
+}
+
+// isSignatureAsset reports whether an asset is the signature of another
+func isSignatureAsset(name string) bool {
+	for _, ext := range signatureExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectAsset picks the asset of a component for the running platform:
+// the published file name if present, otherwise any asset naming the
+// component, OS and architecture
+func selectAsset(assets []githubAsset, component string) (githubAsset, bool) {
+	filename := componentFilename(component)
+	for _, asset := range assets {
+		if asset.Name == filename {
+			return asset, true
+		}
+	}
+
+	arches := []string{runtime.GOARCH}
+	switch runtime.GOARCH {
+	case "amd64":
+		arches = append(arches, "x86_64")
+	case "arm64":
+		arches = append(arches, "aarch64")
+	case "386":
+		arches = append(arches, "x86", "i386")
+	}
+
+	for _, asset := range assets {
+		name := strings.ToLower(asset.Name)
+		if !strings.HasPrefix(name, "ezra-"+component) || !strings.Contains(name, runtime.GOOS) {
+			continue
+		}
+		if strings.HasSuffix(name, ".sha256") || isSignatureAsset(name) {
+			continue
+		}
+		for _, arch := range arches {
+			if strings.Contains(name, arch) {
+				return asset, true
+			}
+		}
+	}
+
+	return githubAsset{}, false
+}
@@ -0,0 +1,166 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Manifest describes a published release
+type Manifest struct {
+	Version    string                       `json:"version"`
+	Components map[string]ComponentManifest `json:"components"`
+	// Bundles describes the published bundles by file name
+	Bundles map[string]ComponentManifest `json:"bundles,omitempty"`
+}
+
+// ComponentManifest describes a single component within a release
+type ComponentManifest struct {
+	Version string `json:"version"`
+	SHA256  string `json:"sha256,omitempty"`
+	// Signature is a base64 Ed25519 signature over the SHA256 digest
+	Signature string `json:"signature,omitempty"`
+	// Size is the size of the release file in bytes, if published
+	Size int64 `json:"size,omitempty"`
+	// Patches lists binary diffs from earlier versions to this one
+	Patches []Patch `json:"patches,omitempty"`
+	// Rollout stages the release to some of the devices; nil offers it
+	// to all of them
+	Rollout *Rollout `json:"rollout,omitempty"`
+}
+
+// Patch describes a binary diff between two versions of a component
+type Patch struct {
+	// From is the version the patch applies to
+	From string `json:"from"`
+	// Format is "bsdiff" or "zstd" (zstd --patch-from)
+	Format string `json:"format"`
+	// Path is the location of the patch relative to the base URL
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// PatchFrom returns the patch that upgrades the given version to this
+// component version, if one is published
+func (c ComponentManifest) PatchFrom(version string) (Patch, bool) {
+	for _, patch := range c.Patches {
+		if patch.From == version {
+			return patch, true
+		}
+	}
+	return Patch{}, false
+}
+
+// DownloadPatch downloads a published patch to the given path. Patches
+// without a digest are refused: they are parsed before the binary they
+// build can be verified.
+func (d *Downloader) DownloadPatch(ctx context.Context, patch Patch, dest string) error {
+	if patch.SHA256 == "" {
+		return &VerificationError{Err: fmt.Errorf("patch %s publishes no sha256", patch.Path)}
+	}
+	locate := func(mirror string) (string, StreamVerifier, error) {
+		url, err := d.fileURL(mirror, patch.Path)
+		return url, newChecksumVerifier(patch.SHA256), err
+	}
+
+	if _, err := d.fetchURLFromMirrors(ctx, "patch", locate, dest); err != nil {
+		return fmt.Errorf("failed to download patch: %w", err)
+	}
+	return nil
+}
+
+// FetchManifest returns the manifest of the component versions that
+// will be installed. With a release index it is built from the chosen
+// index entries; otherwise the latest release manifest is fetched and
+// pinned components are taken from their own release's manifest.
+func (d *Downloader) FetchManifest(ctx context.Context) (*Manifest, error) {
+	if err := d.resolveVersions(ctx); err != nil {
+		return nil, fmt.Errorf("failed to resolve component versions: %w", err)
+	}
+
+	d.versionsMu.Lock()
+	indexed := d.indexed
+	releases := make(map[string]ComponentManifest, len(d.releases))
+	for component, release := range d.releases {
+		releases[component] = release
+	}
+	d.versionsMu.Unlock()
+
+	if indexed {
+		return &Manifest{Components: releases}, nil
+	}
+
+	manifest, err := d.fetchReleaseManifest(ctx, "latest")
+	if err != nil {
+		return nil, err
+	}
+
+	for component, release := range releases {
+		pinned, err := d.fetchReleaseManifest(ctx, release.Version)
+		if err != nil {
+			d.log.Errorf("No manifest for %s %s: %v", component, release.Version, err)
+			delete(manifest.Components, component)
+			continue
+		}
+		if entry, ok := pinned.Components[component]; ok {
+			manifest.Components[component] = entry
+		} else {
+			delete(manifest.Components, component)
+		}
+	}
+
+	return manifest, nil
+}
+
+// fetchReleaseManifest fetches the manifest of one release directory,
+// failing over to the configured mirrors if the primary server is
+// unavailable
+func (d *Downloader) fetchReleaseManifest(ctx context.Context, dir string) (*Manifest, error) {
+	var lastErr error
+
+	for _, mirror := range d.mirrorList() {
+		var manifest *Manifest
+		err := d.withRetry(ctx, "manifest", func() error {
+			var err error
+			manifest, err = d.fetchManifest(ctx, mirror, dir)
+			return err
+		})
+		if err == nil {
+			return manifest, nil
+		}
+		if !isFailoverError(err) {
+			return nil, err
+		}
+
+		d.log.Errorf("Mirror %s failed for manifest: %v", mirror, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to fetch manifest: %w", lastErr)
+}
+
+// fetchManifest fetches a release manifest from a single base URL
+func (d *Downloader) fetchManifest(ctx context.Context, baseURL, dir string) (*Manifest, error) {
+	url, err := d.fileURL(baseURL, "releases/"+dir+"/manifest.json")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.R().SetContext(ctx).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode()}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(resp.Body(), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusError is returned when a server answers with an unexpected status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("download failed with status: %d", e.StatusCode)
+}
+
+// IsNotFound reports whether a fetch failed because the file is not
+// published
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// SetMirrors sets additional base URLs that serve the same release tree
+// as the primary one. They are tried in order after the primary fails.
+func (d *Downloader) SetMirrors(mirrors []string) {
+	d.mirrors = append([]string{d.baseURL}, mirrors...)
+}
+
+// ServedBy returns the base URL that served a component
+func (d *Downloader) ServedBy(component string) string {
+	d.servedMu.Lock()
+	defer d.servedMu.Unlock()
+	return d.served[component]
+}
+
+// SortMirrorsByLatency reorders the mirrors by the time a HEAD request
+// to their base URL takes. Unreachable mirrors are moved to the end.
+func (d *Downloader) SortMirrorsByLatency() {
+	mirrors := d.mirrorList()
+	if len(mirrors) < 2 {
+		return
+	}
+
+	latencies := make(map[string]time.Duration, len(mirrors))
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, mirror := range mirrors {
+		wg.Add(1)
+		go func(mirror string) {
+			defer wg.Done()
+
+			latency := time.Duration(1<<63 - 1)
+			start := time.Now()
+			client := &http.Client{Timeout: 5 * time.Second, Transport: d.httpClient.Transport}
+			if resp, err := client.Head(mirror); err == nil {
+				resp.Body.Close()
+				latency = time.Since(start)
+			}
+
+			mu.Lock()
+			latencies[mirror] = latency
+			mu.Unlock()
+		}(mirror)
+	}
+	wg.Wait()
+
+	sort.SliceStable(mirrors, func(a, b int) bool {
+		return latencies[mirrors[a]] < latencies[mirrors[b]]
+	})
+	d.mirrors = mirrors
+
+	d.log.Infof("Mirror order by latency: %s", strings.Join(mirrors, ", "))
+}
+
+// mirrorList returns the base URLs to try, primary first
+func (d *Downloader) mirrorList() []string {
+	if len(d.mirrors) == 0 {
+		return []string{d.baseURL}
+	}
+	return append([]string(nil), d.mirrors...)
+}
+
+// fetchFromMirrors downloads a component, failing over to the next
+// mirror on server errors and network failures
+func (d *Downloader) fetchFromMirrors(ctx context.Context, component, dest string, sv StreamVerifier) error {
+	// With TUF the trusted targets metadata decides what a valid
+	// component looks like
+	if d.tuf != nil {
+		target, err := d.tuf.Target(d.componentFilename(component))
+		if err != nil {
+			return &VerificationError{Err: err}
+		}
+		sv = newTargetVerifier(target)
+	}
+
+	locate := func(mirror string) (string, StreamVerifier, error) {
+		if d.tuf != nil {
+			url, err := d.tufTargetURL(mirror, component)
+			return url, sv, err
+		}
+		url, digest, err := d.componentLocation(ctx, mirror, component)
+		return url, joinVerifiers(sv, digest), err
+	}
+
+	mirror, err := d.fetchURLFromMirrors(ctx, component, locate, dest)
+	if err != nil {
+		return err
+	}
+
+	d.servedMu.Lock()
+	if d.served == nil {
+		d.served = map[string]string{}
+	}
+	d.served[component] = mirror
+	d.servedMu.Unlock()
+
+	d.log.Infof("%s served by %s", component, mirror)
+	return nil
+}
+
+// fetchURLFromMirrors downloads the file locate finds on each mirror,
+// checking it with the verifier locate returns and failing over on
+// server errors and network failures. It returns the mirror that served
+// the file.
+func (d *Downloader) fetchURLFromMirrors(ctx context.Context, what string, locate func(mirror string) (string, StreamVerifier, error), dest string) (string, error) {
+	var lastErr error
+
+	if err := d.waitForWindow(ctx); err != nil {
+		return "", err
+	}
+
+	for _, mirror := range d.mirrorList() {
+		err := d.withRetry(ctx, what, func() error {
+			url, sv, err := locate(mirror)
+			if err != nil {
+				return err
+			}
+			if d.fromCache(dest, sv) {
+				return nil
+			}
+			if err := d.downloadFile(ctx, url, dest, sv); err != nil {
+				return err
+			}
+			d.toCache(dest, sv)
+			return nil
+		})
+		if err == nil {
+			return mirror, nil
+		}
+
+		if !isFailoverError(err) {
+			return "", err
+		}
+
+		d.log.Errorf("Mirror %s failed for %s: %v", mirror, what, err)
+		lastErr = err
+	}
+
+	return "", fmt.Errorf("all mirrors failed: %w", lastErr)
+}
+
+// isFailoverError reports whether an error should make the downloader
+// try the next mirror. Server errors and transport failures do; client
+// errors such as 404 are returned as they are.
+func isFailoverError(err error) bool {
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultConnectivityCheckURL answers 204 No Content to every request.
+// Captive portals intercept it and answer with their login page instead.
+const DefaultConnectivityCheckURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// Limits of the network check
+const (
+	// latencySamples is the number of requests whose fastest sets the
+	// latency
+	latencySamples = 3
+	// bandwidthSample is the number of bytes fetched to estimate the
+	// bandwidth
+	bandwidthSample = 1 << 20
+	// networkCheckTimeout bounds each request of the check
+	networkCheckTimeout = 10 * time.Second
+	// slowBandwidth is the rate, in bytes per second, below which an
+	// offline installation is suggested
+	slowBandwidth = 64 << 10
+)
+
+// NetworkReport describes how well the companion can be reached
+type NetworkReport struct {
+	// Proxy is the proxy requests to the companion go through, with any
+	// password removed, or empty for direct connections
+	Proxy string `json:"proxy,omitempty"`
+	// Reachable is set if the companion answered at all
+	Reachable bool `json:"reachable"`
+	// Latency is the fastest round trip to the companion
+	Latency time.Duration `json:"latency_ns,omitempty"`
+	// Bandwidth is the estimated download rate in bytes per second, or
+	// zero if it could not be measured
+	Bandwidth float64 `json:"bandwidth,omitempty"`
+	// CaptivePortal is set if a known URL answered with something else,
+	// as networks that need a sign-in page do
+	CaptivePortal bool `json:"captive_portal"`
+	// Error is why the companion could not be reached
+	Error error `json:"-"`
+}
+
+// CheckNetwork checks that the companion can be reached and how fast,
+// through the configured proxy, and whether a captive portal intercepts
+// requests. The connectivity check URL defaults to
+// DefaultConnectivityCheckURL.
+func (d *Downloader) CheckNetwork(ctx context.Context, checkURL string) *NetworkReport {
+	if checkURL == "" {
+		checkURL = DefaultConnectivityCheckURL
+	}
+	report := &NetworkReport{}
+
+	if source, err := d.sourceFor(d.baseURL); err != nil {
+		report.Error = err
+		return report
+	} else if source != nil {
+		report.Error = fmt.Errorf("network checks need an http(s) companion URL, not %s", d.baseURL)
+		return report
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.baseURL, nil)
+	if err != nil {
+		report.Error = err
+		return report
+	}
+	if d.proxy != nil {
+		if proxy, err := d.proxy(req); err == nil && proxy != nil {
+			report.Proxy = proxy.Redacted()
+		}
+	}
+
+	report.CaptivePortal = d.captivePortal(ctx, checkURL)
+
+	client := &http.Client{Timeout: networkCheckTimeout, Transport: d.httpClient.Transport}
+	for n := 0; n < latencySamples; n++ {
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			report.Error = err
+			continue
+		}
+		resp.Body.Close()
+
+		latency := time.Since(start)
+		if !report.Reachable || latency < report.Latency {
+			report.Latency = latency
+		}
+		report.Reachable = true
+		report.Error = nil
+	}
+	if !report.Reachable {
+		return report
+	}
+
+	if bandwidth, err := d.measureBandwidth(ctx, client); err != nil {
+		d.log.Errorf("Could not measure bandwidth: %v", err)
+	} else {
+		report.Bandwidth = bandwidth
+	}
+
+	return report
+}
+
+// Ping asks the companion once whether it can be reached and returns
+// the round trip, without the bandwidth and captive portal checks of
+// CheckNetwork
+func (d *Downloader) Ping(ctx context.Context) (time.Duration, error) {
+	if source, err := d.sourceFor(d.baseURL); err != nil {
+		return 0, err
+	} else if source != nil {
+		return 0, fmt.Errorf("network checks need an http(s) companion URL, not %s", d.baseURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.baseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{Timeout: networkCheckTimeout, Transport: d.httpClient.Transport}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return time.Since(start), nil
+}
+
+// measureBandwidth downloads the start of the companion binary and
+// returns the rate in bytes per second
+func (d *Downloader) measureBandwidth(ctx context.Context, client *http.Client) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.getDownloadURL("companion"), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", bandwidthSample-1))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, &StatusError{StatusCode: resp.StatusCode}
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, bandwidthSample))
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	if n == 0 || elapsed <= 0 {
+		return 0, fmt.Errorf("no data received")
+	}
+	return float64(n) / elapsed, nil
+}
+
+// captivePortal reports whether the connectivity check URL answers with
+// anything but an empty 204. Redirects are not followed, since portals
+// usually redirect to their login page. Networks without internet access
+// are not captive portals.
+func (d *Downloader) captivePortal(ctx context.Context, checkURL string) bool {
+	client := &http.Client{
+		Timeout:   networkCheckTimeout,
+		Transport: d.httpClient.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1))
+	return resp.StatusCode != http.StatusNoContent || len(body) > 0
+}
+
+// MarshalJSON encodes the report with Error as a string
+func (r *NetworkReport) MarshalJSON() ([]byte, error) {
+	type report NetworkReport
+	var message string
+	if r.Error != nil {
+		message = r.Error.Error()
+	}
+	return json.Marshal(struct {
+		*report
+		Error string `json:"error,omitempty"`
+	}{(*report)(r), message})
+}
+
+// Suggestion returns advice for an unreachable or slow companion, or an
+// empty string if the network looks usable
+func (r *NetworkReport) Suggestion() string {
+	switch {
+	case r.CaptivePortal:
+		return "This network shows a sign-in page (captive portal). Open a browser, sign in, and try again."
+	case !r.Reachable && r.Proxy == "":
+		return "The companion cannot be reached. If this network needs a proxy, set proxy_url in the configuration or HTTPS_PROXY; otherwise install from USB or SD card with -offline."
+	case !r.Reachable:
+		return fmt.Sprintf("The companion cannot be reached through proxy %s. Check the proxy settings, or install from USB or SD card with -offline.", r.Proxy)
+	case r.Bandwidth > 0 && r.Bandwidth < slowBandwidth:
+		return fmt.Sprintf("The connection to the companion is slow (%.0f KiB/s). Consider installing from USB or SD card with -offline.", r.Bandwidth/1024)
+	}
+	return ""
+}
@@ -0,0 +1,428 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// Manifest media types accepted from a registry
+const (
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// annotationTitle names the file stored in an ORAS artifact layer
+const annotationTitle = "org.opencontainers.image.title"
+
+// maxOCIManifestSize bounds the manifests read from a registry
+const maxOCIManifestSize = 4 << 20
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// OCIOptions configures pulling components from an OCI registry
+type OCIOptions struct {
+	// Username and Password authenticate to the registry. When empty the
+	// credentials in ~/.docker/config.json are used, if any.
+	Username string
+	Password string
+	// Tag is the tag pulled for each component (default "latest")
+	Tag string
+	// Digests pins components to a manifest digest, overriding Tag
+	Digests map[string]string
+	// PlainHTTP talks to the registry without TLS
+	PlainHTTP bool
+}
+
+// SetOCIOptions configures the OCI registry backend
+func (d *Downloader) SetOCIOptions(opts OCIOptions) {
+	d.oci = opts
+}
+
+// ociSource pulls components stored as ORAS-style artifacts. A base URL
+// of oci://registry.example.com/ezra maps the agent to the repository
+// ezra/ezra-agent on that registry.
+type ociSource struct {
+	d         *Downloader
+	registry  string
+	namespace string
+	scheme    string
+	opts      OCIOptions
+	client    *http.Client
+
+	mu     sync.Mutex
+	basic  bool
+	tokens map[string]string
+}
+
+// ociDescriptor points to a manifest or blob
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+// ociManifest is an image index or an image manifest
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+func newOCISource(d *Downloader, base *url.URL) (Source, error) {
+	if base.Host == "" {
+		return nil, fmt.Errorf("OCI base URL %q has no registry", base.String())
+	}
+
+	opts := d.oci
+	if opts.Tag == "" {
+		opts.Tag = "latest"
+	}
+	if opts.Username == "" {
+		opts.Username, opts.Password = dockerCredentials(base.Host)
+	}
+
+	scheme := "https"
+	if opts.PlainHTTP {
+		scheme = "http"
+	}
+
+	return &ociSource{
+		d:         d,
+		registry:  base.Host,
+		namespace: strings.Trim(base.Path, "/"),
+		scheme:    scheme,
+		opts:      opts,
+		client:    &http.Client{Timeout: d.httpClient.Timeout, Transport: d.httpClient.Transport},
+		tokens:    map[string]string{},
+	}, nil
+}
+
+// ResolveComponent picks the component's manifest for this platform and
+// returns the URL of its blob, pinned to the blob digest
+func (s *ociSource) ResolveComponent(component string) (string, StreamVerifier, error) {
+	repository := "ezra-" + component
+	if s.namespace != "" {
+		repository = s.namespace + "/" + repository
+	}
+
+	// A pinned digest beats a pinned version, which beats the tag
+	reference := s.opts.Tag
+	if version := s.d.pinnedVersion(component); version != "" {
+		reference = version
+	}
+	if digest, ok := s.opts.Digests[component]; ok {
+		reference = digest
+	}
+
+	manifest, err := s.fetchManifest(repository, reference)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Multi-platform artifacts list one manifest per platform
+	if len(manifest.Manifests) > 0 {
+		desc, err := selectPlatform(manifest.Manifests)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", repository, err)
+		}
+		if manifest, err = s.fetchManifest(repository, desc.Digest); err != nil {
+			return "", nil, err
+		}
+	}
+
+	layer, err := selectLayer(manifest.Layers, s.d.componentFilename(component))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", repository, err)
+	}
+
+	sv, err := newDigestVerifier(layer.Digest, layer.Size)
+	if err != nil {
+		return "", nil, &VerificationError{Err: err}
+	}
+
+	return s.url(repository, "blobs", layer.Digest), sv, nil
+}
+
+// ResolveFile reports that registries do not serve the release tree
+func (s *ociSource) ResolveFile(path string) (string, error) {
+	return "", fmt.Errorf("%s on OCI registry %s: %w", path, s.registry, errNotServed)
+}
+
+// Authorize adds the registry credentials to requests for its API
+func (s *ociSource) Authorize(req *http.Request) error {
+	if req.URL.Host != s.registry {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.basic {
+		req.SetBasicAuth(s.opts.Username, s.opts.Password)
+		return nil
+	}
+	if token, ok := s.tokens[repositoryFromPath(req.URL.Path)]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// fetchManifest fetches a manifest by tag or digest. Manifests fetched by
+// digest must hash to that digest.
+func (s *ociSource) fetchManifest(repository, reference string) (*ociManifest, error) {
+	accept := strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerManifest}, ", ")
+
+	resp, err := s.get(s.url(repository, "manifests", reference), accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOCIManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if strings.HasPrefix(reference, "sha256:") {
+		sum := sha256.Sum256(data)
+		if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != reference {
+			return nil, &VerificationError{Err: fmt.Errorf("manifest digest mismatch: expected %s, got %s", reference, actual)}
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// get performs a registry request, authenticating once if challenged
+func (s *ociSource) get(rawURL, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := s.authenticate(challenge, repositoryFromPath(req.URL.Path)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate answers a registry auth challenge, fetching a bearer
+// token for the repository from the token service when asked to
+func (s *ociSource) authenticate(challenge, repository string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch scheme {
+	case "basic":
+		if s.opts.Username == "" {
+			return fmt.Errorf("registry %s requires credentials", s.registry)
+		}
+		s.mu.Lock()
+		s.basic = true
+		s.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported registry auth challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	if s.opts.Username != "" {
+		req.SetBasicAuth(s.opts.Username, s.opts.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch registry token: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to parse registry token: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("registry returned an empty token")
+	}
+
+	s.mu.Lock()
+	s.tokens[repository] = token
+	s.mu.Unlock()
+
+	return nil
+}
+
+// url builds a registry API URL
+func (s *ociSource) url(repository, kind, reference string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", s.scheme, s.registry, repository, kind, reference)
+}
+
+// selectPlatform picks the manifest for the running platform
+func selectPlatform(manifests []ociDescriptor) (ociDescriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform != nil && desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("no manifest for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// selectLayer picks the layer holding the component binary: the one
+// titled with the published file name, or the only layer there is
+func selectLayer(layers []ociDescriptor, filename string) (ociDescriptor, error) {
+	for _, layer := range layers {
+		if layer.Annotations[annotationTitle] == filename {
+			return layer, nil
+		}
+	}
+	if len(layers) == 1 {
+		return layers[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("no layer named %s", filename)
+}
+
+// newDigestVerifier checks a blob against its descriptor
+func newDigestVerifier(digest string, size int64) (StreamVerifier, error) {
+	hexDigest, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+	return newTargetVerifier(verifier.TUFTarget{
+		Length: size,
+		Hashes: map[string]string{"sha256": hexDigest},
+	}), nil
+}
+
+// repositoryFromPath extracts the repository from a /v2/ API path
+func repositoryFromPath(path string) string {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, kind := range []string{"/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(path, kind); i >= 0 {
+			return path[:i]
+		}
+	}
+	return path
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and
+// parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return strings.ToLower(scheme), params
+}
+
+// dockerCredentials looks up registry credentials stored by `docker login`
+func dockerCredentials(registry string) (string, string) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".docker", "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+
+	entry, ok := cfg.Auths[registry]
+	if !ok {
+		entry, ok = cfg.Auths["https://"+registry]
+	}
+	if !ok {
+		return "", ""
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return "", ""
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", ""
+	}
+	return username, password
+}
@@ -0,0 +1,359 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/mattn/go-isatty"
+)
+
+// barTemplate is the layout of download progress bars
+const barTemplate = `{{string . "prefix"}}{{counters . }} {{bar . }} {{percent . }} {{speed . }} {{rtime . "ETA %s"}}`
+
+// jsonProgressInterval is the minimum time between two JSON progress
+// events for the same file
+const jsonProgressInterval = time.Second
+
+// ProgressReporter is told about the progress of every downloaded file.
+// Files are identified by name, and several files may be in progress at
+// once, so implementations must be safe for concurrent use.
+type ProgressReporter interface {
+	// Start is called when a transfer begins. total is -1 when the size
+	// is unknown; current is non-zero when a download is resumed.
+	Start(name string, total, current int64)
+	// Update reports the number of bytes transferred so far
+	Update(name string, current int64)
+	// Finish is called once the transfer ended, with its error if any
+	Finish(name string, err error)
+}
+
+// NewProgress returns the progress reporter for a mode: "tty" draws
+// progress bars, "log" writes progress to the log, "json" writes one
+// JSON event per line to stderr, and "auto" or "" picks "tty" when
+// stdout is a terminal and "log" otherwise.
+func NewProgress(mode string, log Logger) (ProgressReporter, error) {
+	switch mode {
+	case "", "auto":
+		return autoProgress(log), nil
+	case "tty":
+		return NewTTYProgress(), nil
+	case "log":
+		return NewLogProgress(log), nil
+	case "json":
+		return NewJSONProgress(os.Stderr), nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+}
+
+// autoProgress draws progress bars on terminals and logs progress
+// everywhere else, such as under systemd or in CI
+func autoProgress(log Logger) ProgressReporter {
+	if isTerminal(os.Stdout) {
+		return NewTTYProgress()
+	}
+	return NewLogProgress(log)
+}
+
+// isTerminal reports whether a file is an interactive terminal
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// SetProgress sets where download progress is reported. A nil reporter
+// disables progress reporting.
+func (d *Downloader) SetProgress(reporter ProgressReporter) {
+	d.progress = reporter
+}
+
+// AddProgress reports download progress to another reporter as well as
+// the one set, e.g. to stream it to a wrapper around the bootstrap
+func (d *Downloader) AddProgress(reporter ProgressReporter) {
+	if d.progress == nil {
+		d.progress = reporter
+		return
+	}
+	d.progress = multiProgress{d.progress, reporter}
+}
+
+// StartProgressGroup draws the progress bars of the downloads started
+// from now on together, one line per file, so that concurrent downloads
+// do not overwrite each other's bar. The returned function stops the
+// group and must be called once the downloads are done. Reporters other
+// than the TTY one need no grouping.
+func (d *Downloader) StartProgressGroup() func() {
+	reporters := multiProgress{d.progress}
+	if multi, ok := d.progress.(multiProgress); ok {
+		reporters = multi
+	}
+	for _, reporter := range reporters {
+		if tty, ok := reporter.(*ttyProgress); ok {
+			return tty.startGroup(d.log)
+		}
+	}
+	return func() {}
+}
+
+// fileProgress counts the bytes written to it and reports them
+type fileProgress struct {
+	reporter ProgressReporter
+	name     string
+	current  atomic.Int64
+}
+
+// startProgress reports the start of a transfer. The returned value is
+// written every transferred byte and finished when the transfer ends.
+func (d *Downloader) startProgress(name string, total, current int64) *fileProgress {
+	reporter := d.progress
+	if reporter == nil {
+		reporter = nopProgress{}
+	}
+
+	p := &fileProgress{reporter: reporter, name: name}
+	p.current.Store(current)
+	reporter.Start(name, total, current)
+	return p
+}
+
+func (p *fileProgress) Write(b []byte) (int, error) {
+	p.reporter.Update(p.name, p.current.Add(int64(len(b))))
+	return len(b), nil
+}
+
+func (p *fileProgress) finish(err error) {
+	p.reporter.Finish(p.name, err)
+}
+
+// multiProgress reports progress to several reporters
+type multiProgress []ProgressReporter
+
+func (m multiProgress) Start(name string, total, current int64) {
+	for _, reporter := range m {
+		reporter.Start(name, total, current)
+	}
+}
+
+func (m multiProgress) Update(name string, current int64) {
+	for _, reporter := range m {
+		reporter.Update(name, current)
+	}
+}
+
+func (m multiProgress) Finish(name string, err error) {
+	for _, reporter := range m {
+		reporter.Finish(name, err)
+	}
+}
+
+// nopProgress discards progress
+type nopProgress struct{}
+
+func (nopProgress) Start(string, int64, int64) {}
+func (nopProgress) Update(string, int64)       {}
+func (nopProgress) Finish(string, error)       {}
+
+// ttyProgress draws a progress bar per file
+type ttyProgress struct {
+	mu   sync.Mutex
+	bars map[string]*pb.ProgressBar
+	pool *pb.Pool
+}
+
+// NewTTYProgress returns a reporter that draws progress bars on the
+// terminal
+func NewTTYProgress() ProgressReporter {
+	return &ttyProgress{bars: map[string]*pb.ProgressBar{}}
+}
+
+func (t *ttyProgress) Start(name string, total, current int64) {
+	bar := pb.New64(total)
+	bar.SetTemplateString(barTemplate)
+	bar.SetCurrent(current)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pool != nil {
+		bar.Set("prefix", name+" ")
+		t.pool.Add(bar)
+	} else {
+		bar.Start()
+	}
+	t.bars[name] = bar
+}
+
+func (t *ttyProgress) Update(name string, current int64) {
+	t.mu.Lock()
+	bar := t.bars[name]
+	t.mu.Unlock()
+
+	if bar != nil {
+		bar.SetCurrent(current)
+	}
+}
+
+func (t *ttyProgress) Finish(name string, err error) {
+	t.mu.Lock()
+	bar := t.bars[name]
+	delete(t.bars, name)
+	t.mu.Unlock()
+
+	if bar != nil {
+		bar.Finish()
+	}
+}
+
+// startGroup makes bars started from now on share one multi-line display
+func (t *ttyProgress) startGroup(log Logger) func() {
+	pool := pb.NewPool()
+	if err := pool.Start(); err != nil {
+		log.Errorf("Failed to start progress display: %v", err)
+		return func() {}
+	}
+
+	t.mu.Lock()
+	t.pool = pool
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		t.pool = nil
+		t.mu.Unlock()
+		pool.Stop()
+	}
+}
+
+// logProgress writes progress to the log in steps of 10 percent, which
+// keeps journald and CI logs readable
+type logProgress struct {
+	log   Logger
+	mu    sync.Mutex
+	files map[string]*loggedFile
+}
+
+// loggedFile is the progress last logged for a file
+type loggedFile struct {
+	total int64
+	step  int64
+}
+
+// NewLogProgress returns a reporter that logs progress
+func NewLogProgress(log Logger) ProgressReporter {
+	return &logProgress{log: log, files: map[string]*loggedFile{}}
+}
+
+func (l *logProgress) Start(name string, total, current int64) {
+	file := &loggedFile{total: total}
+	if total > 0 {
+		file.step = current * 10 / total
+	}
+
+	l.mu.Lock()
+	l.files[name] = file
+	l.mu.Unlock()
+
+	switch {
+	case current > 0:
+		l.log.Infof("%s: resuming at %d of %d bytes", name, current, total)
+	case total > 0:
+		l.log.Infof("%s: transferring %d bytes", name, total)
+	}
+}
+
+func (l *logProgress) Update(name string, current int64) {
+	l.mu.Lock()
+	file := l.files[name]
+	if file == nil || file.total <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	step := current * 10 / file.total
+	if step <= file.step || step >= 10 {
+		l.mu.Unlock()
+		return
+	}
+	file.step = step
+	l.mu.Unlock()
+
+	l.log.Infof("%s: %d%% (%d of %d bytes)", name, step*10, current, file.total)
+}
+
+func (l *logProgress) Finish(name string, err error) {
+	l.mu.Lock()
+	delete(l.files, name)
+	l.mu.Unlock()
+
+	if err == nil {
+		l.log.Infof("%s: transfer complete", name)
+	}
+}
+
+// jsonProgress writes progress as JSON events, one per line
+type jsonProgress struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	files map[string]*jsonFile
+}
+
+// jsonFile is what the JSON reporter remembers about a file
+type jsonFile struct {
+	total   int64
+	updated time.Time
+}
+
+// progressEvent is a JSON progress event
+type progressEvent struct {
+	Event   string    `json:"event"`
+	File    string    `json:"file"`
+	Current int64     `json:"current,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// NewJSONProgress returns a reporter that writes JSON events to w. Update
+// events are emitted at most once a second per file.
+func NewJSONProgress(w io.Writer) ProgressReporter {
+	return &jsonProgress{enc: json.NewEncoder(w), files: map[string]*jsonFile{}}
+}
+
+func (j *jsonProgress) Start(name string, total, current int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.files[name] = &jsonFile{total: total, updated: time.Now()}
+	j.emit(progressEvent{Event: "start", File: name, Current: current, Total: total})
+}
+
+func (j *jsonProgress) Update(name string, current int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	file := j.files[name]
+	if file == nil || time.Since(file.updated) < jsonProgressInterval {
+		return
+	}
+	file.updated = time.Now()
+	j.emit(progressEvent{Event: "progress", File: name, Current: current, Total: file.total})
+}
+
+func (j *jsonProgress) Finish(name string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.files, name)
+
+	event := progressEvent{Event: "finish", File: name}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	j.emit(event)
+}
+
+// emit writes an event; the caller holds j.mu
+func (j *jsonProgress) emit(event progressEvent) {
+	event.Time = time.Now().UTC()
+	j.enc.Encode(event)
+}
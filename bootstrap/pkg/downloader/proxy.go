@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// maxPACSize bounds the proxy auto-config scripts read
+const maxPACSize = 1 << 20
+
+// pacReturn matches the string literals a PAC script returns
+var pacReturn = regexp.MustCompile(`return\s*["']([^"']*)["']`)
+
+// ProxyOptions configures how the downloader reaches the network
+type ProxyOptions struct {
+	// URL is an explicit http://, https:// or socks5:// proxy. It takes
+	// precedence over the environment and the system settings.
+	URL string
+	// NoProxy lists hosts to reach directly, in NO_PROXY syntax. The
+	// NO_PROXY environment variable is used when empty.
+	NoProxy string
+}
+
+// systemProxy is the proxy configuration of the operating system
+type systemProxy struct {
+	// config holds statically configured proxies
+	config *httpproxy.Config
+	// pacURL is the address of a proxy auto-config script
+	pacURL string
+}
+
+// SetProxy configures the proxy used for every request. Without an
+// explicit proxy, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored, and
+// failing those the proxy settings of Windows or macOS, including
+// auto-config scripts.
+func (d *Downloader) SetProxy(opts ProxyOptions) error {
+	noProxy := opts.NoProxy
+	if noProxy == "" {
+		noProxy = firstEnv("NO_PROXY", "no_proxy")
+	}
+
+	var config *httpproxy.Config
+	switch {
+	case opts.URL != "":
+		proxy, err := url.Parse(opts.URL)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", opts.URL)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+		}
+		config = &httpproxy.Config{HTTPProxy: opts.URL, HTTPSProxy: opts.URL, NoProxy: noProxy}
+		d.log.Infof("Using proxy %s", proxy.Redacted())
+
+	case firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy") != "":
+		config = httpproxy.FromEnvironment()
+		config.NoProxy = noProxy
+
+	default:
+		system, err := detectSystemProxy()
+		if err != nil {
+			d.log.Errorf("Could not read system proxy settings: %v", err)
+			break
+		}
+		if system.pacURL != "" {
+			proxy, err := pacProxy(system.pacURL)
+			if err != nil {
+				d.log.Errorf("Ignoring proxy auto-config %s: %v", system.pacURL, err)
+			} else if proxy != "" {
+				system.config = &httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy}
+			}
+		}
+		config = system.config
+		if config != nil {
+			if config.NoProxy == "" {
+				config.NoProxy = noProxy
+			}
+			d.log.Infof("Using system proxy settings")
+		}
+	}
+
+	d.proxy = nil
+	if config != nil {
+		proxyFunc := config.ProxyFunc()
+		d.proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	d.rebuildTransport()
+
+	return nil
+}
+
+// pacProxy fetches a proxy auto-config script and returns the proxy it
+// selects. Scripts are not evaluated, so only scripts that always pick
+// the same proxy (besides DIRECT) are supported.
+func pacProxy(pacURL string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{Proxy: nil}}
+
+	var data []byte
+	if u, err := url.Parse(pacURL); err == nil && u.Scheme == "file" {
+		if data, err = os.ReadFile(u.Path); err != nil {
+			return "", err
+		}
+	} else {
+		resp, err := client.Get(pacURL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", &StatusError{StatusCode: resp.StatusCode}
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxPACSize)); err != nil {
+			return "", err
+		}
+	}
+
+	proxies := map[string]bool{}
+	for _, match := range pacReturn.FindAllStringSubmatch(string(data), -1) {
+		for _, entry := range strings.Split(match[1], ";") {
+			fields := strings.Fields(entry)
+			if len(fields) != 2 {
+				continue
+			}
+			switch strings.ToUpper(fields[0]) {
+			case "PROXY", "HTTP":
+				proxies["http://"+fields[1]] = true
+			case "HTTPS":
+				proxies["https://"+fields[1]] = true
+			case "SOCKS", "SOCKS5":
+				proxies["socks5://"+fields[1]] = true
+			}
+			// Only the first proxy of each return is used
+			break
+		}
+	}
+
+	switch len(proxies) {
+	case 0:
+		return "", nil
+	case 1:
+		for proxy := range proxies {
+			return proxy, nil
+		}
+	}
+	return "", fmt.Errorf("script chooses between %d proxies, which needs a PAC evaluator", len(proxies))
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// detectSystemProxy reads the proxy settings of the active network
+// service as reported by `scutil --proxy`
+func detectSystemProxy() (*systemProxy, error) {
+	out, err := exec.Command("scutil", "--proxy").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+	var exceptions []string
+	inExceptions := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "ExceptionsList"):
+			inExceptions = true
+			continue
+		case inExceptions && line == "}":
+			inExceptions = false
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		if inExceptions {
+			exceptions = append(exceptions, strings.TrimPrefix(value, "*"))
+		} else {
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	system := &systemProxy{}
+	if settings["ProxyAutoConfigEnable"] == "1" {
+		system.pacURL = settings["ProxyAutoConfigURLString"]
+	}
+
+	config := &httpproxy.Config{NoProxy: strings.Join(exceptions, ",")}
+	if settings["HTTPEnable"] == "1" && settings["HTTPProxy"] != "" {
+		config.HTTPProxy = fmt.Sprintf("http://%s:%s", settings["HTTPProxy"], settings["HTTPPort"])
+	}
+	if settings["HTTPSEnable"] == "1" && settings["HTTPSProxy"] != "" {
+		config.HTTPSProxy = fmt.Sprintf("http://%s:%s", settings["HTTPSProxy"], settings["HTTPSPort"])
+	}
+	if settings["SOCKSEnable"] == "1" && settings["SOCKSProxy"] != "" {
+		socks := fmt.Sprintf("socks5://%s:%s", settings["SOCKSProxy"], settings["SOCKSPort"])
+		if config.HTTPProxy == "" {
+			config.HTTPProxy = socks
+		}
+		if config.HTTPSProxy == "" {
+			config.HTTPSProxy = socks
+		}
+	}
+
+	if config.HTTPProxy != "" || config.HTTPSProxy != "" {
+		system.config = config
+	}
+	return system, nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package downloader
+
+// detectSystemProxy finds no system settings; Linux desktops export
+// their proxy through the environment
+func detectSystemProxy() (*systemProxy, error) {
+	return &systemProxy{}, nil
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/sys/windows/registry"
+)
+
+// detectSystemProxy reads the WinINet proxy settings of the current user
+func detectSystemProxy() (*systemProxy, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+
+	system := &systemProxy{}
+
+	if pac, _, err := key.GetStringValue("AutoConfigURL"); err == nil && pac != "" {
+		system.pacURL = pac
+	}
+
+	enabled, _, err := key.GetIntegerValue("ProxyEnable")
+	if err != nil || enabled == 0 {
+		return system, nil
+	}
+
+	server, _, err := key.GetStringValue("ProxyServer")
+	if err != nil || server == "" {
+		return system, nil
+	}
+
+	config := &httpproxy.Config{}
+
+	// ProxyServer is either "host:port" or "http=host:port;https=host:port"
+	if !strings.Contains(server, "=") {
+		config.HTTPProxy = "http://" + server
+		config.HTTPSProxy = "http://" + server
+	} else {
+		for _, entry := range strings.Split(server, ";") {
+			scheme, address, ok := strings.Cut(entry, "=")
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(scheme) {
+			case "http":
+				config.HTTPProxy = "http://" + address
+			case "https":
+				config.HTTPSProxy = "http://" + address
+			case "socks":
+				if config.HTTPSProxy == "" {
+					config.HTTPSProxy = "socks5://" + address
+				}
+				if config.HTTPProxy == "" {
+					config.HTTPProxy = "socks5://" + address
+				}
+			}
+		}
+	}
+
+	// ProxyOverride uses ';' separators and "<local>" for plain host names
+	if override, _, err := key.GetStringValue("ProxyOverride"); err == nil {
+		var hosts []string
+		for _, host := range strings.Split(override, ";") {
+			host = strings.TrimSpace(host)
+			if host == "" || host == "<local>" {
+				continue
+			}
+			hosts = append(hosts, strings.TrimPrefix(host, "*"))
+		}
+		config.NoProxy = strings.Join(hosts, ",")
+	}
+
+	system.config = config
+	return system, nil
+}
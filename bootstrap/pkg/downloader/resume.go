@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// remoteInfo describes a remote file as reported by a HEAD request
+type remoteInfo struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	AcceptRanges string `json:"-"`
+	length       int64
+}
+
+// remoteInfoFromHeader extracts the resume-relevant headers
+func remoteInfoFromHeader(header http.Header) remoteInfo {
+	length, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	return remoteInfo{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		AcceptRanges: header.Get("Accept-Ranges"),
+		length:       length,
+	}
+}
+
+// acceptsRanges reports whether the server advertised byte range support
+func (r remoteInfo) acceptsRanges() bool {
+	return strings.EqualFold(r.AcceptRanges, "bytes")
+}
+
+// validator returns the value to send in an If-Range header. Weak ETags
+// are not allowed there, so Last-Modified is used instead.
+func (r remoteInfo) validator() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// matches reports whether a saved partial download belongs to the same
+// version of the remote file
+func (r remoteInfo) matches(saved remoteInfo) bool {
+	if r.ETag != "" || saved.ETag != "" {
+		return r.ETag == saved.ETag
+	}
+	return r.LastModified != "" && r.LastModified == saved.LastModified
+}
+
+// resumeOffset returns the number of bytes of a partial download that
+// can be reused, discarding the partial file if it is stale
+func (d *Downloader) resumeOffset(partName string, remote remoteInfo) int64 {
+	info, err := os.Stat(partName)
+	if err != nil {
+		return 0
+	}
+
+	saved, err := readPartialInfo(partName)
+	if err != nil || !remote.matches(saved) || (remote.length > 0 && info.Size() > remote.length) {
+		d.log.Infof("Discarding stale partial download %s", partName)
+		discardPartial(partName)
+		return 0
+	}
+
+	return info.Size()
+}
+
+// readPartialInfo reads the validators recorded for a partial download
+func readPartialInfo(partName string) (remoteInfo, error) {
+	var info remoteInfo
+
+	data, err := os.ReadFile(partName + ".meta")
+	if err != nil {
+		return info, err
+	}
+
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// writePartialInfo records the validators for a partial download
+func writePartialInfo(partName string, remote remoteInfo) error {
+	data, err := json.Marshal(remote)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partName+".meta", data, 0644)
+}
+
+// finishPartial flushes a completed partial download to disk and moves
+// it into place. The rename is atomic, so after a crash name holds
+// either the previous file or the complete new one, never a truncated
+// binary.
+func finishPartial(partName, name string) error {
+	if err := syncFile(partName); err != nil {
+		return fmt.Errorf("failed to flush download: %w", err)
+	}
+	if err := os.Rename(partName, name); err != nil {
+		return err
+	}
+	os.Remove(partName + ".meta")
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+// syncFile flushes a file's contents to stable storage
+func syncFile(name string) error {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir flushes a directory so that a rename into it survives a
+// crash. Not every platform can sync directories, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// discardPartial removes a partial download and its metadata
+func discardPartial(partName string) {
+	os.Remove(partName)
+	os.Remove(partName + ".meta")
+}
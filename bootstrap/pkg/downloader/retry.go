@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per server, including
+	// the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles on every
+	// further attempt up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction (0-1) of each delay that is randomized
+	Jitter float64
+	// RetryableStatus lists the HTTP status codes worth retrying
+	RetryableStatus []int
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is set
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     4,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		Jitter:          0.5,
+		RetryableStatus: []int{408, 429, 500, 502, 503, 504},
+	}
+}
+
+// SetRetryPolicy sets the retry policy for downloads
+func (d *Downloader) SetRetryPolicy(policy RetryPolicy) {
+	d.retry = policy
+}
+
+// withRetry runs fn with the downloader's retry policy
+func (d *Downloader) withRetry(ctx context.Context, what string, fn func() error) error {
+	return d.retry.Run(ctx, d.log, what, fn)
+}
+
+// Run runs fn until it succeeds, fails with a non-retryable error, the
+// attempts are exhausted or ctx is cancelled. Failed attempts are logged
+// to log when it is not nil.
+func (p RetryPolicy) Run(ctx context.Context, log Logger, what string, fn func() error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn()
+		if err == nil || ctx.Err() != nil || !p.retryable(err) || attempt == attempts {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		if log != nil {
+			log.Errorf("Attempt %d/%d for %s failed: %v (retrying in %s)", attempt, attempts, what, err, delay.Round(time.Millisecond))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// retryable reports whether an error is worth another attempt
+func (p RetryPolicy) retryable(err error) bool {
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		for _, code := range p.RetryableStatus {
+			if code == statusErr.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+	return !errors.Is(err, errRangesUnsupported) && !errors.Is(err, errNotServed)
+}
+
+// delay returns the backoff before the given retry attempt
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		spread := float64(delay) * p.Jitter
+		delay = time.Duration(float64(delay) - spread + rand.Float64()*spread)
+	}
+
+	return delay
+}
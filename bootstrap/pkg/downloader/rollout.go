@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+)
+
+// Rollout stages a release to some of the devices. A device is offered
+// the release when it matches every limit that is set. Without a
+// release index only upgrades hold a release back, since a fresh install
+// has no earlier version to take instead.
+type Rollout struct {
+	// Percentage is the share of devices, from 0 to 100, offered the
+	// release by their RolloutBucket; unset offers it to all of them
+	Percentage *float64 `json:"percentage,omitempty"`
+	// Tags offers the release only to devices with one of the tags,
+	// e.g. "canary"
+	Tags []string `json:"tags,omitempty"`
+	// Channels offers the release only to devices following one of the
+	// channels
+	Channels []string `json:"channels,omitempty"`
+}
+
+// RolloutTarget is the device that rollouts are decided for
+type RolloutTarget struct {
+	DeviceID string
+	Tags     []string
+	// ForceLatest ignores rollouts, so every release is taken as soon as
+	// it is published
+	ForceLatest bool
+}
+
+// SetRolloutTarget sets the device that staged releases are offered to
+func (d *Downloader) SetRolloutTarget(target RolloutTarget) {
+	d.versionsMu.Lock()
+	defer d.versionsMu.Unlock()
+
+	d.rollout = target
+	d.versionsResolved = false
+}
+
+// Offered reports whether a component release is offered to the device
+// under its rollout
+func (d *Downloader) Offered(component string, release ComponentManifest) bool {
+	d.versionsMu.Lock()
+	defer d.versionsMu.Unlock()
+	return d.offered(component, release)
+}
+
+// offered is Offered for callers holding versionsMu
+func (d *Downloader) offered(component string, release ComponentManifest) bool {
+	r := release.Rollout
+	if r == nil || d.rollout.ForceLatest {
+		return true
+	}
+	if len(r.Channels) > 0 && !containsFold(r.Channels, d.channelName()) {
+		return false
+	}
+	if len(r.Tags) > 0 && !anyFold(r.Tags, d.rollout.Tags) {
+		return false
+	}
+	if r.Percentage != nil {
+		return RolloutBucket(d.rollout.DeviceID, component, release.Version) < *r.Percentage
+	}
+	return true
+}
+
+// RolloutBucket places a device in [0, 100) for the rollout of a
+// component version. The same device always lands in the same bucket
+// for a release, so it stays in the rollout as its percentage grows,
+// while each release is staged to a different share of the devices.
+// Companions deciding rollouts bucket devices the same way.
+func RolloutBucket(deviceID, component, version string) float64 {
+	sum := sha256.Sum256([]byte(component + "/" + version + "/" + deviceID))
+	// The top 53 bits fit a float64 exactly
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53) * 100
+}
+
+// containsFold reports whether list holds value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyFold reports whether the lists share a value, ignoring case
+func anyFold(list, values []string) bool {
+	for _, value := range values {
+		if containsFold(list, value) {
+			return true
+		}
+	}
+	return false
+}
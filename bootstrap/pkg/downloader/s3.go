@@ -0,0 +1,395 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the keys used to sign S3 requests
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// s3Source fetches the release tree from an S3 bucket. A base URL of
+// s3://bucket/prefix maps releases/index.json to the key
+// prefix/releases/index.json. The region comes from a ?region=
+// query parameter or the AWS environment, and AWS_ENDPOINT_URL_S3 points
+// to S3-compatible stores such as MinIO.
+type s3Source struct {
+	d         *Downloader
+	bucket    string
+	prefix    string
+	region    string
+	endpoint  *url.URL
+	pathStyle bool
+
+	mu          sync.Mutex
+	creds       *awsCredentials
+	credsLoaded bool
+}
+
+func newS3Source(d *Downloader, base *url.URL) (Source, error) {
+	if base.Host == "" {
+		return nil, fmt.Errorf("S3 base URL %q has no bucket", base.String())
+	}
+
+	region := base.Query().Get("region")
+	if region == "" {
+		region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	s := &s3Source{
+		d:      d,
+		bucket: base.Host,
+		prefix: strings.Trim(base.Path, "/"),
+		region: region,
+	}
+
+	if custom := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); custom != "" {
+		endpoint, err := url.Parse(custom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint %q: %w", custom, err)
+		}
+		s.endpoint = endpoint
+		s.pathStyle = true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", region)}
+		// Dotted bucket names do not match the wildcard certificate
+		s.pathStyle = strings.Contains(s.bucket, ".")
+	}
+
+	return s, nil
+}
+
+// ResolveComponent returns the object URL of a component
+func (s *s3Source) ResolveComponent(component string) (string, StreamVerifier, error) {
+	url, err := s.ResolveFile(s.d.componentPath(component))
+	return url, nil, err
+}
+
+// ResolveFile returns the object URL of a file, discovering credentials
+// first so that requests for it can be signed
+func (s *s3Source) ResolveFile(file string) (string, error) {
+	if err := s.loadCredentials(); err != nil {
+		return "", err
+	}
+
+	key := escapeKey(objectKey(s.prefix, file))
+	if s.pathStyle {
+		return fmt.Sprintf("%s://%s/%s/%s", s.endpoint.Scheme, s.endpoint.Host, s.bucket, key), nil
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", s.endpoint.Scheme, s.bucket, s.endpoint.Host, key), nil
+}
+
+// Authorize signs requests to the bucket with AWS Signature Version 4
+func (s *s3Source) Authorize(req *http.Request) error {
+	if !s.owns(req.URL) {
+		return nil
+	}
+
+	s.mu.Lock()
+	creds := s.creds
+	s.mu.Unlock()
+
+	if creds == nil {
+		// Public bucket
+		return nil
+	}
+
+	signV4(req, creds, s.region, "s3", time.Now().UTC())
+	return nil
+}
+
+// owns reports whether a URL points into the bucket
+func (s *s3Source) owns(u *url.URL) bool {
+	if s.pathStyle {
+		return u.Host == s.endpoint.Host && strings.HasPrefix(u.Path, "/"+s.bucket+"/")
+	}
+	return u.Host == s.bucket+"."+s.endpoint.Host
+}
+
+// loadCredentials discovers credentials the way the AWS SDKs do: the
+// environment, the shared credentials file, the ECS task role and the
+// EC2 instance role. Without any, requests are sent unsigned.
+func (s *s3Source) loadCredentials() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.credsLoaded && (s.creds == nil || s.creds.Expiration.IsZero() || time.Until(s.creds.Expiration) > 5*time.Minute) {
+		return nil
+	}
+
+	creds, err := discoverAWSCredentials()
+	if err != nil {
+		return fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	s.creds = creds
+	s.credsLoaded = true
+
+	return nil
+}
+
+func discoverAWSCredentials() (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	if creds := sharedAWSCredentials(); creds != nil {
+		return creds, nil
+	}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return fetchAWSCredentials("http://169.254.170.2"+uri, nil)
+	}
+
+	return ec2RoleCredentials()
+}
+
+// sharedAWSCredentials reads the profile selected by AWS_PROFILE from
+// the shared credentials file
+func sharedAWSCredentials() *awsCredentials {
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	creds := &awsCredentials{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.Token = strings.TrimSpace(value)
+		}
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil
+	}
+	return creds
+}
+
+// ec2RoleCredentials fetches the instance role credentials through
+// IMDSv2. Outside EC2 the metadata service is unreachable and nil is
+// returned so that requests go out unsigned.
+func ec2RoleCredentials() (*awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+
+	req, err := http.NewRequest("PUT", imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, nil
+	}
+	token, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+
+	roles, err := metadataGet(imds+"/meta-data/iam/security-credentials/", headers)
+	if err != nil {
+		// No instance role attached
+		return nil, nil
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return nil, nil
+	}
+
+	return fetchAWSCredentials(imds+"/meta-data/iam/security-credentials/"+role, headers)
+}
+
+// fetchAWSCredentials reads credentials from a metadata endpoint
+func fetchAWSCredentials(endpoint string, headers map[string]string) (*awsCredentials, error) {
+	data, err := metadataGet(endpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds awsCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	if creds.AccessKeyID == "" {
+		return nil, fmt.Errorf("metadata service returned no credentials")
+	}
+
+	return &creds, nil
+}
+
+// metadataGet reads a value from an instance metadata service
+func metadataGet(endpoint string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// signV4 adds an AWS Signature Version 4 authorization header. The
+// payload is left unsigned, which S3 accepts for downloads.
+func signV4(req *http.Request, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           amzDate,
+	}
+	if creds.Token != "" {
+		headers["x-amz-security-token"] = creds.Token
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by name
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEscape(key)+"="+uriEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// firstEnv returns the first non-empty environment variable
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SBOM formats
+const (
+	SBOMCycloneDX = "cyclonedx"
+	SBOMSPDX      = "spdx"
+)
+
+// sbomSuffixes are appended to a file name to find its SBOM, in order of
+// preference
+var sbomSuffixes = []string{".cdx.json", ".spdx.json"}
+
+// SBOM is the list of packages a component is built from
+type SBOM struct {
+	Format   string
+	Packages []SBOMPackage
+}
+
+// SBOMPackage is a package listed in an SBOM
+type SBOMPackage struct {
+	Name    string
+	Version string
+	// PURL is the package URL, such as pkg:golang/golang.org/x/net@v0.17.0
+	PURL string
+}
+
+// cycloneDXComponent is the subset of a CycloneDX component we read
+type cycloneDXComponent struct {
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	PURL       string               `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+// sbomDocument holds the fields of both CycloneDX and SPDX JSON documents
+// needed to tell them apart and list their packages
+type sbomDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	Components  []cycloneDXComponent `json:"components"`
+	SPDXVersion string               `json:"spdxVersion"`
+	Packages    []struct {
+		Name         string `json:"name"`
+		VersionInfo  string `json:"versionInfo"`
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+// FetchSBOM fetches the CycloneDX or SPDX SBOM published next to a
+// component. location is where the component was downloaded from with
+// DownloadFile, or empty for its release file.
+func (d *Downloader) FetchSBOM(ctx context.Context, component, location string) (*SBOM, error) {
+	if location == "" {
+		location = d.componentPath(component)
+	}
+
+	var lastErr error
+	for _, suffix := range sbomSuffixes {
+		data, err := d.FetchFile(ctx, location+suffix)
+		if err != nil {
+			lastErr = err
+			if IsNotPublished(err) {
+				continue
+			}
+			return nil, err
+		}
+		return ParseSBOM(data)
+	}
+	return nil, lastErr
+}
+
+// ParseSBOM reads the packages of a CycloneDX or SPDX JSON document
+func ParseSBOM(data []byte) (*SBOM, error) {
+	var doc sbomDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse SBOM: %w", err)
+	}
+
+	switch {
+	case strings.EqualFold(doc.BOMFormat, "CycloneDX"):
+		sbom := &SBOM{Format: SBOMCycloneDX}
+		sbom.addCycloneDX(doc.Components)
+		return sbom, nil
+	case strings.HasPrefix(doc.SPDXVersion, "SPDX-"):
+		sbom := &SBOM{Format: SBOMSPDX}
+		for _, pkg := range doc.Packages {
+			entry := SBOMPackage{Name: pkg.Name, Version: pkg.VersionInfo}
+			for _, ref := range pkg.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					entry.PURL = ref.ReferenceLocator
+				}
+			}
+			sbom.Packages = append(sbom.Packages, entry)
+		}
+		return sbom, nil
+	default:
+		return nil, fmt.Errorf("unsupported SBOM format")
+	}
+}
+
+// addCycloneDX adds CycloneDX components and their nested components
+func (s *SBOM) addCycloneDX(components []cycloneDXComponent) {
+	for _, c := range components {
+		s.Packages = append(s.Packages, SBOMPackage{Name: c.Name, Version: c.Version, PURL: c.PURL})
+		s.addCycloneDX(c.Components)
+	}
+}
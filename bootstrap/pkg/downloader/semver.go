@@ -0,0 +1,181 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. Missing minor and patch numbers
+// are zero and build metadata is ignored.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses versions such as 1.4.2, v2.0 or 1.5.0-rc.1
+func parseSemver(s string) (semver, error) {
+	var v semver
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 || parts[0] == "" {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	numbers := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*numbers[i] = n
+	}
+
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 following semver precedence
+func (v semver) compare(o semver) int {
+	for _, pair := range [][2]int{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if pair[0] != pair[1] {
+			return cmpInt(pair[0], pair[1])
+		}
+	}
+
+	// A pre-release sorts before its release
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, b := v.pre[i], o.pre[i]
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return cmpInt(an, bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case a != b:
+			return strings.Compare(a, b)
+		}
+	}
+	return cmpInt(len(v.pre), len(o.pre))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionConstraint is a set of comparisons that must all hold, written
+// like ">=2.0 <3.0", "~1.4", "^2" or an exact version
+type versionConstraint []comparison
+
+type comparison struct {
+	op      string
+	version semver
+}
+
+// parseConstraint parses a space separated list of comparisons
+func parseConstraint(s string) (versionConstraint, error) {
+	var constraint versionConstraint
+
+	for _, field := range strings.Fields(s) {
+		op := ""
+		for _, candidate := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				break
+			}
+		}
+
+		version, err := parseSemver(strings.TrimPrefix(field, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		if op == "" {
+			op = "="
+		}
+		constraint = append(constraint, comparison{op: op, version: version})
+	}
+
+	if len(constraint) == 0 {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+	return constraint, nil
+}
+
+// exact returns the version a constraint pins, if it pins exactly one
+func (c versionConstraint) exact() (semver, bool) {
+	if len(c) == 1 && c[0].op == "=" {
+		return c[0].version, true
+	}
+	return semver{}, false
+}
+
+// matches reports whether a version satisfies every comparison
+func (c versionConstraint) matches(v semver) bool {
+	for _, cmp := range c {
+		result := v.compare(cmp.version)
+		var ok bool
+		switch cmp.op {
+		case "=":
+			ok = result == 0
+		case "!=":
+			ok = result != 0
+		case ">":
+			ok = result > 0
+		case ">=":
+			ok = result >= 0
+		case "<":
+			ok = result < 0
+		case "<=":
+			ok = result <= 0
+		case "~":
+			// Patch releases of the same minor version
+			ok = result >= 0 && v.major == cmp.version.major && v.minor == cmp.version.minor
+		case "^":
+			// Compatible releases of the same major version
+			ok = result >= 0 && v.major == cmp.version.major
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionMatches reports whether a version satisfies a constraint written
+// like the component pins, such as ">=1.2 <1.4.7" or "~2.1"
+func VersionMatches(version, constraint string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+	c, err := parseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.matches(v), nil
+}
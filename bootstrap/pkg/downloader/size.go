@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// FileSize returns the size of a file on the primary server from the
+// Content-Length of a HEAD request. The location is a URL or a path under
+// the base URL, as for DownloadFile. Zero means the server did not say.
+func (d *Downloader) FileSize(ctx context.Context, location string) (int64, error) {
+	url := location
+	if !isAbsoluteURL(location) {
+		var err error
+		if url, err = d.fileURL(d.baseURL, location); err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := d.client.R().SetContext(ctx).Head(url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return 0, &StatusError{StatusCode: resp.StatusCode()}
+	}
+
+	size, _ := strconv.ParseInt(resp.Header().Get("Content-Length"), 10, 64)
+	return size, nil
+}
+
+// ComponentSize returns the size of a component's release file on the
+// primary server, or zero if the server did not say
+func (d *Downloader) ComponentSize(ctx context.Context, component string) (int64, error) {
+	if err := d.resolveVersions(ctx); err != nil {
+		return 0, fmt.Errorf("failed to resolve component versions: %w", err)
+	}
+	return d.FileSize(ctx, d.componentPath(component))
+}
@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Source is a distribution backend other than a plain HTTP release tree.
+// It resolves components and release files to HTTP(S) URLs and supplies
+// the credentials needed to fetch them.
+type Source interface {
+	// ResolveComponent returns the URL of a component for this platform,
+	// and a verifier for the digest the backend publishes for it, if any
+	ResolveComponent(component string) (string, StreamVerifier, error)
+
+	// ResolveFile returns the URL of a file in the release tree, such as
+	// releases/index.json
+	ResolveFile(path string) (string, error)
+
+	// Authorize adds credentials to a request for a resolved URL.
+	// Requests to hosts the source does not own must be left untouched.
+	Authorize(req *http.Request) error
+}
+
+// errNotServed means a base URL cannot serve a file at all. It is not
+// retried, but the next mirror is tried.
+var errNotServed = errors.New("not served by this source")
+
+// sourceFactory creates the source for a base URL
+type sourceFactory func(d *Downloader, base *url.URL) (Source, error)
+
+// sourceSchemes maps base URL schemes to their backends. Plain http and
+// https base URLs are fetched directly.
+var sourceSchemes = map[string]sourceFactory{
+	"oci":    newOCISource,
+	"s3":     newS3Source,
+	"gs":     newGCSSource,
+	"azblob": newAzblobSource,
+	"github": newGitHubSource,
+}
+
+// sourceFor returns the source for a base URL, or nil for plain HTTP
+// release trees. Sources are created once and reused.
+func (d *Downloader) sourceFor(baseURL string) (Source, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	scheme := strings.ToLower(base.Scheme)
+	if scheme == "http" || scheme == "https" {
+		return nil, nil
+	}
+
+	factory, ok := sourceSchemes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported base URL scheme %q: %w", base.Scheme, errNotServed)
+	}
+
+	d.sourcesMu.Lock()
+	defer d.sourcesMu.Unlock()
+
+	if source, ok := d.sources[baseURL]; ok {
+		return source, nil
+	}
+
+	source, err := factory(d, base)
+	if err != nil {
+		return nil, err
+	}
+	if d.sources == nil {
+		d.sources = map[string]Source{}
+	}
+	d.sources[baseURL] = source
+
+	return source, nil
+}
+
+// componentLocation returns the URL of a component on a base URL and a
+// verifier for any digest the backend pins it to
+func (d *Downloader) componentLocation(ctx context.Context, baseURL, component string) (string, StreamVerifier, error) {
+	if err := d.resolveVersions(ctx); err != nil {
+		return "", nil, err
+	}
+
+	source, err := d.sourceFor(baseURL)
+	if err != nil {
+		return "", nil, err
+	}
+	if source != nil {
+		return source.ResolveComponent(component)
+	}
+	return d.componentURL(baseURL, component), nil, nil
+}
+
+// fileURL returns the URL of a file in the release tree on a base URL
+func (d *Downloader) fileURL(baseURL, path string) (string, error) {
+	source, err := d.sourceFor(baseURL)
+	if err != nil {
+		return "", err
+	}
+	if source != nil {
+		return source.ResolveFile(path)
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(baseURL, "/"), strings.TrimPrefix(path, "/")), nil
+}
+
+// sourceTransport adds source credentials to outgoing requests and
+// applies the download rate limit to their responses
+type sourceTransport struct {
+	d    *Downloader
+	next http.RoundTripper
+}
+
+func (t *sourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.d.sourcesMu.Lock()
+	sources := make([]Source, 0, len(t.d.sources))
+	for _, source := range t.d.sources {
+		sources = append(sources, source)
+	}
+	t.d.sourcesMu.Unlock()
+
+	// Only the companion is told the CPU features
+	features := len(t.d.target.Features) > 0 && t.d.baseURL != "" &&
+		strings.HasPrefix(req.URL.String(), t.d.baseURL)
+	if len(sources) > 0 || features {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		if features {
+			req.Header.Set(cpuFeaturesHeader, strings.Join(t.d.target.Features, ","))
+		}
+		for _, source := range sources {
+			if err := source.Authorize(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if limiter := t.d.limiter; limiter != nil {
+		resp.Body = &throttledBody{body: resp.Body, limiter: limiter, ctx: req.Context()}
+	}
+	return resp, nil
+}
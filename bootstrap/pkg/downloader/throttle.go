@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleBurst caps the bytes read at once from a throttled body
+const maxThrottleBurst = 64 << 10
+
+// rateUnits maps size suffixes to bytes
+var rateUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+}
+
+// DownloadWindow is a daily time range during which downloads may run.
+// A window whose end is before its start spans midnight.
+type DownloadWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseRate parses a transfer rate such as "2MiB/s" or "500KB" into
+// bytes per second
+func ParseRate(s string) (int64, error) {
+	return parseBytes(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"), s, "rate")
+}
+
+// ParseSize parses a size such as "2GiB" or "500MB" into bytes
+func ParseSize(s string) (int64, error) {
+	return parseBytes(strings.ToLower(strings.TrimSpace(s)), s, "size")
+}
+
+// parseBytes parses a number with an optional size suffix. s is the
+// original text and what names the quantity in errors.
+func parseBytes(value, s, what string) (int64, error) {
+	i := 0
+	for i < len(value) && (value[i] >= '0' && value[i] <= '9' || value[i] == '.') {
+		i++
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", what, s)
+	}
+	unit, ok := rateUnits[strings.TrimSpace(value[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s unit in %q", what, s)
+	}
+
+	bytes := int64(number * unit)
+	if bytes <= 0 {
+		return 0, fmt.Errorf("%s %q must be positive", what, s)
+	}
+	return bytes, nil
+}
+
+// ParseWindow parses a window such as "01:00-05:00"
+func ParseWindow(s string) (DownloadWindow, error) {
+	start, end, ok := strings.Cut(strings.ReplaceAll(s, "–", "-"), "-")
+	if !ok {
+		return DownloadWindow{}, fmt.Errorf("invalid download window %q", s)
+	}
+
+	var window DownloadWindow
+	for _, part := range []struct {
+		text string
+		dest *time.Duration
+	}{{start, &window.Start}, {end, &window.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return DownloadWindow{}, fmt.Errorf("invalid download window %q: %w", s, err)
+		}
+		*part.dest = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+
+	return window, nil
+}
+
+// Contains reports whether a time of day falls inside the window
+func (w DownloadWindow) Contains(t time.Time) bool {
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+// Next returns the next time the window opens after t
+func (w DownloadWindow) Next(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	open := midnight.Add(w.Start)
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// SetRateLimit caps the combined download rate in bytes per second.
+// Zero removes the limit.
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		d.limiter = nil
+		return
+	}
+
+	burst := int(bytesPerSecond)
+	if burst > maxThrottleBurst {
+		burst = maxThrottleBurst
+	}
+	d.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// SetDownloadWindow restricts downloads to a daily time window. A nil
+// window allows downloads at any time.
+func (d *Downloader) SetDownloadWindow(window *DownloadWindow) {
+	d.window = window
+}
+
+// waitForWindow blocks until the download window is open or ctx is
+// cancelled. Downloads already running when the window closes are
+// allowed to finish.
+func (d *Downloader) waitForWindow(ctx context.Context) error {
+	if d.window == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if d.window.Contains(now) {
+		return nil
+	}
+
+	open := d.window.Next(now)
+	d.log.Infof("Outside the download window, waiting until %s", open.Format("15:04"))
+
+	timer := time.NewTimer(time.Until(open))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledBody limits how fast a response body can be read. All bodies
+// share the downloader's token bucket, so parallel connections together
+// stay under the limit.
+type throttledBody struct {
+	body    io.ReadCloser
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := t.body.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (t *throttledBody) Close() error {
+	return t.body.Close()
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures the TLS connections made by the downloader
+type TLSOptions struct {
+	// CACert is a PEM bundle of additional trusted certificate
+	// authorities, such as an internal CA signing the companion
+	CACert string
+	// ClientCert and ClientKey are a PEM certificate and key presented
+	// to servers that ask for one
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables server certificate checks. It is meant
+	// for lab setups only.
+	InsecureSkipVerify bool
+}
+
+// SetTLS configures custom certificate authorities and a client
+// certificate for mutual TLS
+func (d *Downloader) SetTLS(opts TLSOptions) error {
+	if opts.CACert == "" && opts.ClientCert == "" && opts.ClientKey == "" && !opts.InsecureSkipVerify {
+		d.tlsConfig = nil
+		d.rebuildTransport()
+		return nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		// Keep trusting public CAs for mirrors and cloud backends
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return fmt.Errorf("client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.InsecureSkipVerify {
+		d.log.Error("TLS certificate verification is disabled; do not use this outside a lab")
+		config.InsecureSkipVerify = true
+	}
+
+	d.tlsConfig = config
+	d.rebuildTransport()
+	return nil
+}
+
+// rebuildTransport applies the proxy and TLS settings to the transport
+// shared by every client of the downloader
+func (d *Downloader) rebuildTransport() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = d.proxy
+	if d.tlsConfig != nil {
+		transport.TLSClientConfig = d.tlsConfig.Clone()
+	}
+	d.transport.next = transport
+}
@@ -0,0 +1,181 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+
+	"github.com/ezra/bootstrap/pkg/verifier"
+)
+
+// SetTUF makes the downloader fetch components as targets of a TUF
+// repository. Every download is checked against the trusted targets
+// metadata, so the repository must be updated first.
+func (d *Downloader) SetTUF(repo *verifier.TUFRepository) {
+	d.tuf = repo
+}
+
+// UpdateTUF refreshes the repository's metadata from the companion or
+// its mirrors: any new root versions first, then timestamp, snapshot and
+// targets, each verified against the roles trusted before it
+func (d *Downloader) UpdateTUF(ctx context.Context, repo *verifier.TUFRepository) error {
+	var lastErr error
+
+	for _, mirror := range d.mirrorList() {
+		err := d.withRetry(ctx, "TUF metadata", func() error {
+			return d.updateTUFFrom(ctx, mirror, repo)
+		})
+		if err == nil {
+			return nil
+		}
+		if !isFailoverError(err) {
+			return err
+		}
+
+		d.log.Errorf("Mirror %s failed for TUF metadata: %v", mirror, err)
+		lastErr = err
+	}
+
+	return fmt.Errorf("failed to update TUF metadata: %w", lastErr)
+}
+
+// updateTUFFrom runs the TUF client workflow against a single base URL
+func (d *Downloader) updateTUFFrom(ctx context.Context, baseURL string, repo *verifier.TUFRepository) error {
+	// Walk the chain of root rotations
+	for {
+		name := fmt.Sprintf("%d.root.json", repo.RootVersion()+1)
+		data, err := d.fetchTUFMetadata(ctx, baseURL, name)
+		if err != nil {
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+				break
+			}
+			return err
+		}
+		if err := repo.UpdateRoot(data); err != nil {
+			return &VerificationError{Err: err}
+		}
+		d.log.Infof("Rotated TUF root to version %d", repo.RootVersion())
+	}
+	if err := repo.CheckRootFresh(); err != nil {
+		return &VerificationError{Err: err}
+	}
+
+	// Timestamp
+	data, err := d.fetchTUFMetadata(ctx, baseURL, "timestamp.json")
+	if err != nil {
+		return err
+	}
+	if err := repo.UpdateTimestamp(data); err != nil {
+		return &VerificationError{Err: err}
+	}
+
+	// Snapshot
+	name := "snapshot.json"
+	if repo.ConsistentSnapshot() {
+		name = fmt.Sprintf("%d.snapshot.json", repo.SnapshotVersion())
+	}
+	if data, err = d.fetchTUFMetadata(ctx, baseURL, name); err != nil {
+		return err
+	}
+	if err := repo.UpdateSnapshot(data); err != nil {
+		return &VerificationError{Err: err}
+	}
+
+	// Targets
+	name = "targets.json"
+	if repo.ConsistentSnapshot() {
+		name = fmt.Sprintf("%d.targets.json", repo.TargetsVersion())
+	}
+	if data, err = d.fetchTUFMetadata(ctx, baseURL, name); err != nil {
+		return err
+	}
+	if err := repo.UpdateTargets(data); err != nil {
+		return &VerificationError{Err: err}
+	}
+
+	return nil
+}
+
+// fetchTUFMetadata downloads a metadata file from the repository
+func (d *Downloader) fetchTUFMetadata(ctx context.Context, baseURL, name string) ([]byte, error) {
+	url, err := d.fileURL(baseURL, "tuf/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.R().SetContext(ctx).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", name, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode()}
+	}
+	return resp.Body(), nil
+}
+
+// tufTargetURL returns the URL of a component target. With consistent
+// snapshots targets are published under their hash.
+func (d *Downloader) tufTargetURL(baseURL, component string) (string, error) {
+	name := d.componentFilename(component)
+	if d.tuf.ConsistentSnapshot() {
+		if target, err := d.tuf.Target(name); err == nil && target.Hashes["sha256"] != "" {
+			name = target.Hashes["sha256"] + "." + name
+		}
+	}
+	return d.fileURL(baseURL, "tuf/targets/"+name)
+}
+
+// targetVerifier checks a download against trusted TUF target metadata
+type targetVerifier struct {
+	target  verifier.TUFTarget
+	hash    hash.Hash
+	written int64
+}
+
+// NewTargetVerifier returns a verifier that checks a file against the
+// length and sha256 of trusted TUF target metadata
+func NewTargetVerifier(target verifier.TUFTarget) StreamVerifier {
+	return newTargetVerifier(target)
+}
+
+func newTargetVerifier(target verifier.TUFTarget) *targetVerifier {
+	return &targetVerifier{target: target, hash: sha256.New()}
+}
+
+func (t *targetVerifier) Write(p []byte) (int, error) {
+	t.written += int64(len(p))
+	if t.target.Length > 0 && t.written > t.target.Length {
+		return 0, fmt.Errorf("target larger than trusted length %d", t.target.Length)
+	}
+	return t.hash.Write(p)
+}
+
+func (t *targetVerifier) Reset() {
+	t.hash.Reset()
+	t.written = 0
+}
+
+func (t *targetVerifier) ExpectedSHA256() string {
+	return t.target.Hashes["sha256"]
+}
+
+func (t *targetVerifier) Verify() error {
+	if t.written != t.target.Length {
+		return fmt.Errorf("target length %d does not match trusted length %d", t.written, t.target.Length)
+	}
+
+	expected, ok := t.target.Hashes["sha256"]
+	if !ok {
+		return fmt.Errorf("trusted metadata has no sha256 for target")
+	}
+	if actual := hex.EncodeToString(t.hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("target sha256 mismatch: expected %s, got %s", expected, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// StreamVerifier checks a file's contents while they are downloaded.
+// Every downloaded byte is written to it in order; Verify is called once
+// the download is complete and before the file is moved into place.
+type StreamVerifier interface {
+	io.Writer
+	Reset()
+	Verify() error
+}
+
+// VerificationError is returned when a downloaded file fails
+// verification. It is never retried or failed over to another mirror.
+type VerificationError struct {
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification failed: %v", e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// seedVerifier resets the verifier and feeds it the bytes already
+// present in a partial download
+func seedVerifier(partName string, sv StreamVerifier) error {
+	if sv == nil {
+		return nil
+	}
+	sv.Reset()
+
+	file, err := os.Open(partName)
+	if err != nil {
+		return fmt.Errorf("failed to read partial download: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(sv, file); err != nil {
+		return fmt.Errorf("failed to hash partial download: %w", err)
+	}
+	return nil
+}
+
+// finishVerified verifies a completed partial download and moves it into
+// place, discarding it if verification fails
+func finishVerified(partName, name string, sv StreamVerifier) error {
+	if sv != nil {
+		if err := sv.Verify(); err != nil {
+			discardPartial(partName)
+			return &VerificationError{Err: err}
+		}
+	}
+	return finishPartial(partName, name)
+}
+
+// checksumVerifier checks a download against an expected SHA256
+type checksumVerifier struct {
+	expected string
+	hash     hash.Hash
+}
+
+func newChecksumVerifier(expected string) *checksumVerifier {
+	return &checksumVerifier{expected: strings.ToLower(expected), hash: sha256.New()}
+}
+
+func (c *checksumVerifier) Write(p []byte) (int, error) {
+	return c.hash.Write(p)
+}
+
+func (c *checksumVerifier) Reset() {
+	c.hash.Reset()
+}
+
+func (c *checksumVerifier) ExpectedSHA256() string {
+	return c.expected
+}
+
+func (c *checksumVerifier) Verify() error {
+	if actual := hex.EncodeToString(c.hash.Sum(nil)); actual != c.expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", c.expected, actual)
+	}
+	return nil
+}
+
+// multiVerifier feeds a download to several verifiers
+type multiVerifier []StreamVerifier
+
+// joinVerifiers combines verifiers, skipping nil ones
+func joinVerifiers(verifiers ...StreamVerifier) StreamVerifier {
+	var joined multiVerifier
+	for _, sv := range verifiers {
+		if sv != nil {
+			joined = append(joined, sv)
+		}
+	}
+
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	default:
+		return joined
+	}
+}
+
+func (m multiVerifier) Write(p []byte) (int, error) {
+	for _, sv := range m {
+		if _, err := sv.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (m multiVerifier) Reset() {
+	for _, sv := range m {
+		sv.Reset()
+	}
+}
+
+// ExpectedSHA256 returns the first SHA256 one of the verifiers expects
+func (m multiVerifier) ExpectedSHA256() string {
+	for _, sv := range m {
+		if digest := expectedSHA256(sv); digest != "" {
+			return digest
+		}
+	}
+	return ""
+}
+
+func (m multiVerifier) Verify() error {
+	for _, sv := range m {
+		if err := sv.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
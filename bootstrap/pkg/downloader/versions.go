@@ -0,0 +1,242 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ReleaseIndexPath is where a companion lists its published versions
+const ReleaseIndexPath = "releases/index.json"
+
+// channelRank orders the built-in channels from most to least stable. A
+// channel also offers the releases of every more stable channel.
+var channelRank = map[string]int{
+	"stable":  0,
+	"beta":    1,
+	"nightly": 2,
+}
+
+// errNoIndex means no base URL publishes a release index
+var errNoIndex = errors.New("no release index published")
+
+// ReleaseIndex lists the published versions of each component. Each
+// version is stored under releases/<version>/.
+type ReleaseIndex struct {
+	Components map[string][]IndexEntry `json:"components"`
+}
+
+// IndexEntry is one published version of a component
+type IndexEntry struct {
+	ComponentManifest
+	Channel string `json:"channel,omitempty"`
+}
+
+// SetVersions selects the release channel and the per-component version
+// constraints, such as "1.4.2" or ">=2.0 <3.0", used to pick versions
+func (d *Downloader) SetVersions(channel string, constraints map[string]string) {
+	d.versionsMu.Lock()
+	defer d.versionsMu.Unlock()
+
+	d.channel = channel
+	d.constraints = constraints
+	d.versionsResolved = false
+}
+
+// resolveVersions picks the version of every component once. With a
+// release index the newest version on the channel that satisfies the
+// component's constraint is used. Without one components come from
+// releases/latest unless they are pinned to an exact version.
+func (d *Downloader) resolveVersions(ctx context.Context) error {
+	d.versionsMu.Lock()
+	defer d.versionsMu.Unlock()
+
+	if d.versionsResolved {
+		return nil
+	}
+
+	index, err := d.fetchReleaseIndex(ctx)
+	if err != nil && !errors.Is(err, errNoIndex) {
+		return err
+	}
+
+	releases := map[string]ComponentManifest{}
+	if index != nil {
+		for component, entries := range index.Components {
+			entry, err := d.selectVersion(component, entries)
+			if err != nil {
+				return err
+			}
+			releases[component] = entry
+		}
+		for component := range d.constraints {
+			if _, ok := releases[component]; !ok {
+				return fmt.Errorf("release index has no versions of %s", component)
+			}
+		}
+	} else {
+		for component, value := range d.constraints {
+			constraint, err := parseConstraint(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", component, err)
+			}
+			if _, ok := constraint.exact(); !ok {
+				return fmt.Errorf("version constraint %q for %s needs a release index", value, component)
+			}
+			releases[component] = ComponentManifest{Version: strings.TrimPrefix(strings.TrimSpace(value), "=")}
+		}
+	}
+
+	d.releases = releases
+	d.indexed = index != nil
+	d.versionsResolved = true
+
+	for component, release := range releases {
+		d.log.Infof("Using %s %s", component, release.Version)
+	}
+	return nil
+}
+
+// selectVersion picks the newest acceptable version of a component.
+// Exact pins ignore the channel and rollouts; every other choice stays
+// on the channel and takes only releases rolled out to the device.
+func (d *Downloader) selectVersion(component string, entries []IndexEntry) (ComponentManifest, error) {
+	var constraint versionConstraint
+	if value, ok := d.constraints[component]; ok {
+		var err error
+		if constraint, err = parseConstraint(value); err != nil {
+			return ComponentManifest{}, fmt.Errorf("%s: %w", component, err)
+		}
+	}
+	_, pinned := constraint.exact()
+
+	var (
+		best    *IndexEntry
+		bestVer semver
+	)
+	for i := range entries {
+		entry := &entries[i]
+		version, err := parseSemver(entry.Version)
+		if err != nil {
+			d.log.Errorf("Ignoring %s release with invalid version %q", component, entry.Version)
+			continue
+		}
+		if !pinned && !d.onChannel(entry.Channel) {
+			continue
+		}
+		if constraint != nil && !constraint.matches(version) {
+			continue
+		}
+		if !pinned && !d.offered(component, entry.ComponentManifest) {
+			d.log.Infof("%s %s is not rolled out to this device yet", component, entry.Version)
+			continue
+		}
+		if best == nil || version.compare(bestVer) > 0 {
+			best, bestVer = entry, version
+		}
+	}
+
+	if best == nil {
+		if constraint != nil {
+			return ComponentManifest{}, fmt.Errorf("no %s release matches %q on channel %s", component, d.constraints[component], d.channelName())
+		}
+		return ComponentManifest{}, fmt.Errorf("no %s release on channel %s", component, d.channelName())
+	}
+	return best.ComponentManifest, nil
+}
+
+// onChannel reports whether a release published on a channel is offered
+// to the selected channel
+func (d *Downloader) onChannel(channel string) bool {
+	if channel == "" {
+		channel = "stable"
+	}
+	selected := d.channelName()
+
+	rank, known := channelRank[channel]
+	selectedRank, selectedKnown := channelRank[selected]
+	if known && selectedKnown {
+		return rank <= selectedRank
+	}
+	return channel == selected
+}
+
+func (d *Downloader) channelName() string {
+	if d.channel == "" {
+		return "stable"
+	}
+	return d.channel
+}
+
+// releaseDir returns the directory under releases/ holding a component
+func (d *Downloader) releaseDir(component string) string {
+	d.versionsMu.Lock()
+	defer d.versionsMu.Unlock()
+
+	if release, ok := d.releases[component]; ok && release.Version != "" {
+		return release.Version
+	}
+	return "latest"
+}
+
+// pinnedVersion returns the version resolved for a component, if any
+func (d *Downloader) pinnedVersion(component string) string {
+	if dir := d.releaseDir(component); dir != "latest" {
+		return dir
+	}
+	return ""
+}
+
+// componentPath returns the path of a component in the release tree
+func (d *Downloader) componentPath(component string) string {
+	return fmt.Sprintf("releases/%s/%s", d.releaseDir(component), d.componentFilename(component))
+}
+
+// fetchReleaseIndex fetches the release index, trying every mirror
+func (d *Downloader) fetchReleaseIndex(ctx context.Context) (*ReleaseIndex, error) {
+	lastErr := errNoIndex
+
+	for _, mirror := range d.mirrorList() {
+		var index *ReleaseIndex
+		err := d.withRetry(ctx, "release index", func() error {
+			url, err := d.fileURL(mirror, ReleaseIndexPath)
+			if err != nil {
+				return err
+			}
+
+			resp, err := d.client.R().SetContext(ctx).Get(url)
+			if err != nil {
+				return fmt.Errorf("failed to fetch release index: %w", err)
+			}
+			if resp.StatusCode() != http.StatusOK {
+				return &StatusError{StatusCode: resp.StatusCode()}
+			}
+
+			index = &ReleaseIndex{}
+			if err := json.Unmarshal(resp.Body(), index); err != nil {
+				return fmt.Errorf("failed to parse release index: %w", err)
+			}
+			return nil
+		})
+		if err == nil {
+			return index, nil
+		}
+
+		// Older companions do not publish an index
+		var statusErr *StatusError
+		if errors.Is(err, errNotServed) || (errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound) {
+			continue
+		}
+		if !isFailoverError(err) {
+			return nil, err
+		}
+
+		d.log.Errorf("Mirror %s failed for release index: %v", mirror, err)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
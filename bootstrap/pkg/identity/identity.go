@@ -0,0 +1,299 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Default TPM locations of the device identity. The attestation key is
+// persisted in the owner range of persistent handles and the device ID in
+// an owner defined NV index.
+const (
+	DefaultAKHandle = 0x81010E2A
+	DefaultIDIndex  = 0x01800E2A
+)
+
+// maxDeviceID bounds the NV index holding the device ID
+const maxDeviceID = 128
+
+// DefaultPCRs are the SHA256 PCRs quoted at enrollment: firmware, boot
+// loader and secure boot state
+var DefaultPCRs = []uint{0, 1, 2, 3, 4, 5, 6, 7}
+
+// ErrNoTPM is returned by Open when the device has no usable TPM 2.0
+var ErrNoTPM = errors.New("no TPM 2.0 found")
+
+// Options locates the device identity in the TPM. Zero values select the
+// defaults.
+type Options struct {
+	// Device is the TPM device, e.g. /dev/tpmrm0; ignored on Windows
+	Device   string
+	AKHandle uint32
+	IDIndex  uint32
+}
+
+// TPM holds the device identity: a device ID written once into a locked
+// NV index and an attestation key that never leaves the TPM. A copied
+// configuration file carries neither, so it cannot enroll as the device.
+type TPM struct {
+	tpm      transport.TPMCloser
+	akHandle tpm2.TPMHandle
+	idIndex  tpm2.TPMHandle
+}
+
+// Quote is a TPM2_Quote of the enrollment PCRs signed by the attestation
+// key. Quoted is a marshalled TPMS_ATTEST and Signature a marshalled
+// TPMT_SIGNATURE, as the companion verifies them.
+type Quote struct {
+	Nonce     []byte `json:"nonce"`
+	PCRs      []uint `json:"pcrs"`
+	Quoted    []byte `json:"quoted"`
+	Signature []byte `json:"signature"`
+}
+
+// Open opens the TPM of this device
+func Open(opts Options) (*TPM, error) {
+	t, err := openTPM(opts.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	tpm := &TPM{
+		tpm:      t,
+		akHandle: tpm2.TPMHandle(opts.AKHandle),
+		idIndex:  tpm2.TPMHandle(opts.IDIndex),
+	}
+	if opts.AKHandle == 0 {
+		tpm.akHandle = DefaultAKHandle
+	}
+	if opts.IDIndex == 0 {
+		tpm.idIndex = DefaultIDIndex
+	}
+	return tpm, nil
+}
+
+// Close closes the TPM
+func (t *TPM) Close() error {
+	return t.tpm.Close()
+}
+
+// DeviceID returns the device ID kept in the TPM. On first use it stores
+// newID and locks the index so that the ID can never be rewritten.
+func (t *TPM) DeviceID(newID string) (string, error) {
+	index, err := tpm2.NVReadPublic{NVIndex: t.idIndex}.Execute(t.tpm)
+	if errors.Is(err, tpm2.TPMRCHandle) {
+		return newID, t.storeDeviceID(newID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read device ID index: %w", err)
+	}
+
+	public, err := index.NVPublic.Contents()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse device ID index: %w", err)
+	}
+	if !public.Attributes.Written {
+		// Defined by an earlier run that failed before writing
+		return newID, t.writeDeviceID(public, newID)
+	}
+
+	rsp, err := tpm2.NVRead{
+		AuthHandle: tpm2.AuthHandle{
+			Handle: t.idIndex,
+			Name:   index.NVName,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		NVIndex: tpm2.NamedHandle{Handle: t.idIndex, Name: index.NVName},
+		Size:    public.DataSize,
+	}.Execute(t.tpm)
+	if err != nil {
+		return "", fmt.Errorf("failed to read device ID: %w", err)
+	}
+
+	// The index is sized for the longest ID; shorter ones are zero padded
+	id := rsp.Data.Buffer
+	for len(id) > 0 && id[len(id)-1] == 0 {
+		id = id[:len(id)-1]
+	}
+	if len(id) == 0 {
+		return "", fmt.Errorf("device ID index is empty")
+	}
+	return string(id), nil
+}
+
+// storeDeviceID defines the device ID index and writes the ID into it
+func (t *TPM) storeDeviceID(id string) error {
+	if id == "" || len(id) > maxDeviceID {
+		return fmt.Errorf("invalid device ID %q", id)
+	}
+
+	define := tpm2.NVDefineSpace{
+		AuthHandle: tpm2.TPMRHOwner,
+		PublicInfo: tpm2.New2B(tpm2.TPMSNVPublic{
+			NVIndex: t.idIndex,
+			NameAlg: tpm2.TPMAlgSHA256,
+			Attributes: tpm2.TPMANV{
+				OwnerWrite:  true,
+				OwnerRead:   true,
+				AuthRead:    true,
+				NoDA:        true,
+				WriteDefine: true,
+				NT:          tpm2.TPMNTOrdinary,
+			},
+			DataSize: maxDeviceID,
+		}),
+	}
+	if _, err := define.Execute(t.tpm); err != nil {
+		return fmt.Errorf("failed to define device ID index: %w", err)
+	}
+
+	public, err := define.PublicInfo.Contents()
+	if err != nil {
+		return err
+	}
+	return t.writeDeviceID(public, id)
+}
+
+// writeDeviceID writes the ID into its index and locks it for good
+func (t *TPM) writeDeviceID(public *tpm2.TPMSNVPublic, id string) error {
+	if id == "" || len(id) > int(public.DataSize) {
+		return fmt.Errorf("invalid device ID %q", id)
+	}
+	name, err := tpm2.NVName(public)
+	if err != nil {
+		return err
+	}
+	owner := tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(nil)}
+
+	data := make([]byte, public.DataSize)
+	copy(data, id)
+	write := tpm2.NVWrite{
+		AuthHandle: owner,
+		NVIndex:    tpm2.NamedHandle{Handle: public.NVIndex, Name: *name},
+		Data:       tpm2.TPM2BMaxNVBuffer{Buffer: data},
+	}
+	if _, err := write.Execute(t.tpm); err != nil {
+		return fmt.Errorf("failed to write device ID: %w", err)
+	}
+
+	// Writing sets TPMA_NV_WRITTEN, which changes the name of the index
+	public.Attributes.Written = true
+	if name, err = tpm2.NVName(public); err != nil {
+		return err
+	}
+	lock := tpm2.NVWriteLock{
+		AuthHandle: owner,
+		NVIndex:    tpm2.NamedHandle{Handle: public.NVIndex, Name: *name},
+	}
+	if _, err := lock.Execute(t.tpm); err != nil {
+		return fmt.Errorf("failed to lock device ID: %w", err)
+	}
+	return nil
+}
+
+// AttestationKey returns the marshalled TPMT_PUBLIC of the attestation
+// key, creating and persisting the key on first use
+func (t *TPM) AttestationKey() ([]byte, error) {
+	rsp, err := tpm2.ReadPublic{ObjectHandle: t.akHandle}.Execute(t.tpm)
+	if err == nil {
+		return rsp.OutPublic.Bytes(), nil
+	}
+	if !errors.Is(err, tpm2.TPMRCHandle) {
+		return nil, fmt.Errorf("failed to read attestation key: %w", err)
+	}
+
+	// The key is a primary of the endorsement hierarchy, so it is bound
+	// to this TPM's endorsement seed
+	created, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHEndorsement,
+		InPublic:      tpm2.New2B(akTemplate),
+	}.Execute(t.tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attestation key: %w", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: created.ObjectHandle}.Execute(t.tpm)
+
+	evict := tpm2.EvictControl{
+		Auth: tpm2.TPMRHOwner,
+		ObjectHandle: tpm2.NamedHandle{
+			Handle: created.ObjectHandle,
+			Name:   created.Name,
+		},
+		PersistentHandle: t.akHandle,
+	}
+	if _, err := evict.Execute(t.tpm); err != nil {
+		return nil, fmt.Errorf("failed to persist attestation key: %w", err)
+	}
+	return created.OutPublic.Bytes(), nil
+}
+
+// Quote signs the given SHA256 PCRs and a nonce from the companion with
+// the attestation key
+func (t *TPM) Quote(nonce []byte, pcrs []uint) (*Quote, error) {
+	if len(pcrs) == 0 {
+		pcrs = DefaultPCRs
+	}
+
+	key, err := tpm2.ReadPublic{ObjectHandle: t.akHandle}.Execute(t.tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation key: %w", err)
+	}
+
+	rsp, err := tpm2.Quote{
+		SignHandle: tpm2.AuthHandle{
+			Handle: t.akHandle,
+			Name:   key.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		QualifyingData: tpm2.TPM2BData{Buffer: nonce},
+		InScheme:       tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+		PCRSelect: tpm2.TPMLPCRSelection{
+			PCRSelections: []tpm2.TPMSPCRSelection{{
+				Hash:      tpm2.TPMAlgSHA256,
+				PCRSelect: tpm2.PCClientCompatible.PCRs(pcrs...),
+			}},
+		},
+	}.Execute(t.tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote PCRs: %w", err)
+	}
+
+	return &Quote{
+		Nonce:     nonce,
+		PCRs:      pcrs,
+		Quoted:    rsp.Quoted.Bytes(),
+		Signature: tpm2.Marshal(rsp.Signature),
+	}, nil
+}
+
+// akTemplate is a restricted ECDSA P-256 signing key, the usual template
+// of an attestation key
+var akTemplate = tpm2.TPMTPublic{
+	Type:    tpm2.TPMAlgECC,
+	NameAlg: tpm2.TPMAlgSHA256,
+	ObjectAttributes: tpm2.TPMAObject{
+		FixedTPM:            true,
+		FixedParent:         true,
+		SensitiveDataOrigin: true,
+		UserWithAuth:        true,
+		NoDA:                true,
+		Restricted:          true,
+		SignEncrypt:         true,
+	},
+	Parameters: tpm2.NewTPMUPublicParms(
+		tpm2.TPMAlgECC,
+		&tpm2.TPMSECCParms{
+			Scheme: tpm2.TPMTECCScheme{
+				Scheme: tpm2.TPMAlgECDSA,
+				Details: tpm2.NewTPMUAsymScheme(
+					tpm2.TPMAlgECDSA,
+					&tpm2.TPMSSigSchemeECDSA{HashAlg: tpm2.TPMAlgSHA256},
+				),
+			},
+			CurveID: tpm2.TPMECCNistP256,
+		},
+	),
+}
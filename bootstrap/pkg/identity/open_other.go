@@ -0,0 +1,30 @@
+//go:build !windows
+
+package identity
+
+import (
+	"os"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/linuxtpm"
+)
+
+// tpmDevices are tried in order when no device is configured; the
+// resource manager lets other TPM users share the device
+var tpmDevices = []string{"/dev/tpmrm0", "/dev/tpm0"}
+
+// openTPM opens a TPM character device
+func openTPM(device string) (transport.TPMCloser, error) {
+	devices := tpmDevices
+	if device != "" {
+		devices = []string{device}
+	}
+
+	for _, path := range devices {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		return linuxtpm.Open(path)
+	}
+	return nil, ErrNoTPM
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package identity
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/windowstpm"
+)
+
+// openTPM opens the TPM through TBS; the device is chosen by Windows
+func openTPM(device string) (transport.TPMCloser, error) {
+	t, err := windowstpm.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoTPM, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,107 @@
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets removed from text
+const Redacted = "REDACTED"
+
+// minSecretLength is the length below which registered secrets are not
+// replaced, so that short values do not scrub unrelated text
+const minSecretLength = 6
+
+// secretNames matches the names of settings, parameters and fields that
+// hold secrets
+const secretNames = `[\w.-]*(?:token|secret|password|passwd|signature|sig|credential|api[_-]?key|private[_-]?key|auth)`
+
+// patterns find secrets by their shape or the name they are given, each
+// with what to replace the match with
+var patterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	// PEM private keys, possibly with escaped newlines in JSON
+	{regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`), "[" + Redacted + " PRIVATE KEY]"},
+	// Passwords in URLs
+	{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:)[^/\s@]+@`), "${1}" + Redacted + "@"},
+	// Query parameters of signed and authenticated URLs, e.g. sig= of
+	// Azure SAS URLs or X-Amz-Signature= of S3 presigned URLs
+	{regexp.MustCompile(`(?i)([?&]` + secretNames + `=)[^&\s"'#]+`), "${1}" + Redacted},
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`), "${1} " + Redacted},
+	// name=value and "name": "value" pairs
+	{regexp.MustCompile(`(?i)\b(` + secretNames + `)(\\?["']?\s*[:=]\s*\\?["']?)([^\s"'\\&,;}]+)`), "${1}${2}" + Redacted},
+	// Well-known token formats: GitHub tokens, JWTs, AWS access keys
+	{regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), Redacted},
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`), Redacted},
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), Redacted},
+}
+
+// secrets are the registered secret values, longest first so that a
+// secret containing another is replaced whole
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecret registers values to be replaced wherever they appear, also
+// URL-escaped, e.g. the tokens of the configuration. Values shorter than
+// six characters are ignored.
+func AddSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, value := range values {
+		for _, form := range []string{value, url.QueryEscape(value)} {
+			if len(form) < minSecretLength || contains(secrets, form) {
+				continue
+			}
+			secrets = append(secrets, form)
+		}
+	}
+	sort.Slice(secrets, func(a, b int) bool {
+		return len(secrets[a]) > len(secrets[b])
+	})
+}
+
+// String replaces the registered secrets in s, then whatever looks like
+// a secret: private keys, passwords and signatures in URLs, bearer
+// tokens, values named like secrets and well-known token formats
+func String(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	secretsMu.RUnlock()
+
+	for _, pattern := range patterns {
+		s = pattern.re.ReplaceAllString(s, pattern.replacement)
+	}
+	return s
+}
+
+// Strings redacts every string of a slice in place
+func Strings(values []string) {
+	for n, value := range values {
+		values[n] = String(value)
+	}
+}
+
+// Bytes is String for text held in a byte slice
+func Bytes(data []byte) []byte {
+	return []byte(String(string(data)))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
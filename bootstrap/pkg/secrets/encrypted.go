@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ezra/bootstrap/pkg/detector"
+)
+
+// encryptedKeyInfo separates the key of the store from other keys
+// derived from the machine identifier
+const encryptedKeyInfo = "ezra-bootstrap secret store"
+
+// installKeyFile is the file in the store holding its random key. Secret
+// names cannot start with a dot, so it is never taken for a secret.
+const installKeyFile = ".install.key"
+
+// installKeySize is the size of the random key of a store
+const installKeySize = 32
+
+// encryptedStore keeps each secret in a file encrypted with AES-GCM under
+// a key derived from a random key kept in the store, readable by its
+// owner only, and the machine identifier. The machine identifier alone is
+// no secret; it binds the files to the machine. The name of the secret is
+// authenticated with it, so that files cannot be swapped.
+type encryptedStore struct {
+	files *fileStore
+	aead  cipher.AEAD
+}
+
+// openEncrypted opens the store bound to this machine
+func openEncrypted(dir string) (Store, error) {
+	machine, err := detector.MachineID()
+	if err != nil {
+		return nil, fmt.Errorf("cannot bind the secret store to the machine: %w", err)
+	}
+	return newEncryptedStore(dir, machine)
+}
+
+// newEncryptedStore derives the key of the store from its random key and
+// the machine identifier, creating the random key the first time
+func newEncryptedStore(dir, machine string) (*encryptedStore, error) {
+	installKey, err := readInstallKey(filepath.Join(dir, installKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Key(sha256.New, installKey, []byte(machine), encryptedKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{files: &fileStore{dir: dir, suffix: ".enc"}, aead: aead}, nil
+}
+
+// readInstallKey reads the random key of a store, or creates it. It is
+// created exclusively, so that two runs opening a new store at once
+// cannot each write their own.
+func readInstallKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = make([]byte, installKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		err = createInstallKey(path, key)
+		if errors.Is(err, fs.ErrExist) {
+			return readInstallKey(path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key of the secret store: %w", err)
+	}
+	if len(key) != installKeySize {
+		return nil, fmt.Errorf("the key of the secret store %s is corrupt", path)
+	}
+	return key, nil
+}
+
+// createInstallKey writes a new key of a store, readable by its owner
+// only
+func createInstallKey(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(key)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func (s *encryptedStore) Get(name string) ([]byte, error) {
+	data, err := s.files.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	size := s.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("secret %s is corrupt", name)
+	}
+	value, err := s.aead.Open(nil, data[:size], data[size:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s cannot be decrypted: it was stored on another machine or with another store key, or is corrupt", name)
+	}
+	return value, nil
+}
+
+func (s *encryptedStore) Set(name string, value []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	return s.files.Set(name, s.aead.Seal(nonce, nonce, value, []byte(name)))
+}
+
+func (s *encryptedStore) Delete(name string) error {
+	return s.files.Delete(name)
+}
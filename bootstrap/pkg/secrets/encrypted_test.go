@@ -0,0 +1,186 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testMachine = "fed6b2924c424cf1b9a322f606b4de6d"
+
+func newTestEncrypted(t *testing.T, dir, machine string) *encryptedStore {
+	t.Helper()
+	store, err := newEncryptedStore(dir, machine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestEncrypted(t, dir, testMachine)
+	if err := store.Set("enrollment-token", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "enrollment-token.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("secret stored in the clear")
+	}
+
+	// A store opened later on the same machine derives the same key
+	got, err := newTestEncrypted(t, dir, testMachine).Get("enrollment-token")
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("Get = %q, %v, want %q", got, err, "secret")
+	}
+
+	if err := store.Delete("enrollment-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("enrollment-token"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEncryptedStoreRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// change alters the store after the secret is set, returning the
+		// machine it is opened on again
+		change  func(t *testing.T, dir string) string
+		wantErr string
+	}{
+		{"other machine", func(t *testing.T, dir string) string {
+			return "0123456789abcdef0123456789abcdef"
+		}, "cannot be decrypted"},
+		{"other store key", func(t *testing.T, dir string) string {
+			other := filepath.Join(t.TempDir(), installKeyFile)
+			if _, err := readInstallKey(other); err != nil {
+				t.Fatal(err)
+			}
+			key, err := os.ReadFile(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, installKeyFile), key, 0600); err != nil {
+				t.Fatal(err)
+			}
+			return testMachine
+		}, "cannot be decrypted"},
+		{"file of another secret", func(t *testing.T, dir string) string {
+			if err := os.Rename(filepath.Join(dir, "other.enc"), filepath.Join(dir, "token.enc")); err != nil {
+				t.Fatal(err)
+			}
+			return testMachine
+		}, "cannot be decrypted"},
+		{"tampered", func(t *testing.T, dir string) string {
+			path := filepath.Join(dir, "token.enc")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 1
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			return testMachine
+		}, "cannot be decrypted"},
+		{"truncated", func(t *testing.T, dir string) string {
+			if err := os.WriteFile(filepath.Join(dir, "token.enc"), []byte("short"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			return testMachine
+		}, "secret token is corrupt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := newTestEncrypted(t, dir, testMachine)
+			if err := store.Set("token", []byte("secret")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Set("other", []byte("other secret")); err != nil {
+				t.Fatal(err)
+			}
+
+			machine := test.change(t, dir)
+			got, err := newTestEncrypted(t, dir, machine).Get("token")
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Get = %q, %v, want an error containing %q", got, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptedStoreNames(t *testing.T) {
+	store := newTestEncrypted(t, t.TempDir(), testMachine)
+	for _, name := range []string{"", installKeyFile, ".hidden", "../token", "a/b"} {
+		if err := store.Set(name, []byte("secret")); err == nil {
+			t.Errorf("Set accepted the name %q", name)
+		}
+		if _, err := store.Get(name); err == nil {
+			t.Errorf("Get accepted the name %q", name)
+		}
+	}
+}
+
+func TestReadInstallKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", installKeyFile)
+	key, err := readInstallKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != installKeySize {
+		t.Fatalf("key of %d bytes, want %d", len(key), installKeySize)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("key file mode %v, want 0600", mode)
+		}
+	}
+
+	again, err := readInstallKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, key) {
+		t.Error("readInstallKey replaced an existing key")
+	}
+}
+
+func TestReadInstallKeyCorrupt(t *testing.T) {
+	tests := map[string][]byte{
+		"empty": {},
+		"short": bytes.Repeat([]byte{1}, installKeySize-1),
+		"long":  bytes.Repeat([]byte{1}, installKeySize+1),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), installKeyFile)
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readInstallKey(path); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+				t.Fatalf("readInstallKey = %v, want an error for a corrupt key", err)
+			}
+			// A corrupt key is never replaced, which would lose every secret
+			if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("key file = %x, %v, want it left as it was", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux || darwin
+
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// keyringTimeout bounds a keyring tool, which may wait for a keyring to
+// be unlocked
+const keyringTimeout = 30 * time.Second
+
+// keyringStore keeps secrets in the keyring of the system through its
+// command line tool. Values are stored base64-encoded, as the tools
+// handle text.
+type keyringStore struct {
+	// keychain is the macOS keychain secrets are kept in, empty for the
+	// default one
+	keychain string
+}
+
+func (s *keyringStore) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	encoded, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s in the keyring is corrupt: %w", name, err)
+	}
+	return value, nil
+}
+
+func (s *keyringStore) Set(name string, value []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	return s.store(name, base64.StdEncoding.EncodeToString(value))
+}
+
+func (s *keyringStore) Delete(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	return s.clear(name)
+}
+
+// runKeyring runs a keyring tool with input on its standard input and
+// returns its output. A tool that exits with notFound finds no secret.
+func runKeyring(input string, notFound int, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == notFound && len(out) == 0 {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %s", name, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// systemKeychain keeps the secrets of the bootstrap when it runs as root,
+// which launchd daemons can read without a login session
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// securityNotFound is the exit code of security for an item that is not
+// in the keychain
+const securityNotFound = 44
+
+// openKeyring uses the Keychain through the security tool: the System
+// keychain as root, and the user's login keychain otherwise
+func openKeyring(dir string) (Store, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, fmt.Errorf("the keyring backend needs the security tool: %w", err)
+	}
+	s := &keyringStore{}
+	if os.Geteuid() == 0 {
+		s.keychain = systemKeychain
+	}
+	return s, nil
+}
+
+// args appends the keychain to the arguments of a security command
+func (s *keyringStore) args(args ...string) []string {
+	if s.keychain != "" {
+		args = append(args, s.keychain)
+	}
+	return args
+}
+
+func (s *keyringStore) lookup(name string) (string, error) {
+	return runKeyring("", securityNotFound, "security", s.args("find-generic-password", "-s", service, "-a", name, "-w")...)
+}
+
+// store passes the secret as an argument, as security reads it from
+// nowhere else without prompting, so it shows briefly in the process
+// list of the machine
+func (s *keyringStore) store(name, value string) error {
+	_, err := runKeyring("", -1, "security", s.args("add-generic-password", "-U", "-s", service, "-a", name, "-l", "Ezra "+name, "-w", value)...)
+	return err
+}
+
+func (s *keyringStore) clear(name string) error {
+	_, err := runKeyring("", securityNotFound, "security", s.args("delete-generic-password", "-s", service, "-a", name)...)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
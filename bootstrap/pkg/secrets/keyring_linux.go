@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// openKeyring uses the Secret Service, e.g. GNOME Keyring or KWallet,
+// through secret-tool from libsecret. It needs a D-Bus session with an
+// unlocked keyring.
+func openKeyring(dir string) (Store, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, fmt.Errorf("the keyring backend needs secret-tool from libsecret: %w", err)
+	}
+	return &keyringStore{}, nil
+}
+
+func (s *keyringStore) lookup(name string) (string, error) {
+	return runKeyring("", 1, "secret-tool", "lookup", "service", service, "name", name)
+}
+
+// store passes the secret on standard input, so that it does not show in
+// the process list
+func (s *keyringStore) store(name, value string) error {
+	_, err := runKeyring(value, -1, "secret-tool", "store", "--label", "Ezra "+name, "service", service, "name", name)
+	return err
+}
+
+func (s *keyringStore) clear(name string) error {
+	_, err := runKeyring("", 1, "secret-tool", "clear", "service", service, "name", name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package secrets
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// openKeyring fails: there is no keyring the bootstrap can use on this
+// platform
+func openKeyring(dir string) (Store, error) {
+	return nil, fmt.Errorf("the keyring backend is not supported on %s: use the encrypted backend", runtime.GOOS)
+}
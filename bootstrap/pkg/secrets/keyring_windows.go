@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiStore keeps each secret in a file encrypted with DPAPI under the
+// key of the machine, so that the services can read it whatever account
+// they run as. The files are only readable by their owner, and a copy is
+// of no use on another machine.
+type dpapiStore struct {
+	files *fileStore
+}
+
+// openKeyring uses DPAPI
+func openKeyring(dir string) (Store, error) {
+	return &dpapiStore{files: &fileStore{dir: dir, suffix: ".dpapi"}}, nil
+}
+
+func (s *dpapiStore) Get(name string) ([]byte, error) {
+	data, err := s.files.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := dpapi(data, []byte(name), false)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s cannot be decrypted: %w", name, err)
+	}
+	return value, nil
+}
+
+func (s *dpapiStore) Set(name string, value []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	data, err := dpapi(value, []byte(name), true)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret %s: %w", name, err)
+	}
+	return s.files.Set(name, data)
+}
+
+func (s *dpapiStore) Delete(name string) error {
+	return s.files.Delete(name)
+}
+
+// dpapi encrypts or decrypts data with the key of the machine. The name
+// of the secret is the entropy, so that files cannot be swapped.
+func dpapi(data, entropy []byte, encrypt bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	salt := windows.DataBlob{Size: uint32(len(entropy)), Data: &entropy[0]}
+	var out windows.DataBlob
+	flags := uint32(windows.CRYPTPROTECT_UI_FORBIDDEN | windows.CRYPTPROTECT_LOCAL_MACHINE)
+
+	var err error
+	if encrypt {
+		err = windows.CryptProtectData(&in, nil, &salt, 0, nil, flags, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, &salt, 0, nil, flags, &out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Backends of a secret store
+const (
+	// BackendFile keeps each secret in a plain file only its owner can
+	// read
+	BackendFile = "file"
+	// BackendKeyring keeps secrets in the keyring of the system: the
+	// Secret Service on Linux, the Keychain on macOS, and files
+	// encrypted with DPAPI on Windows
+	BackendKeyring = "keyring"
+	// BackendEncrypted keeps each secret in a file encrypted with a key
+	// derived from a random key kept with the files and the machine
+	// identifier, so that a copy of the files is of no use on another
+	// machine
+	BackendEncrypted = "encrypted"
+)
+
+// Backends are the names of the backends, for validation
+var Backends = []string{BackendFile, BackendKeyring, BackendEncrypted}
+
+// service names the secrets of the bootstrap in keyrings
+const service = "ezra-bootstrap"
+
+// ErrNotFound is returned by Get for a secret that is not stored
+var ErrNotFound = errors.New("secret not found")
+
+// namePattern restricts names, which become file names and keyring
+// attributes
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Store keeps secrets by name
+type Store interface {
+	// Get returns a secret, or ErrNotFound
+	Get(name string) ([]byte, error)
+	// Set stores a secret, replacing one of the same name
+	Set(name string, value []byte) error
+	// Delete removes a secret. Removing one that is not stored is not
+	// an error.
+	Delete(name string) error
+}
+
+// Open returns the store of a backend. dir is where the file backends
+// keep their files. An empty backend is the file backend.
+func Open(backend, dir string) (Store, error) {
+	switch backend {
+	case "", BackendFile:
+		return &fileStore{dir: dir}, nil
+	case BackendKeyring:
+		return openKeyring(dir)
+	case BackendEncrypted:
+		return openEncrypted(dir)
+	default:
+		return nil, fmt.Errorf("unknown secret store backend %q", backend)
+	}
+}
+
+// checkName refuses names that cannot be stored
+func checkName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q: use letters, digits, dots, dashes and underscores", name)
+	}
+	return nil
+}
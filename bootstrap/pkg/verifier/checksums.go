@@ -0,0 +1,211 @@
+package verifier
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+// Checksum algorithms
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+	ChecksumBLAKE3 = "blake3"
+)
+
+// Large files are read ahead on a separate goroutine in blocks of
+// hashBlockSize, so that disk reads overlap hashing
+const (
+	parallelHashThreshold = 64 << 20
+	hashBlockSize         = 4 << 20
+)
+
+// checksumFiles are the checksum files looked for next to a release, in
+// order, with the algorithm their name implies. Names starting with "."
+// are suffixes of the release file name; the others are files listing
+// several releases.
+var checksumFiles = []struct {
+	name      string
+	algorithm string
+}{
+	{".sha256", ChecksumSHA256},
+	{".sha512", ChecksumSHA512},
+	{".b3", ChecksumBLAKE3},
+	{".blake3", ChecksumBLAKE3},
+	{"SHA256SUMS", ChecksumSHA256},
+	{"SHA512SUMS", ChecksumSHA512},
+	{"B3SUMS", ChecksumBLAKE3},
+	{"BLAKE3SUMS", ChecksumBLAKE3},
+}
+
+// checksumEntry is a line of a checksums file
+type checksumEntry struct {
+	digest string
+	name   string
+}
+
+// parseChecksums parses the output of sha256sum, sha512sum, b3sum or
+// shasum -a 512: one "<hex digest> <name>" per line, where the name is
+// preceded by a space or by "*" for binary mode. A file holding only a
+// digest yields a single entry without a name.
+func parseChecksums(data string) ([]checksumEntry, error) {
+	var entries []checksumEntry
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// GNU coreutils escapes names holding a backslash or newline and
+		// marks the line with a leading backslash
+		escaped := strings.HasPrefix(text, "\\")
+		text = strings.TrimPrefix(text, "\\")
+
+		digest, name, _ := strings.Cut(text, " ")
+		if _, err := hex.DecodeString(digest); err != nil || checksumAlgorithm(digest) == "" {
+			return nil, fmt.Errorf("line %d: invalid checksum %q", line, digest)
+		}
+
+		name = strings.TrimLeft(name, " \t")
+		name = strings.TrimPrefix(name, "*")
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		entries = append(entries, checksumEntry{digest: strings.ToLower(digest), name: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no checksums found")
+	}
+	return entries, nil
+}
+
+// findChecksum returns the digest listed for a file. Names are compared
+// by their base name, since checksum files are often generated in
+// another directory. A single entry without a name matches any file.
+func findChecksum(data, filename string) (string, error) {
+	entries, err := parseChecksums(data)
+	if err != nil {
+		return "", err
+	}
+
+	base := filepath.Base(filename)
+	for _, entry := range entries {
+		if entry.name == "" && len(entries) == 1 {
+			return entry.digest, nil
+		}
+		if entry.name == base || filepath.Base(filepath.FromSlash(entry.name)) == base {
+			return entry.digest, nil
+		}
+	}
+	return "", fmt.Errorf("no checksum listed for %s", base)
+}
+
+// findChecksumFile returns the first checksum file present next to a
+// release and the algorithm its name implies, or "" if there is none
+func findChecksumFile(releasePath string) (string, string) {
+	for _, file := range checksumFiles {
+		path := filepath.Join(filepath.Dir(releasePath), file.name)
+		if strings.HasPrefix(file.name, ".") {
+			path = releasePath + file.name
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path, file.algorithm
+		}
+	}
+	return "", ""
+}
+
+// SetChecksumAlgorithm forces the algorithm of the checksums passed to
+// VerifyChecksum. By default it is inferred from the digest length, which
+// cannot tell BLAKE3 from SHA256.
+func (v *Verifier) SetChecksumAlgorithm(algorithm string) {
+	v.checksumAlgorithm = strings.ToLower(algorithm)
+}
+
+// checksumAlgorithm infers the algorithm of a hex digest from its length
+func checksumAlgorithm(digest string) string {
+	switch len(digest) {
+	case sha256.Size * 2:
+		return ChecksumSHA256
+	case sha512.Size * 2:
+		return ChecksumSHA512
+	default:
+		return ""
+	}
+}
+
+// newChecksumHash returns the hash computing a checksum algorithm
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	case ChecksumBLAKE3:
+		return blake3.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+}
+
+// hashFile writes a file to a hash. Large files are read ahead on another
+// goroutine so that reading the next block overlaps hashing the current
+// one; BLAKE3 additionally hashes the chunks of each block in parallel.
+func hashFile(file *os.File, h hash.Hash) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() < parallelHashThreshold {
+		_, err := io.Copy(h, file)
+		return err
+	}
+
+	type block struct {
+		data []byte
+		err  error
+	}
+	full := make(chan block, 2)
+	free := make(chan []byte, 3)
+	for n := 0; n < cap(free); n++ {
+		free <- make([]byte, hashBlockSize)
+	}
+
+	go func() {
+		defer close(full)
+		for buf := range free {
+			n, err := io.ReadFull(file, buf)
+			if n > 0 {
+				full <- block{data: buf[:n]}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				full <- block{err: err}
+				return
+			}
+		}
+	}()
+
+	for b := range full {
+		if b.err != nil {
+			return b.err
+		}
+		h.Write(b.data)
+		free <- b.data[:cap(b.data)]
+	}
+	return nil
+}
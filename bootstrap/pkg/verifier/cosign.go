@@ -0,0 +1,337 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Signature types understood by the verifier
+const (
+	SignatureEd25519 = "ed25519"
+	SignatureCosign  = "cosign"
+)
+
+// Fulcio certificate extensions carrying the OIDC issuer
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// CosignOptions configures verification of cosign keyless signatures
+type CosignOptions struct {
+	// FulcioRoots is a PEM file with the Fulcio root and intermediate
+	// certificates
+	FulcioRoots string
+	// RekorPublicKey is a PEM file with the Rekor transparency log key
+	RekorPublicKey string
+	// Identity is the expected certificate subject (email or URI)
+	Identity string
+	// IdentityRegexp matches the certificate subject when Identity is empty
+	IdentityRegexp string
+	// Issuer is the expected OIDC issuer of the signing identity, which
+	// is required
+	Issuer string
+}
+
+// cosignBundle is the bundle written by `cosign sign-blob --bundle`
+type cosignBundle struct {
+	Base64Signature string `json:"base64Signature"`
+	Cert            string `json:"cert"`
+	RekorBundle     struct {
+		SignedEntryTimestamp string             `json:"SignedEntryTimestamp"`
+		Payload              rekorBundlePayload `json:"Payload"`
+	} `json:"rekorBundle"`
+}
+
+// rekorBundlePayload is the Rekor entry signed by the log. Its fields are
+// declared in canonical (sorted) order so that marshalling it reproduces
+// the bytes Rekor signed.
+type rekorBundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the subset of a hashedrekord entry body we check
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// SetSignatureType selects how signatures are verified
+func (v *Verifier) SetSignatureType(signatureType string) {
+	v.signatureType = signatureType
+}
+
+// SetCosign configures cosign keyless verification
+func (v *Verifier) SetCosign(opts CosignOptions) {
+	v.cosign = opts
+}
+
+// verifyCosign verifies a cosign bundle against the SHA256 digest of an
+// artifact: the Rekor inclusion promise, the Fulcio certificate chain and
+// signer identity, and finally the artifact signature itself
+func (v *Verifier) verifyCosign(digest []byte, bundleData []byte) error {
+	var bundle cosignBundle
+	if err := json.Unmarshal(bundleData, &bundle); err != nil {
+		return fmt.Errorf("failed to parse cosign bundle: %w", err)
+	}
+
+	// Verify the Rekor signed entry timestamp
+	if err := v.verifyRekorSET(&bundle); err != nil {
+		return err
+	}
+	integratedTime := time.Unix(bundle.RekorBundle.Payload.IntegratedTime, 0)
+
+	// Verify the signing certificate
+	cert, err := parseBundleCert(bundle.Cert)
+	if err != nil {
+		return err
+	}
+	if err := v.verifyFulcioChain(cert, integratedTime); err != nil {
+		return err
+	}
+	if err := v.verifyIdentity(cert); err != nil {
+		return err
+	}
+
+	// Verify the artifact signature
+	signature, err := base64.StdEncoding.DecodeString(bundle.Base64Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported certificate key type %T", cert.PublicKey)
+	}
+	if !ecdsa.VerifyASN1(publicKey, digest, signature) {
+		return fmt.Errorf("artifact signature does not match certificate")
+	}
+
+	// Make sure the log entry is for this artifact and signature
+	if err := checkRekorBody(bundle.RekorBundle.Payload.Body, digest, bundle.Base64Signature); err != nil {
+		return err
+	}
+
+	v.log.Infof("Cosign signature verified for %s", certIdentity(cert))
+	return nil
+}
+
+// verifyRekorSET checks the transparency log's signature over the entry
+func (v *Verifier) verifyRekorSET(bundle *cosignBundle) error {
+	if v.cosign.RekorPublicKey == "" {
+		return fmt.Errorf("no Rekor public key configured")
+	}
+
+	key, err := loadECDSAPublicKey(v.cosign.RekorPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to load Rekor public key: %w", err)
+	}
+
+	set, err := base64.StdEncoding.DecodeString(bundle.RekorBundle.SignedEntryTimestamp)
+	if err != nil {
+		return fmt.Errorf("failed to decode signed entry timestamp: %w", err)
+	}
+
+	payload, err := json.Marshal(bundle.RekorBundle.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode Rekor payload: %w", err)
+	}
+
+	digest := sha256.Sum256(payload)
+	if !ecdsa.VerifyASN1(key, digest[:], set) {
+		return fmt.Errorf("Rekor signed entry timestamp is invalid")
+	}
+
+	return nil
+}
+
+// verifyFulcioChain checks that the certificate chains to a configured
+// Fulcio root and was valid when the entry was logged
+func (v *Verifier) verifyFulcioChain(cert *x509.Certificate, at time.Time) error {
+	if v.cosign.FulcioRoots == "" {
+		return fmt.Errorf("no Fulcio roots configured")
+	}
+
+	data, err := os.ReadFile(v.cosign.FulcioRoots)
+	if err != nil {
+		return fmt.Errorf("failed to read Fulcio roots: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse Fulcio certificate: %w", err)
+		}
+		if bytes.Equal(ca.RawIssuer, ca.RawSubject) {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate chain verification failed: %w", err)
+	}
+
+	return nil
+}
+
+// verifyIdentity checks the certificate subject and OIDC issuer
+func (v *Verifier) verifyIdentity(cert *x509.Certificate) error {
+	identity := certIdentity(cert)
+
+	switch {
+	case v.cosign.Identity != "":
+		if identity != v.cosign.Identity {
+			return fmt.Errorf("unexpected signer identity %q", identity)
+		}
+	case v.cosign.IdentityRegexp != "":
+		re, err := regexp.Compile(v.cosign.IdentityRegexp)
+		if err != nil {
+			return fmt.Errorf("invalid identity pattern: %w", err)
+		}
+		if !re.MatchString(identity) {
+			return fmt.Errorf("unexpected signer identity %q", identity)
+		}
+	default:
+		return fmt.Errorf("no signer identity configured")
+	}
+
+	if v.cosign.Issuer == "" {
+		return fmt.Errorf("no OIDC issuer configured")
+	}
+	if issuer := certIssuer(cert); issuer != v.cosign.Issuer {
+		return fmt.Errorf("unexpected OIDC issuer %q", issuer)
+	}
+
+	return nil
+}
+
+// checkRekorBody makes sure a hashedrekord entry records the given
+// digest and signature
+func checkRekorBody(body string, digest []byte, signature string) error {
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return fmt.Errorf("failed to decode Rekor entry: %w", err)
+	}
+
+	var entry hashedRekord
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("failed to parse Rekor entry: %w", err)
+	}
+
+	if entry.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported Rekor entry kind %q", entry.Kind)
+	}
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(digest) {
+		return fmt.Errorf("Rekor entry is for a different artifact")
+	}
+	if entry.Spec.Signature.Content != signature {
+		return fmt.Errorf("Rekor entry is for a different signature")
+	}
+
+	return nil
+}
+
+// parseBundleCert decodes the base64 PEM certificate in a bundle
+func parseBundleCert(encoded string) (*x509.Certificate, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// loadECDSAPublicKey reads a PEM encoded ECDSA public key
+func loadECDSAPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return ecKey, nil
+}
+
+// certIdentity returns the signing identity of a Fulcio certificate
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// certIssuer returns the OIDC issuer recorded in a Fulcio certificate
+func certIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidIssuerV1):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,279 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	cosignIdentity = "release@example.com"
+	cosignIssuer   = "https://accounts.example.com"
+)
+
+// cosignCA is a Fulcio certificate authority for tests
+type cosignCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCosignCA(t *testing.T) cosignCA {
+	t.Helper()
+	key := newECDSAKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cosignCA{key: key, cert: cert}
+}
+
+// issue returns a code signing certificate for identity and issuer, valid
+// from notBefore for ten minutes as Fulcio certificates are
+func (ca cosignCA) issue(t *testing.T, key *ecdsa.PrivateKey, identity, issuer string, notBefore time.Time) []byte {
+	t.Helper()
+	issuerValue, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{identity},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuerValue}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writePEM writes a PEM block to a temporary file and returns its path
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// cosignTestBundle holds what goes into a cosign bundle, for tests to
+// change before it is encoded
+type cosignTestBundle struct {
+	signer *ecdsa.PrivateKey
+	cert   []byte
+	// signed is the data the artifact signature covers
+	signed []byte
+	// logged is the data the Rekor entry records
+	logged []byte
+	// loggedSignature replaces the signature the Rekor entry records
+	loggedSignature string
+	integratedTime  time.Time
+	rekor           *ecdsa.PrivateKey
+}
+
+// encode returns the bundle as cosign writes it
+func (b cosignTestBundle) encode(t *testing.T) []byte {
+	t.Helper()
+	digest := sha256.Sum256(b.signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, b.signer, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedSignature := base64.StdEncoding.EncodeToString(signature)
+
+	var entry hashedRekord
+	entry.Kind = "hashedrekord"
+	logged := sha256.Sum256(b.logged)
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(logged[:])
+	entry.Spec.Signature.Content = encodedSignature
+	if b.loggedSignature != "" {
+		entry.Spec.Signature.Content = b.loggedSignature
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle cosignBundle
+	bundle.Base64Signature = encodedSignature
+	bundle.Cert = base64.StdEncoding.EncodeToString(b.cert)
+	bundle.RekorBundle.Payload = rekorBundlePayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: b.integratedTime.Unix(),
+		LogID:          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+		LogIndex:       42,
+	}
+	payload, err := json.Marshal(bundle.RekorBundle.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadDigest := sha256.Sum256(payload)
+	set, err := ecdsa.SignASN1(rand.Reader, b.rekor, payloadDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.RekorBundle.SignedEntryTimestamp = base64.StdEncoding.EncodeToString(set)
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyCosign(t *testing.T) {
+	data := []byte("release")
+	ca := newCosignCA(t)
+	otherCA := newCosignCA(t)
+	signer := newECDSAKey(t)
+	rekor := newECDSAKey(t)
+	otherRekor := newECDSAKey(t)
+
+	// The certificate had expired by now but was valid when logged
+	issued := time.Now().Add(-time.Hour)
+	logged := issued.Add(5 * time.Minute)
+
+	rekorDER, err := x509.MarshalPKIXPublicKey(&rekor.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := CosignOptions{
+		FulcioRoots:    writePEM(t, "CERTIFICATE", ca.cert.Raw),
+		RekorPublicKey: writePEM(t, "PUBLIC KEY", rekorDER),
+		Identity:       cosignIdentity,
+		Issuer:         cosignIssuer,
+	}
+
+	tests := []struct {
+		name string
+		// change alters the bundle or the options of a good signature
+		change  func(b *cosignTestBundle, opts *CosignOptions)
+		wantErr string
+	}{
+		{"good", func(*cosignTestBundle, *CosignOptions) {}, ""},
+		{"identity pattern", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.Identity, opts.IdentityRegexp = "", `^release@example\.com$`
+		}, ""},
+		{"other identity", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.cert = ca.issue(t, signer, "attacker@example.com", cosignIssuer, issued)
+		}, "unexpected signer identity"},
+		{"identity pattern mismatch", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.Identity, opts.IdentityRegexp = "", `^ci@example\.com$`
+		}, "unexpected signer identity"},
+		{"no identity", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.Identity = ""
+		}, "no signer identity configured"},
+		{"other issuer", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.cert = ca.issue(t, signer, cosignIdentity, "https://evil.example.com", issued)
+		}, "unexpected OIDC issuer"},
+		{"no issuer", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.Issuer = ""
+		}, "no OIDC issuer configured"},
+		{"untrusted certificate authority", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.cert = otherCA.issue(t, signer, cosignIdentity, cosignIssuer, issued)
+		}, "certificate chain verification failed"},
+		{"logged after the certificate expired", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.integratedTime = issued.Add(time.Hour)
+		}, "certificate chain verification failed"},
+		{"no Fulcio roots", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.FulcioRoots = ""
+		}, "no Fulcio roots configured"},
+		{"entry timestamp from another log", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.rekor = otherRekor
+		}, "Rekor signed entry timestamp is invalid"},
+		{"no Rekor key", func(_ *cosignTestBundle, opts *CosignOptions) {
+			opts.RekorPublicKey = ""
+		}, "no Rekor public key configured"},
+		{"signed by another key", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.signer = newECDSAKey(t)
+		}, "artifact signature does not match certificate"},
+		{"other data", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.signed, b.logged = []byte("tampered"), []byte("tampered")
+		}, "artifact signature does not match certificate"},
+		{"entry for another artifact", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.logged = []byte("tampered")
+		}, "Rekor entry is for a different artifact"},
+		{"entry for another signature", func(b *cosignTestBundle, _ *CosignOptions) {
+			b.loggedSignature = base64.StdEncoding.EncodeToString([]byte("other"))
+		}, "Rekor entry is for a different signature"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := cosignTestBundle{
+				signer:         signer,
+				cert:           ca.issue(t, signer, cosignIdentity, cosignIssuer, issued),
+				signed:         data,
+				logged:         data,
+				integratedTime: logged,
+				rekor:          rekor,
+			}
+			opts := opts
+			test.change(&bundle, &opts)
+
+			v := New("", testLogger{})
+			v.SetSignatureType(SignatureCosign)
+			v.SetCosign(opts)
+			signature := base64.StdEncoding.EncodeToString(bundle.encode(t))
+			checkErr(t, v.VerifyFile(writeSigned(t, data), signature), test.wantErr)
+		})
+	}
+}
+
+func TestVerifyCosignMalformed(t *testing.T) {
+	tests := map[string]string{
+		"not base64": "not base64!",
+		"not json":   base64.StdEncoding.EncodeToString([]byte("{")),
+	}
+
+	for name, signature := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := New("", testLogger{})
+			v.SetSignatureType(SignatureCosign)
+			if err := v.VerifyFile(writeSigned(t, []byte("release")), signature); err == nil {
+				t.Fatal("VerifyFile accepted the bundle")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultKeyID names the key passed to New among the trusted keys
+const defaultKeyID = "default"
+
+// TrustedKey is a public key signatures are accepted from while it is
+// valid. A zero NotBefore or NotAfter leaves that end of the validity
+// window open.
+type TrustedKey struct {
+	ID string `json:"id"`
+	// PublicKey is a base64 Ed25519 key or a minisign public key
+	PublicKey string    `json:"public_key"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// KeyRotation is a signed document published by the companion that
+// replaces the trusted keys. It must be signed by a key trusted before
+// the rotation, and its version must increase with every rotation.
+type KeyRotation struct {
+	Version int64        `json:"version"`
+	Keys    []TrustedKey `json:"keys"`
+	// Revoked lists IDs of keys that are no longer trusted
+	Revoked []string `json:"revoked"`
+}
+
+// SetTrustedKeys sets the keys trusted in addition to the key passed to
+// New
+func (v *Verifier) SetTrustedKeys(keys []TrustedKey) {
+	v.keys = keys
+}
+
+// SetPublicKey replaces the key passed to New, e.g. once a key has been
+// pinned on first use
+func (v *Verifier) SetPublicKey(publicKey string) {
+	v.publicKey = publicKey
+}
+
+// Trusts reports whether a public key is among the keys trusted now
+func (v *Verifier) Trusts(publicKey string) bool {
+	candidate, err := parsePublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+	keys, err := v.trustedKeys(time.Now())
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		if bytes.Equal(key.key, candidate.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyFingerprint returns the SHA256 fingerprint of a public key in the
+// form shown by ssh-keygen, e.g. SHA256:2m1nPm...
+func KeyFingerprint(publicKey string) (string, error) {
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key.key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeyRotationVersion returns the version of the last key rotation
+// applied, or 0 when the configured keys are used
+func (v *Verifier) KeyRotationVersion() int64 {
+	return v.rotationVersion
+}
+
+// validAt reports whether a key may be used at a given time
+func (k TrustedKey) validAt(now time.Time) bool {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && now.After(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// trustedKeys returns the keys valid at a given time
+func (v *Verifier) trustedKeys(now time.Time) ([]*publicKey, error) {
+	candidates := v.keys
+	if v.publicKey != "" {
+		candidates = append([]TrustedKey{{ID: defaultKeyID, PublicKey: v.publicKey}}, candidates...)
+	}
+
+	var keys []*publicKey
+	for _, trusted := range candidates {
+		if !trusted.validAt(now) {
+			continue
+		}
+		key, err := parsePublicKey(trusted.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %s: %w", trusted.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no trusted key is currently valid")
+	}
+	return keys, nil
+}
+
+// ApplyKeyRotation verifies a key rotation document against the keys
+// trusted now and, if it is newer than the last rotation applied, makes
+// its keys the only trusted ones. It reports whether the keys changed.
+func (v *Verifier) ApplyKeyRotation(data []byte, signature string) (bool, error) {
+	if err := v.verifyTrusted(data, signature); err != nil {
+		return false, fmt.Errorf("key rotation signature: %w", err)
+	}
+
+	rotation, err := parseKeyRotation(data)
+	if err != nil {
+		return false, err
+	}
+	if rotation.Version <= v.rotationVersion {
+		return false, nil
+	}
+
+	v.applyRotation(rotation)
+	return true, nil
+}
+
+// verifyTrusted checks a signature over data made by one of the keys
+// trusted now: Ed25519 over its SHA256 digest, or minisign. Unlike a
+// Stream it requires a signature, and neither cosign identities nor
+// OpenPGP keys can stand in for the trusted keys.
+func (v *Verifier) verifyTrusted(data []byte, signature string) error {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return errors.New("the signature is empty")
+	}
+	if isMinisign(signature) {
+		h := newPrehash()
+		h.Write(data)
+		return v.verifyMinisign(h.Sum(nil), signature)
+	}
+	sum := sha256.Sum256(data)
+	return v.verifyEd25519(sum[:], signature)
+}
+
+// LoadKeyRotation applies a key rotation document without checking its
+// signature, for documents verified by ApplyKeyRotation and persisted
+// since
+func (v *Verifier) LoadKeyRotation(data []byte) error {
+	rotation, err := parseKeyRotation(data)
+	if err != nil {
+		return err
+	}
+	v.applyRotation(rotation)
+	return nil
+}
+
+// parseKeyRotation decodes and validates a key rotation document
+func parseKeyRotation(data []byte) (*KeyRotation, error) {
+	var rotation KeyRotation
+	if err := json.Unmarshal(data, &rotation); err != nil {
+		return nil, fmt.Errorf("failed to parse key rotation: %w", err)
+	}
+	if rotation.Version <= 0 {
+		return nil, fmt.Errorf("key rotation has no version")
+	}
+
+	revoked := map[string]bool{}
+	for _, id := range rotation.Revoked {
+		revoked[id] = true
+	}
+
+	var keys []TrustedKey
+	for _, key := range rotation.Keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("key rotation lists a key without an ID")
+		}
+		if _, err := parsePublicKey(key.PublicKey); err != nil {
+			return nil, fmt.Errorf("key rotation key %s: %w", key.ID, err)
+		}
+		if !revoked[key.ID] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key rotation leaves no trusted key")
+	}
+
+	rotation.Keys = keys
+	return &rotation, nil
+}
+
+// applyRotation replaces the trusted keys, including the key passed to
+// New, with those of a rotation
+func (v *Verifier) applyRotation(rotation *KeyRotation) {
+	v.publicKey = ""
+	v.keys = rotation.Keys
+	v.rotationVersion = rotation.Version
+}
@@ -0,0 +1,106 @@
+package verifier
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// rotationDocument returns a key rotation document trusting keys
+func rotationDocument(t *testing.T, version int64, keys ...testKey) []byte {
+	t.Helper()
+	rotation := KeyRotation{Version: version}
+	for n, key := range keys {
+		rotation.Keys = append(rotation.Keys, TrustedKey{ID: string(rune('a' + n)), PublicKey: key.public})
+	}
+	data, err := json.Marshal(rotation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestApplyKeyRotation(t *testing.T) {
+	trusted := newTestKey(t)
+	attacker := newTestKey(t)
+	data := rotationDocument(t, 1, attacker)
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   bool
+	}{
+		{"empty signature", "", true},
+		{"whitespace signature", " \n\t", true},
+		{"garbage signature", "not a signature", true},
+		{"signed by an untrusted key", attacker.sign(data), true},
+		{"signed over other data", trusted.sign([]byte("other")), true},
+		{"signed by the trusted key", trusted.sign(data), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := New(trusted.public, testLogger{})
+			rotated, err := v.ApplyKeyRotation(data, test.signature)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ApplyKeyRotation() error = %v, want error %v", err, test.wantErr)
+			}
+			if rotated == test.wantErr {
+				t.Errorf("ApplyKeyRotation() rotated = %v", rotated)
+			}
+			if got := v.Trusts(attacker.public); got != !test.wantErr {
+				t.Errorf("Trusts(new key) = %v after the rotation", got)
+			}
+			if got := v.Trusts(trusted.public); got != test.wantErr {
+				t.Errorf("Trusts(old key) = %v after the rotation", got)
+			}
+		})
+	}
+}
+
+func TestApplyKeyRotationVersions(t *testing.T) {
+	first := newTestKey(t)
+	second := newTestKey(t)
+	third := newTestKey(t)
+	v := New(first.public, testLogger{})
+
+	rotation := rotationDocument(t, 2, second)
+	if rotated, err := v.ApplyKeyRotation(rotation, first.sign(rotation)); err != nil || !rotated {
+		t.Fatalf("ApplyKeyRotation() = %v, %v", rotated, err)
+	}
+
+	// Only the keys of the last rotation may sign the next one
+	next := rotationDocument(t, 3, third)
+	if rotated, err := v.ApplyKeyRotation(next, first.sign(next)); err == nil || rotated {
+		t.Errorf("rotation signed by a replaced key = %v, %v", rotated, err)
+	}
+
+	// Older and replayed rotations change nothing
+	for _, version := range []int64{1, 2} {
+		old := rotationDocument(t, version, third)
+		if rotated, err := v.ApplyKeyRotation(old, second.sign(old)); err != nil || rotated {
+			t.Errorf("rotation version %d = %v, %v", version, rotated, err)
+		}
+	}
+	if v.KeyRotationVersion() != 2 || !v.Trusts(second.public) || v.Trusts(third.public) {
+		t.Errorf("keys changed by an old rotation")
+	}
+}
+
+func TestParseKeyRotationRejects(t *testing.T) {
+	key := newTestKey(t)
+	tests := map[string]string{
+		"not JSON":       "{",
+		"no version":     `{"keys": [{"id": "a", "public_key": "` + key.public + `"}]}`,
+		"key without ID": `{"version": 1, "keys": [{"public_key": "` + key.public + `"}]}`,
+		"invalid key":    `{"version": 1, "keys": [{"id": "a", "public_key": "AAAA"}]}`,
+		"all revoked":    `{"version": 1, "keys": [{"id": "a", "public_key": "` + key.public + `"}], "revoked": ["a"]}`,
+		"no keys at all": `{"version": 1}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseKeyRotation([]byte(data)); err == nil {
+				t.Error("parseKeyRotation() accepted the document")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Minisign signature algorithms. Legacy signatures sign the file itself,
+// prehashed ones its BLAKE2b-512 digest.
+const (
+	minisignLegacy    = "Ed"
+	minisignPrehashed = "ED"
+)
+
+// Minisign line prefixes
+const (
+	untrustedCommentPrefix = "untrusted comment:"
+	trustedCommentPrefix   = "trusted comment: "
+)
+
+// minisignKeyIDSize is the length of a minisign key ID
+const minisignKeyIDSize = 8
+
+// minisignSignature is a parsed .minisig file
+type minisignSignature struct {
+	algorithm       string
+	keyID           [minisignKeyIDSize]byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+// publicKey is an Ed25519 key, optionally with the minisign key ID it
+// was published with
+type publicKey struct {
+	key   ed25519.PublicKey
+	keyID [minisignKeyIDSize]byte
+	hasID bool
+}
+
+// isMinisign reports whether a signature is in the minisign format rather
+// than a bare base64 Ed25519 signature
+func isMinisign(signature string) bool {
+	return strings.HasPrefix(strings.TrimSpace(signature), untrustedCommentPrefix)
+}
+
+// newPrehash returns the BLAKE2b-512 hash signed by prehashed minisign
+// signatures
+func newPrehash() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// parseMinisignSignature parses the four lines of a minisign signature:
+// the untrusted comment, the signature, the trusted comment and the
+// global signature over the signature and the trusted comment
+func parseMinisignSignature(data string) (*minisignSignature, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedCommentPrefix) {
+		return nil, fmt.Errorf("malformed minisign signature")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed minisign signature")
+	}
+
+	comment, ok := strings.CutPrefix(lines[2], trustedCommentPrefix)
+	if !ok {
+		return nil, fmt.Errorf("minisign signature has no trusted comment")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed minisign global signature")
+	}
+
+	sig := &minisignSignature{
+		algorithm:       string(raw[:2]),
+		signature:       raw[2+minisignKeyIDSize:],
+		trustedComment:  comment,
+		globalSignature: global,
+	}
+	copy(sig.keyID[:], raw[2:2+minisignKeyIDSize])
+	return sig, nil
+}
+
+// parsePublicKey decodes a base64 public key, either a bare Ed25519 key
+// or a minisign key with its key ID. The untrusted comment line of a
+// minisign key file is skipped.
+func parsePublicKey(encoded string) (*publicKey, error) {
+	lines := strings.Split(strings.TrimSpace(encoded), "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	switch {
+	case len(raw) == ed25519.PublicKeySize:
+		return &publicKey{key: raw}, nil
+	case len(raw) == 2+minisignKeyIDSize+ed25519.PublicKeySize && string(raw[:2]) == minisignLegacy:
+		key := &publicKey{key: raw[2+minisignKeyIDSize:], hasID: true}
+		copy(key.keyID[:], raw[2:2+minisignKeyIDSize])
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid public key length %d", len(raw))
+	}
+}
+
+// formatKeyID renders a key ID the way minisign prints it
+func formatKeyID(id [minisignKeyIDSize]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// verifyMinisign verifies a minisign signature given the BLAKE2b-512
+// digest of the signed file
+func (v *Verifier) verifyMinisign(digest []byte, signature string) error {
+	sig, err := parseMinisignSignature(signature)
+	if err != nil {
+		return err
+	}
+
+	switch sig.algorithm {
+	case minisignPrehashed:
+	case minisignLegacy:
+		return fmt.Errorf("legacy minisign signatures are not supported, sign with minisign -H")
+	default:
+		return fmt.Errorf("unknown minisign algorithm %q", sig.algorithm)
+	}
+
+	keys, err := v.trustedKeys(time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Keys published without a minisign key ID match any signature
+	var key *publicKey
+	for _, trusted := range keys {
+		if !trusted.hasID || trusted.keyID == sig.keyID {
+			if ed25519.Verify(trusted.key, digest, sig.signature) {
+				key = trusted
+				break
+			}
+		}
+	}
+
+	if key == nil {
+		return fmt.Errorf("no trusted key verifies the signature of key %s", formatKeyID(sig.keyID))
+	}
+
+	// The global signature binds the trusted comment to the signature
+	signed := bytes.Join([][]byte{sig.signature, []byte(sig.trustedComment)}, nil)
+	if !ed25519.Verify(key.key, signed, sig.globalSignature) {
+		return fmt.Errorf("trusted comment verification failed")
+	}
+
+	v.log.Infof("Trusted comment: %s", sig.trustedComment)
+	return nil
+}
@@ -0,0 +1,143 @@
+package verifier
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignTestSignature holds the parts of a minisign signature a test
+// may change before it is encoded
+type minisignTestSignature struct {
+	algorithm      string
+	keyID          [minisignKeyIDSize]byte
+	signature      []byte
+	trustedComment string
+	global         []byte
+}
+
+// minisignSign signs the BLAKE2b-512 digest of data as minisign -H does
+func minisignSign(k testKey, keyID [minisignKeyIDSize]byte, data []byte) *minisignTestSignature {
+	digest := blake2b.Sum512(data)
+	sig := &minisignTestSignature{
+		algorithm:      minisignPrehashed,
+		keyID:          keyID,
+		signature:      ed25519.Sign(k.private, digest[:]),
+		trustedComment: "timestamp:1700000000\tfile:release.tar.gz",
+	}
+	sig.global = ed25519.Sign(k.private, append(append([]byte{}, sig.signature...), sig.trustedComment...))
+	return sig
+}
+
+// String encodes the signature as a .minisig file
+func (s *minisignTestSignature) String() string {
+	raw := append([]byte(s.algorithm), s.keyID[:]...)
+	raw = append(raw, s.signature...)
+	return strings.Join([]string{
+		untrustedCommentPrefix + " signature from minisign secret key",
+		base64.StdEncoding.EncodeToString(raw),
+		trustedCommentPrefix + s.trustedComment,
+		base64.StdEncoding.EncodeToString(s.global),
+	}, "\n") + "\n"
+}
+
+// minisignPublicKey encodes the public half of k as a minisign key file
+func minisignPublicKey(k testKey, keyID [minisignKeyIDSize]byte) string {
+	raw, _ := base64.StdEncoding.DecodeString(k.public)
+	raw = append(append([]byte(minisignLegacy), keyID[:]...), raw...)
+	return untrustedCommentPrefix + " minisign public key\n" + base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	data := []byte("release")
+	key := newTestKey(t)
+	other := newTestKey(t)
+	keyID := [minisignKeyIDSize]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	otherID := [minisignKeyIDSize]byte{8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		name      string
+		publicKey string
+		signed    []byte
+		signature string
+		wantErr   string
+	}{
+		{"good", minisignPublicKey(key, keyID), data, minisignSign(key, keyID, data).String(), ""},
+		{"bare key", key.public, data, minisignSign(key, keyID, data).String(), ""},
+		{"crlf line endings", minisignPublicKey(key, keyID), data,
+			strings.ReplaceAll(minisignSign(key, keyID, data).String(), "\n", "\r\n"), ""},
+		{"other key ID", minisignPublicKey(key, keyID), data, minisignSign(key, otherID, data).String(),
+			"no trusted key verifies the signature of key"},
+		{"other key", minisignPublicKey(key, keyID), data, minisignSign(other, keyID, data).String(),
+			"no trusted key verifies"},
+		{"other data", minisignPublicKey(key, keyID), []byte("tampered"), minisignSign(key, keyID, data).String(),
+			"no trusted key verifies"},
+		{"tampered trusted comment", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.trustedComment = "timestamp:1800000000\tfile:release.tar.gz"
+			return sig.String()
+		}(), "trusted comment verification failed"},
+		{"global signature by other key", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.global = minisignSign(other, keyID, data).global
+			return sig.String()
+		}(), "trusted comment verification failed"},
+		{"legacy", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.algorithm = minisignLegacy
+			sig.signature = ed25519.Sign(key.private, data)
+			return sig.String()
+		}(), "legacy minisign signatures are not supported"},
+		{"unknown algorithm", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.algorithm = "EX"
+			return sig.String()
+		}(), "unknown minisign algorithm"},
+		{"no trusted comment", minisignPublicKey(key, keyID), data,
+			strings.Replace(minisignSign(key, keyID, data).String(), "\n"+trustedCommentPrefix, "\ncomment: ", 1),
+			"has no trusted comment"},
+		{"missing global signature", minisignPublicKey(key, keyID), data, func() string {
+			lines := strings.Split(minisignSign(key, keyID, data).String(), "\n")
+			return strings.Join(lines[:3], "\n")
+		}(), "malformed minisign signature"},
+		{"short signature", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.signature = sig.signature[:32]
+			return sig.String()
+		}(), "malformed minisign signature"},
+		{"short global signature", minisignPublicKey(key, keyID), data, func() string {
+			sig := minisignSign(key, keyID, data)
+			sig.global = sig.global[:32]
+			return sig.String()
+		}(), "malformed minisign global signature"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := New(test.publicKey, testLogger{})
+			checkErr(t, v.VerifyFile(writeSigned(t, test.signed), test.signature), test.wantErr)
+		})
+	}
+}
+
+func TestParsePublicKeyRejects(t *testing.T) {
+	key := newTestKey(t)
+	raw, _ := base64.StdEncoding.DecodeString(key.public)
+
+	tests := map[string]string{
+		"not base64":  "not base64!",
+		"short":       base64.StdEncoding.EncodeToString(raw[:31]),
+		"wrong magic": base64.StdEncoding.EncodeToString(append([]byte("XX12345678"), raw...)),
+	}
+
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parsePublicKey(encoded); err == nil {
+				t.Fatal("parsePublicKey accepted the key")
+			}
+		})
+	}
+}
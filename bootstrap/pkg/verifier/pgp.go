@@ -0,0 +1,170 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha512"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// pgpSignatureBlock is the armor type of a detached OpenPGP signature
+const pgpSignatureBlock = "PGP SIGNATURE"
+
+// PGPOptions configures verification of OpenPGP detached signatures
+type PGPOptions struct {
+	// Keys are ASCII armored public keys
+	Keys []string
+	// Keyring is a keyring file, armored or binary as exported by
+	// gpg --export
+	Keyring string
+}
+
+// SetPGP configures the keys trusted for OpenPGP signatures
+func (v *Verifier) SetPGP(opts PGPOptions) {
+	v.pgp = opts
+}
+
+// isPGP reports whether a signature is an ASCII armored OpenPGP signature
+// such as the .asc file written by gpg --detach-sign --armor
+func isPGP(signature string) bool {
+	return strings.HasPrefix(strings.TrimSpace(signature), "-----BEGIN "+pgpSignatureBlock+"-----")
+}
+
+// parsePGPSignature decodes an armored detached signature over a binary
+// file
+func parsePGPSignature(signature string) (*packet.Signature, error) {
+	block, err := armor.Decode(strings.NewReader(strings.TrimSpace(signature)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode OpenPGP signature: %w", err)
+	}
+	if block.Type != pgpSignatureBlock {
+		return nil, fmt.Errorf("unexpected OpenPGP block %q", block.Type)
+	}
+
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP signature: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unsupported OpenPGP signature packet %T", p)
+	}
+
+	switch {
+	case sig.SigType != packet.SigTypeBinary:
+		return nil, fmt.Errorf("unsupported OpenPGP signature type %d", sig.SigType)
+	case sig.IssuerKeyId == nil:
+		return nil, fmt.Errorf("OpenPGP signature does not name its key")
+	}
+	switch sig.Hash {
+	case crypto.SHA256, crypto.SHA384, crypto.SHA512:
+	default:
+		// SHA-1 and older digests are not trusted
+		return nil, fmt.Errorf("unsupported OpenPGP digest %v", sig.Hash)
+	}
+
+	return sig, nil
+}
+
+// verifyPGP checks an OpenPGP signature given the hash of the signed file,
+// made by the signature's PrepareVerify. Key IDs are short enough to be
+// shared, so every key with the signature's ID that may sign is tried.
+func (v *Verifier) verifyPGP(signed hash.Hash, sig *packet.Signature) error {
+	keyring, err := v.pgpKeyring()
+	if err != nil {
+		return err
+	}
+
+	// Keys not allowed to sign are not returned
+	keys := keyring.KeysByIdUsage(*sig.IssuerKeyId, packet.KeyFlagSign)
+	if len(keys) == 0 {
+		return fmt.Errorf("signed with unknown key %016X", *sig.IssuerKeyId)
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, key := range keys {
+		switch {
+		case key.Revoked(now) || key.Entity.Revoked(now):
+			errs = append(errs, fmt.Errorf("signed with revoked key %016X", *sig.IssuerKeyId))
+			continue
+		case key.SelfSignature != nil && key.PublicKey.KeyExpired(key.SelfSignature, sig.CreationTime):
+			errs = append(errs, fmt.Errorf("signed with expired key %016X", *sig.IssuerKeyId))
+			continue
+		}
+
+		// Verifying consumes the hash, so each key gets a copy
+		h, err := cloneHash(signed, sig.Hash)
+		if err != nil {
+			return err
+		}
+		if err := key.PublicKey.VerifySignature(h, sig); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		v.log.Infof("Good OpenPGP signature from key %X", key.PublicKey.Fingerprint)
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// cloneHash returns a copy of a hash in its current state
+func cloneHash(h hash.Hash, algorithm crypto.Hash) (hash.Hash, error) {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("cannot copy %v hash", algorithm)
+	}
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	clone := algorithm.New()
+	if err := clone.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// pgpKeyring loads the configured OpenPGP public keys
+func (v *Verifier) pgpKeyring() (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, key := range v.pgp.Keys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OpenPGP key: %w", err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	if v.pgp.Keyring != "" {
+		data, err := os.ReadFile(v.pgp.Keyring)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring: %w", err)
+		}
+		read := openpgp.ReadKeyRing
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+			read = openpgp.ReadArmoredKeyRing
+		}
+		entities, err := read(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring %s: %w", v.pgp.Keyring, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("no OpenPGP keys configured")
+	}
+	return keyring, nil
+}
@@ -0,0 +1,158 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newPGPKey returns an OpenPGP key made with config
+func newPGPKey(t *testing.T, config *packet.Config) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Ezra Release", "", "release@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// armoredPublicKey returns the public part of an OpenPGP key, armored
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// pgpSign returns an armored detached signature of data
+func pgpSign(t *testing.T, entity *openpgp.Entity, data []byte, config *packet.Config) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, entity, bytes.NewReader(data), config); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// writeSigned writes data to a temporary file and returns its path
+func writeSigned(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "release.tar.gz")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyPGP(t *testing.T) {
+	data := []byte("release")
+	key := newPGPKey(t, nil)
+	other := newPGPKey(t, nil)
+
+	revoked := newPGPKey(t, nil)
+	revokedSignature := pgpSign(t, revoked, data, nil)
+	if err := revoked.RevokeKey(packet.NoReason, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key expires a minute after it is made and signs an hour later,
+	// which go-crypto only does while it does not know of the expiry
+	made := time.Now().Add(-2 * time.Hour)
+	expired := newPGPKey(t, &packet.Config{KeyLifetimeSecs: 60, Time: func() time.Time { return made }})
+	selfSignature := expired.PrimaryIdentity().SelfSignature
+	lifetime := selfSignature.KeyLifetimeSecs
+	selfSignature.KeyLifetimeSecs = nil
+	expiredSignature := pgpSign(t, expired, data, &packet.Config{Time: func() time.Time { return made.Add(time.Hour) }})
+	selfSignature.KeyLifetimeSecs = lifetime
+
+	tests := []struct {
+		name      string
+		keys      []*openpgp.Entity
+		signed    []byte
+		signature string
+		wantErr   string
+	}{
+		{"good", []*openpgp.Entity{key}, data, pgpSign(t, key, data, nil), ""},
+		{"among other keys", []*openpgp.Entity{other, key}, data, pgpSign(t, key, data, nil), ""},
+		{"sha512", []*openpgp.Entity{key}, data, pgpSign(t, key, data, &packet.Config{DefaultHash: crypto.SHA512}), ""},
+		{"other data", []*openpgp.Entity{key}, []byte("tampered"), pgpSign(t, key, data, nil), "signature verification failed"},
+		{"unknown key", []*openpgp.Entity{other}, data, pgpSign(t, key, data, nil), "unknown key"},
+		{"revoked key", []*openpgp.Entity{revoked}, data, revokedSignature, "revoked key"},
+		{"expired key", []*openpgp.Entity{expired}, data, expiredSignature, "expired key"},
+		{"not armored", []*openpgp.Entity{key}, data, "-----BEGIN PGP SIGNATURE-----\ngarbage", "failed to decode"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var keys []string
+			for _, entity := range test.keys {
+				keys = append(keys, armoredPublicKey(t, entity))
+			}
+			v := New("", testLogger{})
+			v.SetPGP(PGPOptions{Keys: keys})
+
+			err := v.VerifyFile(writeSigned(t, test.signed), test.signature)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("VerifyFile: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("VerifyFile = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPGPKeyring(t *testing.T) {
+	data := []byte("release")
+	key := newPGPKey(t, nil)
+	signature := pgpSign(t, key, data, nil)
+
+	var binary bytes.Buffer
+	if err := key.Serialize(&binary); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"binary":  binary.Bytes(),
+		"armored": []byte(armoredPublicKey(t, key)),
+	}
+
+	for name, keyring := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyring.gpg")
+			if err := os.WriteFile(path, keyring, 0644); err != nil {
+				t.Fatal(err)
+			}
+			v := New("", testLogger{})
+			v.SetPGP(PGPOptions{Keyring: path})
+			if err := v.VerifyFile(writeSigned(t, data), signature); err != nil {
+				t.Fatalf("VerifyFile: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyPGPNoKeys(t *testing.T) {
+	key := newPGPKey(t, nil)
+	data := []byte("release")
+	err := New("", testLogger{}).VerifyFile(writeSigned(t, data), pgpSign(t, key, data, nil))
+	if err == nil || !strings.Contains(err.Error(), "no OpenPGP keys configured") {
+		t.Fatalf("VerifyFile = %v, want an error for missing keys", err)
+	}
+}
@@ -0,0 +1,285 @@
+package verifier
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attestation formats understood by VerifyProvenance
+const (
+	inTotoPayloadType   = "application/vnd.in-toto+json"
+	slsaProvenanceV02   = "https://slsa.dev/provenance/v0.2"
+	slsaProvenanceV1    = "https://slsa.dev/provenance/v1"
+	maxAttestationLines = 64
+)
+
+// ProvenancePolicy lists what a provenance attestation must show. Empty
+// lists are not checked. A builder without an @ref suffix matches every
+// version of that builder.
+type ProvenancePolicy struct {
+	Builders    []string
+	SourceRepos []string
+	BuildTypes  []string
+}
+
+// Provenance is what a verified attestation says about how a file was
+// built
+type Provenance struct {
+	PredicateType string `json:"predicate_type"`
+	Builder       string `json:"builder"`
+	BuildType     string `json:"build_type"`
+	Source        string `json:"source"`
+}
+
+// dsseEnvelope is a DSSE envelope as written by in-toto and the SLSA
+// generators
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// inTotoStatement is the payload of an attestation
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// slsaPredicateV02 is the subset of a SLSA v0.2 predicate we check
+type slsaPredicateV02 struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		ConfigSource struct {
+			URI string `json:"uri"`
+		} `json:"configSource"`
+	} `json:"invocation"`
+}
+
+// slsaPredicateV1 is the subset of a SLSA v1 predicate we check
+type slsaPredicateV1 struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			Workflow struct {
+				Repository string `json:"repository"`
+			} `json:"workflow"`
+		} `json:"externalParameters"`
+		ResolvedDependencies []struct {
+			URI string `json:"uri"`
+		} `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+	} `json:"runDetails"`
+}
+
+// VerifyProvenance checks a provenance attestation for the file with the
+// given SHA256. The attestation is a DSSE envelope, or a JSON Lines file
+// of them, that must be signed by a trusted key, name the file as its
+// subject and satisfy the policy.
+func (v *Verifier) VerifyProvenance(attestation []byte, sha256 string, policy ProvenancePolicy) (*Provenance, error) {
+	sha256 = strings.ToLower(strings.TrimSpace(sha256))
+
+	scanner := bufio.NewScanner(bytes.NewReader(attestation))
+	scanner.Buffer(nil, 4<<20)
+	var lastErr error
+	for lines := 0; scanner.Scan() && lines < maxAttestationLines; lines++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		statement, err := v.openEnvelope(line)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !statement.covers(sha256) {
+			lastErr = fmt.Errorf("attestation does not cover sha256 %s", sha256)
+			continue
+		}
+
+		provenance, err := parseProvenance(statement)
+		if err != nil {
+			return nil, err
+		}
+		if err := policy.check(provenance); err != nil {
+			return nil, err
+		}
+		return provenance, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read attestation: %w", err)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("attestation is empty")
+	}
+	return nil, lastErr
+}
+
+// openEnvelope checks the signature of a DSSE envelope and returns the
+// statement it carries
+func (v *Verifier) openEnvelope(data []byte) (*inTotoStatement, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %w", err)
+	}
+	if envelope.PayloadType != inTotoPayloadType {
+		return nil, fmt.Errorf("unsupported attestation payload type %q", envelope.PayloadType)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attestation payload: %w", err)
+	}
+
+	keys, err := v.trustedKeys(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// DSSE signs the pre-authentication encoding of type and payload
+	signed := dssePAE(envelope.PayloadType, payload)
+	verified := false
+	for _, sig := range envelope.Signatures {
+		signature, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if ed25519.Verify(key.key, signed, signature) {
+				verified = true
+			}
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("attestation is not signed by a trusted key")
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation statement: %w", err)
+	}
+	return &statement, nil
+}
+
+// dssePAE returns the DSSE pre-authentication encoding
+func dssePAE(payloadType string, payload []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// covers reports whether a statement is about the file with the given
+// SHA256
+func (s *inTotoStatement) covers(sha256 string) bool {
+	for _, subject := range s.Subject {
+		if strings.EqualFold(subject.Digest["sha256"], sha256) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProvenance extracts the builder, build type and source of a SLSA
+// provenance predicate
+func parseProvenance(statement *inTotoStatement) (*Provenance, error) {
+	provenance := &Provenance{PredicateType: statement.PredicateType}
+
+	switch {
+	case statement.PredicateType == slsaProvenanceV02:
+		var predicate slsaPredicateV02
+		if err := json.Unmarshal(statement.Predicate, &predicate); err != nil {
+			return nil, fmt.Errorf("failed to parse provenance: %w", err)
+		}
+		provenance.Builder = predicate.Builder.ID
+		provenance.BuildType = predicate.BuildType
+		provenance.Source = normalizeRepo(predicate.Invocation.ConfigSource.URI)
+	case strings.HasPrefix(statement.PredicateType, slsaProvenanceV1):
+		var predicate slsaPredicateV1
+		if err := json.Unmarshal(statement.Predicate, &predicate); err != nil {
+			return nil, fmt.Errorf("failed to parse provenance: %w", err)
+		}
+		provenance.Builder = predicate.RunDetails.Builder.ID
+		provenance.BuildType = predicate.BuildDefinition.BuildType
+		source := predicate.BuildDefinition.ExternalParameters.Workflow.Repository
+		if source == "" && len(predicate.BuildDefinition.ResolvedDependencies) > 0 {
+			source = predicate.BuildDefinition.ResolvedDependencies[0].URI
+		}
+		provenance.Source = normalizeRepo(source)
+	default:
+		return nil, fmt.Errorf("unsupported provenance type %q", statement.PredicateType)
+	}
+
+	if provenance.Builder == "" {
+		return nil, fmt.Errorf("provenance does not name its builder")
+	}
+	return provenance, nil
+}
+
+// normalizeRepo reduces a source URI such as
+// git+https://github.com/org/repo.git@refs/tags/v1 to github.com/org/repo
+func normalizeRepo(uri string) string {
+	uri = strings.TrimPrefix(uri, "git+")
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+	}
+	if i := strings.Index(uri, "@"); i >= 0 {
+		uri = uri[:i]
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git"))
+}
+
+// check verifies a provenance against the policy
+func (p ProvenancePolicy) check(provenance *Provenance) error {
+	if len(p.Builders) > 0 && !matchBuilder(p.Builders, provenance.Builder) {
+		return fmt.Errorf("built by untrusted builder %s", provenance.Builder)
+	}
+	if len(p.SourceRepos) > 0 {
+		trusted := false
+		for _, repo := range p.SourceRepos {
+			if normalizeRepo(repo) == provenance.Source {
+				trusted = true
+			}
+		}
+		if !trusted {
+			return fmt.Errorf("built from untrusted source %q", provenance.Source)
+		}
+	}
+	if len(p.BuildTypes) > 0 && !containsString(p.BuildTypes, provenance.BuildType) {
+		return fmt.Errorf("unexpected build type %q", provenance.BuildType)
+	}
+	return nil
+}
+
+// matchBuilder reports whether a builder ID is trusted
+func matchBuilder(trusted []string, builder string) bool {
+	unversioned, _, _ := strings.Cut(builder, "@")
+	for _, id := range trusted {
+		if id == builder || (!strings.Contains(id, "@") && id == unversioned) {
+			return true
+		}
+	}
+	return false
+}
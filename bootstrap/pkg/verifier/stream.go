@@ -0,0 +1,88 @@
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Stream verifies data as it is written to it, so a download can be
+// checked without reading the file back from disk
+type Stream struct {
+	verifier  *Verifier
+	checksum  string
+	signature string
+	hash      hash.Hash
+	// signed is the hash covered by a minisign or OpenPGP signature,
+	// checked by verifySigned
+	signed       hash.Hash
+	verifySigned func(hash.Hash) error
+	// err is why the signature could not be parsed
+	err error
+}
+
+// NewStream creates a stream verifier. Either the expected SHA256
+// checksum, the signature or both may be empty.
+func (v *Verifier) NewStream(checksum, signature string) *Stream {
+	s := &Stream{
+		verifier:  v,
+		checksum:  strings.ToLower(strings.TrimSpace(checksum)),
+		signature: strings.TrimSpace(signature),
+		hash:      sha256.New(),
+	}
+	if s.signature != "" {
+		s.signed, s.verifySigned, s.err = v.signedHash(s.signature)
+	}
+	return s
+}
+
+// Write adds data to the running hashes
+func (s *Stream) Write(p []byte) (int, error) {
+	if s.signed != nil {
+		s.signed.Write(p)
+	}
+	return s.hash.Write(p)
+}
+
+// Reset discards everything written so far
+func (s *Stream) Reset() {
+	s.hash.Reset()
+	if s.signed != nil {
+		s.signed.Reset()
+	}
+}
+
+// ExpectedSHA256 returns the checksum the data must have, or "" when
+// only the signature is checked
+func (s *Stream) ExpectedSHA256() string {
+	return s.checksum
+}
+
+// Verify checks the data written so far against the expected checksum
+// and signature
+func (s *Stream) Verify() error {
+	sum := s.hash.Sum(nil)
+
+	if s.checksum != "" {
+		if actual := hex.EncodeToString(sum); actual != s.checksum {
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", s.checksum, actual)
+		}
+	}
+
+	switch {
+	case s.err != nil:
+		return fmt.Errorf("signature verification failed: %w", s.err)
+	case s.signed != nil:
+		if err := s.verifySigned(s.signed); err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+	case s.signature != "":
+		if err := s.verifier.verifySignature(sum, s.signature); err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,626 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TUF metadata roles
+const (
+	tufRoleRoot      = "root"
+	tufRoleTimestamp = "timestamp"
+	tufRoleSnapshot  = "snapshot"
+	tufRoleTargets   = "targets"
+)
+
+// TUFTrustedRoles are the roles whose metadata is kept between runs next
+// to the root, in the order they are updated, so that the next update can
+// be checked against it for rollbacks
+var TUFTrustedRoles = []string{tufRoleTimestamp, tufRoleSnapshot, tufRoleTargets}
+
+// tufEnvelope is a signed TUF metadata file
+type tufEnvelope struct {
+	Signatures []tufSignature  `json:"signatures"`
+	Signed     json.RawMessage `json:"signed"`
+}
+
+type tufSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// tufCommon holds the fields every TUF role shares
+type tufCommon struct {
+	Type    string    `json:"_type"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type tufRoot struct {
+	tufCommon
+	ConsistentSnapshot bool               `json:"consistent_snapshot"`
+	Keys               map[string]tufKey  `json:"keys"`
+	Roles              map[string]tufRole `json:"roles"`
+}
+
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type tufRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// TUFMetaFile describes a metadata file referenced by timestamp or snapshot
+type TUFMetaFile struct {
+	Version int64             `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+type tufTimestamp struct {
+	tufCommon
+	Meta map[string]TUFMetaFile `json:"meta"`
+}
+
+type tufSnapshot struct {
+	tufCommon
+	Meta map[string]TUFMetaFile `json:"meta"`
+}
+
+// TUFTarget describes a trusted target file
+type TUFTarget struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+type tufTargets struct {
+	tufCommon
+	Targets map[string]TUFTarget `json:"targets"`
+}
+
+// TUFRepository holds the trusted TUF metadata for a repository and
+// enforces signatures, thresholds, versions and expiry as it is updated
+type TUFRepository struct {
+	root      *tufRoot
+	rootRaw   []byte
+	timestamp *tufTimestamp
+	snapshot  *tufSnapshot
+	targets   *tufTargets
+	// trusted holds the metadata of TUFTrustedRoles as trusted, by role
+	trusted map[string][]byte
+	now     func() time.Time
+}
+
+// NewTUFRepository creates a repository from a trusted root metadata
+// file. The root must be signed by a threshold of its own root keys.
+// Expiry is not checked here so that an old root shipped with the device
+// can still be rotated forward.
+func NewTUFRepository(trustedRoot []byte) (*TUFRepository, error) {
+	var envelope tufEnvelope
+	if err := json.Unmarshal(trustedRoot, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse root metadata: %w", err)
+	}
+
+	var root tufRoot
+	if err := json.Unmarshal(envelope.Signed, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse root metadata: %w", err)
+	}
+	if root.Type != tufRoleRoot {
+		return nil, fmt.Errorf("unexpected metadata type %q", root.Type)
+	}
+	if err := checkTUFKeyIDs(envelope.Signed); err != nil {
+		return nil, fmt.Errorf("root metadata: %w", err)
+	}
+
+	if err := verifyTUFThreshold(&root, tufRoleRoot, &envelope); err != nil {
+		return nil, fmt.Errorf("root metadata: %w", err)
+	}
+
+	return &TUFRepository{
+		root:    &root,
+		rootRaw: trustedRoot,
+		trusted: map[string][]byte{},
+		now:     time.Now,
+	}, nil
+}
+
+// LoadTrusted restores the metadata of one of TUFTrustedRoles trusted on
+// an earlier run, so that the next update cannot roll it back. It must
+// be signed by the trusted root's keys for the role, but may have
+// expired since.
+func (r *TUFRepository) LoadTrusted(role string, data []byte) error {
+	switch role {
+	case tufRoleTimestamp:
+		var ts tufTimestamp
+		if err := r.decodeRole(role, data, &ts, &ts.tufCommon); err != nil {
+			return err
+		}
+		r.timestamp = &ts
+	case tufRoleSnapshot:
+		var snap tufSnapshot
+		if err := r.decodeRole(role, data, &snap, &snap.tufCommon); err != nil {
+			return err
+		}
+		r.snapshot = &snap
+	case tufRoleTargets:
+		var targets tufTargets
+		if err := r.decodeRole(role, data, &targets, &targets.tufCommon); err != nil {
+			return err
+		}
+		r.targets = &targets
+	default:
+		return fmt.Errorf("unknown TUF role %s", role)
+	}
+	r.trusted[role] = data
+	return nil
+}
+
+// Trusted returns the trusted metadata of one of TUFTrustedRoles so it
+// can be persisted, or nil if there is none
+func (r *TUFRepository) Trusted(role string) []byte {
+	return r.trusted[role]
+}
+
+// RootVersion returns the version of the trusted root
+func (r *TUFRepository) RootVersion() int64 {
+	return r.root.Version
+}
+
+// Root returns the trusted root metadata so it can be persisted
+func (r *TUFRepository) Root() []byte {
+	return r.rootRaw
+}
+
+// ConsistentSnapshot reports whether metadata and targets are published
+// under version or hash prefixed names
+func (r *TUFRepository) ConsistentSnapshot() bool {
+	return r.root.ConsistentSnapshot
+}
+
+// SnapshotVersion returns the snapshot version named by the trusted
+// timestamp
+func (r *TUFRepository) SnapshotVersion() int64 {
+	if r.timestamp == nil {
+		return 0
+	}
+	return r.timestamp.Meta["snapshot.json"].Version
+}
+
+// TargetsVersion returns the targets version named by the trusted snapshot
+func (r *TUFRepository) TargetsVersion() int64 {
+	if r.snapshot == nil {
+		return 0
+	}
+	return r.snapshot.Meta["targets.json"].Version
+}
+
+// UpdateRoot rotates to the next root version. The new root must be
+// signed by a threshold of both the current and its own root keys.
+func (r *TUFRepository) UpdateRoot(data []byte) error {
+	var envelope tufEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("failed to parse root metadata: %w", err)
+	}
+
+	var next tufRoot
+	if err := json.Unmarshal(envelope.Signed, &next); err != nil {
+		return fmt.Errorf("failed to parse root metadata: %w", err)
+	}
+	if next.Type != tufRoleRoot {
+		return fmt.Errorf("unexpected metadata type %q", next.Type)
+	}
+	if err := checkTUFKeyIDs(envelope.Signed); err != nil {
+		return fmt.Errorf("root v%d: %w", next.Version, err)
+	}
+
+	if err := verifyTUFThreshold(r.root, tufRoleRoot, &envelope); err != nil {
+		return fmt.Errorf("root v%d not trusted by current root: %w", next.Version, err)
+	}
+	if err := verifyTUFThreshold(&next, tufRoleRoot, &envelope); err != nil {
+		return fmt.Errorf("root v%d not self-signed: %w", next.Version, err)
+	}
+	if next.Version != r.root.Version+1 {
+		return fmt.Errorf("root version %d does not follow %d", next.Version, r.root.Version)
+	}
+
+	// Metadata signed by rotated keys is no longer trusted: timestamp and
+	// snapshot go together when either's keys changed, as the
+	// specification requires, and targets when its own did
+	if !sameTUFRoleKeys(r.root, &next, tufRoleTimestamp) || !sameTUFRoleKeys(r.root, &next, tufRoleSnapshot) {
+		r.timestamp, r.snapshot = nil, nil
+		delete(r.trusted, tufRoleTimestamp)
+		delete(r.trusted, tufRoleSnapshot)
+	}
+	if !sameTUFRoleKeys(r.root, &next, tufRoleTargets) {
+		r.targets = nil
+		delete(r.trusted, tufRoleTargets)
+	}
+
+	r.root = &next
+	r.rootRaw = data
+	return nil
+}
+
+// sameTUFRoleKeys reports whether two roots give a role the same keys
+// and threshold
+func sameTUFRoleKeys(old, next *tufRoot, role string) bool {
+	a, b := old.Roles[role], next.Roles[role]
+	if a.Threshold != b.Threshold || len(a.KeyIDs) != len(b.KeyIDs) {
+		return false
+	}
+	for _, id := range a.KeyIDs {
+		if !containsString(b.KeyIDs, id) || !bytes.Equal(tufKeyBytes(old.Keys[id]), tufKeyBytes(next.Keys[id])) {
+			return false
+		}
+	}
+	return true
+}
+
+// tufKeyBytes identifies a key by its type, scheme and public value
+func tufKeyBytes(key tufKey) []byte {
+	return []byte(key.KeyType + "\x00" + key.Scheme + "\x00" + key.KeyVal.Public)
+}
+
+// CheckRootFresh fails if the trusted root has expired. It is called once
+// all available root rotations have been applied.
+func (r *TUFRepository) CheckRootFresh() error {
+	return r.checkExpiry(tufRoleRoot, r.root.Expires)
+}
+
+// UpdateTimestamp verifies and trusts new timestamp metadata
+func (r *TUFRepository) UpdateTimestamp(data []byte) error {
+	var ts tufTimestamp
+	if err := r.verifyRole(tufRoleTimestamp, data, &ts, &ts.tufCommon); err != nil {
+		return err
+	}
+
+	if r.timestamp != nil {
+		if ts.Version < r.timestamp.Version {
+			return fmt.Errorf("timestamp version rolled back from %d to %d", r.timestamp.Version, ts.Version)
+		}
+		if ts.Meta["snapshot.json"].Version < r.timestamp.Meta["snapshot.json"].Version {
+			return fmt.Errorf("snapshot version rolled back")
+		}
+	}
+
+	r.timestamp = &ts
+	r.trusted[tufRoleTimestamp] = data
+	return nil
+}
+
+// UpdateSnapshot verifies and trusts new snapshot metadata against the
+// trusted timestamp
+func (r *TUFRepository) UpdateSnapshot(data []byte) error {
+	if r.timestamp == nil {
+		return fmt.Errorf("snapshot updated before timestamp")
+	}
+	if err := checkTUFMeta(data, r.timestamp.Meta["snapshot.json"]); err != nil {
+		return fmt.Errorf("snapshot metadata: %w", err)
+	}
+
+	var snap tufSnapshot
+	if err := r.verifyRole(tufRoleSnapshot, data, &snap, &snap.tufCommon); err != nil {
+		return err
+	}
+	if snap.Version != r.timestamp.Meta["snapshot.json"].Version {
+		return fmt.Errorf("snapshot version %d does not match timestamp", snap.Version)
+	}
+
+	if r.snapshot != nil {
+		if snap.Version < r.snapshot.Version {
+			return fmt.Errorf("snapshot version rolled back from %d to %d", r.snapshot.Version, snap.Version)
+		}
+		for name, old := range r.snapshot.Meta {
+			if cur, ok := snap.Meta[name]; !ok || cur.Version < old.Version {
+				return fmt.Errorf("snapshot rolled back %s", name)
+			}
+		}
+	}
+
+	r.snapshot = &snap
+	r.trusted[tufRoleSnapshot] = data
+	return nil
+}
+
+// UpdateTargets verifies and trusts new targets metadata against the
+// trusted snapshot
+func (r *TUFRepository) UpdateTargets(data []byte) error {
+	if r.snapshot == nil {
+		return fmt.Errorf("targets updated before snapshot")
+	}
+	if err := checkTUFMeta(data, r.snapshot.Meta["targets.json"]); err != nil {
+		return fmt.Errorf("targets metadata: %w", err)
+	}
+
+	var targets tufTargets
+	if err := r.verifyRole(tufRoleTargets, data, &targets, &targets.tufCommon); err != nil {
+		return err
+	}
+	if targets.Version != r.snapshot.Meta["targets.json"].Version {
+		return fmt.Errorf("targets version %d does not match snapshot", targets.Version)
+	}
+	if r.targets != nil && targets.Version < r.targets.Version {
+		return fmt.Errorf("targets version rolled back from %d to %d", r.targets.Version, targets.Version)
+	}
+
+	r.targets = &targets
+	r.trusted[tufRoleTargets] = data
+	return nil
+}
+
+// Target returns the trusted description of a target file
+func (r *TUFRepository) Target(name string) (TUFTarget, error) {
+	if r.targets == nil {
+		return TUFTarget{}, fmt.Errorf("no trusted targets metadata")
+	}
+	target, ok := r.targets.Targets[name]
+	if !ok {
+		return TUFTarget{}, fmt.Errorf("target %s is not listed in trusted metadata", name)
+	}
+	return target, nil
+}
+
+// TargetNames returns the names of the trusted target files in order
+func (r *TUFRepository) TargetNames() []string {
+	if r.targets == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.targets.Targets))
+	for name := range r.targets.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyRole checks a role's signatures, type and expiry and decodes it
+func (r *TUFRepository) verifyRole(role string, data []byte, out interface{}, common *tufCommon) error {
+	if err := r.decodeRole(role, data, out, common); err != nil {
+		return err
+	}
+	return r.checkExpiry(role, common.Expires)
+}
+
+// decodeRole checks a role's signatures and type and decodes it
+func (r *TUFRepository) decodeRole(role string, data []byte, out interface{}, common *tufCommon) error {
+	var envelope tufEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("failed to parse %s metadata: %w", role, err)
+	}
+
+	if err := verifyTUFThreshold(r.root, role, &envelope); err != nil {
+		return fmt.Errorf("%s metadata: %w", role, err)
+	}
+
+	if err := json.Unmarshal(envelope.Signed, out); err != nil {
+		return fmt.Errorf("failed to parse %s metadata: %w", role, err)
+	}
+	if common.Type != role {
+		return fmt.Errorf("unexpected metadata type %q for %s", common.Type, role)
+	}
+	return nil
+}
+
+func (r *TUFRepository) checkExpiry(role string, expires time.Time) error {
+	if !r.now().Before(expires) {
+		return fmt.Errorf("%s metadata expired at %s", role, expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// verifyTUFThreshold checks that enough distinct authorized keys signed
+// the canonical form of the envelope's signed payload
+func verifyTUFThreshold(root *tufRoot, role string, envelope *tufEnvelope) error {
+	roleKeys, ok := root.Roles[role]
+	if !ok {
+		return fmt.Errorf("role %s not defined in root", role)
+	}
+	if roleKeys.Threshold < 1 {
+		return fmt.Errorf("invalid threshold for role %s", role)
+	}
+
+	payload, err := canonicalJSON(envelope.Signed)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize metadata: %w", err)
+	}
+
+	valid := map[string]bool{}
+	for _, sig := range envelope.Signatures {
+		if valid[sig.KeyID] || !containsString(roleKeys.KeyIDs, sig.KeyID) {
+			continue
+		}
+		key, ok := root.Keys[sig.KeyID]
+		if !ok {
+			continue
+		}
+		if verifyTUFSignature(key, payload, sig.Sig) == nil {
+			valid[sig.KeyID] = true
+		}
+	}
+
+	if len(valid) < roleKeys.Threshold {
+		return fmt.Errorf("signature threshold not met: %d of %d", len(valid), roleKeys.Threshold)
+	}
+	return nil
+}
+
+// checkTUFKeyIDs checks that the ID of every key in root metadata is the
+// hex SHA-256 of the key's canonical JSON, as the specification requires,
+// so that one key cannot count twice toward a threshold under two IDs
+func checkTUFKeyIDs(signed json.RawMessage) error {
+	var root struct {
+		Keys map[string]json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(signed, &root); err != nil {
+		return fmt.Errorf("failed to parse keys: %w", err)
+	}
+	for id, key := range root.Keys {
+		canonical, err := canonicalJSON(key)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", id, err)
+		}
+		sum := sha256.Sum256(canonical)
+		if hex.EncodeToString(sum[:]) != id {
+			return fmt.Errorf("key ID %s does not match the hash of its key", id)
+		}
+	}
+	return nil
+}
+
+// verifyTUFSignature verifies a single hex encoded metadata signature
+func verifyTUFSignature(key tufKey, payload []byte, sigHex string) error {
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return err
+	}
+
+	switch key.KeyType {
+	case "ed25519":
+		public, err := hex.DecodeString(key.KeyVal.Public)
+		if err != nil || len(public) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 key")
+		}
+		if !ed25519.Verify(public, payload, sig) {
+			return fmt.Errorf("invalid signature")
+		}
+	case "ecdsa", "ecdsa-sha2-nistp256":
+		block, _ := pem.Decode([]byte(key.KeyVal.Public))
+		if block == nil {
+			return fmt.Errorf("invalid ecdsa key")
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		public, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid ecdsa key")
+		}
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(public, digest[:], sig) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %s", key.KeyType)
+	}
+
+	return nil
+}
+
+// checkTUFMeta checks a metadata file against the length and hashes its
+// parent role recorded for it
+func checkTUFMeta(data []byte, meta TUFMetaFile) error {
+	if meta.Length > 0 && int64(len(data)) != meta.Length {
+		return fmt.Errorf("length %d does not match expected %d", len(data), meta.Length)
+	}
+	if expected, ok := meta.Hashes["sha256"]; ok {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != expected {
+			return fmt.Errorf("sha256 does not match")
+		}
+	}
+	return nil
+}
+
+// canonicalJSON re-encodes JSON in the OLPC canonical form TUF signs:
+// sorted keys, no insignificant whitespace and minimal string escaping
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return fmt.Errorf("non-integer number %s in canonical JSON", v)
+		}
+		buf.WriteString(v.String())
+	case string:
+		writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for idx, item := range v {
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for idx, key := range keys {
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported canonical JSON value %T", value)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteByte('"')
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
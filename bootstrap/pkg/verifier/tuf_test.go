@@ -0,0 +1,417 @@
+package verifier
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tufTestKey is an Ed25519 key of a test TUF repository
+type tufTestKey struct {
+	id      string
+	key     tufKey
+	private ed25519.PrivateKey
+}
+
+func newTUFTestKey(t *testing.T) tufTestKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := tufKey{KeyType: "ed25519", Scheme: "ed25519"}
+	key.KeyVal.Public = hex.EncodeToString(public)
+
+	raw, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := canonicalJSON(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(canonical)
+	return tufTestKey{id: hex.EncodeToString(sum[:]), key: key, private: private}
+}
+
+// signTUF returns signed metadata for a role signed by keys
+func signTUF(t *testing.T, signed interface{}, keys ...tufTestKey) []byte {
+	t.Helper()
+	raw, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := canonicalJSON(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := tufEnvelope{Signed: raw, Signatures: []tufSignature{}}
+	for _, key := range keys {
+		envelope.Signatures = append(envelope.Signatures, tufSignature{
+			KeyID: key.id,
+			Sig:   hex.EncodeToString(ed25519.Sign(key.private, canonical)),
+		})
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// tufFixture holds the keys of a test repository: two root keys of which
+// both must sign, and one key for each other role
+type tufFixture struct {
+	t         *testing.T
+	root      []tufTestKey
+	timestamp tufTestKey
+	snapshot  tufTestKey
+	targets   tufTestKey
+}
+
+func newTUFFixture(t *testing.T) *tufFixture {
+	t.Helper()
+	return &tufFixture{
+		t:         t,
+		root:      []tufTestKey{newTUFTestKey(t), newTUFTestKey(t)},
+		timestamp: newTUFTestKey(t),
+		snapshot:  newTUFTestKey(t),
+		targets:   newTUFTestKey(t),
+	}
+}
+
+// tufExpires is when test metadata expires unless a test says otherwise
+var tufExpires = time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+// rootMeta returns the root metadata of the fixture's keys
+func (f *tufFixture) rootMeta(version int64) tufRoot {
+	root := tufRoot{
+		tufCommon: tufCommon{Type: tufRoleRoot, Version: version, Expires: tufExpires},
+		Keys:      map[string]tufKey{},
+		Roles:     map[string]tufRole{},
+	}
+	role := tufRole{Threshold: len(f.root)}
+	for _, key := range f.root {
+		root.Keys[key.id] = key.key
+		role.KeyIDs = append(role.KeyIDs, key.id)
+	}
+	root.Roles[tufRoleRoot] = role
+	for name, key := range map[string]tufTestKey{
+		tufRoleTimestamp: f.timestamp,
+		tufRoleSnapshot:  f.snapshot,
+		tufRoleTargets:   f.targets,
+	} {
+		root.Keys[key.id] = key.key
+		root.Roles[name] = tufRole{KeyIDs: []string{key.id}, Threshold: 1}
+	}
+	return root
+}
+
+// repository returns a repository trusting the fixture's version 1 root
+func (f *tufFixture) repository(t *testing.T) *TUFRepository {
+	t.Helper()
+	repo, err := NewTUFRepository(signTUF(t, f.rootMeta(1), f.root...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// metaFile describes metadata the way its parent role records it
+func metaFile(version int64, data []byte) TUFMetaFile {
+	sum := sha256.Sum256(data)
+	return TUFMetaFile{Version: version, Length: int64(len(data)), Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])}}
+}
+
+func (f *tufFixture) targetsMeta(version int64, expires time.Time) []byte {
+	return signTUF(f.t, tufTargets{
+		tufCommon: tufCommon{Type: tufRoleTargets, Version: version, Expires: expires},
+		Targets:   map[string]TUFTarget{"agent": {Length: 5, Hashes: map[string]string{"sha256": "00"}}},
+	}, f.targets)
+}
+
+func (f *tufFixture) snapshotMeta(version int64, targets TUFMetaFile) []byte {
+	return signTUF(f.t, tufSnapshot{
+		tufCommon: tufCommon{Type: tufRoleSnapshot, Version: version, Expires: tufExpires},
+		Meta:      map[string]TUFMetaFile{"targets.json": targets},
+	}, f.snapshot)
+}
+
+func (f *tufFixture) timestampMeta(version int64, snapshot TUFMetaFile, expires time.Time) []byte {
+	return signTUF(f.t, tufTimestamp{
+		tufCommon: tufCommon{Type: tufRoleTimestamp, Version: version, Expires: expires},
+		Meta:      map[string]TUFMetaFile{"snapshot.json": snapshot},
+	}, f.timestamp)
+}
+
+// update brings a repository to the given version of every role
+func (f *tufFixture) update(t *testing.T, repo *TUFRepository, version int64) {
+	t.Helper()
+	targets := f.targetsMeta(version, tufExpires)
+	snapshot := f.snapshotMeta(version, metaFile(version, targets))
+	if err := repo.UpdateTimestamp(f.timestampMeta(version, metaFile(version, snapshot), tufExpires)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateTargets(targets); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkErr fails unless err contains want, or is nil when want is empty
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+		t.Fatalf("error = %v, want an error containing %q", err, want)
+	}
+}
+
+func TestNewTUFRepositoryThreshold(t *testing.T) {
+	f := newTUFFixture(t)
+	stranger := newTUFTestKey(t)
+
+	badID := f.rootMeta(1)
+	key := badID.Keys[f.timestamp.id]
+	delete(badID.Keys, f.timestamp.id)
+	badID.Keys[f.targets.id] = key
+
+	wrongType := f.rootMeta(1)
+	wrongType.Type = tufRoleTargets
+
+	tests := []struct {
+		name    string
+		root    []byte
+		wantErr string
+	}{
+		{"signed by all root keys", signTUF(t, f.rootMeta(1), f.root...), ""},
+		{"one root key", signTUF(t, f.rootMeta(1), f.root[0]), "threshold not met: 1 of 2"},
+		{"one root key twice", signTUF(t, f.rootMeta(1), f.root[0], f.root[0]), "threshold not met: 1 of 2"},
+		{"a key of another role", signTUF(t, f.rootMeta(1), f.root[0], f.timestamp), "threshold not met: 1 of 2"},
+		{"an unknown key", signTUF(t, f.rootMeta(1), f.root[0], stranger), "threshold not met: 1 of 2"},
+		{"unsigned", signTUF(t, f.rootMeta(1)), "threshold not met: 0 of 2"},
+		{"key ID not its hash", signTUF(t, badID, f.root...), "does not match the hash of its key"},
+		{"not a root", signTUF(t, wrongType, f.root...), "unexpected metadata type"},
+		{"not JSON", []byte("root"), "failed to parse root metadata"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTUFRepository(test.root)
+			checkErr(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestTUFUpdateRoot(t *testing.T) {
+	f := newTUFFixture(t)
+	next := &tufFixture{
+		t:         t,
+		root:      []tufTestKey{f.root[0], newTUFTestKey(t)},
+		timestamp: f.timestamp,
+		snapshot:  f.snapshot,
+		targets:   f.targets,
+	}
+	both := []tufTestKey{f.root[0], f.root[1], next.root[1]}
+
+	tests := []struct {
+		name    string
+		root    []byte
+		wantErr string
+	}{
+		{"signed by old and new keys", signTUF(t, next.rootMeta(2), both...), ""},
+		{"only new keys", signTUF(t, next.rootMeta(2), next.root...), "not trusted by current root"},
+		{"only old keys", signTUF(t, next.rootMeta(2), f.root...), "not self-signed"},
+		{"skipped version", signTUF(t, next.rootMeta(3), both...), "does not follow 1"},
+		{"same version", signTUF(t, next.rootMeta(1), both...), "does not follow 1"},
+		{"older version", signTUF(t, next.rootMeta(0), both...), "does not follow 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := f.repository(t)
+			err := repo.UpdateRoot(test.root)
+			checkErr(t, err, test.wantErr)
+
+			want := int64(1)
+			if test.wantErr == "" {
+				want = 2
+			}
+			if repo.RootVersion() != want {
+				t.Errorf("RootVersion = %d, want %d", repo.RootVersion(), want)
+			}
+		})
+	}
+}
+
+func TestTUFUpdateRootRotatesRoleKeys(t *testing.T) {
+	f := newTUFFixture(t)
+	repo := f.repository(t)
+	f.update(t, repo, 1)
+
+	next := *f
+	next.targets = newTUFTestKey(t)
+	if err := repo.UpdateRoot(signTUF(t, next.rootMeta(2), f.root...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Targets signed by the replaced key are no longer trusted, the
+	// timestamp and snapshot whose keys stayed are
+	if _, err := repo.Target("agent"); err == nil {
+		t.Error("targets signed by a rotated key are still trusted")
+	}
+	if repo.Trusted(tufRoleTargets) != nil {
+		t.Error("targets signed by a rotated key are still persisted")
+	}
+	if repo.SnapshotVersion() != 1 || repo.TargetsVersion() != 1 {
+		t.Errorf("timestamp and snapshot dropped although their keys stayed")
+	}
+}
+
+func TestTUFRollback(t *testing.T) {
+	f := newTUFFixture(t)
+	expired := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	targets := f.targetsMeta(2, tufExpires)
+	snapshot := f.snapshotMeta(2, metaFile(2, targets))
+
+	tests := []struct {
+		name string
+		// update applies metadata to a repository at version 2 of every
+		// role
+		update  func(repo *TUFRepository) error
+		wantErr string
+	}{
+		{"same versions", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(f.timestampMeta(2, metaFile(2, snapshot), tufExpires))
+		}, ""},
+		{"newer timestamp", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(f.timestampMeta(3, metaFile(2, snapshot), tufExpires))
+		}, ""},
+		{"older timestamp", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(f.timestampMeta(1, metaFile(2, snapshot), tufExpires))
+		}, "timestamp version rolled back from 2 to 1"},
+		{"timestamp naming an older snapshot", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(f.timestampMeta(3, metaFile(1, snapshot), tufExpires))
+		}, "snapshot version rolled back"},
+		{"expired timestamp", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(f.timestampMeta(3, metaFile(2, snapshot), expired))
+		}, "timestamp metadata expired"},
+		{"timestamp signed by the snapshot key", func(repo *TUFRepository) error {
+			return repo.UpdateTimestamp(signTUF(t, tufTimestamp{
+				tufCommon: tufCommon{Type: tufRoleTimestamp, Version: 3, Expires: tufExpires},
+			}, f.snapshot))
+		}, "threshold not met"},
+		{"older snapshot", func(repo *TUFRepository) error {
+			older := f.snapshotMeta(1, metaFile(2, targets))
+			if err := repo.UpdateTimestamp(f.timestampMeta(3, metaFile(2, older), tufExpires)); err != nil {
+				return err
+			}
+			return repo.UpdateSnapshot(older)
+		}, "does not match timestamp"},
+		{"snapshot naming older targets", func(repo *TUFRepository) error {
+			older := f.snapshotMeta(3, metaFile(1, targets))
+			if err := repo.UpdateTimestamp(f.timestampMeta(3, metaFile(3, older), tufExpires)); err != nil {
+				return err
+			}
+			return repo.UpdateSnapshot(older)
+		}, "snapshot rolled back targets.json"},
+		{"snapshot not matching the timestamp's hash", func(repo *TUFRepository) error {
+			other := f.snapshotMeta(3, metaFile(3, targets))
+			meta := metaFile(3, other)
+			meta.Hashes["sha256"] = strings.Repeat("0", 64)
+			if err := repo.UpdateTimestamp(f.timestampMeta(3, meta, tufExpires)); err != nil {
+				return err
+			}
+			return repo.UpdateSnapshot(other)
+		}, "sha256 does not match"},
+		{"older targets", func(repo *TUFRepository) error {
+			older := f.targetsMeta(1, tufExpires)
+			newer := f.snapshotMeta(3, metaFile(3, older))
+			if err := repo.UpdateTimestamp(f.timestampMeta(3, metaFile(3, newer), tufExpires)); err != nil {
+				return err
+			}
+			if err := repo.UpdateSnapshot(newer); err != nil {
+				return err
+			}
+			return repo.UpdateTargets(older)
+		}, "targets version 1 does not match snapshot"},
+		{"expired targets", func(repo *TUFRepository) error {
+			stale := f.targetsMeta(3, expired)
+			newer := f.snapshotMeta(3, metaFile(3, stale))
+			if err := repo.UpdateTimestamp(f.timestampMeta(3, metaFile(3, newer), tufExpires)); err != nil {
+				return err
+			}
+			if err := repo.UpdateSnapshot(newer); err != nil {
+				return err
+			}
+			return repo.UpdateTargets(stale)
+		}, "targets metadata expired"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := f.repository(t)
+			f.update(t, repo, 2)
+			checkErr(t, test.update(repo), test.wantErr)
+		})
+	}
+}
+
+func TestTUFRollbackAcrossRuns(t *testing.T) {
+	f := newTUFFixture(t)
+	repo := f.repository(t)
+	f.update(t, repo, 2)
+
+	// The next run starts from the root and the persisted metadata
+	next := f.repository(t)
+	for _, role := range TUFTrustedRoles {
+		if err := next.LoadTrusted(role, repo.Trusted(role)); err != nil {
+			t.Fatalf("LoadTrusted(%s): %v", role, err)
+		}
+	}
+	targets := f.targetsMeta(1, tufExpires)
+	snapshot := f.snapshotMeta(1, metaFile(1, targets))
+	err := next.UpdateTimestamp(f.timestampMeta(1, metaFile(1, snapshot), tufExpires))
+	checkErr(t, err, "timestamp version rolled back from 2 to 1")
+
+	// Persisted metadata must be signed by the role's keys
+	forged := signTUF(t, tufTargets{tufCommon: tufCommon{Type: tufRoleTargets, Version: 9, Expires: tufExpires}}, f.timestamp)
+	checkErr(t, next.LoadTrusted(tufRoleTargets, forged), "threshold not met")
+}
+
+func TestTUFUpdateOrder(t *testing.T) {
+	f := newTUFFixture(t)
+	repo := f.repository(t)
+	targets := f.targetsMeta(1, tufExpires)
+
+	checkErr(t, repo.UpdateSnapshot(f.snapshotMeta(1, metaFile(1, targets))), "snapshot updated before timestamp")
+	checkErr(t, repo.UpdateTargets(targets), "targets updated before snapshot")
+	if _, err := repo.Target("agent"); err == nil {
+		t.Error("Target found without trusted targets metadata")
+	}
+}
+
+func TestTUFTarget(t *testing.T) {
+	f := newTUFFixture(t)
+	repo := f.repository(t)
+	f.update(t, repo, 1)
+
+	target, err := repo.Target("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Length != 5 || target.Hashes["sha256"] != "00" {
+		t.Errorf("Target = %+v", target)
+	}
+	checkErr(t, func() error { _, err := repo.Target("executor"); return err }(), "not listed in trusted metadata")
+}
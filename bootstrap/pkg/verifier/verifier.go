@@ -6,14 +6,29 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // Verifier handles signature verification
 type Verifier struct {
-	publicKey string
-	log       Logger
+	publicKey     string
+	signatureType string
+	cosign        CosignOptions
+	pgp           PGPOptions
+	log           Logger
+
+	// keys are trusted besides publicKey, and replace it once a key
+	// rotation has been applied
+	keys            []TrustedKey
+	rotationVersion int64
+
+	// checksumAlgorithm overrides the algorithm inferred for checksums
+	checksumAlgorithm string
 }
 
 // Logger interface for logging
@@ -27,8 +42,9 @@ type Logger interface {
 // New creates a new verifier
 func New(publicKey string, log Logger) *Verifier {
 	return &Verifier{
-		publicKey: publicKey,
-		log:       log,
+		publicKey:     publicKey,
+		signatureType: SignatureEd25519,
+		log:           log,
 	}
 }
 
@@ -43,6 +59,22 @@ func (v *Verifier) VerifyFile(filePath, signature string) error {
 	}
 	defer file.Close()
 	
+	// Minisign and OpenPGP signatures cover their own digest of the file
+	signed, verifySigned, err := v.signedHash(signature)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	if signed != nil {
+		if _, err := io.Copy(signed, file); err != nil {
+			return fmt.Errorf("failed to calculate hash: %w", err)
+		}
+		if err := verifySigned(signed); err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		v.log.Info("File signature verified successfully")
+		return nil
+	}
+	
 	// Calculate file hash
 	hash := sha256.New()
 	if _, err := io.Copy(hash, file); err != nil {
@@ -62,6 +94,12 @@ func (v *Verifier) VerifyFile(filePath, signature string) error {
 
 // VerifyChecksum verifies a file's checksum
 func (v *Verifier) VerifyChecksum(filePath, expectedChecksum string) error {
+	return v.VerifyChecksumAlgorithm(filePath, v.checksumAlgorithm, expectedChecksum)
+}
+
+// VerifyChecksumAlgorithm verifies a file's checksum computed with the
+// given algorithm, or with the one the digest length implies if empty
+func (v *Verifier) VerifyChecksumAlgorithm(filePath, algorithm, expectedChecksum string) error {
 	v.log.Infof("Verifying checksum: %s", filePath)
 	
 	// Read file
@@ -71,9 +109,16 @@ func (v *Verifier) VerifyChecksum(filePath, expectedChecksum string) error {
 	}
 	defer file.Close()
 	
-	// Calculate SHA256 hash
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	// Calculate the hash
+	expectedChecksum = strings.ToLower(strings.TrimSpace(expectedChecksum))
+	if algorithm == "" {
+		algorithm = checksumAlgorithm(expectedChecksum)
+	}
+	hash, err := newChecksumHash(algorithm)
+	if err != nil {
+		return fmt.Errorf("invalid checksum %q: %w", expectedChecksum, err)
+	}
+	if err := hashFile(file, hash); err != nil {
 		return fmt.Errorf("failed to calculate hash: %w", err)
 	}
 	
@@ -88,12 +133,57 @@ func (v *Verifier) VerifyChecksum(filePath, expectedChecksum string) error {
 	return nil
 }
 
-// verifySignature verifies an Ed25519 signature
+// verifySignature verifies a signature over a SHA256 digest using the
+// configured signature type
 func (v *Verifier) verifySignature(data []byte, signature string) error {
-	// Decode public key
-	publicKeyBytes, err := base64.StdEncoding.DecodeString(v.publicKey)
+	if v.signatureType == SignatureCosign {
+		// Cosign signatures are passed around as base64 encoded bundles
+		bundle, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+		if err != nil {
+			return fmt.Errorf("failed to decode cosign bundle: %w", err)
+		}
+		return v.verifyCosign(data, bundle)
+	}
+
+	return v.verifyEd25519(data, signature)
+}
+
+// signedHash returns the hash a signature covers and the function that
+// checks it, for the formats detected from the signature itself. The hash
+// is nil for signatures over the SHA256 digest.
+func (v *Verifier) signedHash(signature string) (hash.Hash, func(hash.Hash) error, error) {
+	if v.signatureType == SignatureCosign {
+		return nil, nil, nil
+	}
+
+	switch {
+	case isMinisign(signature):
+		return newPrehash(), func(h hash.Hash) error {
+			return v.verifyMinisign(h.Sum(nil), signature)
+		}, nil
+	case isPGP(signature):
+		sig, err := parsePGPSignature(signature)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := sig.PrepareVerify()
+		if err != nil {
+			return nil, nil, err
+		}
+		return h, func(h hash.Hash) error {
+			return v.verifyPGP(h, sig)
+		}, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// verifyEd25519 verifies an Ed25519 signature
+func (v *Verifier) verifyEd25519(data []byte, signature string) error {
+	// Collect the keys valid now
+	keys, err := v.trustedKeys(time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to decode public key: %w", err)
+		return err
 	}
 	
 	// Decode signature
@@ -102,12 +192,14 @@ func (v *Verifier) verifySignature(data []byte, signature string) error {
 		return fmt.Errorf("failed to decode signature: %w", err)
 	}
 	
-	// Verify signature
-	if !ed25519.Verify(publicKeyBytes, data, signatureBytes) {
-		return fmt.Errorf("signature verification failed")
+	// Any trusted key may have made the signature
+	for _, key := range keys {
+		if ed25519.Verify(key.key, data, signatureBytes) {
+			return nil
+		}
 	}
 	
-	return nil
+	return fmt.Errorf("signature verification failed")
 }
 
 // VerifyRelease verifies a release's signature and checksums
@@ -116,6 +208,16 @@ func (v *Verifier) VerifyRelease(releasePath string) error {
 	
 	// Check for signature file
 	signatureFile := releasePath + ".sig"
+	if v.signatureType == SignatureCosign {
+		signatureFile = releasePath + ".bundle"
+	} else {
+		for _, ext := range []string{".minisig", ".asc"} {
+			if _, err := os.Stat(releasePath + ext); err == nil {
+				signatureFile = releasePath + ext
+				break
+			}
+		}
+	}
 	if _, err := os.Stat(signatureFile); err != nil {
 		return fmt.Errorf("signature file not found: %w", err)
 	}
@@ -125,6 +227,9 @@ func (v *Verifier) VerifyRelease(releasePath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read signature: %w", err)
 	}
+	if v.signatureType == SignatureCosign {
+		signature = []byte(base64.StdEncoding.EncodeToString(signature))
+	}
 	
 	// Verify file signature
 	if err := v.VerifyFile(releasePath, string(signature)); err != nil {
@@ -132,22 +237,24 @@ func (v *Verifier) VerifyRelease(releasePath string) error {
 	}
 	
 	// Check for checksum file
-	checksumFile := releasePath + ".sha256"
-	if _, err := os.Stat(checksumFile); err == nil {
+	if checksumFile, algorithm := findChecksumFile(releasePath); checksumFile != "" {
 		// Read checksum
 		checksumData, err := os.ReadFile(checksumFile)
 		if err != nil {
 			return fmt.Errorf("failed to read checksum: %w", err)
 		}
 		
-		// Parse checksum (format: "hash filename")
-		checksum := string(checksumData)
-		if len(checksum) > 64 {
-			checksum = checksum[:64]
+		// Parse checksum (format: "hash filename", one per line)
+		checksum, err := findChecksum(string(checksumData), releasePath)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", filepath.Base(checksumFile), err)
 		}
 		
-		// Verify checksum
-		if err := v.VerifyChecksum(releasePath, checksum); err != nil {
+		// Verify checksum, with the algorithm the file name implies
+		if v.checksumAlgorithm != "" {
+			algorithm = v.checksumAlgorithm
+		}
+		if err := v.VerifyChecksumAlgorithm(releasePath, algorithm, checksum); err != nil {
 			return fmt.Errorf("failed to verify checksum: %w", err)
 		}
 	}
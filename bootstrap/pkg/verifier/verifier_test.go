@@ -0,0 +1,40 @@
+package verifier
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+// testLogger discards what the verifier logs
+type testLogger struct{}
+
+func (testLogger) Info(args ...interface{})                  {}
+func (testLogger) Infof(format string, args ...interface{})  {}
+func (testLogger) Error(args ...interface{})                 {}
+func (testLogger) Errorf(format string, args ...interface{}) {}
+
+// testKey is an Ed25519 key pair for tests
+type testKey struct {
+	private ed25519.PrivateKey
+	// public is the public key in the base64 form the verifier takes
+	public string
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{private: private, public: base64.StdEncoding.EncodeToString(public)}
+}
+
+// sign returns the base64 Ed25519 signature of the SHA256 digest of data,
+// as releases are signed
+func (k testKey) sign(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(k.private, sum[:]))
+}